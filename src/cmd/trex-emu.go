@@ -7,10 +7,14 @@ package main
 import (
 	"emu/core"
 	"emu/version"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/akamensky/argparse"
@@ -18,16 +22,21 @@ import (
 	"emu/plugins/appsim"
 	"emu/plugins/arp"
 	"emu/plugins/cdp"
+	"emu/plugins/dhcpsrv"
 	dhcp "emu/plugins/dhcpv4"
 	"emu/plugins/dhcpv6"
+	"emu/plugins/dhcpv6srv"
 	"emu/plugins/dns"
 	"emu/plugins/dot1x"
+	"emu/plugins/http"
 	"emu/plugins/icmp"
 	"emu/plugins/igmp"
 	"emu/plugins/ipfix"
 	"emu/plugins/ipv6"
 	"emu/plugins/lldp"
 	"emu/plugins/mdns"
+	"emu/plugins/quic"
+	"emu/plugins/radius"
 	"emu/plugins/tdl"
 	"emu/plugins/transport"
 	"emu/plugins/transport_example"
@@ -42,15 +51,20 @@ func RegisterPlugins(tctx *core.CThreadCtx) {
 	arp.Register(tctx)
 	cdp.Register(tctx)
 	dhcp.Register(tctx)
+	dhcpsrv.Register(tctx)
 	dhcpv6.Register(tctx)
+	dhcpv6srv.Register(tctx)
 	dns.Register(tctx)
 	dot1x.Register(tctx)
+	http.Register(tctx)
 	icmp.Register(tctx)
 	igmp.Register(tctx)
 	ipfix.Register(tctx)
 	ipv6.Register(tctx)
 	lldp.Register(tctx)
 	mdns.Register(tctx)
+	quic.Register(tctx)
+	radius.Register(tctx)
 	tdl.Register(tctx)
 	transport.Register(tctx)
 	transport_example.Register(tctx)
@@ -70,6 +84,10 @@ type MainArgs struct {
 	verbose     *bool   // verbose mode, will print details
 	version     *bool   // print version of EMU and exit
 	emuTCPoZMQ  *bool   // use TCP over ZMQ instead of the classic IPC to connect with TRex.
+	threads     *int    // number of thread contexts, the namespaces are sharded between them.
+	rawIfs      *string // bind to Linux interfaces with AF_PACKET instead of ZMQ, name[:vport],...
+	metricsPort *int    // serve the counters in OpenMetrics format, 0 to disable
+	pcapAppsim  *string // convert the first flow of a pcap to an appsim program and exit
 }
 
 func printVersion() {
@@ -110,6 +128,10 @@ func parseMainArgs() *MainArgs {
 	args.verbose = parser.Flag("v", "verbose", &argparse.Options{Default: false, Help: "Run server in verbose mode"})
 	args.version = parser.Flag("V", "version", &argparse.Options{Default: false, Help: "Show TRex-Emu version"})
 	args.emuTCPoZMQ = parser.Flag("", "emu-zmq-tcp", &argparse.Options{Default: false, Help: "Run TCP over ZMQ. Default is IPC"})
+	args.rawIfs = parser.String("", "raw-ifs", &argparse.Options{Default: "", Help: "Bind to Linux interfaces instead of ZMQ, comma separated list of name[:vport], default vport is the index in the list"})
+	args.threads = parser.Int("t", "threads", &argparse.Options{Default: 1, Help: "Number of threads, the namespaces are sharded between the threads"})
+	args.metricsPort = parser.Int("", "metrics-port", &argparse.Options{Default: 0, Help: "Serve the counters in OpenMetrics format on http://:<port>/metrics. Default is disabled"})
+	args.pcapAppsim = parser.String("", "pcap-to-appsim", &argparse.Options{Default: "", Help: "Print the appsim program of the first TCP/UDP flow of the pcap and exit"})
 
 	err := parser.Parse(os.Args)
 	if err != nil {
@@ -128,8 +150,19 @@ func RunCoreZmq(args *MainArgs) {
 		os.Exit(0)
 	}
 
+	if *args.pcapAppsim != "" {
+		printPcapAppsim(*args.pcapAppsim)
+		os.Exit(0)
+	}
+
 	port := uint16(*args.port)
-	if *args.emuTCPoZMQ {
+	rawIfs, err := parseRawIfs(*args.rawIfs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(rawIfs) > 0 {
+		fmt.Printf("Run server on [RPC:%d, RX/TX: %s]\n", port, *args.rawIfs)
+	} else if *args.emuTCPoZMQ {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: TCP:%d, TX: TCP:%d]\n", port, *args.vethPort, *args.vethPort+1)
 	} else {
 		fmt.Printf("Run ZMQ server on [RPC:%d, RX: IPC, TX:IPC]\n", port)
@@ -137,6 +170,11 @@ func RunCoreZmq(args *MainArgs) {
 
 	rand.Seed(time.Now().UnixNano())
 
+	if *args.threads > 1 {
+		runThreadMgr(args, port, rawIfs)
+		return
+	}
+
 	var simrx core.VethIFSim
 	if *args.dummyVeth {
 		var simVeth core.VethSink
@@ -145,17 +183,24 @@ func RunCoreZmq(args *MainArgs) {
 
 	tctx := core.NewThreadCtx(0, port, *args.dummyVeth, &simrx)
 
-	if !*args.dummyVeth {
+	if len(rawIfs) > 0 && !*args.dummyVeth {
+		var rawVeth core.VethIFRaw
+		if err := rawVeth.Create(tctx, rawIfs); err != nil {
+			log.Fatal(err)
+		}
+		rawVeth.StartRxThread()
+		tctx.SetZmqVeth(&rawVeth)
+	} else if !*args.dummyVeth {
 		zmqVeth.Create(tctx, uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
 		zmqVeth.StartRxThread()
 		tctx.SetZmqVeth(&zmqVeth)
 	}
 
 	RegisterPlugins(tctx)
+	startMetricsServer(args, []*core.CThreadCtx{tctx})
 
 	tctx.SetRpcParams(*args.verbose, *args.capture)
 	var monitorFile *os.File
-	var err error
 	if *args.monitorFile == "stdout" {
 		monitorFile = os.Stdout
 	} else {
@@ -176,6 +221,118 @@ func RunCoreZmq(args *MainArgs) {
 	}
 }
 
+// printPcapAppsim print the appsim program of the pcap
+func printPcapAppsim(file string) {
+	res, err := appsim.PcapFileToAppsim(&appsim.AppsimPcapParams{File: file})
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := json.MarshalIndent(res, "", "    ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+// parseRawIfs parse a comma separated list of name[:vport], the default vport is the index in the list
+func parseRawIfs(s string) ([]core.VethRawIfCfg, error) {
+	var ifs []core.VethRawIfCfg
+	if s == "" {
+		return ifs, nil
+	}
+	for i, v := range strings.Split(s, ",") {
+		cfg := core.VethRawIfCfg{Name: v, Vport: uint16(i)}
+		if n := strings.LastIndex(v, ":"); n >= 0 {
+			vport, err := strconv.ParseUint(v[n+1:], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vport in raw interface %s", v)
+			}
+			cfg.Name = v[:n]
+			cfg.Vport = uint16(vport)
+		}
+		ifs = append(ifs, cfg)
+	}
+	return ifs, nil
+}
+
+// startMetricsServer serve the counters of the threads in case the metrics port is provided
+func startMetricsServer(args *MainArgs, threads []*core.CThreadCtx) {
+	if *args.metricsPort <= 0 {
+		return
+	}
+	addr := fmt.Sprintf(":%d", *args.metricsPort)
+	if err := core.StartMetricsServer(addr, threads); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Serve metrics on [http://%s/metrics]\n", addr)
+}
+
+// captureJsonName return the capture file name of a thread, thread 0 keeps the original name
+func captureJsonName(name string, id uint32) string {
+	if id == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), id, ext)
+}
+
+// runThreadMgr run the server with a few threads, each namespace is owned by one thread
+func runThreadMgr(args *MainArgs, port uint16, rawIfs []core.VethRawIfCfg) {
+
+	var simrx core.VethIFSim
+	if *args.dummyVeth {
+		var simVeth core.VethSink
+		simrx = &simVeth
+	}
+
+	fmt.Printf("Run %d threads\n", *args.threads)
+	mgr := core.NewThreadMgr(uint32(*args.threads), port, *args.dummyVeth, &simrx)
+	threads := mgr.GetThreads()
+
+	if len(rawIfs) > 0 && !*args.dummyVeth {
+		var rawVeth core.VethIFRaw
+		if err := rawVeth.Create(threads[0], rawIfs); err != nil {
+			log.Fatal(err)
+		}
+		mgr.SetVeth(&rawVeth)
+	} else if !*args.dummyVeth {
+		var zmqVeth core.VethIFZmq
+		zmqVeth.Create(threads[0], uint16(*args.vethPort), *args.zmqServer, *args.emuTCPoZMQ, false)
+		mgr.SetVeth(&zmqVeth)
+	}
+
+	for _, tctx := range threads {
+		RegisterPlugins(tctx)
+	}
+	startMetricsServer(args, threads)
+
+	mgr.SetRpcParams(*args.verbose, *args.capture)
+	var monitorFile *os.File
+	var err error
+	if *args.monitorFile == "stdout" {
+		monitorFile = os.Stdout
+	} else {
+		monitorFile, err = os.Create(*args.monitorFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer monitorFile.Close()
+	}
+	for _, tctx := range threads {
+		tctx.Veth.SetDebug(*args.monitor, monitorFile, *args.capture)
+	}
+	mgr.StartRxThread()
+	defer mgr.Delete()
+
+	mgr.MainLoop()
+
+	if *args.capture {
+		for _, tctx := range threads {
+			tctx.SimRecordExport(captureJsonName(*args.captureJson, tctx.Id))
+		}
+	}
+}
+
 func main() {
 	RunCoreZmq(parseMainArgs())
 }
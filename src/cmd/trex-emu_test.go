@@ -0,0 +1,40 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+package main
+
+import (
+	"emu/core"
+	"reflect"
+	"testing"
+)
+
+func TestParseRawIfs(t *testing.T) {
+	var tests = []struct {
+		in  string
+		exp []core.VethRawIfCfg
+		err bool
+	}{
+		{"", nil, false},
+		{"eth0", []core.VethRawIfCfg{{Name: "eth0", Vport: 0}}, false},
+		{"eth0,eth1", []core.VethRawIfCfg{{Name: "eth0", Vport: 0}, {Name: "eth1", Vport: 1}}, false},
+		{"eth0:5,eth1", []core.VethRawIfCfg{{Name: "eth0", Vport: 5}, {Name: "eth1", Vport: 1}}, false},
+		{"veth:a:7", []core.VethRawIfCfg{{Name: "veth:a", Vport: 7}}, false},
+		{"eth0:255", []core.VethRawIfCfg{{Name: "eth0", Vport: 255}}, false},
+		{"eth0:256", nil, true},
+		{"eth0:x", nil, true},
+		{"eth0:", nil, true},
+	}
+
+	for _, tc := range tests {
+		ifs, err := parseRawIfs(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(ifs, tc.exp) {
+			t.Errorf("%q: got %+v, expected %+v", tc.in, ifs, tc.exp)
+		}
+	}
+}
@@ -83,12 +83,24 @@ type CClient struct {
 	DgIpv6     Ipv6Key    // default gateway if provided would be in highest priority
 	Dhcpv6     Ipv6Key    // the dhcpv6 ipv6, another ipv6 would be the one that was learned from the router
 
+	Dhcpv6Prefix    Ipv6Key // the dhcpv6 delegated prefix (IA_PD)
+	Dhcpv6PrefixLen uint8
+
+	Ipv6SlaacOpaque bool      // RFC 7217 the SLAAC address is Ipv6SlaacStable, there is no EUI-64 address
+	Ipv6SlaacStable Ipv6Key   // RFC 7217 stable-opaque SLAAC address, zero until it is generated
+	Ipv6SlaacTemp   []Ipv6Key // RFC 4941 temporary addresses, the last one is the preferred
+
+	Ipv6Tentative  []Ipv6Key // RFC 4862 DAD was not completed, the addresses can't be used as a source
+	Ipv6Duplicated []Ipv6Key // RFC 4862 DAD found another node with the addresses, they are not used
+
 	Ipv6ForceDGW   bool /* true in case we want to enforce default gateway MAC */
 	Ipv6ForcedgMac MACKey
 
 	ForceDGW       bool /* true in case we want to enforce default gateway MAC */
 	Ipv4ForcedgMac MACKey
 
+	Routes *CRouteTable /* client routes, looked up before the ns routes. nil in case there are none */
+
 	PluginCtx *PluginCtx
 
 	transport interface{} // pointer to transport, allocated only if needed
@@ -114,6 +126,8 @@ type CClientCmd struct {
 	ForceDGW       bool   `json:"ipv4_force_dg"`
 	Ipv4ForcedgMac MACKey `json:"ipv4_force_mac"`
 
+	Routes []CRoute `json:"ipv4_routes" validate:"dive"`
+
 	Plugins *MapJsonPlugs `json:"plugs"`
 }
 
@@ -133,6 +147,14 @@ type CClientInfo struct {
 	DgIpv6    Ipv6Key `json:"dg_ipv6"`
 	DhcpIpv6  Ipv6Key `json:"dhcp_ipv6"`
 
+	DhcpIpv6Prefix    Ipv6Key `json:"dhcp_ipv6_prefix"`
+	DhcpIpv6PrefixLen uint8   `json:"dhcp_ipv6_prefix_len"`
+
+	Ipv6SlaacTemp []Ipv6Key `json:"ipv6_slaac_temp"`
+
+	Ipv6Tentative  []Ipv6Key `json:"ipv6_tentative"`
+	Ipv6Duplicated []Ipv6Key `json:"ipv6_duplicated"`
+
 	Ipv6ForceDGW   bool   `json:"ipv6_force_dg"`
 	Ipv6ForcedgMac MACKey `json:"ipv6_force_mac"`
 	ForceDGW       bool   `json:"ipv4_force_dg"`
@@ -181,6 +203,13 @@ func NewClientCmd(ns *CNSCtx, cmd *CClientCmd) *CClient {
 	c.ForceDGW = cmd.ForceDGW
 	c.Ipv4ForcedgMac = cmd.Ipv4ForcedgMac
 
+	if len(cmd.Routes) > 0 {
+		c.Routes = NewRouteTable()
+		for i := range cmd.Routes {
+			c.Routes.Add(&cmd.Routes[i])
+		}
+	}
+
 	return c
 }
 
@@ -237,11 +266,15 @@ func (o *CClient) OnEvent(a, b interface{}) {
 	}
 }
 
-// fix this
+// GetIpv6Slaac returns the SLAAC address of the advertised prefix, EUI-64 or stable-opaque
 func (o *CClient) GetIpv6Slaac(l6 *Ipv6Key) bool {
 	if o.Ipv6Router == nil {
 		return false
 	}
+	if o.Ipv6SlaacOpaque {
+		*l6 = o.Ipv6SlaacStable
+		return !l6.IsZero()
+	}
 	if o.Ipv6Router.PrefixLen == 64 && !o.Ipv6Router.PrefixIpv6.IsZero() {
 		copy(l6[:], o.Ipv6Router.PrefixIpv6[:])
 		l6[8] = o.Mac[0] ^ 0x2
@@ -411,6 +444,11 @@ func (o *CClient) GetInfo() *CClientInfo {
 	info.Ipv6 = o.Ipv6
 	info.DgIpv6 = o.DgIpv6
 	info.DhcpIpv6 = o.Dhcpv6
+	info.DhcpIpv6Prefix = o.Dhcpv6Prefix
+	info.DhcpIpv6PrefixLen = o.Dhcpv6PrefixLen
+	info.Ipv6SlaacTemp = o.Ipv6SlaacTemp
+	info.Ipv6Tentative = o.Ipv6Tentative
+	info.Ipv6Duplicated = o.Ipv6Duplicated
 
 	info.Ipv6ForceDGW = o.Ipv6ForceDGW
 	info.Ipv6ForcedgMac = o.Ipv6ForcedgMac
@@ -436,6 +474,40 @@ func (o *CClient) ResolveIPv4DGMac() (mac MACKey, ok bool) {
 	return mac, ok
 }
 
+// LookupRouteIPv4 return the route to the destination, the client routes first and then the ns routes
+func (o *CClient) LookupRouteIPv4(dst Ipv4Key) *CRoute {
+	if o.Routes != nil {
+		if r := o.Routes.Lookup(dst); r != nil {
+			return r
+		}
+	}
+	if o.Ns.Routes != nil {
+		return o.Ns.Routes.Lookup(dst)
+	}
+	return nil
+}
+
+/*ResolveIPv4NextHopMac resolve the mac of the next hop to the destination, the hash of the flow chooses
+the next hop of an ECMP route. In case there is no route it is the default gateway */
+func (o *CClient) ResolveIPv4NextHopMac(dst Ipv4Key, hash uint32) (mac MACKey, ok bool) {
+	route := o.LookupRouteIPv4(dst)
+	if route == nil {
+		return o.ResolveIPv4DGMac()
+	}
+	nh := route.NextHop(hash)
+	if !nh.Mac.IsZero() {
+		return nh.Mac, true
+	}
+	via := nh.Via
+	if via.IsZero() {
+		via = dst
+	}
+	if o.Ns.Ipv4Resolver != nil {
+		return o.Ns.Ipv4Resolver.ResolveIPv4(o, via)
+	}
+	return mac, false
+}
+
 func (o *CClient) ResolveIPv6DGMac() (mac MACKey, ok bool) {
 	if o.Ipv6ForceDGW {
 		mac, ok = o.Ipv6ForcedgMac, true
@@ -448,32 +520,36 @@ func (o *CClient) ResolveIPv6DGMac() (mac MACKey, ok bool) {
 }
 
 func (o *CClient) GetSourceIPv6() (Ipv6Key, error) {
-	if !o.Dhcpv6.IsZero() {
+	if !o.Dhcpv6.IsZero() && o.IsIpv6Usable(o.Dhcpv6) {
 		return o.Dhcpv6, nil
 	}
-	if !o.Ipv6.IsZero() {
+	if !o.Ipv6.IsZero() && o.IsIpv6Usable(o.Ipv6) {
 		return o.Ipv6, nil
 	}
+	if ipv6Temp, ok := o.GetIpv6SlaacTemp(); ok {
+		return ipv6Temp, nil
+	}
 	var ipv6Slaac Ipv6Key
-	if o.GetIpv6Slaac(&ipv6Slaac) {
+	if o.GetIpv6Slaac(&ipv6Slaac) && o.IsIpv6Usable(ipv6Slaac) {
 		return ipv6Slaac, nil
 	}
 	var key Ipv6Key
 	return key, fmt.Errorf(" No IPv6 found for this client! client %v ", o.Mac)
 }
 
-func (o *CClient) ResolveSourceIPv6() Ipv6Key {
-	if !o.Dhcpv6.IsZero() {
-		return o.Dhcpv6
-	}
-	if !o.Ipv6.IsZero() {
-		return o.Ipv6
+// IsIpv6Usable returns false in case DAD did not complete for the address or found it duplicated
+func (o *CClient) IsIpv6Usable(ipv6 Ipv6Key) bool {
+	for _, t := range o.Ipv6Tentative {
+		if ipv6 == t {
+			return false
+		}
 	}
-	var ipv6Slaac Ipv6Key
-	if o.GetIpv6Slaac(&ipv6Slaac) {
-		return ipv6Slaac
+	for _, d := range o.Ipv6Duplicated {
+		if ipv6 == d {
+			return false
+		}
 	}
-	panic("No IPv6 found for this client!")
+	return true
 }
 
 func (o *CClient) ResolveDGIPv6() (ipv6 Ipv6Key, ok bool) {
@@ -494,9 +570,24 @@ func (o *CClient) OwnsIPv6(ipv6 Ipv6Key) bool {
 	if (ipv6 == o.Dhcpv6) || (ipv6 == o.Ipv6) || (ipv6 == ipv6Slaac) || (ipv6 == ipv6Local) {
 		return true
 	}
+	for _, t := range o.Ipv6SlaacTemp {
+		if ipv6 == t {
+			return true
+		}
+	}
 	return false
 }
 
+// GetIpv6SlaacTemp returns the preferred temporary address, source address selection prefers it (RFC 6724 rule 7)
+func (o *CClient) GetIpv6SlaacTemp() (Ipv6Key, bool) {
+	for i := len(o.Ipv6SlaacTemp) - 1; i >= 0; i-- {
+		if o.IsIpv6Usable(o.Ipv6SlaacTemp[i]) {
+			return o.Ipv6SlaacTemp[i], true
+		}
+	}
+	return Ipv6Key{}, false
+}
+
 func (o *CClient) ResolveDGv6() (ipv6 Ipv6Key, mac MACKey, ok bool) {
 	if !o.DgIpv6.IsZero() {
 		// The Ipv6 Default Gateway is assigned, the Mac is either forced or resolved
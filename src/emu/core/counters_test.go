@@ -8,6 +8,7 @@ package core
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestCnt1(t *testing.T) {
@@ -45,3 +46,31 @@ func TestCnt1(t *testing.T) {
 	//fmt.Printf(string(db.MarshalValues()))
 	//fmt.Println()
 }
+
+func TestLatHist(t *testing.T) {
+	var h LatHist
+	for _, ms := range []int{0, 1, 2, 7, 5000, 5001, 9000} {
+		h.Add(time.Duration(ms) * time.Millisecond)
+	}
+	exp := map[int]uint64{0: 2, 1: 1, 3: 1, 11: 1, 12: 2}
+	for i := range h.bucket {
+		if h.bucket[i] != exp[i] {
+			t.Fatalf(" bucket %d is %d, expected %d", i, h.bucket[i], exp[i])
+		}
+	}
+	if h.sumMsec != 19011 || h.maxMsec != 9000 {
+		t.Fatalf(" sum %d max %d", h.sumMsec, h.maxMsec)
+	}
+	db := NewLatHistDb("lat", "pkts", &h)
+	if len(db.Vec) != 15 || db.Vec[0].Name != "le1ms" || db.Vec[12].Name != "gt5000ms" || db.Vec[12].Unit != "pkts" {
+		t.Fatalf(" unexpected db %s", db.MarshalMeta())
+	}
+
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	tctx.GetTimerCtx().Ticks = 7
+	if d := tctx.LatNow(); d != 7*tctx.GetTimerCtx().TickDuration {
+		t.Fatalf(" simulation time should be the ticks %v", d)
+	}
+}
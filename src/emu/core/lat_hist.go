@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// latency buckets in msec, the last bucket of the histogram is everything above
+var latHistBuckets = [...]uint64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000}
+
+// LatHist latency histogram of the responses in msec
+type LatHist struct {
+	bucket  [len(latHistBuckets) + 1]uint64
+	sumMsec uint64
+	maxMsec uint64
+}
+
+// Add count the latency of a response
+func (o *LatHist) Add(d time.Duration) {
+	ms := uint64(d / time.Millisecond)
+	i := 0
+	for i < len(latHistBuckets) && ms > latHistBuckets[i] {
+		i++
+	}
+	o.bucket[i]++
+	o.sumMsec += ms
+	if ms > o.maxMsec {
+		o.maxMsec = ms
+	}
+}
+
+// NewLatHistDb the counters of the histogram, unit is the unit of the buckets, e.g. ops or pkts
+func NewLatHistDb(name string, unit string, o *LatHist) *CCounterDb {
+	db := NewCCounterDb(name)
+	for i := range o.bucket {
+		var n, h string
+		if i < len(latHistBuckets) {
+			n = fmt.Sprintf("le%vms", latHistBuckets[i])
+			h = fmt.Sprintf("responses in %v msec or less", latHistBuckets[i])
+		} else {
+			n = fmt.Sprintf("gt%vms", latHistBuckets[i-1])
+			h = fmt.Sprintf("responses after more than %v msec", latHistBuckets[i-1])
+		}
+		db.Add(&CCounterRec{
+			Counter:  &o.bucket[i],
+			Name:     n,
+			Help:     h,
+			Unit:     unit,
+			DumpZero: false,
+			Info:     ScINFO})
+	}
+
+	db.Add(&CCounterRec{
+		Counter:  &o.sumMsec,
+		Name:     "sumMsec",
+		Help:     "sum of the latency, for the average",
+		Unit:     "msec",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.maxMsec,
+		Name:     "maxMsec",
+		Help:     "max latency",
+		Unit:     "msec",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	return db
+}
+
+// LatNow the time to measure a latency, the ticks of the timer wheel in simulation to be deterministic
+func (o *CThreadCtx) LatNow() time.Duration {
+	if o.Simulation {
+		timerw := o.GetTimerCtx()
+		return time.Duration(timerw.Ticks) * timerw.TickDuration
+	}
+	return time.Duration(time.Now().UnixNano())
+}
@@ -12,4 +12,6 @@ const (
 	MSG_UPDATE_DGIPV4_ADDR = "update_dgipv4"   // client plugin, DG ipv4 addr was changed (oldIpv4, NewIpv4 from type Ipv4Key )
 	MSG_UPDATE_DGIPV6_ADDR = "update_dgipv6"   // client plugin, DG ipv4 addr was changed (oldIpv6, NewIpv6 from type Ipv6Key )
 	MSG_DG_MAC_RESOLVED    = "dg_mac_resolved" // client plugin, DG MAC was resolved. When sending this message, the first broadcast parameter `a` is a bit mask of the previous flags.
+	MSG_IPV4_CONFLICT      = "ipv4_conflict"   // client plugin, another host uses the source ipv4 (Ipv4 from type Ipv4Key, MAC of the other host from type MACKey)
+	MSG_IPV6_CONFLICT      = "ipv6_conflict"   // client plugin, DAD found another node with an ipv6 of the client (Ipv6 from type Ipv6Key, MAC of the other node from type MACKey)
 )
@@ -0,0 +1,256 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* OpenMetrics exporter
+
+Serves the counters of the thread, namespaces, clients and plugins in OpenMetrics text format on /metrics.
+The counters are owned by the thread, so a scrape is a request to the main loop of each thread that returns
+a snapshot. A metric is trex_emu_<db>_<counter>_<unit>, e.g. trex_emu_arp_pktTxArpQuery_pkts
+
+	# TYPE trex_emu_arp_pktTxArpQuery_pkts gauge
+	# UNIT trex_emu_arp_pktTxArpQuery_pkts pkts
+	# HELP trex_emu_arp_pktTxArpQuery_pkts tx arp query
+	trex_emu_arp_pktTxArpQuery_pkts{thread="0",vport="1",tci="1,2",tpid="0x8100,0x8100",plugin="arp",severity="info"} 3
+
+labels: thread, vport/tci/tpid of the namespace, mac of the client and the plugin name. severity is the Info of the counter.
+All the metrics are gauges as counters can be cleared by the RPC and some of them are levels (e.g. active clients).
+Zero counters are skipped unless the counter is marked with DumpZero, the same as the counters RPC.
+
+A plugin exports its counters by implementing IPluginCounters.
+*/
+
+const (
+	metricsPrefix      = "trex_emu_"
+	metricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	metricsTimeout     = 2 * time.Second
+)
+
+// IPluginCounters is implemented by a plugin that exports its counters
+type IPluginCounters interface {
+	GetCounterDbVec() *CCounterDbVec
+}
+
+type cMetricFamily struct {
+	name    string
+	help    string
+	unit    string
+	samples []string
+}
+
+// CMetrics a snapshot of counters with their labels
+type CMetrics struct {
+	families map[string]*cMetricFamily
+}
+
+func NewMetrics() *CMetrics {
+	o := new(CMetrics)
+	o.families = make(map[string]*cMetricFamily)
+	return o
+}
+
+func metricsSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// metricsHelpEscaper escapes backslash and line feed in the HELP text, as OpenMetrics requires
+var metricsHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func metricsSeverity(info uint8) string {
+	switch info {
+	case ScWARNING:
+		return "warning"
+	case ScERROR:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// AddDb add the valid counters of the db with the labels, labels is a list of name="value" separated by comma
+func (o *CMetrics) AddDb(db *CCounterDb, labels string) {
+	db.Preupdate()
+	for _, rec := range db.Vec {
+		if !rec.IsValid() {
+			continue
+		}
+		val := rec.GetValAsString()
+		if val == "N/A" {
+			continue
+		}
+		name := metricsPrefix + metricsSanitize(db.Name+"_"+rec.Name)
+		unit := metricsSanitize(rec.Unit)
+		if unit != "" {
+			name += "_" + unit
+		}
+		f, ok := o.families[name]
+		if !ok {
+			f = &cMetricFamily{name: name, help: rec.Help, unit: unit}
+			o.families[name] = f
+		}
+		l := fmt.Sprintf(`severity="%s"`, metricsSeverity(rec.Info))
+		if labels != "" {
+			l = labels + "," + l
+		}
+		f.samples = append(f.samples, fmt.Sprintf("%s{%s} %s", name, l, val))
+	}
+}
+
+func (o *CMetrics) AddDbVec(vec *CCounterDbVec, labels string) {
+	for _, db := range vec.Vec {
+		o.AddDb(db, labels)
+	}
+}
+
+// addPlugins add the counters of the plugins that implement IPluginCounters
+func (o *CMetrics) addPlugins(ctx *PluginCtx, labels string) {
+	for _, name := range ctx.GetAllPlugNames() {
+		plug := ctx.Get(name)
+		c, ok := plug.Ext.(IPluginCounters)
+		if !ok {
+			continue
+		}
+		vec := c.GetCounterDbVec()
+		if vec == nil {
+			continue
+		}
+		o.AddDbVec(vec, fmt.Sprintf(`%s,plugin="%s"`, labels, name))
+	}
+}
+
+// Merge add the samples of another snapshot, e.g. of another thread
+func (o *CMetrics) Merge(m *CMetrics) {
+	for name, mf := range m.families {
+		f, ok := o.families[name]
+		if !ok {
+			o.families[name] = mf
+			continue
+		}
+		f.samples = append(f.samples, mf.samples...)
+	}
+}
+
+// Write the snapshot in OpenMetrics text format, the families are sorted by name
+func (o *CMetrics) Write(w io.Writer) error {
+	names := make([]string, 0, len(o.families))
+	for name := range o.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := o.families[name]
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		if f.unit != "" {
+			fmt.Fprintf(&b, "# UNIT %s %s\n", name, f.unit)
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, metricsHelpEscaper.Replace(f.help))
+		for _, s := range f.samples {
+			b.WriteString(s)
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("# EOF\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (o *CNSCtx) metricsLabels() string {
+	var d CTunnelDataJson
+	o.Key.GetJson(&d)
+	return fmt.Sprintf(`vport="%d",tci="%d,%d",tpid="0x%x,0x%x"`, d.Vport, d.Tci[0], d.Tci[1], d.Tpid[0], d.Tpid[1])
+}
+
+// CollectMetrics return a snapshot of the counters of the thread, namespaces, clients and their plugins
+func (o *CThreadCtx) CollectMetrics() *CMetrics {
+	m := NewMetrics()
+	tl := fmt.Sprintf(`thread="%d"`, o.Id)
+	m.AddDbVec(o.cdbv, tl)
+	m.addPlugins(o.PluginCtx, tl)
+
+	var it DListIterHead
+	for it.Init(&o.nsHead); it.IsCont(); it.Next() {
+		ns := castDlistNSCtx(it.Val())
+		nl := tl + "," + ns.metricsLabels()
+		m.AddDb(ns.cdb, nl)
+		m.addPlugins(ns.PluginCtx, nl)
+
+		var cit DListIterHead
+		for cit.Init(&ns.clientHead); cit.IsCont(); cit.Next() {
+			client := castDlistClient(cit.Val())
+			cl := fmt.Sprintf(`%s,mac="%s"`, nl, net.HardwareAddr(client.Mac[:]).String())
+			m.addPlugins(client.PluginCtx, cl)
+		}
+	}
+	return m
+}
+
+// EnableMetrics let other goroutines request a snapshot of the counters from the main loop, call it before the main loop
+func (o *CThreadCtx) EnableMetrics() {
+	o.metricsC = make(chan chan *CMetrics)
+}
+
+// RequestMetrics called from another goroutine, return nil in case the main loop does not answer
+func (o *CThreadCtx) RequestMetrics() *CMetrics {
+	c := make(chan *CMetrics, 1)
+	select {
+	case o.metricsC <- c:
+	case <-time.After(metricsTimeout):
+		return nil
+	}
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(metricsTimeout):
+		return nil
+	}
+}
+
+// StartMetricsServer serve the counters of the threads on http://addr/metrics
+func StartMetricsServer(addr string, threads []*CThreadCtx) error {
+	for _, tctx := range threads {
+		tctx.EnableMetrics()
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m := NewMetrics()
+		for _, tctx := range threads {
+			tm := tctx.RequestMetrics()
+			if tm == nil {
+				http.Error(w, fmt.Sprintf("thread %d does not answer", tctx.Id), http.StatusServiceUnavailable)
+				return
+			}
+			m.Merge(tm)
+		}
+		w.Header().Set("Content-Type", metricsContentType)
+		m.Write(w)
+	})
+	go func() {
+		// Serve returns only in case the listener fails
+		log.Printf("metrics server on %s stopped: %v", addr, http.Serve(ln, mux))
+	}()
+	return nil
+}
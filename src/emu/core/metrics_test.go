@@ -0,0 +1,102 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+type metricsTestPlug struct {
+	PluginBase
+	cnt  uint64
+	cdbv *CCounterDbVec
+}
+
+func (o *metricsTestPlug) OnEvent(msg string, a, b interface{}) {}
+func (o *metricsTestPlug) OnRemove(ctx *PluginCtx)              {}
+
+func (o *metricsTestPlug) GetCounterDbVec() *CCounterDbVec {
+	return o.cdbv
+}
+
+func TestMetricsFormat(t *testing.T) {
+	var cnt uint64 = 7
+	var errCnt uint32 = 0
+	db := NewCCounterDb("my db")
+	db.Add(&CCounterRec{Counter: &cnt, Name: "pktTx", Help: "tx packets", Unit: "pkts", DumpZero: false, Info: ScINFO})
+	db.Add(&CCounterRec{Counter: &errCnt, Name: "errRx", Help: "rx errors", Unit: "pkts", DumpZero: true, Info: ScERROR})
+	db.Add(&CCounterRec{Counter: &errCnt, Name: "skipped", Help: "zero", Unit: "ops", DumpZero: false, Info: ScINFO})
+
+	m := NewMetrics()
+	m.AddDb(db, `thread="0"`)
+	var b strings.Builder
+	m.Write(&b)
+
+	exp := `# TYPE trex_emu_my_db_errRx_pkts gauge
+# UNIT trex_emu_my_db_errRx_pkts pkts
+# HELP trex_emu_my_db_errRx_pkts rx errors
+trex_emu_my_db_errRx_pkts{thread="0",severity="error"} 0
+# TYPE trex_emu_my_db_pktTx_pkts gauge
+# UNIT trex_emu_my_db_pktTx_pkts pkts
+# HELP trex_emu_my_db_pktTx_pkts tx packets
+trex_emu_my_db_pktTx_pkts{thread="0",severity="info"} 7
+# EOF
+`
+	if b.String() != exp {
+		t.Fatalf(" unexpected metrics\n%s\nexpected\n%s", b.String(), exp)
+	}
+}
+
+func TestMetricsHelpEscape(t *testing.T) {
+	var cnt uint64 = 1
+	db := NewCCounterDb("esc")
+	db.Add(&CCounterRec{Counter: &cnt, Name: "cnt", Help: "a\\b\nc", Unit: "", Info: ScINFO})
+
+	m := NewMetrics()
+	m.AddDb(db, "")
+	var b strings.Builder
+	m.Write(&b)
+
+	exp := "# HELP trex_emu_esc_cnt a\\\\b\\nc\n"
+	if !strings.Contains(b.String(), exp) {
+		t.Fatalf(" missing %q in\n%s", exp, b.String())
+	}
+}
+
+func TestMetricsCollect(t *testing.T) {
+	var simrx VethIFSim = &VethSink{}
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var key CTunnelKey
+	key.Set(&CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	client := NewClient(ns, MACKey{0, 0, 1, 0, 0, 1}, Ipv4Key{16, 0, 0, 1}, Ipv6Key{}, Ipv4Key{16, 0, 0, 2})
+	ns.AddClient(client)
+
+	plug := new(metricsTestPlug)
+	plug.cnt = 3
+	db := NewCCounterDb("test")
+	db.Add(&CCounterRec{Counter: &plug.cnt, Name: "events", Help: "events", Unit: "event", Info: ScINFO})
+	plug.cdbv = NewCCounterDbVec("test")
+	plug.cdbv.Add(db)
+	plug.InitPluginBase(client.PluginCtx, plug)
+	client.PluginCtx.mapPlugins["test"] = &plug.PluginBase
+
+	var b strings.Builder
+	tctx.CollectMetrics().Write(&b)
+	s := b.String()
+
+	for _, exp := range []string{
+		`trex_emu_ns_addClient_ops{thread="0",vport="1",tci="1,2",tpid="0x8100,0x8100",severity="info"} 1`,
+		`trex_emu_test_events_event{thread="0",vport="1",tci="1,2",tpid="0x8100,0x8100",mac="00:00:01:00:00:01",plugin="test",severity="info"} 3`,
+	} {
+		if !strings.Contains(s, exp) {
+			t.Fatalf(" missing %s in\n%s", exp, s)
+		}
+	}
+}
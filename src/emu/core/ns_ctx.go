@@ -192,7 +192,9 @@ type CNSCtx struct {
 	iterReady      bool
 	iter           DListIterHead
 	cdb            *CCounterDb
-	DefClientPlugs *MapJsonPlugs // Default plugins for each new client
+	DefClientPlugs *MapJsonPlugs  // Default plugins for each new client
+	Routes         *CRouteTable   // ipv4 routes of the ns, nil in case there are none
+	Ipv4Resolver   Ipv4ResolverIF // resolves route next hops, set by a ns plugin
 }
 
 type CNsInfo struct {
@@ -513,6 +515,25 @@ func (o *CNSCtx) UpdateClientDIpv6(client *CClient, NewIpv6 Ipv6Key) error {
 	return nil
 }
 
+// AddClientIpv6 add an address that was generated by the client (SLAAC stable/temporary) to the lookup table
+func (o *CNSCtx) AddClientIpv6(client *CClient, ipv6 Ipv6Key) error {
+	if ipv6.IsZero() {
+		return fmt.Errorf(" Adding invalid zero ipv6")
+	}
+	if _, ok := o.mapIpv6[ipv6]; ok {
+		return fmt.Errorf(" ipv6 %v already exist", ipv6.ToIP())
+	}
+	o.mapIpv6[ipv6] = client
+	return nil
+}
+
+// RemoveClientIpv6 remove an address that was added by AddClientIpv6
+func (o *CNSCtx) RemoveClientIpv6(client *CClient, ipv6 Ipv6Key) {
+	if c, ok := o.mapIpv6[ipv6]; ok && c == client {
+		delete(o.mapIpv6, ipv6)
+	}
+}
+
 // IterReset save the rpc epoc and operate only if there wasn't a change
 func (o *CNSCtx) IterReset() bool {
 
@@ -6,6 +6,7 @@
 package core
 
 import (
+	"bytes"
 	"encoding/binary"
 	"external/google/gopacket/layers"
 	"fmt"
@@ -27,6 +28,9 @@ const (
 	IPV6_EXT_END        = 59
 )
 
+// cdpLlcSnap the LLC/SNAP header of CDP, Cisco OUI and protocol 0x2000
+var cdpLlcSnap = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00}
+
 const (
 	IPV6_OPTION_NONE  = 0
 	IPV6_OPTION_PAD   = 1
@@ -78,6 +82,10 @@ type ParserStats struct {
 	errUDP                uint64
 	eapolPkts             uint64
 	eapolBytes            uint64
+	lldpPkts              uint64
+	lldpBytes             uint64
+	cdpPkts               uint64
+	cdpBytes              uint64
 
 	arpPkts               uint64
 	arpBytes              uint64
@@ -138,6 +146,38 @@ func newParserStatsDb(o *ParserStats) *CCounterDb {
 		DumpZero: false,
 		Info:     ScINFO})
 
+	db.Add(&CCounterRec{
+		Counter:  &o.lldpPkts,
+		Name:     "lldpPkts",
+		Help:     "lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.lldpBytes,
+		Name:     "lldpBytes",
+		Help:     "lldp bytes",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.cdpPkts,
+		Name:     "cdpPkts",
+		Help:     "cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.cdpBytes,
+		Name:     "cdpBytes",
+		Help:     "cdp bytes",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     ScINFO})
+
 	db.Add(&CCounterRec{
 		Counter:  &o.errInternalHandler,
 		Name:     "errInternalHandler",
@@ -483,17 +523,22 @@ type Parser struct {
 
 	stats ParserStats
 	/* call backs */
-	arp    ParserCb
-	icmp   ParserCb
-	igmp   ParserCb
-	dhcp   ParserCb
-	dhcpv6 ParserCb
-	mdns   ParserCb
-	tcp    ParserCb
-	udp    ParserCb
-	icmpv6 ParserCb
-	eapol  ParserCb
-	Cdb    *CCounterDb
+	arp       ParserCb
+	icmp      ParserCb
+	igmp      ParserCb
+	dhcp      ParserCb
+	dhcpv6    ParserCb
+	dhcpsrv   ParserCb
+	dhcprly   ParserCb
+	dhcpv6srv ParserCb
+	mdns      ParserCb
+	tcp       ParserCb
+	udp       ParserCb
+	icmpv6    ParserCb
+	eapol     ParserCb
+	lldp      ParserCb
+	cdp       ParserCb
+	Cdb       *CCounterDb
 }
 
 func parserNotSupported(ps *ParserPacketState) int {
@@ -513,18 +558,33 @@ func (o *Parser) Register(protocol string) {
 	if protocol == "dhcp" {
 		o.dhcp = getProto("dhcp")
 	}
+	if protocol == "dhcpsrv" {
+		o.dhcpsrv = getProto("dhcpsrv")
+	}
+	if protocol == "dhcprelay" {
+		o.dhcprly = getProto("dhcprelay")
+	}
 	if protocol == "icmpv6" {
 		o.icmpv6 = getProto("icmpv6")
 	}
 	if protocol == "dhcpv6" {
 		o.dhcpv6 = getProto("dhcpv6")
 	}
+	if protocol == "dhcpv6srv" {
+		o.dhcpv6srv = getProto("dhcpv6srv")
+	}
 	if protocol == "dot1x" {
 		o.eapol = getProto("dot1x")
 	}
 	if protocol == "mdns" {
 		o.mdns = getProto("mdns")
 	}
+	if protocol == "lldp" {
+		o.lldp = getProto("lldp")
+	}
+	if protocol == "cdp" {
+		o.cdp = getProto("cdp")
+	}
 
 	if protocol == "transport" {
 		o.tcp = getProto("transport")
@@ -538,11 +598,16 @@ func (o *Parser) Init(tctx *CThreadCtx) {
 	o.icmp = parserNotSupported
 	o.igmp = parserNotSupported
 	o.dhcp = parserNotSupported
+	o.dhcpsrv = parserNotSupported
+	o.dhcprly = parserNotSupported
 	o.tcp = parserNotSupported
 	o.udp = parserNotSupported
 	o.icmpv6 = parserNotSupported
 	o.dhcpv6 = parserNotSupported
+	o.dhcpv6srv = parserNotSupported
 	o.mdns = parserNotSupported
+	o.lldp = parserNotSupported
+	o.cdp = parserNotSupported
 	o.Cdb = newParserStatsDb(&o.stats)
 }
 
@@ -628,12 +693,29 @@ func (o *Parser) parsePacketL4(ps *ParserPacketState,
 				o.stats.dhcpBytes += uint64(packetSize)
 				return o.dhcpv6(ps)
 			}
+			if udp.DstPort() == 547 {
+				o.stats.dhcpPkts++
+				o.stats.dhcpBytes += uint64(packetSize)
+				if o.dhcpv6srv(ps) == PARSER_OK {
+					return PARSER_OK
+				}
+				/* no server in the namespace, the packet is for the udp sockets */
+			}
 		} else {
 			if (udp.SrcPort() == 67) && (udp.DstPort() == 68) {
 				o.stats.dhcpPkts++
 				o.stats.dhcpBytes += uint64(packetSize)
 				return o.dhcp(ps)
 			}
+			if udp.DstPort() == 67 {
+				o.stats.dhcpPkts++
+				o.stats.dhcpBytes += uint64(packetSize)
+				/* a relay and a server can share the namespace, the relay handles only its own packets */
+				if o.dhcprly(ps) == PARSER_OK || o.dhcpsrv(ps) == PARSER_OK {
+					return PARSER_OK
+				}
+				/* no server in the namespace, the packet is for the udp sockets */
+			}
 		}
 
 		return o.udp(ps)
@@ -745,6 +827,13 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			o.stats.eapolBytes += uint64(packetSize)
 			return o.eapol(&ps)
 
+		case layers.EthernetTypeLinkLayerDiscovery:
+			ps.L3 = offset
+			tun.Set(&d)
+			o.stats.lldpPkts++
+			o.stats.lldpBytes += uint64(packetSize)
+			return o.lldp(&ps)
+
 		case layers.EthernetTypeARP:
 			if packetSize < uint32(offset+layers.ARPHeaderSize) {
 				o.stats.errArpTooShort++
@@ -901,6 +990,15 @@ func (o *Parser) ParsePacket(m *Mbuf) int {
 			ps.L4 = l4
 			return o.parsePacketL4(&ps, nh, ipv6.GetPhCs(osize, nh), l4len, uint16(nextHdr))
 		default:
+			if (uint16(nextHdr) < 0x600) && (packetSize >= uint32(offset+8)) &&
+				bytes.Equal(p[offset:offset+8], cdpLlcSnap) {
+				// 802.3 length with the LLC/SNAP header of CDP
+				ps.L3 = offset + 8
+				tun.Set(&d)
+				o.stats.cdpPkts++
+				o.stats.cdpBytes += uint64(packetSize)
+				return o.cdp(&ps)
+			}
 			o.stats.errL3ProtoUnsupported++
 			return PARSER_ERR
 		}
@@ -401,3 +401,58 @@ func TestParserIpv6Option(t *testing.T) {
 	parser.ParsePacket(m1)
 
 }
+
+// UDP to the port of a DHCP server goes to the udp sockets in case there is no server in the namespace
+func TestParserDhcpSrvFallback(t *testing.T) {
+	tctx := NewThreadCtx(0, 4510, false, nil)
+	var parser Parser
+	parser.tctx = tctx
+	parser.dhcprly = parserNotSupported
+	parser.dhcpsrv = parserNotSupported
+	parser.dhcpv6srv = parserNotSupported
+	parser.udp = arpSupported
+
+	for _, ipv6 := range []bool{false, true} {
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true}
+		if ipv6 {
+			gopacket.SerializeLayers(buf, opts,
+				&layers.Ethernet{
+					SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+					DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+					EthernetType: layers.EthernetTypeIPv6,
+				},
+				&layers.IPv6{Version: 6, HopLimit: 64, SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("ff02::1:2"),
+					NextHeader: layers.IPProtocolUDP},
+				&layers.UDP{SrcPort: 546, DstPort: 547},
+				gopacket.Payload([]byte{1, 2, 3, 4}),
+			)
+		} else {
+			gopacket.SerializeLayers(buf, opts,
+				&layers.Ethernet{
+					SrcMAC:       net.HardwareAddr{0, 1, 1, 1, 1, 1},
+					DstMAC:       net.HardwareAddr{0, 2, 2, 2, 2, 2},
+					EthernetType: layers.EthernetTypeIPv4,
+				},
+				&layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc, SrcIP: net.IPv4(16, 0, 0, 1), DstIP: net.IPv4(48, 0, 0, 1),
+					Protocol: layers.IPProtocolUDP},
+				&layers.UDP{SrcPort: 68, DstPort: 67},
+				gopacket.Payload([]byte{1, 2, 3, 4}),
+			)
+		}
+		data := buf.Bytes()
+		if !ipv6 {
+			ipv4 := layers.IPv4Header(data[14 : 14+20])
+			ipv4.UpdateChecksum()
+		}
+		m1 := tctx.MPool.Alloc(uint16(len(data)))
+		m1.Append(data)
+		m1.SetVPort(7)
+
+		arp = 0
+		parser.ParsePacket(m1)
+		if arp != 1 {
+			t.Fatalf(" udp cb should be called, ipv6 %v ", ipv6)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+/* IPv4 route table
+
+A table per namespace and optionally per client. The client table is looked up first, a destination
+without a route goes to the default gateway of the client (the behavior without a route table).
+Routes are longest prefix match with multiple next hops, a flow chooses the next hop by hashing its tuple (ECMP).
+A next hop with zero via is on-link, the destination itself is resolved.
+The mac of a next hop is static in case it is provided, else it is resolved by the ns resolver (ARP plugin)
+
+*/
+
+// Ipv4ResolverIF resolves the mac of an IPv4 neighbor, a plugin on the ns level implements it
+type Ipv4ResolverIF interface {
+	// ResolveIPv4 return the mac of the ipv4, in case it is not resolved it might start resolution on behalf of the client
+	ResolveIPv4(client *CClient, ipv4 Ipv4Key) (MACKey, bool)
+}
+
+type CRouteNextHop struct {
+	Via Ipv4Key `json:"via"` // zero for on-link
+	Mac MACKey  `json:"mac"` // static resolution, optional
+}
+
+type CRoute struct {
+	Prefix    Ipv4Key         `json:"prefix"`
+	PrefixLen uint8           `json:"prefix_len" validate:"lte=32"`
+	NextHops  []CRouteNextHop `json:"next_hops" validate:"required,min=1,dive"`
+}
+
+// CRouteKey identify a route for removing
+type CRouteKey struct {
+	Prefix    Ipv4Key `json:"prefix"`
+	PrefixLen uint8   `json:"prefix_len" validate:"lte=32"`
+}
+
+type MapRoute map[uint32]*CRoute
+
+// CRouteTable longest prefix match table, a map per prefix length
+type CRouteTable struct {
+	tbl       [33]MapRoute
+	cnt       uint32
+	iter      []CRoute
+	iterIndex int
+	iterReady bool
+}
+
+func routeMask(prefixLen uint8) uint32 {
+	if prefixLen == 0 {
+		return 0
+	}
+	return ^uint32(0) << (32 - uint32(prefixLen))
+}
+
+// NewRouteTable create an empty route table
+func NewRouteTable() *CRouteTable {
+	o := new(CRouteTable)
+	return o
+}
+
+// Add a route, the prefix is masked by the prefix length. An existing route is replaced
+func (o *CRouteTable) Add(route *CRoute) error {
+	if route.PrefixLen > 32 {
+		return fmt.Errorf("invalid prefix length %v", route.PrefixLen)
+	}
+	if len(route.NextHops) == 0 {
+		return fmt.Errorf("route %v/%v without next hops", route.Prefix, route.PrefixLen)
+	}
+	r := new(CRoute)
+	*r = *route
+	r.NextHops = append([]CRouteNextHop{}, route.NextHops...)
+	key := r.Prefix.Uint32() & routeMask(r.PrefixLen)
+	r.Prefix.SetUint32(key)
+
+	m := o.tbl[r.PrefixLen]
+	if m == nil {
+		m = make(MapRoute)
+		o.tbl[r.PrefixLen] = m
+	}
+	if _, ok := m[key]; !ok {
+		o.cnt++
+	}
+	m[key] = r
+	return nil
+}
+
+// Remove a route
+func (o *CRouteTable) Remove(rkey *CRouteKey) error {
+	if rkey.PrefixLen > 32 {
+		return fmt.Errorf("invalid prefix length %v", rkey.PrefixLen)
+	}
+	key := rkey.Prefix.Uint32() & routeMask(rkey.PrefixLen)
+	m := o.tbl[rkey.PrefixLen]
+	if _, ok := m[key]; !ok {
+		return fmt.Errorf("route %v/%v does not exist", rkey.Prefix, rkey.PrefixLen)
+	}
+	delete(m, key)
+	o.cnt--
+	return nil
+}
+
+// Lookup the longest prefix match of the destination, nil in case there is no route
+func (o *CRouteTable) Lookup(dst Ipv4Key) *CRoute {
+	if o.cnt == 0 {
+		return nil
+	}
+	d := dst.Uint32()
+	for l := 32; l >= 0; l-- {
+		m := o.tbl[l]
+		if len(m) == 0 {
+			continue
+		}
+		if r, ok := m[d&routeMask(uint8(l))]; ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// Len return the number of routes
+func (o *CRouteTable) Len() uint32 {
+	return o.cnt
+}
+
+// GetRoutes return a copy of the routes, the longest prefixes first
+func (o *CRouteTable) GetRoutes() []CRoute {
+	r := make([]CRoute, 0, o.cnt)
+	for l := 32; l >= 0; l-- {
+		s := len(r)
+		for _, v := range o.tbl[l] {
+			r = append(r, *v)
+		}
+		part := r[s:]
+		sort.Slice(part, func(i, j int) bool { return part[i].Prefix.Uint32() < part[j].Prefix.Uint32() })
+	}
+	return r
+}
+
+// IterReset take a snapshot of the routes for iteration, return true in case the table is empty
+func (o *CRouteTable) IterReset() bool {
+	o.iter = o.GetRoutes()
+	o.iterIndex = 0
+	o.iterReady = len(o.iter) > 0
+	return !o.iterReady
+}
+
+func (o *CRouteTable) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *CRouteTable) GetNext(n uint16) ([]CRoute, error) {
+	if !o.iterReady {
+		return []CRoute{}, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+	end := o.iterIndex + int(n)
+	if end >= len(o.iter) {
+		end = len(o.iter)
+		o.iterReady = false // require a new reset
+	}
+	r := o.iter[o.iterIndex:end]
+	o.iterIndex = end
+	if !o.iterReady {
+		o.iter = nil
+	}
+	return r, nil
+}
+
+// NextHop choose a next hop by the flow hash
+func (o *CRoute) NextHop(hash uint32) *CRouteNextHop {
+	return &o.NextHops[hash%uint32(len(o.NextHops))]
+}
+
+// RouteHashIPv4 the ECMP hash of a flow, the same flow always choose the same next hop
+func RouteHashIPv4(src Ipv4Key, dst Ipv4Key, proto uint8, srcPort uint16, dstPort uint16) uint32 {
+	/* FNV-1a */
+	h := uint32(2166136261)
+	mix := func(b byte) {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	for _, b := range src {
+		mix(b)
+	}
+	for _, b := range dst {
+		mix(b)
+	}
+	mix(proto)
+	mix(byte(srcPort >> 8))
+	mix(byte(srcPort))
+	mix(byte(dstPort >> 8))
+	mix(byte(dstPort))
+	return h
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"testing"
+)
+
+func TestRouteLpm(t *testing.T) {
+	tbl := NewRouteTable()
+	tbl.Add(&CRoute{Prefix: Ipv4Key{0, 0, 0, 0}, PrefixLen: 0,
+		NextHops: []CRouteNextHop{{Via: Ipv4Key{16, 0, 0, 1}}}})
+	tbl.Add(&CRoute{Prefix: Ipv4Key{48, 1, 2, 3}, PrefixLen: 16,
+		NextHops: []CRouteNextHop{{Via: Ipv4Key{16, 0, 0, 2}}}})
+	tbl.Add(&CRoute{Prefix: Ipv4Key{48, 1, 2, 0}, PrefixLen: 24,
+		NextHops: []CRouteNextHop{{Via: Ipv4Key{16, 0, 0, 3}}, {Via: Ipv4Key{16, 0, 0, 4}}}})
+
+	if tbl.Len() != 3 {
+		t.Fatalf(" expected 3 routes, got %v", tbl.Len())
+	}
+
+	r := tbl.Lookup(Ipv4Key{48, 1, 2, 10})
+	if r == nil || r.PrefixLen != 24 {
+		t.Fatalf(" expected the /24 route, got %+v", r)
+	}
+	r = tbl.Lookup(Ipv4Key{48, 1, 3, 10})
+	if r == nil || r.PrefixLen != 16 || r.Prefix != (Ipv4Key{48, 1, 0, 0}) {
+		t.Fatalf(" expected the masked /16 route, got %+v", r)
+	}
+	r = tbl.Lookup(Ipv4Key{10, 0, 0, 1})
+	if r == nil || r.PrefixLen != 0 {
+		t.Fatalf(" expected the default route, got %+v", r)
+	}
+
+	tbl.Remove(&CRouteKey{Prefix: Ipv4Key{0, 0, 0, 0}, PrefixLen: 0})
+	if tbl.Lookup(Ipv4Key{10, 0, 0, 1}) != nil {
+		t.Fatalf(" default route wasn't removed")
+	}
+	if err := tbl.Remove(&CRouteKey{Prefix: Ipv4Key{0, 0, 0, 0}, PrefixLen: 0}); err == nil {
+		t.Fatalf(" removing a route twice should fail")
+	}
+}
+
+func TestRouteEcmp(t *testing.T) {
+	var route CRoute
+	route.NextHops = []CRouteNextHop{{Via: Ipv4Key{16, 0, 0, 3}}, {Via: Ipv4Key{16, 0, 0, 4}}}
+	used := make(map[Ipv4Key]int)
+	for port := uint16(1000); port < 1100; port++ {
+		hash := RouteHashIPv4(Ipv4Key{16, 0, 0, 10}, Ipv4Key{48, 1, 2, 10}, 17, port, 53)
+		nh := route.NextHop(hash)
+		if nh != route.NextHop(hash) {
+			t.Fatalf(" the same flow should use the same next hop")
+		}
+		used[nh.Via]++
+	}
+	if len(used) != 2 {
+		t.Fatalf(" flows should be spread over both next hops %v", used)
+	}
+}
+
+func TestRouteIter(t *testing.T) {
+	tbl := NewRouteTable()
+	if !tbl.IterReset() {
+		t.Fatalf(" empty table should report empty")
+	}
+	for i := 0; i < 5; i++ {
+		tbl.Add(&CRoute{Prefix: Ipv4Key{48, uint8(i), 0, 0}, PrefixLen: 16,
+			NextHops: []CRouteNextHop{{Via: Ipv4Key{16, 0, 0, 1}}}})
+	}
+	tbl.IterReset()
+	var routes []CRoute
+	for !tbl.IterIsStopped() {
+		r, err := tbl.GetNext(2)
+		if err != nil {
+			t.Fatalf(" %v", err)
+		}
+		routes = append(routes, r...)
+	}
+	if len(routes) != 5 {
+		t.Fatalf(" expected 5 routes, got %v", len(routes))
+	}
+	for i := range routes {
+		if routes[i].Prefix[1] != uint8(i) {
+			t.Fatalf(" routes are not sorted %+v", routes)
+		}
+	}
+}
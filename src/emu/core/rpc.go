@@ -46,9 +46,7 @@ func (o *CZmqJsonRPC2) SetRpcRecorder(rpcRec *[]interface{}) {
 // NewZmqRpc create a zmq server in port
 func (o *CZmqJsonRPC2) NewZmqRpc(serverPort uint16, simulation bool) {
 	context, err := zmq.NewContext()
-	o.simulation = simulation
 	socket, err := context.NewSocket(zmq.REP)
-	o.cn = make(chan []byte)
 
 	if err != nil {
 		panic(err)
@@ -64,6 +62,15 @@ func (o *CZmqJsonRPC2) NewZmqRpc(serverPort uint16, simulation bool) {
 	bindStr := fmt.Sprintf("tcp://*:%d", o.serverPort)
 	socket.Bind(bindStr)
 
+	o.NewRpc(simulation)
+}
+
+// NewRpc create the method repository without a zmq socket. The requests are
+// pushed to the channel by the owner, used by the thread manager workers.
+func (o *CZmqJsonRPC2) NewRpc(simulation bool) {
+	o.simulation = simulation
+	o.cn = make(chan []byte)
+
 	mr := jsonrpc.NewMethodRepository()
 	o.mr = mr
 	o.mr.Verbose = false
@@ -109,7 +116,9 @@ func (o *CZmqJsonRPC2) StartRxThread() {
 
 // Delete  this is an help
 func (o *CZmqJsonRPC2) Delete() {
-	o.socket.Close()
+	if o.socket != nil {
+		o.socket.Close()
+	}
 }
 
 // HandleReqToChan input buffer return resonse to chan
@@ -112,6 +112,31 @@ type (
 		Vec     []*MACKey `json:"data"`
 	}
 
+	/* Route Commands, on the client routes in case mac is provided else on the ns routes */
+	ApiRouteAddHandler struct{}
+	ApiRouteAddParams  struct {
+		Mac    *MACKey  `json:"mac"`
+		Routes []CRoute `json:"routes" validate:"required,dive"`
+	}
+
+	ApiRouteRemoveHandler struct{}
+	ApiRouteRemoveParams  struct {
+		Mac    *MACKey     `json:"mac"`
+		Routes []CRouteKey `json:"routes" validate:"required,dive"`
+	}
+
+	ApiRouteIterHandler struct{}
+	ApiRouteIterParams  struct {
+		Mac   *MACKey `json:"mac"`
+		Reset bool    `json:"reset"`
+		Count uint16  `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiRouteIterResult struct {
+		Empty   bool     `json:"empty"`
+		Stopped bool     `json:"stopped"`
+		Vec     []CRoute `json:"data"`
+	}
+
 	ApiCntHandler struct{}
 	ApiCntParams  struct {
 		Meta  bool     `json:"meta"`
@@ -525,6 +550,146 @@ func (h ApiClientIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.Raw
 	return &res, nil
 }
 
+// getRouteTable return the route table of the client in case mac is provided, else of the ns
+func getRouteTable(ctx interface{}, params *fastjson.RawMessage, mac *MACKey, create bool) (*CRouteTable, error) {
+	tctx := ctx.(*CThreadCtx)
+	ns, err := tctx.GetNsRpc(params)
+	if err != nil {
+		return nil, err
+	}
+	if ns == nil {
+		return nil, fmt.Errorf("namespace doesn't exists")
+	}
+	if mac == nil {
+		if ns.Routes == nil && create {
+			ns.Routes = NewRouteTable()
+		}
+		return ns.Routes, nil
+	}
+	client := ns.GetClient(mac)
+	if client == nil {
+		return nil, fmt.Errorf("client with mac: %v doesn't exists", *mac)
+	}
+	if client.Routes == nil && create {
+		client.Routes = NewRouteTable()
+	}
+	return client.Routes, nil
+}
+
+func (h ApiRouteAddHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiRouteAddParams
+	tctx := ctx.(*CThreadCtx)
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	tbl, err := getRouteTable(ctx, params, p.Mac, true)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	for i := range p.Routes {
+		err = tbl.Add(&p.Routes[i])
+		if err != nil {
+			return nil, &jsonrpc.Error{
+				Code:    jsonrpc.ErrorCodeInvalidRequest,
+				Message: err.Error(),
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (h ApiRouteRemoveHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiRouteRemoveParams
+	tctx := ctx.(*CThreadCtx)
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	tbl, err := getRouteTable(ctx, params, p.Mac, false)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	if tbl == nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "there are no routes",
+		}
+	}
+
+	for i := range p.Routes {
+		err = tbl.Remove(&p.Routes[i])
+		if err != nil {
+			return nil, &jsonrpc.Error{
+				Code:    jsonrpc.ErrorCodeInvalidRequest,
+				Message: err.Error(),
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (h ApiRouteIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiRouteIterParams
+	var res ApiRouteIterResult
+	tctx := ctx.(*CThreadCtx)
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	tbl, err := getRouteTable(ctx, params, p.Mac, true)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = tbl.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if tbl.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+	res.Vec, err = tbl.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &res, nil
+}
+
 func (h ApiCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
 	var p ApiCntParams
@@ -573,6 +738,10 @@ func init() {
 	RegisterCB("ctx_client_set_def_plugins", ApiClientSetDefPlugHandler{}, false)
 	RegisterCB("ctx_client_get_def_plugins", ApiClientGetDefPlugHandler{}, false)
 	RegisterCB("ctx_client_iter", ApiClientIterHandler{}, false)
+
+	RegisterCB("ctx_route_add", ApiRouteAddHandler{}, false)
+	RegisterCB("ctx_route_remove", ApiRouteRemoveHandler{}, false)
+	RegisterCB("ctx_route_iter", ApiRouteIterHandler{}, false)
 	/* TBD add client_update */
 
 }
@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+/*
+Package testpki builds the certificates of the TLS tests.
+
+The keys are derived from a seed and Ed25519 signatures are deterministic, so the
+certificates and the sizes of the TLS messages are the same in each run and the
+generated files can be compared to the expected ones.
+*/
+package testpki
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// Key an Ed25519 key derived from seed
+func Key(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+func template(serial int64, cn string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+func create(tmpl, parent *x509.Certificate, key, parentKey ed25519.PrivateKey) *x509.Certificate {
+	der, err := x509.CreateCertificate(rand.New(rand.NewSource(tmpl.SerialNumber.Int64())), tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		panic(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Cert a certificate of cn signed by parent, a self signed CA in case parent is nil.
+// usage is the extended key usage of the leaf, a server leaf has cn as its DNS name
+func Cert(serial int64, cn string, key ed25519.PrivateKey, parent *x509.Certificate, parentKey ed25519.PrivateKey, usage x509.ExtKeyUsage) *x509.Certificate {
+	tmpl := template(serial, cn)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		return create(tmpl, tmpl, key, key)
+	}
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	if usage == x509.ExtKeyUsageServerAuth {
+		tmpl.DNSNames = []string{cn}
+	}
+	return create(tmpl, parent, key, parentKey)
+}
+
+// SelfSigned a self signed certificate of the server cn, for tests in which the client does not verify it
+func SelfSigned(cn string) (*x509.Certificate, ed25519.PrivateKey) {
+	key := Key(7)
+	tmpl := template(1, cn)
+	tmpl.DNSNames = []string{cn}
+	return create(tmpl, tmpl, key, key), key
+}
+
+// encode the PEM encoding of der
+func encode(t string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: t, Bytes: der})
+}
+
+// CertPem the PEM encoding of c
+func CertPem(c *x509.Certificate) []byte {
+	return encode("CERTIFICATE", c.Raw)
+}
+
+// KeyPem the PEM encoding of key in PKCS #8
+func KeyPem(key ed25519.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return encode("PRIVATE KEY", der)
+}
@@ -218,6 +218,7 @@ type CThreadCtx struct {
 	shutdownTimer   CHTimerObj            // Timer object for shutdown
 	shutdownTimerCb ShutdownTimerCallback // Timer callback object
 	markForShutdown bool                  // device should shutdown, timer completed
+	metricsC        chan chan *CMetrics   // snapshot requests of the metrics server, nil in case it is disabled
 }
 
 func NewThreadCtxProxy() *CThreadCtx {
@@ -238,13 +239,28 @@ func NewThreadCtxProxy() *CThreadCtx {
 }
 
 func NewThreadCtx(Id uint32, serverPort uint16, simulation bool, simRx *VethIFSim) *CThreadCtx {
+	return newThreadCtx(Id, serverPort, true, simulation, simRx)
+}
+
+// NewThreadCtxWorker create a thread context that does not own a RPC socket,
+// the requests are routed to it by CThreadMgr.
+func NewThreadCtxWorker(Id uint32, simulation bool, simRx *VethIFSim) *CThreadCtx {
+	return newThreadCtx(Id, 0, false, simulation, simRx)
+}
+
+func newThreadCtx(Id uint32, serverPort uint16, bindRpc bool, simulation bool, simRx *VethIFSim) *CThreadCtx {
 	o := new(CThreadCtx)
+	o.Id = Id
 	o.timerctx = NewTimerCtx(simulation)
 	o.portMap = make(MapPortT)
 	o.Simulation = simulation
 	o.mapNs = make(MapNsT)
 	o.MPool.Init(mBUFS_CACHE)
-	o.rpc.NewZmqRpc(serverPort, simulation)
+	if bindRpc {
+		o.rpc.NewZmqRpc(serverPort, simulation)
+	} else {
+		o.rpc.NewRpc(simulation)
+	}
 	o.rpc.SetCtx(o) /* back pointer to interface this */
 	o.nsHead.SetSelf()
 	o.PluginCtx = NewPluginCtx(nil, nil, o, PLUGIN_LEVEL_THREAD)
@@ -381,6 +397,8 @@ func (o *CThreadCtx) MainLoop() {
 			o.timerctx.HandleTicks()
 		case msg := <-o.Veth.GetC(): // batch of rx packets
 			o.Veth.OnRxStream(msg)
+		case c := <-o.metricsC: // counters snapshot
+			c <- o.CollectMetrics()
 		}
 		o.Veth.FlushTx()
 		if o.markForShutdown {
@@ -874,3 +892,9 @@ func (o *CThreadCtx) SetRpcParams(v, c bool) {
 	o.rpc.mr.Verbose = v
 	o.rpc.mr.Capture = c
 }
+
+// setApiHandler set the API handler that was agreed by another thread
+func (o *CThreadCtx) setApiHandler(api string) {
+	o.apiHandler = api
+	o.rpc.mr.SetAPI(api)
+}
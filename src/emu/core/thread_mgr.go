@@ -0,0 +1,731 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+/* Thread manager, shard the namespaces over N thread contexts
+
+   Each namespace is owned by one thread, chosen by a hash of the CTunnelKey.
+   The threads do not share any state (mbuf pool, timers, parser) and
+   the manager is the only one that talks with them using channels.
+
+   1. RPC  - the manager owns the RPC socket.
+             params with "tun"     - routed to the owner thread
+             params with "tunnels" - split per owner thread, the results are merged by order
+             ctx_cnt               - broadcast, the counters are summed
+             ctx_iter              - iterate the threads one after the other
+             ctx_set_def_plugins, shutdown, api_sync_v2 - broadcast
+             all the rest          - thread 0
+   2. RX   - the manager owns the veth (zmq or raw), each rx message is split into a message per owner thread
+   3. TX   - each thread builds its own message in the zmq veth format, the manager sends it
+
+   The manager goroutine is the only one that touches the veth. While it waits for a thread
+   it keeps draining the tx channel so a thread that is blocked on tx can always make progress.
+*/
+
+const (
+	THREAD_MGR_PLUG      = "thread_mgr"
+	THREAD_MGR_RX_QUEUE  = 64
+	THREAD_MGR_TX_QUEUE  = 256
+	threadMgrStreamBytes = 32 * 1024
+)
+
+type CThreadMgrStats struct {
+	rpcRouted      uint64
+	rpcSplit       uint64
+	rpcBroadcast   uint64
+	rpcErrThreadDn uint64
+	rxStreams      uint64
+	rxPkts         uint64
+	rxErrTunnel    uint64
+	rxDropThreadDn uint64
+	txStreams      uint64
+}
+
+func newThreadMgrStatsDb(o *CThreadMgrStats) *CCounterDb {
+	db := NewCCounterDb(THREAD_MGR_PLUG)
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcRouted,
+		Name:     "rpcRouted",
+		Help:     "rpc routed to the owner thread",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcSplit,
+		Name:     "rpcSplit",
+		Help:     "rpc with tunnels split per thread",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcBroadcast,
+		Name:     "rpcBroadcast",
+		Help:     "rpc broadcast to all threads",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rpcErrThreadDn,
+		Name:     "rpcErrThreadDn",
+		Help:     "rpc to a thread that was shut down",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxStreams,
+		Name:     "rxStreams",
+		Help:     "rx messages dispatched",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxPkts,
+		Name:     "rxPkts",
+		Help:     "rx packets dispatched",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxErrTunnel,
+		Name:     "rxErrTunnel",
+		Help:     "rx packets without a valid tunnel, sent to thread 0",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.rxDropThreadDn,
+		Name:     "rxDropThreadDn",
+		Help:     "rx packets dropped, thread was shut down",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.txStreams,
+		Name:     "txStreams",
+		Help:     "tx messages sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	return db
+}
+
+// VethIFStream is a veth that can be shared by the threads of CThreadMgr, the messages are in the zmq veth format
+type VethIFStream interface {
+	GetC() chan []byte
+	StartRxThread()
+	SendStream(stream []byte)
+	GetStats() *VethStats
+	SimulatorCleanup()
+}
+
+// cRpcResponse is a JSON-RPC response that keeps the result as is
+type cRpcResponse struct {
+	Version string               `json:"jsonrpc"`
+	Result  *fastjson.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpc.Error       `json:"error,omitempty"`
+	ID      *fastjson.RawMessage `json:"id,omitempty"`
+}
+
+// CThreadMgr shard the namespaces over a few CThreadCtx
+type CThreadMgr struct {
+	threads    []*CThreadCtx
+	running    []bool
+	active     int
+	rxC        []chan []byte // rx zmq messages per thread
+	txC        chan []byte   // tx zmq messages from all the threads
+	doneC      chan uint32   // thread Id that exit the main loop
+	rpc        CZmqJsonRPC2
+	veth       VethIFStream
+	rxBuf      [][]byte
+	rxCnt      []int
+	iterThread int
+	iterReady  bool
+	stats      CThreadMgrStats
+	cdb        *CCounterDb
+}
+
+// NewThreadMgr create a manager with threads thread contexts, the RPC server is bound to serverPort
+func NewThreadMgr(threads uint32, serverPort uint16, simulation bool, simRx *VethIFSim) *CThreadMgr {
+	if threads == 0 {
+		panic(" thread manager requires at least one thread ")
+	}
+	o := new(CThreadMgr)
+	o.rpc.NewZmqRpc(serverPort, simulation)
+	o.txC = make(chan []byte, THREAD_MGR_TX_QUEUE)
+	o.doneC = make(chan uint32, threads)
+	for i := uint32(0); i < threads; i++ {
+		o.threads = append(o.threads, NewThreadCtxWorker(i, simulation, simRx))
+		o.running = append(o.running, false)
+		o.rxC = append(o.rxC, make(chan []byte, THREAD_MGR_RX_QUEUE))
+		o.rxBuf = append(o.rxBuf, make([]byte, 0, threadMgrStreamBytes))
+		o.rxCnt = append(o.rxCnt, 0)
+	}
+	o.cdb = newThreadMgrStatsDb(&o.stats)
+	return o
+}
+
+// GetThreads return all the thread contexts
+func (o *CThreadMgr) GetThreads() []*CThreadCtx {
+	return o.threads
+}
+
+// GetThreadIndex return the index of the thread that owns the namespace with this key
+func (o *CThreadMgr) GetThreadIndex(key *CTunnelKey) int {
+	if len(o.threads) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write(key[:])
+	return int(h.Sum32() % uint32(len(o.threads)))
+}
+
+// GetThread return the thread that owns the namespace with this key
+func (o *CThreadMgr) GetThread(key *CTunnelKey) *CThreadCtx {
+	return o.threads[o.GetThreadIndex(key)]
+}
+
+// SetVeth set the veth that is owned by the manager, each thread gets a shard veth
+func (o *CThreadMgr) SetVeth(veth VethIFStream) {
+	o.veth = veth
+	for i, tctx := range o.threads {
+		shard := new(VethIFZmq)
+		shard.CreateShard(tctx, o.rxC[i], o.txC)
+		tctx.SetZmqVeth(shard)
+	}
+}
+
+// SetRpcParams set verbose and capture for all the threads
+func (o *CThreadMgr) SetRpcParams(v, c bool) {
+	for _, tctx := range o.threads {
+		tctx.SetRpcParams(v, c)
+	}
+}
+
+func (o *CThreadMgr) StartRxThread() {
+	o.rpc.StartRxThread()
+	if o.veth != nil {
+		o.veth.StartRxThread()
+	}
+}
+
+// Start run the main loop of each thread in its own goroutine
+func (o *CThreadMgr) Start() {
+	for i, tctx := range o.threads {
+		o.running[i] = true
+		o.active++
+		go o.runThread(tctx)
+	}
+}
+
+func (o *CThreadMgr) runThread(tctx *CThreadCtx) {
+	tctx.MainLoop()
+	o.doneC <- tctx.Id
+}
+
+func (o *CThreadMgr) onThreadDone(id uint32) {
+	if o.running[id] {
+		o.running[id] = false
+		o.active--
+	}
+}
+
+func (o *CThreadMgr) vethC() chan []byte {
+	if o.veth == nil {
+		return nil
+	}
+	return o.veth.GetC()
+}
+
+// MainLoop start the threads and serve RPC, rx and tx until all the threads are shut down
+func (o *CThreadMgr) MainLoop() {
+	o.Start()
+	for o.active > 0 {
+		select {
+		case req := <-o.rpc.GetC():
+			o.rpc.GetC() <- o.HandleReq(req)
+		case stream := <-o.vethC():
+			o.dispatchRx(stream)
+		case stream := <-o.txC:
+			o.sendTx(stream)
+		case id := <-o.doneC:
+			o.onThreadDone(id)
+		}
+	}
+	o.drainTx()
+	if o.veth != nil {
+		o.veth.SimulatorCleanup()
+	}
+}
+
+func (o *CThreadMgr) Delete() {
+	for _, tctx := range o.threads {
+		tctx.Delete()
+	}
+	o.rpc.Delete()
+}
+
+func (o *CThreadMgr) sendTx(stream []byte) {
+	o.stats.txStreams++
+	if o.veth != nil {
+		o.veth.SendStream(stream)
+	}
+}
+
+func (o *CThreadMgr) drainTx() {
+	for {
+		select {
+		case stream := <-o.txC:
+			o.sendTx(stream)
+		default:
+			return
+		}
+	}
+}
+
+// push a message to a thread channel, keep serving tx while the thread is busy
+func (o *CThreadMgr) push(c chan []byte, b []byte) {
+	for {
+		select {
+		case c <- b:
+			return
+		case stream := <-o.txC:
+			o.sendTx(stream)
+		}
+	}
+}
+
+// pull a message from a thread channel, keep serving tx while the thread is busy
+func (o *CThreadMgr) pull(c chan []byte) []byte {
+	for {
+		select {
+		case b := <-c:
+			return b
+		case stream := <-o.txC:
+			o.sendTx(stream)
+		}
+	}
+}
+
+// GetPktTunnelKey extract the tunnel key of a packet the same way the parser does
+func GetPktTunnelKey(vport uint16, p []byte, key *CTunnelKey) bool {
+	var d CTunnelData
+	d.Vport = vport
+	if len(p) < 14 {
+		return false
+	}
+	offset := 14
+	nextHdr := layers.EthernetType(binary.BigEndian.Uint16(p[12:14]))
+	for i := 0; i < 2; i++ {
+		if nextHdr != layers.EthernetTypeDot1Q && nextHdr != layers.EthernetTypeQinQ {
+			break
+		}
+		if len(p) < offset+4 {
+			return false
+		}
+		d.Vlans[i] = binary.BigEndian.Uint32(p[offset-2:offset+2]) & 0xffff0fff
+		nextHdr = layers.EthernetType(binary.BigEndian.Uint16(p[offset+2 : offset+4]))
+		offset += 4
+	}
+	key.Set(&d)
+	return true
+}
+
+// dispatchRx split a zmq rx message into a message per owner thread
+func (o *CThreadMgr) dispatchRx(stream []byte) {
+	o.stats.rxStreams++
+	for i := range o.rxBuf {
+		o.rxBuf[i] = append(o.rxBuf[i][:0], 0, 0, 0, 0)
+		o.rxCnt[i] = 0
+	}
+	var key CTunnelKey
+	ZmqStreamForEach(stream, o.veth.GetStats(), func(vport uint8, pkt []byte) {
+		i := 0
+		if GetPktTunnelKey(uint16(vport), pkt, &key) {
+			i = o.GetThreadIndex(&key)
+		} else {
+			o.stats.rxErrTunnel++
+		}
+		o.stats.rxPkts++
+		o.rxBuf[i] = ZmqStreamAppendPkt(o.rxBuf[i], vport, pkt)
+		o.rxCnt[i]++
+	})
+	for i := range o.rxBuf {
+		if o.rxCnt[i] == 0 {
+			continue
+		}
+		if !o.running[i] {
+			o.stats.rxDropThreadDn += uint64(o.rxCnt[i])
+			continue
+		}
+		ZmqStreamSetHeader(o.rxBuf[i], o.rxCnt[i])
+		b := make([]byte, len(o.rxBuf[i]))
+		copy(b, o.rxBuf[i])
+		o.push(o.rxC[i], b)
+	}
+}
+
+// HandleReq handle a RPC request (compressed, batch) and return the response
+func (o *CThreadMgr) HandleReq(req []byte) []byte {
+	compress := jsonrpc.IsCompress(req)
+	if compress {
+		req = jsonrpc.UncompressBuff(req)
+	}
+
+	var b []byte
+	rs, batch, err := jsonrpc.ParseRequestBytes(req)
+	if err != nil {
+		b, _ = fastjson.Marshal(&cRpcResponse{Version: jsonrpc.Version, Error: err})
+	} else {
+		resp := make([]*cRpcResponse, len(rs))
+		for i, r := range rs {
+			resp[i] = o.route(r)
+		}
+		if batch || len(resp) > 1 {
+			b, _ = fastjson.Marshal(resp)
+		} else {
+			b, _ = fastjson.Marshal(resp[0])
+		}
+	}
+
+	if compress {
+		return jsonrpc.CompressBuff(b)
+	}
+	return b
+}
+
+func (o *CThreadMgr) route(r *jsonrpc.Request) *cRpcResponse {
+	var params map[string]*fastjson.RawMessage
+	if r.Params != nil {
+		// params that are not an object are handled by thread 0
+		fastjson.Unmarshal(*r.Params, &params)
+	}
+
+	switch r.Method {
+	case "api_sync_v2":
+		return o.apiSync(r)
+	case "ctx_iter":
+		return o.iterNs(r, params)
+	case "ctx_cnt":
+		return o.counters(r)
+	case "ctx_set_def_plugins", "shutdown":
+		return o.broadcast(r)
+	}
+
+	if tun, ok := params["tun"]; ok && tun != nil {
+		var d CTunnelDataJson
+		if fastjson.Unmarshal(*tun, &d) == nil {
+			var key CTunnelKey
+			key.SetJson(&d)
+			o.stats.rpcRouted++
+			return o.callThread(o.GetThreadIndex(&key), r)
+		}
+	}
+
+	if tuns, ok := params["tunnels"]; ok && tuns != nil {
+		return o.splitTunnels(r, params, tuns)
+	}
+
+	return o.callThread(0, r)
+}
+
+func (o *CThreadMgr) errResponse(r *jsonrpc.Request, msg string) *cRpcResponse {
+	return &cRpcResponse{
+		Version: r.Version,
+		ID:      r.ID,
+		Error: &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInternal,
+			Message: msg,
+		},
+	}
+}
+
+// callThread send one request to a thread and wait for the response
+func (o *CThreadMgr) callThread(i int, r *jsonrpc.Request) *cRpcResponse {
+	if !o.running[i] {
+		o.stats.rpcErrThreadDn++
+		return o.errResponse(r, "thread was shut down")
+	}
+	req, err := fastjson.Marshal(r)
+	if err != nil {
+		return o.errResponse(r, err.Error())
+	}
+	c := o.threads[i].rpc.GetC()
+	o.push(c, req)
+	b := o.pull(c)
+
+	var res cRpcResponse
+	if err := fastjson.Unmarshal(b, &res); err != nil {
+		return o.errResponse(r, err.Error())
+	}
+	return &res
+}
+
+func (o *CThreadMgr) withParams(r *jsonrpc.Request, params map[string]*fastjson.RawMessage) *jsonrpc.Request {
+	sub := *r
+	b, _ := fastjson.Marshal(params)
+	raw := fastjson.RawMessage(b)
+	sub.Params = &raw
+	return &sub
+}
+
+func toRaw(v interface{}) *fastjson.RawMessage {
+	b, _ := fastjson.Marshal(v)
+	raw := fastjson.RawMessage(b)
+	return &raw
+}
+
+// broadcast send the request to all the running threads, return the first error or the response of the first thread
+func (o *CThreadMgr) broadcast(r *jsonrpc.Request) *cRpcResponse {
+	var first *cRpcResponse
+	o.stats.rpcBroadcast++
+	for i := range o.threads {
+		if !o.running[i] {
+			continue
+		}
+		res := o.callThread(i, r)
+		if res.Error != nil {
+			return res
+		}
+		if first == nil {
+			first = res
+		}
+	}
+	if first == nil {
+		return o.callThread(0, r)
+	}
+	return first
+}
+
+// apiSync let thread 0 generate the API handler and set the same handler in all the threads
+func (o *CThreadMgr) apiSync(r *jsonrpc.Request) *cRpcResponse {
+	res := o.callThread(0, r)
+	if res.Error != nil {
+		return res
+	}
+	api := o.threads[0].rpc.mr.GetAPI()
+	for _, tctx := range o.threads[1:] {
+		tctx.setApiHandler(api)
+	}
+	return res
+}
+
+// splitTunnels send each thread only the tunnels it owns, in case the result is a slice per tunnel it is merged by order
+func (o *CThreadMgr) splitTunnels(r *jsonrpc.Request,
+	params map[string]*fastjson.RawMessage,
+	tuns *fastjson.RawMessage) *cRpcResponse {
+
+	var tunnels []*fastjson.RawMessage
+	if err := fastjson.Unmarshal(*tuns, &tunnels); err != nil || len(tunnels) == 0 {
+		return o.callThread(0, r)
+	}
+
+	perThread := make([][]*fastjson.RawMessage, len(o.threads))
+	index := make([][]int, len(o.threads))
+	for j, t := range tunnels {
+		var d CTunnelDataJson
+		var key CTunnelKey
+		if t == nil || fastjson.Unmarshal(*t, &d) != nil {
+			return o.callThread(0, r)
+		}
+		key.SetJson(&d)
+		i := o.GetThreadIndex(&key)
+		perThread[i] = append(perThread[i], t)
+		index[i] = append(index[i], j)
+	}
+
+	o.stats.rpcSplit++
+	results := make([]*fastjson.RawMessage, len(tunnels))
+	merge := true
+	var res *cRpcResponse
+	for i := range o.threads {
+		if len(perThread[i]) == 0 {
+			continue
+		}
+		params["tunnels"] = toRaw(perThread[i])
+		res = o.callThread(i, o.withParams(r, params))
+		if res.Error != nil {
+			return res
+		}
+		var vec []*fastjson.RawMessage
+		if res.Result == nil || fastjson.Unmarshal(*res.Result, &vec) != nil || len(vec) != len(perThread[i]) {
+			merge = false
+			continue
+		}
+		for k, v := range vec {
+			results[index[i][k]] = v
+		}
+	}
+	if merge {
+		res.Result = toRaw(results)
+	}
+	return res
+}
+
+// iterNs iterate the namespaces of all the threads one after the other
+func (o *CThreadMgr) iterNs(r *jsonrpc.Request, params map[string]*fastjson.RawMessage) *cRpcResponse {
+	var p ApiNsIterParams
+	if r.Params != nil {
+		fastjson.Unmarshal(*r.Params, &p)
+	}
+
+	if p.Reset {
+		o.iterThread = 0
+		o.iterReady = true
+	}
+	if !o.iterReady || params == nil {
+		return o.callThread(o.iterThread, r)
+	}
+
+	req := r
+	last := len(o.threads) - 1
+	for {
+		res := o.callThread(o.iterThread, req)
+		if res.Error != nil {
+			return res
+		}
+		var iter ApiNsIterResult
+		if res.Result != nil {
+			fastjson.Unmarshal(*res.Result, &iter)
+		}
+		if !iter.Empty && !iter.Stopped {
+			return res
+		}
+		if o.iterThread == last {
+			o.iterReady = false
+			if iter.Empty && !p.Reset {
+				/* the first threads were not empty */
+				res.Result = toRaw(&ApiNsIterResult{Stopped: true})
+			}
+			return res
+		}
+		/* this thread is done, move to the next one */
+		o.iterThread++
+		params["reset"] = toRaw(true)
+		req = o.withParams(r, params)
+	}
+}
+
+// counters broadcast ctx_cnt, the values of all the threads are summed
+func (o *CThreadMgr) counters(r *jsonrpc.Request) *cRpcResponse {
+	var p ApiCntParams
+	if r.Params != nil {
+		fastjson.Unmarshal(*r.Params, &p)
+	}
+
+	o.stats.rpcBroadcast++
+	var first *cRpcResponse
+	merged := make(map[string]interface{})
+	for i := range o.threads {
+		if !o.running[i] {
+			continue
+		}
+		res := o.callThread(i, r)
+		if res.Error != nil {
+			return res
+		}
+		if first == nil {
+			first = res
+		}
+		if p.Meta || p.Clear || res.Result == nil {
+			continue
+		}
+		var vals map[string]interface{}
+		d := fastjson.NewDecoder(bytes.NewReader(*res.Result))
+		d.UseNumber()
+		if d.Decode(&vals) == nil {
+			mergeCounterValues(merged, vals)
+		}
+	}
+	if first == nil {
+		return o.callThread(0, r)
+	}
+
+	if p.Clear {
+		o.cdb.ClearValues()
+		return first
+	}
+
+	if p.Meta {
+		var meta map[string]*fastjson.RawMessage
+		if first.Result != nil && fastjson.Unmarshal(*first.Result, &meta) == nil {
+			meta[o.cdb.Name] = toRaw(o.cdb)
+			first.Result = toRaw(meta)
+		}
+		return first
+	}
+
+	inMask := len(p.Mask) == 0
+	for _, name := range p.Mask {
+		if name == o.cdb.Name {
+			inMask = true
+		}
+	}
+	if inMask {
+		if vals := o.cdb.MarshalValues(p.Zero); len(vals) > 0 {
+			merged[o.cdb.Name] = vals
+		}
+	}
+	first.Result = toRaw(merged)
+	return first
+}
+
+// mergeCounterValues add the counters of src into dst, the values are fastjson.Number
+func mergeCounterValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		switch sv := v.(type) {
+		case map[string]interface{}:
+			dv, ok := dst[k].(map[string]interface{})
+			if !ok {
+				dv = make(map[string]interface{})
+				dst[k] = dv
+			}
+			mergeCounterValues(dv, sv)
+		case fastjson.Number:
+			dv, ok := dst[k].(fastjson.Number)
+			if !ok {
+				dst[k] = sv
+			} else {
+				dst[k] = addJsonNumbers(dv, sv)
+			}
+		default:
+			if _, ok := dst[k]; !ok {
+				dst[k] = v
+			}
+		}
+	}
+}
+
+func addJsonNumbers(a, b fastjson.Number) fastjson.Number {
+	ai, erra := strconv.ParseUint(string(a), 10, 64)
+	bi, errb := strconv.ParseUint(string(b), 10, 64)
+	if erra == nil && errb == nil {
+		return fastjson.Number(strconv.FormatUint(ai+bi, 10))
+	}
+	af, _ := a.Float64()
+	bf, _ := b.Float64()
+	return fastjson.Number(strconv.FormatFloat(af+bf, 'f', -1, 64))
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type threadMgrTestRes struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func threadMgrCall(t *testing.T, mgr *CThreadMgr, method string, params string) json.RawMessage {
+	req := fmt.Sprintf(`{"jsonrpc": "2.0", "method": "%s", "params": %s, "id": 3}`, method, params)
+	var res threadMgrTestRes
+	if err := json.Unmarshal(mgr.HandleReq([]byte(req)), &res); err != nil {
+		t.Fatalf(" %s bad response %v \n", method, err)
+	}
+	if res.Error != nil {
+		t.Fatalf(" %s failed %s \n", method, res.Error.Message)
+	}
+	return res.Result
+}
+
+func threadMgrTunnels(n int) string {
+	s := "["
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf(`{"vport": 1, "tci": [%d, 0], "tpid": [33024, 0]}`, i+1)
+	}
+	return s + "]"
+}
+
+func TestThreadMgrPktTunnelKey(t *testing.T) {
+	pkt := []byte{0, 0, 1, 0, 0, 1, 0, 0, 2, 0, 0, 2,
+		0x81, 0x00, 0xe0, 0x07, /* pcp is ignored */
+		0x81, 0x00, 0x00, 0x08,
+		0x08, 0x00}
+
+	var key, exp CTunnelKey
+	if !GetPktTunnelKey(2, pkt, &key) {
+		t.Fatalf(" failed to parse the tunnel \n")
+	}
+	exp.SetJson(&CTunnelDataJson{Vport: 2, Tpid: [2]uint16{0x8100, 0x8100}, Tci: [2]uint16{7, 8}})
+	if key != exp {
+		t.Fatalf(" tunnel key %v expected %v \n", key, exp)
+	}
+
+	if GetPktTunnelKey(2, pkt[:17], &key) {
+		t.Fatalf(" short packet should not have a tunnel \n")
+	}
+}
+
+func TestThreadMgrRpc(t *testing.T) {
+	const tunnels = 16
+	var simVeth VethSink
+	var simrx VethIFSim = &simVeth
+	mgr := NewThreadMgr(4, 4510, true, &simrx)
+	mgr.Start()
+
+	threadMgrCall(t, mgr, "ctx_add", fmt.Sprintf(`{"tunnels": %s}`, threadMgrTunnels(tunnels)))
+
+	/* each namespace is in the owner thread */
+	total := 0
+	for _, tctx := range mgr.GetThreads() {
+		total += len(tctx.mapNs)
+	}
+	if total != tunnels {
+		t.Fatalf(" namespaces %d expected %d \n", total, tunnels)
+	}
+	for i := 0; i < tunnels; i++ {
+		var key CTunnelKey
+		key.SetJson(&CTunnelDataJson{Vport: 1, Tci: [2]uint16{uint16(i + 1), 0}})
+		if mgr.GetThread(&key).GetNs(&key) == nil {
+			t.Fatalf(" namespace %d is not in the owner thread \n", i+1)
+		}
+	}
+
+	/* the results are merged in the order of the request */
+	var info []CNsInfo
+	json.Unmarshal(threadMgrCall(t, mgr, "ctx_get_info", fmt.Sprintf(`{"tunnels": %s}`, threadMgrTunnels(tunnels))), &info)
+	if len(info) != tunnels {
+		t.Fatalf(" ctx_get_info returned %d namespaces \n", len(info))
+	}
+	for i := range info {
+		if info[i].Tci[0] != uint16(i+1) {
+			t.Fatalf(" ctx_get_info wrong order %v \n", info[i].Tci)
+		}
+	}
+
+	/* iterate all the threads */
+	seen := make(map[uint16]bool)
+	params := `{"reset": true, "count": 3}`
+	for {
+		var iter ApiNsIterResult
+		json.Unmarshal(threadMgrCall(t, mgr, "ctx_iter", params), &iter)
+		params = `{"reset": false, "count": 3}`
+		if iter.Empty || iter.Stopped {
+			break
+		}
+		for _, d := range iter.Vec {
+			seen[d.Tci[0]] = true
+		}
+	}
+	if len(seen) != tunnels {
+		t.Fatalf(" ctx_iter returned %d namespaces \n", len(seen))
+	}
+
+	/* the counters of all the threads are summed */
+	var cnt map[string]map[string]uint64
+	raw := threadMgrCall(t, mgr, "ctx_cnt", `{"mask": ["ctx", "thread_mgr"]}`)
+	json.Unmarshal(raw, &cnt)
+	if cnt["ctx"]["addNs"] != tunnels {
+		t.Fatalf(" ctx addNs %d expected %d \n", cnt["ctx"]["addNs"], tunnels)
+	}
+	if cnt["thread_mgr"]["rpcSplit"] != 2 {
+		t.Fatalf(" thread_mgr rpcSplit %d expected 2 \n", cnt["thread_mgr"]["rpcSplit"])
+	}
+
+	threadMgrCall(t, mgr, "ctx_remove", fmt.Sprintf(`{"tunnels": %s}`, threadMgrTunnels(tunnels)))
+	for _, tctx := range mgr.GetThreads() {
+		if len(tctx.mapNs) != 0 {
+			t.Fatalf(" thread %d still has namespaces \n", tctx.Id)
+		}
+	}
+
+	threadMgrCall(t, mgr, "shutdown", `{"time": 0}`)
+	for mgr.active > 0 {
+		mgr.onThreadDone(<-mgr.doneC)
+	}
+	mgr.Delete()
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+)
+
+/* Stepped TLS session
+
+crypto/tls over an in memory net.Conn, for plugins that get the TLS data of the peer in packets (TCP, EAP).
+crypto/tls is blocking, so each session runs in its own goroutine and is stepped by the thread: the thread
+pushes the TLS data of the peer and waits until the session needs more data or ends. Only one of them runs
+at a time, so the session can update the plugin without locks. The TLS data to send is queued in the conn,
+the thread takes it after each step.
+*/
+
+// tlsSessionConn net.Conn between the thread and crypto/tls
+type tlsSessionConn struct {
+	in     bytes.Buffer
+	out    bytes.Buffer
+	inC    chan []byte
+	waitC  chan bool // true in case the session waits for data, false in case it ended
+	local  net.Addr
+	remote net.Addr
+}
+
+func (o *tlsSessionConn) Read(b []byte) (int, error) {
+	if o.in.Len() == 0 {
+		o.waitC <- true
+		d, ok := <-o.inC
+		if !ok {
+			return 0, io.EOF
+		}
+		o.in.Write(d)
+	}
+	return o.in.Read(b)
+}
+
+func (o *tlsSessionConn) Write(b []byte) (int, error) {
+	return o.out.Write(b)
+}
+
+func (o *tlsSessionConn) Close() error                       { return nil }
+func (o *tlsSessionConn) LocalAddr() net.Addr                { return o.local }
+func (o *tlsSessionConn) RemoteAddr() net.Addr               { return o.remote }
+func (o *tlsSessionConn) SetDeadline(t time.Time) error      { return nil }
+func (o *tlsSessionConn) SetReadDeadline(t time.Time) error  { return nil }
+func (o *tlsSessionConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// TlsSession a crypto/tls session that runs in its own goroutine and is stepped by the thread
+type TlsSession struct {
+	Conn    *tls.Conn
+	conn    *tlsSessionConn
+	running bool
+	err     error
+}
+
+// NewTlsSession create a client or server session, local/remote are the addresses of the net.Conn
+func NewTlsSession(cfg *tls.Config, server bool, local, remote net.Addr) *TlsSession {
+	o := new(TlsSession)
+	o.conn = &tlsSessionConn{inC: make(chan []byte), waitC: make(chan bool, 1), local: local, remote: remote}
+	if server {
+		o.Conn = tls.Server(o.conn, cfg)
+	} else {
+		o.Conn = tls.Client(o.conn, cfg)
+	}
+	return o
+}
+
+// Start run the session until it waits for data, run does the handshake and the rest of the session in the goroutine
+func (o *TlsSession) Start(run func(c *tls.Conn) error) {
+	go func() {
+		o.err = run(o.Conn)
+		o.conn.waitC <- false
+	}()
+	o.running = <-o.conn.waitC
+}
+
+// Step push TLS data of the peer and run the session until it waits for data or ends
+func (o *TlsSession) Step(d []byte) {
+	if !o.running || len(d) == 0 {
+		return
+	}
+	o.conn.inC <- d
+	o.running = <-o.conn.waitC
+}
+
+// TakeOut return the TLS data to send, nil in case there is none
+func (o *TlsSession) TakeOut() []byte {
+	if o.conn.out.Len() == 0 {
+		return nil
+	}
+	r := append([]byte{}, o.conn.out.Bytes()...)
+	o.conn.out.Reset()
+	return r
+}
+
+// Running returns true in case the session waits for data
+func (o *TlsSession) Running() bool {
+	return o.running
+}
+
+// Err the error that ended the session, valid once it is not running
+func (o *TlsSession) Err() error {
+	return o.err
+}
+
+// Close end the goroutine of the session, the session does not run after Close returns
+func (o *TlsSession) Close() {
+	if !o.running {
+		return
+	}
+	close(o.conn.inC) // the session gets EOF on each read and ends
+	for o.running {
+		o.running = <-o.conn.waitC
+	}
+}
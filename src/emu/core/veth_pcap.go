@@ -0,0 +1,252 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* Pcap veth, replay a capture in simulation
+
+   The rx packets are read from a pcap/pcapng file. The first packet is injected at
+   StartSec of the simulated clock and each packet after it keeps its gap from the first
+   packet, so a capture taken from a real DUT can be replayed against the plugins.
+   The tx packets are written to a pcap file with the simulated time.
+   RPC requests queued by AppendSimuationRPC and a VethIFSim responder work as in VethIFSimulator.
+*/
+
+import (
+	"bufio"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const pcapNgMagic = 0x0A0D0D0A
+
+// VethPcapCfg configure the pcap veth
+type VethPcapCfg struct {
+	RxFile   string  // pcap or pcapng file, the packets are injected to rx. Empty for no rx.
+	TxFile   string  // pcap file, the tx packets are written to it. Empty for no tx capture.
+	Vport    uint16  // vport of the rx packets
+	StartSec float64 // simulated time of the first rx packet
+}
+
+type VethPcapStats struct {
+	RxPcapPkts   uint64
+	RxPcapErrLen uint64
+	TxPcapErr    uint64
+}
+
+type vethPcapPkt struct {
+	time float64 // simulated time in sec
+	data []byte
+}
+
+// VethIFPcap is a simulation veth that replays rx from a pcap file and writes tx to a pcap file
+type VethIFPcap struct {
+	VethIFSimulator
+	cfg       VethPcapCfg
+	rx        []vethPcapPkt
+	rxIndex   int
+	baseTime  time.Time // wall time of simulated time 0
+	txFile    *os.File
+	txWriter  *pcapgo.Writer
+	pcapStats VethPcapStats
+}
+
+func newVethPcapStatsDb(db *CCounterDb, o *VethPcapStats) {
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxPcapPkts,
+		Name:     "RxPcapPkts",
+		Help:     "RxPcapPkts, packets injected from the pcap",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScINFO})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxPcapErrLen,
+		Name:     "RxPcapErrLen",
+		Help:     "RxPcapErrLen, pcap packets that are too long",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxPcapErr,
+		Name:     "TxPcapErr",
+		Help:     "TxPcapErr, error writing the tx pcap",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+}
+
+// PcapPacketReader reads the packets of a pcap or a pcapng file
+type PcapPacketReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// OpenPcapReader a reader of a pcap or a pcapng by the magic of the file
+func OpenPcapReader(r io.Reader) (PcapPacketReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(magic) == pcapNgMagic {
+		return pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(br)
+}
+
+// Create read the rx pcap and create the tx pcap. sim is an optional responder to the tx packets.
+func (o *VethIFPcap) Create(ctx *CThreadCtx, cfg VethPcapCfg, sim VethIFSim) error {
+	o.VethIFSimulator.Create(ctx)
+	o.Sim = sim
+	o.cfg = cfg
+	newVethPcapStatsDb(o.cdb, &o.pcapStats)
+	o.baseTime = time.Unix(0, 0)
+
+	if cfg.RxFile != "" {
+		if err := o.loadRx(cfg.RxFile); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TxFile != "" {
+		f, err := os.Create(cfg.TxFile)
+		if err != nil {
+			return err
+		}
+		o.txFile = f
+		o.txWriter = pcapgo.NewWriterNanos(f)
+		if err = o.txWriter.WriteFileHeader(uint32(MAX_PACKET_SIZE), layers.LinkTypeEthernet); err != nil {
+			o.closeTx()
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *VethIFPcap) loadRx(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := OpenPcapReader(f)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid pcap file: %v", filename, err)
+	}
+	if r.LinkType() != layers.LinkTypeEthernet {
+		return fmt.Errorf("%s link type %v is not supported, only Ethernet", filename, r.LinkType())
+	}
+
+	var first time.Time
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s error reading packet %d: %v", filename, len(o.rx)+1, err)
+		}
+		if len(o.rx) == 0 {
+			first = ci.Timestamp
+			/* keep the tx pcap in the same time line as the rx pcap */
+			o.baseTime = first.Add(-time.Duration(o.cfg.StartSec * float64(time.Second)))
+		}
+		o.rx = append(o.rx, vethPcapPkt{
+			time: o.cfg.StartSec + ci.Timestamp.Sub(first).Seconds(),
+			data: data})
+	}
+	return nil
+}
+
+func (o *VethIFPcap) closeTx() {
+	if o.txFile != nil {
+		o.txFile.Close()
+		o.txFile = nil
+		o.txWriter = nil
+	}
+}
+
+func (o *VethIFPcap) writeTx(m *Mbuf, timeSec float64) {
+	if o.txWriter == nil {
+		return
+	}
+	data := m.GetData()
+	ci := gopacket.CaptureInfo{
+		Timestamp:     o.baseTime.Add(time.Duration(timeSec * float64(time.Second))),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	if err := o.txWriter.WritePacket(ci, data); err != nil {
+		o.pcapStats.TxPcapErr++
+	}
+}
+
+// RxPending return the number of pcap packets that were not injected yet
+func (o *VethIFPcap) RxPending() int {
+	return len(o.rx) - o.rxIndex
+}
+
+func (o *VethIFPcap) SimulatorCheckRxQueue() {
+
+	o.handleRpcQueue()
+	now := o.tctx.GetTickSimInSec()
+	for _, m := range o.vec {
+		if o.K12Monitor {
+			m.DumpK12(now, o.monitorFile)
+		}
+		if o.Record {
+			o.tctx.SimRecordAppend(m.GetRecord(now, "tx"))
+		}
+		o.writeTx(m, now)
+		if o.Sim == nil {
+			m.FreeMbuf()
+			continue
+		}
+		mrx := o.Sim.ProcessTxToRx(m)
+		if mrx != nil {
+			o.rxvec = append(o.rxvec, mrx)
+		}
+	}
+	o.vec = o.vec[:0]
+
+	for ; o.rxIndex < len(o.rx) && o.rx[o.rxIndex].time <= now; o.rxIndex++ {
+		data := o.rx[o.rxIndex].data
+		if len(data) > int(MAX_PACKET_SIZE) {
+			o.pcapStats.RxPcapErrLen++
+			continue
+		}
+		o.pcapStats.RxPcapPkts++
+		m := o.tctx.MPool.Alloc(uint16(len(data)))
+		m.SetVPort(o.cfg.Vport)
+		m.Append(data)
+		o.rxvec = append(o.rxvec, m)
+	}
+
+	for _, m := range o.rxvec {
+		o.OnRx(m)
+	}
+	o.rxvec = o.rxvec[:0]
+}
+
+func (o *VethIFPcap) SimulatorCleanup() {
+
+	o.SimulatorCheckRxQueue()
+	for _, m := range o.vec {
+		m.FreeMbuf()
+	}
+	o.vec = nil
+	o.rxvec = nil
+	o.closeTx()
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func vethPcapTestPkt(id byte) []byte {
+	pkt := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 1, 0, 0, id, 0x08, 0x06}
+	return append(pkt, make([]byte, 46)...)
+}
+
+func TestVethPcapReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veth-pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	/* 3 packets, 0.5 sec apart */
+	rxFile := filepath.Join(dir, "rx.pcap")
+	f, _ := os.Create(rxFile)
+	w := pcapgo.NewWriter(f)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		pkt := vethPcapTestPkt(byte(i))
+		w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * 500 * time.Millisecond),
+			CaptureLength: len(pkt),
+			Length:        len(pkt)}, pkt)
+	}
+	f.Close()
+
+	var simrx VethIFSim
+	var simVeth VethSink
+	simrx = &simVeth
+	tctx := NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+
+	var veth VethIFPcap
+	txFile := filepath.Join(dir, "tx.pcap")
+	err = veth.Create(tctx, VethPcapCfg{RxFile: rxFile, TxFile: txFile, Vport: 1, StartSec: 1.0}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tctx.SetZmqVeth(&veth)
+	tctx.Veth.SetDebug(false, os.Stdout, true)
+
+	txPkt := vethPcapTestPkt(7)
+	m := tctx.MPool.Alloc(uint16(len(txPkt)))
+	m.SetVPort(1)
+	m.Append(txPkt)
+	tctx.Veth.Send(m)
+
+	tctx.MainLoopSim(10 * time.Second)
+
+	var rxTimes []float64
+	for _, r := range tctx.simRecorder {
+		if j, ok := r.(*MbufJson); ok && j.Meta == "rx" {
+			rxTimes = append(rxTimes, j.Time)
+		}
+	}
+	if len(rxTimes) != 3 || veth.RxPending() != 0 {
+		t.Fatalf(" expected 3 rx packets got %d \n", len(rxTimes))
+	}
+	for i, rt := range rxTimes {
+		exp := 1.0 + 0.5*float64(i)
+		if rt < exp || rt > exp+0.1 {
+			t.Fatalf(" packet %d injected at %f expected %f \n", i, rt, exp)
+		}
+	}
+
+	/* tx pcap */
+	f, _ = os.Open(txFile)
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var txCnt int
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, txPkt) || ci.Timestamp.Before(start.Add(-time.Second)) {
+			t.Fatalf(" unexpected tx packet %v \n", ci)
+		}
+		txCnt++
+	}
+	if txCnt != 1 {
+		t.Fatalf(" expected 1 tx packet got %d \n", txCnt)
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+/* Raw veth, bind directly to Linux interfaces using AF_PACKET sockets
+
+   Each interface is mapped to a vport. The rx side uses a TPACKET_V3 ring, a goroutine
+   per interface waits for a ready block and converts it into one message in the zmq veth
+   format (see veth_zmq.go), so the main loop and the thread manager handle it the same way.
+   The tx side writes the packets to the socket of the vport.
+
+   The kernel strips the vlan tags on rx and reports them in the ring header, the tags are
+   pushed back into the packet because the namespaces are keyed by the vlans.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	VETH_RAW_BLOCK_SIZE = 1 << 18
+	VETH_RAW_BLOCK_NR   = 16
+	VETH_RAW_FRAME_SIZE = 1 << 11
+	VETH_RAW_BLOCK_TOV  = 10  // msec, retire a block that is not full
+	VETH_RAW_POLL_TOV   = 100 // msec, check for stop
+
+	solPacket           = 263
+	packetAddMembership = 1
+	packetRxRing        = 5
+	packetVersion       = 10
+	packetMrPromisc     = 1
+	packetOutgoing      = 4
+	tpacketV3           = 2
+
+	tpStatusKernel        = 0
+	tpStatusUser          = 1
+	tpStatusVlanValid     = 0x10
+	tpStatusVlanTpidValid = 0x40
+
+	tpacket3HdrLen = 48 // TPACKET_ALIGN(sizeof(struct tpacket3_hdr))
+	pollIn         = 0x1
+)
+
+type tpacketReq3 struct {
+	blockSize      uint32
+	blockNr        uint32
+	frameSize      uint32
+	frameNr        uint32
+	retireBlkTov   uint32
+	sizeofPriv     uint32
+	featureReqWord uint32
+}
+
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// VethRawIfCfg map a Linux interface to a vport
+type VethRawIfCfg struct {
+	Name  string
+	Vport uint16
+}
+
+// VethRawStats are updated with atomic ops, by the rx goroutines and by the thread manager
+type VethRawStats struct {
+	RxRawErr     uint64
+	TxRawErr     uint64
+	TxDropNoPort uint64
+}
+
+type vethRawPort struct {
+	name    string
+	vport   uint16
+	ifindex int
+	fd      int
+	ring    []byte
+}
+
+// VethIFRaw is a veth that binds to Linux interfaces using AF_PACKET
+type VethIFRaw struct {
+	ports       map[uint16]*vethRawPort
+	cn          chan []byte
+	vec         []*Mbuf
+	stats       VethStats
+	rawStats    VethRawStats
+	tctx        *CThreadCtx
+	K12Monitor  bool     // K12 packet monitoring to monitorDest
+	monitorFile *os.File // File to print the K12 packet captured. Default is stdout.
+	cdb         *CCounterDb
+	stop        int32
+	wg          sync.WaitGroup
+}
+
+func newVethRawStatsDb(db *CCounterDb, o *VethRawStats) {
+
+	db.Add(&CCounterRec{
+		Counter:  &o.RxRawErr,
+		Name:     "RxRawErr",
+		Help:     "RxRawErr",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxRawErr,
+		Name:     "TxRawErr",
+		Help:     "TxRawErr",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+
+	db.Add(&CCounterRec{
+		Counter:  &o.TxDropNoPort,
+		Name:     "TxDropNoPort",
+		Help:     "TxDropNoPort, no interface for the vport",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     ScERROR})
+}
+
+// the ring headers are in host byte order
+func hostUint32(b []byte) uint32 {
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
+
+func hostUint16(b []byte) uint16 {
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+func htons(v uint16) uint16 {
+	return (v << 8) | (v >> 8)
+}
+
+func setsockopt(fd, level, name int, val unsafe.Pointer, vallen uintptr) error {
+	_, _, e := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name),
+		uintptr(val), vallen, 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// Create open an AF_PACKET socket with a TPACKET_V3 rx ring for each interface
+func (o *VethIFRaw) Create(ctx *CThreadCtx, ifs []VethRawIfCfg) error {
+	o.tctx = ctx
+	o.cn = make(chan []byte, VETH_RAW_BLOCK_NR)
+	o.vec = make([]*Mbuf, 0)
+	o.ports = make(map[uint16]*vethRawPort)
+	o.cdb = NewVethStatsDb(&o.stats)
+	newVethRawStatsDb(o.cdb, &o.rawStats)
+
+	if len(ifs) == 0 {
+		return fmt.Errorf("raw veth requires at least one interface")
+	}
+
+	for _, cfg := range ifs {
+		if cfg.Vport > 0xff {
+			o.close()
+			return fmt.Errorf("vport %d of interface %s should be less than 256", cfg.Vport, cfg.Name)
+		}
+		if _, ok := o.ports[cfg.Vport]; ok {
+			o.close()
+			return fmt.Errorf("vport %d is mapped to more than one interface", cfg.Vport)
+		}
+		p, err := o.openPort(cfg)
+		if err != nil {
+			o.close()
+			return err
+		}
+		o.ports[cfg.Vport] = p
+	}
+	return nil
+}
+
+func (o *VethIFRaw) openPort(cfg VethRawIfCfg) (*vethRawPort, error) {
+	ifc, err := net.InterfaceByName(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("interface %s, can't open AF_PACKET socket: %v", cfg.Name, err)
+	}
+	p := &vethRawPort{name: cfg.Name, vport: cfg.Vport, ifindex: ifc.Index, fd: fd}
+
+	if err = syscall.SetsockoptInt(fd, solPacket, packetVersion, tpacketV3); err != nil {
+		p.close()
+		return nil, fmt.Errorf("interface %s, TPACKET_V3 is not supported: %v", cfg.Name, err)
+	}
+
+	req := tpacketReq3{
+		blockSize:    VETH_RAW_BLOCK_SIZE,
+		blockNr:      VETH_RAW_BLOCK_NR,
+		frameSize:    VETH_RAW_FRAME_SIZE,
+		frameNr:      (VETH_RAW_BLOCK_SIZE / VETH_RAW_FRAME_SIZE) * VETH_RAW_BLOCK_NR,
+		retireBlkTov: VETH_RAW_BLOCK_TOV,
+	}
+	if err = setsockopt(fd, solPacket, packetRxRing, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+		p.close()
+		return nil, fmt.Errorf("interface %s, can't set rx ring: %v", cfg.Name, err)
+	}
+
+	p.ring, err = syscall.Mmap(fd, 0, VETH_RAW_BLOCK_SIZE*VETH_RAW_BLOCK_NR,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		p.close()
+		return nil, fmt.Errorf("interface %s, can't map rx ring: %v", cfg.Name, err)
+	}
+
+	/* the clients have their own MAC addresses */
+	mreq := packetMreq{ifindex: int32(ifc.Index), mrType: packetMrPromisc}
+	if err = setsockopt(fd, solPacket, packetAddMembership, unsafe.Pointer(&mreq), unsafe.Sizeof(mreq)); err != nil {
+		p.close()
+		return nil, fmt.Errorf("interface %s, can't set promiscuous mode: %v", cfg.Name, err)
+	}
+
+	sa := syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifc.Index}
+	if err = syscall.Bind(fd, &sa); err != nil {
+		p.close()
+		return nil, fmt.Errorf("interface %s, can't bind: %v", cfg.Name, err)
+	}
+	return p, nil
+}
+
+func (p *vethRawPort) close() {
+	if p.ring != nil {
+		syscall.Munmap(p.ring)
+		p.ring = nil
+	}
+	if p.fd >= 0 {
+		syscall.Close(p.fd)
+		p.fd = -1
+	}
+}
+
+func (o *VethIFRaw) close() {
+	for _, p := range o.ports {
+		p.close()
+	}
+	o.ports = nil
+}
+
+func (o *VethIFRaw) StartRxThread() {
+	for _, p := range o.ports {
+		o.wg.Add(1)
+		go o.rxThread(p)
+	}
+}
+
+func (o *VethIFRaw) stopped() bool {
+	return atomic.LoadInt32(&o.stop) != 0
+}
+
+func (o *VethIFRaw) rxThread(p *vethRawPort) {
+	defer o.wg.Done()
+	block := 0
+	pfd := pollFd{fd: int32(p.fd), events: pollIn}
+	for !o.stopped() {
+		hdr := p.ring[block*VETH_RAW_BLOCK_SIZE : (block+1)*VETH_RAW_BLOCK_SIZE]
+		status := (*uint32)(unsafe.Pointer(&hdr[8]))
+		if atomic.LoadUint32(status)&tpStatusUser == 0 {
+			_, _, e := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&pfd)), 1, VETH_RAW_POLL_TOV)
+			if e != 0 && e != syscall.EINTR {
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddUint64(&o.rawStats.RxRawErr, 1)
+			}
+			continue
+		}
+		stream := o.blockToStream(p, hdr)
+		atomic.StoreUint32(status, tpStatusKernel)
+		block = (block + 1) % VETH_RAW_BLOCK_NR
+		if stream != nil {
+			o.cn <- stream
+		}
+	}
+}
+
+// blockToStream convert a ring block into a message in the zmq veth format
+func (o *VethIFRaw) blockToStream(p *vethRawPort, hdr []byte) []byte {
+	pkts := hostUint32(hdr[12:16])
+	of := hostUint32(hdr[16:20])
+	stream := make([]byte, 4, 4+pkts*(4+VETH_RAW_FRAME_SIZE/2))
+	cnt := 0
+	for i := uint32(0); i < pkts && of+tpacket3HdrLen <= VETH_RAW_BLOCK_SIZE; i++ {
+		ph := hdr[of:]
+		next := hostUint32(ph[0:4])
+		snaplen := hostUint32(ph[12:16])
+		status := hostUint32(ph[20:24])
+		mac := uint32(hostUint16(ph[24:26]))
+		/* struct sockaddr_ll follows the header, sll_pkttype */
+		pktType := ph[tpacket3HdrLen+10]
+
+		if pktType != packetOutgoing && snaplen >= 14 && of+mac+snaplen <= VETH_RAW_BLOCK_SIZE {
+			pkt := ph[mac : mac+snaplen]
+			if status&tpStatusVlanValid != 0 {
+				tpid := uint16(0x8100)
+				if status&tpStatusVlanTpidValid != 0 {
+					tpid = hostUint16(ph[36:38])
+				}
+				tci := uint16(hostUint32(ph[32:36]))
+				var tag [4]byte
+				binary.BigEndian.PutUint16(tag[0:2], tpid)
+				binary.BigEndian.PutUint16(tag[2:4], tci)
+				tagged := make([]byte, 0, len(pkt)+4)
+				tagged = append(tagged, pkt[:12]...)
+				tagged = append(tagged, tag[:]...)
+				pkt = append(tagged, pkt[12:]...)
+			}
+			stream = ZmqStreamAppendPkt(stream, uint8(p.vport), pkt)
+			cnt++
+		}
+		if next == 0 {
+			break
+		}
+		of += next
+	}
+	if cnt == 0 {
+		return nil
+	}
+	ZmqStreamSetHeader(stream, cnt)
+	return stream
+}
+
+func (o *VethIFRaw) GetC() chan []byte {
+	return o.cn
+}
+
+func (o *VethIFRaw) OnRxStream(stream []byte) {
+	o.stats.RxBatch++
+	ZmqStreamForEach(stream, &o.stats, func(vport uint8, pkt []byte) {
+		m := o.tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(uint16(vport))
+		m.Append(pkt)
+		o.OnRx(m)
+	})
+}
+
+func (o *VethIFRaw) sendPkt(vport uint16, pkt []byte) {
+	p, ok := o.ports[vport]
+	if !ok {
+		atomic.AddUint64(&o.rawStats.TxDropNoPort, 1)
+		return
+	}
+	if _, err := syscall.Write(p.fd, pkt); err != nil {
+		atomic.AddUint64(&o.rawStats.TxRawErr, 1)
+	}
+}
+
+// SendStream send a message in the zmq veth format, should be called only from one goroutine
+func (o *VethIFRaw) SendStream(stream []byte) {
+	o.stats.TxBatch++
+	ZmqStreamForEach(stream, &o.stats, func(vport uint8, pkt []byte) {
+		o.sendPkt(uint16(vport), pkt)
+	})
+}
+
+func (o *VethIFRaw) FlushTx() {
+	if len(o.vec) == 0 {
+		return
+	}
+	o.stats.TxBatch++
+	for _, m := range o.vec {
+		if o.K12Monitor {
+			m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+		}
+		o.sendPkt(m.VPort(), m.GetData())
+		m.FreeMbuf()
+	}
+	o.vec = o.vec[:0]
+}
+
+func (o *VethIFRaw) Send(m *Mbuf) {
+	o.stats.TxPkts++
+	o.stats.TxBytes += uint64(m.PktLen())
+
+	if !m.IsContiguous() {
+		m1 := m.GetContiguous(&o.tctx.MPool)
+		m.FreeMbuf()
+		o.vec = append(o.vec, m1)
+	} else {
+		o.vec = append(o.vec, m)
+	}
+	if len(o.vec) == ZMQ_TX_PKT_BUTST_SIZE {
+		o.FlushTx()
+	}
+}
+
+// SendBuffer get a buffer as input, should allocate mbuf and call send
+func (o *VethIFRaw) SendBuffer(unicast bool, c *CClient, b []byte) {
+	m := o.tctx.MPool.Alloc(uint16(len(b)))
+	m.SetVPort(c.Ns.GetVport())
+	m.Append(b)
+	if unicast {
+		if c.DGW == nil || !c.DGW.IpdgResolved {
+			m.FreeMbuf()
+			o.stats.TxDropNotResolve++
+			return
+		}
+		p := m.GetData()
+		copy(p[6:12], c.Mac[:])
+		copy(p[0:6], c.DGW.IpdgMac[:])
+	}
+	o.Send(m)
+}
+
+func (o *VethIFRaw) OnRx(m *Mbuf) {
+	o.stats.RxPkts++
+	o.stats.RxBytes += uint64(m.PktLen())
+	if o.K12Monitor {
+		io.WriteString(o.monitorFile, "\n ->RX<- \n")
+		m.DumpK12(o.tctx.GetTickSimInSec(), o.monitorFile)
+	}
+	o.tctx.HandleRxPacket(m)
+}
+
+func (o *VethIFRaw) GetStats() *VethStats {
+	return &o.stats
+}
+
+// SimulatorCleanup stop the rx goroutines and close the sockets
+func (o *VethIFRaw) SimulatorCleanup() {
+	for _, m := range o.vec {
+		m.FreeMbuf()
+	}
+	o.vec = nil
+	if !atomic.CompareAndSwapInt32(&o.stop, 0, 1) {
+		return
+	}
+	/* a goroutine could be blocked on a full channel */
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-o.cn:
+		case <-done:
+			o.close()
+			return
+		}
+	}
+}
+
+func (o *VethIFRaw) SetDebug(monitor bool, monitorFile *os.File, capture bool) {
+	o.K12Monitor = monitor
+	o.monitorFile = monitorFile
+}
+
+func (o *VethIFRaw) GetCdb() *CCounterDb {
+	return o.cdb
+}
+
+func (o *VethIFRaw) SimulatorCheckRxQueue() {
+}
+
+func (o *VethIFRaw) AppendSimuationRPC(request []byte) {
+	panic("AppendSimuationRPC should not be called ")
+}
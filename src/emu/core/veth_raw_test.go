@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package core
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+type vethRawTestPkt struct {
+	pkt      []byte
+	outgoing bool
+	vlan     uint16 // tci reported by the kernel, 0 for none
+	tpid     uint16 // tpid reported by the kernel, 0 for none
+}
+
+func putHostUint32(b []byte, v uint32) {
+	*(*uint32)(unsafe.Pointer(&b[0])) = v
+}
+
+func putHostUint16(b []byte, v uint16) {
+	*(*uint16)(unsafe.Pointer(&b[0])) = v
+}
+
+// vethRawTestBlock build a TPACKET_V3 ring block the way the kernel fills it
+func vethRawTestBlock(pkts []vethRawTestPkt) []byte {
+	const mac = 80 // the frame follows the header and sockaddr_ll
+	hdr := make([]byte, VETH_RAW_BLOCK_SIZE)
+	of := uint32(48)
+	putHostUint32(hdr[12:16], uint32(len(pkts)))
+	putHostUint32(hdr[16:20], of)
+	for i, p := range pkts {
+		ph := hdr[of:]
+		next := (uint32(mac+len(p.pkt)) + 15) &^ 15
+		if i == len(pkts)-1 {
+			next = 0
+		}
+		putHostUint32(ph[0:4], next)
+		putHostUint32(ph[12:16], uint32(len(p.pkt)))
+		putHostUint16(ph[24:26], mac)
+		var status uint32
+		if p.vlan != 0 {
+			status |= tpStatusVlanValid
+			putHostUint32(ph[32:36], uint32(p.vlan))
+		}
+		if p.tpid != 0 {
+			status |= tpStatusVlanTpidValid
+			putHostUint16(ph[36:38], p.tpid)
+		}
+		putHostUint32(ph[20:24], status)
+		if p.outgoing {
+			ph[tpacket3HdrLen+10] = packetOutgoing
+		}
+		copy(ph[mac:], p.pkt)
+		of += next
+	}
+	return hdr
+}
+
+func TestVethRawBlockToStream(t *testing.T) {
+	a := vethPcapTestPkt(1)
+	b := vethPcapTestPkt(2)
+	tagged := func(pkt []byte, tag ...byte) []byte {
+		r := append([]byte{}, pkt[:12]...)
+		r = append(r, tag...)
+		return append(r, pkt[12:]...)
+	}
+
+	var tests = []struct {
+		name string
+		pkts []vethRawTestPkt
+		exp  [][]byte // nil for no stream
+	}{
+		{"one", []vethRawTestPkt{{pkt: a}}, [][]byte{a}},
+		{"two", []vethRawTestPkt{{pkt: a}, {pkt: b}}, [][]byte{a, b}},
+		{"outgoing", []vethRawTestPkt{{pkt: a, outgoing: true}, {pkt: b}}, [][]byte{b}},
+		{"only outgoing", []vethRawTestPkt{{pkt: a, outgoing: true}}, nil},
+		{"runt", []vethRawTestPkt{{pkt: a[:10]}}, nil},
+		{"vlan", []vethRawTestPkt{{pkt: a, vlan: 0x2064}}, [][]byte{tagged(a, 0x81, 0x00, 0x20, 0x64)}},
+		{"qinq tpid", []vethRawTestPkt{{pkt: b, vlan: 7, tpid: 0x88a8}}, [][]byte{tagged(b, 0x88, 0xa8, 0, 7)}},
+	}
+
+	var o VethIFRaw
+	p := &vethRawPort{vport: 3}
+	for _, tc := range tests {
+		stream := o.blockToStream(p, vethRawTestBlock(tc.pkts))
+		if tc.exp == nil {
+			if stream != nil {
+				t.Errorf("%s: expected no stream, got %v", tc.name, stream)
+			}
+			continue
+		}
+		var stats VethStats
+		var res [][]byte
+		ZmqStreamForEach(stream, &stats, func(vport uint8, pkt []byte) {
+			if vport != 3 {
+				t.Errorf("%s: invalid vport %d", tc.name, vport)
+			}
+			res = append(res, append([]byte{}, pkt...))
+		})
+		if len(res) != len(tc.exp) || stats.RxParseErr != 0 {
+			t.Errorf("%s: expected %d packets, got %d, parse errors %d", tc.name, len(tc.exp), len(res), stats.RxParseErr)
+			continue
+		}
+		for i := range res {
+			if !bytes.Equal(res[i], tc.exp[i]) {
+				t.Errorf("%s: packet %d\n got %v\n exp %v", tc.name, i, res[i], tc.exp[i])
+			}
+		}
+	}
+}
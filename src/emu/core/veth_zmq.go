@@ -60,6 +60,7 @@ type VethIFZmq struct {
 	cdb         *CCounterDb
 	buf         []byte
 	cb          VethIFCb
+	shardTx     chan []byte // in shard mode the tx stream is pushed to the thread manager
 }
 
 func (o *VethIFZmq) SetCb(cb VethIFCb) {
@@ -124,10 +125,31 @@ func (o *VethIFZmq) Create(ctx *CThreadCtx, port uint16, server string, tcp bool
 	o.cdb = NewVethStatsDb(&o.stats)
 }
 
+// CreateShard creates a veth for a worker thread of CThreadMgr. There are no zmq sockets,
+// the manager pushes rx streams to rx and the tx streams are pushed to tx, both in the zmq message format.
+func (o *VethIFZmq) CreateShard(ctx *CThreadCtx, rx chan []byte, tx chan []byte) {
+	o.buf = make([]byte, 32*1024)
+	o.cn = rx
+	o.shardTx = tx
+	o.vec = make([]*Mbuf, 0)
+	o.txVecSize = 0
+	o.tctx = ctx
+	o.cdb = NewVethStatsDb(&o.stats)
+}
+
 func (o *VethIFZmq) StartRxThread() {
+	if o.shardTx != nil {
+		return
+	}
 	go o.rxThread()
 }
 
+// SendStream send a ready tx stream, should be called only from one goroutine
+func (o *VethIFZmq) SendStream(stream []byte) {
+	o.stats.TxBatch++
+	o.txSocket.SendBytes(stream, 0)
+}
+
 func (o *VethIFZmq) rxThread() {
 
 	for {
@@ -173,6 +195,12 @@ func (o *VethIFZmq) FlushTx() {
 	}
 	o.vec = o.vec[:0]
 	o.txVecSize = 0
+	if o.shardTx != nil {
+		stream := make([]byte, len(o.buf))
+		copy(stream, o.buf)
+		o.shardTx <- stream
+		return
+	}
 	o.txSocket.SendBytes(o.buf, 0)
 }
 
@@ -250,6 +278,9 @@ func (o *VethIFZmq) SimulatorCleanup() {
 		m.FreeMbuf()
 	}
 	o.vec = nil
+	if o.shardTx != nil {
+		return
+	}
 	o.rxSocket.Close()
 	o.txSocket.Close()
 	o.rxCtx.Term()
@@ -272,49 +303,71 @@ func (o *VethIFZmq) SimulatorCheckRxQueue() {
 
 func (o *VethIFZmq) OnRxStream(stream []byte) {
 	o.stats.RxBatch++
+	ZmqStreamForEach(stream, &o.stats, func(vport uint8, pkt []byte) {
+		m := o.tctx.MPool.Alloc(uint16(len(pkt)))
+		m.SetVPort(uint16(vport))
+		m.Append(pkt)
+		o.OnRx(m)
+	})
+}
+
+// ZmqStreamForEach call cb for each packet in a zmq message, parse errors are counted in stats
+func ZmqStreamForEach(stream []byte, stats *VethStats, cb func(vport uint8, pkt []byte)) {
 	blen := uint32(len(stream))
 	if blen < 4 {
-		o.stats.RxParseErr++
+		stats.RxParseErr++
 		return
 	}
 	header := binary.BigEndian.Uint32(stream[0:4])
 	if ((header & 0xffff0000) >> 16) != ZMQ_PACKET_HEADER_MAGIC {
-		o.stats.RxParseErr++
+		stats.RxParseErr++
 		return
 	}
 	pkts := int(header & 0xffff)
-	var of uint16
+	var of uint32
 	of = 4
 	var vport uint8
-	var pktLen uint16
-	var m *Mbuf
+	var pktLen uint32
 	for i := 0; i < pkts; i++ {
-		if blen < uint32(of+4) {
-			o.stats.RxParseErr++
+		if blen < of+4 {
+			stats.RxParseErr++
 			return
 		}
 
 		header = binary.BigEndian.Uint32(stream[of : of+4])
 		if (header & 0xff000000) != 0xAA000000 {
-			o.stats.RxParseErr++
+			stats.RxParseErr++
 			return
 		}
 
 		vport = uint8((header & 0x00ff0000) >> 16)
-		pktLen = uint16((header & 0x0000ffff))
-		if blen < uint32(of+4+pktLen) {
-			o.stats.RxParseErr++
+		pktLen = header & 0x0000ffff
+		if blen < of+4+pktLen {
+			stats.RxParseErr++
 			return
 		}
 
-		m = o.tctx.MPool.Alloc(pktLen)
-		m.SetVPort(uint16(vport))
-		m.Append(stream[of+4 : of+4+pktLen])
-		o.OnRx(m)
+		cb(vport, stream[of+4:of+4+pktLen])
 		of = of + 4 + pktLen
 	}
 }
 
+// ZmqStreamAppendPkt append a packet to a zmq message, the message header should be
+// written by ZmqStreamSetHeader after the last packet.
+func ZmqStreamAppendPkt(stream []byte, vport uint8, pkt []byte) []byte {
+	var pkth [4]byte
+	pktHeader := (uint32(0xAA) << 24) + uint32(vport)<<16 + uint32(len(pkt)&0xffff)
+	binary.BigEndian.PutUint32(pkth[:], pktHeader)
+	stream = append(stream, pkth[:]...)
+	return append(stream, pkt...)
+}
+
+// ZmqStreamSetHeader write the message header of a zmq message with pkts packets
+func ZmqStreamSetHeader(stream []byte, pkts int) {
+	header := (uint32(ZMQ_PACKET_HEADER_MAGIC) << 16) + uint32(pkts)
+	binary.BigEndian.PutUint32(stream[0:4], header)
+}
+
 func (o *VethIFZmq) AppendSimuationRPC(request []byte) {
 	panic("AppendSimuationRPC should not be called ")
 }
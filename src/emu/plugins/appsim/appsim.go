@@ -21,11 +21,13 @@ for example it would be possible to simulate a HTTP/SSDP program
 */
 
 import (
+	"bytes"
 	"emu/core"
 	"encoding/base64"
 	"external/osamingo/jsonrpc"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"time"
 
 	"emu/plugins/transport"
@@ -39,13 +41,14 @@ const (
 )
 
 type AppsimRec struct {
-	Cps    float64 `json:"cps"`    // new connection per second
-	CSType string  `json:"t"`      // "c" for client and "s" for server
-	Tid    uint32  `json:"tid"`    //template id from global ns program
-	Ipv6   bool    `json:"ipv6"`   //is ipv6
-	Stream bool    `json:"stream"` //udp or tcp
-	DestIP string  `json:"dst"`    //dest ip either ipv4 or ipv6 (base on ipv6 value)
-	Limit  uint32  `json:"limit"`  //limit the number of new flows. zero means unlimited
+	Cps    float64           `json:"cps"`    // new connection per second
+	CSType string            `json:"t"`      // "c" for client and "s" for server
+	Tid    uint32            `json:"tid"`    //template id from global ns program
+	Ipv6   bool              `json:"ipv6"`   //is ipv6
+	Stream bool              `json:"stream"` //udp or tcp
+	DestIP string            `json:"dst"`    //dest ip either ipv4 or ipv6 (base on ipv6 value)
+	Limit  uint32            `json:"limit"`  //limit the number of new flows. zero means unlimited
+	Tls    *transport.TlsCfg `json:"tls"`    //tls stream over tcp, the server requires cert and key
 }
 
 type AppsimInit struct {
@@ -60,6 +63,7 @@ type appsimStream struct {
 	stream   bool
 	dst      string
 	limit    uint32
+	tls      *transport.TlsCfg
 
 	timerw *core.TimerCtx
 	plug   *PluginAppsimClient
@@ -111,7 +115,13 @@ func (o *appsimStream) createNewFlow() {
 	sim.is_client = true
 	d := o.dst
 	fmt.Printf(" %+v \n", ioctl)
-	ap, err := sim.ctx.Dial(net, d, app.getCb(), ioctl, nil, 0)
+	var ap transport.SocketApi
+	var err error
+	if o.stream && o.tls != nil {
+		ap, err = sim.ctx.DialTls(d, app.getCb(), ioctl, nil, 0, o.tls)
+	} else {
+		ap, err = sim.ctx.Dial(net, d, app.getCb(), ioctl, nil, 0)
+	}
 	if err != nil {
 		fmt.Printf(" error dial %v \n ", err)
 		o.plug.stats.eventDropConnectionErr++
@@ -167,6 +177,11 @@ type AppsimStats struct {
 	eventDelFlow           uint64
 	eventInvalidApp        uint64
 	eventInvalidtid        uint64
+	eventInvalidTls        uint64
+	eventRxOverflow        uint64
+	eventJmpMatch          uint64
+	eventCapture           uint64
+	eventCaptureMiss       uint64
 }
 
 func NewAppSimStatsDb(o *AppsimStats) *core.CCounterDb {
@@ -180,6 +195,14 @@ func NewAppSimStatsDb(o *AppsimStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eventInvalidTls,
+		Name:     "eventInvalidTls",
+		Help:     "invalid tls in client json ",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.eventInvalidApp,
 		Name:     "eventInvalidApp",
@@ -252,10 +275,46 @@ func NewAppSimStatsDb(o *AppsimStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eventRxOverflow,
+		Name:     "rxOverflow",
+		Help:     "rx_until without a match in max bytes, the flow is closed ",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eventJmpMatch,
+		Name:     "jmpMatch",
+		Help:     "jmp_match taken ",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eventCapture,
+		Name:     "capture",
+		Help:     "captured variables ",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eventCaptureMiss,
+		Name:     "captureMiss",
+		Help:     "capture without a match, the variable is not changed ",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
-const apVAR_NUM_SIZE = 2
+const (
+	apVAR_NUM_SIZE = 2
+	apRX_BUF_MAX   = 65536       // the received data that is kept for the content commands
+	apREGEX_KEY    = "regex_obj" // the compiled regex of a command, set when the program is loaded
+)
 
 type tcp_app_state int
 
@@ -293,6 +352,9 @@ const (
 	taTIMER_INIT_WAS_DONE = 0x4
 	taDO_RX_CLEAR         = 0x400
 	taLOG_ENABLE          = 0x800
+	taRX_CONTENT          = 0x1000 /* the program looks at the received data */
+	taRX_UNTIL            = 0x2000 /* wait for a delimiter instead of a number of bytes */
+	taJMP                 = 0x4000 /* the command index was set by a jump */
 )
 
 type iAppL7SimCb interface {
@@ -326,6 +388,13 @@ type appL7Sim struct {
 	timerCb            UDPKeepAliveTimer
 	udp_keepalive      bool
 	udp_keepalive_msec uint32
+	rx_buf             []byte            // received data that was not consumed yet, up to apRX_BUF_MAX
+	rx_msg             []byte            // the data consumed by the last rx command
+	rx_delim           []byte            // rx_until delimiter
+	rx_re              *regexp.Regexp    // rx_until regex
+	rx_max             int               // rx_until max bytes
+	cvars              map[string][]byte // captured variables, ${name} in the tx buffers
+	cvar_names         []string
 }
 
 type UDPKeepAliveTimer struct {
@@ -426,6 +495,12 @@ func (o *appL7Sim) start() {
 	pl := (o.program)["program_list"].([]interface{})
 	cmds := pl[pindex].(map[string]interface{})
 	o.cmda = cmds["commands"].([]interface{})
+	for _, c := range o.cmda {
+		switch c.(map[string]interface{})["name"].(string) {
+		case "rx_until", "jmp_match", "capture":
+			o.flags |= taRX_CONTENT
+		}
+	}
 	if o.socket != nil {
 		o.onNewSocket()
 	}
@@ -454,6 +529,15 @@ func (o *appL7Sim) getBuffer(bufIndex uint32) []byte {
 	return o.bufferList[bufIndex]
 }
 
+// getTxBuffer the buffer with the captured variables substituted
+func (o *appL7Sim) getTxBuffer(bufIndex uint32) []byte {
+	b := o.getBuffer(bufIndex)
+	for _, name := range o.cvar_names {
+		b = bytes.Replace(b, []byte("${"+name+"}"), o.cvars[name], -1)
+	}
+	return b
+}
+
 func (o *appL7Sim) processDelayRand(min_usec float64, max_usec float64) {
 	var choosen float64
 	if max_usec <= min_usec {
@@ -486,24 +570,167 @@ func (o *appL7Sim) processDelay(usec float64) {
 
 // next command
 func (o *appL7Sim) checkRxCondition() bool {
+	if o.flags&taRX_UNTIL > 0 {
+		return o.checkRxUntil()
+	}
 
 	if o.cmd_rx_bytes >= o.cmd_rx_bytes_wm {
+		o.rxConsume(int(o.cmd_rx_bytes_wm))
 		o.cmd_rx_bytes -= o.cmd_rx_bytes_wm
-		if o.flags&taDO_RX_CLEAR > 0 {
-			o.cmd_rx_bytes = 0
-			o.flags &= (^(uint16)(taDO_RX_CLEAR))
-		}
+		o.checkRxClear()
 		return true
 	}
 	return false
 }
 
+func (o *appL7Sim) checkRxClear() {
+	if o.flags&taDO_RX_CLEAR > 0 {
+		o.cmd_rx_bytes = 0
+		o.rx_buf = o.rx_buf[:0]
+		o.flags &= (^(uint16)(taDO_RX_CLEAR))
+	}
+}
+
+// rxAppend keeps the received data for the content commands
+func (o *appL7Sim) rxAppend(d []byte) {
+	room := apRX_BUF_MAX - len(o.rx_buf)
+	if len(d) > room {
+		d = d[:room]
+	}
+	o.rx_buf = append(o.rx_buf, d...)
+}
+
+// rxConsume moves the first n bytes of the received data to the last message, for datagrams all of it
+func (o *appL7Sim) rxConsume(n int) {
+	if o.flags&taRX_CONTENT == 0 {
+		return
+	}
+	if !o.isStream() || n > len(o.rx_buf) {
+		n = len(o.rx_buf)
+	}
+	o.rx_msg = append(o.rx_msg[:0], o.rx_buf[:n]...)
+	o.rx_buf = o.rx_buf[:copy(o.rx_buf, o.rx_buf[n:])]
+}
+
+// checkRxUntil the received data up to the end of the delimiter is consumed
+func (o *appL7Sim) checkRxUntil() bool {
+	end := -1
+	if o.rx_re != nil {
+		if loc := o.rx_re.FindIndex(o.rx_buf); loc != nil {
+			end = loc[1]
+		}
+	} else if i := bytes.Index(o.rx_buf, o.rx_delim); i >= 0 {
+		end = i + len(o.rx_delim)
+	}
+
+	if end < 0 {
+		if len(o.rx_buf) >= o.rx_max {
+			if o.isLog() {
+				fmt.Printf(" client :(%v) rx_until overflow %v \n", o.is_client, len(o.rx_buf))
+			}
+			o.stat.eventRxOverflow++
+			o.changeState(te_CLOSED)
+			o.socket.Close()
+		}
+		return false
+	}
+
+	o.flags &= (^(uint16)(taRX_UNTIL))
+	o.rxConsume(end)
+	if o.isStream() {
+		o.cmd_rx_bytes -= uint64(end)
+	} else {
+		o.cmd_rx_bytes = 0
+	}
+	o.checkRxClear()
+	return true
+}
+
+func (o *appL7Sim) processRxUntil(cmd map[string]interface{}) bool {
+	o.rx_re = nil
+	o.rx_delim = nil
+	if val, ok := cmd[apREGEX_KEY]; ok {
+		o.rx_re = val.(*regexp.Regexp)
+	} else {
+		o.rx_delim = []byte(cmd["delim"].(string))
+	}
+	o.rx_max = apRX_BUF_MAX
+	if val, ok := cmd["max_bytes"]; ok {
+		o.rx_max = int(val.(float64))
+	}
+	o.flags &= (^(uint16)(taDO_RX_CLEAR))
+	if val, ok := cmd["clear"]; ok && val.(bool) {
+		o.flags |= taDO_RX_CLEAR
+	}
+	o.flags |= taRX_UNTIL
+	o.changeState(te_WAIT_RX)
+	return o.checkRxCondition()
+}
+
+// rxStatus the status code of a status line like "HTTP/1.1 200 OK" in the last message, zero if there is none
+func rxStatus(b []byte) int {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	i := bytes.IndexByte(b, ' ')
+	if i < 0 || len(b) < i+4 {
+		return 0
+	}
+	code := 0
+	for _, c := range b[i+1 : i+4] {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		code = code*10 + int(c-'0')
+	}
+	if len(b) > i+4 && b[i+4] != ' ' && b[i+4] != '\r' {
+		return 0
+	}
+	return code
+}
+
+// isMatch the condition of jmp_match on the last message
+func (o *appL7Sim) isMatch(cmd map[string]interface{}) bool {
+	if val, ok := cmd[apREGEX_KEY]; ok {
+		return val.(*regexp.Regexp).Match(o.rx_msg)
+	}
+	if val, ok := cmd["pattern"]; ok {
+		return bytes.Contains(o.rx_msg, []byte(val.(string)))
+	}
+	return rxStatus(o.rx_msg) == int(cmd["status"].(float64))
+}
+
+// capture the first group of the regex in the last message, or all of the match without a group
+func (o *appL7Sim) capture(name string, re *regexp.Regexp) {
+	m := re.FindSubmatch(o.rx_msg)
+	if m == nil {
+		o.stat.eventCaptureMiss++
+		return
+	}
+	v := m[0]
+	if len(m) > 1 {
+		v = m[1]
+	}
+	if o.cvars == nil {
+		o.cvars = make(map[string][]byte)
+	}
+	if _, ok := o.cvars[name]; !ok {
+		o.cvar_names = append(o.cvar_names, name)
+	}
+	o.cvars[name] = append([]byte(nil), v...)
+	o.stat.eventCapture++
+	if o.isLog() {
+		fmt.Printf(" client :(%v) capture %v=%q \n", o.is_client, name, v)
+	}
+}
+
 func (o *appL7Sim) processRx(min_bytes uint64, clear bool) bool {
 	if clear {
 		o.flags |= taDO_RX_CLEAR
 	} else {
 		o.flags &= (^(uint16)(taDO_RX_CLEAR))
 	}
+	o.flags &= (^(uint16)(taRX_UNTIL))
 	o.changeState(te_WAIT_RX)
 	o.cmd_rx_bytes_wm = min_bytes
 
@@ -525,7 +752,7 @@ func (o *appL7Sim) processOneCmd(cmd map[string]interface{}) bool {
 	switch cmd_name {
 	case "tx":
 		bi := uint32(cmd["buf_index"].(float64))
-		b := o.getBuffer(bi)
+		b := o.getTxBuffer(bi)
 		o.stat.BytesTx += uint64(len(b))
 		o.stat.eventTx += 1
 		r, queued := o.socket.Write(b)
@@ -578,7 +805,7 @@ func (o *appL7Sim) processOneCmd(cmd map[string]interface{}) bool {
 	case "tx_msg":
 		o.state = te_NONE
 		bi := uint32(cmd["buf_index"].(float64))
-		b := o.getBuffer(bi)
+		b := o.getTxBuffer(bi)
 		if !o.isStream() {
 			o.stat.BytesTx += uint64(len(b))
 			o.stat.eventTx += 1
@@ -649,6 +876,24 @@ func (o *appL7Sim) processOneCmd(cmd map[string]interface{}) bool {
 		return true
 		break
 
+	case "rx_until":
+		if o.processRxUntil(cmd) {
+			return true
+		}
+
+	case "jmp_match":
+		if o.isMatch(cmd) {
+			o.stat.eventJmpMatch++
+			o.cmd_index = uint32(int64(o.cmd_index) + int64(cmd["offset"].(float64)))
+			o.flags |= taJMP
+			o.checkCmdIndexOverflow()
+		}
+		return true
+
+	case "capture":
+		o.capture(cmd["var"].(string), cmd[apREGEX_KEY].(*regexp.Regexp))
+		return true
+
 	case "jmp_dp":
 		break
 
@@ -716,7 +961,9 @@ func (o *appL7Sim) checkLastCmd() bool {
 
 // return in case we finished
 func (o *appL7Sim) nextCmd() bool {
-	if !o.isLastCommand() {
+	if o.flags&taJMP > 0 {
+		o.flags &= (^(uint16)(taJMP))
+	} else if !o.isLastCommand() {
 		o.cmd_index++
 	}
 
@@ -789,6 +1036,9 @@ func (o *appL7Sim) OnRxData(d []byte) {
 	} else {
 		o.cmd_rx_bytes += 1
 	}
+	if o.flags&taRX_CONTENT > 0 {
+		o.rxAppend(d)
+	}
 
 	if o.state == te_WAIT_RX {
 		if o.checkRxCondition() {
@@ -911,6 +1161,7 @@ func (o *PluginAppsimClient) createStream(v *AppsimRec) *appsimStream {
 	obj.stream = v.Stream
 	obj.dst = v.DestIP
 	obj.limit = v.Limit
+	obj.tls = v.Tls
 	obj.plug = o
 
 	program := o.getGlobalProgram()
@@ -952,7 +1203,14 @@ func (o *PluginAppsimClient) createStream(v *AppsimRec) *appsimStream {
 
 	sim.is_client = false
 	sport := getServerPort(obj.tid, program)
-	sim.ctx.Listen(net, sport, app.getServerAcceptCb())
+	if obj.stream && obj.tls != nil {
+		if err := sim.ctx.ListenTls(sport, app.getServerAcceptCb(), obj.tls); err != nil {
+			o.stats.eventInvalidTls++
+			return nil
+		}
+	} else {
+		sim.ctx.Listen(net, sport, app.getServerAcceptCb())
+	}
 	if ioctl != nil {
 		sim.ioctl = ioctl // save it for the callback
 	}
@@ -1013,6 +1271,11 @@ func (o *PluginAppsimClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginAppsimClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginAppsimClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the ink to the client */
 	ctx.UnregisterEvents(&o.PluginBase, appsimEvents)
@@ -1063,6 +1326,11 @@ func NewAppSimNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginAppsimNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginAppsimNs) OnRemove(ctx *core.PluginCtx) {
 }
 
@@ -1091,8 +1359,9 @@ func (o PluginAppsimNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core
 /*******************************************/
 /*  RPC commands */
 type (
-	ApiAppsimClientCntHandler struct{}
-	ApiAppsimNsCntHandler     struct{}
+	ApiAppsimClientCntHandler  struct{}
+	ApiAppsimNsCntHandler      struct{}
+	ApiAppsimPcapImportHandler struct{}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginAppsimNs, *jsonrpc.Error) {
@@ -1153,6 +1422,27 @@ func (h ApiAppsimNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.Ra
 	return ns.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+// ServeJSONRPC convert a pcap file of the server to an appsim program
+func (h ApiAppsimPcapImportHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p AppsimPcapParams
+	tctx := ctx.(*core.CThreadCtx)
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res, err := PcapFileToAppsim(&p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return res, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -1176,8 +1466,9 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("appsim_client_cnt", ApiAppsimClientCntHandler{}, false) // get counters/meta
-	core.RegisterCB("appsim_ns_cnt", ApiAppsimClientCntHandler{}, false)     // get counters/meta
+	core.RegisterCB("appsim_client_cnt", ApiAppsimClientCntHandler{}, false)     // get counters/meta
+	core.RegisterCB("appsim_ns_cnt", ApiAppsimClientCntHandler{}, false)         // get counters/meta
+	core.RegisterCB("appsim_t_pcap_import", ApiAppsimPcapImportHandler{}, false) // pcap of a flow to a program
 
 }
 
@@ -121,7 +121,11 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool,
 	if server {
 
 		o.is_client = false
-		o.ctx.Listen(net, ":80", app.getServerAcceptCb())
+		if params.tlss != nil {
+			o.ctx.ListenTls(":80", app.getServerAcceptCb(), params.tlss)
+		} else {
+			o.ctx.Listen(net, ":80", app.getServerAcceptCb())
+		}
 		if ioctl != nil {
 			o.ioctl = ioctl // save it for the callback
 		}
@@ -131,7 +135,13 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool,
 		if params.ipv6 {
 			d = "[2001:db8::3000:1]:80"
 		}
-		ap, err := o.ctx.Dial(net, d, app.getCb(), ioctl, nil,0)
+		var ap transport.SocketApi
+		var err error
+		if params.tlsc != nil {
+			ap, err = o.ctx.DialTls(d, app.getCb(), ioctl, nil, 0, params.tlsc)
+		} else {
+			ap, err = o.ctx.Dial(net, d, app.getCb(), ioctl, nil, 0)
+		}
 		if err != nil {
 			fmt.Printf(" ERROR %v \n", err)
 			return nil
@@ -149,6 +159,8 @@ type transportSimParam struct {
 	ipv6         bool
 	udp          bool
 	program_json string
+	tlsc         *transport.TlsCfg // client over tls
+	tlss         *transport.TlsCfg // server over tls
 }
 
 type transportSim struct {
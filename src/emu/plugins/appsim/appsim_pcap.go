@@ -0,0 +1,268 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package appsim
+
+/*
+Convert a pcap of one TCP or UDP flow to an appsim program, like the pcap mode of the TRex ASTF profiles.
+
+The first flow of the pcap is taken, the packets of other flows are ignored. The client is the side that sent the
+SYN (the first packet for UDP). The TCP payload of each direction is reassembled by the sequence numbers, the
+retransmissions are dropped. Each run of data in one direction is a message, a tx in one side and an rx of its
+size in the other side. Each UDP datagram is a message, tx_msg and rx_msg of one packet.
+*/
+
+import (
+	"emu/core"
+	"emu/plugins/transport"
+	"encoding/base64"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+	"io"
+	"os"
+)
+
+// AppsimPcapParams the pcap to convert and the parameters of the template
+type AppsimPcapParams struct {
+	File string  `json:"file" validate:"required"`
+	Cps  float64 `json:"cps"`  // new flows per second of the client template, default 1
+	Port uint16  `json:"port"` // the server port, default is the port of the flow
+}
+
+// AppsimPcapProgram the namespace init json of appsim and the parameters of the client streams
+type AppsimPcapProgram struct {
+	Program map[string]interface{} `json:"program"`
+	Stream  bool                   `json:"stream"` // TCP, false for UDP
+	Ipv6    bool                   `json:"ipv6"`
+	Port    uint16                 `json:"port"`
+	Msgs    int                    `json:"msgs"`  // messages in the flow
+	Bytes   uint64                 `json:"bytes"` // payload bytes of the flow
+}
+
+type pcapMsg struct {
+	client bool
+	data   []byte
+}
+
+// pcapTcpDir reassembly of one direction of a TCP flow
+type pcapTcpDir struct {
+	init    bool
+	next    uint32
+	pending map[uint32][]byte // out of order segments by their sequence
+}
+
+// push a segment, returns the new in order data
+func (o *pcapTcpDir) push(seq uint32, syn bool, payload []byte) []byte {
+	if syn {
+		o.init = true
+		o.next = seq + 1
+		return nil
+	}
+	if !o.init {
+		o.init = true
+		o.next = seq
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	off := int32(o.next - seq)
+	if off < 0 {
+		if o.pending == nil {
+			o.pending = make(map[uint32][]byte)
+		}
+		o.pending[seq] = append([]byte(nil), payload...)
+		return nil
+	}
+	if int(off) >= len(payload) {
+		return nil // retransmission
+	}
+	out := append([]byte(nil), payload[off:]...)
+	o.next += uint32(len(out))
+
+	for found := true; found; {
+		found = false
+		for s, d := range o.pending {
+			off := int32(o.next - s)
+			if off < 0 {
+				continue
+			}
+			delete(o.pending, s)
+			if int(off) < len(d) {
+				out = append(out, d[off:]...)
+				o.next += uint32(len(d) - int(off))
+			}
+			found = true
+		}
+	}
+	return out
+}
+
+// pcapFlow the messages of the first flow of the pcap
+type pcapFlow struct {
+	init   bool
+	stream bool
+	ipv6   bool
+	client gopacket.Endpoint // the network address of the client
+	server gopacket.Endpoint
+	cport  uint16
+	sport  uint16
+	dir    [2]pcapTcpDir // client, server
+	msgs   []pcapMsg
+}
+
+func (o *pcapFlow) add(client bool, d []byte) {
+	if len(d) == 0 {
+		return
+	}
+	n := len(o.msgs)
+	if o.stream && n > 0 && o.msgs[n-1].client == client {
+		o.msgs[n-1].data = append(o.msgs[n-1].data, d...)
+		return
+	}
+	o.msgs = append(o.msgs, pcapMsg{client: client, data: d})
+}
+
+func (o *pcapFlow) onPacket(p gopacket.Packet) {
+	nl := p.NetworkLayer()
+	if nl == nil {
+		return
+	}
+	src, dst := nl.NetworkFlow().Endpoints()
+	var sport, dport uint16
+	var tcp *layers.TCP
+	var payload []byte
+	stream := false
+	if l, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+		tcp, stream = l, true
+		sport, dport, payload = uint16(l.SrcPort), uint16(l.DstPort), l.Payload
+	} else if l, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+		sport, dport, payload = uint16(l.SrcPort), uint16(l.DstPort), l.Payload
+	} else {
+		return
+	}
+
+	if !o.init {
+		o.init = true
+		o.stream = stream
+		o.ipv6 = nl.LayerType() == layers.LayerTypeIPv6
+		o.client, o.server, o.cport, o.sport = src, dst, sport, dport
+		if tcp != nil && tcp.Flags&(transport.TH_SYN|transport.TH_ACK) == transport.TH_SYN|transport.TH_ACK {
+			// the capture started after the SYN
+			o.client, o.server, o.cport, o.sport = dst, src, dport, sport
+		}
+	}
+	if stream != o.stream {
+		return
+	}
+
+	var client bool
+	if src == o.client && dst == o.server && sport == o.cport && dport == o.sport {
+		client = true
+	} else if src != o.server || dst != o.client || sport != o.sport || dport != o.cport {
+		return
+	}
+
+	if tcp == nil {
+		o.add(client, append([]byte(nil), payload...))
+		return
+	}
+	i := 1
+	if client {
+		i = 0
+	}
+	o.add(client, o.dir[i].push(tcp.Seq, tcp.Flags&transport.TH_SYN != 0, payload))
+}
+
+// program the appsim program of the messages, program 0 is the client and 1 the server
+func (o *pcapFlow) program(p *AppsimPcapParams) *AppsimPcapProgram {
+	var bufs []interface{}
+	var c, s []interface{}
+	var bytes uint64
+	for _, m := range o.msgs {
+		bi := len(bufs)
+		bufs = append(bufs, base64.StdEncoding.EncodeToString(m.data))
+		bytes += uint64(len(m.data))
+		var tx, rx map[string]interface{}
+		if o.stream {
+			tx = map[string]interface{}{"name": "tx", "buf_index": bi}
+			rx = map[string]interface{}{"name": "rx", "min_bytes": len(m.data)}
+		} else {
+			tx = map[string]interface{}{"name": "tx_msg", "buf_index": bi}
+			rx = map[string]interface{}{"name": "rx_msg", "min_pkts": 1}
+		}
+		if m.client {
+			c, s = append(c, tx), append(s, rx)
+		} else {
+			c, s = append(c, rx), append(s, tx)
+		}
+	}
+
+	port := o.sport
+	if p.Port != 0 {
+		port = p.Port
+	}
+	cps := p.Cps
+	if cps == 0 {
+		cps = 1
+	}
+	prog := map[string]interface{}{
+		"buf_list": bufs,
+		"program_list": []interface{}{
+			map[string]interface{}{"commands": c},
+			map[string]interface{}{"commands": s},
+		},
+		"templates": []interface{}{
+			map[string]interface{}{
+				"client_template": map[string]interface{}{"program_index": 0, "port": port, "cps": cps},
+				"server_template": map[string]interface{}{"program_index": 1,
+					"assoc": []interface{}{map[string]interface{}{"port": port}}},
+			},
+		},
+	}
+	return &AppsimPcapProgram{Program: prog, Stream: o.stream, Ipv6: o.ipv6, Port: port,
+		Msgs: len(o.msgs), Bytes: bytes}
+}
+
+// PcapToAppsim convert the first TCP or UDP flow of a pcap to an appsim program
+func PcapToAppsim(r io.Reader, p *AppsimPcapParams) (*AppsimPcapProgram, error) {
+	pr, err := core.OpenPcapReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var flow pcapFlow
+	for {
+		data, _, err := pr.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		flow.onPacket(gopacket.NewPacket(data, pr.LinkType(), gopacket.NoCopy))
+	}
+	if !flow.init {
+		return nil, fmt.Errorf("there is no TCP or UDP flow in the pcap")
+	}
+	if len(flow.msgs) == 0 {
+		return nil, fmt.Errorf("there is no payload in the flow")
+	}
+	for i := range flow.dir {
+		if len(flow.dir[i].pending) > 0 {
+			return nil, fmt.Errorf("missing TCP data at %v of the %v", flow.dir[i].next, []string{"client", "server"}[i])
+		}
+	}
+	return flow.program(p), nil
+}
+
+// PcapFileToAppsim convert the first flow of the pcap file
+func PcapFileToAppsim(p *AppsimPcapParams) (*AppsimPcapProgram, error) {
+	f, err := os.Open(p.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return PcapToAppsim(f, p)
+}
@@ -8,6 +8,7 @@ package appsim
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
 
 	"github.com/intel-go/fastjson"
 	"github.com/xeipuuv/gojsonschema"
@@ -265,6 +266,98 @@ const schema string = `{
           "required": ["name", "id", "offset", "duration"]
      },
 
+       "program_command_rx_until_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["rx_until"]
+                   },
+
+                   "delim" : {
+                       "type" : "string",
+                       "minLength": 1
+                   },
+
+                   "regex" : {
+                       "type" : "string",
+                       "minLength": 1
+                   },
+
+                   "max_bytes" : {
+                       "type" : "integer",
+                       "minimum": 1,
+                       "maximum": 65536
+                   },
+
+                   "clear" : {
+                       "type" : "boolean"
+                   }
+             },
+             "required": ["name"],
+             "oneOf": [
+                 {"required": ["delim"]},
+                 {"required": ["regex"]}
+             ]
+        },
+
+       "program_command_jmp_match_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["jmp_match"]
+                   },
+
+                   "pattern" : {
+                       "type" : "string",
+                       "minLength": 1
+                   },
+
+                   "regex" : {
+                       "type" : "string",
+                       "minLength": 1
+                   },
+
+                   "status" : {
+                       "type" : "integer",
+                       "minimum": 100,
+                       "maximum": 999
+                   },
+
+                   "offset" : {
+                       "type" : "integer"
+                   }
+             },
+             "required": ["name","offset"],
+             "oneOf": [
+                 {"required": ["pattern"]},
+                 {"required": ["regex"]},
+                 {"required": ["status"]}
+             ]
+        },
+
+       "program_command_capture_t" : {
+            "type": "object",
+             "properties": {
+                   "name": {
+                       "type" : "string",
+                       "enum" : ["capture"]
+                   },
+
+                   "var" : {
+                       "type" : "string",
+                       "pattern": "^[A-Za-z_][A-Za-z0-9_]*$"
+                   },
+
+                   "regex" : {
+                       "type" : "string",
+                       "minLength": 1
+                   }
+             },
+             "required": ["name","var","regex"]
+        },
+
         "program_command_tx_mode_t" : {
             "type": "object",
              "properties": {
@@ -302,7 +395,10 @@ const schema string = `{
                                  {"$ref": "#/definitions/program_command_set_tick_var_t"},
                                  {"$ref": "#/definitions/program_command_jmpnz_t"},
                                  {"$ref": "#/definitions/program_command_jmpdp_t"},
-                                 {"$ref": "#/definitions/program_command_tx_mode_t"}
+                                 {"$ref": "#/definitions/program_command_tx_mode_t"},
+                                 {"$ref": "#/definitions/program_command_rx_until_t"},
+                                 {"$ref": "#/definitions/program_command_jmp_match_t"},
+                                 {"$ref": "#/definitions/program_command_capture_t"}
                                  
                                  ]
                              },
@@ -458,7 +554,7 @@ func validateAppJsonJson(o map[string]interface{}) error {
 	for _, o := range pl {
 		c1 := o.(map[string]interface{})
 		cmds := c1["commands"].([]interface{})
-		for _, c := range cmds {
+		for i, c := range cmds {
 			c2 := c.(map[string]interface{})
 			name := c2["name"].(string)
 			if name == "tx" || name == "tx_msg" {
@@ -468,6 +564,19 @@ func validateAppJsonJson(o map[string]interface{}) error {
 					return err
 				}
 			}
+			if val, ok := c2["regex"]; ok {
+				// compiled once, the flows of the program share it
+				re, err := regexp.Compile(val.(string))
+				if err != nil {
+					return fmt.Errorf("invalid regex in %v: %v", name, err)
+				}
+				c2[apREGEX_KEY] = re
+			}
+			if name == "jmp_match" {
+				if err := validateJmpMatch(cmds, i, int(c2["offset"].(float64))); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
@@ -511,6 +620,24 @@ func validateAppJsonJson(o map[string]interface{}) error {
 	return nil
 }
 
+// validateJmpMatch the target is in the program, a loop should wait for rx or a delay
+func validateJmpMatch(cmds []interface{}, index int, offset int) error {
+	target := index + offset
+	if offset == 0 || target < 0 || target > len(cmds) {
+		return fmt.Errorf("jmp_match offset %v of command %v is out of the program", offset, index)
+	}
+	if offset > 0 {
+		return nil
+	}
+	for _, c := range cmds[target:index] {
+		switch c.(map[string]interface{})["name"].(string) {
+		case "rx", "rx_msg", "rx_until", "delay", "delay_rnd":
+			return nil
+		}
+	}
+	return fmt.Errorf("jmp_match loop of command %v does not wait for rx or a delay", index)
+}
+
 var schemaLoader gojsonschema.JSONLoader = nil
 
 func IsValidAppSimJson(raw *fastjson.RawMessage, out *map[string]interface{}) error {
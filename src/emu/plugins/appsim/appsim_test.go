@@ -6,12 +6,21 @@
 package appsim
 
 import (
+	"bytes"
 	"emu/core"
+	"emu/core/testpki"
+	"emu/plugins/transport"
 	"encoding/base64"
+	"encoding/json"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/google/gopacket/pcapgo"
 	"flag"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -283,6 +292,7 @@ type AppL7SimTestBase struct {
 	cbArg1 interface{}
 	cbArg2 interface{}
 	param  transportSimParam
+	check  func(t *testing.T, sim *transportSim) // verify the sim before the compare
 }
 
 // run one flow (client/server) simulation
@@ -303,6 +313,9 @@ func (o *AppL7SimTestBase) Run(t *testing.T, compare bool) {
 	sim.tctx.MainLoopSim(o.duration)
 
 	defer sim.tctx.Delete()
+	if o.check != nil {
+		o.check(t, sim)
+	}
 	sim.tctx.SimRecordCompare(o.testname, t)
 }
 
@@ -1260,6 +1273,323 @@ func TestPluginAppSim36(t *testing.T) {
 	a.Run(t)
 }
 
+// self signed Ed25519 certificate of the server, the client does not verify it
+func appsimTlsCfg() (*transport.TlsCfg, *transport.TlsCfg) {
+	c, key := testpki.SelfSigned("www.emu.test")
+	cert := string(testpki.CertPem(c))
+	pkey := string(testpki.KeyPem(key))
+	sni := "www.emu.test"
+	return &transport.TlsCfg{ServerName: &sni, Alpn: []string{"http/1.1"}},
+		&transport.TlsCfg{Alpn: []string{"http/1.1"}, Cert: &cert, Key: &pkey}
+}
+
+// TCP program over TLS
+func TestPluginAppSim37(t *testing.T) {
+	c, s := appsimTlsCfg()
+	a := &AppL7SimTestBase{
+		testname:     "appsim-37",
+		monitor:      false,
+		match:        0,
+		capture:      false,
+		duration:     20 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:         "a",
+			ipv6:         false,
+			program_json: input_json3,
+			tlsc:         c,
+			tlss:         s,
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		cs := sim.client.stas
+		ss := sim.server.stas
+		if cs.BytesTx != 249 || ss.BytesRx != 249 || cs.BytesRx != ss.BytesTx || cs.BytesRx == 0 {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+	}
+	a.Run(t, false)
+}
+
+func TestPluginAppSim38(t *testing.T) {
+	var init AppsimInit
+	j := `{"data": {"s1": {"cps": 1, "t": "c", "tid": 0, "stream": true, "dst": "48.0.0.1:443", "tls": {"sni": "www.emu.test", "alpn": ["h2"], "max_version": "1.2"}}}}`
+	if err := fastjson.Unmarshal([]byte(j), &init); err != nil {
+		t.Fatalf(" %v", err)
+	}
+	tls := init.Data["s1"].Tls
+	if tls == nil || *tls.ServerName != "www.emu.test" || tls.Alpn[0] != "h2" || *tls.MaxVersion != "1.2" {
+		t.Fatalf(" unexpected tls %+v", tls)
+	}
+}
+
+// a login that returns a session cookie, the next request sends it back and the server answers by its content
+const input_json39 string = `
+{
+    "buf_list": [
+        "R0VUIC9sb2dpbiBIVFRQLzEuMQ0KSG9zdDogd3d3LmVtdS50ZXN0DQoNCg==",
+        "R0VUIC9kYXRhIEhUVFAvMS4xDQpIb3N0OiB3d3cuZW11LnRlc3QNCkNvb2tpZTogc2lkPSR7c2lkfQ0KDQo=",
+        "SFRUUC8xLjEgMjAwIE9LDQpTZXQtQ29va2llOiBzaWQ9YTFiMmMzOyBQYXRoPS8NCkNvbnRlbnQtTGVuZ3RoOiA1DQoNCmhlbGxv",
+        "SFRUUC8xLjEgNDAzIEZvcmJpZGRlbg0KQ29udGVudC1MZW5ndGg6IDANCg0K"
+    ],
+    "program_list": [
+        {
+            "commands": [
+                {"name": "tx", "buf_index": 0},
+                {"name": "rx_until", "delim": "\r\n\r\n"},
+                {"name": "capture", "var": "sid", "regex": "Set-Cookie: sid=([^;\r\n]+)"},
+                {"name": "jmp_match", "status": 403, "offset": 4},
+                {"name": "rx", "min_bytes": 5},
+                {"name": "tx", "buf_index": 1},
+                {"name": "rx_until", "regex": "Content-Length: \\d+\r\n\r\n"}
+            ]
+        },
+        {
+            "commands": [
+                {"name": "rx_until", "delim": "\r\n\r\n"},
+                {"name": "jmp_match", "pattern": "Cookie: sid=a1b2c3", "offset": 3},
+                {"name": "tx", "buf_index": 2},
+                {"name": "jmp_match", "regex": "^GET ", "offset": -3},
+                {"name": "tx", "buf_index": 3}
+            ]
+        }
+    ],
+
+    "templates": [{
+        "client_template" :{"program_index": 0,
+                "port": 80,
+                "cps": 1
+              },
+        "server_template" : {"assoc": [
+                    {
+                        "port": 80
+                    }
+                ],
+                "program_index": 1
+                }
+    }]
+}
+`
+
+func TestPluginAppSim39(t *testing.T) {
+	a := &AppL7SimTestBase{
+		testname:     "appsim-39",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:         "a",
+			ipv6:         false,
+			program_json: input_json39,
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		cs := sim.client.stas
+		ss := sim.server.stas
+		c := &sim.clientApp.(*socketAppL7).appl7
+		s := &sim.serverApp.(*socketAppL7).appl7
+		if string(c.cvars["sid"]) != "a1b2c3" || cs.eventCapture != 1 || ss.eventJmpMatch != 2 {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+		if cs.BytesTx != 105 || cs.BytesRx != 120 || rxStatus(c.rx_msg) != 403 || !bytes.Contains(s.rx_msg, []byte("sid=a1b2c3")) {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+	}
+	a.Run(t, false)
+}
+
+func TestPluginAppSim40(t *testing.T) {
+	prog := func(cmds string) string {
+		return `{"buf_list": [], "program_list": [{"commands": [` + cmds + `]}],
+			"templates": [{"client_template": {"program_index": 0, "port": 80, "cps": 1},
+			"server_template": {"assoc": [{"port": 80}], "program_index": 0}}]}`
+	}
+	invalid := []string{
+		`{"name": "rx_until"}`,
+		`{"name": "rx_until", "regex": "(a"}`,
+		`{"name": "capture", "var": "1a", "regex": "a"}`,
+		`{"name": "jmp_match", "status": 200, "offset": 0}`,
+		`{"name": "jmp_match", "status": 200, "offset": 2}`,
+		`{"name": "set_var", "id": 0, "val": 1}, {"name": "jmp_match", "pattern": "a", "offset": -1}`,
+	}
+	for _, c := range invalid {
+		var out map[string]interface{}
+		raw := fastjson.RawMessage(prog(c))
+		if err := IsValidAppSimJson(&raw, &out); err == nil {
+			t.Fatalf(" %v should be invalid", c)
+		}
+	}
+	/* delim and regex are exclusive */
+	var out map[string]interface{}
+	raw := fastjson.RawMessage(prog(`{"name": "rx_until", "delim": "\r\n", "regex": "\r\n"}`))
+	if err := IsValidAppSimJson(&raw, &out); err == nil || !strings.Contains(err.Error(), "one and only one schema (oneOf)") {
+		t.Fatalf(" delim and regex should be exclusive, %v", err)
+	}
+	raw = fastjson.RawMessage(prog(`{"name": "rx_until", "regex": "a+"}, {"name": "jmp_match", "pattern": "a", "offset": -1}`))
+	if err := IsValidAppSimJson(&raw, &out); err != nil {
+		t.Fatalf(" %v", err)
+	}
+	for _, s := range []struct {
+		b      string
+		status int
+	}{{"HTTP/1.1 200 OK\r\n", 200}, {"SIP/2.0 180\r\n", 180}, {"HTTP/1.1 2000 OK", 0}, {"GET /a\nHTTP/1.1 200", 0}, {"", 0}} {
+		if rxStatus([]byte(s.b)) != s.status {
+			t.Fatalf(" status of %q", s.b)
+		}
+	}
+}
+
+type appsimPcapPkt struct {
+	client bool
+	seq    uint32
+	flags  uint8
+	data   string
+}
+
+// appsimTestPcap a pcap of the packets, tcp or udp between 16.0.0.1:1025 and 48.0.0.1:80
+func appsimTestPcap(t *testing.T, pkts []appsimPcapPkt, udp bool, ipv6 bool) *bytes.Buffer {
+	var b bytes.Buffer
+	w := pcapgo.NewWriter(&b)
+	w.WriteFileHeader(65536, layers.LinkTypeEthernet)
+	for i, p := range pkts {
+		eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 1, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 1, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4}
+		c, s := net.IP{16, 0, 0, 1}, net.IP{48, 0, 0, 1}
+		if ipv6 {
+			c, s = net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::3000:1")
+		}
+		if !p.client {
+			c, s = s, c
+		}
+		proto := layers.IPProtocolTCP
+		if udp {
+			proto = layers.IPProtocolUDP
+		}
+		var l3 gopacket.SerializableLayer
+		if ipv6 {
+			eth.EthernetType = layers.EthernetTypeIPv6
+			l3 = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: c, DstIP: s}
+		} else {
+			l3 = &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: proto, SrcIP: c, DstIP: s}
+		}
+		cp, sp := uint16(1025), uint16(80)
+		if !p.client {
+			cp, sp = sp, cp
+		}
+		var l4 gopacket.SerializableLayer
+		if udp {
+			l4 = &layers.UDP{SrcPort: layers.UDPPort(cp), DstPort: layers.UDPPort(sp)}
+		} else {
+			l4 = &layers.TCP{SrcPort: layers.TCPPort(cp), DstPort: layers.TCPPort(sp), Seq: p.seq, Window: 1000}
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, l3, l4,
+			gopacket.Payload(p.data)); err != nil {
+			t.Fatalf(" %v", err)
+		}
+		d := buf.Bytes()
+		if !udp {
+			l4off := 14 + 20
+			if ipv6 {
+				l4off = 14 + 40
+			}
+			d[l4off+13] = p.flags
+		}
+		w.WritePacket(gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), Length: len(d), CaptureLength: len(d)}, d)
+	}
+	return &b
+}
+
+// a TCP flow with a retransmission and out of order segments to a program, the program runs on the transport
+func TestPluginAppSim41(t *testing.T) {
+	syn, ack := uint8(transport.TH_SYN), uint8(transport.TH_ACK)
+	pkts := []appsimPcapPkt{
+		{client: true, seq: 1000, flags: syn},
+		{client: false, seq: 5000, flags: syn | ack},
+		{client: true, seq: 1001, flags: ack},
+		{client: true, seq: 1001, flags: ack, data: "GET / HTT"},
+		{client: true, seq: 1010, flags: ack, data: "P/1.1\r\n\r\n"},
+		{client: true, seq: 1001, flags: ack, data: "GET / HTT"},
+		{client: false, seq: 5018, flags: ack, data: "Content-Length: 5\r\n\r\nhello"},
+		{client: false, seq: 5001, flags: ack, data: "HTTP/1.1 200 OK\r\n"},
+		{client: false, seq: 5001, flags: ack, data: "HTTP/1.1"},
+		{client: true, seq: 1019, flags: ack, data: "BYE\r\n"},
+		{client: true, seq: 1024, flags: ack | transport.TH_FIN},
+	}
+	res, err := PcapToAppsim(appsimTestPcap(t, pkts, false, false), &AppsimPcapParams{Cps: 2})
+	if err != nil {
+		t.Fatalf(" %v", err)
+	}
+	if !res.Stream || res.Ipv6 || res.Port != 80 || res.Msgs != 3 || res.Bytes != 18+43+5 {
+		t.Fatalf(" unexpected %+v", res)
+	}
+	js, _ := json.Marshal(res.Program)
+	exp := `{"buf_list":["R0VUIC8gSFRUUC8xLjENCg0K","SFRUUC8xLjEgMjAwIE9LDQpDb250ZW50LUxlbmd0aDogNQ0KDQpoZWxsbw==","QllFDQo="],` +
+		`"program_list":[{"commands":[{"buf_index":0,"name":"tx"},{"min_bytes":43,"name":"rx"},{"buf_index":2,"name":"tx"}]},` +
+		`{"commands":[{"min_bytes":18,"name":"rx"},{"buf_index":1,"name":"tx"},{"min_bytes":5,"name":"rx"}]}],` +
+		`"templates":[{"client_template":{"cps":2,"port":80,"program_index":0},"server_template":{"assoc":[{"port":80}],"program_index":1}}]}`
+	if string(js) != exp {
+		t.Fatalf(" unexpected program %s", js)
+	}
+
+	a := &AppL7SimTestBase{
+		testname:     "appsim-41",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:         "a",
+			ipv6:         false,
+			program_json: string(js),
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		cs := sim.client.stas
+		ss := sim.server.stas
+		if cs.BytesTx != 23 || ss.BytesRx != 23 || cs.BytesRx != 43 || ss.BytesTx != 43 || ss.eventDelFlow != 1 {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+	}
+	a.Run(t, false)
+}
+
+func TestPluginAppSim42(t *testing.T) {
+	pkts := []appsimPcapPkt{
+		{client: true, data: "M-SEARCH"},
+		{client: true, data: "M-SEARCH2"},
+		{client: false, data: "OK"},
+	}
+	res, err := PcapToAppsim(appsimTestPcap(t, pkts, true, true), &AppsimPcapParams{Port: 1900})
+	if err != nil {
+		t.Fatalf(" %v", err)
+	}
+	js, _ := json.Marshal(res.Program)
+	raw := fastjson.RawMessage(js)
+	var out map[string]interface{}
+	if err := IsValidAppSimJson(&raw, &out); err != nil {
+		t.Fatalf(" %v", err)
+	}
+	c := fmt.Sprint(res.Program["program_list"])
+	if res.Stream || !res.Ipv6 || res.Port != 1900 || res.Msgs != 3 ||
+		c != "[map[commands:[map[buf_index:0 name:tx_msg] map[buf_index:1 name:tx_msg] map[min_pkts:1 name:rx_msg]]] "+
+			"map[commands:[map[min_pkts:1 name:rx_msg] map[min_pkts:1 name:rx_msg] map[buf_index:2 name:tx_msg]]]]" {
+		t.Fatalf(" unexpected %+v %v", res, c)
+	}
+
+	// the second part of the request is missing
+	tcp := []appsimPcapPkt{{client: true, seq: 1, flags: transport.TH_ACK, data: "a"}, {client: true, seq: 5, data: "b"}}
+	if _, err := PcapToAppsim(appsimTestPcap(t, tcp, false, false), &AppsimPcapParams{}); err == nil {
+		t.Fatalf(" missing data should fail")
+	}
+	if _, err := PcapToAppsim(appsimTestPcap(t, nil, false, false), &AppsimPcapParams{}); err == nil {
+		t.Fatalf(" empty pcap should fail")
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 	flag.IntVar(&emu_debug, "emu_debug", 0, "emu_debug")
@@ -7,12 +7,39 @@ package arp
 
 /*
 RFC 826  for ARP
+RFC 5227 IPv4 Address Conflict Detection
+RFC 1027 proxy ARP
 
 client inijson {
 	Timer uint32 `json:"timer"` // timer in sec for query and keep the client alive from DUT, default is 60 sec
 	TimerDisable bool `json:"timer_disable"` // disable the Query timer (timer is zero)
+	Acd *ArpAcdInit `json:"acd"` // probe/announce the source ipv4 before using it
 }:
 
+acd inijson {
+	"probe_num": 3,         // number of probes
+	"probe_interval": 1,    // sec between probes
+	"announce_wait": 2,     // sec between the last probe and the first announcement
+	"announce_num": 2,      // number of announcements
+	"announce_interval": 2  // sec between announcements
+}
+
+With acd the client does not answer queries, resolve the default gateway or send the keepalive query
+until the probes ended without a conflict. A conflict (another MAC uses or probes the address) while
+probing stops the client from using the address. After the probes the client defends the address once in
+DEFEND_INTERVAL and stops using it on a second conflict. Each conflict is counted and sent as MSG_IPV4_CONFLICT.
+
+ns inijson {
+	"proxy": [{"subnet": [16, 1, 0, 0], "prefix": 16, "mac": [0, 0, 2, 0, 0, 1]}]
+}
+
+The namespace answers with the MAC of the entry for a query to an address in the subnet that is not
+owned by a client of the namespace. Probes and gratuitous ARP are not answered.
+
+The GARP storm (arp_c_cmd_storm) sends gratuitous ARP from a client in a given rate to stress the ARP policing of the DUT.
+
+The namespace resolves the next hops of the IPv4 routes (ctx_route_add), an unknown next hop is queried
+on behalf of the sending client.
 */
 
 import (
@@ -36,15 +63,54 @@ const (
 	stateIncomplete      = 17
 	stateComplete        = 18
 	stateRefresh         = 19 /* re-query wait for results to get back to stateQuery */
+
+	/* RFC 5227 address conflict detection state of the client */
+	acdStateProbe    = 1
+	acdStateAnnounce = 2
+	acdStateBound    = 3
+	acdStateConflict = 4
+
+	acdProbeNum         = 3
+	acdProbeInterval    = 1
+	acdAnnounceWait     = 2
+	acdAnnounceNum      = 2
+	acdAnnounceInterval = 2
+	acdDefendInterval   = 10 * time.Second
 )
 
+var acdStateNames = map[uint8]string{
+	acdStateProbe:    "probe",
+	acdStateAnnounce: "announce",
+	acdStateBound:    "bound",
+	acdStateConflict: "conflict",
+}
+
 // refresh the time here
 // I would like to make this table generic, let try to the table without generic first
 // then optimize it
 
+type ArpAcdInit struct {
+	ProbeNum         uint8  `json:"probe_num"`
+	ProbeInterval    uint32 `json:"probe_interval"`
+	AnnounceWait     uint32 `json:"announce_wait"`
+	AnnounceNum      uint8  `json:"announce_num"`
+	AnnounceInterval uint32 `json:"announce_interval"`
+}
+
 type ArpCInit struct {
-	Timer        uint32 `json:"timer"`
-	TimerDisable bool   `json:"timer_disable"`
+	Timer        uint32      `json:"timer"`
+	TimerDisable bool        `json:"timer_disable"`
+	Acd          *ArpAcdInit `json:"acd"`
+}
+
+type ArpProxyEntry struct {
+	Subnet core.Ipv4Key `json:"subnet" validate:"required"`
+	Prefix uint8        `json:"prefix" validate:"required,gte=1,lte=32"`
+	Mac    core.MACKey  `json:"mac" validate:"required"`
+}
+
+type ArpNsInit struct {
+	Proxy []ArpProxyEntry `json:"proxy" validate:"dive"`
 }
 
 type ArpFlow struct {
@@ -102,6 +168,17 @@ type ArpNsStats struct {
 	tblRemove             uint64
 	associateWithClient   uint64
 	disasociateWithClient uint64
+
+	invalidInitJson   uint64
+	pktTxProxyReply   uint64
+	pktTxAcdProbe     uint64
+	acdConflict       uint64
+	acdDefend         uint64
+	acdAddrBound      uint64
+	pktTxGArpStorm    uint64
+	garpStormStarted  uint64
+	garpStormFinished uint64
+	pktTxRouteQuery   uint64
 }
 
 func NewArpNsStatsDb(o *ArpNsStats) *core.CCounterDb {
@@ -285,6 +362,86 @@ func NewArpNsStatsDb(o *ArpNsStats) *core.CCounterDb {
 		Unit:     "ops",
 		DumpZero: false,
 		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxProxyReply,
+		Name:     "pktTxProxyReply",
+		Help:     "tx proxy arp reply",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAcdProbe,
+		Name:     "pktTxAcdProbe",
+		Help:     "tx address conflict detection probe",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acdConflict,
+		Name:     "acdConflict",
+		Help:     "another host uses the address of a client",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acdDefend,
+		Name:     "acdDefend",
+		Help:     "garp to defend the address of a client",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acdAddrBound,
+		Name:     "acdAddrBound",
+		Help:     "probes ended without a conflict",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxGArpStorm,
+		Name:     "pktTxGArpStorm",
+		Help:     "tx garp storm",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.garpStormStarted,
+		Name:     "garpStormStarted",
+		Help:     "garp storm started",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.garpStormFinished,
+		Name:     "garpStormFinished",
+		Help:     "garp storm finished or stopped",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRouteQuery,
+		Name:     "pktTxRouteQuery",
+		Help:     "tx query for a route next hop",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
 	return db
 }
 
@@ -595,6 +752,22 @@ func (o *PluginArpCTimer) OnEvent(a, b interface{}) {
 	c.onTimerUpdate()
 }
 
+type PluginArpCAcdTimer struct {
+}
+
+func (o *PluginArpCAcdTimer) OnEvent(a, b interface{}) {
+	c := a.(*PluginArpClient)
+	c.onAcdTimer()
+}
+
+type PluginArpCStormTimer struct {
+}
+
+func (o *PluginArpCStormTimer) OnEvent(a, b interface{}) {
+	c := a.(*PluginArpClient)
+	c.onStormTimer()
+}
+
 // PluginArpClient arp information per client
 type PluginArpClient struct {
 	core.PluginBase
@@ -608,11 +781,28 @@ type PluginArpClient struct {
 	timerw         *core.TimerCtx
 	arpNsPlug      *PluginArpNs
 	timerSec       uint32
+	acd            *ArpAcdInit // address conflict detection, nil in case it is disabled
+	acdState       uint8
+	acdCnt         uint8
+	acdTimer       core.CHTimerObj
+	acdTimerCb     PluginArpCAcdTimer
+	acdDefended    bool
+	acdDefendTick  uint64 // last time the address was defended
+	acdConflicts   uint32
+	acdConflictMac core.MACKey
+	stormTimer     core.CHTimerObj
+	stormTimerCb   PluginArpCStormTimer
+	stormTicks     uint32
+	stormBurst     uint32
+	stormLeft      uint32 // garp to send in case the storm is limited
+	stormLimited   bool
 }
 
 func (o *PluginArpClient) onTimerUpdate() {
 	// periodic
-	o.SendQuery()
+	if o.srcInUse() {
+		o.SendQuery()
+	}
 	o.timerw.Start(&o.timer, time.Duration(o.timerSec)*time.Second)
 }
 
@@ -655,6 +845,24 @@ func NewArpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 		if init.TimerDisable {
 			o.timerSec = 0
 		}
+		if init.Acd != nil {
+			o.acd = init.Acd
+			if o.acd.ProbeNum == 0 {
+				o.acd.ProbeNum = acdProbeNum
+			}
+			if o.acd.ProbeInterval == 0 {
+				o.acd.ProbeInterval = acdProbeInterval
+			}
+			if o.acd.AnnounceWait == 0 {
+				o.acd.AnnounceWait = acdAnnounceWait
+			}
+			if o.acd.AnnounceNum == 0 {
+				o.acd.AnnounceNum = acdAnnounceNum
+			}
+			if o.acd.AnnounceInterval == 0 {
+				o.acd.AnnounceInterval = acdAnnounceInterval
+			}
+		}
 	}
 
 	o.arpEnable = true
@@ -677,7 +885,21 @@ func (o *PluginArpClient) OnEvent(msg string, a, b interface{}) {
 	case core.MSG_UPDATE_IPV4_ADDR:
 		oldIPv4 := a.(core.Ipv4Key)
 		newIPv4 := b.(core.Ipv4Key)
-		if newIPv4.IsZero() != oldIPv4.IsZero() {
+		if o.acd != nil {
+			if newIPv4 != oldIPv4 {
+				/* probe the new address before using it */
+				o.arpNsPlug.stats.eventsChangeSrc++
+				oldInUse := !oldIPv4.IsZero() && o.acdAllowUse()
+				o.stopAcd()
+				o.OnChangeDGSrcIPv4(o.Client.DgIpv4,
+					o.Client.DgIpv4,
+					oldInUse,
+					false)
+				if !newIPv4.IsZero() {
+					o.startAcd()
+				}
+			}
+		} else if newIPv4.IsZero() != oldIPv4.IsZero() {
 			/* there was a change in Source IPv4 */
 			o.arpNsPlug.stats.eventsChangeSrc++
 			o.OnChangeDGSrcIPv4(o.Client.DgIpv4,
@@ -694,8 +916,8 @@ func (o *PluginArpClient) OnEvent(msg string, a, b interface{}) {
 			o.arpNsPlug.stats.eventsChangeDgIPv4++
 			o.OnChangeDGSrcIPv4(oldIPv4,
 				newIPv4,
-				!o.Client.Ipv4.IsZero(),
-				!o.Client.Ipv4.IsZero())
+				o.srcInUse(),
+				o.srcInUse())
 		}
 
 	}
@@ -724,15 +946,24 @@ func (o *PluginArpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	inUse := o.srcInUse()
+	o.stopAcd()
+	o.StopGArpStorm()
 
 	o.OnChangeDGSrcIPv4(o.Client.DgIpv4,
 		o.Client.DgIpv4,
-		!o.Client.Ipv4.IsZero(),
+		inUse,
 		false)
 	ctx.UnregisterEvents(&o.PluginBase, arpEvents)
 }
 
 func (o *PluginArpClient) OnCreate() {
+	o.acdTimer.SetCB(&o.acdTimerCb, o, 0)
+	o.stormTimer.SetCB(&o.stormTimerCb, o, 0)
+	if o.acd != nil && !o.Client.Ipv4.IsZero() {
+		o.startAcd()
+		return
+	}
 	if o.Client.ForceDGW {
 		return
 	}
@@ -760,16 +991,165 @@ func (o *PluginArpClient) SendGArp() {
 func (o *PluginArpClient) SendQuery() {
 	if !o.Client.DgIpv4.IsZero() {
 		o.arpNsPlug.stats.pktTxArpQuery++
-		o.arpHeader.SetOperation(1)
-		o.arpHeader.SetSrcIpAddress(o.Client.Ipv4.Uint32())
-		o.arpHeader.SetDstIpAddress(o.Client.DgIpv4.Uint32())
-		o.arpHeader.SetDestAddress([]byte{0, 0, 0, 0, 0, 0})
-		o.Tctx.Veth.SendBuffer(false, o.Client, o.arpPktTemplate)
+		o.sendQueryTo(o.Client.DgIpv4)
 	} else {
 		//panic("  SendQuery() arp wasn't sent ")
 	}
 }
 
+func (o *PluginArpClient) sendQueryTo(ipv4 core.Ipv4Key) {
+	o.arpHeader.SetOperation(1)
+	o.arpHeader.SetSrcIpAddress(o.Client.Ipv4.Uint32())
+	o.arpHeader.SetDstIpAddress(ipv4.Uint32())
+	o.arpHeader.SetDestAddress([]byte{0, 0, 0, 0, 0, 0})
+	o.Tctx.Veth.SendBuffer(false, o.Client, o.arpPktTemplate)
+}
+
+// SendProbe sends an address conflict detection probe, RFC 5227 2.1.1
+func (o *PluginArpClient) SendProbe() {
+	o.arpNsPlug.stats.pktTxAcdProbe++
+	o.arpHeader.SetOperation(1)
+	o.arpHeader.SetSrcIpAddress(0)
+	o.arpHeader.SetDstIpAddress(o.Client.Ipv4.Uint32())
+	o.arpHeader.SetDestAddress([]byte{0, 0, 0, 0, 0, 0})
+	o.Tctx.Veth.SendBuffer(false, o.Client, o.arpPktTemplate)
+}
+
+// acdAllowUse return true in case the address conflict detection lets the client use the address
+func (o *PluginArpClient) acdAllowUse() bool {
+	return o.acd == nil || o.acdState == acdStateAnnounce || o.acdState == acdStateBound
+}
+
+// srcInUse return true in case the client uses its source ipv4
+func (o *PluginArpClient) srcInUse() bool {
+	return !o.Client.Ipv4.IsZero() && o.acdAllowUse()
+}
+
+func (o *PluginArpClient) startAcd() {
+	o.acdState = acdStateProbe
+	o.acdCnt = 0
+	o.acdDefended = false
+	o.onAcdTimer()
+}
+
+func (o *PluginArpClient) stopAcd() {
+	if o.acdTimer.IsRunning() {
+		o.timerw.Stop(&o.acdTimer)
+	}
+	o.acdState = 0
+}
+
+func (o *PluginArpClient) restartAcdTimer(sec uint32) {
+	o.timerw.Start(&o.acdTimer, time.Duration(sec)*time.Second)
+}
+
+func (o *PluginArpClient) onAcdTimer() {
+	switch o.acdState {
+	case acdStateProbe:
+		if o.acdCnt < o.acd.ProbeNum {
+			o.SendProbe()
+			o.acdCnt++
+			if o.acdCnt == o.acd.ProbeNum {
+				o.restartAcdTimer(o.acd.AnnounceWait)
+			} else {
+				o.restartAcdTimer(o.acd.ProbeInterval)
+			}
+			return
+		}
+		/* no conflict, start using the address. The first announcement is sent on association */
+		o.arpNsPlug.stats.acdAddrBound++
+		o.acdState = acdStateAnnounce
+		o.acdCnt = 1
+		if !o.Client.ForceDGW && !o.Client.DgIpv4.IsZero() {
+			o.arpNsPlug.AssociateClient(o)
+		} else {
+			o.SendGArp()
+		}
+	case acdStateAnnounce:
+		o.SendGArp()
+		o.acdCnt++
+	default:
+		return
+	}
+	if o.acdCnt >= o.acd.AnnounceNum {
+		o.acdState = acdStateBound
+		return
+	}
+	o.restartAcdTimer(o.acd.AnnounceInterval)
+}
+
+// onAcdConflict another host uses or probes the address of the client, RFC 5227 2.1.1 and 2.4
+func (o *PluginArpClient) onAcdConflict(mac core.MACKey) {
+	o.arpNsPlug.stats.acdConflict++
+	o.acdConflicts++
+	o.acdConflictMac = mac
+	o.Client.PluginCtx.BroadcastMsg(&o.PluginBase, core.MSG_IPV4_CONFLICT, o.Client.Ipv4, mac)
+
+	switch o.acdState {
+	case acdStateProbe:
+		o.stopAcd()
+		o.acdState = acdStateConflict
+	case acdStateAnnounce, acdStateBound:
+		defendTicks := uint64(o.timerw.DurationToTicks(acdDefendInterval))
+		if !o.acdDefended || o.timerw.Ticks-o.acdDefendTick > defendTicks {
+			o.acdDefended = true
+			o.acdDefendTick = o.timerw.Ticks
+			o.arpNsPlug.stats.acdDefend++
+			o.SendGArp()
+			return
+		}
+		/* a second conflict in the defend interval, stop using the address */
+		o.stopAcd()
+		if !o.Client.ForceDGW {
+			o.OnChangeDGSrcIPv4(o.Client.DgIpv4, o.Client.DgIpv4, true, false)
+		}
+		o.acdState = acdStateConflict
+	}
+}
+
+// StartGArpStorm sends count gratuitous ARP (zero for no limit) in rate pps
+func (o *PluginArpClient) StartGArpStorm(rate float32, count uint32) error {
+	if rate <= 0 {
+		return fmt.Errorf("invalid storm rate %v", rate)
+	}
+	if !o.srcInUse() {
+		return fmt.Errorf("client does not use a source ipv4")
+	}
+	o.StopGArpStorm()
+	o.stormTicks, o.stormBurst = o.timerw.DurationToTicksBurst(time.Duration(float64(time.Second) / float64(rate)))
+	o.stormLeft = count
+	o.stormLimited = count > 0
+	o.arpNsPlug.stats.garpStormStarted++
+	o.timerw.StartTicks(&o.stormTimer, o.stormTicks)
+	return nil
+}
+
+func (o *PluginArpClient) StopGArpStorm() {
+	if o.stormTimer.IsRunning() {
+		o.timerw.Stop(&o.stormTimer)
+		o.arpNsPlug.stats.garpStormFinished++
+	}
+}
+
+func (o *PluginArpClient) onStormTimer() {
+	burst := o.stormBurst
+	if o.stormLimited && burst > o.stormLeft {
+		burst = o.stormLeft
+	}
+	for i := uint32(0); i < burst; i++ {
+		o.arpNsPlug.stats.pktTxGArpStorm++
+		o.SendGArp()
+	}
+	if o.stormLimited {
+		o.stormLeft -= burst
+		if o.stormLeft == 0 {
+			o.arpNsPlug.stats.garpStormFinished++
+			return
+		}
+	}
+	o.timerw.StartTicks(&o.stormTimer, o.stormTicks)
+}
+
 func (o *PluginArpClient) Respond(arpHeader *layers.ArpHeader) {
 
 	o.arpNsPlug.stats.pktTxReply++
@@ -791,6 +1171,8 @@ type PluginArpNs struct {
 	core.PluginBase
 	arpEnable bool
 	tbl       ArpFlowTable
+	proxy     []ArpProxyEntry
+	nhQuery   map[core.Ipv4Key]uint64 /* ticks of the last query of a route next hop */
 	stats     ArpNsStats
 	cdb       *core.CCounterDb
 	cdbv      *core.CCounterDbVec
@@ -806,13 +1188,59 @@ func NewArpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o.cdb = NewArpNsStatsDb(&o.stats)
 	o.cdbv = core.NewCCounterDbVec("arp")
 	o.cdbv.Add(o.cdb)
+	o.nhQuery = make(map[core.Ipv4Key]uint64)
+	o.Ns.Ipv4Resolver = o
+	if len(initJson) > 0 {
+		var init ArpNsInit
+		if err := ctx.Tctx.UnmarshalValidate(initJson, &init); err != nil {
+			o.stats.invalidInitJson++
+		} else {
+			o.proxy = init.Proxy
+		}
+	}
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginArpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginArpNs) OnRemove(ctx *core.PluginCtx) {
+	if o.Ns.Ipv4Resolver == core.Ipv4ResolverIF(o) {
+		o.Ns.Ipv4Resolver = nil
+	}
 	o.tbl.OnRemove()
 }
 
+/*ResolveIPv4 resolve a route next hop from the cache. An unknown next hop is queried on behalf
+of the client, at most once a second. The answer is learned like any other ARP */
+func (o *PluginArpNs) ResolveIPv4(client *core.CClient, ipv4 core.Ipv4Key) (core.MACKey, bool) {
+	flow := o.tbl.Lookup(ipv4)
+	if flow != nil && flow.action.IpdgResolved {
+		flow.touch = true
+		delete(o.nhQuery, ipv4)
+		return flow.action.IpdgMac, true
+	}
+	var mac core.MACKey
+	ticks := o.Tctx.GetTimerCtx().Ticks
+	if last, ok := o.nhQuery[ipv4]; ok && ticks-last < uint64(o.tbl.second) {
+		return mac, false
+	}
+	cplg := client.PluginCtx.Get(ARP_PLUG)
+	if cplg == nil {
+		return mac, false
+	}
+	arpc := cplg.Ext.(*PluginArpClient)
+	if !arpc.srcInUse() {
+		return mac, false
+	}
+	o.nhQuery[ipv4] = ticks
+	o.stats.pktTxRouteQuery++
+	arpc.sendQueryTo(ipv4)
+	return mac, false
+}
+
 func (o *PluginArpNs) OnEvent(msg string, a, b interface{}) {
 
 }
@@ -895,6 +1323,86 @@ func (o *PluginArpNs) ArpLearn(arpHeader *layers.ArpHeader) {
 	}
 }
 
+// getClientPlugin return the arp plugin of the client with this ipv4
+func (o *PluginArpNs) getClientPlugin(ipv4 uint32) *PluginArpClient {
+	var key core.Ipv4Key
+	key.SetUint32(ipv4)
+	client := o.Ns.CLookupByIPv4(&key)
+	if client == nil {
+		return nil
+	}
+	cplg := client.PluginCtx.Get(ARP_PLUG)
+	if cplg == nil {
+		return nil
+	}
+	return cplg.Ext.(*PluginArpClient)
+}
+
+/*detectConflict check if another host uses the address of a client with address conflict detection,
+that is a packet with the address as the sender or a probe for the address while the client probes it */
+func (o *PluginArpNs) detectConflict(arpHeader *layers.ArpHeader) bool {
+	var arpc *PluginArpClient
+	if sip := arpHeader.GetSrcIpAddress(); sip != 0 {
+		arpc = o.getClientPlugin(sip)
+	} else if arpHeader.GetOperation() == layers.ARPRequest {
+		arpc = o.getClientPlugin(arpHeader.GetDstIpAddress())
+		if arpc != nil && arpc.acdState != acdStateProbe {
+			arpc = nil
+		}
+	}
+	if arpc == nil || arpc.acd == nil {
+		return false
+	}
+	var mac core.MACKey
+	copy(mac[:], arpHeader.GetSourceAddress())
+	if mac == arpc.Client.Mac {
+		return false
+	}
+	arpc.onAcdConflict(mac)
+	return true
+}
+
+// proxyReply answer on behalf of a host in a proxy subnet, return false in case there is no such subnet
+func (o *PluginArpNs) proxyReply(arpHeader *layers.ArpHeader) bool {
+	sip := arpHeader.GetSrcIpAddress()
+	dip := arpHeader.GetDstIpAddress()
+	if sip == 0 || sip == dip {
+		/* probe or gratuitous ARP */
+		return false
+	}
+	for i := range o.proxy {
+		entry := &o.proxy[i]
+		mask := ^uint32(0) << (32 - uint32(entry.Prefix))
+		if (dip & mask) != (entry.Subnet.Uint32() & mask) {
+			continue
+		}
+		var dipKey core.Ipv4Key
+		var sipKey core.Ipv4Key
+		dipKey.SetUint32(dip)
+		sipKey.SetUint32(sip)
+		l2 := o.Ns.GetL2Header(false, uint16(layers.EthernetTypeARP))
+		copy(l2[0:6], arpHeader.GetSourceAddress())
+		copy(l2[6:12], entry.Mac[:])
+		arp := core.PacketUtlBuild(&layers.ARP{
+			AddrType:          0x1,
+			Protocol:          0x800,
+			HwAddressSize:     0x6,
+			ProtAddressSize:   0x4,
+			Operation:         layers.ARPReply,
+			SourceHwAddress:   entry.Mac[:],
+			SourceProtAddress: dipKey[:],
+			DstHwAddress:      arpHeader.GetSourceAddress(),
+			DstProtAddress:    sipKey[:]})
+		pkt := append(l2, arp...)
+		m := o.Ns.AllocMbuf(uint16(len(pkt)))
+		m.Append(pkt)
+		o.Tctx.Veth.Send(m)
+		o.stats.pktTxProxyReply++
+		return true
+	}
+	return false
+}
+
 //HandleRxArpPacket there is no need to free  buffer
 func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 	if m.PktLen() < uint32(layers.ARPHeaderSize+l3) {
@@ -909,6 +1417,7 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 	switch arpHeader.GetOperation() {
 	case layers.ARPRequest:
 		o.stats.pktRxArpQuery++
+		conflict := o.detectConflict(&arpHeader)
 		// learn the request information
 		o.ArpLearn(&arpHeader)
 
@@ -921,9 +1430,12 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 			cplg := client.PluginCtx.Get(ARP_PLUG)
 			if cplg != nil {
 				arpCPlug := cplg.Ext.(*PluginArpClient)
-				arpCPlug.Respond(&arpHeader)
+				/* the address is not used while probing, the conflict was answered by a garp */
+				if arpCPlug.srcInUse() && !conflict {
+					arpCPlug.Respond(&arpHeader)
+				}
 			}
-		} else {
+		} else if !o.proxyReply(&arpHeader) {
 			o.stats.pktRxArpQueryNotForUs++
 		}
 
@@ -933,6 +1445,7 @@ func (o *PluginArpNs) HandleRxArpPacket(m *core.Mbuf, l3 uint16) {
 			return
 		}
 		o.stats.pktRxArpReply++
+		o.detectConflict(&arpHeader)
 		o.ArpLearn(&arpHeader)
 
 	default:
@@ -994,6 +1507,23 @@ type (
 		Garp bool `json:"garp"`
 	}
 
+	ApiArpNsSetProxyHandler struct{} // replace the proxy arp subnets
+	ApiArpNsGetProxyHandler struct{}
+
+	ApiArpCGetAcdHandler struct{} // address conflict detection state
+	ApiArpCGetAcdResult  struct {
+		State       string      `json:"state"`
+		Conflicts   uint32      `json:"conflicts"`
+		ConflictMac core.MACKey `json:"conflict_mac"`
+	}
+
+	ApiArpCCmdStormHandler struct{} // garp storm
+	ApiArpCCmdStormParams  struct {
+		Rate  float32 `json:"rate" validate:"omitempty,gt=0,lte=1000000"` // pps
+		Count uint32  `json:"count"`                                                           // zero for no limit
+		Stop  bool    `json:"stop"`
+	}
+
 	ApiArpNsIterHandler struct{} // iterate on the nd ipv6 cache table
 	ApiArpNsIterParams  struct {
 		Reset bool   `json:"reset"`
@@ -1116,6 +1646,95 @@ func (h ApiArpCCmdQueryHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return nil, nil
 }
 
+func (h ApiArpNsSetProxyHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ArpNsInit
+	tctx := ctx.(*core.CThreadCtx)
+
+	arpNs, err := getNsPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	arpNs.proxy = p.Proxy
+	return nil, nil
+}
+
+func (h ApiArpNsGetProxyHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	arpNs, err := getNsPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return &ArpNsInit{Proxy: arpNs.proxy}, nil
+}
+
+func (h ApiArpCGetAcdHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	arpC, err := getClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	res := ApiArpCGetAcdResult{State: "disabled",
+		Conflicts:   arpC.acdConflicts,
+		ConflictMac: arpC.acdConflictMac}
+	if arpC.acd != nil {
+		res.State = "idle"
+		if name, ok := acdStateNames[arpC.acdState]; ok {
+			res.State = name
+		}
+	}
+	return &res, nil
+}
+
+func (h ApiArpCCmdStormHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiArpCCmdStormParams
+	tctx := ctx.(*core.CThreadCtx)
+
+	arpC, err := getClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err1 := tctx.UnmarshalValidate(*params, &p)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+
+	if p.Stop {
+		arpC.StopGArpStorm()
+		return nil, nil
+	}
+
+	err1 = arpC.StartGArpStorm(p.Rate, p.Count)
+	if err1 != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err1.Error(),
+		}
+	}
+	return nil, nil
+}
+
 func (h ApiArpNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
 
 	var p ApiArpNsIterParams
@@ -1188,6 +1807,10 @@ func init() {
 	core.RegisterCB("arp_ns_cnt", ApiArpNsCntHandler{}, true)
 	core.RegisterCB("arp_c_cmd_query", ApiArpCCmdQueryHandler{}, true)
 	core.RegisterCB("arp_ns_iter", ApiArpNsIterHandler{}, true)
+	core.RegisterCB("arp_ns_set_proxy", ApiArpNsSetProxyHandler{}, true)
+	core.RegisterCB("arp_ns_get_proxy", ApiArpNsGetProxyHandler{}, true)
+	core.RegisterCB("arp_c_get_acd", ApiArpCGetAcdHandler{}, true)
+	core.RegisterCB("arp_c_cmd_storm", ApiArpCCmdStormHandler{}, true)
 
 	/* register callback for rx side*/
 	core.ParserRegister("arp", HandleRxArpPacket)
@@ -32,6 +32,8 @@ type ArpTestBase struct {
 	cb           ArpTestCb
 	cbArg1       interface{}
 	cbArg2       interface{}
+	nsJson       string
+	clientJson   string
 }
 
 type ArpTestCb func(tctx *core.CThreadCtx, test *ArpTestBase) int
@@ -45,7 +47,7 @@ func (o *ArpTestBase) Run(t *testing.T) {
 	if o.match > 0 {
 		simVeth.match = o.match
 	}
-	tctx, _ := createSimulationEnv(&simrx, o.clientsToSim)
+	tctx, _ := createSimulationEnv(&simrx, o.clientsToSim, o.nsJson, o.clientJson)
 	if o.cb != nil {
 		o.cb(tctx, o)
 	}
@@ -75,12 +77,19 @@ func (o *ArpTestBase) Run(t *testing.T) {
 
 }
 
-func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *core.CClient) {
+func createSimulationEnv(simRx *core.VethIFSim, num int, nsJson string, clientJson string) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
 	var key core.CTunnelKey
 	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
 	ns := core.NewNSCtx(tctx, &key)
 	tctx.AddNs(&key, ns)
+	if nsJson != "" {
+		ns.PluginCtx.CreatePlugins([]string{"arp"}, [][]byte{[]byte(nsJson)})
+	}
+	inijson := [][]byte{}
+	if clientJson != "" {
+		inijson = [][]byte{[]byte(clientJson)}
+	}
 	for j := 0; j < num; j++ {
 		a := uint8((j >> 8) & 0xff)
 		b := uint8(j & 0xff)
@@ -95,7 +104,7 @@ func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *cor
 			core.Ipv6Key{},
 			dg)
 		ns.AddClient(client)
-		client.PluginCtx.CreatePlugins([]string{"arp"}, [][]byte{})
+		client.PluginCtx.CreatePlugins([]string{"arp"}, inijson)
 	}
 	tctx.RegisterParserCb("arp")
 	return tctx, nil
@@ -409,6 +418,235 @@ func TestPluginArp7(t *testing.T) {
 	a.Run(t)*/
 }
 
+/* ArpConflictCtx - another host claims the address of the client, cnt times every 2 sec */
+type ArpConflictCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+	cnt   int
+}
+
+func (o *ArpConflictCtx) OnEvent(a, b interface{}) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{}
+	gopacket.SerializeLayers(buf, opts,
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 3, 0, 0},
+			DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			Priority:       uint8(0),
+			VLANIdentifier: uint16(1),
+			Type:           layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			Priority:       uint8(0),
+			VLANIdentifier: uint16(2),
+			Type:           layers.EthernetTypeARP,
+		},
+
+		&layers.ARP{
+			AddrType:          0x1,
+			Protocol:          0x800,
+			HwAddressSize:     0x6,
+			ProtAddressSize:   0x4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   net.HardwareAddr{0, 0, 0, 3, 0, 0},
+			SourceProtAddress: []uint8{16, 0x0, 0x0, 0x0},
+			DstHwAddress:      []uint8{0x0, 0x0, 0x0, 0x0, 0x0, 0x0},
+			DstProtAddress:    []uint8{16, 0x0, 0x0, 0x0}})
+
+	m := o.tctx.MPool.Alloc(uint16(128))
+	m.SetVPort(1)
+	m.Append(buf.Bytes())
+	o.tctx.Veth.OnRx(m)
+
+	o.cnt--
+	if o.cnt > 0 {
+		timerw := o.tctx.GetTimerCtx()
+		timerw.Start(&o.timer, 2*time.Second)
+	}
+}
+
+func cbConflict(tctx *core.CThreadCtx, test *ArpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	var arpctx ArpConflictCtx
+	arpctx.timer.SetCB(&arpctx, nil, nil)
+	arpctx.tctx = tctx
+	arpctx.cnt = test.cbArg2.(int)
+	timerw.Start(&arpctx.timer, test.cbArg1.(time.Duration))
+	return 0
+}
+
+/*TestPluginArpAcd1 - probe and announce the address, no conflict */
+func TestPluginArpAcd1(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_acd1",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		clientJson:   `{"acd": {}}`,
+	}
+	a.Run(t)
+}
+
+/*TestPluginArpAcd2 - another host uses the address while probing, the address is not used */
+func TestPluginArpAcd2(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_acd2",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		clientJson:   `{"acd": {"probe_num": 4, "probe_interval": 2}}`,
+		cb:           cbConflict,
+		cbArg1:       3 * time.Second,
+		cbArg2:       1,
+	}
+	a.Run(t)
+}
+
+/*TestPluginArpAcd3 - conflict on a bound address, defend once and give up on the second conflict */
+func TestPluginArpAcd3(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_acd3",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		clientJson:   `{"acd": {}}`,
+		cb:           cbConflict,
+		cbArg1:       20 * time.Second,
+		cbArg2:       2,
+	}
+	a.Run(t)
+}
+
+/*TestPluginArpProxy - answer a query for 16.0.0.5 on behalf of a proxy subnet */
+func TestPluginArpProxy(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_proxy",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           Cb4,
+		cbArg1:       1,
+		nsJson:       `{"proxy": [{"subnet": [16, 0, 0, 4], "prefix": 30, "mac": [0, 0, 4, 0, 0, 0]}]}`,
+	}
+	a.Run(t)
+}
+
+type ArpStormRpcCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+func (o *ArpStormRpcCtx) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"arp_c_cmd_storm",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,0], "rate": 10, "count": 25 }, "id": 3 }`))
+
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"arp_c_cmd_storm",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,0], "rate": 0 }, "id": 4 }`))
+
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"arp_c_get_acd",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,0] }, "id": 5 }`))
+
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"arp_ns_set_proxy",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "proxy": [{"subnet": [48, 0, 0, 0], "prefix": 8, "mac": [0, 0, 4, 0, 0, 0]}] }, "id": 6 }`))
+
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"arp_ns_get_proxy",
+	"params": {"tun": {"vport":1,"tci":[1,2]} }, "id": 7 }`))
+}
+
+func rpcStorm(tctx *core.CThreadCtx, test *ArpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	var arpctx ArpStormRpcCtx
+	arpctx.timer.SetCB(&arpctx, nil, nil)
+	arpctx.tctx = tctx
+	timerw.Start(&arpctx.timer, 10*time.Second)
+	return 0
+}
+
+/*TestPluginArpStorm - gratuitous ARP storm of 25 packets in 10 pps */
+func TestPluginArpStorm(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_storm",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     20 * time.Second,
+		clientsToSim: 1,
+		clientJson:   `{"acd": {}}`,
+		cb:           rpcStorm,
+	}
+	a.Run(t)
+}
+
+/* ArpRouteCtx - resolve a route next hop every 200 msec */
+type ArpRouteCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+	cnt   int
+}
+
+func (o *ArpRouteCtx) OnEvent(a, b interface{}) {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	client := o.tctx.GetNs(&key).GetFirstClient()
+	client.ResolveIPv4NextHopMac(core.Ipv4Key{48, 0, 0, 1}, 0)
+	o.cnt--
+	if o.cnt > 0 {
+		o.tctx.GetTimerCtx().Start(&o.timer, 200*time.Millisecond)
+	}
+}
+
+func cbRoute(tctx *core.CThreadCtx, test *ArpTestBase) int {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	ns.Routes = core.NewRouteTable()
+	ns.Routes.Add(&core.CRoute{Prefix: core.Ipv4Key{48, 0, 0, 0}, PrefixLen: 8,
+		NextHops: []core.CRouteNextHop{{Via: core.Ipv4Key{16, 0, 0, 9}}}})
+
+	var arpctx ArpRouteCtx
+	arpctx.timer.SetCB(&arpctx, nil, nil)
+	arpctx.tctx = tctx
+	arpctx.cnt = 15
+	tctx.GetTimerCtx().Start(&arpctx.timer, 5*time.Second)
+	return 0
+}
+
+/*TestPluginArpRoute - query a route next hop on behalf of the client, once a second */
+func TestPluginArpRoute(t *testing.T) {
+	a := &ArpTestBase{
+		testname:     "arp_route",
+		dropAll:      true,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		cb:           cbRoute,
+	}
+	a.Run(t)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
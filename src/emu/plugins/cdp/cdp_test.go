@@ -6,9 +6,12 @@
 package cdp
 
 import (
+	"bytes"
 	"emu/core"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"external/google/gopacket/layers"
 	"flag"
 	"os"
 	"testing"
@@ -29,6 +32,8 @@ type CdpTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	options      []byte
+	nsOptions    []byte
+	check        func(c *PluginCdpClient, t *testing.T)
 }
 
 type CdpTestCb func(tctx *core.CThreadCtx, test *CdpTestBase) int
@@ -68,6 +73,9 @@ func (o *CdpTestBase) Run(t *testing.T) {
 		t.Fatalf(" can't find plugin")
 	}
 	cdpPlug := nsplg.Ext.(*PluginCdpClient)
+	if o.check != nil {
+		o.check(cdpPlug, t)
+	}
 	cdpPlug.cdbv.Dump()
 	tctx.GetCounterDbVec().Dump()
 
@@ -90,7 +98,11 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *CdpTestBase) (*co
 		core.Ipv6Key{},
 		dg)
 	ns.AddClient(client)
-	ns.PluginCtx.CreatePlugins([]string{"cdp"}, [][]byte{})
+	if test.nsOptions == nil {
+		ns.PluginCtx.CreatePlugins([]string{"cdp"}, [][]byte{})
+	} else {
+		ns.PluginCtx.CreatePlugins([]string{"cdp"}, [][]byte{test.nsOptions})
+	}
 
 	var inijson [][]byte
 	if test.options == nil {
@@ -101,6 +113,7 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *CdpTestBase) (*co
 
 	client.PluginCtx.CreatePlugins([]string{"cdp"}, inijson)
 	ns.Dump()
+	tctx.RegisterParserCb("cdp")
 
 	nsplg := ns.PluginCtx.Get(CDP_PLUG)
 	if nsplg == nil {
@@ -129,8 +142,10 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 	return nil
 }
 
+const cdpDutTlvs = "0001000c6d7973776974636800020011000000010101cc0004c0a800fd000300134661737445746865726e6574302f31000400080000002800050114436973636f20496e7465726e6574776f726b204f7065726174696e672053797374656d20536f667477617265200a494f532028746d2920433239353020536f667477617265202843323935302d49364b324c3251342d4d292c2056657273696f6e2031322e3128323229454131342c2052454c4541534520534f4654574152452028666331290a546563686e6963616c20537570706f72743a20687474703a2f2f7777772e636973636f2e636f6d2f74656368737570706f72740a436f707972696768742028632920313938362d3230313020627920636973636f2053797374656d732c20496e632e0a436f6d70696c6564205475652032362d4f63742d31302031303a3335206279206e627572726100060015636973636f2057532d43323935302d31320008002400000c011200000000ffffffff010220ff000000000000000bbe189a40ff00000009000c4d59444f4d41494e000a00060001000b0005010012000500001300050000160011000000010101cc0004c0a800fd"
+
 func TestPluginCdp1(t *testing.T) {
-	cdp_options, _ := hex.DecodeString("0001000c6d7973776974636800020011000000010101cc0004c0a800fd000300134661737445746865726e6574302f31000400080000002800050114436973636f20496e7465726e6574776f726b204f7065726174696e672053797374656d20536f667477617265200a494f532028746d2920433239353020536f667477617265202843323935302d49364b324c3251342d4d292c2056657273696f6e2031322e3128323229454131342c2052454c4541534520534f4654574152452028666331290a546563686e6963616c20537570706f72743a20687474703a2f2f7777772e636973636f2e636f6d2f74656368737570706f72740a436f707972696768742028632920313938362d3230313020627920636973636f2053797374656d732c20496e632e0a436f6d70696c6564205475652032362d4f63742d31302031303a3335206279206e627572726100060015636973636f2057532d43323935302d31320008002400000c011200000000ffffffff010220ff000000000000000bbe189a40ff00000009000c4d59444f4d41494e000a00060001000b0005010012000500001300050000160011000000010101cc0004c0a800fd")
+	cdp_options, _ := hex.DecodeString(cdpDutTlvs)
 	l := &CdpInit{Options: &CdpOptionsT{Raw: &cdp_options}}
 	jsonData2, _ := json.Marshal(l)
 
@@ -165,6 +180,226 @@ func TestPluginCdp2(t *testing.T) {
 	a.Run(t)
 }
 
+// cdpTlv build a TLV of a CDP packet
+func cdpTlv(t layers.CDPTLVType, v ...byte) []byte {
+	h := make([]byte, 4)
+	binary.BigEndian.PutUint16(h[0:2], uint16(t))
+	binary.BigEndian.PutUint16(h[2:4], uint16(4+len(v)))
+	return append(h, v...)
+}
+
+// cdpDutPkt build a CDP packet of the DUT on the vlans of the namespace
+func cdpDutPkt(dst core.MACKey, ttl uint8, badCs bool, tlvs ...[]byte) []byte {
+	cdph := []byte{2, ttl, 0, 0}
+	for _, t := range tlvs {
+		cdph = append(cdph, t...)
+	}
+	cs := layers.CdpChecksum(cdph, 0)
+	if badCs {
+		cs++
+	}
+	binary.BigEndian.PutUint16(cdph[2:4], cs)
+
+	p := append([]byte{}, dst[:]...)
+	p = append(p, 0, 0, 0, 1, 0, 0, 0x81, 0, 0, 1, 0x81, 0, 0, 2, 0, 0)
+	binary.BigEndian.PutUint16(p[20:22], uint16(8+len(cdph)))
+	p = append(p, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00)
+	p = append(p, cdph...)
+	for len(p) < 68 {
+		p = append(p, 0) // padding
+	}
+	return p
+}
+
+type cdpRxPkt struct {
+	sec int
+	pkt []byte
+}
+
+// CdpRxCtx inject the packets of the DUT by their time
+type CdpRxCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+	pkts  []cdpRxPkt
+	sec   int
+}
+
+func (o *CdpRxCtx) OnEvent(a, b interface{}) {
+	o.sec++
+	for len(o.pkts) > 0 && o.pkts[0].sec <= o.sec {
+		o.tctx.Veth.OnRx(genMbuf(o.tctx, o.pkts[0].pkt))
+		o.pkts = o.pkts[1:]
+	}
+	if len(o.pkts) > 0 {
+		timerw := o.tctx.GetTimerCtx()
+		timerw.StartTicks(&o.timer, timerw.DurationToTicks(time.Second))
+	}
+}
+
+func cdpRxCb(tctx *core.CThreadCtx, test *CdpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	rxctx := &CdpRxCtx{tctx: tctx, pkts: test.cbArg1.([]cdpRxPkt)}
+	rxctx.timer.SetCB(rxctx, nil, nil)
+	timerw.StartTicks(&rxctx.timer, timerw.DurationToTicks(time.Second))
+	return 0
+}
+
+/* neighbors of the DUT, TTL aging, zero TTL, bad checksum, a malformed packet and a unicast packet */
+func TestPluginCdp3(t *testing.T) {
+	tlvs, _ := hex.DecodeString(cdpDutTlvs)
+	nbr1 := cdpDutPkt(cdpDefaultDestMAC, 10, false, tlvs)
+	nbr2 := func(ttl uint8) []byte {
+		return cdpDutPkt(cdpDefaultDestMAC, ttl, false,
+			cdpTlv(layers.CDPTLVDevID, []byte("sw2")...),
+			cdpTlv(layers.CDPTLVPortID, []byte("Gi0/2")...))
+	}
+	badCs := cdpDutPkt(cdpDefaultDestMAC, 180, true, cdpTlv(layers.CDPTLVDevID, []byte("sw3")...))
+	noDevId := cdpDutPkt(cdpDefaultDestMAC, 180, false, cdpTlv(layers.CDPTLVPortID, []byte("Gi0/3")...))
+	nbr4 := cdpDutPkt(core.MACKey{0, 0, 1, 0, 0, 1}, 180, false,
+		cdpTlv(layers.CDPTLVDevID, []byte("sw4")...),
+		cdpTlv(layers.CDPTLVPortID, []byte("Gi0/4")...),
+		cdpTlv(layers.CDPTLVNativeVLAN, 0, 100))
+
+	a := &CdpTestBase{
+		testname:     "cdp3",
+		dropAll:      false,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     20 * time.Second,
+		clientsToSim: 1,
+		cb:           cdpRxCb,
+		cbArg1: []cdpRxPkt{{1, nbr1}, {2, nbr2(180)}, {3, badCs}, {4, noDevId},
+			{5, nbr2(0)}, {6, nbr1}, {8, nbr4}},
+		check: func(c *PluginCdpClient, t *testing.T) {
+			if c.stats.pktRx != 7 || c.stats.pktRxBadCs != 1 || c.stats.pktRxErr != 1 || c.stats.nbrAdd != 3 ||
+				c.stats.nbrAged != 1 || c.stats.nbrShutdown != 1 || c.stats.nbrActive != 1 {
+				t.Fatalf(" unexpected counters %+v \n", c.stats)
+			}
+			if c.IterReset() {
+				t.Fatalf(" neighbor table is empty \n")
+			}
+			nbrs, _ := c.GetNext(10)
+			if len(nbrs) != 1 || nbrs[0].DeviceId != "sw4" || nbrs[0].PortId != "Gi0/4" ||
+				nbrs[0].NativeVlan != 100 || nbrs[0].RemainSec != 168 {
+				t.Fatalf(" unexpected neighbors %+v \n", nbrs)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the records of the neighbor table */
+func TestPluginCdpDecode(t *testing.T) {
+	var rec CdpNeighborRec
+	tlvs, _ := hex.DecodeString(cdpDutTlvs)
+	key, err := cdpDecode(append([]byte{2, 180, 0, 0}, tlvs...), &rec)
+	if err != nil || key == "" {
+		t.Fatalf(" decode failed %v \n", err)
+	}
+	if rec.Ver != 2 || rec.Ttl != 180 || rec.DeviceId != "myswitch" || rec.PortId != "FastEthernet0/1" ||
+		rec.Capabilities != 0x28 || rec.Platform != "cisco WS-C2950-12" || rec.VtpDomain != "MYDOMAIN" ||
+		rec.NativeVlan != 1 || !rec.FullDuplex || len(rec.Addr) != 1 || rec.Addr[0] != "192.168.0.253" ||
+		len(rec.MgmtAddr) != 1 || rec.MgmtAddr[0] != "192.168.0.253" {
+		t.Fatalf(" unexpected record %+v \n", rec)
+	}
+
+	for _, b := range [][]byte{
+		{2, 180},
+		{2, 180, 0, 0, 0, 1, 0, 3, 'a'},
+		{2, 180, 0, 0, 0, 1, 0, 8, 'a'},
+		append([]byte{2, 180, 0, 0}, cdpTlv(layers.CDPTLVAddress, 0, 0, 0, 1, 1, 1, 0xcc, 0, 4, 10)...),
+	} {
+		if _, err := cdpDecode(b, &rec); err == nil {
+			t.Fatalf(" malformed %v was decoded \n", b)
+		}
+	}
+}
+
+const cdpPhoneTlvs = `{"tlvs": {"device_id": "SEP00000100000", "addr": "10.0.0.5", "port_id": "Port 1",
+	"capabilities": 144, "sw_version": "phone 1.0", "platform": "trex phone", "native_vlan": 10,
+	"full_duplex": true, "power": 6300, "power_request": {"request_id": 1, "mgmt_id": 2, "power": [6300, 12000]}}}`
+
+const cdpPhoneEngines = `{"engines": [
+	{"engine_name": "device_id", "engine_type": "string_list",
+	 "params": {"size": 16, "offset": 0, "op": "inc", "list": ["SEP000001000001", "SEP000001000002"]}},
+	{"engine_name": "native_vlan", "engine_type": "uint",
+	 "params": {"size": 2, "offset": 0, "op": "inc", "step": 2, "min": 10, "max": 100}}]}`
+
+/* typed TLVs of an IP phone, the TLVs of the namespace engines */
+func TestPluginCdp4(t *testing.T) {
+	a := &CdpTestBase{
+		testname:     "cdp4",
+		dropAll:      false,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     60 * time.Second,
+		clientsToSim: 1,
+		options:      []byte(cdpPhoneTlvs),
+		nsOptions:    []byte(cdpPhoneEngines),
+		check: func(c *PluginCdpClient, t *testing.T) {
+			var rec CdpNeighborRec
+			if !cdpVerifyChecksum(c.pktTemplate[c.l3Offset+8:]) {
+				t.Fatalf(" bad checksum \n")
+			}
+			if _, err := cdpDecode(c.pktTemplate[c.l3Offset+8:], &rec); err != nil {
+				t.Fatalf(" decode failed %v \n", err)
+			}
+			if rec.DeviceId != "SEP000001000001" || rec.NativeVlan != 10 {
+				t.Fatalf(" unexpected TLVs %+v \n", rec)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the engines of the namespace, each client takes the next value */
+func TestPluginCdpTlvs(t *testing.T) {
+	var init CdpInit
+	var nsInit CdpNsInit
+	json.Unmarshal([]byte(cdpPhoneTlvs), &init)
+	json.Unmarshal([]byte(cdpPhoneEngines), &nsInit)
+	var simrx core.VethIFSim = &VethIgmpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var eng cdpEngines
+	eng.create(tctx, nsInit.Engines)
+	if !eng.valid {
+		t.Fatalf(" invalid engines \n")
+	}
+
+	for i, exp := range []struct {
+		devId string
+		vlan  uint16
+	}{{"SEP000001000001", 10}, {"SEP000001000002", 12}, {"SEP000001000001", 14}} {
+		d := append([]byte{2, 180, 0, 0}, cdpBuildTlvs(eng.apply(init.Tlvs))...)
+		var rec CdpNeighborRec
+		if _, err := cdpDecode(d, &rec); err != nil {
+			t.Fatalf(" client %d decode failed %v \n", i, err)
+		}
+		if rec.DeviceId != exp.devId || rec.PortId != "Port 1" || len(rec.Addr) != 1 || rec.Addr[0] != "10.0.0.5" ||
+			rec.Capabilities != 144 || rec.SwVersion != "phone 1.0" || rec.Platform != "trex phone" ||
+			rec.NativeVlan != exp.vlan || !rec.FullDuplex {
+			t.Fatalf(" client %d unexpected record %+v \n", i, rec)
+		}
+	}
+
+	// power consumption and power request
+	d := cdpBuildTlvs(&CdpTlvsT{Power: 6300, PowerRequest: init.Tlvs.PowerRequest})
+	exp, _ := hex.DecodeString("00100006189c" + "00190010000100020000189c00002ee0")
+	if !bytes.Equal(d, exp) {
+		t.Fatalf(" unexpected power TLVs %x \n", d)
+	}
+
+	// IPv6 address
+	d = cdpBuildTlvs(&CdpTlvsT{DeviceId: "sw", Addr: "2001:db8::1"})
+	var rec CdpNeighborRec
+	if _, err := cdpDecode(append([]byte{2, 180, 0, 0}, d...), &rec); err != nil || rec.Addr[0] != "2001:db8::1" {
+		t.Fatalf(" unexpected IPv6 address %+v %v \n", rec, err)
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
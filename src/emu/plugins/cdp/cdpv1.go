@@ -10,6 +10,8 @@ cdp protocol.
 
 broadcast cisco CDP packet every tick. The CDP TLV information can be tuned by the inijson
 
+The CDP packets of the DUT are decoded to a neighbor table per client, see neighbor.go
+
 */
 
 import (
@@ -17,6 +19,8 @@ import (
 	"encoding/binary"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -34,14 +38,25 @@ type CdpOptionsT struct {
 }
 
 type CdpInit struct {
-	TimerSec uint32       `json:"timer"`
-	Ver      uint8        `json:"ver"` // 1, or 2
-	Options  *CdpOptionsT `json:"options"`
-	BadCs    uint16       `json:"cs"` // for generating bad cs
+	TimerSec     uint32       `json:"timer"`
+	Ver          uint8        `json:"ver"` // 1, or 2
+	Options      *CdpOptionsT `json:"options"`
+	Tlvs         *CdpTlvsT    `json:"tlvs"`          // typed TLVs, the raw options are added after them
+	BadCs        uint16       `json:"cs"`            // for generating bad cs
+	MaxNeighbors uint16       `json:"max_neighbors"` // size of the neighbor table, default CDP_MAX_NEIGHBORS
 }
 
 type CdpStats struct {
-	pktTx uint64
+	pktTx        uint64
+	pktRx        uint64
+	pktRxErr     uint64
+	pktRxBadCs   uint64
+	nbrAdd       uint64
+	nbrAged      uint64
+	nbrShutdown  uint64
+	nbrTableFull uint64
+	nbrActive    uint64
+	errEngine    uint64
 }
 
 func NewCdpStatsDb(o *CdpStats) *core.CCounterDb {
@@ -55,6 +70,78 @@ func NewCdpStatsDb(o *CdpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "rx cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxErr,
+		Name:     "pktRxErr",
+		Help:     "rx malformed cdp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadCs,
+		Name:     "pktRxBadCs",
+		Help:     "rx cdp packets with a bad checksum",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrAdd,
+		Name:     "nbrAdd",
+		Help:     "new neighbors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrAged,
+		Name:     "nbrAged",
+		Help:     "neighbors removed by the TTL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrShutdown,
+		Name:     "nbrShutdown",
+		Help:     "neighbors removed by a packet with zero TTL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrTableFull,
+		Name:     "nbrTableFull",
+		Help:     "new neighbors dropped, the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrActive,
+		Name:     "nbrActive",
+		Help:     "neighbors in the table",
+		Unit:     "nbrs",
+		DumpZero: true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errEngine,
+		Name:     "errEngine",
+		Help:     "invalid field engines of the namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -80,6 +167,12 @@ type PluginCdpClient struct {
 	timerSec    uint32
 	l3Offset    uint16
 	pktTemplate []byte
+	maxNbrs     uint16
+	nbrs        map[string]*CdpNeighbor
+	nbrHead     core.DList
+	activeIter  *core.DList
+	iterReady   bool
+	nbrTimerCb  PluginCdpNbrTimer
 }
 
 var cdpEvents = []string{}
@@ -94,6 +187,7 @@ func NewCdpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o.RegisterEvents(ctx, cdpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(CDP_PLUG)
 	o.cdpNsPlug = nsplg.Ext.(*PluginCdpNs)
+	o.cdpNsPlug.clients = append(o.cdpNsPlug.clients, o)
 	o.OnCreate()
 
 	return &o.PluginBase
@@ -101,15 +195,21 @@ func NewCdpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 
 func (o *PluginCdpClient) OnCreate() {
 	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewCdpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("cdp")
+	o.cdbv.Add(o.cdb)
 	o.preparePacketTemplate()
 	o.timerSec = 30
 	if o.init.TimerSec > 0 {
 		o.timerSec = o.init.TimerSec
 	}
+	o.maxNbrs = CDP_MAX_NEIGHBORS
+	if o.init.MaxNeighbors > 0 {
+		o.maxNbrs = o.init.MaxNeighbors
+	}
+	o.nbrs = make(map[string]*CdpNeighbor)
+	o.nbrHead.SetSelf()
 
-	o.cdb = NewCdpStatsDb(&o.stats)
-	o.cdbv = core.NewCCounterDbVec("cdp")
-	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
 	o.SendCdp()
 }
@@ -149,6 +249,13 @@ func (o *PluginCdpClient) preparePacketTemplate() {
 		cdph,
 	)
 
+	if o.init.Tlvs != nil {
+		if !o.cdpNsPlug.engines.valid {
+			o.stats.errEngine++
+		}
+		d = append(d, cdpBuildTlvs(o.cdpNsPlug.engines.apply(o.init.Tlvs))...)
+	}
+
 	if (o.init.Options != nil) && (o.init.Options.Raw != nil) {
 		d = append(d, *(o.init.Options.Raw)...)
 	}
@@ -177,12 +284,19 @@ func (o *PluginCdpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginCdpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginCdpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, cdpEvents)
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.removeAllNeighbors()
+	o.cdpNsPlug.removeClient(o)
 }
 
 func (o *PluginCdpClient) restartTimer(sec uint32) {
@@ -203,14 +317,19 @@ func (o *PluginCdpClient) onTimerEvent() {
 // PluginCdpNs icmp information per namespace
 type PluginCdpNs struct {
 	core.PluginBase
-	stats CdpStats
+	stats   CdpStats
+	init    CdpNsInit
+	engines cdpEngines
+	clients []*PluginCdpClient
 }
 
 func NewCdpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 
 	o := new(PluginCdpNs)
+	fastjson.Unmarshal(initJson, &o.init)
 	o.InitPluginBase(ctx, o)
 	o.RegisterEvents(ctx, []string{}, o)
+	o.engines.create(o.Tctx, o.init.Engines)
 
 	return &o.PluginBase
 }
@@ -226,6 +345,88 @@ func (o *PluginCdpNs) SetTruncated() {
 
 }
 
+func (o *PluginCdpNs) removeClient(c *PluginCdpClient) {
+	for i, s := range o.clients {
+		if s == c {
+			o.clients = append(o.clients[:i], o.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxCdpPacket decode the CDP packet once and update the neighbor table of the clients, all the clients for
+// a multicast destination
+func (o *PluginCdpNs) HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	clients := o.clients
+	if !mackey.IsMulticast() {
+		client := o.Ns.CLookupByMac(&mackey)
+		if client == nil {
+			return core.PARSER_ERR
+		}
+		cplg := client.PluginCtx.Get(CDP_PLUG)
+		if cplg == nil {
+			return core.PARSER_ERR
+		}
+		clients = []*PluginCdpClient{cplg.Ext.(*PluginCdpClient)}
+	}
+
+	// the 802.3 length covers the LLC/SNAP header and the CDP packet, the rest is padding
+	var rec CdpNeighborRec
+	var key string
+	var err error
+	badCs := false
+	end := int(ps.L3) - 8 + int(binary.BigEndian.Uint16(p[ps.L3-10:ps.L3-8]))
+	if end < int(ps.L3)+cdpHeaderSize || end > len(p) {
+		err = fmt.Errorf("invalid 802.3 length")
+	} else if !cdpVerifyChecksum(p[ps.L3:end]) {
+		badCs = true
+	} else {
+		rec.SrcMac = net.HardwareAddr(p[6:12]).String()
+		key, err = cdpDecode(p[ps.L3:end], &rec)
+	}
+
+	for _, c := range clients {
+		if string(c.Client.Mac[:]) == string(p[6:12]) {
+			continue // our own packet
+		}
+		c.stats.pktRx++
+		if badCs {
+			c.stats.pktRxBadCs++
+			continue
+		}
+		if err != nil {
+			c.stats.pktRxErr++
+			continue
+		}
+		r := rec
+		r.Addr = append([]string(nil), rec.Addr...)
+		r.MgmtAddr = append([]string(nil), rec.MgmtAddr...)
+		c.onRxNeighbor(key, &r)
+	}
+	if badCs || err != nil {
+		return core.PARSER_ERR
+	}
+	return 0
+}
+
+// HandleRxCdpPacket Parser call this function with mbuf from the pool
+func HandleRxCdpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(CDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	cdpPlug := nsplg.Ext.(*PluginCdpNs)
+	return cdpPlug.HandleRxCdpPacket(ps)
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -244,6 +445,17 @@ func (o PluginCdpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.Pl
 /*  RPC commands */
 type (
 	ApiCdpClientCntHandler struct{}
+
+	ApiCdpClientNbrIterHandler struct{} // iterate on the neighbor table
+	ApiCdpClientNbrIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiCdpClientNbrIterResult struct {
+		Empty   bool             `json:"empty"`
+		Stopped bool             `json:"stopped"`
+		Vec     []CdpNeighborRec `json:"data"`
+	}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginCdpNs, *jsonrpc.Error) {
@@ -290,6 +502,51 @@ func (h ApiCdpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.R
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiCdpClientNbrIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiCdpClientNbrIterParams
+	var res ApiCdpClientNbrIterResult
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = c.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if c.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	nbrs, err := c.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res.Vec = nbrs
+	return &res, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -313,10 +570,13 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("cdp_client_cnt", ApiCdpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("cdp_client_cnt", ApiCdpClientCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("cdp_c_neighbors_iter", ApiCdpClientNbrIterHandler{}, false) // iterate the neighbors
 
+	/* register callback for rx side*/
+	core.ParserRegister("cdp", HandleRxCdpPacket)
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb("cdp")
 }
@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package cdp
+
+/*
+cdp rx side, the CDP packets of the DUT are decoded to a neighbor table per client.
+
+The neighbor is identified by the device ID and the port ID. The TTL of the packet restarts the aging timer of
+the neighbor, a TTL of zero removes it.
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+)
+
+const (
+	CDP_MAX_NEIGHBORS = 32 // default size of the neighbor table of a client
+
+	cdpHeaderSize = 4
+)
+
+var cdpIpv6Proto = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x86, 0xdd}
+
+// CdpNeighborRec is the RPC view of a neighbor
+type CdpNeighborRec struct {
+	Ver          uint8    `json:"ver"`
+	Ttl          uint8    `json:"ttl"`
+	DeviceId     string   `json:"device_id"`
+	PortId       string   `json:"port_id"`
+	Addr         []string `json:"addr,omitempty"`
+	Capabilities uint32   `json:"capabilities"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+	Platform     string   `json:"platform,omitempty"`
+	VtpDomain    string   `json:"vtp_domain,omitempty"`
+	NativeVlan   uint16   `json:"native_vlan,omitempty"`
+	FullDuplex   bool     `json:"full_duplex"`
+	MgmtAddr     []string `json:"mgmt_addr,omitempty"`
+	SrcMac       string   `json:"src_mac"`
+	RxPkts       uint64   `json:"rx_pkts"`
+	RemainSec    uint32   `json:"remain"`
+}
+
+// cdpDecodeAddr decode the addresses of an address TLV
+func cdpDecodeAddr(v []byte) ([]string, error) {
+	if len(v) < 4 {
+		return nil, fmt.Errorf("invalid address TLV")
+	}
+	n := binary.BigEndian.Uint32(v[0:4])
+	v = v[4:]
+	var r []string
+	for i := uint32(0); i < n; i++ {
+		// protocol type, protocol length, protocol, address length, address
+		if len(v) < 2 || len(v) < 2+int(v[1])+2 {
+			return nil, fmt.Errorf("address %v is truncated", i)
+		}
+		proto := v[2 : 2+int(v[1])]
+		v = v[2+len(proto):]
+		l := int(binary.BigEndian.Uint16(v[0:2]))
+		if len(v) < 2+l {
+			return nil, fmt.Errorf("address %v is truncated", i)
+		}
+		a := v[2 : 2+l]
+		v = v[2+l:]
+		if (len(proto) == 1 && proto[0] == 0xcc && l == 4) ||
+			(string(proto) == string(cdpIpv6Proto) && l == 16) {
+			r = append(r, net.IP(a).String())
+		} else {
+			r = append(r, hex.EncodeToString(a))
+		}
+	}
+	return r, nil
+}
+
+// cdpDecode decode the header and the TLVs of a CDP packet, returns the key of the neighbor
+func cdpDecode(d []byte, rec *CdpNeighborRec) (string, error) {
+	var err error
+	if len(d) < cdpHeaderSize {
+		return "", fmt.Errorf("packet is too short")
+	}
+	rec.Ver = d[0]
+	rec.Ttl = d[1]
+	devId := false
+	for v := d[cdpHeaderSize:]; len(v) > 0; {
+		if len(v) < 4 {
+			return "", fmt.Errorf("TLV is truncated")
+		}
+		t := layers.CDPTLVType(binary.BigEndian.Uint16(v[0:2]))
+		l := int(binary.BigEndian.Uint16(v[2:4]))
+		if l < 4 || len(v) < l {
+			return "", fmt.Errorf("TLV %v has invalid length %v", uint16(t), l)
+		}
+		val := v[4:l]
+		v = v[l:]
+
+		switch t {
+		case layers.CDPTLVDevID:
+			devId = true
+			rec.DeviceId = string(val)
+		case layers.CDPTLVPortID:
+			rec.PortId = string(val)
+		case layers.CDPTLVAddress:
+			if rec.Addr, err = cdpDecodeAddr(val); err != nil {
+				return "", err
+			}
+		case layers.CDPTLVMgmtAddresses:
+			if rec.MgmtAddr, err = cdpDecodeAddr(val); err != nil {
+				return "", err
+			}
+		case layers.CDPTLVCapabilities:
+			if len(val) < 4 {
+				return "", fmt.Errorf("invalid capabilities TLV")
+			}
+			rec.Capabilities = binary.BigEndian.Uint32(val[0:4])
+		case layers.CDPTLVVersion:
+			rec.SwVersion = string(val)
+		case layers.CDPTLVPlatform:
+			rec.Platform = string(val)
+		case layers.CDPTLVVTPDomain:
+			rec.VtpDomain = string(val)
+		case layers.CDPTLVNativeVLAN:
+			if len(val) < 2 {
+				return "", fmt.Errorf("invalid native VLAN TLV")
+			}
+			rec.NativeVlan = binary.BigEndian.Uint16(val[0:2])
+		case layers.CDPTLVFullDuplex:
+			if len(val) < 1 {
+				return "", fmt.Errorf("invalid duplex TLV")
+			}
+			rec.FullDuplex = val[0] == 1
+		}
+	}
+	if !devId {
+		return "", fmt.Errorf("missing device ID TLV")
+	}
+	return rec.DeviceId + "\x00" + rec.PortId, nil
+}
+
+// cdpVerifyChecksum verify the checksum of the CDP packet, the checksum field is cleared for the calculation
+func cdpVerifyChecksum(d []byte) bool {
+	cs := binary.BigEndian.Uint16(d[2:4])
+	binary.BigEndian.PutUint16(d[2:4], 0)
+	ok := layers.CdpChecksum(d, 0) == cs
+	binary.BigEndian.PutUint16(d[2:4], cs)
+	return ok
+}
+
+type PluginCdpNbrTimer struct {
+}
+
+func (o *PluginCdpNbrTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginCdpClient)
+	nbr := b.(*CdpNeighbor)
+	pi.onNeighborTimer(nbr)
+}
+
+// CdpNeighbor a neighbor that was learned from a CDP packet
+type CdpNeighbor struct {
+	dlist     core.DList
+	timer     core.CHTimerObj
+	key       string
+	expireTck uint64
+	rec       CdpNeighborRec
+}
+
+func covertToNeighbor(dlist *core.DList) *CdpNeighbor {
+	var s CdpNeighbor
+	return (*CdpNeighbor)(unsafe.Pointer(uintptr(unsafe.Pointer(dlist)) - unsafe.Offsetof(s.dlist)))
+}
+
+// onRxNeighbor update the table by the decoded packet
+func (o *PluginCdpClient) onRxNeighbor(key string, rec *CdpNeighborRec) {
+	nbr, ok := o.nbrs[key]
+	if rec.Ttl == 0 {
+		if ok {
+			o.stats.nbrShutdown++
+			o.removeNeighbor(nbr)
+		}
+		return
+	}
+	if !ok {
+		if len(o.nbrs) >= int(o.maxNbrs) {
+			o.stats.nbrTableFull++
+			return
+		}
+		nbr = new(CdpNeighbor)
+		nbr.key = key
+		nbr.timer.SetCB(&o.nbrTimerCb, o, nbr)
+		o.nbrs[key] = nbr
+		o.nbrHead.AddLast(&nbr.dlist)
+		o.stats.nbrAdd++
+		o.stats.nbrActive++
+	}
+	rec.RxPkts = nbr.rec.RxPkts + 1
+	nbr.rec = *rec
+
+	if nbr.timer.IsRunning() {
+		o.timerw.Stop(&nbr.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(rec.Ttl) * time.Second)
+	nbr.expireTck = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&nbr.timer, ticks)
+}
+
+func (o *PluginCdpClient) removeNeighbor(nbr *CdpNeighbor) {
+	if nbr.timer.IsRunning() {
+		o.timerw.Stop(&nbr.timer)
+	}
+	if o.activeIter == &nbr.dlist {
+		o.activeIter = nbr.dlist.Next()
+	}
+	o.nbrHead.RemoveNode(&nbr.dlist)
+	delete(o.nbrs, nbr.key)
+	o.stats.nbrActive--
+}
+
+func (o *PluginCdpClient) removeAllNeighbors() {
+	for !o.nbrHead.IsEmpty() {
+		o.removeNeighbor(covertToNeighbor(o.nbrHead.Next()))
+	}
+}
+
+func (o *PluginCdpClient) onNeighborTimer(nbr *CdpNeighbor) {
+	o.stats.nbrAged++
+	o.removeNeighbor(nbr)
+}
+
+func (o *PluginCdpClient) neighborRec(nbr *CdpNeighbor) CdpNeighborRec {
+	rec := nbr.rec
+	if nbr.expireTck > o.timerw.Ticks {
+		remain := time.Duration(nbr.expireTck-o.timerw.Ticks) * o.timerw.TickDuration
+		rec.RemainSec = uint32(remain / time.Second)
+	}
+	return rec
+}
+
+func (o *PluginCdpClient) IterReset() bool {
+	o.activeIter = o.nbrHead.Next()
+	if o.nbrHead.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginCdpClient) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginCdpClient) GetNext(n uint16) ([]CdpNeighborRec, error) {
+	r := make([]CdpNeighborRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.nbrHead {
+			o.iterReady = false // require a new reset
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.neighborRec(covertToNeighbor(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package cdp
+
+/*
+typed TLVs of the CDP packet, device ID, addresses, port ID, capabilities, version, platform, native VLAN,
+duplex, power consumption and power request.
+
+The field engines of the namespace vary the TLVs per client, each new client takes the next value of the engine
+with the name of the field.
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"net"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+// CdpPowerRequestT the power levels that the device can work with
+type CdpPowerRequestT struct {
+	RequestId uint16   `json:"request_id"`
+	MgmtId    uint16   `json:"mgmt_id"`
+	Power     []uint32 `json:"power"` // the power levels in mW
+}
+
+// CdpTlvsT typed TLVs of the CDP packet, a TLV is added only when its field is set
+type CdpTlvsT struct {
+	DeviceId     string            `json:"device_id"`
+	PortId       string            `json:"port_id"`
+	Addr         string            `json:"addr"`         // IPv4 or IPv6 address
+	Capabilities uint32            `json:"capabilities"` // 0x10 host, 0x80 phone
+	SwVersion    string            `json:"sw_version"`
+	Platform     string            `json:"platform"`
+	NativeVlan   uint16            `json:"native_vlan"`
+	FullDuplex   *bool             `json:"full_duplex"`
+	Power        uint16            `json:"power"` // power consumption in mW
+	PowerRequest *CdpPowerRequestT `json:"power_request"`
+}
+
+// CdpNsInit the init json of the namespace
+type CdpNsInit struct {
+	Engines *fastjson.RawMessage `json:"engines"` // field engines by the name of the TLV field
+}
+
+// cdpEngines the field engines of the namespace by the name of the field
+type cdpEngines struct {
+	engines map[string]engines.FieldEngineIF
+	str     map[string]bool // engines that generate a string
+	valid   bool
+}
+
+func (o *cdpEngines) create(tctx *core.CThreadCtx, data *fastjson.RawMessage) {
+	*o = cdpEngines{valid: true}
+	if data == nil {
+		return
+	}
+	var req []engines.FieldEngineRequest
+	mgr := engines.NewEngineManager(tctx, data)
+	if !mgr.WasCreatedSuccessfully() || fastjson.Unmarshal(*data, &req) != nil {
+		o.valid = false
+		return
+	}
+	o.engines = mgr.GetEngineMap()
+	o.str = make(map[string]bool)
+	for _, r := range req {
+		switch r.EngineType {
+		case "string_list", "histogram_string", "histogram_url":
+			o.str[r.EngineName] = true
+		}
+	}
+}
+
+// next the next value of the engine, nil if there is no engine for the field
+func (o *cdpEngines) next(name string) []byte {
+	eng, ok := o.engines[name]
+	if !ok {
+		return nil
+	}
+	b := make([]byte, eng.GetSize())
+	if _, err := eng.Update(b); err != nil {
+		return nil
+	}
+	return b
+}
+
+// nextUint the number of a numeric engine
+func (o *cdpEngines) nextUint(name string) (uint64, bool) {
+	b := o.next(name)
+	if b == nil || o.str[name] || len(b) > 8 {
+		return 0, false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+// nextString a string engine replaces the value, the number of a numeric engine is added to it
+func (o *cdpEngines) nextString(name string, s string) string {
+	if o.str[name] {
+		if b := o.next(name); b != nil {
+			return string(bytes.TrimRight(b, "\x00"))
+		}
+		return s
+	}
+	if v, ok := o.nextUint(name); ok {
+		return s + strconv.FormatUint(v, 10)
+	}
+	return s
+}
+
+// apply the engines of the namespace to a copy of the TLVs
+func (o *cdpEngines) apply(t *CdpTlvsT) *CdpTlvsT {
+	r := *t
+	if len(o.engines) == 0 {
+		return &r
+	}
+	r.DeviceId = o.nextString("device_id", r.DeviceId)
+	r.PortId = o.nextString("port_id", r.PortId)
+	r.SwVersion = o.nextString("sw_version", r.SwVersion)
+	r.Platform = o.nextString("platform", r.Platform)
+	if v, ok := o.nextUint("native_vlan"); ok {
+		r.NativeVlan = uint16(v)
+	}
+	return &r
+}
+
+// cdpAppendTlv append a TLV to the CDP packet
+func cdpAppendTlv(b []byte, t layers.CDPTLVType, v ...[]byte) []byte {
+	l := 4
+	for _, p := range v {
+		l += len(p)
+	}
+	if l > 0xffff {
+		return b // can't be encoded
+	}
+	b = append(b, uint8(t>>8), uint8(t), uint8(l>>8), uint8(l))
+	for _, p := range v {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// cdpAddr encode one address, NLPID for IPv4 and 802.2 for IPv6
+func cdpAddr(ip net.IP) []byte {
+	b := []byte{0, 0, 0, 1}
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, 1, 1, 0xcc, 0, 4)
+		return append(b, ip4...)
+	}
+	b = append(b, 2, uint8(len(cdpIpv6Proto)))
+	b = append(b, cdpIpv6Proto...)
+	b = append(b, 0, 16)
+	return append(b, ip.To16()...)
+}
+
+// cdpBuildTlvs build the typed TLVs
+func cdpBuildTlvs(t *CdpTlvsT) []byte {
+	var b []byte
+	u16 := func(v uint16) []byte {
+		return []byte{uint8(v >> 8), uint8(v)}
+	}
+	u32 := func(v uint32) []byte {
+		d := make([]byte, 4)
+		binary.BigEndian.PutUint32(d, v)
+		return d
+	}
+	if t.DeviceId != "" {
+		b = cdpAppendTlv(b, layers.CDPTLVDevID, []byte(t.DeviceId))
+	}
+	if ip := net.ParseIP(t.Addr); ip != nil {
+		b = cdpAppendTlv(b, layers.CDPTLVAddress, cdpAddr(ip))
+	}
+	if t.PortId != "" {
+		b = cdpAppendTlv(b, layers.CDPTLVPortID, []byte(t.PortId))
+	}
+	if t.Capabilities != 0 {
+		b = cdpAppendTlv(b, layers.CDPTLVCapabilities, u32(t.Capabilities))
+	}
+	if t.SwVersion != "" {
+		b = cdpAppendTlv(b, layers.CDPTLVVersion, []byte(t.SwVersion))
+	}
+	if t.Platform != "" {
+		b = cdpAppendTlv(b, layers.CDPTLVPlatform, []byte(t.Platform))
+	}
+	if t.NativeVlan != 0 {
+		b = cdpAppendTlv(b, layers.CDPTLVNativeVLAN, u16(t.NativeVlan))
+	}
+	if t.FullDuplex != nil {
+		d := []byte{0}
+		if *t.FullDuplex {
+			d[0] = 1
+		}
+		b = cdpAppendTlv(b, layers.CDPTLVFullDuplex, d)
+	}
+	if t.Power != 0 {
+		b = cdpAppendTlv(b, layers.CDPTLVPower, u16(t.Power))
+	}
+	if r := t.PowerRequest; r != nil {
+		d := append(u16(r.RequestId), u16(r.MgmtId)...)
+		for _, p := range r.Power {
+			d = append(d, u32(p)...)
+		}
+		b = cdpAppendTlv(b, layers.CDPTLVPowerRequested, d)
+	}
+	return b
+}
@@ -0,0 +1,1263 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpsrv
+
+/*
+RFC 2131 DHCP server
+
+The server is a client plugin, the client IPv4/MAC are the server identifier.
+The namespace plugin dispatches the packets to UDP port 67 to the server of the namespace,
+so one namespace acts as the DHCP server of the emulated or real clients behind it.
+
+client inijson {
+	"default_lease": 3600,  // sec, lease time in case the client did not ask for one
+	"min_lease": 60,        // sec, min lease time the client can ask for
+	"max_lease": 86400,     // sec, max lease time the client can ask for
+	"offer_hold": 60,       // sec, time an offered address is reserved
+	"next_server_ip": [0, 0, 0, 0],
+	"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 100], "prefix": 24, "exclude": [[16, 0, 0, 20]]}],
+	"options": {
+		"router": [16, 0, 0, 1],
+		"dns": [[8, 8, 8, 8]],
+		"domain": "trex.local",
+		"offer": [[type, data..]],  // raw options added to offer
+		"ack": [[type, data..]]     // raw options added to ack
+	},
+	"opt82": {"strip": false, "require": false}
+}
+
+The pool is selected by the relay agent address (giaddr) if there is one, otherwise by the
+server address. Option 82 (relay agent information) is echoed back in the reply (RFC 3046)
+unless strip is set; with require the requests without option 82 are dropped.
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCPSRV_PLUG = "dhcpsrv"
+
+	/* state of each lease */
+	DHCPSRV_LEASE_OFFERED  = 0
+	DHCPSRV_LEASE_BOUND    = 1
+	DHCPSRV_LEASE_DECLINED = 2
+
+	DHCP_OPT_RELAY_AGENT = 82
+
+	dhcpSrvDefaultLease = 3600
+	dhcpSrvMinLease     = 60
+	dhcpSrvMaxLease     = 86400
+	dhcpSrvOfferHold    = 60
+	dhcpBroadcastFlag   = 0x8000
+)
+
+var dhcpSrvLeaseStateNames = []string{"offered", "bound", "declined"}
+
+type DhcpSrvPool struct {
+	Min     core.Ipv4Key   `json:"min" validate:"required"`
+	Max     core.Ipv4Key   `json:"max" validate:"required"`
+	Prefix  uint8          `json:"prefix" validate:"required,gte=1,lte=32"`
+	Exclude []core.Ipv4Key `json:"exclude"`
+}
+
+type DhcpSrvOptions struct {
+	Router *core.Ipv4Key  `json:"router"`
+	Dns    []core.Ipv4Key `json:"dns"`
+	Domain string         `json:"domain"`
+	Offer  [][]byte       `json:"offer"`
+	Ack    [][]byte       `json:"ack"`
+}
+
+type DhcpSrvOpt82 struct {
+	Strip   bool `json:"strip"`   // do not echo option 82 in the reply
+	Require bool `json:"require"` // drop requests without option 82
+}
+
+type DhcpSrvInit struct {
+	DefaultLease uint32          `json:"default_lease"`
+	MinLease     uint32          `json:"min_lease"`
+	MaxLease     uint32          `json:"max_lease"`
+	OfferHold    uint32          `json:"offer_hold"`
+	NextServerIp core.Ipv4Key    `json:"next_server_ip"`
+	Pools        []DhcpSrvPool   `json:"pools" validate:"required,dive"`
+	Options      *DhcpSrvOptions `json:"options"`
+	Opt82        DhcpSrvOpt82    `json:"opt82"`
+}
+
+type DhcpSrvStats struct {
+	invalidInitJson   uint64
+	pktRxDiscover     uint64
+	pktRxRequest      uint64
+	pktRxRelease      uint64
+	pktRxDecline      uint64
+	pktRxInform       uint64
+	pktTxOffer        uint64
+	pktTxAck          uint64
+	pktTxNak          uint64
+	pktRxLenErr       uint64
+	pktRxParserErr    uint64
+	pktRxWrongHwType  uint64
+	pktRxUnhandled    uint64
+	pktRxOtherServer  uint64
+	pktRxNoOpt82      uint64
+	pktRxRelayed      uint64
+	poolEmpty         uint64
+	noPool            uint64
+	leaseExpired      uint64
+	leaseOfferExpired uint64
+	leaseBound        uint64
+	leaseActive       uint64
+}
+
+func NewDhcpSrvStatsDb(o *DhcpSrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DHCPSRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDiscover,
+		Name:     "pktRxDiscover",
+		Help:     "rx discover",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRequest,
+		Name:     "pktRxRequest",
+		Help:     "rx request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelease,
+		Name:     "pktRxRelease",
+		Help:     "rx release",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecline,
+		Name:     "pktRxDecline",
+		Help:     "rx decline, the address is in use",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInform,
+		Name:     "pktRxInform",
+		Help:     "rx inform",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxOffer,
+		Name:     "pktTxOffer",
+		Help:     "tx offer",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAck,
+		Name:     "pktTxAck",
+		Help:     "tx ack",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxNak,
+		Name:     "pktTxNak",
+		Help:     "tx nak",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLenErr,
+		Name:     "pktRxLenErr",
+		Help:     "len error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongHwType,
+		Name:     "pktRxWrongHwType",
+		Help:     "wrong hw type",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnhandled,
+		Name:     "pktRxUnhandled",
+		Help:     "unhandled dhcp packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxOtherServer,
+		Name:     "pktRxOtherServer",
+		Help:     "request to another server, the offer was released",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoOpt82,
+		Name:     "pktRxNoOpt82",
+		Help:     "drop, option 82 is required",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelayed,
+		Name:     "pktRxRelayed",
+		Help:     "rx from a relay agent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.poolEmpty,
+		Name:     "poolEmpty",
+		Help:     "no free address in the pool",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noPool,
+		Name:     "noPool",
+		Help:     "no pool for the subnet",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseExpired,
+		Name:     "leaseExpired",
+		Help:     "bound lease expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseOfferExpired,
+		Name:     "leaseOfferExpired",
+		Help:     "offer was not requested",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseBound,
+		Name:     "leaseBound",
+		Help:     "lease bound",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseActive,
+		Name:     "leaseActive",
+		Help:     "active leases",
+		Unit:     "leases",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// dhcpSrvPool is a range of addresses in a subnet
+type dhcpSrvPool struct {
+	min     uint32
+	max     uint32
+	mask    uint32
+	subnet  uint32
+	next    uint32
+	exclude map[uint32]bool
+}
+
+func (o *dhcpSrvPool) create(p *DhcpSrvPool) error {
+	o.min = p.Min.Uint32()
+	o.max = p.Max.Uint32()
+	o.mask = ^uint32(0) << (32 - uint32(p.Prefix))
+	o.subnet = o.min & o.mask
+	if o.max < o.min || (o.max&o.mask) != o.subnet {
+		return fmt.Errorf("pool %v-%v is not a range in a /%d subnet", p.Min, p.Max, p.Prefix)
+	}
+	o.next = o.min
+	o.exclude = make(map[uint32]bool)
+	for _, ip := range p.Exclude {
+		o.exclude[ip.Uint32()] = true
+	}
+	return nil
+}
+
+func (o *dhcpSrvPool) inSubnet(ip uint32) bool {
+	return (ip & o.mask) == o.subnet
+}
+
+func (o *dhcpSrvPool) inRange(ip uint32) bool {
+	return ip >= o.min && ip <= o.max && !o.exclude[ip]
+}
+
+func (o *dhcpSrvPool) size() uint32 {
+	return o.max - o.min + 1
+}
+
+type DhcpSrvLeaseTimer struct {
+}
+
+func (o *DhcpSrvLeaseTimer) OnEvent(a, b interface{}) {
+	srv := a.(*PluginDhcpSrvClient)
+	lease := b.(*DhcpSrvLease)
+	srv.onLeaseTimer(lease)
+}
+
+// DhcpSrvLease an address that was offered/bound/declined
+type DhcpSrvLease struct {
+	dlist     core.DList
+	timer     core.CHTimerObj
+	ipv4      core.Ipv4Key
+	mac       core.MACKey
+	clientId  string
+	hostname  string
+	giaddr    core.Ipv4Key
+	opt82     []byte
+	state     uint8
+	leaseSec  uint32
+	expireTck uint64
+}
+
+func covertToLease(dlist *core.DList) *DhcpSrvLease {
+	var s DhcpSrvLease
+	return (*DhcpSrvLease)(unsafe.Pointer(uintptr(unsafe.Pointer(dlist)) - unsafe.Offsetof(s.dlist)))
+}
+
+// DhcpSrvLeaseRec is the RPC view of a lease
+type DhcpSrvLeaseRec struct {
+	Ipv4      core.Ipv4Key `json:"ipv4"`
+	Mac       core.MACKey  `json:"mac"`
+	ClientId  string       `json:"client_id"`
+	Hostname  string       `json:"hostname"`
+	State     string       `json:"state"`
+	LeaseSec  uint32       `json:"lease"`
+	RemainSec uint32       `json:"remain"`
+	Giaddr    core.Ipv4Key `json:"giaddr"`
+	CircuitId string       `json:"circuit_id"`
+	RemoteId  string       `json:"remote_id"`
+}
+
+// dhcpSrvReq is a parsed request
+type dhcpSrvReq struct {
+	dhcph     layers.DHCPv4
+	msgType   layers.DHCPMsgType
+	srcMac    core.MACKey
+	clientKey string
+	reqIp     core.Ipv4Key
+	serverId  *core.Ipv4Key
+	leaseSec  uint32
+	hostname  string
+	opt82     []byte
+	giaddr    core.Ipv4Key
+	ciaddr    core.Ipv4Key
+}
+
+// PluginDhcpSrvClient the DHCP server, per client
+type PluginDhcpSrvClient struct {
+	core.PluginBase
+	timerw     *core.TimerCtx
+	nsPlug     *PluginDhcpSrvNs
+	init       DhcpSrvInit
+	pools      []dhcpSrvPool
+	leaseByIp  map[core.Ipv4Key]*DhcpSrvLease
+	leaseByKey map[string]*DhcpSrvLease
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
+	timerCb    DhcpSrvLeaseTimer
+	stats      DhcpSrvStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	valid      bool
+}
+
+var dhcpSrvEvents = []string{}
+
+/*NewDhcpSrvClient create plugin */
+func NewDhcpSrvClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpSrvClient)
+	o.InitPluginBase(ctx, o)                /* init base object*/
+	o.RegisterEvents(ctx, dhcpSrvEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCPSRV_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginDhcpSrvNs)
+	o.OnCreate()
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.pools = make([]dhcpSrvPool, len(o.init.Pools))
+	for i := range o.init.Pools {
+		if o.pools[i].create(&o.init.Pools[i]) != nil {
+			o.stats.invalidInitJson++
+			return &o.PluginBase
+		}
+	}
+	if o.init.DefaultLease == 0 {
+		o.init.DefaultLease = dhcpSrvDefaultLease
+	}
+	if o.init.MinLease == 0 {
+		o.init.MinLease = dhcpSrvMinLease
+	}
+	if o.init.MaxLease == 0 {
+		o.init.MaxLease = dhcpSrvMaxLease
+	}
+	if o.init.OfferHold == 0 {
+		o.init.OfferHold = dhcpSrvOfferHold
+	}
+	o.valid = true
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpSrvClient) OnCreate() {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.leaseByIp = make(map[core.Ipv4Key]*DhcpSrvLease)
+	o.leaseByKey = make(map[string]*DhcpSrvLease)
+	o.head.SetSelf()
+	o.nsPlug.servers = append(o.nsPlug.servers, o)
+	o.cdb = NewDhcpSrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DHCPSRV_PLUG)
+	o.cdbv.Add(o.cdb)
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginDhcpSrvClient) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDhcpSrvClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpSrvClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dhcpSrvEvents)
+	o.nsPlug.removeServer(o)
+	for _, lease := range o.leaseByIp {
+		if lease.timer.IsRunning() {
+			o.timerw.Stop(&lease.timer)
+		}
+	}
+}
+
+// selectPool select the pool by the relay address or by the server address
+func (o *PluginDhcpSrvClient) selectPool(giaddr core.Ipv4Key) *dhcpSrvPool {
+	addr := giaddr.Uint32()
+	if addr == 0 {
+		addr = o.Client.Ipv4.Uint32()
+	}
+	for i := range o.pools {
+		if o.pools[i].inSubnet(addr) {
+			return &o.pools[i]
+		}
+	}
+	if giaddr.IsZero() && len(o.pools) > 0 {
+		return &o.pools[0]
+	}
+	return nil
+}
+
+func (o *PluginDhcpSrvClient) isFree(pool *dhcpSrvPool, ip uint32) bool {
+	var key core.Ipv4Key
+	key.SetUint32(ip)
+	if !pool.inRange(ip) || ip == o.Client.Ipv4.Uint32() {
+		return false
+	}
+	_, ok := o.leaseByIp[key]
+	return !ok
+}
+
+func (o *PluginDhcpSrvClient) allocate(pool *dhcpSrvPool, req *dhcpSrvReq) (core.Ipv4Key, bool) {
+	var key core.Ipv4Key
+	/* try the address the client asked for */
+	if !req.reqIp.IsZero() && o.isFree(pool, req.reqIp.Uint32()) {
+		return req.reqIp, true
+	}
+	for i := uint32(0); i < pool.size(); i++ {
+		ip := pool.next
+		if pool.next == pool.max {
+			pool.next = pool.min
+		} else {
+			pool.next++
+		}
+		if o.isFree(pool, ip) {
+			key.SetUint32(ip)
+			return key, true
+		}
+	}
+	return key, false
+}
+
+func (o *PluginDhcpSrvClient) startLeaseTimer(lease *DhcpSrvLease, sec uint32) {
+	if lease.timer.IsRunning() {
+		o.timerw.Stop(&lease.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(sec) * time.Second)
+	lease.expireTck = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&lease.timer, ticks)
+}
+
+func (o *PluginDhcpSrvClient) addLease(ipv4 core.Ipv4Key, req *dhcpSrvReq) *DhcpSrvLease {
+	lease := new(DhcpSrvLease)
+	lease.ipv4 = ipv4
+	lease.mac = req.srcMac
+	copy(lease.mac[:], req.dhcph.ClientHWAddr)
+	lease.clientId = req.clientKey
+	lease.timer.SetCB(&o.timerCb, o, lease)
+	o.leaseByIp[ipv4] = lease
+	if lease.clientId != "" {
+		o.leaseByKey[lease.clientId] = lease
+	}
+	o.head.AddLast(&lease.dlist)
+	o.stats.leaseActive++
+	return lease
+}
+
+func (o *PluginDhcpSrvClient) removeLease(lease *DhcpSrvLease) {
+	if lease.timer.IsRunning() {
+		o.timerw.Stop(&lease.timer)
+	}
+	if o.activeIter == &lease.dlist {
+		o.activeIter = lease.dlist.Next()
+	}
+	o.head.RemoveNode(&lease.dlist)
+	delete(o.leaseByIp, lease.ipv4)
+	if lease.clientId != "" {
+		if l, ok := o.leaseByKey[lease.clientId]; ok && l == lease {
+			delete(o.leaseByKey, lease.clientId)
+		}
+	}
+	o.stats.leaseActive--
+}
+
+func (o *PluginDhcpSrvClient) onLeaseTimer(lease *DhcpSrvLease) {
+	switch lease.state {
+	case DHCPSRV_LEASE_OFFERED:
+		o.stats.leaseOfferExpired++
+	case DHCPSRV_LEASE_BOUND:
+		o.stats.leaseExpired++
+	}
+	o.removeLease(lease)
+}
+
+func (o *PluginDhcpSrvClient) leaseTime(req *dhcpSrvReq) uint32 {
+	sec := o.init.DefaultLease
+	if req.leaseSec > 0 {
+		sec = req.leaseSec
+	}
+	if sec < o.init.MinLease {
+		sec = o.init.MinLease
+	}
+	if sec > o.init.MaxLease {
+		sec = o.init.MaxLease
+	}
+	return sec
+}
+
+func uint32Opt(t layers.DHCPOpt, v uint32) layers.DHCPOption {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return layers.NewDHCPOption(t, b[:])
+}
+
+// sendReply build the reply and send it to the client or to the relay agent
+func (o *PluginDhcpSrvClient) sendReply(req *dhcpSrvReq,
+	msgType layers.DHCPMsgType,
+	yiaddr core.Ipv4Key,
+	leaseSec uint32,
+	pool *dhcpSrvPool) {
+
+	dhcph := &layers.DHCPv4{Operation: layers.DHCPOpReply,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		Xid:          req.dhcph.Xid,
+		Flags:        req.dhcph.Flags,
+		ClientIP:     net.IP{0, 0, 0, 0},
+		YourClientIP: yiaddr.ToIP(),
+		NextServerIP: net.IP{0, 0, 0, 0},
+		RelayAgentIP: req.giaddr.ToIP(),
+		ClientHWAddr: req.dhcph.ClientHWAddr,
+		ServerName:   make([]byte, 64), File: make([]byte, 128)}
+
+	if msgType != layers.DHCPMsgTypeNak {
+		dhcph.ClientIP = req.ciaddr.ToIP()
+		dhcph.NextServerIP = o.init.NextServerIp.ToIP()
+	}
+
+	dhcph.Options = append(dhcph.Options,
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(msgType)}),
+		layers.NewDHCPOption(layers.DHCPOptServerID, o.Client.Ipv4[:]))
+
+	if msgType != layers.DHCPMsgTypeNak {
+		if leaseSec > 0 {
+			dhcph.Options = append(dhcph.Options,
+				uint32Opt(layers.DHCPOptLeaseTime, leaseSec),
+				uint32Opt(layers.DHCPOptT1, leaseSec/2),
+				uint32Opt(layers.DHCPOptT2, uint32(uint64(leaseSec)*7/8)))
+		}
+		if pool != nil {
+			dhcph.Options = append(dhcph.Options, uint32Opt(layers.DHCPOptSubnetMask, pool.mask))
+		}
+		if opts := o.init.Options; opts != nil {
+			if opts.Router != nil {
+				dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptRouter, opts.Router[:]))
+			}
+			if len(opts.Dns) > 0 {
+				var dns []byte
+				for _, ip := range opts.Dns {
+					dns = append(dns, ip[:]...)
+				}
+				dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptDNS, dns))
+			}
+			if opts.Domain != "" {
+				dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOptDomainName, []byte(opts.Domain)))
+			}
+			raw := opts.Ack
+			if msgType == layers.DHCPMsgTypeOffer {
+				raw = opts.Offer
+			}
+			for _, op := range raw {
+				if len(op) > 0 {
+					dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOpt(op[0]), op[1:]))
+				}
+			}
+		}
+	}
+
+	if req.opt82 != nil && !o.init.Opt82.Strip {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOpt(DHCP_OPT_RELAY_AGENT), req.opt82))
+	}
+
+	/* destination, RFC 2131 4.1 */
+	var dstIp uint32
+	dstMac := req.srcMac
+	dstPort := layers.UDPPort(68)
+	broadcast := false
+	switch {
+	case !req.giaddr.IsZero():
+		dstIp = req.giaddr.Uint32()
+		dstPort = 67
+	case msgType == layers.DHCPMsgTypeNak:
+		broadcast = true
+	case !req.ciaddr.IsZero():
+		dstIp = req.ciaddr.Uint32()
+	case req.dhcph.Flags&dhcpBroadcastFlag != 0:
+		broadcast = true
+	default:
+		dstIp = yiaddr.Uint32()
+		copy(dstMac[:], req.dhcph.ClientHWAddr)
+	}
+	if broadcast {
+		dstIp = 0xffffffff
+		dstMac = core.MACKey{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+
+	l2 := o.Client.GetL2Header(broadcast, uint16(layers.EthernetTypeIPv4))
+	copy(l2[0:6], dstMac[:])
+
+	d := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc,
+			SrcIP:    o.Client.Ipv4.ToIP(),
+			DstIP:    net.IPv4(0, 0, 0, 0),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: 67, DstPort: dstPort},
+		dhcph,
+	)
+
+	ipv4 := layers.IPv4Header(d[0:20])
+	ipv4.SetIPDst(dstIp)
+	ipv4.SetLength(uint16(len(d)))
+	ipv4.UpdateChecksum()
+
+	binary.BigEndian.PutUint16(d[24:26], uint16(len(d)-20))
+	binary.BigEndian.PutUint16(d[26:28], 0)
+	cs := layers.PktChecksumTcpUdp(d[20:], 0, ipv4)
+	binary.BigEndian.PutUint16(d[26:28], cs)
+
+	switch msgType {
+	case layers.DHCPMsgTypeOffer:
+		o.stats.pktTxOffer++
+	case layers.DHCPMsgTypeAck:
+		o.stats.pktTxAck++
+	case layers.DHCPMsgTypeNak:
+		o.stats.pktTxNak++
+	}
+	o.Tctx.Veth.SendBuffer(false, o.Client, append(l2, d...))
+}
+
+func (o *PluginDhcpSrvClient) handleDiscover(req *dhcpSrvReq) {
+	pool := o.selectPool(req.giaddr)
+	if pool == nil {
+		o.stats.noPool++
+		return
+	}
+
+	lease, ok := o.leaseByKey[req.clientKey]
+	if ok && lease.state != DHCPSRV_LEASE_DECLINED && pool.inSubnet(lease.ipv4.Uint32()) {
+		/* offer the same address again */
+		if lease.state == DHCPSRV_LEASE_OFFERED {
+			o.startLeaseTimer(lease, o.init.OfferHold)
+		}
+	} else {
+		ipv4, found := o.allocate(pool, req)
+		if !found {
+			o.stats.poolEmpty++
+			return
+		}
+		if ok && lease.state != DHCPSRV_LEASE_DECLINED {
+			o.removeLease(lease)
+		}
+		lease = o.addLease(ipv4, req)
+		lease.state = DHCPSRV_LEASE_OFFERED
+		o.startLeaseTimer(lease, o.init.OfferHold)
+	}
+	lease.leaseSec = o.leaseTime(req)
+	lease.hostname = req.hostname
+	lease.giaddr = req.giaddr
+	lease.opt82 = req.opt82
+	o.sendReply(req, layers.DHCPMsgTypeOffer, lease.ipv4, lease.leaseSec, pool)
+}
+
+func (o *PluginDhcpSrvClient) bind(req *dhcpSrvReq, lease *DhcpSrvLease, pool *dhcpSrvPool) {
+	if lease.state != DHCPSRV_LEASE_BOUND {
+		o.stats.leaseBound++
+	}
+	lease.state = DHCPSRV_LEASE_BOUND
+	lease.leaseSec = o.leaseTime(req)
+	lease.giaddr = req.giaddr
+	lease.opt82 = req.opt82
+	if req.hostname != "" {
+		lease.hostname = req.hostname
+	}
+	o.startLeaseTimer(lease, lease.leaseSec)
+	o.sendReply(req, layers.DHCPMsgTypeAck, lease.ipv4, lease.leaseSec, pool)
+}
+
+func (o *PluginDhcpSrvClient) handleRequest(req *dhcpSrvReq) {
+	lease, ok := o.leaseByKey[req.clientKey]
+	if ok && lease.state == DHCPSRV_LEASE_DECLINED {
+		ok = false
+	}
+
+	if req.serverId != nil {
+		/* SELECTING */
+		if *req.serverId != o.Client.Ipv4 {
+			/* the client selected another server */
+			o.stats.pktRxOtherServer++
+			if ok && lease.state == DHCPSRV_LEASE_OFFERED {
+				o.removeLease(lease)
+			}
+			return
+		}
+		if !ok || lease.ipv4 != req.reqIp {
+			o.sendReply(req, layers.DHCPMsgTypeNak, core.Ipv4Key{}, 0, nil)
+			return
+		}
+		o.bind(req, lease, o.selectPool(req.giaddr))
+		return
+	}
+
+	if req.ciaddr.IsZero() {
+		/* INIT-REBOOT */
+		pool := o.selectPool(req.giaddr)
+		if ok && lease.ipv4 == req.reqIp {
+			o.bind(req, lease, pool)
+			return
+		}
+		if pool == nil || !pool.inSubnet(req.reqIp.Uint32()) || ok {
+			o.sendReply(req, layers.DHCPMsgTypeNak, core.Ipv4Key{}, 0, nil)
+		}
+		/* no record of this client, remain silent */
+		return
+	}
+
+	/* RENEWING / REBINDING */
+	if ok && lease.ipv4 == req.ciaddr && lease.state == DHCPSRV_LEASE_BOUND {
+		o.bind(req, lease, o.selectPool(req.giaddr))
+		return
+	}
+	o.sendReply(req, layers.DHCPMsgTypeNak, core.Ipv4Key{}, 0, nil)
+}
+
+func (o *PluginDhcpSrvClient) handleRelease(req *dhcpSrvReq) {
+	lease, ok := o.leaseByKey[req.clientKey]
+	if ok && lease.ipv4 == req.ciaddr && lease.state != DHCPSRV_LEASE_DECLINED {
+		o.removeLease(lease)
+	}
+}
+
+func (o *PluginDhcpSrvClient) handleDecline(req *dhcpSrvReq) {
+	lease, ok := o.leaseByIp[req.reqIp]
+	if !ok || lease.clientId != req.clientKey {
+		return
+	}
+	/* the address is used by someone else, keep it out of the pool for a while */
+	if lease.clientId != "" {
+		delete(o.leaseByKey, lease.clientId)
+	}
+	lease.clientId = ""
+	lease.state = DHCPSRV_LEASE_DECLINED
+	o.startLeaseTimer(lease, o.init.DefaultLease)
+}
+
+func (o *PluginDhcpSrvClient) handleInform(req *dhcpSrvReq) {
+	if req.ciaddr.IsZero() {
+		o.stats.pktRxUnhandled++
+		return
+	}
+	var pool *dhcpSrvPool
+	for i := range o.pools {
+		if o.pools[i].inSubnet(req.ciaddr.Uint32()) {
+			pool = &o.pools[i]
+		}
+	}
+	o.sendReply(req, layers.DHCPMsgTypeAck, core.Ipv4Key{}, 0, pool)
+}
+
+func (o *PluginDhcpSrvClient) parseReq(ps *core.ParserPacketState, req *dhcpSrvReq) int {
+	p := ps.M.GetData()
+	dhcphlen := ps.L7Len
+
+	if dhcphlen < 240 {
+		o.stats.pktRxLenErr++
+		return core.PARSER_ERR
+	}
+
+	err := req.dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	if req.dhcph.Operation != layers.DHCPOpRequest {
+		o.stats.pktRxUnhandled++
+		return core.PARSER_ERR
+	}
+
+	if req.dhcph.HardwareType != layers.LinkTypeEthernet || req.dhcph.HardwareLen != 6 ||
+		len(req.dhcph.ClientHWAddr) != 6 {
+		o.stats.pktRxWrongHwType++
+		return core.PARSER_ERR
+	}
+
+	copy(req.srcMac[:], p[6:12])
+	req.clientKey = string(req.dhcph.ClientHWAddr)
+	if ip := req.dhcph.ClientIP.To4(); ip != nil {
+		copy(req.ciaddr[:], ip)
+	}
+	if ip := req.dhcph.RelayAgentIP.To4(); ip != nil {
+		copy(req.giaddr[:], ip)
+	}
+	req.msgType = layers.DHCPMsgTypeUnspecified
+
+	for _, op := range req.dhcph.Options {
+		switch op.Type {
+		case layers.DHCPOptMessageType:
+			if len(op.Data) == 1 {
+				req.msgType = layers.DHCPMsgType(op.Data[0])
+			}
+		case layers.DHCPOptClientID:
+			if len(op.Data) > 0 {
+				req.clientKey = string(op.Data)
+			}
+		case layers.DHCPOptRequestIP:
+			if len(op.Data) == 4 {
+				copy(req.reqIp[:], op.Data)
+			}
+		case layers.DHCPOptServerID:
+			if len(op.Data) == 4 {
+				var server core.Ipv4Key
+				copy(server[:], op.Data)
+				req.serverId = &server
+			}
+		case layers.DHCPOptLeaseTime:
+			if len(op.Data) == 4 {
+				req.leaseSec = binary.BigEndian.Uint32(op.Data)
+			}
+		case layers.DHCPOptHostname:
+			req.hostname = string(op.Data)
+		case layers.DHCPOpt(DHCP_OPT_RELAY_AGENT):
+			req.opt82 = append([]byte{}, op.Data...)
+		}
+	}
+	return core.PARSER_OK
+}
+
+func (o *PluginDhcpSrvClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
+
+	if !o.valid {
+		return core.PARSER_ERR
+	}
+
+	var req dhcpSrvReq
+	if o.parseReq(ps, &req) != core.PARSER_OK {
+		return core.PARSER_ERR
+	}
+
+	if req.opt82 == nil && o.init.Opt82.Require {
+		o.stats.pktRxNoOpt82++
+		return core.PARSER_ERR
+	}
+	if !req.giaddr.IsZero() {
+		o.stats.pktRxRelayed++
+	}
+
+	switch req.msgType {
+	case layers.DHCPMsgTypeDiscover:
+		o.stats.pktRxDiscover++
+		o.handleDiscover(&req)
+	case layers.DHCPMsgTypeRequest:
+		o.stats.pktRxRequest++
+		o.handleRequest(&req)
+	case layers.DHCPMsgTypeRelease:
+		o.stats.pktRxRelease++
+		o.handleRelease(&req)
+	case layers.DHCPMsgTypeDecline:
+		o.stats.pktRxDecline++
+		o.handleDecline(&req)
+	case layers.DHCPMsgTypeInform:
+		o.stats.pktRxInform++
+		o.handleInform(&req)
+	default:
+		o.stats.pktRxUnhandled++
+	}
+	return core.PARSER_OK
+}
+
+// opt82SubOption return a sub option of the relay agent information option
+func opt82SubOption(opt82 []byte, code byte) []byte {
+	for i := 0; i+2 <= len(opt82); {
+		l := int(opt82[i+1])
+		if i+2+l > len(opt82) {
+			return nil
+		}
+		if opt82[i] == code {
+			return opt82[i+2 : i+2+l]
+		}
+		i += 2 + l
+	}
+	return nil
+}
+
+func (o *PluginDhcpSrvClient) leaseRec(lease *DhcpSrvLease) DhcpSrvLeaseRec {
+	var rec DhcpSrvLeaseRec
+	rec.Ipv4 = lease.ipv4
+	rec.Mac = lease.mac
+	if lease.clientId != "" && lease.clientId != string(lease.mac[:]) {
+		rec.ClientId = hex.EncodeToString([]byte(lease.clientId))
+	}
+	rec.Hostname = lease.hostname
+	rec.State = dhcpSrvLeaseStateNames[lease.state]
+	rec.LeaseSec = lease.leaseSec
+	if lease.expireTck > o.timerw.Ticks {
+		remain := time.Duration(lease.expireTck-o.timerw.Ticks) * o.timerw.TickDuration
+		rec.RemainSec = uint32(remain / time.Second)
+	}
+	rec.Giaddr = lease.giaddr
+	rec.CircuitId = string(opt82SubOption(lease.opt82, 1))
+	rec.RemoteId = string(opt82SubOption(lease.opt82, 2))
+	return rec
+}
+
+func (o *PluginDhcpSrvClient) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginDhcpSrvClient) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginDhcpSrvClient) GetNext(n uint16) ([]DhcpSrvLeaseRec, error) {
+	r := make([]DhcpSrvLeaseRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false // require a new reset
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.leaseRec(covertToLease(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
+
+// PluginDhcpSrvNs dispatch the rx packets to the server of the namespace
+type PluginDhcpSrvNs struct {
+	core.PluginBase
+	servers []*PluginDhcpSrvClient
+}
+
+func NewDhcpSrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpSrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpSrvNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginDhcpSrvNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// getServer return the server that should handle the packet, by the destination MAC/IPv4 or the first server for broadcast
+func (o *PluginDhcpSrvNs) getServer(ps *core.ParserPacketState) *PluginDhcpSrvClient {
+	p := ps.M.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	if !mackey.IsBroadcast() {
+		client := o.Ns.CLookupByMac(&mackey)
+		if client == nil {
+			return nil
+		}
+		cplg := client.PluginCtx.Get(DHCPSRV_PLUG)
+		if cplg == nil {
+			return nil
+		}
+		return cplg.Ext.(*PluginDhcpSrvClient)
+	}
+
+	if len(o.servers) == 0 {
+		return nil
+	}
+	return o.servers[0]
+}
+
+func (o *PluginDhcpSrvNs) removeServer(srv *PluginDhcpSrvClient) {
+	for i, s := range o.servers {
+		if s == srv {
+			o.servers = append(o.servers[:i], o.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (o *PluginDhcpSrvNs) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
+	srv := o.getServer(ps)
+	if srv == nil {
+		return core.PARSER_ERR
+	}
+	return srv.HandleRxDhcpPacket(ps)
+}
+
+// HandleRxDhcpSrvPacket Parser call this function with mbuf from the pool
+func HandleRxDhcpSrvPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCPSRV_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	dhcpPlug := nsplg.Ext.(*PluginDhcpSrvNs)
+	return dhcpPlug.HandleRxDhcpPacket(ps)
+}
+
+type PluginDhcpSrvCReg struct{}
+type PluginDhcpSrvNsReg struct{}
+
+func (o PluginDhcpSrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpSrvClient(ctx, initJson)
+}
+
+func (o PluginDhcpSrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpSrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpSrvClientCntHandler struct{}
+
+	ApiDhcpSrvClientIterHandler struct{} // iterate on the lease table
+	ApiDhcpSrvClientIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiDhcpSrvClientIterResult struct {
+		Empty   bool              `json:"empty"`
+		Stopped bool              `json:"stopped"`
+		Vec     []DhcpSrvLeaseRec `json:"data"`
+	}
+)
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpSrvClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, DHCPSRV_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginDhcpSrvClient)
+
+	return pClient, nil
+}
+
+func (h ApiDhcpSrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiDhcpSrvClientIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiDhcpSrvClientIterParams
+	var res ApiDhcpSrvClientIterResult
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = c.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if c.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	leases, err := c.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res.Vec = leases
+	return &res, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DHCPSRV_PLUG,
+		core.PluginRegisterData{Client: PluginDhcpSrvCReg{},
+			Ns:     PluginDhcpSrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("dhcpsrv_c_cnt", ApiDhcpSrvClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("dhcpsrv_c_iter", ApiDhcpSrvClientIterHandler{}, false) // iterate the leases
+
+	/* register callback for rx side*/
+	core.ParserRegister("dhcpsrv", HandleRxDhcpSrvPacket)
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb("dhcpsrv")
+}
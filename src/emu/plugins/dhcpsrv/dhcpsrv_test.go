@@ -0,0 +1,211 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpsrv
+
+import (
+	"emu/core"
+	_ "emu/plugins/dhcpv4" // dhcp client plugin
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+type DhcpSrvTestBase struct {
+	testname     string
+	monitor      bool
+	capture      bool
+	duration     time.Duration
+	clientsToSim int
+	srvJson      string
+	clientJson   string
+	cb           DhcpSrvTestCb
+}
+
+type DhcpSrvTestCb func(tctx *core.CThreadCtx, srv *PluginDhcpSrvClient, t *testing.T)
+
+// VethDhcpSrvSim loop back the tx packets, the server and the clients are in the same namespace
+type VethDhcpSrvSim struct {
+	tctx *core.CThreadCtx
+}
+
+func (o *VethDhcpSrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	mr := o.tctx.MPool.Alloc(uint16(len(p)))
+	mr.SetVPort(m.VPort())
+	mr.Append(p)
+	m.FreeMbuf()
+	return mr
+}
+
+func (o *DhcpSrvTestBase) Run(t *testing.T) {
+
+	var simVeth VethDhcpSrvSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx, srv := createSimulationEnv(&simrx, o)
+	simVeth.tctx = tctx
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+	defer tctx.Delete()
+
+	if o.cb != nil {
+		o.cb(tctx, srv, t)
+	}
+
+	srv.cdbv.Dump()
+	tctx.GetCounterDbVec().Dump()
+	tctx.SimRecordAppend(srv.cdb.MarshalValues(false))
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func createSimulationEnv(simRx *core.VethIFSim, test *DhcpSrvTestBase) (*core.CThreadCtx, *PluginDhcpSrvClient) {
+	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	server := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{16, 0, 0, 1},
+		core.Ipv6Key{},
+		core.Ipv4Key{16, 0, 0, 1})
+	ns.AddClient(server)
+	ns.PluginCtx.CreatePlugins([]string{"dhcp"}, [][]byte{})
+	server.PluginCtx.CreatePlugins([]string{DHCPSRV_PLUG}, [][]byte{[]byte(test.srvJson)})
+
+	for i := 0; i < test.clientsToSim; i++ {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(2 + i)},
+			core.Ipv4Key{0, 0, 0, 0},
+			core.Ipv6Key{},
+			core.Ipv4Key{0, 0, 0, 0})
+		ns.AddClient(client)
+		var inijson [][]byte
+		if test.clientJson != "" {
+			inijson = [][]byte{[]byte(test.clientJson)}
+		}
+		client.PluginCtx.CreatePlugins([]string{"dhcp"}, inijson)
+	}
+	tctx.RegisterParserCb("dhcp")
+	tctx.RegisterParserCb("dhcpsrv")
+
+	cplg := server.PluginCtx.Get(DHCPSRV_PLUG)
+	if cplg == nil {
+		panic(" can't find plugin")
+	}
+	return tctx, cplg.Ext.(*PluginDhcpSrvClient)
+}
+
+func dhcpSrvClientIp(tctx *core.CThreadCtx, i int) core.Ipv4Key {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	c := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, byte(2 + i)})
+	return c.Ipv4
+}
+
+func TestPluginDhcpSrv1(t *testing.T) {
+	a := &DhcpSrvTestBase{
+		testname:     "dhcpsrv1",
+		monitor:      false,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		srvJson: `{"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 20], "prefix": 24, "exclude": [[16, 0, 0, 10]]}],
+		           "options": {"router": [16, 0, 0, 1], "dns": [[8, 8, 8, 8]], "domain": "trex.local"}}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpSrvClient, t *testing.T) {
+			for i, exp := range []core.Ipv4Key{{16, 0, 0, 11}, {16, 0, 0, 12}} {
+				if ip := dhcpSrvClientIp(tctx, i); ip != exp {
+					t.Fatalf(" client %d got %v expected %v \n", i, ip, exp)
+				}
+			}
+			if srv.IterReset() {
+				t.Fatalf(" lease table is empty \n")
+			}
+			leases, _ := srv.GetNext(10)
+			if len(leases) != 2 || leases[0].State != "bound" || leases[0].LeaseSec != dhcpSrvDefaultLease {
+				t.Fatalf(" unexpected leases %+v \n", leases)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* short lease, the clients renew it */
+func TestPluginDhcpSrv2(t *testing.T) {
+	a := &DhcpSrvTestBase{
+		testname:     "dhcpsrv2",
+		monitor:      false,
+		capture:      true,
+		duration:     3 * time.Minute,
+		clientsToSim: 1,
+		srvJson:      `{"default_lease": 60, "pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 20], "prefix": 24}]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpSrvClient, t *testing.T) {
+			if srv.stats.pktTxAck < 3 || srv.stats.leaseActive != 1 {
+				t.Fatalf(" lease was not renewed, acks %d \n", srv.stats.pktTxAck)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the pool has one address */
+func TestPluginDhcpSrvPoolEmpty(t *testing.T) {
+	a := &DhcpSrvTestBase{
+		testname:     "dhcpsrv3",
+		monitor:      false,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		srvJson:      `{"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 10], "prefix": 24}]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpSrvClient, t *testing.T) {
+			if srv.stats.poolEmpty == 0 || srv.stats.leaseActive != 1 {
+				t.Fatalf(" expected pool empty \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginDhcpSrvInvalidJson(t *testing.T) {
+	a := &DhcpSrvTestBase{
+		testname:     "dhcpsrv4",
+		monitor:      false,
+		capture:      true,
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+		srvJson:      `{"pools": [{"min": [16, 0, 0, 20], "max": [16, 0, 0, 10], "prefix": 24}]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpSrvClient, t *testing.T) {
+			if srv.stats.invalidInitJson != 1 || srv.stats.pktTxOffer != 0 {
+				t.Fatalf(" invalid pool was accepted \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestDhcpSrvOpt82SubOption(t *testing.T) {
+	opt82 := []byte{1, 3, 'e', 't', '0', 2, 2, 'r', '1'}
+	if s := string(opt82SubOption(opt82, 1)); s != "et0" {
+		t.Fatalf(" circuit id %s \n", s)
+	}
+	if s := string(opt82SubOption(opt82, 2)); s != "r1" {
+		t.Fatalf(" remote id %s \n", s)
+	}
+	if opt82SubOption(opt82[:4], 2) != nil {
+		t.Fatalf(" short option \n")
+	}
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -512,6 +512,11 @@ func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDhcpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	o.SendRenewRebind(false, true, 0)
@@ -1032,4 +1037,5 @@ func init() {
 
 func Register(ctx *core.CThreadCtx) {
 	ctx.RegisterParserCb("dhcp")
+	ctx.RegisterParserCb("dhcprelay")
 }
@@ -7,6 +7,7 @@ package dhcp
 
 import (
 	"emu/core"
+	"emu/plugins/dhcpsrv"
 	"encoding/binary"
 	"encoding/hex"
 	"external/google/gopacket"
@@ -346,6 +347,88 @@ func TestPluginDhcp2(t *testing.T) {
 	//err := dhcph.DecodeFromBytes(b[off:], gopacket.NilDecodeFeedback)
 }
 
+// VethDhcpRelaySim loop back the tx packets, the server, the relay and the clients are in the same namespace
+type VethDhcpRelaySim struct {
+	tctx *core.CThreadCtx
+}
+
+func (o *VethDhcpRelaySim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	mr := o.tctx.MPool.Alloc(uint16(len(p)))
+	mr.SetVPort(m.VPort())
+	mr.Append(p)
+	m.FreeMbuf()
+	return mr
+}
+
+func TestPluginDhcpRelay1(t *testing.T) {
+	var simVeth VethDhcpRelaySim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	simVeth.tctx = tctx
+	defer tctx.Delete()
+
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	srvMac := core.MACKey{0, 0, 1, 0, 0, 0x10}
+	server := core.NewClient(ns, srvMac, core.Ipv4Key{16, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 1})
+	ns.AddClient(server)
+	server.PluginCtx.CreatePlugins([]string{dhcpsrv.DHCPSRV_PLUG},
+		[][]byte{[]byte(`{"pools": [{"min": [16, 0, 0, 10], "max": [16, 0, 0, 20], "prefix": 24}], "opt82": {"require": true}}`)})
+
+	/* the relay sends to the server MAC */
+	relay := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 0x11}, core.Ipv4Key{16, 0, 0, 2}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 1})
+	relay.ForceDGW = true
+	relay.Ipv4ForcedgMac = srvMac
+	ns.AddClient(relay)
+	relay.PluginCtx.CreatePlugins([]string{DHCP_RELAY_PLUG},
+		[][]byte{[]byte(`{"server": [16, 0, 0, 1], "circuit_id": "{vlan1}/{vlan2}", "remote_id": "{mac}"}`)})
+
+	ns.PluginCtx.CreatePlugins([]string{DHCP_PLUG}, [][]byte{})
+	for i := 0; i < 2; i++ {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(1 + i)}, core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{})
+		ns.AddClient(client)
+		client.PluginCtx.CreatePlugins([]string{DHCP_PLUG}, [][]byte{})
+	}
+	Register(tctx)
+	dhcpsrv.Register(tctx)
+
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, true)
+	tctx.MainLoopSim(10 * time.Second)
+
+	for i, exp := range []core.Ipv4Key{{16, 0, 0, 10}, {16, 0, 0, 11}} {
+		c := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, byte(1 + i)})
+		if c.Ipv4 != exp {
+			t.Fatalf(" client %d got %v expected %v \n", i, c.Ipv4, exp)
+		}
+	}
+
+	srv := server.PluginCtx.Get(dhcpsrv.DHCPSRV_PLUG).Ext.(*dhcpsrv.PluginDhcpSrvClient)
+	srv.IterReset()
+	leases, _ := srv.GetNext(10)
+	if len(leases) != 2 {
+		t.Fatalf(" server has %d leases \n", len(leases))
+	}
+	for i, l := range leases {
+		if l.Giaddr != relay.Ipv4 || l.CircuitId != "1/2" || l.RemoteId != fmt.Sprintf("00:00:01:00:00:%02x", i+1) {
+			t.Fatalf(" unexpected relay info %+v \n", l)
+		}
+	}
+
+	relayPlug := relay.PluginCtx.Get(DHCP_RELAY_PLUG).Ext.(*PluginDhcpRelayClient)
+	relayPlug.cdbv.Dump()
+	tctx.SimRecordAppend(relayPlug.cdb.MarshalValues(false))
+	tctx.SimRecordCompare("dhcp_relay1", t)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -0,0 +1,549 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcp
+
+/*
+RFC 1542/3046 DHCP relay agent
+
+The client with the relay plugin intercepts the broadcast DISCOVER/REQUEST of the namespace,
+sets giaddr to the client IPv4 and adds option 82, and unicasts them to the server using the
+default gateway MAC of the client. The replies of the server to giaddr are relayed back to the
+clients without option 82.
+
+client inijson {
+	"server": [16, 0, 0, 1],          // DHCP server
+	"circuit_id": "{vlan1}/{vlan2}",  // option 82 sub-option 1 template, empty for none
+	"remote_id": "{mac}",             // option 82 sub-option 2 template, empty for none
+	"max_hops": 4                     // drop requests with more hops
+}
+
+template variables:
+	{vport}     the port of the namespace
+	{vlan1}     the first VLAN id of the namespace, 0 if there is none
+	{vlan2}     the second VLAN id of the namespace, 0 if there is none
+	{mac}       the client hardware address (chaddr)
+	{relay_ip}  the relay IPv4
+	{relay_mac} the relay MAC
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCP_RELAY_PLUG = "dhcprelay"
+
+	DHCP_OPT_RELAY_AGENT     = 82
+	dhcpRelaySubOptCircuitId = 1
+	dhcpRelaySubOptRemoteId  = 2
+	dhcpRelayDefaultMaxHops  = 4
+	dhcpRelayBroadcastFlag   = 0x8000
+	dhcpServerPort           = 67
+	dhcpClientPort           = 68
+	dhcpRelayMaxSubOptionLen = 255
+	dhcpRelayMinPacketLen    = 240
+)
+
+type DhcpRelayInit struct {
+	Server    core.Ipv4Key `json:"server" validate:"required"`
+	CircuitId string       `json:"circuit_id"`
+	RemoteId  string       `json:"remote_id"`
+	MaxHops   uint8        `json:"max_hops"`
+}
+
+type DhcpRelayStats struct {
+	invalidInitJson  uint64
+	pktRxClient      uint64
+	pktTxServer      uint64
+	pktRxServer      uint64
+	pktTxClient      uint64
+	pktRxLenErr      uint64
+	pktRxParserErr   uint64
+	pktRxMaxHops     uint64
+	pktRxHasOpt82    uint64
+	pktRxWrongGiaddr uint64
+	pktRxUnhandled   uint64
+	pktTxNoDgMac     uint64
+}
+
+func NewDhcpRelayStatsDb(o *DhcpRelayStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DHCP_RELAY_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxClient,
+		Name:     "pktRxClient",
+		Help:     "rx requests from clients",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxServer,
+		Name:     "pktTxServer",
+		Help:     "tx requests relayed to the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxServer,
+		Name:     "pktRxServer",
+		Help:     "rx replies from the server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxClient,
+		Name:     "pktTxClient",
+		Help:     "tx replies relayed to the clients",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLenErr,
+		Name:     "pktRxLenErr",
+		Help:     "len error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxMaxHops,
+		Name:     "pktRxMaxHops",
+		Help:     "drop, too many hops",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxHasOpt82,
+		Name:     "pktRxHasOpt82",
+		Help:     "drop, request from a client with option 82",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongGiaddr,
+		Name:     "pktRxWrongGiaddr",
+		Help:     "drop, reply to another relay",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnhandled,
+		Name:     "pktRxUnhandled",
+		Help:     "unhandled dhcp packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxNoDgMac,
+		Name:     "pktTxNoDgMac",
+		Help:     "drop, default gateway MAC is not resolved",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+// PluginDhcpRelayClient the relay agent, per client
+type PluginDhcpRelayClient struct {
+	core.PluginBase
+	relayNsPlug *PluginDhcpRelayNs
+	init        DhcpRelayInit
+	stats       DhcpRelayStats
+	cdb         *core.CCounterDb
+	cdbv        *core.CCounterDbVec
+	valid       bool
+}
+
+var dhcpRelayEvents = []string{}
+
+/*NewDhcpRelayClient create plugin */
+func NewDhcpRelayClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpRelayClient)
+	o.InitPluginBase(ctx, o)                  /* init base object*/
+	o.RegisterEvents(ctx, dhcpRelayEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCP_RELAY_PLUG)
+	o.relayNsPlug = nsplg.Ext.(*PluginDhcpRelayNs)
+	o.relayNsPlug.relays = append(o.relayNsPlug.relays, o)
+	o.cdb = NewDhcpRelayStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DHCP_RELAY_PLUG)
+	o.cdbv.Add(o.cdb)
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	if o.init.MaxHops == 0 {
+		o.init.MaxHops = dhcpRelayDefaultMaxHops
+	}
+	o.valid = true
+	return &o.PluginBase
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginDhcpRelayClient) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDhcpRelayClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpRelayClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dhcpRelayEvents)
+	o.relayNsPlug.removeRelay(o)
+}
+
+// expandTemplate replace the template variables of the option 82 sub-options
+func (o *PluginDhcpRelayClient) expandTemplate(tmpl string, chaddr net.HardwareAddr) string {
+	if !strings.Contains(tmpl, "{") {
+		return tmpl
+	}
+	var tund core.CTunnelData
+	o.Ns.Key.Get(&tund)
+	r := strings.NewReplacer(
+		"{vport}", fmt.Sprintf("%d", tund.Vport),
+		"{vlan1}", fmt.Sprintf("%d", tund.Vlans[0]&0xfff),
+		"{vlan2}", fmt.Sprintf("%d", tund.Vlans[1]&0xfff),
+		"{mac}", chaddr.String(),
+		"{relay_ip}", o.Client.Ipv4.ToIP().String(),
+		"{relay_mac}", net.HardwareAddr(o.Client.Mac[:]).String())
+	return r.Replace(tmpl)
+}
+
+// buildOpt82 build the relay agent information option data, nil in case there are no sub-options
+func (o *PluginDhcpRelayClient) buildOpt82(chaddr net.HardwareAddr) []byte {
+	var b []byte
+	add := func(code byte, tmpl string) {
+		if tmpl == "" {
+			return
+		}
+		v := o.expandTemplate(tmpl, chaddr)
+		if len(v) > dhcpRelayMaxSubOptionLen-2 {
+			v = v[:dhcpRelayMaxSubOptionLen-2]
+		}
+		b = append(b, code, byte(len(v)))
+		b = append(b, v...)
+	}
+	add(dhcpRelaySubOptCircuitId, o.init.CircuitId)
+	add(dhcpRelaySubOptRemoteId, o.init.RemoteId)
+	if len(b) > dhcpRelayMaxSubOptionLen {
+		b = b[:0]
+		add(dhcpRelaySubOptCircuitId, o.init.CircuitId)
+	}
+	return b
+}
+
+// sendDhcp build IPv4/UDP around the DHCP message and send it
+func (o *PluginDhcpRelayClient) sendDhcp(dhcph *layers.DHCPv4, dstMac core.MACKey, dstIp uint32, dstPort uint16) {
+	broadcast := dstMac.IsBroadcast()
+	l2 := o.Client.GetL2Header(broadcast, uint16(layers.EthernetTypeIPv4))
+	layers.EthernetHeader(l2).SetDestAddress(dstMac[:])
+
+	d := core.PacketUtlBuild(
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 128, Id: 0xcc,
+			SrcIP:    o.Client.Ipv4.ToIP(),
+			DstIP:    net.IPv4(0, 0, 0, 0),
+			Protocol: layers.IPProtocolUDP},
+
+		&layers.UDP{SrcPort: dhcpServerPort, DstPort: layers.UDPPort(dstPort)},
+		dhcph,
+	)
+
+	ipv4 := layers.IPv4Header(d[0:20])
+	ipv4.SetIPDst(dstIp)
+	ipv4.SetLength(uint16(len(d)))
+	ipv4.UpdateChecksum()
+
+	binary.BigEndian.PutUint16(d[24:26], uint16(len(d)-20))
+	binary.BigEndian.PutUint16(d[26:28], 0)
+	cs := layers.PktChecksumTcpUdp(d[20:], 0, ipv4)
+	binary.BigEndian.PutUint16(d[26:28], cs)
+
+	o.Tctx.Veth.SendBuffer(false, o.Client, append(l2, d...))
+}
+
+// relayToServer add giaddr and option 82 and unicast the request to the server
+func (o *PluginDhcpRelayClient) relayToServer(dhcph *layers.DHCPv4) int {
+	o.stats.pktRxClient++
+
+	if dhcph.HardwareOpts >= o.init.MaxHops { /* hops */
+		o.stats.pktRxMaxHops++
+		return core.PARSER_ERR
+	}
+
+	giaddr := dhcph.RelayAgentIP.To4()
+	hasOpt82 := false
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOpt(DHCP_OPT_RELAY_AGENT) {
+			hasOpt82 = true
+		}
+	}
+	if giaddr == nil || giaddr.Equal(net.IPv4zero) {
+		/* RFC 3046 2.1.1, a client should not send option 82 */
+		if hasOpt82 {
+			o.stats.pktRxHasOpt82++
+			return core.PARSER_ERR
+		}
+		dhcph.RelayAgentIP = o.Client.Ipv4.ToIP()
+		if opt82 := o.buildOpt82(dhcph.ClientHWAddr); len(opt82) > 0 {
+			dhcph.Options = append(dhcph.Options, layers.NewDHCPOption(layers.DHCPOpt(DHCP_OPT_RELAY_AGENT), opt82))
+		}
+	}
+	dhcph.HardwareOpts++
+
+	dstMac, ok := o.Client.ResolveIPv4DGMac()
+	if !ok {
+		o.stats.pktTxNoDgMac++
+		return core.PARSER_ERR
+	}
+	o.sendDhcp(dhcph, dstMac, o.init.Server.Uint32(), dhcpServerPort)
+	o.stats.pktTxServer++
+	return core.PARSER_OK
+}
+
+// relayToClient remove option 82 and send the reply to the client, RFC 2131 4.1
+func (o *PluginDhcpRelayClient) relayToClient(dhcph *layers.DHCPv4) int {
+	o.stats.pktRxServer++
+
+	if len(dhcph.ClientHWAddr) != 6 {
+		o.stats.pktRxUnhandled++
+		return core.PARSER_ERR
+	}
+
+	options := dhcph.Options[:0]
+	msgType := layers.DHCPMsgTypeUnspecified
+	for _, op := range dhcph.Options {
+		if op.Type == layers.DHCPOpt(DHCP_OPT_RELAY_AGENT) {
+			continue
+		}
+		if op.Type == layers.DHCPOptMessageType && len(op.Data) == 1 {
+			msgType = layers.DHCPMsgType(op.Data[0])
+		}
+		options = append(options, op)
+	}
+	dhcph.Options = options
+
+	var dstMac core.MACKey
+	var dstIp uint32
+	yiaddr := dhcph.YourClientIP.To4()
+	if msgType == layers.DHCPMsgTypeNak || dhcph.Flags&dhcpRelayBroadcastFlag != 0 ||
+		yiaddr == nil || yiaddr.Equal(net.IPv4zero) {
+		dstMac = core.MACKey{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		dstIp = 0xffffffff
+	} else {
+		copy(dstMac[:], dhcph.ClientHWAddr)
+		dstIp = binary.BigEndian.Uint32(yiaddr)
+	}
+	o.sendDhcp(dhcph, dstMac, dstIp, dhcpClientPort)
+	o.stats.pktTxClient++
+	return core.PARSER_OK
+}
+
+func (o *PluginDhcpRelayClient) HandleRxDhcpPacket(ps *core.ParserPacketState, dhcph *layers.DHCPv4) int {
+	if !o.valid {
+		return core.PARSER_ERR
+	}
+
+	switch dhcph.Operation {
+	case layers.DHCPOpRequest:
+		return o.relayToServer(dhcph)
+	case layers.DHCPOpReply:
+		var giaddr core.Ipv4Key
+		copy(giaddr[:], dhcph.RelayAgentIP.To4())
+		if giaddr != o.Client.Ipv4 {
+			o.stats.pktRxWrongGiaddr++
+			return core.PARSER_ERR
+		}
+		return o.relayToClient(dhcph)
+	}
+	o.stats.pktRxUnhandled++
+	return core.PARSER_ERR
+}
+
+// PluginDhcpRelayNs dispatch the packets to UDP port 67 to the relay of the namespace
+type PluginDhcpRelayNs struct {
+	core.PluginBase
+	relays []*PluginDhcpRelayClient
+}
+
+func NewDhcpRelayNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpRelayNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpRelayNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginDhcpRelayNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+func (o *PluginDhcpRelayNs) removeRelay(relay *PluginDhcpRelayClient) {
+	for i, r := range o.relays {
+		if r == relay {
+			o.relays = append(o.relays[:i], o.relays[i+1:]...)
+			return
+		}
+	}
+}
+
+// getRelay return the relay of a request by the destination MAC, the first relay for broadcast.
+// The replies of the server are sent to giaddr.
+func (o *PluginDhcpRelayNs) getRelay(p []byte, dhcph *layers.DHCPv4) *PluginDhcpRelayClient {
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	var client *core.CClient
+	if dhcph.Operation == layers.DHCPOpReply {
+		var giaddr core.Ipv4Key
+		copy(giaddr[:], dhcph.RelayAgentIP.To4())
+		client = o.Ns.CLookupByIPv4(&giaddr)
+	} else if mackey.IsBroadcast() {
+		if len(o.relays) == 0 {
+			return nil
+		}
+		return o.relays[0]
+	} else {
+		client = o.Ns.CLookupByMac(&mackey)
+	}
+	if client == nil {
+		return nil
+	}
+	cplg := client.PluginCtx.Get(DHCP_RELAY_PLUG)
+	if cplg == nil {
+		return nil
+	}
+	return cplg.Ext.(*PluginDhcpRelayClient)
+}
+
+func (o *PluginDhcpRelayNs) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
+	if len(o.relays) == 0 {
+		return core.PARSER_ERR
+	}
+
+	p := ps.M.GetData()
+	dhcphlen := ps.L7Len
+	if dhcphlen < dhcpRelayMinPacketLen {
+		o.relays[0].stats.pktRxLenErr++
+		return core.PARSER_ERR
+	}
+
+	var dhcph layers.DHCPv4
+	err := dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.relays[0].stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	relay := o.getRelay(p, &dhcph)
+	if relay == nil {
+		/* not for the relay, might be for a server in the namespace */
+		return core.PARSER_ERR
+	}
+	return relay.HandleRxDhcpPacket(ps, &dhcph)
+}
+
+// HandleRxDhcpRelayPacket Parser call this function with mbuf from the pool
+func HandleRxDhcpRelayPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCP_RELAY_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	relayPlug := nsplg.Ext.(*PluginDhcpRelayNs)
+	return relayPlug.HandleRxDhcpPacket(ps)
+}
+
+type PluginDhcpRelayCReg struct{}
+type PluginDhcpRelayNsReg struct{}
+
+func (o PluginDhcpRelayCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpRelayClient(ctx, initJson)
+}
+
+func (o PluginDhcpRelayNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpRelayNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpRelayClientCntHandler struct{}
+)
+
+func (h ApiDhcpRelayClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, DHCP_RELAY_PLUG)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	c := plug.Ext.(*PluginDhcpRelayClient)
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DHCP_RELAY_PLUG,
+		core.PluginRegisterData{Client: PluginDhcpRelayCReg{},
+			Ns:     PluginDhcpRelayNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dhcprelay_client_cnt", ApiDhcpRelayClientCntHandler{}, false) // get counters/meta
+
+	/* register callback for rx side*/
+	core.ParserRegister("dhcprelay", HandleRxDhcpRelayPacket)
+}
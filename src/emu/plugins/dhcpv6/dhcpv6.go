@@ -8,6 +8,22 @@ package dhcpv6
 /*
 RFC 8415  DHCPv6 client
 
+client inijson {
+	"timerd": 5,
+	"timero": 10,
+	"options": {..},
+	"ia_pd": {
+		"hint_len": 56,         // prefix length hint in the solicit, 0 for no hint
+		"no_iana": false,       // request only a prefix, without an address
+		"downstream": [[0, 0, 1, 0, 0, 2]] // clients of the namespace that get an address from a /64 of the prefix
+	}
+}
+
+With ia_pd the client requests a delegated prefix (IA_PD) in addition to the address. The prefix
+is renewed/rebound with the address and removed when its valid lifetime ends. The i-th downstream
+client gets the i-th /64 of the prefix with its EUI-64 as its DHCPv6 address.
+
+A Rapid Commit option in the solicit ("sol": [[0, 14]]) lets the server answer with a Reply (RFC 8415 18.2.1).
 */
 
 import (
@@ -60,11 +76,19 @@ type DhcpOptionsT struct {
 	RemoveVC bool      `json:"rm_vc"` // Remove Default Vendor Class
 }
 
+// DhcpIaPdT represents a struct that can be provided in the Init Json in order to request prefix delegation.
+type DhcpIaPdT struct {
+	HintLen    uint8         `json:"hint_len"`   // Prefix length hint, 0 for no hint.
+	NoIana     bool          `json:"no_iana"`    // Request only a prefix.
+	Downstream []core.MACKey `json:"downstream"` // Clients of the namespace, each gets a /64 of the prefix.
+}
+
 // DhcpInit represents the Init Json for Dhcpv6 plugin
 type DhcpInit struct {
 	TimerDiscoverSec uint32        `json:"timerd"`
 	TimerOfferSec    uint32        `json:"timero"`
 	Options          *DhcpOptionsT `json:"options"`
+	IaPd             *DhcpIaPdT    `json:"ia_pd"`
 }
 
 // DhcpStats is a struct that aggregates Dhcpv6 statistics.
@@ -96,6 +120,14 @@ type DhcpStats struct {
 	pktRxNotify    uint64
 	pktRxRenew     uint64
 	pktRxRebind    uint64
+
+	pktRxNoIAPD      uint64
+	pktRxWrongIAPDId uint64
+	pktRxNoIAPrefix  uint64
+	pdBound          uint64
+	pdExpired        uint64
+	pdCarved         uint64
+	pdCarveErr       uint64
 }
 
 func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
@@ -297,6 +329,62 @@ func NewDhcpStatsDb(o *DhcpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoIAPD,
+		Name:     "pktRxNoIAPD",
+		Help:     "rx no IA_PD option",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongIAPDId,
+		Name:     "pktRxWrongIAPDId",
+		Help:     "rx wrong IA_PD id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoIAPrefix,
+		Name:     "pktRxNoIAPrefix",
+		Help:     "rx IA_PD without a prefix",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdBound,
+		Name:     "pdBound",
+		Help:     "delegated prefix bound",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdExpired,
+		Name:     "pdExpired",
+		Help:     "delegated prefix valid lifetime expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdCarved,
+		Name:     "pdCarved",
+		Help:     "downstream clients with an address from the prefix",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pdCarveErr,
+		Name:     "pdCarveErr",
+		Help:     "downstream client without an address, unknown client or prefix too long",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -308,6 +396,14 @@ func (o *PluginDhcpClientTimer) OnEvent(a, b interface{}) {
 	pi.onTimerEvent()
 }
 
+type PluginDhcpClientPdTimer struct {
+}
+
+func (o *PluginDhcpClientPdTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginDhcpClient)
+	pi.onPdExpired()
+}
+
 //PluginDhcpClient information per client
 type PluginDhcpClient struct {
 	core.PluginBase
@@ -349,6 +445,13 @@ type PluginDhcpClient struct {
 	iaid                       uint32
 	serverOption               []byte
 	pktIana                    layers.DHCPv6OptionIANA
+	pktIapd                    layers.DHCPv6OptionIAPD
+	validIapd                  bool                         // IA_PD with a prefix in the last rx packet
+	pdPrefix                   core.Ipv6Key                 // offered/bound delegated prefix
+	pdPrefixLen                uint8                        // 0 in case there is no prefix
+	pdTimer                    core.CHTimerObj              // valid lifetime of the bound prefix
+	pdTimerCb                  PluginDhcpClientPdTimer
+	pdDownstream               map[core.MACKey]core.Ipv6Key // address of each downstream client
 }
 
 var dhcpEvents = []string{}
@@ -396,8 +499,11 @@ func (o *PluginDhcpClient) OnCreate() {
 	o.cdbv = core.NewCCounterDbVec("dhcpv6")
 	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
+	o.pdTimer.SetCB(&o.pdTimerCb, o, 0)
+	o.pdDownstream = make(map[core.MACKey]core.Ipv6Key)
 	o.ticksStart = o.timerw.Ticks
 	o.pktIana.IPv6 = make(net.IP, net.IPv6len)
+	o.pktIapd.Prefix = make(net.IP, net.IPv6len)
 	o.sipv6 = make(net.IP, net.IPv6len)
 	o.sid = make([]byte, 0)
 	o.SendDiscover()
@@ -468,6 +574,14 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 	o.l4Offset = o.l3Offset + IPV6_HEADER_SIZE
 	o.l7Offset = o.l4Offset + 8
 
+	o.buildPacketTemplates()
+}
+
+// buildPacketTemplates builds the templates of all the message types, it is called again when the
+// delegated prefix changes as the prefix is part of the IA_PD option.
+func (o *PluginDhcpClient) buildPacketTemplates() {
+	l2 := o.Client.GetL2Header(true, uint16(layers.EthernetTypeIPv6))
+	xid := o.xid
 	transactionId := []byte{(byte((xid >> 16) & 0xff)), byte(((xid & 0xff00) >> 8)), byte(xid & 0xff)}
 
 	solicit := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeSolicit, TransactionID: transactionId}
@@ -489,7 +603,6 @@ func (o *PluginDhcpClient) preparePacketTemplate() {
 	release := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeRelease, TransactionID: transactionId}
 	o.createOptions(release, layers.DHCPv6MsgTypeRelease)
 	o.releasePktTemplate = o.buildPacket(l2, release)
-
 }
 
 // createOptions creates the DHCP options for each type of message.
@@ -549,7 +662,12 @@ func (o *PluginDhcpClient) createOptions(dhcpv6 *layers.DHCPv6, msgType layers.D
 	if !removeVendorClass {
 		optionsMap[layers.DHCPv6OptVendorClass] = []byte{0x00, 0x00, 0x01, 0x37, 0x00, 0x08, 0x4d, 0x53, 0x46, 0x54, 0x20, 0x35, 0x2e, 0x30}
 	}
-	optionsMap[layers.DHCPv6OptIANA] = ianaOpt
+	if o.init.IaPd == nil || !o.init.IaPd.NoIana {
+		optionsMap[layers.DHCPv6OptIANA] = ianaOpt
+	}
+	if o.init.IaPd != nil {
+		optionsMap[layers.DHCPv6OptIAPD] = o.buildIapdOption()
+	}
 	optionsMap[layers.DHCPv6OptElapsedTime] = []byte{0x00, 0x00}
 
 	if optionsBinary != nil {
@@ -648,6 +766,120 @@ func (o *PluginDhcpClient) SendDhcpPacket(msgType layers.DHCPv6MsgType, serverOp
 	o.Tctx.Veth.Send(m)
 }
 
+// buildIapdOption builds the IA_PD option data, with the prefix in case there is one or the hint.
+func (o *PluginDhcpClient) buildIapdOption() []byte {
+	iapd := make([]byte, 12)
+	binary.BigEndian.PutUint32(iapd[0:4], o.iaid)
+
+	if o.pdPrefixLen == 0 && o.init.IaPd.HintLen == 0 {
+		return iapd
+	}
+	prefix := make([]byte, 25) // preferred, valid lifetime are 0
+	if o.pdPrefixLen > 0 {
+		prefix[8] = o.pdPrefixLen
+		copy(prefix[9:25], o.pdPrefix[:])
+	} else {
+		prefix[8] = o.init.IaPd.HintLen
+	}
+	return append(iapd, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefix))...)
+}
+
+// setPdPrefix changes the prefix sent in the IA_PD option.
+func (o *PluginDhcpClient) setPdPrefix(prefix net.IP, prefixLen uint8) {
+	var key core.Ipv6Key
+	copy(key[:], prefix)
+	if key == o.pdPrefix && prefixLen == o.pdPrefixLen {
+		return
+	}
+	o.pdPrefix = key
+	o.pdPrefixLen = prefixLen
+	o.buildPacketTemplates()
+}
+
+// bindPd updates the client with the delegated prefix and carves the /64s of the downstream clients.
+func (o *PluginDhcpClient) bindPd() {
+	o.setPdPrefix(o.pktIapd.Prefix, o.pktIapd.PrefixLen)
+	if o.Client.Dhcpv6Prefix != o.pdPrefix || o.Client.Dhcpv6PrefixLen != o.pdPrefixLen {
+		o.clearDownstream()
+		o.Client.Dhcpv6Prefix = o.pdPrefix
+		o.Client.Dhcpv6PrefixLen = o.pdPrefixLen
+		o.stats.pdBound++
+		o.carveDownstream()
+	}
+
+	if o.pdTimer.IsRunning() {
+		o.timerw.Stop(&o.pdTimer)
+	}
+	if o.pktIapd.ValidLife != 0xffffffff {
+		o.timerw.Start(&o.pdTimer, time.Duration(o.pktIapd.ValidLife)*time.Second)
+	}
+}
+
+func (o *PluginDhcpClient) carveDownstream() {
+	if len(o.init.IaPd.Downstream) == 0 {
+		return
+	}
+	if o.pdPrefixLen > 64 {
+		o.stats.pdCarveErr += uint64(len(o.init.IaPd.Downstream))
+		return
+	}
+	mask := ^uint64(0) << (64 - o.pdPrefixLen)
+	base := binary.BigEndian.Uint64(o.pdPrefix[0:8]) & mask
+	for i, mac := range o.init.IaPd.Downstream {
+		if uint64(i) > ^mask {
+			o.stats.pdCarveErr++
+			continue
+		}
+		client := o.Ns.CLookupByMac(&mac)
+		if client == nil {
+			o.stats.pdCarveErr++
+			continue
+		}
+		var ipv6 core.Ipv6Key
+		binary.BigEndian.PutUint64(ipv6[0:8], base|uint64(i))
+		ipv6[8] = mac[0] ^ 0x2
+		ipv6[9] = mac[1]
+		ipv6[10] = mac[2]
+		ipv6[11] = 0xFF
+		ipv6[12] = 0xFE
+		ipv6[13] = mac[3]
+		ipv6[14] = mac[4]
+		ipv6[15] = mac[5]
+		if client.UpdateDIPv6(ipv6) != nil {
+			o.stats.pdCarveErr++
+			continue
+		}
+		o.pdDownstream[mac] = ipv6
+		o.stats.pdCarved++
+	}
+}
+
+func (o *PluginDhcpClient) clearDownstream() {
+	for mac, ipv6 := range o.pdDownstream {
+		client := o.Ns.CLookupByMac(&mac)
+		if client != nil && client.Dhcpv6 == ipv6 {
+			client.UpdateDIPv6(core.Ipv6Key{})
+		}
+		delete(o.pdDownstream, mac)
+	}
+}
+
+// clearPd removes the delegated prefix from the client and the downstream clients.
+func (o *PluginDhcpClient) clearPd() {
+	if o.pdTimer.IsRunning() {
+		o.timerw.Stop(&o.pdTimer)
+	}
+	o.clearDownstream()
+	o.Client.Dhcpv6Prefix = core.Ipv6Key{}
+	o.Client.Dhcpv6PrefixLen = 0
+}
+
+func (o *PluginDhcpClient) onPdExpired() {
+	o.stats.pdExpired++
+	o.clearPd()
+	o.setPdPrefix(net.IPv6zero, 0)
+}
+
 func (o *PluginDhcpClient) SendDiscover() {
 	o.state = DHCP_STATE_INIT
 	o.cnt = 0
@@ -659,6 +891,11 @@ func (o *PluginDhcpClient) SendDiscover() {
 /*OnEvent support event change of IP  */
 func (o *PluginDhcpClient) OnEvent(msg string, a, b interface{}) {}
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDhcpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	o.SendRenewRebind(false, true, 0)
@@ -666,6 +903,9 @@ func (o *PluginDhcpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	if o.init.IaPd != nil {
+		o.clearPd()
+	}
 }
 
 func (o *PluginDhcpClient) SendRenewRebind(rebind bool, release bool, timerSec uint32) {
@@ -725,7 +965,19 @@ func (o *PluginDhcpClient) verifyPkt(dhcph *layers.DHCPv6,
 		return -1
 	}
 
-	if !validIana {
+	if o.init.IaPd != nil {
+		if !o.validIapd {
+			o.stats.pktRxNoIAPD++
+			if o.init.IaPd.NoIana {
+				return -1
+			}
+		} else if o.pktIapd.IAID != o.iaid {
+			o.stats.pktRxWrongIAPDId++
+			return -1
+		}
+	}
+
+	if !validIana && (o.init.IaPd == nil || !o.init.IaPd.NoIana) {
 		o.stats.pktRxNoIANA++
 		return -1
 		if o.pktIana.IAID != o.iaid {
@@ -829,15 +1081,31 @@ func (o *PluginDhcpClient) HandleAckNak(dhcpmt layers.DHCPv6MsgType,
 	case layers.DHCPv6MsgTypeReply:
 		o.stats.pktRxAck++
 		o.state = DHCP_STATE_BOUND
-		if notify {
+		noIana := o.init.IaPd != nil && o.init.IaPd.NoIana
+		if notify && !noIana {
 			o.stats.pktRxNotify++
 			var NewIpv6 core.Ipv6Key
 			copy(NewIpv6[:], o.pktIana.IPv6)
 			o.Client.UpdateDIPv6(NewIpv6)
 		}
 
-		o.t1 = normTime(o.pktIana.T1, false)
-		o.t2 = normTime(o.pktIana.T2, true)
+		if noIana {
+			o.t1 = normTime(o.pktIapd.T1, false)
+			o.t2 = normTime(o.pktIapd.T2, true)
+		} else {
+			o.t1 = normTime(o.pktIana.T1, false)
+			o.t2 = normTime(o.pktIana.T2, true)
+		}
+		if o.validIapd {
+			/* renew both of them at the earliest time */
+			if t1 := normTime(o.pktIapd.T1, false); t1 < o.t1 {
+				o.t1 = t1
+			}
+			if t2 := normTime(o.pktIapd.T2, true); t2 < o.t2 {
+				o.t2 = t2
+			}
+			o.bindPd()
+		}
 		if o.t2 < o.t1 {
 			o.t2 = o.t1 + 60
 		}
@@ -856,6 +1124,18 @@ func EncodeOption(o layers.DHCPv6Option) []byte {
 	return b
 }
 
+// saveServer saves the server id option and the server address of the selected server.
+func (o *PluginDhcpClient) saveServer(sid []byte, ipv6 layers.IPv6Header) int {
+	if sid == nil {
+		o.stats.pktRxMissingServerIdOption++
+		return -1
+	}
+	o.sid = append(o.sid, sid[:]...)
+	o.sidOption = EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.sid))
+	copy(o.sipv6[:], ipv6.SrcIP())
+	return 0
+}
+
 func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 
 	m := ps.M
@@ -884,6 +1164,8 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 	var sid []byte
 	var validIana bool
 	var status uint16
+	var rapidCommit bool
+	o.validIapd = false
 
 	for _, op := range dhcph.Options {
 		switch op.Code {
@@ -896,6 +1178,18 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 			if o.pktIana.Decode(op.Data) == nil {
 				validIana = true
 			}
+		case layers.DHCPv6OptIAPD:
+			if o.init.IaPd != nil && o.pktIapd.Decode(op.Data) == nil {
+				if o.pktIapd.Status == STATUS_NoPrefixAvail {
+					o.stats.pktRxSTATUS_NoPrefixAvail++
+				}
+				o.validIapd = o.pktIapd.OptionValid && o.pktIapd.Status == STATUS_Success
+				if !o.validIapd {
+					o.stats.pktRxNoIAPrefix++
+				}
+			}
+		case layers.DHCPv6OptRapidCommit:
+			rapidCommit = true
 		case layers.DHCPv6OptStatusCode:
 			if len(op.Data) == 2 {
 				status = binary.BigEndian.Uint16(op.Data[0:2])
@@ -941,18 +1235,26 @@ func (o *PluginDhcpClient) HandleRxDhcpPacket(ps *core.ParserPacketState) int {
 				return -1
 			}
 
-			if sid == nil {
-				o.stats.pktRxMissingServerIdOption++
+			if o.saveServer(sid, ipv6) != 0 {
 				return -1
 			}
-			o.sid = append(o.sid, sid[:]...)
-			o.sidOption = EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.sid))
-			copy(o.sipv6[:], ipv6.SrcIP())
+			if o.validIapd {
+				/* request the advertised prefix */
+				o.setPdPrefix(o.pktIapd.Prefix, o.pktIapd.PrefixLen)
+			}
 			o.state = DHCP_STATE_REQUESTING
 			o.SendReq()
 			return 0
 		}
 
+		if dhcpmt == layers.DHCPv6MsgTypeReply && rapidCommit {
+			/* the server committed the solicit, RFC 8415 18.2.1 */
+			if o.saveServer(sid, ipv6) != 0 {
+				return -1
+			}
+			return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
+		}
+
 	case DHCP_STATE_REQUESTING:
 		return o.HandleAckNak(dhcpmt, &dhcph, ipv6, true, status)
 
@@ -32,10 +32,14 @@ type DhcpTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	options      []byte
+	checkCb      DhcpTestCheckCb
 }
 
 type IgmpTestCb func(tctx *core.CThreadCtx, test *DhcpTestBase) int
 
+// DhcpTestCheckCb is called at the end of the simulation
+type DhcpTestCheckCb func(tctx *core.CThreadCtx, c *core.CClient, t *testing.T)
+
 func (o *DhcpTestBase) Run(t *testing.T) {
 
 	var simVeth VethIgmpSim
@@ -73,6 +77,9 @@ func (o *DhcpTestBase) Run(t *testing.T) {
 	dhcpPlug := nsplg.Ext.(*PluginDhcpClient)
 	dhcpPlug.cdbv.Dump()
 	tctx.GetCounterDbVec().Dump()
+	if o.checkCb != nil {
+		o.checkCb(tctx, c, t)
+	}
 
 	//tctx.SimRecordAppend(igmpPlug.cdb.MarshalValues(false))
 	tctx.SimRecordCompare(o.testname, t)
@@ -187,6 +194,22 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 			pkt := GenerateOfferPacket(xid, src, dst, int(layers.DHCPv6MsgTypeAdverstise))
 			mr = genMbuf(o.tctx, pkt)
 		}
+	case 3, 4, 5:
+		/* prefix delegation, 4 without IA_NA, 5 does not answer renew/rebind */
+		iana := o.match != 4
+		switch dhcpmt {
+		case layers.DHCPv6MsgTypeSolicit:
+			pkt := generateDhcpv6Packet(xid, src, dst, int(layers.DHCPv6MsgTypeAdverstise), iana, generateIapd())
+			mr = genMbuf(o.tctx, pkt)
+		case layers.DHCPv6MsgTypeRequest:
+			pkt := generateDhcpv6Packet(xid, src, dst, int(layers.DHCPv6MsgTypeReply), iana, generateIapd())
+			mr = genMbuf(o.tctx, pkt)
+		case layers.DHCPv6MsgTypeRenew, layers.DHCPv6MsgTypeRebind:
+			if o.match != 5 {
+				pkt := generateDhcpv6Packet(xid, src, dst, int(layers.DHCPv6MsgTypeReply), iana, generateIapd())
+				mr = genMbuf(o.tctx, pkt)
+			}
+		}
 	}
 
 	m.FreeMbuf()
@@ -276,12 +299,114 @@ func TestPluginDhcpv6_5(t *testing.T) {
 	a.Run(t)
 }
 
+func dhcpv6PdCheck(downstream bool) DhcpTestCheckCb {
+	return func(tctx *core.CThreadCtx, c *core.CClient, t *testing.T) {
+		var prefix core.Ipv6Key
+		copy(prefix[:], Ipv6SA("2001:db8:1200::"))
+		info := c.GetInfo()
+		if info.DhcpIpv6Prefix != prefix || info.DhcpIpv6PrefixLen != 56 {
+			t.Fatalf(" delegated prefix %v/%d \n", info.DhcpIpv6Prefix, info.DhcpIpv6PrefixLen)
+		}
+		if !downstream {
+			return
+		}
+		for i, exp := range []string{"2001:db8:1200:0:200:1ff:fe00:2", "2001:db8:1200:1:200:1ff:fe00:3"} {
+			d := c.Ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, byte(2 + i)})
+			if !net.IP(d.Dhcpv6[:]).Equal(Ipv6SA(exp)) {
+				t.Fatalf(" downstream %d got %v expected %s \n", i, net.IP(d.Dhcpv6[:]), exp)
+			}
+		}
+	}
+}
+
+func dhcpv6PdDownstream(tctx *core.CThreadCtx, test *DhcpTestBase) int {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	for i := 0; i < 2; i++ {
+		ns.AddClient(core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(2 + i)},
+			core.Ipv4Key{}, core.Ipv6Key{}, core.Ipv4Key{}))
+	}
+	return 0
+}
+
+func TestPluginDhcpv6Pd1(t *testing.T) {
+	a := &DhcpTestBase{
+		testname:     "dhcpv6_pd1",
+		dropAll:      false,
+		monitor:      false,
+		match:        3,
+		capture:      true,
+		duration:     120 * time.Second,
+		clientsToSim: 1,
+		cb:           dhcpv6PdDownstream,
+		options:      []byte(`{"ia_pd": {"hint_len": 56, "downstream": [[0, 0, 1, 0, 0, 2], [0, 0, 1, 0, 0, 3], [0, 0, 1, 0, 0, 9]]}}`),
+		checkCb:      dhcpv6PdCheck(true),
+	}
+	a.Run(t)
+}
+
+/* only prefix, without an address */
+func TestPluginDhcpv6Pd2(t *testing.T) {
+	a := &DhcpTestBase{
+		testname:     "dhcpv6_pd2",
+		dropAll:      false,
+		monitor:      false,
+		match:        4,
+		capture:      true,
+		duration:     120 * time.Second,
+		clientsToSim: 1,
+		options:      []byte(`{"ia_pd": {"no_iana": true}}`),
+		checkCb: func(tctx *core.CThreadCtx, c *core.CClient, t *testing.T) {
+			dhcpv6PdCheck(false)(tctx, c, t)
+			if !c.Dhcpv6.IsZero() {
+				t.Fatalf(" unexpected address %v \n", c.Dhcpv6)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the renew is not answered, the prefix expires */
+func TestPluginDhcpv6Pd3(t *testing.T) {
+	a := &DhcpTestBase{
+		testname:     "dhcpv6_pd3",
+		dropAll:      false,
+		monitor:      false,
+		match:        5,
+		capture:      true,
+		duration:     120 * time.Second,
+		clientsToSim: 1,
+		cb:           dhcpv6PdDownstream,
+		options:      []byte(`{"ia_pd": {"downstream": [[0, 0, 1, 0, 0, 2]]}}`),
+		checkCb: func(tctx *core.CThreadCtx, c *core.CClient, t *testing.T) {
+			d := c.Ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 2})
+			if c.Dhcpv6PrefixLen != 0 || !c.Dhcpv6Prefix.IsZero() || !d.Dhcpv6.IsZero() {
+				t.Fatalf(" prefix did not expire \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
 func getL2() []byte {
 	l2 := []byte{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 2, 0x81, 00, 0x00, 0x01, 0x81, 00, 0x00, 0x02, 0x86, 0xdd}
 	return l2
 }
 
+// generateIapd returns IA_PD 2001:db8:1200::/56, T1 30, T2 50, preferred 60 and valid 90.
+func generateIapd() []byte {
+	iapd := []byte{0x12, 0x34, 0x56, 0x78, 0, 0, 0, 30, 0, 0, 0, 50}
+	prefix := []byte{0, 0, 0, 60, 0, 0, 0, 90, 56}
+	prefix = append(prefix, Ipv6SA("2001:db8:1200::")...)
+	return append(iapd, EncodeOption(layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, prefix))...)
+}
+
 func GenerateOfferPacket(xid uint32, src net.IP, dst net.IP, dt int) []byte {
+	return generateDhcpv6Packet(xid, src, dst, dt, true, nil)
+}
+
+func generateDhcpv6Packet(xid uint32, src net.IP, dst net.IP, dt int, iana bool, iapd []byte) []byte {
 
 	dhcp := &layers.DHCPv6{MsgType: layers.DHCPv6MsgType(dt),
 		TransactionID: []byte{(byte((xid & 0xff0000) >> 16)), byte((xid & 0xff00) >> 8), byte(xid & 0xff)}}
@@ -297,7 +422,12 @@ func GenerateOfferPacket(xid uint32, src net.IP, dst net.IP, dt int) []byte {
 
 	//binary.BigEndian.PutUint32(ianao[0:4], o.iaid)
 
-	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, ianao))
+	if iana {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIANA, ianao))
+	}
+	if iapd != nil {
+		dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptIAPD, iapd))
+	}
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0x00, 0x00}))
 	dhcp.Options = append(dhcp.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, []byte{0x00, 0x01, 0x00, 0x01, 0x21, 0x54, 0xee, 0xe7, 0x00, 0x0c, 0x29, 0x70, 0x3d, 0xd8}))
 
@@ -0,0 +1,1385 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpv6srv
+
+/*
+RFC 8415 DHCPv6 server
+
+The server is a client plugin, the client MAC is the server DUID (DUID-LL) and the client link-local
+address is the source of the replies. The namespace plugin dispatches the packets to UDP port 547 to
+the server of the namespace, so one namespace acts as the DHCPv6 server of the clients behind it.
+
+client inijson {
+	"stateless": false,          // answer only Information-Request
+	"rapid_commit": true,        // answer a Solicit with Rapid Commit with a Reply
+	"preference": 255,           // preference option in the advertise, 0 for none
+	"preferred_lifetime": 3600,  // sec
+	"valid_lifetime": 7200,      // sec
+	"t1": 1800,                  // sec, default is 0.5 of the preferred lifetime
+	"t2": 2880,                  // sec, default is 0.8 of the preferred lifetime
+	"offer_hold": 60,            // sec, time an advertised address/prefix is reserved
+	"na_pools": [{"min": [0x20, 0x01, .. 0x10], "max": [0x20, 0x01, .. 0x20]}],
+	"pd_pools": [{"prefix": [0x20, 0x01, 0x0d, 0xb8, 0, 0, ..], "prefix_len": 48, "delegated_len": 56}],
+	"dns": [[0x20, 0x01, .. 0x53]],
+	"domains": ["trex.local"]
+}
+
+An IA_NA gets an address from the NA pools and an IA_PD gets a prefix from the PD pools. The address/prefix
+the client asked for is used in case it is free. The min/max of an NA pool share the upper 64 bits.
+The DNS servers and domain search list are added in case the client asked for them in the Option Request option.
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DHCPV6SRV_PLUG = "dhcpv6srv"
+
+	/* state of each lease */
+	DHCPV6SRV_LEASE_OFFERED  = 0
+	DHCPV6SRV_LEASE_BOUND    = 1
+	DHCPV6SRV_LEASE_DECLINED = 2
+
+	/* type of the identity association */
+	DHCPV6SRV_IA_NA = 0
+	DHCPV6SRV_IA_PD = 1
+
+	DHCPV6_OPT_DNS_SERVERS = 23
+	DHCPV6_OPT_DOMAIN_LIST = 24
+
+	STATUS_Success       = 0
+	STATUS_NoAddrsAvail  = 2
+	STATUS_NoBinding     = 3
+	STATUS_NotOnLink     = 4
+	STATUS_NoPrefixAvail = 6
+
+	IPV6_HEADER_SIZE = 40
+
+	dhcpv6SrvPreferredLifetime = 3600
+	dhcpv6SrvValidLifetime     = 7200
+	dhcpv6SrvOfferHold         = 60
+)
+
+var dhcpv6SrvLeaseStateNames = []string{"offered", "bound", "declined"}
+var dhcpv6SrvIaNames = []string{"na", "pd"}
+
+type Dhcpv6SrvNaPool struct {
+	Min core.Ipv6Key `json:"min" validate:"required"`
+	Max core.Ipv6Key `json:"max" validate:"required"`
+}
+
+type Dhcpv6SrvPdPool struct {
+	Prefix       core.Ipv6Key `json:"prefix" validate:"required"`
+	PrefixLen    uint8        `json:"prefix_len" validate:"required,gte=1,lte=64"`
+	DelegatedLen uint8        `json:"delegated_len" validate:"required,gte=1,lte=64"`
+}
+
+type Dhcpv6SrvInit struct {
+	Stateless         bool              `json:"stateless"`
+	RapidCommit       bool              `json:"rapid_commit"`
+	Preference        uint8             `json:"preference"`
+	PreferredLifetime uint32            `json:"preferred_lifetime"`
+	ValidLifetime     uint32            `json:"valid_lifetime"`
+	T1                uint32            `json:"t1"`
+	T2                uint32            `json:"t2"`
+	OfferHold         uint32            `json:"offer_hold"`
+	NaPools           []Dhcpv6SrvNaPool `json:"na_pools" validate:"dive"`
+	PdPools           []Dhcpv6SrvPdPool `json:"pd_pools" validate:"dive"`
+	Dns               []core.Ipv6Key    `json:"dns"`
+	Domains           []string          `json:"domains"`
+}
+
+type Dhcpv6SrvStats struct {
+	invalidInitJson    uint64
+	pktRxSolicit       uint64
+	pktRxRequest       uint64
+	pktRxRenew         uint64
+	pktRxRebind        uint64
+	pktRxRelease       uint64
+	pktRxDecline       uint64
+	pktRxConfirm       uint64
+	pktRxInfoRequest   uint64
+	pktTxAdvertise     uint64
+	pktTxReply         uint64
+	pktTxRapidCommit   uint64
+	pktRxLenErr        uint64
+	pktRxParserErr     uint64
+	pktRxNoClientId    uint64
+	pktRxWrongServerId uint64
+	pktRxStateless     uint64
+	pktRxUnhandled     uint64
+	noAddrsAvail       uint64
+	noPrefixAvail      uint64
+	noBinding          uint64
+	leaseExpired       uint64
+	leaseOfferExpired  uint64
+	leaseBound         uint64
+	leaseActive        uint64
+}
+
+func NewDhcpv6SrvStatsDb(o *Dhcpv6SrvStats) *core.CCounterDb {
+	db := core.NewCCounterDb(DHCPV6SRV_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxSolicit,
+		Name:     "pktRxSolicit",
+		Help:     "rx solicit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRequest,
+		Name:     "pktRxRequest",
+		Help:     "rx request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRenew,
+		Name:     "pktRxRenew",
+		Help:     "rx renew",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRebind,
+		Name:     "pktRxRebind",
+		Help:     "rx rebind",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxRelease,
+		Name:     "pktRxRelease",
+		Help:     "rx release",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecline,
+		Name:     "pktRxDecline",
+		Help:     "rx decline, the address is in use",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxConfirm,
+		Name:     "pktRxConfirm",
+		Help:     "rx confirm",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInfoRequest,
+		Name:     "pktRxInfoRequest",
+		Help:     "rx information request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAdvertise,
+		Name:     "pktTxAdvertise",
+		Help:     "tx advertise",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxReply,
+		Name:     "pktTxReply",
+		Help:     "tx reply",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRapidCommit,
+		Name:     "pktTxRapidCommit",
+		Help:     "tx reply to a solicit with rapid commit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLenErr,
+		Name:     "pktRxLenErr",
+		Help:     "len error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoClientId,
+		Name:     "pktRxNoClientId",
+		Help:     "drop, no client id option",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongServerId,
+		Name:     "pktRxWrongServerId",
+		Help:     "drop, missing server id or the id of another server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxStateless,
+		Name:     "pktRxStateless",
+		Help:     "drop, stateful message to a stateless server",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnhandled,
+		Name:     "pktRxUnhandled",
+		Help:     "unhandled dhcpv6 packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noAddrsAvail,
+		Name:     "noAddrsAvail",
+		Help:     "no free address in the pools",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noPrefixAvail,
+		Name:     "noPrefixAvail",
+		Help:     "no free prefix in the pools",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.noBinding,
+		Name:     "noBinding",
+		Help:     "renew of an unknown binding",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseExpired,
+		Name:     "leaseExpired",
+		Help:     "bound lease expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseOfferExpired,
+		Name:     "leaseOfferExpired",
+		Help:     "advertised lease was not requested",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseBound,
+		Name:     "leaseBound",
+		Help:     "lease bound",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.leaseActive,
+		Name:     "leaseActive",
+		Help:     "active leases",
+		Unit:     "leases",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+func ipv6Hi(ip *core.Ipv6Key) uint64 {
+	return binary.BigEndian.Uint64(ip[0:8])
+}
+
+func ipv6Lo(ip *core.Ipv6Key) uint64 {
+	return binary.BigEndian.Uint64(ip[8:16])
+}
+
+// dhcpv6SrvPool is a range of addresses (NA) or of delegated prefixes (PD), both are kept
+// as a range of indexes, an address is the lower 64 bits and a prefix is the upper 64 bits >> (64 - len)
+type dhcpv6SrvPool struct {
+	iaType uint8
+	base   core.Ipv6Key
+	plen   uint8 // delegated prefix length, 128 for an address
+	min    uint64
+	max    uint64
+	next   uint64
+}
+
+func (o *dhcpv6SrvPool) createNa(p *Dhcpv6SrvNaPool) error {
+	if ipv6Hi(&p.Min) != ipv6Hi(&p.Max) || ipv6Lo(&p.Max) < ipv6Lo(&p.Min) {
+		return fmt.Errorf("pool %v-%v is not a range in a /64 subnet", p.Min.ToIP(), p.Max.ToIP())
+	}
+	o.iaType = DHCPV6SRV_IA_NA
+	o.base = p.Min
+	o.plen = 128
+	o.min = ipv6Lo(&p.Min)
+	o.max = ipv6Lo(&p.Max)
+	o.next = o.min
+	return nil
+}
+
+func (o *dhcpv6SrvPool) createPd(p *Dhcpv6SrvPdPool) error {
+	/* 0 < prefix_len <= delegated_len <= 64 */
+	if p.PrefixLen == 0 || p.DelegatedLen > 64 {
+		return fmt.Errorf("invalid prefix /%d or delegated /%d length", p.PrefixLen, p.DelegatedLen)
+	}
+	if p.DelegatedLen < p.PrefixLen || p.DelegatedLen-p.PrefixLen > 32 {
+		return fmt.Errorf("can't delegate /%d prefixes from a /%d prefix", p.DelegatedLen, p.PrefixLen)
+	}
+	o.iaType = DHCPV6SRV_IA_PD
+	o.base = p.Prefix
+	o.plen = p.DelegatedLen
+	shift := 64 - uint64(p.DelegatedLen)
+	prefix := (ipv6Hi(&p.Prefix) >> (64 - uint64(p.PrefixLen))) << (64 - uint64(p.PrefixLen))
+	o.min = prefix >> shift
+	o.max = o.min + (uint64(1) << (p.DelegatedLen - p.PrefixLen)) - 1
+	o.next = o.min
+	return nil
+}
+
+// index return the index of the address/prefix in the pool
+func (o *dhcpv6SrvPool) index(ip *core.Ipv6Key) (uint64, bool) {
+	var idx uint64
+	if o.iaType == DHCPV6SRV_IA_NA {
+		if ipv6Hi(ip) != ipv6Hi(&o.base) {
+			return 0, false
+		}
+		idx = ipv6Lo(ip)
+	} else {
+		idx = ipv6Hi(ip) >> (64 - uint64(o.plen))
+	}
+	return idx, idx >= o.min && idx <= o.max
+}
+
+func (o *dhcpv6SrvPool) addr(idx uint64) core.Ipv6Key {
+	var ip core.Ipv6Key
+	if o.iaType == DHCPV6SRV_IA_NA {
+		copy(ip[0:8], o.base[0:8])
+		binary.BigEndian.PutUint64(ip[8:16], idx)
+	} else {
+		binary.BigEndian.PutUint64(ip[0:8], idx<<(64-uint64(o.plen)))
+	}
+	return ip
+}
+
+type Dhcpv6SrvLeaseTimer struct {
+}
+
+func (o *Dhcpv6SrvLeaseTimer) OnEvent(a, b interface{}) {
+	srv := a.(*PluginDhcpv6SrvClient)
+	lease := b.(*Dhcpv6SrvLease)
+	srv.onLeaseTimer(lease)
+}
+
+// Dhcpv6SrvLease an address or a prefix that was offered/bound/declined
+type Dhcpv6SrvLease struct {
+	dlist     core.DList
+	timer     core.CHTimerObj
+	iaType    uint8
+	addr      core.Ipv6Key
+	plen      uint8
+	key       string
+	duid      []byte
+	iaid      uint32
+	mac       core.MACKey
+	state     uint8
+	validSec  uint32
+	expireTck uint64
+}
+
+func covertToLease(dlist *core.DList) *Dhcpv6SrvLease {
+	var s Dhcpv6SrvLease
+	return (*Dhcpv6SrvLease)(unsafe.Pointer(uintptr(unsafe.Pointer(dlist)) - unsafe.Offsetof(s.dlist)))
+}
+
+// Dhcpv6SrvLeaseRec is the RPC view of a lease
+type Dhcpv6SrvLeaseRec struct {
+	Type      string       `json:"type"`
+	Ipv6      core.Ipv6Key `json:"ipv6"`
+	PrefixLen uint8        `json:"prefix_len"`
+	Duid      string       `json:"duid"`
+	Iaid      uint32       `json:"iaid"`
+	Mac       core.MACKey  `json:"mac"`
+	State     string       `json:"state"`
+	ValidSec  uint32       `json:"valid"`
+	RemainSec uint32       `json:"remain"`
+}
+
+// dhcpv6SrvIa is an IA_NA/IA_PD of a request
+type dhcpv6SrvIa struct {
+	iaType uint8
+	iaid   uint32
+	hint   core.Ipv6Key
+	status uint16
+	lease  *Dhcpv6SrvLease
+}
+
+// dhcpv6SrvReq is a parsed request
+type dhcpv6SrvReq struct {
+	dhcph       layers.DHCPv6
+	srcMac      core.MACKey
+	srcIp       core.Ipv6Key
+	clientId    []byte
+	serverId    []byte
+	rapidCommit bool
+	oro         []byte
+	ias         []dhcpv6SrvIa
+}
+
+func (o *dhcpv6SrvReq) requested(opt uint16) bool {
+	for i := 0; i+2 <= len(o.oro); i += 2 {
+		if binary.BigEndian.Uint16(o.oro[i:i+2]) == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// PluginDhcpv6SrvClient the DHCPv6 server, per client
+type PluginDhcpv6SrvClient struct {
+	core.PluginBase
+	timerw     *core.TimerCtx
+	nsPlug     *PluginDhcpv6SrvNs
+	init       Dhcpv6SrvInit
+	pools      []dhcpv6SrvPool
+	duid       []byte
+	leaseByIp  map[core.Ipv6Key]*Dhcpv6SrvLease
+	leaseByKey map[string]*Dhcpv6SrvLease
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
+	timerCb    Dhcpv6SrvLeaseTimer
+	stats      Dhcpv6SrvStats
+	cdb        *core.CCounterDb
+	cdbv       *core.CCounterDbVec
+	valid      bool
+}
+
+var dhcpv6SrvEvents = []string{}
+
+/*NewDhcpv6SrvClient create plugin */
+func NewDhcpv6SrvClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpv6SrvClient)
+	o.InitPluginBase(ctx, o)                  /* init base object*/
+	o.RegisterEvents(ctx, dhcpv6SrvEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(DHCPV6SRV_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginDhcpv6SrvNs)
+	o.OnCreate()
+
+	err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+	if err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.pools = make([]dhcpv6SrvPool, len(o.init.NaPools)+len(o.init.PdPools))
+	for i := range o.init.NaPools {
+		if o.pools[i].createNa(&o.init.NaPools[i]) != nil {
+			o.stats.invalidInitJson++
+			return &o.PluginBase
+		}
+	}
+	for i := range o.init.PdPools {
+		if o.pools[len(o.init.NaPools)+i].createPd(&o.init.PdPools[i]) != nil {
+			o.stats.invalidInitJson++
+			return &o.PluginBase
+		}
+	}
+	if o.init.PreferredLifetime == 0 {
+		o.init.PreferredLifetime = dhcpv6SrvPreferredLifetime
+	}
+	if o.init.ValidLifetime == 0 {
+		o.init.ValidLifetime = dhcpv6SrvValidLifetime
+	}
+	if o.init.ValidLifetime < o.init.PreferredLifetime {
+		o.init.ValidLifetime = o.init.PreferredLifetime
+	}
+	if o.init.T1 == 0 {
+		o.init.T1 = o.init.PreferredLifetime / 2
+	}
+	if o.init.T2 == 0 {
+		o.init.T2 = uint32(uint64(o.init.PreferredLifetime) * 4 / 5)
+	}
+	if o.init.OfferHold == 0 {
+		o.init.OfferHold = dhcpv6SrvOfferHold
+	}
+	o.valid = true
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpv6SrvClient) OnCreate() {
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.leaseByIp = make(map[core.Ipv6Key]*Dhcpv6SrvLease)
+	o.leaseByKey = make(map[string]*Dhcpv6SrvLease)
+	o.head.SetSelf()
+	duid := &layers.DHCPv6DUID{Type: layers.DHCPv6DUIDTypeLL, HardwareType: []byte{0, 1}, LinkLayerAddress: o.Client.Mac[:]}
+	o.duid = duid.Encode()
+	o.nsPlug.servers = append(o.nsPlug.servers, o)
+	o.cdb = NewDhcpv6SrvStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(DHCPV6SRV_PLUG)
+	o.cdbv.Add(o.cdb)
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginDhcpv6SrvClient) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDhcpv6SrvClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginDhcpv6SrvClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, dhcpv6SrvEvents)
+	o.nsPlug.removeServer(o)
+	for _, lease := range o.leaseByIp {
+		if lease.timer.IsRunning() {
+			o.timerw.Stop(&lease.timer)
+		}
+	}
+}
+
+func leaseKey(iaType uint8, duid []byte, iaid uint32) string {
+	var b [5]byte
+	b[0] = iaType
+	binary.BigEndian.PutUint32(b[1:5], iaid)
+	return string(b[:]) + string(duid)
+}
+
+func (o *PluginDhcpv6SrvClient) isFree(ip core.Ipv6Key) bool {
+	_, ok := o.leaseByIp[ip]
+	return !ok
+}
+
+func (o *PluginDhcpv6SrvClient) allocate(ia *dhcpv6SrvIa) (core.Ipv6Key, uint8, bool) {
+	/* try the address/prefix the client asked for */
+	if !ia.hint.IsZero() {
+		for i := range o.pools {
+			pool := &o.pools[i]
+			if idx, ok := pool.index(&ia.hint); ok && pool.iaType == ia.iaType {
+				if ip := pool.addr(idx); o.isFree(ip) {
+					return ip, pool.plen, true
+				}
+			}
+		}
+	}
+	for i := range o.pools {
+		pool := &o.pools[i]
+		if pool.iaType != ia.iaType {
+			continue
+		}
+		for cnt := pool.max - pool.min; ; cnt-- {
+			ip := pool.addr(pool.next)
+			if pool.next == pool.max {
+				pool.next = pool.min
+			} else {
+				pool.next++
+			}
+			if o.isFree(ip) {
+				return ip, pool.plen, true
+			}
+			if cnt == 0 {
+				break
+			}
+		}
+	}
+	return core.Ipv6Key{}, 0, false
+}
+
+func (o *PluginDhcpv6SrvClient) startLeaseTimer(lease *Dhcpv6SrvLease, sec uint32) {
+	if lease.timer.IsRunning() {
+		o.timerw.Stop(&lease.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(sec) * time.Second)
+	lease.expireTck = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&lease.timer, ticks)
+}
+
+func (o *PluginDhcpv6SrvClient) addLease(addr core.Ipv6Key, plen uint8, req *dhcpv6SrvReq, ia *dhcpv6SrvIa) *Dhcpv6SrvLease {
+	lease := new(Dhcpv6SrvLease)
+	lease.iaType = ia.iaType
+	lease.addr = addr
+	lease.plen = plen
+	lease.duid = append([]byte{}, req.clientId...)
+	lease.iaid = ia.iaid
+	lease.key = leaseKey(ia.iaType, req.clientId, ia.iaid)
+	lease.mac = req.srcMac
+	lease.timer.SetCB(&o.timerCb, o, lease)
+	o.leaseByIp[addr] = lease
+	o.leaseByKey[lease.key] = lease
+	o.head.AddLast(&lease.dlist)
+	o.stats.leaseActive++
+	return lease
+}
+
+func (o *PluginDhcpv6SrvClient) removeLease(lease *Dhcpv6SrvLease) {
+	if lease.timer.IsRunning() {
+		o.timerw.Stop(&lease.timer)
+	}
+	if o.activeIter == &lease.dlist {
+		o.activeIter = lease.dlist.Next()
+	}
+	o.head.RemoveNode(&lease.dlist)
+	delete(o.leaseByIp, lease.addr)
+	if l, ok := o.leaseByKey[lease.key]; ok && l == lease {
+		delete(o.leaseByKey, lease.key)
+	}
+	o.stats.leaseActive--
+}
+
+func (o *PluginDhcpv6SrvClient) onLeaseTimer(lease *Dhcpv6SrvLease) {
+	switch lease.state {
+	case DHCPV6SRV_LEASE_OFFERED:
+		o.stats.leaseOfferExpired++
+	case DHCPV6SRV_LEASE_BOUND:
+		o.stats.leaseExpired++
+	}
+	o.removeLease(lease)
+}
+
+// getLease return the lease of the IA, declined leases are not a binding
+func (o *PluginDhcpv6SrvClient) getLease(req *dhcpv6SrvReq, ia *dhcpv6SrvIa) *Dhcpv6SrvLease {
+	lease, ok := o.leaseByKey[leaseKey(ia.iaType, req.clientId, ia.iaid)]
+	if !ok || lease.state == DHCPV6SRV_LEASE_DECLINED {
+		return nil
+	}
+	return lease
+}
+
+func (o *PluginDhcpv6SrvClient) setNoAvail(ia *dhcpv6SrvIa) {
+	if ia.iaType == DHCPV6SRV_IA_NA {
+		o.stats.noAddrsAvail++
+		ia.status = STATUS_NoAddrsAvail
+	} else {
+		o.stats.noPrefixAvail++
+		ia.status = STATUS_NoPrefixAvail
+	}
+}
+
+// offer reserve an address/prefix for each IA of a solicit
+func (o *PluginDhcpv6SrvClient) offer(req *dhcpv6SrvReq) {
+	for i := range req.ias {
+		ia := &req.ias[i]
+		lease := o.getLease(req, ia)
+		if lease == nil {
+			addr, plen, found := o.allocate(ia)
+			if !found {
+				o.setNoAvail(ia)
+				continue
+			}
+			lease = o.addLease(addr, plen, req, ia)
+		}
+		if lease.state == DHCPV6SRV_LEASE_OFFERED {
+			o.startLeaseTimer(lease, o.init.OfferHold)
+		}
+		ia.lease = lease
+	}
+}
+
+// bind bind each IA of the request, allocate a new lease in case there is no binding and alloc is set
+func (o *PluginDhcpv6SrvClient) bind(req *dhcpv6SrvReq, alloc bool) {
+	for i := range req.ias {
+		ia := &req.ias[i]
+		lease := o.getLease(req, ia)
+		if lease == nil {
+			if !alloc {
+				o.stats.noBinding++
+				ia.status = STATUS_NoBinding
+				continue
+			}
+			addr, plen, found := o.allocate(ia)
+			if !found {
+				o.setNoAvail(ia)
+				continue
+			}
+			lease = o.addLease(addr, plen, req, ia)
+		}
+		if lease.state != DHCPV6SRV_LEASE_BOUND {
+			o.stats.leaseBound++
+		}
+		lease.state = DHCPV6SRV_LEASE_BOUND
+		lease.mac = req.srcMac
+		lease.validSec = o.init.ValidLifetime
+		o.startLeaseTimer(lease, lease.validSec)
+		ia.lease = lease
+	}
+}
+
+// encodeOption encode an option, to be used as a sub option
+func encodeOption(o layers.DHCPv6Option) []byte {
+	b := make([]byte, 4, 4+len(o.Data))
+	binary.BigEndian.PutUint16(b[0:2], uint16(o.Code))
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(o.Data)))
+	return append(b, o.Data...)
+}
+
+func statusOption(status uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, status)
+	return b
+}
+
+// iaOption build the IA_NA/IA_PD option of the reply
+func (o *PluginDhcpv6SrvClient) iaOption(ia *dhcpv6SrvIa) layers.DHCPv6Option {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], ia.iaid)
+	code := layers.DHCPv6OptIANA
+	if ia.iaType == DHCPV6SRV_IA_PD {
+		code = layers.DHCPv6OptIAPD
+	}
+
+	if ia.lease == nil {
+		sub := layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, statusOption(ia.status))
+		return layers.NewDHCPv6Option(code, append(b, encodeOption(sub)...))
+	}
+
+	binary.BigEndian.PutUint32(b[4:8], o.init.T1)
+	binary.BigEndian.PutUint32(b[8:12], o.init.T2)
+	var lifetimes [8]byte
+	binary.BigEndian.PutUint32(lifetimes[0:4], o.init.PreferredLifetime)
+	binary.BigEndian.PutUint32(lifetimes[4:8], o.init.ValidLifetime)
+
+	var sub layers.DHCPv6Option
+	if ia.iaType == DHCPV6SRV_IA_NA {
+		data := make([]byte, 0, 24)
+		data = append(data, ia.lease.addr[:]...)
+		data = append(data, lifetimes[:]...)
+		sub = layers.NewDHCPv6Option(layers.DHCPv6OptIAAddr, data)
+	} else {
+		data := make([]byte, 0, 25)
+		data = append(data, lifetimes[:]...)
+		data = append(data, ia.lease.plen)
+		data = append(data, ia.lease.addr[:]...)
+		sub = layers.NewDHCPv6Option(layers.DHCPv6OptIAPrefix, data)
+	}
+	return layers.NewDHCPv6Option(code, append(b, encodeOption(sub)...))
+}
+
+// encodeDomainList encode the domain search list, RFC 1035 3.1
+func encodeDomainList(domains []string) []byte {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.Trim(d, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				continue
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
+
+// sendReply build the advertise/reply and send it to the client
+func (o *PluginDhcpv6SrvClient) sendReply(req *dhcpv6SrvReq, msgType layers.DHCPv6MsgType, status *uint16, rapidCommit bool) {
+
+	dhcph := &layers.DHCPv6{MsgType: msgType, TransactionID: req.dhcph.TransactionID}
+
+	if req.clientId != nil {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6OptClientID, req.clientId))
+	}
+	dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6OptServerID, o.duid))
+
+	for i := range req.ias {
+		dhcph.Options = append(dhcph.Options, o.iaOption(&req.ias[i]))
+	}
+	if msgType == layers.DHCPv6MsgTypeAdverstise && o.init.Preference > 0 {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6OptPreference, []byte{o.init.Preference}))
+	}
+	if status != nil {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6OptStatusCode, statusOption(*status)))
+	}
+	if rapidCommit {
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6OptRapidCommit, []byte{}))
+	}
+	if len(o.init.Dns) > 0 && req.requested(DHCPV6_OPT_DNS_SERVERS) {
+		var dns []byte
+		for _, ip := range o.init.Dns {
+			dns = append(dns, ip[:]...)
+		}
+		dhcph.Options = append(dhcph.Options, layers.NewDHCPv6Option(layers.DHCPv6Opt(DHCPV6_OPT_DNS_SERVERS), dns))
+	}
+	if len(o.init.Domains) > 0 && req.requested(DHCPV6_OPT_DOMAIN_LIST) {
+		dhcph.Options = append(dhcph.Options,
+			layers.NewDHCPv6Option(layers.DHCPv6Opt(DHCPV6_OPT_DOMAIN_LIST), encodeDomainList(o.init.Domains)))
+	}
+
+	l2 := o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	copy(l2[0:6], req.srcMac[:])
+
+	var l6 core.Ipv6Key
+	o.Client.GetIpv6LocalLink(&l6)
+
+	d := core.PacketUtlBuild(
+		&layers.IPv6{
+			Version:    6,
+			Length:     8,
+			NextHeader: layers.IPProtocolUDP,
+			HopLimit:   64,
+			SrcIP:      l6.ToIP(),
+			DstIP:      req.srcIp.ToIP(),
+		},
+
+		&layers.UDP{SrcPort: 547, DstPort: 546},
+		dhcph,
+	)
+
+	ipv6 := layers.IPv6Header(d[0:IPV6_HEADER_SIZE])
+	binary.BigEndian.PutUint16(d[IPV6_HEADER_SIZE+4:IPV6_HEADER_SIZE+6], uint16(len(d)-IPV6_HEADER_SIZE))
+	ipv6.SetPyloadLength(uint16(len(d) - IPV6_HEADER_SIZE))
+	ipv6.FixUdpL4Checksum(d[IPV6_HEADER_SIZE:], 0)
+
+	if msgType == layers.DHCPv6MsgTypeAdverstise {
+		o.stats.pktTxAdvertise++
+	} else {
+		o.stats.pktTxReply++
+	}
+	o.Tctx.Veth.SendBuffer(false, o.Client, append(l2, d...))
+}
+
+func (o *PluginDhcpv6SrvClient) handleSolicit(req *dhcpv6SrvReq) {
+	if req.serverId != nil {
+		o.stats.pktRxWrongServerId++
+		return
+	}
+	if req.rapidCommit && o.init.RapidCommit {
+		o.bind(req, true)
+		o.stats.pktTxRapidCommit++
+		o.sendReply(req, layers.DHCPv6MsgTypeReply, nil, true)
+		return
+	}
+	o.offer(req)
+	o.sendReply(req, layers.DHCPv6MsgTypeAdverstise, nil, false)
+}
+
+func (o *PluginDhcpv6SrvClient) handleRelease(req *dhcpv6SrvReq) {
+	for i := range req.ias {
+		ia := &req.ias[i]
+		if lease := o.getLease(req, ia); lease != nil {
+			o.removeLease(lease)
+			continue
+		}
+		o.stats.noBinding++
+		ia.status = STATUS_NoBinding
+	}
+	/* only the IAs without a binding are in the reply */
+	ias := req.ias[:0]
+	for _, ia := range req.ias {
+		if ia.status != STATUS_Success {
+			ias = append(ias, ia)
+		}
+	}
+	req.ias = ias
+	status := uint16(STATUS_Success)
+	o.sendReply(req, layers.DHCPv6MsgTypeReply, &status, false)
+}
+
+func (o *PluginDhcpv6SrvClient) handleDecline(req *dhcpv6SrvReq) {
+	for i := range req.ias {
+		ia := &req.ias[i]
+		lease := o.getLease(req, ia)
+		if lease == nil || ia.iaType != DHCPV6SRV_IA_NA {
+			continue
+		}
+		/* the address is used by someone else, keep it out of the pool for a while */
+		delete(o.leaseByKey, lease.key)
+		lease.key = ""
+		lease.state = DHCPV6SRV_LEASE_DECLINED
+		o.startLeaseTimer(lease, o.init.ValidLifetime)
+	}
+	req.ias = nil
+	status := uint16(STATUS_Success)
+	o.sendReply(req, layers.DHCPv6MsgTypeReply, &status, false)
+}
+
+func (o *PluginDhcpv6SrvClient) handleConfirm(req *dhcpv6SrvReq) {
+	if len(req.ias) == 0 {
+		return
+	}
+	status := uint16(STATUS_Success)
+	for _, ia := range req.ias {
+		onLink := false
+		for i := range o.pools {
+			if _, ok := o.pools[i].index(&ia.hint); ok && o.pools[i].iaType == DHCPV6SRV_IA_NA {
+				onLink = true
+				break
+			}
+		}
+		if !onLink {
+			status = STATUS_NotOnLink
+		}
+	}
+	req.ias = nil
+	o.sendReply(req, layers.DHCPv6MsgTypeReply, &status, false)
+}
+
+func decodeIa(iaType uint8, data []byte, ia *dhcpv6SrvIa) bool {
+	if len(data) < 12 {
+		return false
+	}
+	ia.iaType = iaType
+	ia.iaid = binary.BigEndian.Uint32(data[0:4])
+	p := data[12:]
+	for len(p) >= 4 {
+		code := layers.DHCPv6Opt(binary.BigEndian.Uint16(p[0:2]))
+		length := int(binary.BigEndian.Uint16(p[2:4]))
+		if len(p) < 4+length {
+			return false
+		}
+		v := p[4 : 4+length]
+		switch {
+		case code == layers.DHCPv6OptIAAddr && iaType == DHCPV6SRV_IA_NA && length >= 16:
+			copy(ia.hint[:], v[0:16])
+		case code == layers.DHCPv6OptIAPrefix && iaType == DHCPV6SRV_IA_PD && length >= 25:
+			copy(ia.hint[:], v[9:25])
+		}
+		p = p[4+length:]
+	}
+	return true
+}
+
+func (o *PluginDhcpv6SrvClient) parseReq(ps *core.ParserPacketState, req *dhcpv6SrvReq) int {
+	p := ps.M.GetData()
+	dhcphlen := ps.L7Len
+
+	if dhcphlen < 4 {
+		o.stats.pktRxLenErr++
+		return core.PARSER_ERR
+	}
+
+	err := req.dhcph.DecodeFromBytes(p[ps.L7:ps.L7+dhcphlen], gopacket.NilDecodeFeedback)
+	if err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+
+	copy(req.srcMac[:], p[6:12])
+	ipv6 := layers.IPv6Header(p[ps.L3 : ps.L3+IPV6_HEADER_SIZE])
+	copy(req.srcIp[:], ipv6.SrcIP())
+
+	for _, op := range req.dhcph.Options {
+		var ia dhcpv6SrvIa
+		switch op.Code {
+		case layers.DHCPv6OptClientID:
+			req.clientId = op.Data
+		case layers.DHCPv6OptServerID:
+			req.serverId = op.Data
+		case layers.DHCPv6OptRapidCommit:
+			req.rapidCommit = true
+		case layers.DHCPv6OptOro:
+			req.oro = op.Data
+		case layers.DHCPv6OptIANA:
+			if !decodeIa(DHCPV6SRV_IA_NA, op.Data, &ia) {
+				o.stats.pktRxParserErr++
+				return core.PARSER_ERR
+			}
+			req.ias = append(req.ias, ia)
+		case layers.DHCPv6OptIAPD:
+			if !decodeIa(DHCPV6SRV_IA_PD, op.Data, &ia) {
+				o.stats.pktRxParserErr++
+				return core.PARSER_ERR
+			}
+			req.ias = append(req.ias, ia)
+		}
+	}
+	return core.PARSER_OK
+}
+
+// checkServerId verify the server id option, RFC 8415 16
+func (o *PluginDhcpv6SrvClient) checkServerId(req *dhcpv6SrvReq, must bool) bool {
+	if req.serverId == nil {
+		if must {
+			o.stats.pktRxWrongServerId++
+		}
+		return !must
+	}
+	if !bytes.Equal(req.serverId, o.duid) {
+		o.stats.pktRxWrongServerId++
+		return false
+	}
+	return true
+}
+
+func (o *PluginDhcpv6SrvClient) HandleRxDhcpv6Packet(ps *core.ParserPacketState) int {
+
+	if !o.valid {
+		return core.PARSER_ERR
+	}
+
+	var req dhcpv6SrvReq
+	if o.parseReq(ps, &req) != core.PARSER_OK {
+		return core.PARSER_ERR
+	}
+
+	msgType := req.dhcph.MsgType
+	if req.clientId == nil && msgType != layers.DHCPv6MsgTypeInformationRequest {
+		o.stats.pktRxNoClientId++
+		return core.PARSER_ERR
+	}
+
+	switch msgType {
+	case layers.DHCPv6MsgTypeSolicit, layers.DHCPv6MsgTypeRequest, layers.DHCPv6MsgTypeRenew,
+		layers.DHCPv6MsgTypeRebind, layers.DHCPv6MsgTypeRelease, layers.DHCPv6MsgTypeDecline,
+		layers.DHCPv6MsgTypeConfirm:
+		if o.init.Stateless {
+			o.stats.pktRxStateless++
+			return core.PARSER_OK
+		}
+	}
+
+	switch msgType {
+	case layers.DHCPv6MsgTypeSolicit:
+		o.stats.pktRxSolicit++
+		o.handleSolicit(&req)
+	case layers.DHCPv6MsgTypeRequest:
+		o.stats.pktRxRequest++
+		if o.checkServerId(&req, true) {
+			o.bind(&req, true)
+			o.sendReply(&req, layers.DHCPv6MsgTypeReply, nil, false)
+		}
+	case layers.DHCPv6MsgTypeRenew:
+		o.stats.pktRxRenew++
+		if o.checkServerId(&req, true) {
+			o.bind(&req, false)
+			o.sendReply(&req, layers.DHCPv6MsgTypeReply, nil, false)
+		}
+	case layers.DHCPv6MsgTypeRebind:
+		/* any server can extend the lease, create a new binding in case the server has no record */
+		o.stats.pktRxRebind++
+		if req.serverId == nil {
+			o.bind(&req, true)
+			o.sendReply(&req, layers.DHCPv6MsgTypeReply, nil, false)
+		}
+	case layers.DHCPv6MsgTypeRelease:
+		o.stats.pktRxRelease++
+		if o.checkServerId(&req, true) {
+			o.handleRelease(&req)
+		}
+	case layers.DHCPv6MsgTypeDecline:
+		o.stats.pktRxDecline++
+		if o.checkServerId(&req, true) {
+			o.handleDecline(&req)
+		}
+	case layers.DHCPv6MsgTypeConfirm:
+		o.stats.pktRxConfirm++
+		if req.serverId == nil {
+			o.handleConfirm(&req)
+		}
+	case layers.DHCPv6MsgTypeInformationRequest:
+		o.stats.pktRxInfoRequest++
+		if o.checkServerId(&req, false) {
+			req.ias = nil
+			o.sendReply(&req, layers.DHCPv6MsgTypeReply, nil, false)
+		}
+	default:
+		o.stats.pktRxUnhandled++
+	}
+	return core.PARSER_OK
+}
+
+func (o *PluginDhcpv6SrvClient) leaseRec(lease *Dhcpv6SrvLease) Dhcpv6SrvLeaseRec {
+	var rec Dhcpv6SrvLeaseRec
+	rec.Type = dhcpv6SrvIaNames[lease.iaType]
+	rec.Ipv6 = lease.addr
+	rec.PrefixLen = lease.plen
+	rec.Duid = hex.EncodeToString(lease.duid)
+	rec.Iaid = lease.iaid
+	rec.Mac = lease.mac
+	rec.State = dhcpv6SrvLeaseStateNames[lease.state]
+	rec.ValidSec = lease.validSec
+	if lease.expireTck > o.timerw.Ticks {
+		remain := time.Duration(lease.expireTck-o.timerw.Ticks) * o.timerw.TickDuration
+		rec.RemainSec = uint32(remain / time.Second)
+	}
+	return rec
+}
+
+func (o *PluginDhcpv6SrvClient) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginDhcpv6SrvClient) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginDhcpv6SrvClient) GetNext(n uint16) ([]Dhcpv6SrvLeaseRec, error) {
+	r := make([]Dhcpv6SrvLeaseRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false // require a new reset
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.leaseRec(covertToLease(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
+
+// PluginDhcpv6SrvNs dispatch the rx packets to the server of the namespace
+type PluginDhcpv6SrvNs struct {
+	core.PluginBase
+	servers []*PluginDhcpv6SrvClient
+}
+
+func NewDhcpv6SrvNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginDhcpv6SrvNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+
+	return &o.PluginBase
+}
+
+func (o *PluginDhcpv6SrvNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginDhcpv6SrvNs) OnEvent(msg string, a, b interface{}) {
+
+}
+
+// getServer return the server that should handle the packet, by the destination MAC or the first server for multicast
+func (o *PluginDhcpv6SrvNs) getServer(ps *core.ParserPacketState) *PluginDhcpv6SrvClient {
+	p := ps.M.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	if !mackey.IsMulticast() {
+		client := o.Ns.CLookupByMac(&mackey)
+		if client == nil {
+			return nil
+		}
+		cplg := client.PluginCtx.Get(DHCPV6SRV_PLUG)
+		if cplg == nil {
+			return nil
+		}
+		return cplg.Ext.(*PluginDhcpv6SrvClient)
+	}
+
+	if len(o.servers) == 0 {
+		return nil
+	}
+	return o.servers[0]
+}
+
+func (o *PluginDhcpv6SrvNs) removeServer(srv *PluginDhcpv6SrvClient) {
+	for i, s := range o.servers {
+		if s == srv {
+			o.servers = append(o.servers[:i], o.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (o *PluginDhcpv6SrvNs) HandleRxDhcpv6Packet(ps *core.ParserPacketState) int {
+	srv := o.getServer(ps)
+	if srv == nil {
+		return core.PARSER_ERR
+	}
+	return srv.HandleRxDhcpv6Packet(ps)
+}
+
+// HandleRxDhcpv6SrvPacket Parser call this function with mbuf from the pool
+func HandleRxDhcpv6SrvPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(DHCPV6SRV_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	dhcpPlug := nsplg.Ext.(*PluginDhcpv6SrvNs)
+	return dhcpPlug.HandleRxDhcpv6Packet(ps)
+}
+
+type PluginDhcpv6SrvCReg struct{}
+type PluginDhcpv6SrvNsReg struct{}
+
+func (o PluginDhcpv6SrvCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpv6SrvClient(ctx, initJson)
+}
+
+func (o PluginDhcpv6SrvNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewDhcpv6SrvNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDhcpv6SrvClientCntHandler struct{}
+
+	ApiDhcpv6SrvClientIterHandler struct{} // iterate on the lease table
+	ApiDhcpv6SrvClientIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiDhcpv6SrvClientIterResult struct {
+		Empty   bool                `json:"empty"`
+		Stopped bool                `json:"stopped"`
+		Vec     []Dhcpv6SrvLeaseRec `json:"data"`
+	}
+)
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginDhcpv6SrvClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+
+	plug, err := tctx.GetClientPlugin(params, DHCPV6SRV_PLUG)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pClient := plug.Ext.(*PluginDhcpv6SrvClient)
+
+	return pClient, nil
+}
+
+func (h ApiDhcpv6SrvClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiDhcpv6SrvClientIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiDhcpv6SrvClientIterParams
+	var res ApiDhcpv6SrvClientIterResult
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = c.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if c.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	leases, err := c.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res.Vec = leases
+	return &res, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(DHCPV6SRV_PLUG,
+		core.PluginRegisterData{Client: PluginDhcpv6SrvCReg{},
+			Ns:     PluginDhcpv6SrvNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	core.RegisterCB("dhcpv6srv_c_cnt", ApiDhcpv6SrvClientCntHandler{}, false)   // get counters/meta
+	core.RegisterCB("dhcpv6srv_c_iter", ApiDhcpv6SrvClientIterHandler{}, false) // iterate the leases
+
+	/* register callback for rx side*/
+	core.ParserRegister("dhcpv6srv", HandleRxDhcpv6SrvPacket)
+}
+
+func Register(ctx *core.CThreadCtx) {
+	ctx.RegisterParserCb("dhcpv6srv")
+}
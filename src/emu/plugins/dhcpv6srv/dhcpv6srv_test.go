@@ -0,0 +1,313 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dhcpv6srv
+
+import (
+	"bytes"
+	"emu/core"
+	_ "emu/plugins/dhcpv6" // dhcpv6 client plugin
+	"external/google/gopacket/layers"
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+type Dhcpv6SrvTestBase struct {
+	testname     string
+	monitor      bool
+	capture      bool
+	duration     time.Duration
+	clientsToSim int
+	srvJson      string
+	clientJson   string
+	infoRequest  bool // send an information request from the first client
+	cb           Dhcpv6SrvTestCb
+}
+
+type Dhcpv6SrvTestCb func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T)
+
+// VethDhcpv6SrvSim loop back the tx packets, the server and the clients are in the same namespace
+type VethDhcpv6SrvSim struct {
+	tctx *core.CThreadCtx
+}
+
+func (o *VethDhcpv6SrvSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	mr := o.tctx.MPool.Alloc(uint16(len(p)))
+	mr.SetVPort(m.VPort())
+	mr.Append(p)
+	m.FreeMbuf()
+	return mr
+}
+
+func (o *Dhcpv6SrvTestBase) Run(t *testing.T) {
+
+	var simVeth VethDhcpv6SrvSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+	tctx, srv := createSimulationEnv(&simrx, o)
+	simVeth.tctx = tctx
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	if o.infoRequest {
+		sendInfoRequest(tctx, dhcpv6SrvClient(tctx, 0))
+	}
+	tctx.MainLoopSim(o.duration)
+	defer tctx.Delete()
+
+	if o.cb != nil {
+		o.cb(tctx, srv, t)
+	}
+
+	srv.cdbv.Dump()
+	tctx.GetCounterDbVec().Dump()
+	tctx.SimRecordAppend(srv.cdb.MarshalValues(false))
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func createSimulationEnv(simRx *core.VethIFSim, test *Dhcpv6SrvTestBase) (*core.CThreadCtx, *PluginDhcpv6SrvClient) {
+	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	server := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, 1},
+		core.Ipv4Key{0, 0, 0, 0},
+		core.Ipv6Key{},
+		core.Ipv4Key{0, 0, 0, 0})
+	ns.AddClient(server)
+	ns.PluginCtx.CreatePlugins([]string{"dhcpv6"}, [][]byte{})
+	server.PluginCtx.CreatePlugins([]string{DHCPV6SRV_PLUG}, [][]byte{[]byte(test.srvJson)})
+
+	for i := 0; i < test.clientsToSim; i++ {
+		client := core.NewClient(ns, core.MACKey{0, 0, 1, 0, 0, byte(2 + i)},
+			core.Ipv4Key{0, 0, 0, 0},
+			core.Ipv6Key{},
+			core.Ipv4Key{0, 0, 0, 0})
+		ns.AddClient(client)
+		if test.infoRequest {
+			continue
+		}
+		var inijson [][]byte
+		if test.clientJson != "" {
+			inijson = [][]byte{[]byte(test.clientJson)}
+		}
+		client.PluginCtx.CreatePlugins([]string{"dhcpv6"}, inijson)
+	}
+	tctx.RegisterParserCb("dhcpv6")
+	tctx.RegisterParserCb("dhcpv6srv")
+
+	cplg := server.PluginCtx.Get(DHCPV6SRV_PLUG)
+	if cplg == nil {
+		panic(" can't find plugin")
+	}
+	return tctx, cplg.Ext.(*PluginDhcpv6SrvClient)
+}
+
+func dhcpv6SrvClient(tctx *core.CThreadCtx, i int) *core.CClient {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	return ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, byte(2 + i)})
+}
+
+// sendInfoRequest send a stateless information request that asks for the DNS servers and the domain list
+func sendInfoRequest(tctx *core.CThreadCtx, client *core.CClient) {
+	dhcph := &layers.DHCPv6{MsgType: layers.DHCPv6MsgTypeInformationRequest, TransactionID: []byte{0x12, 0x34, 0x56}}
+	dhcph.Options = append(dhcph.Options,
+		layers.NewDHCPv6Option(layers.DHCPv6OptOro, []byte{0, DHCPV6_OPT_DNS_SERVERS, 0, DHCPV6_OPT_DOMAIN_LIST}),
+		layers.NewDHCPv6Option(layers.DHCPv6OptElapsedTime, []byte{0, 0}))
+
+	var l6 core.Ipv6Key
+	client.GetIpv6LocalLink(&l6)
+	l2 := client.GetL2Header(true, uint16(layers.EthernetTypeIPv6))
+	copy(l2[0:6], []byte{0x33, 0x33, 0, 1, 0, 2})
+	d := core.PacketUtlBuild(
+		&layers.IPv6{
+			Version:    6,
+			Length:     8,
+			NextHeader: layers.IPProtocolUDP,
+			HopLimit:   1,
+			SrcIP:      l6.ToIP(),
+			DstIP:      []byte{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 2},
+		},
+		&layers.UDP{SrcPort: 546, DstPort: 547},
+		dhcph,
+	)
+	ipv6 := layers.IPv6Header(d[0:IPV6_HEADER_SIZE])
+	ipv6.SetPyloadLength(uint16(len(d) - IPV6_HEADER_SIZE))
+	d[IPV6_HEADER_SIZE+4] = byte((len(d) - IPV6_HEADER_SIZE) >> 8)
+	d[IPV6_HEADER_SIZE+5] = byte(len(d) - IPV6_HEADER_SIZE)
+	ipv6.FixUdpL4Checksum(d[IPV6_HEADER_SIZE:], 0)
+	tctx.Veth.SendBuffer(false, client, append(l2, d...))
+}
+
+const (
+	naPool = `"na_pools": [{"min": [32, 1, 13, 184, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 16],
+	                        "max": [32, 1, 13, 184, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 32]}]`
+	pdPool = `"pd_pools": [{"prefix": [32, 1, 13, 184, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 40, "delegated_len": 56}]`
+)
+
+func TestPluginDhcpv6Srv1(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv1",
+		monitor:      false,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		srvJson:      `{` + naPool + `, "dns": [[32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 83]], "domains": ["trex.local"]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			for i, exp := range []byte{16, 17} {
+				ip := dhcpv6SrvClient(tctx, i).Dhcpv6
+				if ip[15] != exp || ip[0] != 0x20 {
+					t.Fatalf(" client %d got %v \n", i, ip.ToIP())
+				}
+			}
+			if srv.IterReset() {
+				t.Fatalf(" lease table is empty \n")
+			}
+			leases, _ := srv.GetNext(10)
+			if len(leases) != 2 || leases[0].State != "bound" || leases[0].Type != "na" || leases[0].PrefixLen != 128 {
+				t.Fatalf(" unexpected leases %+v \n", leases)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* rapid commit with an address and a prefix */
+func TestPluginDhcpv6SrvRapidCommitPd(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv2",
+		monitor:      false,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		srvJson:      `{"rapid_commit": true, ` + naPool + `, ` + pdPool + `}`,
+		clientJson:   `{"options": {"sol": [[0, 14]]}, "ia_pd": {"hint_len": 56}}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			if srv.stats.pktTxRapidCommit != 2 || srv.stats.pktTxAdvertise != 0 || srv.stats.leaseActive != 4 {
+				t.Fatalf(" rapid commit failed \n")
+			}
+			c := dhcpv6SrvClient(tctx, 1)
+			if c.Dhcpv6PrefixLen != 56 || !bytes.Equal(c.Dhcpv6Prefix[0:8], []byte{32, 1, 13, 184, 1, 0, 1, 0}) {
+				t.Fatalf(" wrong prefix %v/%d \n", c.Dhcpv6Prefix.ToIP(), c.Dhcpv6PrefixLen)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* short lifetime, the clients renew it */
+func TestPluginDhcpv6SrvRenew(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv3",
+		monitor:      false,
+		capture:      true,
+		duration:     3 * time.Minute,
+		clientsToSim: 1,
+		srvJson:      `{"preferred_lifetime": 60, "valid_lifetime": 90, ` + naPool + `}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			if srv.stats.pktRxRenew < 3 || srv.stats.leaseActive != 1 || srv.stats.leaseExpired != 0 {
+				t.Fatalf(" lease was not renewed, renews %d \n", srv.stats.pktRxRenew)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the pool has one address */
+func TestPluginDhcpv6SrvPoolEmpty(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv4",
+		monitor:      false,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 2,
+		srvJson: `{"na_pools": [{"min": [32, 1, 13, 184, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 16],
+		                         "max": [32, 1, 13, 184, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 16]}]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			if srv.stats.noAddrsAvail == 0 || srv.stats.leaseActive != 1 {
+				t.Fatalf(" expected pool empty \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginDhcpv6SrvStateless(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv5",
+		monitor:      false,
+		capture:      true,
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+		infoRequest:  true,
+		srvJson:      `{"stateless": true, "dns": [[32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 83]], "domains": ["trex.local", "cisco.com"]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			if srv.stats.pktRxInfoRequest != 1 || srv.stats.pktTxReply != 1 || srv.stats.leaseActive != 0 {
+				t.Fatalf(" information request was not answered \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestPluginDhcpv6SrvInvalidJson(t *testing.T) {
+	a := &Dhcpv6SrvTestBase{
+		testname:     "dhcpv6srv6",
+		monitor:      false,
+		capture:      true,
+		duration:     5 * time.Second,
+		clientsToSim: 1,
+		srvJson:      `{"pd_pools": [{"prefix": [32, 1, 13, 184, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 48, "delegated_len": 40}]}`,
+		cb: func(tctx *core.CThreadCtx, srv *PluginDhcpv6SrvClient, t *testing.T) {
+			if srv.stats.invalidInitJson != 1 || srv.stats.pktTxAdvertise != 0 {
+				t.Fatalf(" invalid pool was accepted \n")
+			}
+		},
+	}
+	a.Run(t)
+}
+
+func TestDhcpv6SrvPool(t *testing.T) {
+	var pool dhcpv6SrvPool
+	pd := Dhcpv6SrvPdPool{Prefix: core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x01}, PrefixLen: 40, DelegatedLen: 48}
+	if pool.createPd(&pd) != nil || pool.max-pool.min != 255 {
+		t.Fatalf(" wrong pool size \n")
+	}
+	ip := pool.addr(pool.min + 2)
+	if ip != (core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x01, 0x02}) {
+		t.Fatalf(" wrong prefix %v \n", ip.ToIP())
+	}
+	if idx, ok := pool.index(&ip); !ok || idx != pool.min+2 {
+		t.Fatalf(" wrong index \n")
+	}
+	for _, l := range [][2]uint8{{0, 16}, {48, 40}, {40, 65}, {64, 128}, {16, 64}} {
+		pd = Dhcpv6SrvPdPool{Prefix: core.Ipv6Key{0x20, 0x01}, PrefixLen: l[0], DelegatedLen: l[1]}
+		if pool.createPd(&pd) == nil {
+			t.Fatalf(" invalid prefix length /%d delegated /%d was accepted \n", l[0], l[1])
+		}
+	}
+	pd = Dhcpv6SrvPdPool{Prefix: core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8}, PrefixLen: 32, DelegatedLen: 64}
+	if pool.createPd(&pd) != nil || pool.max-pool.min != 0xffffffff {
+		t.Fatalf(" wrong /64 pool size \n")
+	}
+	if b := encodeDomainList([]string{"trex.local."}); !bytes.Equal(b, []byte("\x04trex\x05local\x00")) {
+		t.Fatalf(" wrong domain list %v \n", b)
+	}
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
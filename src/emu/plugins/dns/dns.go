@@ -336,6 +336,11 @@ func (o *PluginDnsClient) OnAccept(socket transport.SocketApi) transport.ISocket
 // OnEvent callback of the Dns client in case of events.
 func (o *PluginDnsClient) OnEvent(msg string, a, b interface{}) {}
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDnsClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // OnRemove is called when we remove the Dns client.
 func (o *PluginDnsClient) OnRemove(ctx *core.PluginCtx) {
 	ctx.UnregisterEvents(&o.PluginBase, dnsEvents)
@@ -0,0 +1,888 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+802.1X authenticator (PAE) with a local EAP server
+
+The namespace acts as the switch port. A supplicant starts with EAPOL-Start (or the dot1x_ns_auth_start RPC),
+the authenticator sends EAP-Request/Identity and runs EAP-MD5 or EAP-MSCHAPv2 against the local user table.
+The methods are offered by the order of "methods", a NAK of the supplicant selects another one.
+
+Per supplicant (port) state:
+
+	connecting      -> identity was requested
+	authenticating  -> method challenge was sent
+	authenticated   -> authorized, reauthentication after "reauth" sec in case it is not zero
+	held            -> failure, EAPOL-Start is ignored for "quiet" sec
+	disconnected    -> logoff or end of the quiet period
+
+A request is retransmitted each "timeo" sec up to "max_req" times, and then the authentication fails.
+The port stays authorized while it is reauthenticated.
+
+ns init json:
+
+	{"auth": {"mac": [0,0,1,0,0,2], "users": [{"user": "hhaim", "password": "xyz"}], "methods": [4, 26], "reauth": 3600}}
+*/
+
+import (
+	"crypto/md5"
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	DOT1X_AUTH_DISCONNECTED   = 0
+	DOT1X_AUTH_CONNECTING     = 1
+	DOT1X_AUTH_AUTHENTICATING = 2
+	DOT1X_AUTH_AUTHENTICATED  = 3
+	DOT1X_AUTH_HELD           = 4
+
+	dot1xAuthDefTimeoutSec = 30
+	dot1xAuthDefMaxReq     = 2
+	dot1xAuthDefQuietSec   = 60
+	dot1xAuthEapolVer      = 2
+	dot1xAuthServerName    = "emu"
+)
+
+var dot1xAuthStateNames = []string{"disconnected", "connecting", "authenticating", "authenticated", "held"}
+
+type Dot1xAuthUser struct {
+	User     string `json:"user" validate:"required"`
+	Password string `json:"password"`
+}
+
+type Dot1xAuthInit struct {
+	Mac        core.MACKey     `json:"mac"` // source mac of the authenticator
+	Users      []Dot1xAuthUser `json:"users" validate:"dive"`
+	Methods    []uint8         `json:"methods"` // EAP types by preference, MD5 and MSCHAPv2 by default
+	TimeoutSec uint32          `json:"timeo"`   // retransmit timeout of a request
+	MaxReq     uint32          `json:"max_req"` // max retransmits of a request
+	QuietSec   uint32          `json:"quiet"`   // quiet period after a failure
+	ReauthSec  uint32          `json:"reauth"`  // reauthentication period, zero to disable
+}
+
+type Dot1xNsInit struct {
+	Auth *Dot1xAuthInit `json:"auth"`
+}
+
+type Dot1xAuthStats struct {
+	invalidInitJson uint64
+	pktRxStart      uint64
+	pktRxLogoff     uint64
+	pktRxResponse   uint64
+	pktRxNak        uint64
+	pktRxParserErr  uint64
+	pktRxWrongId    uint64
+	pktRxNoPort     uint64
+	pktRxHeld       uint64
+	pktRxUnexpected uint64
+	pktTxRequest    uint64
+	pktTxRetransmit uint64
+	pktTxSuccess    uint64
+	pktTxFailure    uint64
+	authOk          uint64
+	authFail        uint64
+	authTimeout     uint64
+	authUnknownUser uint64
+	reauth          uint64
+	portActive      uint64
+	portAuthorized  uint64
+}
+
+func NewDot1xAuthStatsDb(o *Dot1xAuthStats) *core.CCounterDb {
+	db := core.NewCCounterDb("dot1xauth")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "invalid init json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxStart,
+		Name:     "pktRxStart",
+		Help:     "rx EAPOL-Start",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxLogoff,
+		Name:     "pktRxLogoff",
+		Help:     "rx EAPOL-Logoff",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxResponse,
+		Name:     "pktRxResponse",
+		Help:     "rx EAP response",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNak,
+		Name:     "pktRxNak",
+		Help:     "rx EAP nak",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "rx parser error",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxWrongId,
+		Name:     "pktRxWrongId",
+		Help:     "rx response with wrong id",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoPort,
+		Name:     "pktRxNoPort",
+		Help:     "rx response of an unknown supplicant",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxHeld,
+		Name:     "pktRxHeld",
+		Help:     "rx ignored in the quiet period",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnexpected,
+		Name:     "pktRxUnexpected",
+		Help:     "rx response in the wrong state",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRequest,
+		Name:     "pktTxRequest",
+		Help:     "tx EAP request",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRetransmit,
+		Name:     "pktTxRetransmit",
+		Help:     "tx EAP request retransmit",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxSuccess,
+		Name:     "pktTxSuccess",
+		Help:     "tx EAP success",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxFailure,
+		Name:     "pktTxFailure",
+		Help:     "tx EAP failure",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authOk,
+		Name:     "authOk",
+		Help:     "successful authentications",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authFail,
+		Name:     "authFail",
+		Help:     "failed authentications",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTimeout,
+		Name:     "authTimeout",
+		Help:     "authentications without a response",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authUnknownUser,
+		Name:     "authUnknownUser",
+		Help:     "identity not in the user table",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reauth,
+		Name:     "reauth",
+		Help:     "reauthentications",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.portActive,
+		Name:     "portActive",
+		Help:     "supplicants",
+		Unit:     "ports",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.portAuthorized,
+		Name:     "portAuthorized",
+		Help:     "authorized supplicants",
+		Unit:     "ports",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type Dot1xAuthPortTimer struct {
+}
+
+func (o *Dot1xAuthPortTimer) OnEvent(a, b interface{}) {
+	auth := a.(*Dot1xAuth)
+	port := b.(*Dot1xAuthPort)
+	auth.onPortTimer(port)
+}
+
+// Dot1xAuthPort the state of one supplicant
+type Dot1xAuthPort struct {
+	dlist        core.DList
+	timer        core.CHTimerObj
+	mac          core.MACKey
+	state        uint8
+	authorized   bool
+	id           uint8
+	user         *Dot1xAuthUser
+	identity     string
+	method       uint8
+	challenge    []byte
+	authResponse string // MSCHAPv2, waiting for the ack of the success
+	reqType      uint8  // last request for retransmit
+	reqData      []byte
+	reqCnt       uint32
+	authCnt      uint32
+	failCnt      uint32
+	expireTck    uint64
+}
+
+func covertToAuthPort(dlist *core.DList) *Dot1xAuthPort {
+	var s Dot1xAuthPort
+	return (*Dot1xAuthPort)(unsafe.Pointer(uintptr(unsafe.Pointer(dlist)) - unsafe.Offsetof(s.dlist)))
+}
+
+// Dot1xAuthPortRec is the RPC view of a port
+type Dot1xAuthPortRec struct {
+	Mac        core.MACKey `json:"mac"`
+	State      string      `json:"state"`
+	Authorized bool        `json:"authorized"`
+	User       string      `json:"user"`
+	Method     uint8       `json:"method"`
+	AuthCnt    uint32      `json:"auth_cnt"`
+	FailCnt    uint32      `json:"fail_cnt"`
+	RemainSec  uint32      `json:"remain"` // to the next timer event, retransmit/quiet/reauth
+}
+
+// Dot1xAuth the authenticator of the namespace
+type Dot1xAuth struct {
+	nsPlug     *PluginDot1xNs
+	timerw     *core.TimerCtx
+	init       Dot1xAuthInit
+	users      map[string]*Dot1xAuthUser
+	ports      map[core.MACKey]*Dot1xAuthPort
+	head       core.DList
+	activeIter *core.DList
+	iterReady  bool
+	timerCb    Dot1xAuthPortTimer
+	stats      *Dot1xAuthStats
+	l2         []byte
+}
+
+func NewDot1xAuth(nsPlug *PluginDot1xNs, init *Dot1xAuthInit, stats *Dot1xAuthStats) *Dot1xAuth {
+	o := new(Dot1xAuth)
+	o.nsPlug = nsPlug
+	o.timerw = nsPlug.Tctx.GetTimerCtx()
+	o.init = *init
+	o.stats = stats
+	if len(o.init.Methods) == 0 {
+		o.init.Methods = []uint8{EAP_TYPE_MD5, EAP_TYPE_MSCHAPV2}
+	}
+	if o.init.TimeoutSec == 0 {
+		o.init.TimeoutSec = dot1xAuthDefTimeoutSec
+	}
+	if o.init.MaxReq == 0 {
+		o.init.MaxReq = dot1xAuthDefMaxReq
+	}
+	if o.init.QuietSec == 0 {
+		o.init.QuietSec = dot1xAuthDefQuietSec
+	}
+	o.users = make(map[string]*Dot1xAuthUser)
+	for i := range o.init.Users {
+		o.users[o.init.Users[i].User] = &o.init.Users[i]
+	}
+	o.ports = make(map[core.MACKey]*Dot1xAuthPort)
+	o.head.SetSelf()
+	o.l2 = nsPlug.Ns.GetL2Header(false, uint16(layers.EthernetTypeEAPOL))
+	copy(o.l2[6:12], o.init.Mac[:])
+	return o
+}
+
+func (o *Dot1xAuth) validate() error {
+	for _, m := range o.init.Methods {
+		if !o.isMethodSupported(m) {
+			return fmt.Errorf("method %v is not supported by the authenticator", m)
+		}
+	}
+	return nil
+}
+
+func (o *Dot1xAuth) isMethodSupported(m uint8) bool {
+	return (m == EAP_TYPE_MD5) || (m == EAP_TYPE_MSCHAPV2)
+}
+
+func (o *Dot1xAuth) isMethodAllowed(m uint8) bool {
+	for _, v := range o.init.Methods {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Dot1xAuth) OnRemove() {
+	for _, port := range o.ports {
+		if port.timer.IsRunning() {
+			o.timerw.Stop(&port.timer)
+		}
+	}
+}
+
+func (o *Dot1xAuth) getPort(mac core.MACKey) *Dot1xAuthPort {
+	port, ok := o.ports[mac]
+	if ok {
+		return port
+	}
+	port = new(Dot1xAuthPort)
+	port.mac = mac
+	port.timer.SetCB(&o.timerCb, o, port)
+	o.ports[mac] = port
+	o.head.AddLast(&port.dlist)
+	o.stats.portActive++
+	return port
+}
+
+func (o *Dot1xAuth) startTimer(port *Dot1xAuthPort, sec uint32) {
+	if port.timer.IsRunning() {
+		o.timerw.Stop(&port.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(sec) * time.Second)
+	port.expireTck = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&port.timer, ticks)
+}
+
+func (o *Dot1xAuth) stopTimer(port *Dot1xAuthPort) {
+	if port.timer.IsRunning() {
+		o.timerw.Stop(&port.timer)
+	}
+	port.expireTck = 0
+}
+
+func (o *Dot1xAuth) setAuthorized(port *Dot1xAuthPort, authorized bool) {
+	if port.authorized == authorized {
+		return
+	}
+	port.authorized = authorized
+	if authorized {
+		o.stats.portAuthorized++
+	} else {
+		o.stats.portAuthorized--
+	}
+}
+
+// send an EAP packet to the supplicant, eaptype is used only by requests
+func (o *Dot1xAuth) send(port *Dot1xAuthPort, code uint8, eaptype uint8, d []byte) {
+	eapLen := 4
+	if code == uint8(layers.EAPCodeRequest) {
+		eapLen += 1 + len(d)
+	}
+	pkt := make([]byte, 0, len(o.l2)+4+eapLen)
+	pkt = append(pkt, o.l2...)
+	copy(pkt[0:6], port.mac[:])
+	l3 := len(pkt)
+	pkt = append(pkt, dot1xAuthEapolVer, byte(layers.EAPOLTypeEAP), 0, 0, code, port.id, 0, 0)
+	binary.BigEndian.PutUint16(pkt[l3+2:l3+4], uint16(eapLen))
+	binary.BigEndian.PutUint16(pkt[l3+6:l3+8], uint16(eapLen))
+	if code == uint8(layers.EAPCodeRequest) {
+		pkt = append(pkt, eaptype)
+		pkt = append(pkt, d...)
+	}
+	m := o.nsPlug.Ns.AllocMbuf(uint16(len(pkt)))
+	m.Append(pkt)
+	o.nsPlug.Tctx.Veth.Send(m)
+}
+
+func (o *Dot1xAuth) sendRequest(port *Dot1xAuthPort, eaptype uint8, d []byte) {
+	port.id++
+	port.reqType = eaptype
+	port.reqData = d
+	port.reqCnt = 0
+	o.stats.pktTxRequest++
+	o.send(port, uint8(layers.EAPCodeRequest), eaptype, d)
+	o.startTimer(port, o.init.TimeoutSec)
+}
+
+// startAuth send identity request, the port stays authorized in case of reauthentication
+func (o *Dot1xAuth) startAuth(port *Dot1xAuthPort) {
+	if port.state == DOT1X_AUTH_AUTHENTICATED {
+		o.stats.reauth++
+	}
+	port.state = DOT1X_AUTH_CONNECTING
+	port.user = nil
+	port.identity = ""
+	port.method = 0
+	port.authResponse = ""
+	o.sendRequest(port, uint8(layers.EAPTypeIdentity), []byte{})
+}
+
+func (o *Dot1xAuth) success(port *Dot1xAuthPort) {
+	o.stats.pktTxSuccess++
+	o.stats.authOk++
+	port.authCnt++
+	o.send(port, uint8(layers.EAPCodeSuccess), 0, nil)
+	port.state = DOT1X_AUTH_AUTHENTICATED
+	o.setAuthorized(port, true)
+	if o.init.ReauthSec > 0 {
+		o.startTimer(port, o.init.ReauthSec)
+	} else {
+		o.stopTimer(port)
+	}
+}
+
+func (o *Dot1xAuth) failure(port *Dot1xAuthPort) {
+	o.stats.pktTxFailure++
+	o.stats.authFail++
+	port.failCnt++
+	o.send(port, uint8(layers.EAPCodeFailure), 0, nil)
+	port.state = DOT1X_AUTH_HELD
+	o.setAuthorized(port, false)
+	o.startTimer(port, o.init.QuietSec)
+}
+
+func (o *Dot1xAuth) onPortTimer(port *Dot1xAuthPort) {
+	port.expireTck = 0
+	switch port.state {
+	case DOT1X_AUTH_CONNECTING, DOT1X_AUTH_AUTHENTICATING:
+		if port.reqCnt < o.init.MaxReq {
+			port.reqCnt++
+			o.stats.pktTxRetransmit++
+			o.send(port, uint8(layers.EAPCodeRequest), port.reqType, port.reqData)
+			o.startTimer(port, o.init.TimeoutSec)
+			return
+		}
+		o.stats.authTimeout++
+		o.failure(port)
+	case DOT1X_AUTH_HELD:
+		port.state = DOT1X_AUTH_DISCONNECTED
+	case DOT1X_AUTH_AUTHENTICATED:
+		o.startAuth(port)
+	}
+}
+
+func (o *Dot1xAuth) genChallenge(port *Dot1xAuthPort) {
+	if o.nsPlug.Tctx.Simulation {
+		port.challenge = []byte{0xa1, 0x50, 0x1e, 0x8b, 0xb2, 0x70, 0x1d, 0x3d, 0x9b, 0x53, 0x55, 0x94, 0x99, 0x3f, 0x67, 0xa7}
+	} else {
+		genChalange16B(&port.challenge)
+	}
+}
+
+// sendMethod send the challenge of the method
+func (o *Dot1xAuth) sendMethod(port *Dot1xAuthPort, method uint8) {
+	port.method = method
+	port.state = DOT1X_AUTH_AUTHENTICATING
+	o.genChallenge(port)
+	var d []byte
+	switch method {
+	case EAP_TYPE_MD5:
+		d = append(d, uint8(len(port.challenge)))
+		d = append(d, port.challenge...)
+		d = append(d, []byte(dot1xAuthServerName)...)
+	case EAP_TYPE_MSCHAPV2:
+		// OpCode, MS-CHAPv2-ID (the id of the request), MS-Length, Value-Size, Challenge, Name
+		d = append(d, MS_CHAPV2_CHALLENGE, port.id+1, 0, 0, uint8(len(port.challenge)))
+		d = append(d, port.challenge...)
+		d = append(d, []byte(dot1xAuthServerName)...)
+		binary.BigEndian.PutUint16(d[2:4], uint16(len(d)))
+	}
+	o.sendRequest(port, method, d)
+}
+
+func (o *Dot1xAuth) handleIdentity(port *Dot1xAuthPort, eap *layers.EAP) {
+	if port.state != DOT1X_AUTH_CONNECTING {
+		o.stats.pktRxUnexpected++
+		return
+	}
+	port.identity = string(eap.TypeData)
+	user, ok := o.users[port.identity]
+	if !ok {
+		o.stats.authUnknownUser++
+		o.failure(port)
+		return
+	}
+	port.user = user
+	o.sendMethod(port, o.init.Methods[0])
+}
+
+func (o *Dot1xAuth) handleNak(port *Dot1xAuthPort, eap *layers.EAP) {
+	o.stats.pktRxNak++
+	if port.state != DOT1X_AUTH_AUTHENTICATING {
+		o.stats.pktRxUnexpected++
+		return
+	}
+	for _, t := range eap.TypeData {
+		if t != port.method && o.isMethodAllowed(t) {
+			o.sendMethod(port, t)
+			return
+		}
+	}
+	o.failure(port)
+}
+
+func (o *Dot1xAuth) handleMd5(port *Dot1xAuthPort, eap *layers.EAP) {
+	b := eap.TypeData
+	if len(b) < 17 || b[0] != 16 {
+		o.stats.pktRxParserErr++
+		o.failure(port)
+		return
+	}
+	h := []byte{port.id}
+	h = append(h, []byte(port.user.Password)...)
+	h = append(h, port.challenge...)
+	r := md5.Sum(h)
+	if string(b[1:17]) != string(r[:]) {
+		o.failure(port)
+		return
+	}
+	o.success(port)
+}
+
+func (o *Dot1xAuth) handleMschapv2(port *Dot1xAuthPort, eap *layers.EAP) {
+	b := eap.TypeData
+	if len(b) < 1 {
+		o.stats.pktRxParserErr++
+		o.failure(port)
+		return
+	}
+	switch b[0] {
+	case MS_CHAPV2_RESPONSE:
+		// OpCode, MS-CHAPv2-ID, MS-Length, Value-Size, Peer-Challenge, Reserved, NT-Response, Flags, Name
+		if port.authResponse != "" || len(b) < 54 || b[4] != 49 {
+			o.stats.pktRxParserErr++
+			o.failure(port)
+			return
+		}
+		res, err := Encryptv2(port.challenge, b[5:21], port.identity, port.user.Password)
+		if err != nil || string(b[29:53]) != string(res.ChallengeResponse) {
+			o.failure(port)
+			return
+		}
+		port.authResponse = res.AuthenticatorResponse
+		d := []byte{MS_CHAPV2_SUCCESS, b[1], 0, 0}
+		d = append(d, []byte(res.AuthenticatorResponse+" M=OK")...)
+		binary.BigEndian.PutUint16(d[2:4], uint16(len(d)))
+		o.sendRequest(port, EAP_TYPE_MSCHAPV2, d)
+	case MS_CHAPV2_SUCCESS:
+		if port.authResponse == "" {
+			o.stats.pktRxUnexpected++
+			return
+		}
+		o.success(port)
+	default:
+		o.failure(port)
+	}
+}
+
+func (o *Dot1xAuth) handleResponse(port *Dot1xAuthPort, eap *layers.EAP) {
+	if port.state == DOT1X_AUTH_HELD {
+		o.stats.pktRxHeld++
+		return
+	}
+	if eap.Id != port.id {
+		o.stats.pktRxWrongId++
+		return
+	}
+	switch uint8(eap.Type) {
+	case uint8(layers.EAPTypeIdentity):
+		o.handleIdentity(port, eap)
+	case uint8(layers.EAPTypeNACK):
+		o.handleNak(port, eap)
+	default:
+		if port.state != DOT1X_AUTH_AUTHENTICATING || uint8(eap.Type) != port.method {
+			o.stats.pktRxUnexpected++
+			return
+		}
+		switch port.method {
+		case EAP_TYPE_MD5:
+			o.handleMd5(port, eap)
+		case EAP_TYPE_MSCHAPV2:
+			o.handleMschapv2(port, eap)
+		}
+	}
+}
+
+// HandleRxPacket a packet of a supplicant, EAPOL-Start/Logoff or EAP response
+func (o *Dot1xAuth) HandleRxPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	l3 := int(ps.L3)
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+
+	switch layers.EAPOLType(p[l3+1]) {
+	case layers.EAPOLTypeStart:
+		o.stats.pktRxStart++
+		port := o.getPort(mac)
+		if port.state == DOT1X_AUTH_HELD {
+			o.stats.pktRxHeld++
+			return 0
+		}
+		o.startAuth(port)
+		return 0
+
+	case layers.EAPOLTypeLogOff:
+		o.stats.pktRxLogoff++
+		port, ok := o.ports[mac]
+		if ok {
+			o.stopTimer(port)
+			port.state = DOT1X_AUTH_DISCONNECTED
+			o.setAuthorized(port, false)
+		}
+		return 0
+	}
+
+	var eap layers.EAP
+	if len(p) < l3+8 {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	if err := eap.DecodeFromBytes(p[l3+4:], gopacket.NilDecodeFeedback); err != nil {
+		o.stats.pktRxParserErr++
+		return core.PARSER_ERR
+	}
+	o.stats.pktRxResponse++
+	port, ok := o.ports[mac]
+	if !ok {
+		o.stats.pktRxNoPort++
+		return 0
+	}
+	o.handleResponse(port, &eap)
+	return 0
+}
+
+// StartPort start the authentication of a supplicant by the authenticator, reauthentication in case it is authenticated
+func (o *Dot1xAuth) StartPort(mac core.MACKey) {
+	port := o.getPort(mac)
+	o.startAuth(port)
+}
+
+func (o *Dot1xAuth) portRec(port *Dot1xAuthPort) Dot1xAuthPortRec {
+	var rec Dot1xAuthPortRec
+	rec.Mac = port.mac
+	rec.State = dot1xAuthStateNames[port.state]
+	rec.Authorized = port.authorized
+	rec.User = port.identity
+	rec.Method = port.method
+	rec.AuthCnt = port.authCnt
+	rec.FailCnt = port.failCnt
+	if port.expireTck > o.timerw.Ticks {
+		remain := time.Duration(port.expireTck-o.timerw.Ticks) * o.timerw.TickDuration
+		rec.RemainSec = uint32(remain / time.Second)
+	}
+	return rec
+}
+
+func (o *Dot1xAuth) IterReset() bool {
+	o.activeIter = o.head.Next()
+	if o.head.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *Dot1xAuth) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *Dot1xAuth) GetNext(n uint16) ([]Dot1xAuthPortRec, error) {
+	r := make([]Dot1xAuthPortRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.head {
+			o.iterReady = false // require a new reset
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.portRec(covertToAuthPort(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiDot1xNsAuthCntHandler struct{}
+
+	ApiDot1xNsAuthIterHandler struct{} // iterate on the supplicants
+	ApiDot1xNsAuthIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiDot1xNsAuthIterResult struct {
+		Empty   bool               `json:"empty"`
+		Stopped bool               `json:"stopped"`
+		Vec     []Dot1xAuthPortRec `json:"data"`
+	}
+
+	ApiDot1xNsAuthStartHandler struct{} // start/reauthenticate a supplicant
+	ApiDot1xNsAuthStartParams  struct {
+		Mac core.MACKey `json:"mac" validate:"required"`
+	}
+)
+
+func getNsAuth(ctx interface{}, params *fastjson.RawMessage) (*PluginDot1xNs, *jsonrpc.Error) {
+	ns, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if ns.auth == nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "authenticator is not enabled in the namespace",
+		}
+	}
+	return ns, nil
+}
+
+func (h ApiDot1xNsAuthCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	ns, err := getNs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return ns.cdbv.GeneralCounters(nil, tctx, params, &p)
+}
+
+func (h ApiDot1xNsAuthIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiDot1xNsAuthIterParams
+	var res ApiDot1xNsAuthIterResult
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	ns, jerr := getNsAuth(ctx, params)
+	if jerr != nil {
+		return nil, jerr
+	}
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = ns.auth.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if ns.auth.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	ports, err := ns.auth.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res.Vec = ports
+	return &res, nil
+}
+
+func (h ApiDot1xNsAuthStartHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiDot1xNsAuthStartParams
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	ns, jerr := getNsAuth(ctx, params)
+	if jerr != nil {
+		return nil, jerr
+	}
+
+	err := tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	ns.auth.StartPort(p.Mac)
+	return nil, nil
+}
@@ -10,6 +10,11 @@ RFC 8415  dot1x client with
 
 EAP-MD5
 EAP-MSCHAPv2
+EAP-TLS
+PEAPv0 (EAP-MSCHAPv2)
+EAP-TTLS (PAP/MSCHAPv2)
+
+and an authenticator with a local EAP server (EAP-MD5/EAP-MSCHAPv2) on the namespace, see authenticator.go
 
 
 */
@@ -47,24 +52,37 @@ const (
 	MAX_STARTS_CNT    = 3
 
 	EAP_TYPE_MD5      = 4
+	EAP_TYPE_TLS      = 13
+	EAP_TYPE_TTLS     = 21
+	EAP_TYPE_PEAP     = 25
 	EAP_TYPE_MSCHAPV2 = 26
+	EAP_TYPE_TLV      = 33
 
 	MAX_EAPOL_VER      = 3
 	EAPSIZE_PKT_HEADER = 5
 
 	EAP_MD5_MASK      = 1
 	EAP_MSCHAPv2_MASK = 2
+	EAP_TLS_MASK      = 4
+	EAP_PEAP_MASK     = 8
+	EAP_TTLS_MASK     = 16
 )
 
 var dot1xDefaultDestMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x03}
 
 type Dot1xCfg struct {
-	User       *string `json:"user"`       // user name
-	Password   *string `json:"password"`   // password
-	Nthash     *string `json:"nthash"`     // hash string for MSCHAPv2
-	Flags      uint32  `json:"flags"`      // not used
-	TimeoutSec uint32  `json:"timeo_idle"` // timeout for success in sec
-	MaxStart   uint32  `json:"max_start"`  // max number of retries
+	User       *string `json:"user"`        // user name
+	Password   *string `json:"password"`    // password
+	Nthash     *string `json:"nthash"`      // hash string for MSCHAPv2
+	Flags      uint32  `json:"flags"`       // mask of methods to disable
+	TimeoutSec uint32  `json:"timeo_idle"`  // timeout for success in sec
+	MaxStart   uint32  `json:"max_start"`   // max number of retries
+	CaCert     *string `json:"ca_cert"`     // PEM CA certificates to verify the server, no verification if not provided
+	Cert       *string `json:"cert"`        // PEM client certificate, required for EAP-TLS
+	Key        *string `json:"key"`         // PEM private key of the client certificate
+	ServerName *string `json:"server_name"` // name to verify in the server certificate
+	TtlsInner  *string `json:"ttls_inner"`  // inner method of EAP-TTLS "pap" (default) or "mschapv2"
+	FragSize   uint16  `json:"frag_size"`   // max TLS data in one EAP packet
 }
 
 type Dot1xStats struct {
@@ -82,6 +100,13 @@ type Dot1xStats struct {
 	pktMethodNoPassword    uint64
 	pktMethodWrongLen      uint64
 	pktMethodFailErr       uint64
+	pktTlsRxFrag           uint64
+	pktTlsTxFrag           uint64
+	pktTlsFragErr          uint64
+	tlsHandshakeOk         uint64
+	tlsHandshakeErr        uint64
+	tlsCfgErr              uint64
+	tlsTunnelErr           uint64
 }
 
 func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
@@ -199,6 +224,62 @@ func NewDot1xStatsDb(o *Dot1xStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScERROR})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsRxFrag,
+		Name:     "pktTlsRxFrag",
+		Help:     "rx tls fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsTxFrag,
+		Name:     "pktTlsTxFrag",
+		Help:     "tx tls fragments",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTlsFragErr,
+		Name:     "pktTlsFragErr",
+		Help:     "rx invalid tls fragment",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsHandshakeOk,
+		Name:     "tlsHandshakeOk",
+		Help:     "tls handshake completed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsHandshakeErr,
+		Name:     "tlsHandshakeErr",
+		Help:     "tls handshake failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsCfgErr,
+		Name:     "tlsCfgErr",
+		Help:     "invalid tls configuration, cert/key/ca",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsTunnelErr,
+		Name:     "tlsTunnelErr",
+		Help:     "inner method of the tunnel failed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -279,7 +360,11 @@ func (o *PluginDot1xClient) LoadCfg(initJson []byte) {
 
 	o.cfg.TimeoutSec = TIMEOUT_TIMER_SEC
 	o.cfg.MaxStart = MAX_STARTS_CNT
+	o.cfg.FragSize = TLS_DEF_FRAG_SIZE
 	fastjson.Unmarshal(initJson, &o.cfg)
+	if (o.cfg.FragSize == 0) || (o.cfg.FragSize > TLS_MAX_FRAG_SIZE) {
+		o.cfg.FragSize = TLS_DEF_FRAG_SIZE
+	}
 }
 
 func (o *PluginDot1xClient) OnCreate() {
@@ -301,10 +386,24 @@ func (o *PluginDot1xClient) OnCreate() {
 	if o.cfg.Flags&EAP_MSCHAPv2_MASK == 0 {
 		o.mapHandler[EAP_TYPE_MSCHAPV2] = NewEapMschapv2()
 	}
+	if o.cfg.Flags&EAP_TLS_MASK == 0 {
+		o.mapHandler[EAP_TYPE_TLS] = NewEapTls()
+	}
+	if o.cfg.Flags&EAP_PEAP_MASK == 0 {
+		o.mapHandler[EAP_TYPE_PEAP] = NewEapPeap()
+	}
+	if o.cfg.Flags&EAP_TTLS_MASK == 0 {
+		o.mapHandler[EAP_TYPE_TTLS] = NewEapTtls()
+	}
 
-	if o.cfg.Flags&EAP_MD5_MASK == 0 {
-		o.nack = []byte{EAP_TYPE_MD5}
-	} else {
+	// the nack offers the enabled methods
+	o.nack = make([]byte, 0)
+	for _, t := range []uint8{EAP_TYPE_MD5, EAP_TYPE_MSCHAPV2, EAP_TYPE_TLS, EAP_TYPE_PEAP, EAP_TYPE_TTLS} {
+		if _, ok := o.mapHandler[t]; ok {
+			o.nack = append(o.nack, t)
+		}
+	}
+	if len(o.nack) == 0 {
 		o.nack = []byte{EAP_TYPE_MSCHAPV2}
 	}
 
@@ -398,12 +497,20 @@ func (o *PluginDot1xClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDot1xClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDot1xClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, dot1xEvents)
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	for _, mhandler := range o.mapHandler {
+		mhandler.OnRemove()
+	}
 }
 
 func (o *PluginDot1xClient) makeSurereTimerIsRunning() {
@@ -515,8 +622,9 @@ func (o *PluginDot1xClient) sendNack(eap *layers.EAP) {
 }
 
 func (o *PluginDot1xClient) handleFailure(eap *layers.EAP) {
-	if o.smState != EAP_WAIT_FOR_RESULTS {
-		o.stats.pktSuccessWrongState++
+	// the server can fail the method after any response
+	if (o.smState != EAP_WAIT_FOR_RESULTS) && (o.smState != EAP_WAIT_FOR_METHOD) {
+		o.stats.pktFaliureWrongState++
 		return
 	}
 
@@ -600,6 +708,11 @@ func (o *PluginDot1xClient) handleRequest(eap *layers.EAP) {
 // PluginDot1xNs information per namespace
 type PluginDot1xNs struct {
 	core.PluginBase
+	init      Dot1xNsInit
+	auth      *Dot1xAuth // nil in case the authenticator is not enabled
+	authStats Dot1xAuthStats
+	cdb       *core.CCounterDb
+	cdbv      *core.CCounterDbVec
 }
 
 func NewDot1xNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
@@ -607,11 +720,52 @@ func NewDot1xNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o := new(PluginDot1xNs)
 	o.InitPluginBase(ctx, o)
 	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewDot1xAuthStatsDb(&o.authStats)
+	o.cdbv = core.NewCCounterDbVec("dot1xauth")
+	o.cdbv.Add(o.cdb)
+
+	if len(initJson) > 0 {
+		err := o.Tctx.UnmarshalValidate(initJson, &o.init)
+		if err != nil {
+			o.authStats.invalidInitJson++
+			return &o.PluginBase
+		}
+	}
+	if o.init.Auth != nil {
+		auth := NewDot1xAuth(o, o.init.Auth, &o.authStats)
+		if auth.validate() != nil {
+			o.authStats.invalidInitJson++
+			return &o.PluginBase
+		}
+		o.auth = auth
+	}
 
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginDot1xNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginDot1xNs) OnRemove(ctx *core.PluginCtx) {
+	if o.auth != nil {
+		o.auth.OnRemove()
+	}
+}
+
+// isSupplicantPkt EAPOL-Start/Logoff or EAP response, goes to the authenticator
+func isSupplicantPkt(p []byte, l3 uint16) bool {
+	if len(p) < int(l3)+4 {
+		return false
+	}
+	switch layers.EAPOLType(p[l3+1]) {
+	case layers.EAPOLTypeStart, layers.EAPOLTypeLogOff:
+		return true
+	case layers.EAPOLTypeEAP:
+		return (len(p) > int(l3)+4) && (p[l3+4] == byte(layers.EAPCodeResponse))
+	}
+	return false
 }
 
 func (o *PluginDot1xNs) OnEvent(msg string, a, b interface{}) {
@@ -628,6 +782,10 @@ func (o *PluginDot1xNs) HandleRxDot1xPacket(ps *core.ParserPacketState) int {
 	p := m.GetData()
 	/* the header is at least 8 bytes*/
 	/* UDP checksum was verified in the parser */
+	if o.auth != nil && isSupplicantPkt(p, ps.L3) {
+		return o.auth.HandleRxPacket(ps)
+	}
+
 	var mackey core.MACKey
 	copy(mackey[:], p[0:6])
 	var client *core.CClient
@@ -782,6 +940,10 @@ func init() {
 	core.RegisterCB("dot1x_client_info", ApiDot1xClientInfoHandler{}, false) // get info per array
 
 	core.RegisterCB("dot1x_client_cnt", ApiDot1xClientCntHandler{}, false) // get counters/meta
+
+	core.RegisterCB("dot1x_ns_auth_cnt", ApiDot1xNsAuthCntHandler{}, false)     // authenticator counters
+	core.RegisterCB("dot1x_ns_auth_iter", ApiDot1xNsAuthIterHandler{}, false)   // iterate the supplicants
+	core.RegisterCB("dot1x_ns_auth_start", ApiDot1xNsAuthStartHandler{}, false) // start/reauthenticate a supplicant
 	// TBD getter for the client info
 
 	/* register callback for rx side*/
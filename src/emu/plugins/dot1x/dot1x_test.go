@@ -8,9 +8,14 @@ package dot1x
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
 	"emu/core"
+	"emu/core/testpki"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"external/google/gopacket"
 	"external/google/gopacket/layers"
 	"flag"
 	"fmt"
@@ -29,6 +34,11 @@ type Dot1xTestBase struct {
 	capture      bool
 	duration     time.Duration
 	clientsToSim int
+	clientJson   string
+	nsJson       string     // the authenticator, packets are looped back
+	srv          *eapSrvSim // EAP server for the TLS methods
+	expState     uint8
+	expPort      string // state of the supplicant in the authenticator
 	cb           IgmpTestCb
 	cbArg1       interface{}
 	cbArg2       interface{}
@@ -45,7 +55,9 @@ func (o *Dot1xTestBase) Run(t *testing.T) {
 	if o.match > 0 {
 		simVeth.match = o.match
 	}
-	tctx, _ := createSimulationEnv(&simrx, o.clientsToSim)
+	simVeth.srv = o.srv
+	simVeth.loopback = o.nsJson != ""
+	tctx, _ := createSimulationEnv(&simrx, o.clientsToSim, o.nsJson, o.clientJson)
 	if o.cb != nil {
 		o.cb(tctx, o)
 	}
@@ -73,13 +85,33 @@ func (o *Dot1xTestBase) Run(t *testing.T) {
 	dot1xPlug := nsplg.Ext.(*PluginDot1xClient)
 	dot1xPlug.cdbv.Dump()
 	tctx.GetCounterDbVec().Dump()
+	if o.srv != nil {
+		tctx.SimRecordAppend(dot1xPlug.cdb.MarshalValues(false))
+	}
+	if o.expState != 0 && dot1xPlug.smState != o.expState {
+		t.Fatalf(" expected state %v, got %v", o.expState, dot1xPlug.smState)
+	}
+	if o.nsJson != "" {
+		nsPlug := ns.PluginCtx.Get(DOT1X_PLUG).Ext.(*PluginDot1xNs)
+		nsPlug.cdbv.Dump()
+		tctx.SimRecordAppend(nsPlug.cdb.MarshalValues(false))
+		if o.expPort != "" {
+			if nsPlug.auth == nil {
+				t.Fatalf(" authenticator is not enabled")
+			}
+			port, ok := nsPlug.auth.ports[c.Mac]
+			if !ok || dot1xAuthStateNames[port.state] != o.expPort {
+				t.Fatalf(" expected port state %v, got %+v", o.expPort, port)
+			}
+		}
+	}
 
 	//tctx.SimRecordAppend(igmpPlug.cdb.MarshalValues(false))
 	tctx.SimRecordCompare(o.testname, t)
 
 }
 
-func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *core.CClient) {
+func createSimulationEnv(simRx *core.VethIFSim, num int, nsJson string, clientJson string) (*core.CThreadCtx, *core.CClient) {
 	tctx := core.NewThreadCtx(0, 4510, true, simRx)
 	var key core.CTunnelKey
 	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
@@ -96,9 +128,16 @@ func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *cor
 
 	var cinitJson [][]byte
 	cinitJson = make([][]byte, 0)
-	cinitJson = append(cinitJson, []byte(`{"user": "hhaim", "password":"432768ec1d"}`))
+	if clientJson == "" {
+		clientJson = `{"user": "hhaim", "password":"432768ec1d"}`
+	}
+	cinitJson = append(cinitJson, []byte(clientJson))
 
-	ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{})
+	if nsJson != "" {
+		ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{[]byte(nsJson)})
+	} else {
+		ns.PluginCtx.CreatePlugins([]string{"dot1x"}, [][]byte{})
+	}
 	client.PluginCtx.CreatePlugins([]string{"dot1x"}, cinitJson)
 	ns.Dump()
 	tctx.RegisterParserCb("dot1x")
@@ -113,10 +152,12 @@ func createSimulationEnv(simRx *core.VethIFSim, num int) (*core.CThreadCtx, *cor
 }
 
 type VethIgmpSim struct {
-	DropAll bool
-	cnt     uint8
-	match   uint8
-	tctx    *core.CThreadCtx
+	DropAll  bool
+	cnt      uint8
+	match    uint8
+	tctx     *core.CThreadCtx
+	srv      *eapSrvSim
+	loopback bool
 }
 
 func genMbuf(tctx *core.CThreadCtx, pkt []byte) *core.Mbuf {
@@ -136,6 +177,16 @@ func (o *VethIgmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 		return nil
 	}
 
+	if o.srv != nil {
+		mr = o.srv.process(o.tctx, m)
+		m.FreeMbuf()
+		return mr
+	}
+
+	if o.loopback {
+		return m
+	}
+
 	if o.match == 0 {
 		switch o.cnt {
 		case 0:
@@ -373,6 +424,424 @@ func TestPlugindot1x_7(t *testing.T) {
 	a.Run(t)
 }
 
+/* TLS methods, a local stand-in of the authenticator and the EAP server (hostapd/FreeRADIUS) */
+
+const (
+	dot1xTestServerName = "radius.emu"
+	dot1xTestUser       = "hhaim"
+	dot1xTestPassword   = "432768ec1d"
+)
+
+type dot1xTestPki struct {
+	caPem        []byte
+	otherCaPem   []byte
+	clientPem    []byte
+	clientKeyPem []byte
+	server       tls.Certificate
+	pool         *x509.CertPool
+}
+
+func newDot1xTestPki() *dot1xTestPki {
+	o := new(dot1xTestPki)
+	caKey := testpki.Key(1)
+	ca := testpki.Cert(1, "emu ca", caKey, nil, nil, 0)
+	otherCa := testpki.Cert(2, "other ca", testpki.Key(2), nil, nil, 0)
+	srvKey := testpki.Key(3)
+	srv := testpki.Cert(3, dot1xTestServerName, srvKey, ca, caKey, x509.ExtKeyUsageServerAuth)
+	clientKey := testpki.Key(4)
+	client := testpki.Cert(4, dot1xTestUser, clientKey, ca, caKey, x509.ExtKeyUsageClientAuth)
+
+	o.caPem = testpki.CertPem(ca)
+	o.otherCaPem = testpki.CertPem(otherCa)
+	o.clientPem = testpki.CertPem(client)
+	o.clientKeyPem = testpki.KeyPem(clientKey)
+	o.server = tls.Certificate{Certificate: [][]byte{srv.Raw, ca.Raw}, PrivateKey: srvKey}
+	o.pool = x509.NewCertPool()
+	o.pool.AddCert(ca)
+	return o
+}
+
+func (o *dot1xTestPki) clientJson(method string, caPem []byte, cert bool, fragSize int) string {
+	cfg := map[string]interface{}{
+		"user":        dot1xTestUser,
+		"password":    dot1xTestPassword,
+		"ca_cert":     string(caPem),
+		"server_name": dot1xTestServerName,
+		"frag_size":   fragSize,
+	}
+	if cert {
+		cfg["cert"] = string(o.clientPem)
+		cfg["key"] = string(o.clientKeyPem)
+	}
+	if method != "" {
+		cfg["ttls_inner"] = method
+	}
+	b, _ := json.Marshal(cfg)
+	return string(b)
+}
+
+// eapSrvSim answers the EAPOL packets of the client, the TLS is fragmented by fragSize
+type eapSrvSim struct {
+	method   uint8
+	inner    string
+	tlsCfg   *tls.Config
+	fragSize int
+	id       uint8
+	sess     *core.TlsSession
+	rx       []byte
+	tx       []byte
+	txOff    int
+	buf      []byte
+}
+
+func newEapSrvSim(pki *dot1xTestPki, method uint8, inner string) *eapSrvSim {
+	o := new(eapSrvSim)
+	o.method = method
+	o.inner = inner
+	o.fragSize = 500
+	o.buf = make([]byte, TLS_MAX_RECORD_SIZE)
+	o.tlsCfg = &tls.Config{
+		Certificates:           []tls.Certificate{pki.server},
+		ClientCAs:              pki.pool,
+		ClientAuth:             tls.VerifyClientCertIfGiven,
+		MaxVersion:             tls.VersionTLS12,
+		CipherSuites:           []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SessionTicketsDisabled: true,
+	}
+	if method == EAP_TYPE_TLS {
+		o.tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return o
+}
+
+func (o *eapSrvSim) request(tctx *core.CThreadCtx, code uint8, eaptype uint8, d []byte) *core.Mbuf {
+	o.id++
+	return genMbuf(tctx, GenerateOfferPacket(code, o.id, eaptype, d))
+}
+
+func (o *eapSrvSim) process(tctx *core.CThreadCtx, m *core.Mbuf) *core.Mbuf {
+	p := m.GetData()
+	l3 := len(getL2())
+	if len(p) < l3+4 {
+		return nil
+	}
+	if p[l3+1] == byte(layers.EAPOLTypeStart) {
+		return o.request(tctx, uint8(layers.EAPCodeRequest), uint8(layers.EAPTypeIdentity), []byte{})
+	}
+	var eap layers.EAP
+	if eap.DecodeFromBytes(p[l3+4:], gopacket.NilDecodeFeedback) != nil || eap.Code != layers.EAPCodeResponse {
+		return nil
+	}
+	switch uint8(eap.Type) {
+	case uint8(layers.EAPTypeIdentity):
+		if o.sess != nil {
+			o.sess.Close()
+		}
+		o.rx, o.tx, o.txOff = nil, nil, 0
+		o.sess = core.NewTlsSession(o.tlsCfg, true, eapTlsAddr{}, eapTlsAddr{})
+		o.sess.Start(o.run)
+		return o.request(tctx, uint8(layers.EAPCodeRequest), o.method, []byte{EAP_TLS_FLAGS_START})
+	case o.method:
+		return o.handleTls(tctx, eap.TypeData)
+	}
+	return o.request(tctx, uint8(layers.EAPCodeFailure), 0, []byte{})
+}
+
+func (o *eapSrvSim) handleTls(tctx *core.CThreadCtx, b []byte) *core.Mbuf {
+	flags := b[0]
+	b = b[1:]
+	if len(b) == 0 && o.txOff < len(o.tx) {
+		return o.next(tctx)
+	}
+	if flags&EAP_TLS_FLAGS_LEN != 0 {
+		b = b[4:]
+	}
+	o.rx = append(o.rx, b...)
+	if flags&EAP_TLS_FLAGS_MORE != 0 {
+		return o.request(tctx, uint8(layers.EAPCodeRequest), o.method, []byte{0})
+	}
+	o.sess.Step(o.rx)
+	o.tx = o.sess.TakeOut()
+	o.rx = nil
+	o.txOff = 0
+	return o.next(tctx)
+}
+
+func (o *eapSrvSim) next(tctx *core.CThreadCtx) *core.Mbuf {
+	if o.txOff < len(o.tx) {
+		end := o.txOff + o.fragSize
+		more := end < len(o.tx)
+		if !more {
+			end = len(o.tx)
+		}
+		r := []byte{0}
+		if o.txOff == 0 && more {
+			r[0] = EAP_TLS_FLAGS_LEN | EAP_TLS_FLAGS_MORE
+			r = append(r, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(r[1:5], uint32(len(o.tx)))
+		} else if more {
+			r[0] = EAP_TLS_FLAGS_MORE
+		}
+		r = append(r, o.tx[o.txOff:end]...)
+		o.txOff = end
+		return o.request(tctx, uint8(layers.EAPCodeRequest), o.method, r)
+	}
+	if !o.sess.Running() {
+		if o.sess.Err() == nil {
+			return o.request(tctx, uint8(layers.EAPCodeSuccess), 0, []byte{})
+		}
+		return o.request(tctx, uint8(layers.EAPCodeFailure), 0, []byte{})
+	}
+	return o.request(tctx, uint8(layers.EAPCodeRequest), o.method, []byte{0})
+}
+
+func (o *eapSrvSim) read(c *tls.Conn) ([]byte, error) {
+	n, err := c.Read(o.buf)
+	return o.buf[:n], err
+}
+
+// run the server side of the session in its goroutine
+func (o *eapSrvSim) run(c *tls.Conn) error {
+	if err := c.Handshake(); err != nil {
+		return err
+	}
+	switch o.method {
+	case EAP_TYPE_PEAP:
+		return o.runPeap(c)
+	case EAP_TYPE_TTLS:
+		return o.runTtls(c)
+	}
+	return nil
+}
+
+func (o *eapSrvSim) runPeap(c *tls.Conn) error {
+	c.Write([]byte{uint8(layers.EAPTypeIdentity)})
+	p, err := o.read(c)
+	if err != nil {
+		return err
+	}
+	if string(p) != "\x01"+dot1xTestUser {
+		return fmt.Errorf("unexpected identity %v", p)
+	}
+
+	challenge := []byte{1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8}
+	r := []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_CHALLENGE, 7, 0, 0, 16}
+	r = append(r, challenge...)
+	r = append(r, []byte("srv")...)
+	binary.BigEndian.PutUint16(r[3:5], uint16(len(r)-1))
+	c.Write(r)
+	p, err = o.read(c)
+	if err != nil {
+		return err
+	}
+	if len(p) < 54 || p[0] != EAP_TYPE_MSCHAPV2 || p[1] != MS_CHAPV2_RESPONSE {
+		return fmt.Errorf("unexpected mschapv2 response %v", p)
+	}
+	res, _ := Encryptv2(challenge, p[6:22], dot1xTestUser, dot1xTestPassword)
+	if !bytes.Equal(p[30:54], res.ChallengeResponse) {
+		return fmt.Errorf("wrong mschapv2 response")
+	}
+
+	r = []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_SUCCESS, 7, 0, 0}
+	r = append(r, []byte(res.AuthenticatorResponse+" M=OK")...)
+	binary.BigEndian.PutUint16(r[3:5], uint16(len(r)-1))
+	c.Write(r)
+	p, err = o.read(c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p, []byte{EAP_TYPE_MSCHAPV2, MS_CHAPV2_SUCCESS}) {
+		return fmt.Errorf("unexpected mschapv2 success %v", p)
+	}
+
+	c.Write([]byte{1, 8, 0, 11, EAP_TYPE_TLV, 0x80, PEAP_TLV_RESULT, 0, 2, 0, PEAP_TLV_RESULT_SUCCESS})
+	p, err = o.read(c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p, []byte{2, 8, 0, 11, EAP_TYPE_TLV, 0x80, PEAP_TLV_RESULT, 0, 2, 0, PEAP_TLV_RESULT_SUCCESS}) {
+		return fmt.Errorf("unexpected tlv result %v", p)
+	}
+	return nil
+}
+
+func (o *eapSrvSim) runTtls(c *tls.Conn) error {
+	p, err := o.read(c)
+	if err != nil {
+		return err
+	}
+	avps, err := TtlsParseAvps(p)
+	if err != nil {
+		return err
+	}
+	m := make(map[uint32][]byte)
+	for _, avp := range avps {
+		m[avp.Code] = avp.Data
+	}
+	if string(m[TTLS_AVP_USER_NAME]) != dot1xTestUser {
+		return fmt.Errorf("unexpected user %v", m[TTLS_AVP_USER_NAME])
+	}
+	if o.inner == "pap" {
+		if string(bytes.TrimRight(m[TTLS_AVP_USER_PASSWORD], "\x00")) != dot1xTestPassword {
+			return fmt.Errorf("wrong password")
+		}
+		return nil
+	}
+
+	cs := c.ConnectionState()
+	km, err := cs.ExportKeyingMaterial(TTLS_CHALLENGE_LABEL, nil, 17)
+	if err != nil {
+		return err
+	}
+	resp := m[TTLS_AVP_MS_CHAP2_RESPONSE]
+	if !bytes.Equal(m[TTLS_AVP_MS_CHAP_CHALLENGE], km[:16]) || len(resp) != 50 || resp[0] != km[16] {
+		return fmt.Errorf("unexpected mschapv2 avps")
+	}
+	res, _ := Encryptv2(km[:16], resp[2:18], dot1xTestUser, dot1xTestPassword)
+	if !bytes.Equal(resp[26:50], res.ChallengeResponse) {
+		return fmt.Errorf("wrong mschapv2 response")
+	}
+	c.Write(TtlsAppendAvp(nil, TTLS_AVP_MS_CHAP2_SUCCESS, TTLS_VENDOR_MICROSOFT, append([]byte{km[16]}, []byte(res.AuthenticatorResponse)...)))
+	return nil
+}
+
+func dot1xTlsTest(t *testing.T, testname string, method uint8, inner string, clientJson string, expState uint8) {
+	a := &Dot1xTestBase{
+		testname:     testname,
+		dropAll:      false,
+		monitor:      false,
+		capture:      false,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		clientJson:   clientJson,
+		srv:          newEapSrvSim(newDot1xTestPki(), method, inner),
+		expState:     expState,
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xTls(t *testing.T) {
+	pki := newDot1xTestPki()
+	dot1xTlsTest(t, "dot1x_tls", EAP_TYPE_TLS, "", pki.clientJson("", pki.caPem, true, 300), EAP_DONE_OK)
+}
+
+func TestPlugindot1xTlsBadCa(t *testing.T) {
+	pki := newDot1xTestPki()
+	dot1xTlsTest(t, "dot1x_tls_bad_ca", EAP_TYPE_TLS, "", pki.clientJson("", pki.otherCaPem, true, 300), EAP_DONE_FAIL)
+}
+
+func TestPlugindot1xPeap(t *testing.T) {
+	pki := newDot1xTestPki()
+	dot1xTlsTest(t, "dot1x_peap", EAP_TYPE_PEAP, "", pki.clientJson("", pki.caPem, false, 1398), EAP_DONE_OK)
+}
+
+func TestPlugindot1xTtlsPap(t *testing.T) {
+	pki := newDot1xTestPki()
+	dot1xTlsTest(t, "dot1x_ttls_pap", EAP_TYPE_TTLS, "pap", pki.clientJson("pap", pki.caPem, false, 1398), EAP_DONE_OK)
+}
+
+func TestPlugindot1xTtlsMschapv2(t *testing.T) {
+	pki := newDot1xTestPki()
+	dot1xTlsTest(t, "dot1x_ttls_mschapv2", EAP_TYPE_TTLS, "mschapv2", pki.clientJson("mschapv2", pki.caPem, false, 1398), EAP_DONE_OK)
+}
+
+/* authenticator of the namespace and the supplicant of the client */
+
+const dot1xAuthTestNsJson = `{"auth": {"mac": [0, 0, 1, 0, 0, 2], "users": [{"user": "hhaim", "password": "432768ec1d"}, {"user": "other", "password": "x"}] %s}}`
+
+func TestPlugindot1xAuthMd5(t *testing.T) {
+	a := &Dot1xTestBase{
+		testname:     "dot1x_auth_md5",
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		nsJson:       fmt.Sprintf(dot1xAuthTestNsJson, ""),
+		expState:     EAP_DONE_OK,
+		expPort:      "authenticated",
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuthMschapv2(t *testing.T) {
+	// the supplicant naks MD5
+	a := &Dot1xTestBase{
+		testname:     "dot1x_auth_mschapv2",
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		nsJson:       fmt.Sprintf(dot1xAuthTestNsJson, ""),
+		clientJson:   `{"user": "hhaim", "password":"432768ec1d", "flags": 1}`,
+		expState:     EAP_DONE_OK,
+		expPort:      "authenticated",
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuthFail(t *testing.T) {
+	a := &Dot1xTestBase{
+		testname:     "dot1x_auth_fail",
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		nsJson:       fmt.Sprintf(dot1xAuthTestNsJson, `, "methods": [26], "quiet": 30`),
+		clientJson:   `{"user": "hhaim", "password":"wrong"}`,
+		expState:     EAP_DONE_FAIL,
+		expPort:      "held",
+	}
+	a.Run(t)
+}
+
+func TestPlugindot1xAuthReauth(t *testing.T) {
+	// reauthenticated at 10, 20 and 30 sec
+	a := &Dot1xTestBase{
+		testname:     "dot1x_auth_reauth",
+		capture:      false,
+		duration:     35 * time.Second,
+		clientsToSim: 1,
+		nsJson:       fmt.Sprintf(dot1xAuthTestNsJson, `, "reauth": 10`),
+		expState:     EAP_DONE_OK,
+		expPort:      "authenticated",
+	}
+	a.Run(t)
+}
+
+type Dot1xAuthRpcCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+func (o *Dot1xAuthRpcCtx) OnEvent(a, b interface{}) {
+	// a supplicant that does not answer
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+	"method":"dot1x_ns_auth_start",
+	"params": {"tun": {"vport":1,"tci":[1,2]}, "mac":[0, 0, 1, 0, 0, 9] },
+	"id": 3 }`))
+}
+
+func authRpcQueue(tctx *core.CThreadCtx, test *Dot1xTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	ticks := timerw.DurationToTicks(5 * time.Second)
+	var rpcctx Dot1xAuthRpcCtx
+	rpcctx.timer.SetCB(&rpcctx, 0, 0)
+	rpcctx.tctx = tctx
+	timerw.StartTicks(&rpcctx.timer, ticks)
+	return 0
+}
+
+func TestPlugindot1xAuthTimeout(t *testing.T) {
+	a := &Dot1xTestBase{
+		testname:     "dot1x_auth_timeout",
+		capture:      true,
+		duration:     20 * time.Second,
+		clientsToSim: 1,
+		nsJson:       fmt.Sprintf(dot1xAuthTestNsJson, `, "timeo": 3`),
+		expState:     EAP_DONE_OK,
+		expPort:      "authenticated",
+		cb:           authRpcQueue,
+	}
+	a.Run(t)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
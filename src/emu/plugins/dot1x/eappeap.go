@@ -0,0 +1,142 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+PEAPv0 with inner EAP-MSCHAPv2
+
+The inner EAP packets are without the EAP header (only type and data) except of EAP-TLV, some servers send the
+identity request with the header. The response is built in the same way as the request.
+The method ends with the EAP-TLV result, the client echos the result of the server.
+*/
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+)
+
+const (
+	PEAP_TLV_RESULT         = 3
+	PEAP_TLV_MANDATORY      = 0x8000
+	PEAP_TLV_RESULT_SUCCESS = 1
+	PEAP_TLV_RESULT_FAILURE = 2
+)
+
+type EapPeapTunnel struct {
+	mschapv2 Dot1xMethodIF
+	buf      []byte
+}
+
+func (o *EapPeapTunnel) run(h *EapTlsHandler, c *tls.Conn) error {
+	for {
+		n, err := c.Read(o.buf)
+		if err != nil {
+			return err
+		}
+		r, err := o.handleInner(h, o.buf[:n])
+		if len(r) > 0 {
+			c.Write(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (o *EapPeapTunnel) handleInner(h *EapTlsHandler, p []byte) ([]byte, error) {
+	var eap layers.EAP
+	header := (len(p) >= 5) && (p[0] == byte(layers.EAPCodeRequest)) && (int(binary.BigEndian.Uint16(p[2:4])) == len(p))
+	if header {
+		if err := eap.DecodeFromBytes(p, gopacket.NilDecodeFeedback); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(p) < 1 {
+			return nil, fmt.Errorf("empty inner packet")
+		}
+		eap.Code = layers.EAPCodeRequest
+		eap.Length = uint16(len(p) + 4)
+		eap.Type = layers.EAPType(p[0])
+		eap.TypeData = p[1:]
+	}
+
+	plug := h.plug
+	var r []byte
+	var err error
+	switch uint8(eap.Type) {
+	case uint8(layers.EAPTypeIdentity):
+		if plug.cfg.User == nil {
+			plug.stats.pktNoUserNameErr++
+			return nil, fmt.Errorf("no user name")
+		}
+		r = append([]byte{uint8(layers.EAPTypeIdentity)}, []byte(*plug.cfg.User)...)
+
+	case EAP_TYPE_MSCHAPV2:
+		var obj Dot1xMethodData
+		obj.eap = &eap
+		obj.plug = plug
+		ok, _, res := o.mschapv2.BuildResp(&obj)
+		if !ok {
+			return nil, fmt.Errorf("inner eap-mschapv2 failed")
+		}
+		r = append([]byte{EAP_TYPE_MSCHAPV2}, res...)
+
+	case EAP_TYPE_TLV:
+		// always with a header
+		b := eap.TypeData
+		result := uint16(PEAP_TLV_RESULT_FAILURE)
+		for len(b) >= 4 {
+			t := binary.BigEndian.Uint16(b[0:2]) &^ PEAP_TLV_MANDATORY
+			l := int(binary.BigEndian.Uint16(b[2:4]))
+			if len(b) < 4+l {
+				break
+			}
+			if t == PEAP_TLV_RESULT && l == 2 {
+				result = binary.BigEndian.Uint16(b[4:6])
+			}
+			b = b[4+l:]
+		}
+		r = make([]byte, 11)
+		r[0] = byte(layers.EAPCodeResponse)
+		r[1] = eap.Id
+		binary.BigEndian.PutUint16(r[2:4], uint16(len(r)))
+		r[4] = EAP_TYPE_TLV
+		binary.BigEndian.PutUint16(r[5:7], PEAP_TLV_MANDATORY|PEAP_TLV_RESULT)
+		binary.BigEndian.PutUint16(r[7:9], 2)
+		binary.BigEndian.PutUint16(r[9:11], result)
+		h.done = true
+		if result != PEAP_TLV_RESULT_SUCCESS {
+			err = fmt.Errorf("peap result failure")
+		}
+		return r, err
+
+	default:
+		r = []byte{uint8(layers.EAPTypeNACK), EAP_TYPE_MSCHAPV2}
+	}
+
+	if header {
+		hdr := make([]byte, 4, 4+len(r))
+		hdr[0] = byte(layers.EAPCodeResponse)
+		hdr[1] = eap.Id
+		binary.BigEndian.PutUint16(hdr[2:4], uint16(4+len(r)))
+		r = append(hdr, r...)
+	}
+	return r, nil
+}
+
+func NewEapPeap() Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.method = EAP_TYPE_PEAP
+	p.name = "peap"
+	t := new(EapPeapTunnel)
+	t.mschapv2 = NewEapMschapv2()
+	t.buf = make([]byte, TLS_MAX_RECORD_SIZE)
+	p.tunnel = t
+	return p
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+EapPeer runs the EAP-MD5/EAP-MSCHAPv2 methods of the supplicant without EAPOL, for EAP that is carried by another
+protocol e.g. the EAP-Message attribute of RADIUS. It works on full EAP packets (with the EAP header).
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
+	"fmt"
+)
+
+// EapPeer the peer side of one EAP conversation
+type EapPeer struct {
+	plug       PluginDot1xClient // configuration and counters of the method handlers
+	mapHandler MethodToHandler
+	nack       []byte
+}
+
+// NewEapPeer create a peer with one method, EAP_TYPE_MD5 or EAP_TYPE_MSCHAPV2
+func NewEapPeer(tctx *core.CThreadCtx, user string, password string, method uint8) (*EapPeer, error) {
+	o := new(EapPeer)
+	o.plug.Tctx = tctx
+	o.plug.cfg.User = &user
+	o.plug.cfg.Password = &password
+	o.mapHandler = make(MethodToHandler)
+	switch method {
+	case EAP_TYPE_MD5:
+		o.mapHandler[method] = NewEapMd5()
+	case EAP_TYPE_MSCHAPV2:
+		o.mapHandler[method] = NewEapMschapv2()
+	default:
+		return nil, fmt.Errorf("unsupported eap method %v", method)
+	}
+	o.nack = []byte{method}
+	return o, nil
+}
+
+func (o *EapPeer) build(id uint8, eaptype uint8, d []byte) []byte {
+	r := make([]byte, EAPSIZE_PKT_HEADER, EAPSIZE_PKT_HEADER+len(d))
+	r[0] = byte(layers.EAPCodeResponse)
+	r[1] = id
+	binary.BigEndian.PutUint16(r[2:4], uint16(EAPSIZE_PKT_HEADER+len(d)))
+	r[4] = eaptype
+	return append(r, d...)
+}
+
+// Identity build the EAP-Response/Identity that starts the conversation
+func (o *EapPeer) Identity(id uint8) []byte {
+	return o.build(id, uint8(layers.EAPTypeIdentity), []byte(*o.plug.cfg.User))
+}
+
+// Response build the response to an EAP request, nil in case there is no valid response
+func (o *EapPeer) Response(p []byte) []byte {
+	var eap layers.EAP
+	if err := eap.DecodeFromBytes(p, gopacket.NilDecodeFeedback); err != nil {
+		o.plug.stats.pktRxParserErr++
+		return nil
+	}
+	if eap.Code != layers.EAPCodeRequest {
+		o.plug.stats.pktRxParserInvalidCode++
+		return nil
+	}
+	if eap.Type == layers.EAPTypeIdentity {
+		return o.Identity(eap.Id)
+	}
+	t := uint8(eap.Type)
+	mhandler, ok := o.mapHandler[t]
+	if !ok {
+		o.plug.stats.pktTxNack++
+		return o.build(eap.Id, uint8(layers.EAPTypeNACK), o.nack)
+	}
+	var obj Dot1xMethodData
+	obj.eap = &eap
+	obj.plug = &o.plug
+	ok, _, res := mhandler.BuildResp(&obj)
+	if !ok {
+		return nil
+	}
+	return o.build(eap.Id, t, res)
+}
+
+// OnRemove release the method handlers
+func (o *EapPeer) OnRemove() {
+	for _, h := range o.mapHandler {
+		h.OnRemove()
+	}
+}
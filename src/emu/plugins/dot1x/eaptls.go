@@ -0,0 +1,271 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+EAP-TLS RFC 5216, the TLS part of PEAP and EAP-TTLS RFC 5281
+
+The TLS is a core.TlsSession that is stepped by the method: the method pushes the reassembled TLS data of a
+request and sends the output of the session in the response.
+
+The TLS data of a response is fragmented by frag_size, the next fragment is sent on the ack of the server.
+The max version is TLS 1.2, TLS 1.3 changes the way the method ends (RFC 9190)
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"emu/core"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	EAP_TLS_FLAGS_LEN   = 0x80
+	EAP_TLS_FLAGS_MORE  = 0x40
+	EAP_TLS_FLAGS_START = 0x20
+	EAP_TLS_VER_MASK    = 0x07
+
+	TLS_DEF_FRAG_SIZE = 1398
+	TLS_MAX_FRAG_SIZE = 1400
+	TLS_MAX_MSG_SIZE  = 65536
+
+	TLS_MAX_RECORD_SIZE = 16384
+)
+
+type eapTlsAddr struct{}
+
+func (o eapTlsAddr) Network() string { return "eap" }
+func (o eapTlsAddr) String() string  { return "eap" }
+
+// eapTunnelIF the inner part of PEAP/EAP-TTLS, runs in the session goroutine after the handshake
+type eapTunnelIF interface {
+	run(h *EapTlsHandler, c *tls.Conn) error
+}
+
+// EapTlsHandler EAP-TLS and the TLS part of PEAP/EAP-TTLS, fragmentation and reassembly
+type EapTlsHandler struct {
+	method        uint8
+	name          string
+	cert          bool // client certificate is required
+	tunnel        eapTunnelIF
+	plug          *PluginDot1xClient
+	sess          *core.TlsSession
+	ver           uint8 // version of PEAP/EAP-TTLS
+	rx            []byte
+	rxLen         uint32
+	tx            []byte
+	txOff         int
+	handshakeDone bool
+	done          bool // the method ended, wait for the result
+}
+
+func (o *EapTlsHandler) GetName() string {
+	return o.name
+}
+
+func (o *EapTlsHandler) reset() {
+	if o.sess != nil {
+		o.sess.Close()
+		o.sess = nil
+	}
+	o.rx = nil
+	o.rxLen = 0
+	o.tx = nil
+	o.txOff = 0
+	o.handshakeDone = false
+	o.done = false
+}
+
+func (o *EapTlsHandler) ack() []byte {
+	return []byte{o.ver}
+}
+
+// sendFragment build the next fragment of the pending TLS data, ack in case there is nothing to send
+func (o *EapTlsHandler) sendFragment(d *Dot1xMethodData) (bool, bool, []byte) {
+	if o.txOff >= len(o.tx) {
+		return true, o.done, o.ack()
+	}
+	fragSize := int(d.plug.cfg.FragSize)
+	end := o.txOff + fragSize
+	more := end < len(o.tx)
+	if !more {
+		end = len(o.tx)
+	}
+	r := []byte{o.ver}
+	if o.txOff == 0 && more {
+		r[0] |= EAP_TLS_FLAGS_LEN | EAP_TLS_FLAGS_MORE
+		r = append(r, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(r[1:5], uint32(len(o.tx)))
+	} else if more {
+		r[0] |= EAP_TLS_FLAGS_MORE
+	}
+	r = append(r, o.tx[o.txOff:end]...)
+	o.txOff = end
+	if more || o.txOff > fragSize {
+		d.plug.stats.pktTlsTxFrag++
+	}
+	return true, o.done && !more, r
+}
+
+func (o *EapTlsHandler) start(d *Dot1xMethodData) (bool, bool, []byte) {
+	o.reset()
+	cfg, err := d.plug.getTlsConfig(o.cert)
+	if err != nil {
+		d.plug.stats.tlsCfgErr++
+		return false, false, []byte{}
+	}
+	if o.method == EAP_TYPE_PEAP {
+		o.ver = 0 // PEAPv0 only, the server accepts a lower version
+	}
+	o.plug = d.plug
+	o.sess = core.NewTlsSession(cfg, false, eapTlsAddr{}, eapTlsAddr{})
+	o.sess.Start(func(c *tls.Conn) error {
+		if err := c.Handshake(); err != nil {
+			return err
+		}
+		o.handshakeDone = true
+		o.plug.stats.tlsHandshakeOk++
+		if o.tunnel == nil {
+			o.done = true
+			return nil
+		}
+		return o.tunnel.run(o, c)
+	})
+	o.tx = o.sess.TakeOut()
+	return o.sendFragment(d)
+}
+
+func (o *EapTlsHandler) BuildResp(d *Dot1xMethodData) (bool, bool, []byte) {
+	b := d.eap.TypeData
+	if len(b) < 1 {
+		d.plug.stats.pktMethodWrongLen++
+		return false, false, []byte{}
+	}
+	flags := b[0]
+	b = b[1:]
+	if flags&EAP_TLS_FLAGS_START != 0 {
+		return o.start(d)
+	}
+	if o.sess == nil {
+		d.plug.stats.pktMethodWrongstate++
+		return false, false, []byte{}
+	}
+	if len(b) == 0 && o.txOff < len(o.tx) {
+		// ack of the server to our fragment
+		return o.sendFragment(d)
+	}
+	if flags&EAP_TLS_FLAGS_LEN != 0 {
+		if len(b) < 4 {
+			d.plug.stats.pktMethodWrongLen++
+			return false, false, []byte{}
+		}
+		if len(o.rx) == 0 {
+			o.rxLen = binary.BigEndian.Uint32(b[0:4])
+		}
+		b = b[4:]
+	}
+	if (len(o.rx) > 0) || (flags&EAP_TLS_FLAGS_MORE != 0) {
+		d.plug.stats.pktTlsRxFrag++
+	}
+	o.rx = append(o.rx, b...)
+	if (o.rxLen > TLS_MAX_MSG_SIZE) || (len(o.rx) > TLS_MAX_MSG_SIZE) {
+		d.plug.stats.pktTlsFragErr++
+		o.rx = nil
+		o.rxLen = 0
+		return false, false, []byte{}
+	}
+	if flags&EAP_TLS_FLAGS_MORE != 0 {
+		return true, false, o.ack()
+	}
+	if (o.rxLen != 0) && (uint32(len(o.rx)) != o.rxLen) {
+		d.plug.stats.pktTlsFragErr++
+	}
+	data := o.rx
+	o.rx = nil
+	o.rxLen = 0
+
+	running := o.sess.Running()
+	o.sess.Step(data)
+	o.tx = o.sess.TakeOut()
+	o.txOff = 0
+	if running && !o.sess.Running() && o.sess.Err() != nil {
+		if o.handshakeDone {
+			d.plug.stats.tlsTunnelErr++
+		} else {
+			d.plug.stats.tlsHandshakeErr++
+		}
+		o.done = true // send the alert and wait for the failure
+	}
+	return o.sendFragment(d)
+}
+
+func (o *EapTlsHandler) Success(d *Dot1xMethodData) bool {
+	return o.done
+}
+
+func (o *EapTlsHandler) OnRemove() {
+	o.reset()
+}
+
+// getTlsConfig build the TLS configuration of the client from the init json
+func (o *PluginDot1xClient) getTlsConfig(cert bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MaxVersion:             tls.VersionTLS12,
+		InsecureSkipVerify:     true, // verified by VerifyPeerCertificate in case there is a CA
+		SessionTicketsDisabled: true,
+	}
+
+	if o.cfg.CaCert != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(*o.cfg.CaCert)) {
+			return nil, fmt.Errorf("invalid ca_cert")
+		}
+		var serverName string
+		if o.cfg.ServerName != nil {
+			serverName = *o.cfg.ServerName
+		}
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("server without certificate")
+			}
+			opts := x509.VerifyOptions{Roots: pool, DNSName: serverName, Intermediates: x509.NewCertPool()}
+			var leaf *x509.Certificate
+			for i, raw := range rawCerts {
+				c, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				if i == 0 {
+					leaf = c
+				} else {
+					opts.Intermediates.AddCert(c)
+				}
+			}
+			_, err := leaf.Verify(opts)
+			return err
+		}
+	}
+
+	if (o.cfg.Cert != nil) && (o.cfg.Key != nil) {
+		c, err := tls.X509KeyPair([]byte(*o.cfg.Cert), []byte(*o.cfg.Key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{c}
+	} else if cert {
+		return nil, fmt.Errorf("EAP-TLS requires cert and key")
+	}
+	return cfg, nil
+}
+
+func NewEapTls() Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.method = EAP_TYPE_TLS
+	p.name = "eap-tls"
+	p.cert = true
+	return p
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dot1x
+
+/*
+EAP-TTLSv0 RFC 5281 with inner PAP or MSCHAPv2 (AVPs, not EAP)
+
+PAP sends User-Name and User-Password and ends.
+MSCHAPv2 takes the challenge from the TLS keying material ("ttls challenge"), sends User-Name, MS-CHAP-Challenge
+and MS-CHAP2-Response and verifies the MS-CHAP2-Success of the server.
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	TTLS_AVP_USER_NAME     = 1
+	TTLS_AVP_USER_PASSWORD = 2
+
+	TTLS_VENDOR_MICROSOFT      = 311
+	TTLS_AVP_MS_CHAP_CHALLENGE = 11
+	TTLS_AVP_MS_CHAP2_RESPONSE = 25
+	TTLS_AVP_MS_CHAP2_SUCCESS  = 26
+
+	TTLS_AVP_FLAG_VENDOR    = 0x80
+	TTLS_AVP_FLAG_MANDATORY = 0x40
+
+	TTLS_CHALLENGE_LABEL = "ttls challenge"
+)
+
+// TtlsAvp one AVP of the tunnel
+type TtlsAvp struct {
+	Code   uint32
+	Vendor uint32
+	Data   []byte
+}
+
+// TtlsAppendAvp append a mandatory AVP padded to 4 bytes, vendor is zero for IETF AVPs
+func TtlsAppendAvp(b []byte, code uint32, vendor uint32, data []byte) []byte {
+	l := 8 + len(data)
+	flags := uint8(TTLS_AVP_FLAG_MANDATORY)
+	if vendor != 0 {
+		flags |= TTLS_AVP_FLAG_VENDOR
+		l += 4
+	}
+	var h [12]byte
+	binary.BigEndian.PutUint32(h[0:4], code)
+	binary.BigEndian.PutUint32(h[4:8], uint32(l))
+	h[4] = flags
+	binary.BigEndian.PutUint32(h[8:12], vendor)
+	if vendor != 0 {
+		b = append(b, h[:12]...)
+	} else {
+		b = append(b, h[:8]...)
+	}
+	b = append(b, data...)
+	for i := l; i%4 != 0; i++ {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// TtlsParseAvps split the data of the tunnel to AVPs
+func TtlsParseAvps(b []byte) ([]TtlsAvp, error) {
+	var r []TtlsAvp
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, fmt.Errorf("avp too short")
+		}
+		var avp TtlsAvp
+		avp.Code = binary.BigEndian.Uint32(b[0:4])
+		flags := b[4]
+		l := int(binary.BigEndian.Uint32(b[4:8]) & 0xffffff)
+		hl := 8
+		if flags&TTLS_AVP_FLAG_VENDOR != 0 {
+			if len(b) < 12 {
+				return nil, fmt.Errorf("avp too short")
+			}
+			avp.Vendor = binary.BigEndian.Uint32(b[8:12])
+			hl = 12
+		}
+		if l < hl || l > len(b) {
+			return nil, fmt.Errorf("invalid avp length %v", l)
+		}
+		avp.Data = b[hl:l]
+		r = append(r, avp)
+		pl := (l + 3) &^ 3
+		if pl > len(b) {
+			pl = len(b)
+		}
+		b = b[pl:]
+	}
+	return r, nil
+}
+
+type EapTtlsTunnel struct {
+	buf           []byte
+	peerChallenge []byte
+}
+
+func (o *EapTtlsTunnel) run(h *EapTlsHandler, c *tls.Conn) error {
+	plug := h.plug
+	if plug.cfg.User == nil {
+		plug.stats.pktNoUserNameErr++
+		return fmt.Errorf("no user name")
+	}
+	if plug.cfg.Password == nil {
+		plug.stats.pktMethodNoPassword++
+		return fmt.Errorf("no password")
+	}
+	user := *plug.cfg.User
+	passwd := *plug.cfg.Password
+
+	r := TtlsAppendAvp(nil, TTLS_AVP_USER_NAME, 0, []byte(user))
+	if (plug.cfg.TtlsInner == nil) || (*plug.cfg.TtlsInner == "pap") {
+		// the password is padded to 16 bytes
+		p := []byte(passwd)
+		for len(p) == 0 || len(p)%16 != 0 {
+			p = append(p, 0)
+		}
+		r = TtlsAppendAvp(r, TTLS_AVP_USER_PASSWORD, 0, p)
+		c.Write(r)
+		h.done = true
+		return nil
+	}
+
+	if *plug.cfg.TtlsInner != "mschapv2" {
+		plug.stats.tlsCfgErr++
+		return fmt.Errorf("invalid ttls_inner %s", *plug.cfg.TtlsInner)
+	}
+
+	cs := c.ConnectionState()
+	km, err := cs.ExportKeyingMaterial(TTLS_CHALLENGE_LABEL, nil, 17)
+	if err != nil {
+		return err
+	}
+	authChallenge := km[:16]
+	ident := km[16]
+	if plug.Tctx.Simulation {
+		o.peerChallenge = []byte{1, 2, 3, 4, 5, 6, 7, 8, 1, 2, 3, 4, 5, 6, 7, 8}
+	} else {
+		genChalange16B(&o.peerChallenge)
+	}
+	res, err := Encryptv2(authChallenge, o.peerChallenge, user, passwd)
+	if err != nil {
+		return err
+	}
+	// Ident, Flags, Peer-Challenge, Reserved, NT-Response
+	resp := make([]byte, 0, 50)
+	resp = append(resp, ident, 0)
+	resp = append(resp, o.peerChallenge...)
+	resp = append(resp, 0, 0, 0, 0, 0, 0, 0, 0)
+	resp = append(resp, res.ChallengeResponse...)
+	r = TtlsAppendAvp(r, TTLS_AVP_MS_CHAP_CHALLENGE, TTLS_VENDOR_MICROSOFT, authChallenge)
+	r = TtlsAppendAvp(r, TTLS_AVP_MS_CHAP2_RESPONSE, TTLS_VENDOR_MICROSOFT, resp)
+	c.Write(r)
+
+	n, err := c.Read(o.buf)
+	if err != nil {
+		return err
+	}
+	avps, err := TtlsParseAvps(o.buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, avp := range avps {
+		if avp.Vendor != TTLS_VENDOR_MICROSOFT || avp.Code != TTLS_AVP_MS_CHAP2_SUCCESS {
+			continue
+		}
+		// Ident, "S=<auth_string>"
+		if len(avp.Data) < 1+len(res.AuthenticatorResponse) || avp.Data[0] != ident {
+			break
+		}
+		if !bytes.Equal(avp.Data[1:1+len(res.AuthenticatorResponse)], []byte(res.AuthenticatorResponse)) {
+			break
+		}
+		h.done = true // ack the success
+		return nil
+	}
+	return fmt.Errorf("no valid MS-CHAP2-Success")
+}
+
+func NewEapTtls() Dot1xMethodIF {
+	p := new(EapTlsHandler)
+	p.method = EAP_TYPE_TTLS
+	p.name = "eap-ttls"
+	t := new(EapTtlsTunnel)
+	t.buf = make([]byte, TLS_MAX_RECORD_SIZE)
+	t.peerChallenge = make([]byte, 16)
+	p.tunnel = t
+	return p
+}
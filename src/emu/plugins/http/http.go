@@ -0,0 +1,1283 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+/*
+HTTP/1.1 client and server over the emu transport (TCP or TLS), RFC 7230
+
+Each client runs a request profile toward the server address. The requests are sent on a persistent connection
+(keepalive) with up to pipeline requests in flight. A new request is sent after think_time msec from the response,
+or immediately in case it is zero. Without keepalive each request opens a new connection. The client stops
+after requests requests, zero means forever.
+
+The request target is url, or it is generated for each request by the field engine named "url" (type histogram_url).
+The host of the generated URL is the Host header. The body size of each request is picked from body_size
+by the probability of each entry.
+
+client init json:
+
+	{
+		"addr": "48.0.0.1:80",          // the server, there is no request profile in case it is not provided
+		"tls": {},                      // transport TlsCfg, https
+		"method": "GET",
+		"url": "/index.html",
+		"engines": [{"engine_name": "url", "engine_type": "histogram_url",
+					 "params": {"size": 64, "offset": 0, "entries": [{"schemes": ["http"], "hosts": ["www.emu.com"], "paths": ["/a", "/b"], "prob": 1}]}}],
+		"headers": {"Accept": "text/html"},
+		"body_size": [{"size": 0, "prob": 80}, {"size": 1024, "prob": 20}],
+		"think_time": 0,
+		"requests": 0,
+		"keepalive": true,
+		"pipeline": 1,
+		"timeo": 10,                    // sec without a response, the connection is reset
+		"listen": false                 // serve the namespace server on this client
+	}
+
+The server is defined per namespace, it is served by each client that was created with listen. It answers each
+request with a status and an object size picked from responses by the probability of each entry.
+
+namespace init json:
+
+	{
+		"server": {
+			"port": 80,
+			"tls": {"cert": "..", "key": ".."},
+			"responses": [{"status": 200, "size": 1024, "prob": 90}, {"status": 404, "size": 0, "prob": 10}],
+			"headers": {"Cache-Control": "no-cache"},
+			"keepalive": true
+		}
+	}
+
+The latency is from the request to the last byte of its response, in a histogram per client (msec buckets).
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	HTTP_PLUG = "http"
+
+	HTTP_DEF_PORT        = 80
+	HTTP_DEF_TIMEO       = 10
+	HTTP_DEF_OBJECT_SIZE = 1024
+	HTTP_BODY_CHUNK      = 16384
+	HTTP_URL_ENGINE      = "url"
+	HTTP_USER_AGENT      = "trex-emu"
+
+	// delay of a new connection after a connection that failed
+	HTTP_RECONNECT_DELAY = time.Second
+
+	// state of each client
+	HTTP_STATE_INIT       = 1 // not started, invalid configuration or server only
+	HTTP_STATE_CONNECTING = 2
+	HTTP_STATE_ACTIVE     = 3 // connected, sending requests
+	HTTP_STATE_WAIT       = 4 // not connected, waiting for the next connection
+	HTTP_STATE_DONE       = 5 // all the requests were sent
+
+	// timers of the client
+	httpTimerThink = 1
+	httpTimerTimeo = 2
+)
+
+// httpBody the content of all the bodies, a body is written in chunks of it
+var httpBody = func() []byte {
+	b := make([]byte, HTTP_BODY_CHUNK)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return b
+}()
+
+type HttpClientStats struct {
+	invalidInitJson  uint64
+	invalidEngine    uint64
+	invalidUrl       uint64
+	invalidSocket    uint64
+	socketWriteError uint64
+	connOpen         uint64
+	connEstablished  uint64
+	connErr          uint64
+	reqTx            uint64
+	reqTxBytes       uint64
+	respRx           uint64
+	respRxBodyBytes  uint64
+	respParserErr    uint64
+	respUnexpected   uint64
+	respTimeout      uint64
+	respAborted      uint64
+}
+
+func NewHttpClientStatsDb(o *HttpClientStats) *core.CCounterDb {
+	db := core.NewCCounterDb(HTTP_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidEngine,
+		Name:     "invalidEngine",
+		Help:     "Error creating the field engines or there is no url engine",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidUrl,
+		Name:     "invalidUrl",
+		Help:     "Invalid URL from the url engine",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidSocket,
+		Name:     "invalidSocket",
+		Help:     "Error creating socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.socketWriteError,
+		Name:     "socketWriteError",
+		Help:     "Error writing in socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connOpen,
+		Name:     "connOpen",
+		Help:     "Connections opened",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connEstablished,
+		Name:     "connEstablished",
+		Help:     "Connections established",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connErr,
+		Name:     "connErr",
+		Help:     "Connections closed before they were established",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqTx,
+		Name:     "reqTx",
+		Help:     "Requests sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqTxBytes,
+		Name:     "reqTxBytes",
+		Help:     "Bytes of the requests, headers and body",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respRx,
+		Name:     "respRx",
+		Help:     "Responses received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respRxBodyBytes,
+		Name:     "respRxBodyBytes",
+		Help:     "Bytes of the bodies of the responses",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respParserErr,
+		Name:     "respParserErr",
+		Help:     "Invalid response",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respUnexpected,
+		Name:     "respUnexpected",
+		Help:     "Response without a pending request",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTimeout,
+		Name:     "respTimeout",
+		Help:     "Requests without a response in timeo seconds",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respAborted,
+		Name:     "respAborted",
+		Help:     "Requests without a response when the connection was closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+type HttpServerStats struct {
+	invalidInitJson  uint64
+	invalidServer    uint64
+	socketWriteError uint64
+	connAccept       uint64
+	reqRx            uint64
+	reqRxBodyBytes   uint64
+	reqParserErr     uint64
+	respTx           uint64
+	respTxBytes      uint64
+}
+
+func NewHttpServerStatsDb(o *HttpServerStats) *core.CCounterDb {
+	db := core.NewCCounterDb("httpSrv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidServer,
+		Name:     "invalidServer",
+		Help:     "Error listening, invalid server configuration",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.socketWriteError,
+		Name:     "socketWriteError",
+		Help:     "Error writing in socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connAccept,
+		Name:     "connAccept",
+		Help:     "Connections accepted",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqRx,
+		Name:     "reqRx",
+		Help:     "Requests received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqRxBodyBytes,
+		Name:     "reqRxBodyBytes",
+		Help:     "Bytes of the bodies of the requests",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqParserErr,
+		Name:     "reqParserErr",
+		Help:     "Invalid request, answered by 400",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTx,
+		Name:     "respTx",
+		Help:     "Responses sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTxBytes,
+		Name:     "respTxBytes",
+		Help:     "Bytes of the responses, headers and body",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+// HttpStatusStats status code of the responses by class
+type HttpStatusStats struct {
+	status1xx   uint64
+	status2xx   uint64
+	status3xx   uint64
+	status4xx   uint64
+	status5xx   uint64
+	statusOther uint64
+}
+
+// Add count a response by the class of its status
+func (o *HttpStatusStats) Add(status int) {
+	switch status / 100 {
+	case 1:
+		o.status1xx++
+	case 2:
+		o.status2xx++
+	case 3:
+		o.status3xx++
+	case 4:
+		o.status4xx++
+	case 5:
+		o.status5xx++
+	default:
+		o.statusOther++
+	}
+}
+
+func NewHttpStatusDb(name string, o *HttpStatusStats) *core.CCounterDb {
+	db := core.NewCCounterDb(name)
+	cnt := []*uint64{&o.status1xx, &o.status2xx, &o.status3xx, &o.status4xx, &o.status5xx}
+	for i := range cnt {
+		db.Add(&core.CCounterRec{
+			Counter:  cnt[i],
+			Name:     fmt.Sprintf("status%vxx", i+1),
+			Help:     fmt.Sprintf("responses with status %vxx", i+1),
+			Unit:     "ops",
+			DumpZero: false,
+			Info:     core.ScINFO})
+	}
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.statusOther,
+		Name:     "statusOther",
+		Help:     "responses with status 600 or above",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type HttpSizeEntry struct {
+	Size uint32 `json:"size"`
+	Prob uint32 `json:"prob" validate:"gte=1"`
+}
+
+type HttpClientInit struct {
+	Listen    bool                 `json:"listen"`
+	Addr      string               `json:"addr"`
+	Tls       *transport.TlsCfg    `json:"tls"`
+	Method    string               `json:"method" validate:"oneof=GET HEAD POST PUT DELETE OPTIONS"`
+	Url       string               `json:"url"`
+	Engines   *fastjson.RawMessage `json:"engines"`
+	Headers   map[string]string    `json:"headers"`
+	BodySize  []HttpSizeEntry      `json:"body_size" validate:"dive"`
+	ThinkTime uint32               `json:"think_time"`
+	Requests  uint32               `json:"requests"`
+	KeepAlive bool                 `json:"keepalive"`
+	Pipeline  uint32               `json:"pipeline" validate:"gte=1,lte=32"`
+	TimeoSec  uint32               `json:"timeo" validate:"gte=1"`
+}
+
+type HttpResponseEntry struct {
+	Status uint16 `json:"status" validate:"gte=200,lte=599"`
+	Size   uint32 `json:"size"`
+	Prob   uint32 `json:"prob" validate:"gte=1"`
+}
+
+type HttpServerInit struct {
+	Port      uint16              `json:"port" validate:"ne=0"`
+	Tls       *transport.TlsCfg   `json:"tls"`
+	Responses []HttpResponseEntry `json:"responses" validate:"required,min=1,dive"`
+	Headers   map[string]string   `json:"headers"`
+	KeepAlive bool                `json:"keepalive"`
+}
+
+type HttpNsInit struct {
+	Server HttpServerInit `json:"server"`
+}
+
+// httpHeaders the headers of the configuration sorted by name, to be added as is to each message
+func httpHeaders(m map[string]string) []byte {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, n := range names {
+		b = append(b, n...)
+		b = append(b, ": "...)
+		b = append(b, m[n]...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+func httpHasHeader(m map[string]string, name string) bool {
+	for n := range m {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpTxBuf a buffer to write, or fill bytes of the body in case b is nil
+type httpTxBuf struct {
+	b    []byte
+	fill int64
+}
+
+// httpTx the tx queue of a connection. The socket keeps the buffer that it could not queue until SocketTxMore,
+// the rest waits here.
+type httpTx struct {
+	q     []httpTxBuf
+	drain bool
+}
+
+func (o *httpTx) reset() {
+	o.q = o.q[:0]
+	o.drain = false
+}
+
+func (o *httpTx) empty() bool {
+	return !o.drain && len(o.q) == 0
+}
+
+func (o *httpTx) flush(s transport.SocketApi) transport.SocketErr {
+	for !o.drain && len(o.q) > 0 {
+		e := &o.q[0]
+		b := e.b
+		var n int64
+		if b == nil {
+			b = httpBody
+			if int64(len(b)) > e.fill {
+				b = b[:e.fill]
+			}
+			n = int64(len(b))
+		}
+		r, queued := s.Write(b)
+		if r == transport.SeWRITE_WHILE_DRAIN {
+			// SocketTxMore of a buffer that was queued before, wait for the next one
+			o.drain = true
+			return transport.SeOK
+		}
+		if r != transport.SeOK {
+			return r
+		}
+		e.fill -= n
+		if e.fill == 0 {
+			o.q = o.q[1:]
+		}
+		o.drain = !queued
+	}
+	return transport.SeOK
+}
+
+func (o *httpTx) write(s transport.SocketApi, b []byte) transport.SocketErr {
+	o.q = append(o.q, httpTxBuf{b: b})
+	return o.flush(s)
+}
+
+func (o *httpTx) writeBody(s transport.SocketApi, n int64) transport.SocketErr {
+	if n == 0 {
+		return transport.SeOK
+	}
+	o.q = append(o.q, httpTxBuf{fill: n})
+	return o.flush(s)
+}
+
+func (o *httpTx) onTxMore(s transport.SocketApi) transport.SocketErr {
+	o.drain = false
+	return o.flush(s)
+}
+
+type PluginHttpTimer struct {
+}
+
+func (o *PluginHttpTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginHttpClient)
+	pi.onTimerEvent(b.(int))
+}
+
+// httpReq a request that waits for its response
+type httpReq struct {
+	head  bool
+	start time.Duration
+}
+
+// PluginHttpClient information per client
+type PluginHttpClient struct {
+	core.PluginBase
+	nsPlug    *PluginHttpNs
+	cfg       HttpClientInit
+	valid     bool
+	ctx       *transport.TransportCtx
+	timerw    *core.TimerCtx
+	timerCb   PluginHttpTimer
+	think     core.CHTimerObj
+	timeo     core.CHTimerObj
+	state     uint8
+	s         transport.SocketApi
+	connected bool
+	closing   bool
+	tx        httpTx
+	parser    httpParser
+	pending   []httpReq
+	depth     int
+	reqCnt    uint32
+	hdrs      []byte // the headers of the configuration
+	hasHost   bool
+	hasAgent  bool
+	host      string
+	urlEng    engines.FieldEngineIF // nil in case of a fixed url
+	urlBuf    []byte
+	bodyGen   *engines.NonUniformRandGen
+	listening bool
+	flows     map[*httpSrvFlow]bool
+	stats     HttpClientStats
+	status    HttpStatusStats
+	lat       core.LatHist
+	cdb       *core.CCounterDb
+	cdbv      *core.CCounterDbVec
+}
+
+/*NewHttpClient create plugin */
+func NewHttpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginHttpClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, []string{}, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(HTTP_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginHttpNs)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.state = HTTP_STATE_INIT
+	o.think.SetCB(&o.timerCb, o, httpTimerThink)
+	o.timeo.SetCB(&o.timerCb, o, httpTimerTimeo)
+	o.parser.init(false, o)
+	o.cdb = NewHttpClientStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.cdbv.Add(NewHttpStatusDb("httpStatus", &o.status))
+	o.cdbv.Add(core.NewLatHistDb("httpLat", "ops", &o.lat))
+
+	o.cfg = HttpClientInit{Method: "GET", Url: "/", KeepAlive: true, Pipeline: 1, TimeoSec: HTTP_DEF_TIMEO}
+	if err := o.Tctx.UnmarshalValidate(initJson, &o.cfg); err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.ctx = transport.GetTransportCtx(o.Client)
+	if o.ctx == nil {
+		o.stats.invalidSocket++
+		return &o.PluginBase
+	}
+	if o.cfg.Listen {
+		o.listen()
+	}
+	if o.cfg.Addr == "" {
+		return &o.PluginBase
+	}
+	if err := o.OnCreate(); err != nil {
+		return &o.PluginBase
+	}
+	o.valid = true
+	o.dial()
+	return &o.PluginBase
+}
+
+func (o *PluginHttpClient) OnCreate() error {
+	host, port, err := net.SplitHostPort(o.cfg.Addr)
+	if err != nil || net.ParseIP(host) == nil || port == "" {
+		o.stats.invalidInitJson++
+		return fmt.Errorf("invalid addr %s", o.cfg.Addr)
+	}
+	if !strings.HasPrefix(o.cfg.Url, "/") {
+		o.stats.invalidInitJson++
+		return fmt.Errorf("invalid url %s", o.cfg.Url)
+	}
+	o.host = o.cfg.Addr
+	o.hdrs = httpHeaders(o.cfg.Headers)
+	o.hasHost = httpHasHeader(o.cfg.Headers, "Host")
+	o.hasAgent = httpHasHeader(o.cfg.Headers, "User-Agent")
+	o.depth = int(o.cfg.Pipeline)
+	if !o.cfg.KeepAlive {
+		o.depth = 1
+	}
+
+	if o.cfg.Engines != nil {
+		mgr := engines.NewEngineManager(o.Tctx, o.cfg.Engines)
+		if !mgr.WasCreatedSuccessfully() {
+			o.stats.invalidEngine++
+			return fmt.Errorf("invalid engines")
+		}
+		eng, ok := mgr.GetEngineMap()[HTTP_URL_ENGINE]
+		if !ok {
+			o.stats.invalidEngine++
+			return fmt.Errorf("there is no %s engine", HTTP_URL_ENGINE)
+		}
+		o.urlEng = eng
+		o.urlBuf = make([]byte, eng.GetSize())
+	}
+
+	if len(o.cfg.BodySize) > 0 {
+		probs := make([]uint32, len(o.cfg.BodySize))
+		for i := range o.cfg.BodySize {
+			probs[i] = o.cfg.BodySize[i].Prob
+		}
+		o.bodyGen, err = engines.NewNonUniformRandGen(probs)
+		if err != nil {
+			o.stats.invalidInitJson++
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *PluginHttpClient) OnEvent(msg string, a, b interface{}) {}
+
+func (o *PluginHttpClient) OnRemove(ctx *core.PluginCtx) {
+	o.stopTimer(&o.think)
+	o.stopTimer(&o.timeo)
+	o.state = HTTP_STATE_DONE
+	if o.s != nil {
+		o.s.Shutdown()
+	}
+	if o.listening {
+		o.unlisten()
+	}
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginHttpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginHttpClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+func (o *PluginHttpClient) startTimer(t *core.CHTimerObj, d time.Duration) {
+	o.stopTimer(t)
+	o.timerw.Start(t, d)
+}
+
+// more there are more requests to send
+func (o *PluginHttpClient) more() bool {
+	return o.cfg.Requests == 0 || o.reqCnt < o.cfg.Requests
+}
+
+func (o *PluginHttpClient) dial() {
+	var err error
+	if o.cfg.Tls != nil {
+		o.s, err = o.ctx.DialTls(o.cfg.Addr, o, nil, nil, 0, o.cfg.Tls)
+	} else {
+		o.s, err = o.ctx.Dial("tcp", o.cfg.Addr, o, nil, nil, 0)
+	}
+	if err != nil {
+		o.stats.invalidSocket++
+		o.s = nil
+		o.state = HTTP_STATE_WAIT
+		o.startTimer(&o.think, HTTP_RECONNECT_DELAY)
+		return
+	}
+	o.stats.connOpen++
+	o.state = HTTP_STATE_CONNECTING
+	o.connected = false
+	o.closing = false
+}
+
+// closeConn close the connection after the tx queue of the socket is flushed
+func (o *PluginHttpClient) closeConn() {
+	if o.s != nil && !o.closing {
+		o.closing = true
+		o.s.Close()
+	}
+}
+
+// abort the requests that wait for a response
+func (o *PluginHttpClient) abort() {
+	o.stats.respAborted += uint64(len(o.pending))
+	o.pending = o.pending[:0]
+}
+
+func (o *PluginHttpClient) onClosed() {
+	if !o.connected {
+		o.stats.connErr++
+	}
+	o.abort()
+	o.s = nil
+	o.tx.reset()
+	o.parser.reset()
+	o.stopTimer(&o.timeo)
+	if o.state == HTTP_STATE_DONE {
+		return
+	}
+	if !o.more() {
+		o.state = HTTP_STATE_DONE
+		return
+	}
+	// the next connection after the think time, in case of a failure wait at least the reconnect delay
+	d := time.Duration(o.cfg.ThinkTime) * time.Millisecond
+	if !o.connected && d < HTTP_RECONNECT_DELAY {
+		d = HTTP_RECONNECT_DELAY
+	}
+	if d == 0 {
+		o.dial()
+		return
+	}
+	o.state = HTTP_STATE_WAIT
+	o.startTimer(&o.think, d)
+}
+
+// fill send requests up to the pipeline depth
+func (o *PluginHttpClient) fill() {
+	for o.s != nil && o.connected && !o.closing && len(o.pending) < o.depth && o.more() {
+		if !o.sendRequest() {
+			return
+		}
+	}
+}
+
+// next the next request after a response, after the think time
+func (o *PluginHttpClient) next() {
+	if o.cfg.ThinkTime == 0 {
+		o.fill()
+		return
+	}
+	if !o.think.IsRunning() {
+		o.startTimer(&o.think, time.Duration(o.cfg.ThinkTime)*time.Millisecond)
+	}
+}
+
+// target the request target and the host of the next request
+func (o *PluginHttpClient) target() (target string, host string, err error) {
+	if o.urlEng == nil {
+		return o.cfg.Url, o.host, nil
+	}
+	if _, err = o.urlEng.Update(o.urlBuf); err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(string(bytes.TrimRight(o.urlBuf, "\x00")))
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" {
+		return u.RequestURI(), o.host, nil
+	}
+	return u.RequestURI(), u.Host, nil
+}
+
+func (o *PluginHttpClient) sendRequest() bool {
+	target, host, err := o.target()
+	if err != nil {
+		o.stats.invalidUrl++
+		o.state = HTTP_STATE_DONE
+		o.closeConn()
+		return false
+	}
+	var bodyLen int64
+	if o.bodyGen != nil {
+		bodyLen = int64(o.cfg.BodySize[o.bodyGen.Generate()].Size)
+	}
+
+	h := make([]byte, 0, 128+len(target)+len(o.hdrs))
+	h = append(h, o.cfg.Method...)
+	h = append(h, ' ')
+	h = append(h, target...)
+	h = append(h, " HTTP/1.1\r\n"...)
+	if !o.hasHost {
+		h = append(h, "Host: "...)
+		h = append(h, host...)
+		h = append(h, "\r\n"...)
+	}
+	if !o.hasAgent {
+		h = append(h, "User-Agent: "+HTTP_USER_AGENT+"\r\n"...)
+	}
+	if bodyLen > 0 || o.cfg.Method == "POST" || o.cfg.Method == "PUT" {
+		h = append(h, "Content-Length: "...)
+		h = strconv.AppendInt(h, bodyLen, 10)
+		h = append(h, "\r\n"...)
+	}
+	if !o.cfg.KeepAlive {
+		h = append(h, "Connection: close\r\n"...)
+	}
+	h = append(h, o.hdrs...)
+	h = append(h, "\r\n"...)
+
+	r := o.tx.write(o.s, h)
+	if r == transport.SeOK {
+		r = o.tx.writeBody(o.s, bodyLen)
+	}
+	if r != transport.SeOK {
+		o.stats.socketWriteError++
+		o.closeConn()
+		return false
+	}
+	o.reqCnt++
+	o.stats.reqTx++
+	o.stats.reqTxBytes += uint64(len(h)) + uint64(bodyLen)
+	o.pending = append(o.pending, httpReq{head: o.cfg.Method == "HEAD", start: o.Tctx.LatNow()})
+	if !o.timeo.IsRunning() {
+		o.startTimer(&o.timeo, time.Duration(o.cfg.TimeoSec)*time.Second)
+	}
+	return true
+}
+
+func (o *PluginHttpClient) onTimerEvent(t int) {
+	switch t {
+	case httpTimerThink:
+		if o.s == nil {
+			o.dial()
+		} else {
+			o.fill()
+		}
+	case httpTimerTimeo:
+		o.stats.respTimeout += uint64(len(o.pending))
+		o.pending = o.pending[:0]
+		if o.s != nil {
+			o.s.Shutdown()
+		}
+	}
+}
+
+func (o *PluginHttpClient) OnRxEvent(event transport.SocketEventType) {
+	if (event & transport.SocketEventConnected) > 0 {
+		o.stats.connEstablished++
+		o.connected = true
+		o.state = HTTP_STATE_ACTIVE
+		o.fill()
+	}
+
+	if event&transport.SocketRemoteDisconnect > 0 {
+		if err := o.parser.eof(); err != nil {
+			o.stats.respParserErr++
+		}
+		o.closeConn()
+	}
+
+	if (event & transport.SocketClosed) > 0 {
+		o.onClosed()
+	}
+}
+
+func (o *PluginHttpClient) OnRxData(d []byte) {
+	if len(o.pending) > 0 {
+		o.startTimer(&o.timeo, time.Duration(o.cfg.TimeoSec)*time.Second)
+	}
+	if err := o.parser.parse(d); err != nil {
+		o.stats.respParserErr++
+		o.abort()
+		o.closeConn()
+	}
+}
+
+func (o *PluginHttpClient) OnTxEvent(event transport.SocketEventType) {
+	if event&transport.SocketTxMore > 0 && o.s != nil {
+		if o.tx.onTxMore(o.s) != transport.SeOK {
+			o.stats.socketWriteError++
+			o.closeConn()
+		}
+	}
+}
+
+// onHeaders implements httpHandler for the responses
+func (o *PluginHttpClient) onHeaders(m *httpMsg) bool {
+	return len(o.pending) > 0 && !o.pending[0].head
+}
+
+func (o *PluginHttpClient) onBody(n int) {
+	o.stats.respRxBodyBytes += uint64(n)
+}
+
+func (o *PluginHttpClient) onMessage(m *httpMsg) {
+	if len(o.pending) == 0 {
+		o.stats.respUnexpected++
+		return
+	}
+	o.status.Add(m.status)
+	if m.status < 200 {
+		// interim response, the final response follows
+		return
+	}
+	req := o.pending[0]
+	o.pending = append(o.pending[:0], o.pending[1:]...)
+	o.stats.respRx++
+	o.lat.Add(o.Tctx.LatNow() - req.start)
+	if len(o.pending) == 0 {
+		o.stopTimer(&o.timeo)
+	}
+
+	if m.close || !o.cfg.KeepAlive {
+		// the server closes the connection or it was for one request
+		o.closeConn()
+		return
+	}
+	if !o.more() {
+		if len(o.pending) == 0 {
+			o.state = HTTP_STATE_DONE
+			o.closeConn()
+		}
+		return
+	}
+	o.next()
+}
+
+// listen serve the namespace server on this client
+func (o *PluginHttpClient) listen() {
+	ns := o.nsPlug
+	if !ns.valid {
+		ns.stats.invalidServer++
+		return
+	}
+	addr := fmt.Sprintf(":%v", ns.init.Server.Port)
+	var err error
+	if ns.init.Server.Tls != nil {
+		err = o.ctx.ListenTls(addr, o, ns.init.Server.Tls)
+	} else {
+		err = o.ctx.Listen("tcp", addr, o)
+	}
+	if err != nil {
+		ns.stats.invalidServer++
+		return
+	}
+	o.listening = true
+	o.flows = make(map[*httpSrvFlow]bool)
+}
+
+func (o *PluginHttpClient) unlisten() {
+	ns := o.nsPlug
+	addr := fmt.Sprintf(":%v", ns.init.Server.Port)
+	if ns.init.Server.Tls != nil {
+		o.ctx.UnListenTls(addr, o)
+	} else {
+		o.ctx.UnListen("tcp", addr, o)
+	}
+	for f := range o.flows {
+		if f.s != nil {
+			f.s.Shutdown()
+		}
+	}
+	o.flows = nil
+	o.listening = false
+}
+
+// OnAccept implements transport.IServerSocketCb for the server
+func (o *PluginHttpClient) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	f := &httpSrvFlow{c: o, ns: o.nsPlug, s: socket}
+	f.parser.init(true, f)
+	o.flows[f] = true
+	o.nsPlug.stats.connAccept++
+	return f
+}
+
+// httpSrvFlow a connection of the server
+type httpSrvFlow struct {
+	c       *PluginHttpClient
+	ns      *PluginHttpNs
+	s       transport.SocketApi
+	tx      httpTx
+	parser  httpParser
+	head    bool
+	closing bool // close after the tx queue is flushed
+	closed  bool
+}
+
+func (o *httpSrvFlow) OnRxEvent(event transport.SocketEventType) {
+	if event&transport.SocketRemoteDisconnect > 0 {
+		if o.parser.eof() != nil {
+			o.ns.stats.reqParserErr++
+		}
+		o.closing = true
+		o.checkClose()
+	}
+
+	if (event & transport.SocketClosed) > 0 {
+		if o.c.flows != nil {
+			delete(o.c.flows, o)
+		}
+		o.s = nil
+	}
+}
+
+func (o *httpSrvFlow) OnRxData(d []byte) {
+	if o.closing {
+		return
+	}
+	if err := o.parser.parse(d); err != nil {
+		o.ns.stats.reqParserErr++
+		o.respond(400, 0, true)
+	}
+}
+
+func (o *httpSrvFlow) OnTxEvent(event transport.SocketEventType) {
+	if event&transport.SocketTxMore > 0 && o.s != nil {
+		if o.tx.onTxMore(o.s) != transport.SeOK {
+			o.ns.stats.socketWriteError++
+			o.s.Shutdown()
+			return
+		}
+		o.checkClose()
+	}
+}
+
+func (o *httpSrvFlow) checkClose() {
+	if o.closing && !o.closed && o.s != nil && o.tx.empty() {
+		o.closed = true
+		o.s.Close()
+	}
+}
+
+// onHeaders implements httpHandler for the requests
+func (o *httpSrvFlow) onHeaders(m *httpMsg) bool {
+	o.head = m.method == "HEAD"
+	return true
+}
+
+func (o *httpSrvFlow) onBody(n int) {
+	o.ns.stats.reqRxBodyBytes += uint64(n)
+}
+
+func (o *httpSrvFlow) onMessage(m *httpMsg) {
+	if o.closing {
+		return
+	}
+	o.ns.stats.reqRx++
+	e := &o.ns.init.Server.Responses[o.ns.respGen.Generate()]
+	o.respond(int(e.Status), int64(e.Size), m.close || !o.ns.init.Server.KeepAlive)
+}
+
+func (o *httpSrvFlow) respond(status int, size int64, close bool) {
+	bodyless := status == 204 || status == 304
+	h := make([]byte, 0, 160+len(o.ns.hdrs))
+	h = append(h, "HTTP/1.1 "...)
+	h = strconv.AppendInt(h, int64(status), 10)
+	h = append(h, ' ')
+	h = append(h, nethttp.StatusText(status)...)
+	h = append(h, "\r\nServer: "+HTTP_USER_AGENT+"\r\n"...)
+	if !bodyless {
+		h = append(h, "Content-Type: application/octet-stream\r\nContent-Length: "...)
+		h = strconv.AppendInt(h, size, 10)
+		h = append(h, "\r\n"...)
+	}
+	if close {
+		h = append(h, "Connection: close\r\n"...)
+	}
+	h = append(h, o.ns.hdrs...)
+	h = append(h, "\r\n"...)
+
+	if bodyless || o.head {
+		size = 0
+	}
+	r := o.tx.write(o.s, h)
+	if r == transport.SeOK {
+		r = o.tx.writeBody(o.s, size)
+	}
+	if r != transport.SeOK {
+		o.ns.stats.socketWriteError++
+		o.closing = true
+		o.closed = true
+		o.s.Shutdown()
+		return
+	}
+	o.ns.stats.respTx++
+	o.ns.stats.respTxBytes += uint64(len(h)) + uint64(size)
+	o.ns.status.Add(status)
+	if close {
+		o.closing = true
+		o.checkClose()
+	}
+}
+
+// PluginHttpNs information per namespace, the server
+type PluginHttpNs struct {
+	core.PluginBase
+	init    HttpNsInit
+	valid   bool
+	respGen *engines.NonUniformRandGen
+	hdrs    []byte
+	stats   HttpServerStats
+	status  HttpStatusStats
+	cdb     *core.CCounterDb
+	cdbv    *core.CCounterDbVec
+}
+
+func NewHttpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginHttpNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewHttpServerStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(HTTP_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.cdbv.Add(NewHttpStatusDb("httpSrvStatus", &o.status))
+
+	o.init.Server = HttpServerInit{Port: HTTP_DEF_PORT, KeepAlive: true,
+		Responses: []HttpResponseEntry{{Status: 200, Size: HTTP_DEF_OBJECT_SIZE, Prob: 1}}}
+	if len(initJson) > 0 {
+		if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+			o.stats.invalidInitJson++
+			return &o.PluginBase
+		}
+	}
+	probs := make([]uint32, len(o.init.Server.Responses))
+	for i := range o.init.Server.Responses {
+		probs[i] = o.init.Server.Responses[i].Prob
+	}
+	var err error
+	o.respGen, err = engines.NewNonUniformRandGen(probs)
+	if err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.hdrs = httpHeaders(o.init.Server.Headers)
+	o.valid = true
+	return &o.PluginBase
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginHttpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginHttpNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginHttpNs) OnEvent(msg string, a, b interface{}) {
+}
+
+type PluginHttpCReg struct{}
+type PluginHttpNsReg struct{}
+
+func (o PluginHttpCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpClient(ctx, initJson)
+}
+
+func (o PluginHttpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewHttpNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiHttpNsCntHandler     struct{}
+	ApiHttpClientCntHandler struct{}
+)
+
+func getNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginHttpNs, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, HTTP_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginHttpNs), nil
+}
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginHttpClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, HTTP_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginHttpClient), nil
+}
+
+func (h ApiHttpNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	nsPlug, err := getNsPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return nsPlug.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiHttpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(HTTP_PLUG,
+		core.PluginRegisterData{Client: PluginHttpCReg{},
+			Ns:     PluginHttpNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("http_ns_cnt", ApiHttpNsCntHandler{}, true)    // server counters
+	core.RegisterCB("http_c_cnt", ApiHttpClientCntHandler{}, true) // counters of the request profile of the client
+}
+
+func Register(ctx *core.CThreadCtx) {
+}
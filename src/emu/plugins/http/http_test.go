@@ -0,0 +1,303 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+import (
+	"emu/core"
+	"emu/core/testpki"
+	"emu/plugins/transport"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+type HttpTestBase struct {
+	testname   string
+	capture    bool
+	duration   time.Duration
+	seed       int64
+	nsJson     string
+	clientJson string
+	expState   uint8
+	expReq     uint32
+	listen     bool // the server listens, otherwise the connections are refused
+}
+
+var httpTestClientMac = core.MACKey{0, 0, 1, 0, 0, 1}
+var httpTestServerMac = core.MACKey{0, 0, 1, 0, 0, 2}
+
+func (o *HttpTestBase) Run(t *testing.T) {
+	var simVeth VethHttpSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	rand.Seed(o.seed)
+	tctx := createSimulationEnv(&simrx, o)
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+	defer tctx.Delete()
+
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := tctx.GetNs(&key)
+	if ns == nil {
+		t.Fatalf(" can't find ns")
+	}
+	c := ns.CLookupByMac(&httpTestClientMac)
+	plug := c.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpClient)
+	nsPlug := ns.PluginCtx.Get(HTTP_PLUG).Ext.(*PluginHttpNs)
+	plug.cdbv.Dump()
+	nsPlug.cdbv.Dump()
+	tctx.SimRecordAppend(plug.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(nsPlug.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(map[string]interface{}{"state": plug.state, "requests": plug.reqCnt})
+	if plug.state != o.expState || plug.reqCnt != o.expReq {
+		t.Fatalf(" expected state %v requests %v, got %v %v", o.expState, o.expReq, plug.state, plug.reqCnt)
+	}
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func createSimulationEnv(simRx *core.VethIFSim, test *HttpTestBase) *core.CThreadCtx {
+	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{HTTP_PLUG}, [][]byte{[]byte(test.nsJson)})
+
+	// the packets are looped back, the default gateway of the server is the client and vice versa
+	srv := core.NewClient(ns, httpTestServerMac, core.Ipv4Key{16, 0, 0, 2}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 1})
+	srv.ForceDGW = true
+	srv.Ipv4ForcedgMac = httpTestClientMac
+	ns.AddClient(srv)
+	srvJson := []byte(`{"listen": true}`)
+	if !test.listen {
+		srvJson = []byte(`{}`)
+	}
+	srv.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, HTTP_PLUG}, [][]byte{[]byte{}, srvJson})
+
+	client := core.NewClient(ns, httpTestClientMac, core.Ipv4Key{16, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 2})
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = httpTestServerMac
+	ns.AddClient(client)
+	client.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, HTTP_PLUG}, [][]byte{[]byte{}, []byte(test.clientJson)})
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+	ns.Dump()
+	return tctx
+}
+
+type VethHttpSim struct {
+}
+
+func (o *VethHttpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// httpTestTls a self signed certificate of the server, the client does not verify it
+func httpTestTls() string {
+	c, key := testpki.SelfSigned("www.emu.test")
+	return fmt.Sprintf(`{"cert": %q, "key": %q}`, testpki.CertPem(c), testpki.KeyPem(key))
+}
+
+func TestPluginHttp1(t *testing.T) {
+	a := &HttpTestBase{
+		testname:   "http1",
+		capture:    true,
+		duration:   10 * time.Second,
+		nsJson:     `{"server": {"responses": [{"status": 200, "size": 3000, "prob": 1}]}}`,
+		clientJson: `{"addr": "16.0.0.2:80", "url": "/index.html", "requests": 3, "think_time": 500}`,
+		expState:   HTTP_STATE_DONE,
+		expReq:     3,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+// pipeline of POST requests, the status and the size of the responses by their probability
+func TestPluginHttp2(t *testing.T) {
+	a := &HttpTestBase{
+		testname: "http2",
+		capture:  false,
+		duration: 60 * time.Second,
+		seed:     0x1234,
+		nsJson: `{"server": {"port": 8080, "headers": {"Cache-Control": "no-cache"},
+			"responses": [{"status": 200, "size": 20000, "prob": 60}, {"status": 302, "size": 0, "prob": 10},
+						  {"status": 404, "size": 100, "prob": 20}, {"status": 503, "size": 0, "prob": 10}]}}`,
+		clientJson: `{"addr": "16.0.0.2:8080", "method": "POST", "url": "/upload", "requests": 100, "pipeline": 4,
+			"headers": {"Accept": "*/*"}, "body_size": [{"size": 0, "prob": 50}, {"size": 5000, "prob": 50}]}`,
+		expState: HTTP_STATE_DONE,
+		expReq:   100,
+		listen:   true,
+	}
+	a.Run(t)
+}
+
+// the URLs of the requests from the histogram_url engine
+func TestPluginHttp3(t *testing.T) {
+	a := &HttpTestBase{
+		testname: "http3",
+		capture:  true,
+		duration: 10 * time.Second,
+		seed:     0x5678,
+		nsJson:   `{"server": {"responses": [{"status": 200, "size": 10, "prob": 1}]}}`,
+		clientJson: `{"addr": "16.0.0.2:80", "requests": 5, "engines": [{"engine_name": "url", "engine_type": "histogram_url",
+			"params": {"size": 64, "offset": 0, "entries": [
+				{"schemes": ["http"], "hosts": ["www.emu.test", "img.emu.test"], "paths": ["/a", "/b/c"], "queries": ["x=1"], "prob": 3},
+				{"schemes": ["http"], "hosts": ["api.emu.test:8080"], "paths": ["/v1"], "prob": 1}]}}]}`,
+		expState: HTTP_STATE_DONE,
+		expReq:   5,
+		listen:   true,
+	}
+	a.Run(t)
+}
+
+// a new connection for each request, the server closes the connection
+func TestPluginHttp4(t *testing.T) {
+	a := &HttpTestBase{
+		testname:   "http4",
+		capture:    false,
+		duration:   10 * time.Second,
+		nsJson:     `{"server": {"keepalive": false}}`,
+		clientJson: `{"addr": "16.0.0.2:80", "method": "HEAD", "requests": 4, "think_time": 100, "keepalive": false}`,
+		expState:   HTTP_STATE_DONE,
+		expReq:     4,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+// there is no server, a new connection a second after each connection fails
+func TestPluginHttp5(t *testing.T) {
+	a := &HttpTestBase{
+		testname:   "http5",
+		capture:    false,
+		duration:   12 * time.Second,
+		clientJson: `{"addr": "16.0.0.2:80", "requests": 1}`,
+		expState:   HTTP_STATE_CONNECTING,
+		expReq:     0,
+		listen:     false,
+	}
+	a.Run(t)
+}
+
+// https
+func TestPluginHttp6(t *testing.T) {
+	tls := httpTestTls()
+	a := &HttpTestBase{
+		testname:   "http6",
+		capture:    false,
+		duration:   20 * time.Second,
+		nsJson:     fmt.Sprintf(`{"server": {"port": 443, "tls": %s}}`, tls),
+		clientJson: `{"addr": "16.0.0.2:443", "tls": {"sni": "www.emu.test"}, "requests": 10, "pipeline": 2}`,
+		expState:   HTTP_STATE_DONE,
+		expReq:     10,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+func TestPluginHttpInvalid(t *testing.T) {
+	a := &HttpTestBase{
+		testname:   "http_invalid",
+		capture:    false,
+		duration:   time.Second,
+		nsJson:     `{"server": {"responses": [{"status": 99, "size": 10, "prob": 1}]}}`,
+		clientJson: `{"addr": "16.0.0.2:80", "engines": [{"engine_name": "path", "engine_type": "histogram_url", "params": {"size": 64, "offset": 0, "entries": [{"schemes": ["http"], "hosts": ["www.emu.test"], "prob": 1}]}}]}`,
+		expState:   HTTP_STATE_INIT,
+		expReq:     0,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+// httpTestHandler collects the events of the parser
+type httpTestHandler struct {
+	noBody bool
+	body   int
+	msgs   []httpMsg
+}
+
+func (o *httpTestHandler) onHeaders(m *httpMsg) bool { return !o.noBody }
+func (o *httpTestHandler) onBody(n int)              { o.body += n }
+func (o *httpTestHandler) onMessage(m *httpMsg)      { o.msgs = append(o.msgs, *m) }
+
+func TestHttpParser(t *testing.T) {
+	type parserTest struct {
+		request bool
+		noBody  bool
+		stream  string
+		eof     bool
+		status  []int
+		body    int
+		err     bool
+	}
+	tests := []parserTest{
+		{stream: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", status: []int{200}, body: 5},
+		{stream: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n10\r\n0123456789abcdef\r\n0\r\nX-Trailer: 1\r\n\r\n",
+			status: []int{200}, body: 21},
+		{stream: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\nHTTP/1.1 404 Not Found\r\ncontent-length: 3\r\n\r\nabc",
+			status: []int{100, 204, 404}, body: 3},
+		{stream: "HTTP/1.0 200 OK\r\n\r\nuntil close", eof: true, status: []int{200}, body: 11},
+		{noBody: true, stream: "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nHTTP/1.1 200 OK\r\n\r\n", status: []int{200, 200}},
+		{request: true, stream: "GET / HTTP/1.1\r\nHost: a\r\n\r\nPOST /x HTTP/1.1\r\nContent-Length: 4\r\n\r\nbodyGET /y HTTP/1.0\r\n\r\n", body: 4},
+		{stream: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc", eof: true, err: true, body: 3},
+		{stream: "HTTP/2 200\r\n\r\n", err: true},
+		{stream: "HTTP/1.1 20x OK\r\n\r\n", err: true},
+		{stream: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", err: true},
+	}
+	for i, test := range tests {
+		// feed the stream in all the possible splits to two buffers
+		for split := 0; split <= len(test.stream); split++ {
+			var h httpTestHandler
+			h.noBody = test.noBody
+			var p httpParser
+			p.init(test.request, &h)
+			err := p.parse([]byte(test.stream[:split]))
+			if err == nil {
+				err = p.parse([]byte(test.stream[split:]))
+			}
+			if err == nil && test.eof {
+				err = p.eof()
+			}
+			if (err != nil) != test.err {
+				t.Fatalf(" test %v split %v unexpected error %v", i, split, err)
+			}
+			if test.err {
+				continue
+			}
+			if h.body != test.body {
+				t.Fatalf(" test %v split %v body %v, expected %v", i, split, h.body, test.body)
+			}
+			if test.request {
+				if len(h.msgs) != 3 || h.msgs[1].method != "POST" || h.msgs[1].target != "/x" || !h.msgs[2].close {
+					t.Fatalf(" test %v split %v unexpected requests %+v", i, split, h.msgs)
+				}
+				continue
+			}
+			if len(h.msgs) != len(test.status) {
+				t.Fatalf(" test %v split %v %v messages, expected %v", i, split, len(h.msgs), len(test.status))
+			}
+			for j := range h.msgs {
+				if h.msgs[j].status != test.status[j] {
+					t.Fatalf(" test %v split %v status %v, expected %v", i, split, h.msgs[j].status, test.status[j])
+				}
+			}
+		}
+	}
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
@@ -0,0 +1,292 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+HTTP/1.1 message parser, RFC 7230. The parser is fed by the stream of the socket, a message could be split
+to several buffers and a buffer could hold several messages (pipelining). The body is not kept, only counted.
+*/
+
+const (
+	HTTP_MAX_HEADER_LEN = 16384
+	HTTP_MAX_LINE_LEN   = 1024
+
+	// state of the parser
+	httpStateHeader     = 0
+	httpStateBody       = 1 // Content-Length body
+	httpStateChunkSize  = 2
+	httpStateChunkData  = 3
+	httpStateChunkCrlf  = 4
+	httpStateTrailer    = 5
+	httpStateUntilClose = 6 // response without length, the body ends when the connection is closed
+)
+
+var httpHeaderEnd = []byte("\r\n\r\n")
+
+// httpMsg the start line and the headers of a request or a response
+type httpMsg struct {
+	method  string // request only
+	target  string // request only
+	status  int    // response only
+	length  int64  // Content-Length, -1 in case there is none
+	chunked bool
+	close   bool // Connection: close, or HTTP/1.0 without keep-alive
+}
+
+// httpHandler the events of the parser
+type httpHandler interface {
+	onHeaders(m *httpMsg) bool // return false in case the message has no body, i.e. response to HEAD
+	onBody(n int)
+	onMessage(m *httpMsg)
+}
+
+type httpParser struct {
+	request bool
+	h       httpHandler
+	state   uint8
+	buf     []byte // the header or a line of the chunked encoding
+	left    int64  // bytes left of the body or of the chunk
+	msg     httpMsg
+}
+
+func (o *httpParser) init(request bool, h httpHandler) {
+	o.request = request
+	o.h = h
+	o.reset()
+}
+
+func (o *httpParser) reset() {
+	o.state = httpStateHeader
+	o.buf = o.buf[:0]
+	o.left = 0
+}
+
+// inProgress a message was started and was not completed
+func (o *httpParser) inProgress() bool {
+	return o.state != httpStateHeader || len(o.buf) > 0
+}
+
+// eof the connection was closed by the remote side, return an error in case a message is not complete
+func (o *httpParser) eof() error {
+	if o.state == httpStateUntilClose {
+		o.done()
+		return nil
+	}
+	if o.inProgress() {
+		o.reset()
+		return fmt.Errorf("connection closed in the middle of a message")
+	}
+	return nil
+}
+
+func (o *httpParser) done() {
+	o.state = httpStateHeader
+	o.h.onMessage(&o.msg)
+}
+
+func (o *httpParser) startBody() {
+	s := o.msg.status
+	if !o.h.onHeaders(&o.msg) || (!o.request && (s < 200 || s == 204 || s == 304)) {
+		o.done()
+		return
+	}
+	switch {
+	case o.msg.chunked:
+		o.state = httpStateChunkSize
+	case o.msg.length > 0:
+		o.state = httpStateBody
+		o.left = o.msg.length
+	case o.msg.length < 0 && !o.request:
+		o.state = httpStateUntilClose
+	default:
+		o.done()
+	}
+}
+
+// line accumulate a line of the chunked encoding, return nil until it is complete
+func (o *httpParser) line(d []byte) (l []byte, n int, err error) {
+	i := bytes.IndexByte(d, '\n')
+	if i < 0 {
+		o.buf = append(o.buf, d...)
+		if len(o.buf) > HTTP_MAX_LINE_LEN {
+			return nil, 0, fmt.Errorf("line is too long")
+		}
+		return nil, len(d), nil
+	}
+	o.buf = append(o.buf, d[:i+1]...)
+	l = bytes.TrimRight(o.buf, "\r\n")
+	o.buf = o.buf[:0]
+	return l, i + 1, nil
+}
+
+// body consume the body or the chunk data
+func (o *httpParser) body(d []byte) int {
+	n := len(d)
+	if int64(n) > o.left {
+		n = int(o.left)
+	}
+	o.left -= int64(n)
+	o.h.onBody(n)
+	return n
+}
+
+// parse feed the parser with the next buffer of the stream
+func (o *httpParser) parse(d []byte) error {
+	for len(d) > 0 {
+		switch o.state {
+		case httpStateHeader:
+			s := len(o.buf) - len(httpHeaderEnd) + 1
+			if s < 0 {
+				s = 0
+			}
+			p := len(o.buf)
+			o.buf = append(o.buf, d...)
+			i := bytes.Index(o.buf[s:], httpHeaderEnd)
+			if i < 0 {
+				if len(o.buf) > HTTP_MAX_HEADER_LEN {
+					o.reset()
+					return fmt.Errorf("header is too long")
+				}
+				return nil
+			}
+			i += s
+			d = d[i+len(httpHeaderEnd)-p:]
+			err := o.msg.decode(o.buf[:i], o.request)
+			o.buf = o.buf[:0]
+			if err != nil {
+				o.reset()
+				return err
+			}
+			o.startBody()
+
+		case httpStateBody:
+			d = d[o.body(d):]
+			if o.left == 0 {
+				o.done()
+			}
+
+		case httpStateChunkData:
+			d = d[o.body(d):]
+			if o.left == 0 {
+				o.state = httpStateChunkCrlf
+			}
+
+		case httpStateUntilClose:
+			o.h.onBody(len(d))
+			d = nil
+
+		default:
+			l, n, err := o.line(d)
+			if err != nil {
+				o.reset()
+				return err
+			}
+			d = d[n:]
+			if l == nil {
+				continue
+			}
+			switch o.state {
+			case httpStateChunkSize:
+				if i := bytes.IndexByte(l, ';'); i >= 0 {
+					l = l[:i] // chunk extensions are ignored
+				}
+				size, err := strconv.ParseInt(string(bytes.TrimSpace(l)), 16, 64)
+				if err != nil || size < 0 {
+					o.reset()
+					return fmt.Errorf("invalid chunk size %q", l)
+				}
+				if size == 0 {
+					o.state = httpStateTrailer
+				} else {
+					o.state = httpStateChunkData
+					o.left = size
+				}
+			case httpStateChunkCrlf:
+				if len(l) != 0 {
+					o.reset()
+					return fmt.Errorf("chunk data is not terminated by CRLF")
+				}
+				o.state = httpStateChunkSize
+			case httpStateTrailer:
+				if len(l) == 0 {
+					o.done()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decode the start line and the headers, without the empty line at the end
+func (o *httpMsg) decode(b []byte, request bool) error {
+	lines := strings.Split(string(b), "\r\n")
+	start := strings.SplitN(lines[0], " ", 3)
+	*o = httpMsg{length: -1}
+
+	var version string
+	if request {
+		if len(start) != 3 || start[0] == "" || start[1] == "" {
+			return fmt.Errorf("invalid request line %q", lines[0])
+		}
+		o.method = start[0]
+		o.target = start[1]
+		version = start[2]
+	} else {
+		if len(start) < 2 {
+			return fmt.Errorf("invalid status line %q", lines[0])
+		}
+		version = start[0]
+		status, err := strconv.Atoi(start[1])
+		if err != nil || status < 100 || status > 999 {
+			return fmt.Errorf("invalid status %q", start[1])
+		}
+		o.status = status
+	}
+	if !strings.HasPrefix(version, "HTTP/1.") {
+		return fmt.Errorf("unsupported version %q", version)
+	}
+
+	keepAlive := false
+	for _, l := range lines[1:] {
+		i := strings.IndexByte(l, ':')
+		if i <= 0 {
+			return fmt.Errorf("invalid header %q", l)
+		}
+		name := strings.ToLower(strings.TrimSpace(l[:i]))
+		value := strings.ToLower(strings.TrimSpace(l[i+1:]))
+		switch name {
+		case "content-length":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid content-length %q", value)
+			}
+			o.length = n
+		case "transfer-encoding":
+			// chunked must be the last coding
+			o.chunked = strings.HasSuffix(value, "chunked")
+		case "connection":
+			for _, t := range strings.Split(value, ",") {
+				switch strings.TrimSpace(t) {
+				case "close":
+					o.close = true
+				case "keep-alive":
+					keepAlive = true
+				}
+			}
+		}
+	}
+	if version == "HTTP/1.0" && !keepAlive {
+		o.close = true
+	}
+	return nil
+}
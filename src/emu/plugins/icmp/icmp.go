@@ -209,7 +209,8 @@ func (o *PluginIcmpClient) PreparePingPacketTemplate(id, seq uint16, magic uint6
 	myIPv4 := o.Client.Ipv4
 	dstIPv4 := o.pingData.Dst
 	pkt = o.Client.GetL2Header(false, uint16(layers.EthernetTypeIPv4))
-	dstMac, ok := o.Client.ResolveIPv4DGMac()
+	hash := core.RouteHashIPv4(myIPv4, dstIPv4, uint8(layers.IPProtocolICMPv4), id, 0)
+	dstMac, ok := o.Client.ResolveIPv4NextHopMac(dstIPv4, hash)
 	if ok {
 		layers.EthernetHeader(pkt).SetDestAddress(dstMac[:])
 	}
@@ -267,6 +268,11 @@ func NewIcmpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginIcmpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIcmpNs) OnRemove(ctx *core.PluginCtx) {
 }
 
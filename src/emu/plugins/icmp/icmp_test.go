@@ -326,6 +326,50 @@ func TestPluginIcmp3(t *testing.T) {
 	a.Run(t, true)
 }
 
+type IcmpRouteCtxRpc struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+}
+
+func (o *IcmpRouteCtxRpc) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+		"method":"ctx_route_add",
+		"params": {"tun": {"vport":1,"tci":[1,2]},
+			"routes": [{"prefix": [48, 0, 0, 0], "prefix_len": 8, "next_hops": [{"via": [16, 0, 0, 9], "mac": [0, 0, 5, 0, 0, 1]}]}]},
+		"id": 3}`))
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+		"method":"ctx_route_iter",
+		"params": {"tun": {"vport":1,"tci":[1,2]}, "reset": true, "count": 10},
+		"id": 4}`))
+	o.tctx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+		"method":"icmp_c_start_ping",
+		"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0, 0, 1, 0, 0, 0], "amount": 3, "pace": 1, "dst": [48, 0, 0, 1], "payloadSize": 20},
+		"id": 5}`))
+}
+
+func rpcRoute(tctx *core.CThreadCtx, test *IcmpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	var tstctx IcmpRouteCtxRpc
+	tstctx.timer.SetCB(&tstctx, nil, nil)
+	tstctx.tctx = tctx
+	timerw.Start(&tstctx.timer, 2*time.Second)
+	return 0
+}
+
+/*TestPluginIcmpRoute - ping a destination with a route, the echo requests are sent to the next hop mac */
+func TestPluginIcmpRoute(t *testing.T) {
+	a := &IcmpTestBase{
+		testname:     "icmp_route",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		cb:           rpcRoute,
+	}
+	a.Run(t, true)
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -839,6 +839,11 @@ func (o *PluginIgmpNs) preparePacketTemplate() {
 	o.ipv4pktTemplate = append(l2, igmpHeader...)
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginIgmpNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIgmpNs) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
@@ -1008,6 +1008,17 @@ func NewIPFixClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 		}
 	}
 
+	if !o.isIpv6 {
+		// A collector with a route doesn't wait for the default gateway, the socket resolves the next hop.
+		var dst core.Ipv4Key
+		if ip := net.ParseIP(host).To4(); ip != nil {
+			copy(dst[:], ip)
+			if o.Client.LookupRouteIPv4(dst) != nil {
+				o.OnResolve()
+			}
+		}
+	}
+
 	return &o.PluginBase
 }
 
@@ -1056,6 +1067,11 @@ func (o *PluginIPFixClient) OnResolve() {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginIPFixClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // OnRemove is called when we are trying to remove this IPFix client.
 func (o *PluginIPFixClient) OnRemove(ctx *core.PluginCtx) {
 	ctx.UnregisterEvents(&o.PluginBase, ipfixEvents)
@@ -0,0 +1,240 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipv6
+
+/*
+RFC 4862 Duplicate Address Detection of the client addresses.
+
+client inijson {
+	"dad": {
+		"transmits": 1,        // DupAddrDetectTransmits, number of NS probes
+		"retrans_timer": 1000, // msec between probes
+		"optimistic": false    // RFC 4429, use the address while it is tentative
+	}
+}
+
+With dad the static (Ipv6), DHCPv6 and SLAAC (EUI-64, stable-opaque and temporary) addresses are tentative
+until `transmits` probes (NS from ::) were sent without an answer. A tentative address is not used as a source,
+it is not answered and not advertised. An optimistic address is used while it is tentative, but it is not answered.
+The unsolicited NA of the address is sent when DAD ends.
+
+A NA of another node for the address, or a probe of another node while the address is tentative, is a conflict.
+Each conflict is counted and sent as MSG_IPV6_CONFLICT. A SLAAC privacy address is generated again, any other
+address is marked duplicated and is not used until it is changed. The link-local address is not checked.
+*/
+
+import (
+	"emu/core"
+	"net"
+	"time"
+)
+
+const (
+	/* DAD state of an address */
+	dadStateTentative  = 1
+	dadStateOptimistic = 2
+	dadStatePreferred  = 3
+	dadStateDuplicated = 4
+
+	dadTransmits        = 1    // DupAddrDetectTransmits
+	dadRetransTimerMsec = 1000 // RETRANS_TIMER
+)
+
+var dadStateNames = map[uint8]string{
+	dadStateTentative:  "tentative",
+	dadStateOptimistic: "optimistic",
+	dadStatePreferred:  "preferred",
+	dadStateDuplicated: "duplicated",
+}
+
+type Ipv6NdDadInit struct {
+	Transmits    uint8  `json:"transmits"`
+	RetransTimer uint32 `json:"retrans_timer"`
+	Optimistic   bool   `json:"optimistic"`
+}
+
+type NdDadTimer struct {
+}
+
+func (o *NdDadTimer) OnEvent(a, b interface{}) {
+	d := a.(*NdDadCtx)
+	d.onTimer(b.(*ndDadAddr))
+}
+
+type ndDadAddr struct {
+	ipv6  core.Ipv6Key
+	state uint8
+	cnt   uint8 // probes that were sent
+	timer core.CHTimerObj
+}
+
+// NdDadCtx the DAD state of the addresses of a client
+type NdDadCtx struct {
+	nd          *NdClientCtx
+	client      *core.CClient
+	timerw      *core.TimerCtx
+	cfg         Ipv6NdDadInit
+	ticks       uint32
+	addrs       []*ndDadAddr
+	slaac       core.Ipv6Key // the EUI-64 SLAAC address that was checked
+	timerCb     NdDadTimer
+	conflicts   uint32
+	conflictMac core.MACKey
+}
+
+func (o *NdDadCtx) Init(nd *NdClientCtx, init *Ipv6NdDadInit) {
+	o.nd = nd
+	o.client = nd.base.Client
+	o.timerw = nd.timerw
+	o.cfg = *init
+	if o.cfg.Transmits == 0 {
+		o.cfg.Transmits = dadTransmits
+	}
+	if o.cfg.RetransTimer == 0 {
+		o.cfg.RetransTimer = dadRetransTimerMsec
+	}
+	o.ticks = o.timerw.DurationToTicks(time.Duration(o.cfg.RetransTimer) * time.Millisecond)
+}
+
+func (o *NdDadCtx) OnRemove() {
+	for len(o.addrs) > 0 {
+		o.Stop(&o.addrs[0].ipv6)
+	}
+}
+
+func (o *NdDadCtx) lookup(ipv6 *core.Ipv6Key) *ndDadAddr {
+	for _, a := range o.addrs {
+		if a.ipv6 == *ipv6 {
+			return a
+		}
+	}
+	return nil
+}
+
+// Start the address is tentative, send the first probe
+func (o *NdDadCtx) Start(ipv6 *core.Ipv6Key) {
+	o.Stop(ipv6)
+	a := &ndDadAddr{ipv6: *ipv6, state: dadStateTentative}
+	if o.cfg.Optimistic {
+		a.state = dadStateOptimistic
+	} else {
+		o.client.Ipv6Tentative = append(o.client.Ipv6Tentative, a.ipv6)
+	}
+	a.timer.SetCB(&o.timerCb, o, a)
+	o.addrs = append(o.addrs, a)
+	o.nd.nsPlug.stats.dadStart++
+	o.sendProbe(a)
+}
+
+// Stop the address was removed from the client
+func (o *NdDadCtx) Stop(ipv6 *core.Ipv6Key) {
+	for i, a := range o.addrs {
+		if a.ipv6 == *ipv6 {
+			if a.timer.IsRunning() {
+				o.timerw.Stop(&a.timer)
+			}
+			o.client.Ipv6Tentative = dadRemoveKey(o.client.Ipv6Tentative, a.ipv6)
+			o.client.Ipv6Duplicated = dadRemoveKey(o.client.Ipv6Duplicated, a.ipv6)
+			o.addrs = append(o.addrs[:i], o.addrs[i+1:]...)
+			return
+		}
+	}
+}
+
+func dadRemoveKey(keys []core.Ipv6Key, ipv6 core.Ipv6Key) []core.Ipv6Key {
+	for i, k := range keys {
+		if k == ipv6 {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+func (o *NdDadCtx) sendProbe(a *ndDadAddr) {
+	o.nd.SendNS(true, nil, &a.ipv6)
+	a.cnt++
+	o.timerw.StartTicks(&a.timer, o.ticks)
+}
+
+func (o *NdDadCtx) onTimer(a *ndDadAddr) {
+	if a.cnt < o.cfg.Transmits {
+		o.sendProbe(a)
+		return
+	}
+	/* no conflict, the address is preferred */
+	o.nd.nsPlug.stats.dadAddrPreferred++
+	if a.state == dadStateTentative {
+		o.client.Ipv6Tentative = dadRemoveKey(o.client.Ipv6Tentative, a.ipv6)
+	}
+	a.state = dadStatePreferred
+	o.nd.SendUnsolicitedNaIpv6(&a.ipv6, &a.ipv6, &o.client.Mac)
+	o.nd.AdvIPv6()
+}
+
+// OnPrefix check the EUI-64 SLAAC address of the advertised prefix, the privacy addresses are checked by NdSlaacCtx
+func (o *NdDadCtx) OnPrefix() {
+	var l6 core.Ipv6Key
+	if o.client.Ipv6SlaacOpaque || !o.client.GetIpv6Slaac(&l6) {
+		l6 = core.Ipv6Key{}
+	}
+	if l6 == o.slaac {
+		return
+	}
+	if !o.slaac.IsZero() {
+		o.Stop(&o.slaac)
+	}
+	o.slaac = l6
+	if !l6.IsZero() {
+		o.Start(&l6)
+	}
+}
+
+// OnSolicitation returns false in case a NS for the address should not be answered, a probe of another node for a
+// tentative address is a conflict
+func (o *NdDadCtx) OnSolicitation(target core.Ipv6Key, ps *core.ParserPacketState) bool {
+	a := o.lookup(&target)
+	if a == nil || a.state == dadStatePreferred {
+		return true
+	}
+	if a.state == dadStateDuplicated {
+		return false
+	}
+	p := ps.M.GetData()
+	sipv6 := net.IP(p[ps.L3+8 : ps.L3+24])
+	var mac core.MACKey
+	copy(mac[:], p[6:12])
+	if sipv6.IsUnspecified() && mac != o.client.Mac {
+		o.OnConflict(target, mac)
+	}
+	return false
+}
+
+// OnConflict another node uses the address, returns false in case DAD does not check it
+func (o *NdDadCtx) OnConflict(ipv6 core.Ipv6Key, mac core.MACKey) bool {
+	a := o.lookup(&ipv6)
+	if a == nil || a.state == dadStateDuplicated {
+		return false
+	}
+	o.nd.nsPlug.stats.dadConflict++
+	o.conflicts++
+	o.conflictMac = mac
+	o.client.PluginCtx.BroadcastMsg(&o.nd.base.PluginBase, core.MSG_IPV6_CONFLICT, ipv6, mac)
+
+	if o.nd.slaac != nil && o.nd.slaac.OnConflict(ipv6) {
+		return true // a new privacy address was generated
+	}
+
+	if a.timer.IsRunning() {
+		o.timerw.Stop(&a.timer)
+	}
+	if a.state == dadStateTentative {
+		o.client.Ipv6Tentative = dadRemoveKey(o.client.Ipv6Tentative, a.ipv6)
+	}
+	a.state = dadStateDuplicated
+	o.client.Ipv6Duplicated = append(o.client.Ipv6Duplicated, a.ipv6)
+	o.nd.nsPlug.stats.dadAddrDuplicated++
+	return true
+}
@@ -299,6 +299,11 @@ func NewIpv6Ns(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginIpv6Ns) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginIpv6Ns) OnRemove(ctx *core.PluginCtx) {
 	o.mld.OnRemove(ctx)
 	o.nd.OnRemove(ctx)
@@ -649,6 +654,18 @@ type (
 		Vec     []Ipv6NsCacheRec `json:"data"`
 	}
 
+	ApiNdCGetDadHandler struct{} // duplicate address detection state of the client addresses
+	ApiNdCGetDadResult  struct {
+		Enabled     bool            `json:"enabled"`
+		Vec         []ApiNdDadEntry `json:"data"`
+		Conflicts   uint32          `json:"conflicts"`
+		ConflictMac core.MACKey     `json:"conflict_mac"`
+	}
+	ApiNdDadEntry struct {
+		Ipv6  core.Ipv6Key `json:"ipv6"`
+		State string       `json:"state"`
+	}
+
 	ApiIpv6StartPingHandler struct {
 		Amount      uint32       `json:"amount"  validate:"ne=0"`       // Amount of echo requests to send
 		Pace        float32      `json:"pace"    validate:"ne=0"`       // Pace of sending the Echo-Requests in packets per second.
@@ -975,6 +992,26 @@ func (h ApiNdNsIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMe
 	return &res, nil
 }
 
+func (h ApiNdCGetDadHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	c, err := getClient(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	res := ApiNdCGetDadResult{Vec: []ApiNdDadEntry{}}
+	dad := c.nd.dad
+	if dad != nil {
+		res.Enabled = true
+		res.Conflicts = dad.conflicts
+		res.ConflictMac = dad.conflictMac
+		for _, a := range dad.addrs {
+			res.Vec = append(res.Vec, ApiNdDadEntry{Ipv6: a.ipv6, State: dadStateNames[a.state]})
+		}
+	}
+	return &res, nil
+}
+
 /* ServeJSONRPC for ApiIpv6StartPingHandler starts a Ping instance.
 Returns True if it successfully started the ping, else False. */
 func (h ApiIpv6StartPingHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
@@ -987,9 +1024,11 @@ func (h ApiIpv6StartPingHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	}
 
 	dgIpv6, dgOk := c.Client.ResolveDGIPv6()
+	// the source is zero in case DAD did not complete for all the addresses
+	src, _ := c.Client.GetSourceIPv6()
 
 	p := ApiIpv6StartPingHandler{Amount: ping.DefaultPingAmount, Pace: ping.DefaultPingPace, Dst: dgIpv6,
-		Src: c.Client.ResolveSourceIPv6(), Timeout: ping.DefaultPingTimeout, PayloadSize: ping.DefaultPingPayloadSize}
+		Src: src, Timeout: ping.DefaultPingTimeout, PayloadSize: ping.DefaultPingPayloadSize}
 
 	err1 := tctx.UnmarshalValidate(*params, &p)
 	if err1 != nil {
@@ -1004,7 +1043,13 @@ func (h ApiIpv6StartPingHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 			Message: "Destination address not provided and default gateway not resolved/set.",
 		}
 	}
-	ok := c.Client.OwnsIPv6(p.Src)
+	if p.Src.IsZero() {
+		return false, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "No usable source IPv6 for this client.",
+		}
+	}
+	ok := c.Client.OwnsIPv6(p.Src) && c.Client.IsIpv6Usable(p.Src)
 	if !ok {
 		return ok, &jsonrpc.Error{
 			Code:    jsonrpc.ErrorCodeInvalidRequest,
@@ -1083,6 +1128,7 @@ func init() {
 	core.RegisterCB("ipv6_mld_ns_get_cfg", ApiMldGetHandler{}, false)          // mld Get
 	core.RegisterCB("ipv6_mld_ns_set_cfg", ApiMldSetHandler{}, false)          // mld Set
 	core.RegisterCB("ipv6_nd_ns_iter", ApiNdNsIterHandler{}, false)            // nd ipv6 cache table iterator
+	core.RegisterCB("ipv6_nd_c_get_dad", ApiNdCGetDadHandler{}, true)          // dad state of the client addresses
 	core.RegisterCB("ipv6_start_ping", ApiIpv6StartPingHandler{}, true)        // start ping
 	core.RegisterCB("ipv6_stop_ping", ApiIpv6StopPingHandler{}, true)          // stop ping
 	core.RegisterCB("ipv6_get_ping_stats", ApiIpv6GetPingStatsHandler{}, true) // get ping stats
@@ -8,6 +8,7 @@ package ipv6
 import (
 	"emu/core"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"external/google/gopacket"
 	"external/google/gopacket/layers"
@@ -17,6 +18,8 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"github.com/intel-go/fastjson"
 )
 
 var monitor int
@@ -33,6 +36,9 @@ type IcmpTestBase struct {
 	cb           IcmpTestCb
 	cbArg1       interface{}
 	cbArg2       interface{}
+	nsInit       []byte
+	cInit        []byte
+	check        func(tctx *core.CThreadCtx, t *testing.T)
 }
 
 type IcmpTestCb func(tctx *core.CThreadCtx, test *IcmpTestBase) int
@@ -76,6 +82,9 @@ func (o *IcmpTestBase) Run(t *testing.T, compare bool) {
 	if compare {
 		tctx.SimRecordCompare(o.testname, t)
 	}
+	if o.check != nil {
+		o.check(tctx, t)
+	}
 }
 
 func createSimulationEnv(simRx *core.VethIFSim, num int, mcSim int, test *IcmpTestBase) (*core.CThreadCtx, *core.CClient) {
@@ -105,10 +114,16 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, mcSim int, test *IcmpTe
 				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
 		}
 		ns.AddClient(client)
-		if mcSim > 0 || test.flush > 0 {
+		if test.nsInit != nil {
+			ns.PluginCtx.CreatePlugins([]string{"ipv6"}, [][]byte{test.nsInit})
+		} else if mcSim > 0 || test.flush > 0 {
 			ns.PluginCtx.CreatePlugins([]string{"ipv6"}, [][]byte{[]byte(`{"dmac" :[0, 0, 1, 0, 0, 0]  } `)})
 		}
-		client.PluginCtx.CreatePlugins([]string{"ipv6"}, [][]byte{})
+		if test.cInit != nil {
+			client.PluginCtx.CreatePlugins([]string{"ipv6"}, [][]byte{test.cInit})
+		} else {
+			client.PluginCtx.CreatePlugins([]string{"ipv6"}, [][]byte{})
+		}
 	}
 	tctx.RegisterParserCb("icmpv6")
 
@@ -137,6 +152,13 @@ type VethIcmpSim struct {
 }
 
 func (o *VethIcmpSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	if o.match == 8 {
+		// reflect the router advertisements to the host side of the namespace
+		p := m.GetData()
+		if len(p) > 62 && p[62] == layers.ICMPv6TypeRouterAdvertisement {
+			return m
+		}
+	}
 	m.FreeMbuf()
 	return nil
 }
@@ -153,6 +175,7 @@ func (o *IcmpQueryCtx) OnEvent(a, b interface{}) {
 	opts := gopacket.SerializeOptions{FixLengths: false, ComputeChecksums: false}
 
 	var raw []byte
+	repeat := 1
 
 	switch o.match {
 	case 0:
@@ -584,11 +607,72 @@ func (o *IcmpQueryCtx) OnEvent(a, b interface{}) {
 		binary.BigEndian.PutUint16(pkt[icmppyof+2:icmppyof+4], cs)
 		raw = pkt
 
+	case 8:
+		// router solicitation and neighbor solicitation of the router link-local address
+		var icmp gopacket.SerializableLayer
+		var icmpOpt gopacket.SerializableLayer
+		dst := net.IP{0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02}
+		dmac := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 2}
+		if o.cnt%2 == 0 {
+			repeat = 2 // the second solicitation gets a delayed advertisement
+			icmp = &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterSolicitation, 0)}
+			icmpOpt = &layers.ICMPv6RouterSolicitation{}
+		} else {
+			dst = net.IP{0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xff, 0x00, 0x00, 0x01}
+			dmac = net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 1}
+			icmp = &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)}
+			icmpOpt = &layers.ICMPv6NeighborSolicitation{
+				TargetAddress: net.IP{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0xff, 0xfe, 0x03, 0x00, 0x01},
+			}
+		}
+		gopacket.SerializeLayers(buf, opts,
+			&layers.Ethernet{
+				SrcMAC:       net.HardwareAddr{0, 0, 0, 2, 0, 0},
+				DstMAC:       dmac,
+				EthernetType: layers.EthernetTypeDot1Q,
+			},
+			&layers.Dot1Q{
+				Priority:       uint8(0),
+				VLANIdentifier: uint16(1),
+				Type:           layers.EthernetTypeDot1Q,
+			},
+			&layers.Dot1Q{
+				Priority:       uint8(0),
+				VLANIdentifier: uint16(2),
+				Type:           layers.EthernetTypeIPv6,
+			},
+
+			&layers.IPv6{
+				Version:      6,
+				TrafficClass: 0,
+				FlowLabel:    0,
+				Length:       8,
+				NextHeader:   layers.IPProtocolICMPv6,
+				HopLimit:     255,
+				SrcIP:        net.IP{0xfe, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0xff, 0xfe, 0x02, 0x00, 0x00},
+				DstIP:        dst,
+			},
+			icmp,
+			icmpOpt,
+			gopacket.Payload([]byte{0x01, 0x01, 0x0, 0x00, 0x0, 0x2, 0x00, 0x00}),
+		)
+
+		pkt := buf.Bytes()
+		off := 14 + 8
+		icmppyof := off + 40
+
+		ipv6 := layers.IPv6Header(pkt[off : off+40])
+		ipv6.SetPyloadLength(uint16(len(pkt) - off - 40))
+
+		binary.BigEndian.PutUint16(pkt[icmppyof+2:icmppyof+4], 0)
+		cs := layers.PktChecksumTcpUdpV6(pkt[icmppyof:], 0, ipv6, 0, 58)
+		binary.BigEndian.PutUint16(pkt[icmppyof+2:icmppyof+4], cs)
+		raw = pkt
 	}
 
 	o.cnt += 1
 
-	if len(raw) > 0 {
+	for i := 0; i < repeat && len(raw) > 0; i++ {
 		m := o.tctx.MPool.Alloc(uint16(256))
 		m.SetVPort(1)
 		m.Append(raw)
@@ -1330,6 +1414,442 @@ func TestPluginPing(t *testing.T) {
 	a.Run(t, true) // the timestamp making a new json due to the timestamp. skip the it
 }
 
+const ndRouterInit = `{"router": {"mac": [0, 0, 0, 3, 0, 1], "interval": 30, "managed": true, "other": true,
+	"preference": "high", "mtu": 1400,
+	"prefixes": [{"prefix": [32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 64},
+	             {"prefix": [32, 1, 13, 184, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0], "prefix_len": 48, "autonomous": false,
+	              "valid_lifetime": 3600, "preferred_lifetime": 1800}],
+	"rdnss": [[32, 1, 13, 184, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 83]],
+	"dnssl": ["example.com", "lab.example.com."]}}`
+
+// router mode, solicited/periodic advertisements are reflected to the host side of the namespace
+func TestPluginNd_router1(t *testing.T) {
+	a := &IcmpTestBase{
+		testname:     "ipv6nd_router1",
+		monitor:      false,
+		match:        8,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           Cb4,
+		nsInit:       []byte(ndRouterInit),
+		check: func(tctx *core.CThreadCtx, t *testing.T) {
+			var key core.CTunnelKey
+			key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+			ns := tctx.GetNs(&key)
+			nd := &ns.PluginCtx.Get(IPV6_PLUG).Ext.(*PluginIpv6Ns).nd
+			if nd.stats.pktTxRouterAdvertisement != 4 || nd.stats.pktTxRouterAdvSolicited == 0 ||
+				nd.stats.routerAdvSolicitedDelayed == 0 || nd.stats.pktTxNeighborAdvUnicast != 3 {
+				t.Fatalf(" unexpected router counters %+v", nd.stats)
+			}
+			if nd.routerAd.MTU != 1400 || nd.routerAd.PrefixLen != 64 {
+				t.Fatalf(" host side did not learn the advertisement %+v", nd.routerAd)
+			}
+			c := ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+			var l6 core.Ipv6Key
+			if !c.GetIpv6Slaac(&l6) || l6.ToIP().String() != "2001:db8:1:0:200:1ff:fe00:0" {
+				t.Fatalf(" wrong slaac address %v", l6.ToIP())
+			}
+		},
+	}
+	a.Run(t, true)
+}
+
+func TestPluginNdRouterOptions(t *testing.T) {
+	var simVeth VethIcmpSim
+	var simrx core.VethIFSim = &simVeth
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var init Ipv6NdNsInit
+	if err := fastjson.Unmarshal([]byte(ndRouterInit), &init); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Ipv6RouterInit{Interval: routerAdvIntervalSec, HopLimit: routerAdvHopLimit, Preference: "medium"}
+	if err := tctx.UnmarshalValidate(*init.Router, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	opt, err := routerBuildOptions(&cfg, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "0101000000030001" +
+		"0501000000000578" +
+		"030440c000278d0000093a800000000020010db8000100000000000000000000" +
+		"0304308000000e10000007080000000020010db8000200000000000000000000" +
+		"190300000000005a20010db8000100000000000000000053" +
+		"1f0500000000005a076578616d706c6503636f6d00036c6162076578616d706c6503636f6d000000"
+	if hex.EncodeToString(opt) != exp {
+		t.Fatalf(" wrong options\n%v\n%v", hex.EncodeToString(opt), exp)
+	}
+
+	cfg.Dnssl = []string{"a..b"}
+	if _, err := routerBuildOptions(&cfg, 90); err == nil {
+		t.Fatalf(" invalid domain should fail")
+	}
+	if tctx.UnmarshalValidate([]byte(`{"mac": [0, 0, 0, 3, 0, 1], "preference": "top"}`), &cfg) == nil {
+		t.Fatalf(" invalid preference should fail")
+	}
+}
+
+// slaacConflictCtx another node advertises the stable (or the preferred temporary) address of the client
+type slaacConflictCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+	temp  bool
+}
+
+func (o *slaacConflictCtx) OnEvent(a, b interface{}) {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	c := o.tctx.GetNs(&key).CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+	target := c.Ipv6SlaacStable
+	if o.temp {
+		target, _ = c.GetIpv6SlaacTemp()
+	}
+
+	ndInjectConflict(o.tctx, false, target)
+}
+
+// ndInjectConflict another node (00:00:00:02:00:00) advertises target, or probes it by a NS from :: (DAD)
+func ndInjectConflict(tctx *core.CThreadCtx, ns bool, target core.Ipv6Key) {
+	var mcipv6 core.Ipv6Key
+	IPv6SolicitationMcAddr(&target, &mcipv6)
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 2, 0, 0},
+		DstMAC:       net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1},
+		EthernetType: layers.EthernetTypeDot1Q,
+	}
+	ip := &layers.IPv6{
+		Version:      6,
+		TrafficClass: 0,
+		FlowLabel:    0,
+		Length:       8,
+		NextHeader:   layers.IPProtocolICMPv6,
+		HopLimit:     255,
+		SrcIP:        target.ToIP(),
+		DstIP:        net.IP{0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01},
+	}
+	var nd []gopacket.SerializableLayer
+	if ns {
+		eth.DstMAC = net.HardwareAddr{0x33, 0x33, mcipv6[12], mcipv6[13], mcipv6[14], mcipv6[15]}
+		ip.SrcIP = net.IPv6unspecified
+		ip.DstIP = mcipv6.ToIP()
+		nd = []gopacket.SerializableLayer{
+			&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)},
+			&layers.ICMPv6NeighborSolicitation{TargetAddress: target.ToIP()},
+		}
+	} else {
+		nd = []gopacket.SerializableLayer{
+			&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)},
+			&layers.ICMPv6NeighborAdvertisement{
+				Flags:         0x20,
+				TargetAddress: target.ToIP(),
+			},
+			gopacket.Payload([]byte{0x02, 0x01, 0x0, 0x00, 0x0, 0x2, 0x00, 0x00}),
+		}
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: false, ComputeChecksums: false}
+	gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth,
+		&layers.Dot1Q{
+			Priority:       uint8(0),
+			VLANIdentifier: uint16(1),
+			Type:           layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{
+			Priority:       uint8(0),
+			VLANIdentifier: uint16(2),
+			Type:           layers.EthernetTypeIPv6,
+		},
+		ip}, nd...)...,
+	)
+
+	pkt := buf.Bytes()
+	off := 14 + 8
+	icmppyof := off + 40
+
+	ipv6 := layers.IPv6Header(pkt[off : off+40])
+	ipv6.SetPyloadLength(uint16(len(pkt) - off - 40))
+
+	binary.BigEndian.PutUint16(pkt[icmppyof+2:icmppyof+4], 0)
+	cs := layers.PktChecksumTcpUdpV6(pkt[icmppyof:], 0, ipv6, 0, 58)
+	binary.BigEndian.PutUint16(pkt[icmppyof+2:icmppyof+4], cs)
+
+	m := tctx.MPool.Alloc(uint16(256))
+	m.SetVPort(1)
+	m.Append(pkt)
+	tctx.Veth.OnRx(m)
+}
+
+func slaacConflictCb(tctx *core.CThreadCtx, test *IcmpTestBase) int {
+	Cb4(tctx, test)
+	var conflict slaacConflictCtx
+	conflict.tctx = tctx
+	conflict.temp = test.cbArg1.(bool)
+	conflict.timer.SetCB(&conflict, nil, nil)
+	timerw := tctx.GetTimerCtx()
+	ticks := timerw.DurationToTicks(test.cbArg2.(time.Duration))
+	timerw.StartTicks(&conflict.timer, ticks)
+	return 0
+}
+
+func slaacGetNd(tctx *core.CThreadCtx) (*NdNsCtx, *core.CClient) {
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1, Vlans: [2]uint32{0x81000001, 0x81000002}})
+	ns := tctx.GetNs(&key)
+	nd := &ns.PluginCtx.Get(IPV6_PLUG).Ext.(*PluginIpv6Ns).nd
+	return nd, ns.CLookupByMac(&core.MACKey{0, 0, 1, 0, 0, 0})
+}
+
+// RFC 7217 stable-opaque address, a conflict generates the address of the next DAD counter
+func TestPluginNd_slaac1(t *testing.T) {
+	a := &IcmpTestBase{
+		testname:     "ipv6nd_slaac1",
+		monitor:      false,
+		match:        4,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           slaacConflictCb,
+		cbArg1:       false,
+		cbArg2:       5 * time.Second,
+		flush:        1,
+		cInit:        []byte(`{"slaac": {"stable_secret": "trex-secret"}}`),
+		check: func(tctx *core.CThreadCtx, t *testing.T) {
+			nd, c := slaacGetNd(tctx)
+			if nd.stats.slaacStableAdd != 2 || nd.stats.slaacDadConflict != 1 || nd.stats.slaacIdGenErr != 0 {
+				t.Fatalf(" unexpected slaac counters %+v", nd.stats)
+			}
+			exp := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x01}
+			copy(exp[8:], slaacStableIid(&exp, &c.Mac, 1, []byte("trex-secret")))
+			var l6 core.Ipv6Key
+			if !c.GetIpv6Slaac(&l6) || l6 != exp || c.Ns.CLookupByIPv6(&l6) != c {
+				t.Fatalf(" wrong stable address %v expected %v", l6.ToIP(), exp.ToIP())
+			}
+			if src, _ := c.GetSourceIPv6(); src != c.Ipv6 {
+				t.Fatalf(" wrong source address %v", src.ToIP())
+			}
+		},
+	}
+	a.Run(t, true)
+}
+
+// RFC 4941 temporary addresses are regenerated, deprecated and expired by their lifetimes
+func TestPluginNd_slaac2(t *testing.T) {
+	a := &IcmpTestBase{
+		testname:     "ipv6nd_slaac2",
+		monitor:      false,
+		match:        4,
+		capture:      false,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           slaacConflictCb,
+		cbArg1:       true,
+		cbArg2:       50 * time.Second,
+		flush:        1,
+		cInit: []byte(`{"slaac": {"temporary": true, "temp_preferred_lifetime": 20, "temp_valid_lifetime": 40,
+		                           "regen_advance": 5}}`),
+		check: func(tctx *core.CThreadCtx, t *testing.T) {
+			nd, c := slaacGetNd(tctx)
+			if nd.stats.slaacTempAdd != 6 || nd.stats.slaacTempExpired != 2 || nd.stats.slaacDadConflict != 1 {
+				t.Fatalf(" unexpected slaac counters %+v", nd.stats)
+			}
+			info := c.GetInfo()
+			if len(info.Ipv6SlaacTemp) != 3 || info.Ipv6SlaacTemp[0] == info.Ipv6SlaacTemp[1] ||
+				info.Ipv6SlaacTemp[1] == info.Ipv6SlaacTemp[2] {
+				t.Fatalf(" wrong temporary addresses %v", info.Ipv6SlaacTemp)
+			}
+			for _, a := range info.Ipv6SlaacTemp {
+				if a.ToIP().Mask(net.CIDRMask(64, 128)).String() != "2001:db8:0:1::" || a[8]&0x2 != 0 ||
+					(a[11] == 0xff && a[12] == 0xfe) || !c.OwnsIPv6(a) || c.Ns.CLookupByIPv6(&a) != c {
+					t.Fatalf(" wrong temporary address %v", a.ToIP())
+				}
+			}
+			var l6 core.Ipv6Key
+			if !c.GetIpv6Slaac(&l6) || l6.ToIP().String() != "2001:db8:0:1:200:1ff:fe00:0" {
+				t.Fatalf(" wrong slaac address %v", l6.ToIP())
+			}
+			c.Ipv6 = core.Ipv6Key{}
+			if src, _ := c.GetSourceIPv6(); src != info.Ipv6SlaacTemp[2] {
+				t.Fatalf(" source address %v is not the preferred temporary address", src.ToIP())
+			}
+		},
+	}
+	a.Run(t, false)
+}
+
+// ndTimedEvent runs fn at the time at of the simulation
+type ndTimedEvent struct {
+	at time.Duration
+	fn func(c *core.CClient)
+}
+
+type ndTimedCtx struct {
+	tctx   *core.CThreadCtx
+	timer  core.CHTimerObj
+	events []ndTimedEvent
+	last   time.Duration
+}
+
+func (o *ndTimedCtx) OnEvent(a, b interface{}) {
+	_, c := slaacGetNd(o.tctx)
+	e := o.events[0]
+	o.events = o.events[1:]
+	e.fn(c)
+	o.last = e.at
+	o.start()
+}
+
+func (o *ndTimedCtx) start() {
+	if len(o.events) > 0 {
+		timerw := o.tctx.GetTimerCtx()
+		timerw.StartTicks(&o.timer, timerw.DurationToTicks(o.events[0].at-o.last))
+	}
+}
+
+func dadEventsCb(tctx *core.CThreadCtx, test *IcmpTestBase) int {
+	Cb4(tctx, test)
+	ctx := &ndTimedCtx{tctx: tctx, events: test.cbArg1.([]ndTimedEvent)}
+	ctx.timer.SetCB(ctx, nil, nil)
+	ctx.start()
+	return 0
+}
+
+// DAD of the static and the EUI-64 SLAAC addresses, a probe for the tentative SLAAC address and an advertisement
+// of the static address make them duplicated
+func TestPluginNd_dad1(t *testing.T) {
+	eui := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x01, 0x02, 0x00, 0x01, 0xff, 0xfe, 0x00, 0x00, 0x00}
+	static := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x02}
+	a := &IcmpTestBase{
+		testname:     "ipv6nd_dad1",
+		monitor:      false,
+		match:        4,
+		capture:      true,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           dadEventsCb,
+		cbArg1: []ndTimedEvent{
+			{200 * time.Millisecond, func(c *core.CClient) {
+				// the static address is tentative, there is no source for the ping
+				c.Ns.ThreadCtx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+				"method":"ipv6_start_ping",
+				"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,0], "dst": [32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1] }, "id": 2 }`))
+			}},
+			{1300 * time.Millisecond, func(c *core.CClient) { ndInjectConflict(c.Ns.ThreadCtx, true, eui) }},
+			{5 * time.Second, func(c *core.CClient) { ndInjectConflict(c.Ns.ThreadCtx, false, static) }},
+			{10 * time.Second, func(c *core.CClient) {
+				c.Ns.ThreadCtx.Veth.AppendSimuationRPC([]byte(`{"jsonrpc": "2.0",
+				"method":"ipv6_nd_c_get_dad",
+				"params": {"tun": {"vport":1,"tci":[1,2]}, "mac": [0,0,1,0,0,0] }, "id": 3 }`))
+			}},
+		},
+		flush: 1,
+		cInit: []byte(`{"dad": {"transmits": 2, "retrans_timer": 500}}`),
+		check: func(tctx *core.CThreadCtx, t *testing.T) {
+			nd, c := slaacGetNd(tctx)
+			if nd.stats.dadStart != 2 || nd.stats.dadAddrPreferred != 1 || nd.stats.dadConflict != 2 ||
+				nd.stats.dadAddrDuplicated != 2 {
+				t.Fatalf(" unexpected dad counters %+v", nd.stats)
+			}
+			info := c.GetInfo()
+			if len(info.Ipv6Tentative) != 0 || len(info.Ipv6Duplicated) != 2 ||
+				info.Ipv6Duplicated[0] != eui || info.Ipv6Duplicated[1] != static {
+				t.Fatalf(" wrong dad state tentative %v duplicated %v", info.Ipv6Tentative, info.Ipv6Duplicated)
+			}
+			if _, err := c.GetSourceIPv6(); err == nil {
+				t.Fatalf(" duplicated address is used as a source")
+			}
+		},
+	}
+	a.Run(t, true)
+}
+
+// optimistic DAD, of the static, EUI-64 SLAAC, temporary and DHCPv6 addresses
+func TestPluginNd_dad2(t *testing.T) {
+	dhcp := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x00}
+	var src core.Ipv6Key
+	var tentative int
+	var conflicts uint32
+	a := &IcmpTestBase{
+		testname:     "ipv6nd_dad2",
+		monitor:      false,
+		match:        4,
+		capture:      false,
+		duration:     1 * time.Minute,
+		clientsToSim: 1,
+		cb:           dadEventsCb,
+		cbArg1: []ndTimedEvent{
+			{1300 * time.Millisecond, func(c *core.CClient) {
+				src, _ = c.GetSourceIPv6()
+				tentative = len(c.Ipv6Tentative)
+			}},
+			{1500 * time.Millisecond, func(c *core.CClient) {
+				temp, _ := c.GetIpv6SlaacTemp()
+				ndInjectConflict(c.Ns.ThreadCtx, false, temp)
+			}},
+			{2 * time.Second, func(c *core.CClient) { c.UpdateDIPv6(dhcp) }},
+			{10 * time.Second, func(c *core.CClient) {
+				conflicts = c.PluginCtx.Get(IPV6_PLUG).Ext.(*PluginIpv6Client).nd.dad.conflicts
+			}},
+		},
+		flush: 1,
+		cInit: []byte(`{"dad": {"transmits": 3, "optimistic": true}, "slaac": {"temporary": true}}`),
+		check: func(tctx *core.CThreadCtx, t *testing.T) {
+			nd, c := slaacGetNd(tctx)
+			if src != c.Ipv6 || tentative != 0 {
+				t.Fatalf(" optimistic address is not used, source %v tentative %v", src.ToIP(), tentative)
+			}
+			if nd.stats.dadStart != 5 || nd.stats.dadAddrPreferred != 4 || nd.stats.dadConflict != 1 ||
+				nd.stats.dadAddrDuplicated != 0 || nd.stats.slaacTempAdd != 2 || conflicts != 1 {
+				t.Fatalf(" unexpected dad counters %+v", nd.stats)
+			}
+			info := c.GetInfo()
+			if len(info.Ipv6Tentative) != 0 || len(info.Ipv6Duplicated) != 0 || len(info.Ipv6SlaacTemp) != 1 {
+				t.Fatalf(" wrong dad state %+v", info)
+			}
+			if s, _ := c.GetSourceIPv6(); s != dhcp {
+				t.Fatalf(" wrong source address %v", s.ToIP())
+			}
+		},
+	}
+	a.Run(t, false)
+}
+
+func TestPluginNdSlaacIid(t *testing.T) {
+	prefix := core.Ipv6Key{0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x01}
+	mac := core.MACKey{0, 0, 1, 0, 0, 0}
+	a := hex.EncodeToString(slaacStableIid(&prefix, &mac, 0, []byte("key")))
+	if a != hex.EncodeToString(slaacStableIid(&prefix, &mac, 0, []byte("key"))) ||
+		a == hex.EncodeToString(slaacStableIid(&prefix, &mac, 1, []byte("key"))) ||
+		a == hex.EncodeToString(slaacStableIid(&prefix, &mac, 0, []byte("key2"))) {
+		t.Fatalf(" stable iid should depend only on its inputs")
+	}
+	prefix[7] = 2
+	if a == hex.EncodeToString(slaacStableIid(&prefix, &mac, 0, []byte("key"))) {
+		t.Fatalf(" stable iid should depend on the prefix")
+	}
+
+	// RFC 4941 3.2.1 by the history value
+	history := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	iid := slaacTempIid(&history, &mac)
+	if hex.EncodeToString(iid) != "190e455e39b61635" || hex.EncodeToString(history[:]) != "bdb8b5bf182bbaa3" {
+		t.Fatalf(" wrong temporary iid %x history %x", iid, history)
+	}
+
+	for _, r := range []string{"0000000000000000", "fdffffffffffff80", "fdffffffffffffff", "02005efffe000001"} {
+		b, _ := hex.DecodeString(r)
+		if !slaacIsReservedIid(b) {
+			t.Fatalf(" %v is reserved", r)
+		}
+	}
+	b, _ := hex.DecodeString("fdffffffffffff7f")
+	if slaacIsReservedIid(b) {
+		t.Fatalf(" fdffffffffffff7f is not reserved")
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -56,8 +56,10 @@ type NdCacheFlow struct {
 // then optimize it
 
 type Ipv6NdInit struct {
-	Timer        uint32 `json:"nd_timer"`
-	TimerDisable bool   `json:"nd_timer_disable"`
+	Timer        uint32               `json:"nd_timer"`
+	TimerDisable bool                 `json:"nd_timer_disable"`
+	Slaac        *fastjson.RawMessage `json:"slaac"` // privacy extensions of the SLAAC address
+	Dad          *Ipv6NdDadInit       `json:"dad"`   // duplicate address detection of the addresses
 }
 
 func covertToNdCacheFlow(dlist *core.DList) *NdCacheFlow {
@@ -112,6 +114,23 @@ type Ipv6NsStats struct {
 	pktTxNeighborUnsolicitedDAD   uint64
 	pktTxNeighborUnsolicitedQuery uint64
 
+	pktTxRouterAdvertisement  uint64
+	pktTxRouterAdvSolicited   uint64
+	routerAdvSolicitedDelayed uint64
+	routerInitErr             uint64
+
+	slaacStableAdd   uint64
+	slaacTempAdd     uint64
+	slaacTempExpired uint64
+	slaacDadConflict uint64
+	slaacIdGenErr    uint64
+	slaacInitErr     uint64
+
+	dadStart          uint64
+	dadAddrPreferred  uint64
+	dadConflict       uint64
+	dadAddrDuplicated uint64
+
 	tblActive             uint64
 	tblAdd                uint64
 	tblRemove             uint64
@@ -300,6 +319,118 @@ func NewIpv6NsStatsDb(o *Ipv6NsStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRouterAdvertisement,
+		Name:     "pktTxRouterAdvertisement",
+		Help:     "tx periodic router advertisement",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxRouterAdvSolicited,
+		Name:     "pktTxRouterAdvSolicited",
+		Help:     "tx solicited router advertisement",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.routerAdvSolicitedDelayed,
+		Name:     "routerAdvSolicitedDelayed",
+		Help:     "router solicitation answered by a delayed advertisement",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.routerInitErr,
+		Name:     "routerInitErr",
+		Help:     "invalid router configuration, router mode is disabled",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacStableAdd,
+		Name:     "slaacStableAdd",
+		Help:     "stable-opaque slaac address generated",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacTempAdd,
+		Name:     "slaacTempAdd",
+		Help:     "temporary slaac address generated",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacTempExpired,
+		Name:     "slaacTempExpired",
+		Help:     "temporary slaac address valid lifetime expired",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacDadConflict,
+		Name:     "slaacDadConflict",
+		Help:     "generated slaac address is used by another node",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacIdGenErr,
+		Name:     "slaacIdGenErr",
+		Help:     "failed to generate slaac address after retries",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.slaacInitErr,
+		Name:     "slaacInitErr",
+		Help:     "invalid slaac configuration, using EUI-64",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dadStart,
+		Name:     "dadStart",
+		Help:     "dad started, the address is tentative",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dadAddrPreferred,
+		Name:     "dadAddrPreferred",
+		Help:     "dad ended without a conflict",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dadConflict,
+		Name:     "dadConflict",
+		Help:     "dad found another node with the address",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dadAddrDuplicated,
+		Name:     "dadAddrDuplicated",
+		Help:     "address marked duplicated, not used",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.pktRxErrRouternotLinklocal,
 		Name:     "pktRxErrRouternotLinklocal",
@@ -784,22 +915,29 @@ type NdClientCtx struct {
 	timerCb          NdClientTimer
 	timerw           *core.TimerCtx
 	timerNASec       uint32
+	slaac            *NdSlaacCtx // privacy extensions, nil in case of EUI-64
+	dad              *NdDadCtx   // duplicate address detection, nil in case it is disabled
 }
 
 func (o *NdClientCtx) advIPv6SrcAddr(srcipv6 *core.Ipv6Key) {
 
 	c := o.base.Client
-	if !c.Ipv6.IsZero() {
+	if !c.Ipv6.IsZero() && c.IsIpv6Usable(c.Ipv6) {
 		o.SendNS(false, &c.Ipv6, srcipv6)
 	}
-	if !c.Dhcpv6.IsZero() {
+	if !c.Dhcpv6.IsZero() && c.IsIpv6Usable(c.Dhcpv6) {
 		o.SendNS(false, &c.Dhcpv6, srcipv6)
 	}
 
 	var l6 core.Ipv6Key
-	if c.GetIpv6Slaac(&l6) {
+	if c.GetIpv6Slaac(&l6) && c.IsIpv6Usable(l6) {
 		o.SendNS(false, &l6, srcipv6)
 	}
+	for i := range c.Ipv6SlaacTemp {
+		if c.IsIpv6Usable(c.Ipv6SlaacTemp[i]) {
+			o.SendNS(false, &c.Ipv6SlaacTemp[i], srcipv6)
+		}
+	}
 
 	c.GetIpv6LocalLink(&l6)
 	o.SendNS(false, &l6, srcipv6)
@@ -938,7 +1076,37 @@ func (o *NdClientCtx) Init(base *PluginIpv6Client,
 	o.timer.SetCB(&o.timerCb, o, 0)
 	o.timerw.Start(&o.timer, time.Duration(o.timerNASec)*time.Second)
 
+	if err == nil && init.Dad != nil {
+		o.dad = new(NdDadCtx)
+		o.dad.Init(o, init.Dad)
+	}
+
+	if err == nil && init.Slaac != nil {
+		o.slaac = new(NdSlaacCtx)
+		if o.slaac.Init(o, ctx, *init.Slaac) != nil {
+			nsPlug.stats.slaacInitErr++
+			o.slaac = nil
+		}
+	}
+
 	o.OnCreate()
+
+	if o.slaac != nil || o.dad != nil {
+		nsPlug.addPrefixClient(o)
+	}
+	if o.slaac != nil {
+		o.slaac.OnPrefix()
+	}
+}
+
+// onPrefixChange the advertised prefix was changed
+func (o *NdClientCtx) onPrefixChange() {
+	if o.slaac != nil {
+		o.slaac.OnPrefix()
+	}
+	if o.dad != nil {
+		o.dad.OnPrefix()
+	}
 }
 
 // in case of add
@@ -968,10 +1136,18 @@ func (o *NdClientCtx) OnEvent(msg string, a, b interface{}) {
 			}
 
 			o.nsPlug.stats.eventsChangeDHCPSrc++
+			if o.dad != nil {
+				o.dad.Stop(&oldIPv6)
+			}
 			if !newIPv6.IsZero() {
 				o.addMcCache(&newIPv6) // add it to MC
 				var l6 core.Ipv6Key
 				l6 = newIPv6
+				if o.dad != nil {
+					// the NA is sent when DAD ends
+					o.dad.Start(&l6)
+					return
+				}
 				// send unsolicitate message
 				pmac := &o.base.Client.Mac
 				o.SendUnsolicitedNaIpv6(&l6, nil, pmac)
@@ -988,6 +1164,9 @@ func (o *NdClientCtx) OnEvent(msg string, a, b interface{}) {
 		}
 		if newIPv6 != oldIPv6 {
 			o.nsPlug.stats.eventsChangeSrc++
+			if o.dad != nil {
+				o.dad.Stop(&oldIPv6)
+			}
 			if !newIPv6.IsZero() {
 				o.addMcCache(&newIPv6)
 				o.SendUnsolicitedNA()
@@ -1039,6 +1218,16 @@ func (o *NdClientCtx) OnRemove(ctx *core.PluginCtx) {
 		o.removeMc(&o.base.Client.Dhcpv6)
 	}
 
+	if o.slaac != nil {
+		o.slaac.OnRemove()
+	}
+	if o.dad != nil {
+		o.dad.OnRemove()
+	}
+	if o.slaac != nil || o.dad != nil {
+		o.nsPlug.removePrefixClient(o)
+	}
+
 	o.base.Client.Ipv6Router = nil
 
 	if !o.base.Client.DgIpv6.IsZero() {
@@ -1140,6 +1329,10 @@ func (o *NdClientCtx) SendNS(dad bool, sourceipv6 *core.Ipv6Key, target *core.Ip
 }
 
 func (o *NdClientCtx) SendUnsolicitedSlaac() {
+	if o.dad != nil {
+		o.dad.OnPrefix() // the NA is sent when DAD ends
+		return
+	}
 	var l6 core.Ipv6Key
 	if o.base.Client.GetIpv6Slaac(&l6) {
 		pmac := &o.base.Client.Mac
@@ -1163,6 +1356,10 @@ func (o *NdClientCtx) SendUnsolicited(linkLocal bool) {
 		l6 = o.base.Client.Ipv6
 		spl6 = &sl6
 		sl6 = o.base.Client.Ipv6
+		if o.dad != nil {
+			o.dad.Start(&l6) // the NA is sent when DAD ends
+			return
+		}
 	}
 	o.SendNS(true, spl6, &l6) // dad
 	o.SendUnsolicitedNaIpv6(&l6, spl6, pmac)
@@ -1223,6 +1420,14 @@ func (o *NdClientCtx) Respond(mac *core.MACKey, ps *core.ParserPacketState) {
 	psrc := ms.GetData()
 	sipv6 := layers.IPv6Header(psrc[ps.L3 : ps.L3+40])
 
+	if o.dad != nil {
+		var target core.Ipv6Key
+		copy(target[:], psrc[ps.L4+8:ps.L4+8+16])
+		if !o.dad.OnSolicitation(target, ps) {
+			return // tentative or duplicated
+		}
+	}
+
 	m := o.base.Ns.AllocMbuf(uint16(len(o.naPktTemplate)))
 	m.Append(o.naPktTemplate)
 	p := m.GetData()
@@ -1277,6 +1482,8 @@ type NdNsCtx struct {
 	routerAdCnt    uint32
 	timerRouterSo  core.CHTimerObj // timer to ask solicitation from the router
 	routerSoMac    core.MACKey
+	router         *NdRouterCtx   // router mode, nil in case of host
+	prefixClients  []*NdClientCtx // clients with privacy extensions or DAD, notified on a change of the prefix
 }
 
 func (o *NdNsCtx) Init(base *PluginIpv6Ns, ctx *core.CThreadCtx, initJson []byte) {
@@ -1288,6 +1495,50 @@ func (o *NdNsCtx) Init(base *PluginIpv6Ns, ctx *core.CThreadCtx, initJson []byte
 
 	o.timerRouterSo.SetCB(&o.routeAdTimerCB, o, 0) // set the callback to OnEvent
 	o.routerAdTicks = o.timerw.DurationToTicks(routeSolSec * time.Second)
+
+	var init Ipv6NdNsInit
+	err := fastjson.Unmarshal(initJson, &init)
+	if err == nil && init.Router != nil {
+		o.router = new(NdRouterCtx)
+		if o.router.Init(o, ctx, *init.Router) != nil {
+			o.stats.routerInitErr++
+			o.router = nil
+		}
+	}
+}
+
+func (o *NdNsCtx) addPrefixClient(c *NdClientCtx) {
+	o.prefixClients = append(o.prefixClients, c)
+}
+
+func (o *NdNsCtx) removePrefixClient(c *NdClientCtx) {
+	for i, e := range o.prefixClients {
+		if e == c {
+			o.prefixClients = append(o.prefixClients[:i], o.prefixClients[i+1:]...)
+			return
+		}
+	}
+}
+
+// onPrefixChange generate and check the SLAAC addresses of the new prefix
+func (o *NdNsCtx) onPrefixChange() {
+	for _, c := range o.prefixClients {
+		c.onPrefixChange()
+	}
+}
+
+// onAddrConflict a neighbor advertisement of another node for an address of the client
+func (o *NdNsCtx) onAddrConflict(client *core.CClient, ipv6 core.Ipv6Key, mac core.MACKey) {
+	cplg := client.PluginCtx.Get(IPV6_PLUG)
+	if cplg == nil {
+		return
+	}
+	cCPlug := cplg.Ext.(*PluginIpv6Client)
+	if cCPlug.nd.dad != nil {
+		cCPlug.nd.dad.OnConflict(ipv6, mac)
+	} else if cCPlug.nd.slaac != nil {
+		cCPlug.nd.slaac.OnConflict(ipv6)
+	}
 }
 
 func (o *NdNsCtx) IsRouterSolActive() bool {
@@ -1317,6 +1568,9 @@ func (o *NdNsCtx) OnRemove(ctx *core.PluginCtx) {
 	if o.timerRouterSo.IsRunning() {
 		o.timerw.Stop(&o.timerRouterSo)
 	}
+	if o.router != nil {
+		o.router.OnRemove()
+	}
 
 	o.tbl.OnRemove()
 }
@@ -1461,7 +1715,15 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 
 	case layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterSolicitation, 0):
 		o.stats.pktRxRouterSolicitation++
-		return core.PARSER_OK // nothing to do
+		if o.router == nil {
+			return core.PARSER_OK // nothing to do
+		}
+		if ipv6.HopLimit() != hoplimitmax {
+			o.stats.pktRxErrWrongHopLimit++
+			return core.PARSER_ERR
+		}
+		o.router.OnRouterSolicitation()
+		return core.PARSER_OK
 
 	case layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterAdvertisement, 0):
 		o.stats.pktRxRouterAdvertisement++
@@ -1505,6 +1767,7 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 		}
 		var prefixCnt uint8
 		prefixCnt = 0
+		oldPrefix, oldPrefixLen := o.routerAd.PrefixIpv6, o.routerAd.PrefixLen
 		copy(o.routerAd.IPv6[:], ipv6.SrcIP()[:])
 		for _, opt := range ra.Options {
 			switch opt.Type {
@@ -1542,6 +1805,9 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 			}
 
 		}
+		if oldPrefix != o.routerAd.PrefixIpv6 || oldPrefixLen != o.routerAd.PrefixLen {
+			o.onPrefixChange()
+		}
 
 	case layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0):
 		o.stats.pktRxNeighborSolicitation++
@@ -1619,6 +1885,11 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 			}
 		}
 
+		if o.router != nil && o.router.IsRouterIpv6(ra.TargetAddress) {
+			o.router.RespondNS(ps)
+			return core.PARSER_OK
+		}
+
 		global := ra.TargetAddress.IsGlobalUnicast()
 
 		if ra.TargetAddress.IsLinkLocalUnicast() || global {
@@ -1757,6 +2028,9 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 					if !ours {
 						o.stats.pktRxNeighborAdvLearn++
 						o.NdLearn(tipv6, &targetMac)
+					} else if targetMac != client.Mac {
+						o.stats.pktRxNeighborAdvWithOwnAddr++
+						o.onAddrConflict(client, tipv6, targetMac)
 					}
 
 				} else {
@@ -1769,6 +2043,7 @@ func (o *NdNsCtx) HandleRxIpv6NdPacket(ps *core.ParserPacketState, code layers.I
 						o.NdLearn(tipv6, &targetMac)
 					} else {
 						o.stats.pktRxNeighborAdvWithOwnAddr++
+						o.onAddrConflict(client, tipv6, targetMac)
 					}
 				}
 			}
@@ -0,0 +1,397 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipv6
+
+/*
+router mode of the namespace, RFC 4861 router side.
+
+The namespace sends periodic router advertisements to all-nodes and answers router solicitations with a
+solicited advertisement (rate limited by MIN_DELAY_BETWEEN_RAS). The advertisement carries the prefixes
+(RFC 4861), the MTU, the router preference (RFC 4191) and the DNS options (RFC 8106). The router answers
+neighbor solicitations for its link-local address so hosts can resolve it as the default gateway.
+
+The router is configured by the "router" object of the namespace init json, the namespace is a host without it.
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"external/google/gopacket/layers"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	routerAdvIntervalSec    = 600 // MaxRtrAdvInterval default
+	routerAdvHopLimit       = 64
+	routerAdvInitialSec     = 16 // MAX_INITIAL_RTR_ADVERT_INTERVAL
+	routerAdvInitialCnt     = 3  // MAX_INITIAL_RTR_ADVERTISEMENTS
+	routerAdvMinDelaySec    = 3  // MIN_DELAY_BETWEEN_RAS
+	routerPrefixValidSec    = 2592000
+	routerPrefixPreferedSec = 604800
+
+	icmpv6OptRdnss = 25
+	icmpv6OptDnssl = 31
+)
+
+// Ipv6NdNsInit is the init json of the namespace nd, the router is enabled by the router object
+type Ipv6NdNsInit struct {
+	Router *fastjson.RawMessage `json:"router"`
+}
+
+// Ipv6RouterPrefix a prefix information option, the flags and the lifetimes have defaults
+type Ipv6RouterPrefix struct {
+	Prefix            core.Ipv6Key `json:"prefix"`
+	PrefixLen         uint8        `json:"prefix_len" validate:"required,lte=128"`
+	OnLink            *bool        `json:"on_link"`    // default true
+	Autonomous        *bool        `json:"autonomous"` // default true
+	ValidLifetime     *uint32      `json:"valid_lifetime"`
+	PreferredLifetime *uint32      `json:"preferred_lifetime"`
+}
+
+// Ipv6RouterInit the router advertisement configuration
+type Ipv6RouterInit struct {
+	Mac           core.MACKey        `json:"mac" validate:"required"` // source MAC of the router
+	Interval      uint32             `json:"interval" validate:"gte=4,lte=1800"`
+	Lifetime      *uint16            `json:"lifetime" validate:"omitempty,lte=9000"` // default 3*interval, zero for not a default router
+	HopLimit      uint8              `json:"hop_limit"`
+	Managed       bool               `json:"managed"` // M flag, addresses by DHCPv6
+	Other         bool               `json:"other"`   // O flag, other configuration by DHCPv6
+	Preference    string             `json:"preference" validate:"oneof=high medium low"`
+	ReachableTime uint32             `json:"reachable_time"`
+	RetransTimer  uint32             `json:"retrans_timer"`
+	Mtu           uint32             `json:"mtu"` // no MTU option in case of zero
+	Prefixes      []Ipv6RouterPrefix `json:"prefixes" validate:"dive"`
+	Rdnss         []core.Ipv6Key     `json:"rdnss"`
+	RdnssLifetime *uint32            `json:"rdnss_lifetime"` // default 3*interval
+	Dnssl         []string           `json:"dnssl"`
+	DnsslLifetime *uint32            `json:"dnssl_lifetime"` // default 3*interval
+}
+
+type RouterAdvNsTimer struct {
+}
+
+func (o *RouterAdvNsTimer) OnEvent(a, b interface{}) {
+	r := a.(*NdRouterCtx)
+	if b.(bool) {
+		r.onSolicitedTimer()
+	} else {
+		r.onTimer()
+	}
+}
+
+// NdRouterCtx the router mode of a namespace
+type NdRouterCtx struct {
+	nd          *NdNsCtx
+	timerw      *core.TimerCtx
+	mac         core.MACKey
+	linkLocal   core.Ipv6Key
+	raTemplate  []byte
+	timer       core.CHTimerObj // periodic advertisements
+	timerSol    core.CHTimerObj // delayed solicited advertisement
+	timerCb     RouterAdvNsTimer
+	ticks       uint32
+	initTicks   uint32
+	minTicks    uint32
+	initCnt     uint32
+	lastTxTicks uint64
+	txValid     bool
+}
+
+// routerDnsslEncode encode the domains as DNS names, padded to 8 bytes
+func routerDnsslEncode(domains []string) ([]byte, error) {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(d, "."), ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid domain %v", d)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	return b, nil
+}
+
+// routerBuildOptions build the options of the router advertisement
+func routerBuildOptions(cfg *Ipv6RouterInit, dnsLifetime uint32) ([]byte, error) {
+	opt := []byte{uint8(layers.ICMPv6OptSourceAddress), 1}
+	opt = append(opt, cfg.Mac[:]...)
+
+	if cfg.Mtu > 0 {
+		opt = append(opt, uint8(layers.ICMPv6OptMTU), 1, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(opt[len(opt)-4:], cfg.Mtu)
+	}
+
+	for _, p := range cfg.Prefixes {
+		var flags uint8
+		if p.OnLink == nil || *p.OnLink {
+			flags |= 0x80
+		}
+		if p.Autonomous == nil || *p.Autonomous {
+			flags |= 0x40
+		}
+		valid := uint32(routerPrefixValidSec)
+		if p.ValidLifetime != nil {
+			valid = *p.ValidLifetime
+		}
+		preferred := uint32(routerPrefixPreferedSec)
+		if p.PreferredLifetime != nil {
+			preferred = *p.PreferredLifetime
+		}
+		if preferred > valid {
+			return nil, fmt.Errorf("preferred lifetime of prefix %v is bigger than the valid lifetime", p.Prefix.ToIP())
+		}
+		prefix := p.Prefix.ToIP().Mask(net.CIDRMask(int(p.PrefixLen), 128))
+
+		b := make([]byte, 32)
+		b[0] = uint8(layers.ICMPv6OptPrefixInfo)
+		b[1] = 4
+		b[2] = p.PrefixLen
+		b[3] = flags
+		binary.BigEndian.PutUint32(b[4:8], valid)
+		binary.BigEndian.PutUint32(b[8:12], preferred)
+		copy(b[16:32], prefix)
+		opt = append(opt, b...)
+	}
+
+	if len(cfg.Rdnss) > 0 {
+		lifetime := dnsLifetime
+		if cfg.RdnssLifetime != nil {
+			lifetime = *cfg.RdnssLifetime
+		}
+		b := make([]byte, 8)
+		b[0] = icmpv6OptRdnss
+		b[1] = uint8(1 + 2*len(cfg.Rdnss))
+		binary.BigEndian.PutUint32(b[4:8], lifetime)
+		for _, s := range cfg.Rdnss {
+			b = append(b, s[:]...)
+		}
+		opt = append(opt, b...)
+	}
+
+	if len(cfg.Dnssl) > 0 {
+		lifetime := dnsLifetime
+		if cfg.DnsslLifetime != nil {
+			lifetime = *cfg.DnsslLifetime
+		}
+		names, err := routerDnsslEncode(cfg.Dnssl)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, 8)
+		b[0] = icmpv6OptDnssl
+		b[1] = uint8(1 + len(names)/8)
+		binary.BigEndian.PutUint32(b[4:8], lifetime)
+		opt = append(opt, b...)
+		opt = append(opt, names...)
+	}
+	if len(opt) > 1280-40-16 {
+		return nil, fmt.Errorf("router advertisement is too big, %v bytes of options", len(opt))
+	}
+	return opt, nil
+}
+
+// Init parse the router object of the init json and start the advertisements
+func (o *NdRouterCtx) Init(nd *NdNsCtx, ctx *core.CThreadCtx, initJson []byte) error {
+	cfg := Ipv6RouterInit{Interval: routerAdvIntervalSec, HopLimit: routerAdvHopLimit, Preference: "medium"}
+	err := ctx.UnmarshalValidate(initJson, &cfg)
+	if err != nil {
+		return err
+	}
+
+	lifetime := uint16(3 * cfg.Interval)
+	if cfg.Lifetime != nil {
+		lifetime = *cfg.Lifetime
+	}
+
+	opt, err := routerBuildOptions(&cfg, 3*cfg.Interval)
+	if err != nil {
+		return err
+	}
+
+	o.nd = nd
+	o.timerw = ctx.GetTimerCtx()
+	o.mac = cfg.Mac
+	o.linkLocal = core.Ipv6Key{0xfe, 0x80, 0, 0, 0, 0, 0, 0,
+		o.mac[0] ^ 0x2, o.mac[1], o.mac[2], 0xff, 0xfe, o.mac[3], o.mac[4], o.mac[5]}
+
+	var flags uint8
+	if cfg.Managed {
+		flags |= 0x80
+	}
+	if cfg.Other {
+		flags |= 0x40
+	}
+	switch cfg.Preference {
+	case "high":
+		flags |= 0x08
+	case "low":
+		flags |= 0x18
+	}
+
+	l2 := nd.base.Ns.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	ipoffset := len(l2)
+	copy(l2[0:6], []byte{0x33, 0x33, 0, 0, 0, 1})
+	copy(l2[6:12], o.mac[:])
+
+	raHeader := core.PacketUtlBuild(
+		&layers.IPv6{
+			Version:      6,
+			TrafficClass: 0,
+			FlowLabel:    0,
+			Length:       uint16(16 + len(opt)),
+			NextHeader:   layers.IPProtocolICMPv6,
+			HopLimit:     255,
+			SrcIP:        o.linkLocal.ToIP(),
+			DstIP:        net.IPv6linklocalallnodes,
+		},
+
+		&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeRouterAdvertisement, 0)},
+	)
+	ra := make([]byte, 12)
+	ra[0] = cfg.HopLimit
+	ra[1] = flags
+	binary.BigEndian.PutUint16(ra[2:4], lifetime)
+	binary.BigEndian.PutUint32(ra[4:8], cfg.ReachableTime)
+	binary.BigEndian.PutUint32(ra[8:12], cfg.RetransTimer)
+
+	o.raTemplate = append(l2, raHeader...)
+	o.raTemplate = append(o.raTemplate, ra...)
+	o.raTemplate = append(o.raTemplate, opt...)
+	ipv6 := layers.IPv6Header(o.raTemplate[ipoffset : ipoffset+IPV6_HEADER_SIZE])
+	ipv6.SetPyloadLength(uint16(len(o.raTemplate) - ipoffset - IPV6_HEADER_SIZE))
+	ipv6.FixIcmpL4Checksum(o.raTemplate[ipoffset+IPV6_HEADER_SIZE:], 0)
+
+	o.ticks = o.timerw.DurationToTicks(time.Duration(cfg.Interval) * time.Second)
+	o.initTicks = o.ticks
+	if cfg.Interval > routerAdvInitialSec {
+		o.initTicks = o.timerw.DurationToTicks(routerAdvInitialSec * time.Second)
+	}
+	o.minTicks = o.timerw.DurationToTicks(routerAdvMinDelaySec * time.Second)
+	o.timer.SetCB(&o.timerCb, o, false)
+	o.timerSol.SetCB(&o.timerCb, o, true)
+	o.timerw.StartTicks(&o.timer, o.timerw.DurationToTicks(routeSolSec*time.Second))
+	return nil
+}
+
+func (o *NdRouterCtx) OnRemove() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	if o.timerSol.IsRunning() {
+		o.timerw.Stop(&o.timerSol)
+	}
+}
+
+// IsRouterIpv6 returns true in case of the link-local address of the router
+func (o *NdRouterCtx) IsRouterIpv6(ipv6 net.IP) bool {
+	return ipv6.Equal(o.linkLocal.ToIP())
+}
+
+func (o *NdRouterCtx) send() {
+	m := o.nd.base.Ns.AllocMbuf(uint16(len(o.raTemplate)))
+	m.Append(o.raTemplate)
+	o.lastTxTicks = o.timerw.Ticks
+	o.txValid = true
+	o.nd.base.Tctx.Veth.Send(m)
+}
+
+// onTimer periodic advertisement, the first advertisements use a shorter interval. It answers a delayed
+// solicitation as well
+func (o *NdRouterCtx) onTimer() {
+	if o.timerSol.IsRunning() {
+		o.timerw.Stop(&o.timerSol)
+	}
+	o.nd.stats.pktTxRouterAdvertisement++
+	o.send()
+	ticks := o.ticks
+	if o.initCnt < routerAdvInitialCnt {
+		o.initCnt++
+		ticks = o.initTicks
+	}
+	o.timerw.StartTicks(&o.timer, ticks)
+}
+
+func (o *NdRouterCtx) onSolicitedTimer() {
+	o.nd.stats.pktTxRouterAdvSolicited++
+	o.send()
+}
+
+// OnRouterSolicitation answer by a multicast advertisement, no more than one in MIN_DELAY_BETWEEN_RAS
+func (o *NdRouterCtx) OnRouterSolicitation() {
+	if o.timerSol.IsRunning() {
+		o.nd.stats.routerAdvSolicitedDelayed++
+		return
+	}
+	if !o.txValid || o.timerw.Ticks-o.lastTxTicks >= uint64(o.minTicks) {
+		o.onSolicitedTimer()
+		return
+	}
+	o.nd.stats.routerAdvSolicitedDelayed++
+	o.timerw.StartTicks(&o.timerSol, o.minTicks-uint32(o.timerw.Ticks-o.lastTxTicks))
+}
+
+// RespondNS answer a neighbor solicitation for the link-local address of the router
+func (o *NdRouterCtx) RespondNS(ps *core.ParserPacketState) {
+	psrc := ps.M.GetData()
+	sipv6 := layers.IPv6Header(psrc[ps.L3 : ps.L3+40])
+
+	l2 := o.nd.base.Ns.GetL2Header(false, uint16(layers.EthernetTypeIPv6))
+	ipoffset := len(l2)
+	copy(l2[6:12], o.mac[:])
+
+	flags := uint8(0xa0) // router, override
+	dst := net.IP(sipv6.SrcIP())
+	if dst.IsUnspecified() {
+		o.nd.stats.pktTxNeighborDADError++
+		dst = net.IPv6linklocalallnodes
+		copy(l2[0:6], []byte{0x33, 0x33, 0, 0, 0, 1})
+	} else {
+		o.nd.stats.pktTxNeighborAdvUnicast++
+		flags |= 0x40 // solicited
+		copy(l2[0:6], psrc[6:12])
+	}
+
+	naHeader := core.PacketUtlBuild(
+		&layers.IPv6{
+			Version:      6,
+			TrafficClass: 0,
+			FlowLabel:    0,
+			Length:       32,
+			NextHeader:   layers.IPProtocolICMPv6,
+			HopLimit:     255,
+			SrcIP:        o.linkLocal.ToIP(),
+			DstIP:        dst,
+		},
+
+		&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)},
+
+		&layers.ICMPv6NeighborAdvertisement{
+			Flags:         flags,
+			TargetAddress: o.linkLocal.ToIP(),
+		},
+	)
+	pkt := append(l2, naHeader...)
+	pkt = append(pkt, uint8(layers.ICMPv6OptTargetAddress), 1)
+	pkt = append(pkt, o.mac[:]...)
+
+	m := o.nd.base.Ns.AllocMbuf(uint16(len(pkt)))
+	m.Append(pkt)
+	p := m.GetData()
+	ipv6 := layers.IPv6Header(p[ipoffset : ipoffset+IPV6_HEADER_SIZE])
+	ipv6.SetPyloadLength(uint16(len(p) - ipoffset - IPV6_HEADER_SIZE))
+	ipv6.FixIcmpL4Checksum(p[ipoffset+IPV6_HEADER_SIZE:], 0)
+	o.nd.base.Tctx.Veth.Send(m)
+}
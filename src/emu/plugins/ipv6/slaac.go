@@ -0,0 +1,297 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ipv6
+
+/*
+SLAAC privacy extensions of the client.
+
+RFC 7217 replaces the EUI-64 interface ID of the SLAAC address by a stable-opaque one, a hash of the prefix,
+the MAC, the DAD counter and a secret key. The address is stable per prefix and does not expose the MAC.
+
+RFC 4941 adds temporary addresses with a randomized interface ID. A new address is generated regen_advance sec
+before the preferred lifetime of the current one ends, the old address is deprecated and removed at the end of
+its valid lifetime. The client uses the preferred temporary address as its source address.
+
+Each generated address is checked by DAD, a neighbor advertisement of another node for it generates a new
+address, up to IDGEN_RETRIES (TEMP_IDGEN_RETRIES) times.
+*/
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"emu/core"
+	"math/rand"
+	"time"
+)
+
+const (
+	slaacTempValidSec     = 172800 // TEMP_VALID_LIFETIME
+	slaacTempPreferredSec = 86400  // TEMP_PREFERRED_LIFETIME
+	slaacRegenAdvanceSec  = 5      // REGEN_ADVANCE
+	slaacIdGenRetries     = 3      // IDGEN_RETRIES and TEMP_IDGEN_RETRIES
+)
+
+// Ipv6SlaacInit the privacy extensions of the SLAAC address of the client
+type Ipv6SlaacInit struct {
+	StableSecret          string `json:"stable_secret"` // RFC 7217 secret key, enables the stable-opaque address
+	Temporary             bool   `json:"temporary"`     // RFC 4941 temporary addresses
+	TempValidLifetime     uint32 `json:"temp_valid_lifetime" validate:"gtefield=TempPreferredLifetime"`
+	TempPreferredLifetime uint32 `json:"temp_preferred_lifetime" validate:"gtfield=RegenAdvance"`
+	RegenAdvance          uint32 `json:"regen_advance"`
+}
+
+type NdSlaacTimer struct {
+}
+
+func (o *NdSlaacTimer) OnEvent(a, b interface{}) {
+	s := a.(*NdSlaacCtx)
+	if t, ok := b.(*ndSlaacTempAddr); ok {
+		s.onTempExpired(t)
+	} else {
+		s.onRegenTimer()
+	}
+}
+
+type ndSlaacTempAddr struct {
+	ipv6  core.Ipv6Key
+	timer core.CHTimerObj // valid lifetime
+}
+
+// NdSlaacCtx the generated SLAAC addresses of a client
+type NdSlaacCtx struct {
+	nd          *NdClientCtx
+	client      *core.CClient
+	timerw      *core.TimerCtx
+	secret      []byte
+	temporary   bool
+	prefix      core.Ipv6Key // the /64 prefix of the generated addresses
+	dadCnt      uint8        // RFC 7217 DAD_Counter
+	history     [8]byte      // RFC 4941 history value
+	temps       []*ndSlaacTempAddr
+	tempRetries uint8
+	timer       core.CHTimerObj // generate the next temporary address
+	timerCb     NdSlaacTimer
+	validTicks  uint32
+	regenTicks  uint32
+}
+
+// slaacStableIid RFC 7217 F(Prefix, Net_Iface, Network_ID, DAD_Counter, secret_key) by SHA-256, the MAC is
+// the interface and there is no network ID
+func slaacStableIid(prefix *core.Ipv6Key, mac *core.MACKey, dadCnt uint8, secret []byte) []byte {
+	h := sha256.New()
+	h.Write(prefix[0:8])
+	h.Write(mac[:])
+	h.Write([]byte{dadCnt})
+	h.Write(secret)
+	return h.Sum(nil)[0:8]
+}
+
+// slaacTempIid RFC 4941 3.2.1, MD5 of the history value and the EUI-64 interface ID. The first half is the
+// interface ID with the u bit cleared, the second half is the next history value
+func slaacTempIid(history *[8]byte, mac *core.MACKey) []byte {
+	eui := []byte{mac[0] ^ 0x2, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}
+	d := md5.Sum(append(history[:], eui...))
+	copy(history[:], d[8:16])
+	d[0] &^= 0x2
+	return d[0:8]
+}
+
+// slaacIsReservedIid RFC 5453 reserved interface IDs
+func slaacIsReservedIid(iid []byte) bool {
+	zero := true
+	for _, b := range iid {
+		if b != 0 {
+			zero = false
+		}
+	}
+	if zero {
+		return true // subnet-router anycast
+	}
+	if string(iid[0:5]) == "\x02\x00\x5e\xff\xfe" {
+		return true // proxy mobile IPv6
+	}
+	return string(iid[0:7]) == "\xfd\xff\xff\xff\xff\xff\xff" && iid[7] >= 0x80 // subnet anycast
+}
+
+// Init parse the slaac object of the init json
+func (o *NdSlaacCtx) Init(nd *NdClientCtx, ctx *core.CThreadCtx, initJson []byte) error {
+	init := Ipv6SlaacInit{TempValidLifetime: slaacTempValidSec,
+		TempPreferredLifetime: slaacTempPreferredSec,
+		RegenAdvance:          slaacRegenAdvanceSec}
+	err := ctx.UnmarshalValidate(initJson, &init)
+	if err != nil {
+		return err
+	}
+
+	o.nd = nd
+	o.client = nd.base.Client
+	o.timerw = ctx.GetTimerCtx()
+	if len(init.StableSecret) > 0 {
+		o.secret = []byte(init.StableSecret)
+		o.client.Ipv6SlaacOpaque = true
+	}
+	o.temporary = init.Temporary
+	rand.Read(o.history[:])
+	o.validTicks = o.timerw.DurationToTicks(time.Duration(init.TempValidLifetime) * time.Second)
+	o.regenTicks = o.timerw.DurationToTicks(time.Duration(init.TempPreferredLifetime-init.RegenAdvance) * time.Second)
+	o.timer.SetCB(&o.timerCb, o, nil)
+	return nil
+}
+
+func (o *NdSlaacCtx) OnRemove() {
+	o.removeAll()
+	o.client.Ipv6SlaacOpaque = false
+}
+
+// OnPrefix generate the addresses in case the advertised prefix was changed
+func (o *NdSlaacCtx) OnPrefix() {
+	var prefix core.Ipv6Key
+	r := o.client.Ipv6Router
+	if r != nil && r.PrefixLen == 64 {
+		copy(prefix[0:8], r.PrefixIpv6[0:8])
+	}
+	if prefix == o.prefix {
+		return
+	}
+	o.removeAll()
+	o.prefix = prefix
+	if prefix.IsZero() {
+		return
+	}
+	if o.secret != nil {
+		o.dadCnt = 0
+		o.genStable()
+	}
+	if o.temporary {
+		o.tempRetries = 0
+		o.genTemp()
+		o.timerw.StartTicks(&o.timer, o.regenTicks)
+	}
+}
+
+// addAddr add a generated address to the namespace, join its solicited-node group and start DAD
+func (o *NdSlaacCtx) addAddr(ipv6 *core.Ipv6Key) bool {
+	if o.client.Ns.AddClientIpv6(o.client, *ipv6) != nil {
+		return false
+	}
+	o.nd.addMcCache(ipv6)
+	if o.nd.dad != nil {
+		o.nd.dad.Start(ipv6)
+	} else {
+		o.nd.SendNS(true, nil, ipv6)
+	}
+	return true
+}
+
+func (o *NdSlaacCtx) removeAddr(ipv6 *core.Ipv6Key) {
+	o.client.Ns.RemoveClientIpv6(o.client, *ipv6)
+	o.nd.removeMc(ipv6)
+	if o.nd.dad != nil {
+		o.nd.dad.Stop(ipv6)
+	}
+}
+
+func (o *NdSlaacCtx) genStable() {
+	for ; o.dadCnt <= slaacIdGenRetries; o.dadCnt++ {
+		ipv6 := o.prefix
+		iid := slaacStableIid(&o.prefix, &o.client.Mac, o.dadCnt, o.secret)
+		if slaacIsReservedIid(iid) {
+			continue
+		}
+		copy(ipv6[8:16], iid)
+		if o.addAddr(&ipv6) {
+			o.client.Ipv6SlaacStable = ipv6
+			o.nd.nsPlug.stats.slaacStableAdd++
+			return
+		}
+	}
+	o.nd.nsPlug.stats.slaacIdGenErr++
+}
+
+func (o *NdSlaacCtx) genTemp() {
+	for ; o.tempRetries <= slaacIdGenRetries; o.tempRetries++ {
+		ipv6 := o.prefix
+		iid := slaacTempIid(&o.history, &o.client.Mac)
+		if slaacIsReservedIid(iid) {
+			continue
+		}
+		copy(ipv6[8:16], iid)
+		if o.addAddr(&ipv6) {
+			t := &ndSlaacTempAddr{ipv6: ipv6}
+			t.timer.SetCB(&o.timerCb, o, t)
+			o.timerw.StartTicks(&t.timer, o.validTicks)
+			o.temps = append(o.temps, t)
+			o.client.Ipv6SlaacTemp = append(o.client.Ipv6SlaacTemp, ipv6)
+			o.nd.nsPlug.stats.slaacTempAdd++
+			return
+		}
+	}
+	o.nd.nsPlug.stats.slaacIdGenErr++
+}
+
+func (o *NdSlaacCtx) removeTemp(t *ndSlaacTempAddr) {
+	if t.timer.IsRunning() {
+		o.timerw.Stop(&t.timer)
+	}
+	o.removeAddr(&t.ipv6)
+	for i, e := range o.temps {
+		if e == t {
+			o.temps = append(o.temps[:i], o.temps[i+1:]...)
+			o.client.Ipv6SlaacTemp = append(o.client.Ipv6SlaacTemp[:i], o.client.Ipv6SlaacTemp[i+1:]...)
+			break
+		}
+	}
+}
+
+func (o *NdSlaacCtx) removeAll() {
+	if o.timer.IsRunning() {
+		o.timerw.Stop(&o.timer)
+	}
+	for len(o.temps) > 0 {
+		o.removeTemp(o.temps[0])
+	}
+	if !o.client.Ipv6SlaacStable.IsZero() {
+		o.removeAddr(&o.client.Ipv6SlaacStable)
+		o.client.Ipv6SlaacStable = core.Ipv6Key{}
+	}
+}
+
+// onRegenTimer the preferred temporary address is deprecated, generate a new one
+func (o *NdSlaacCtx) onRegenTimer() {
+	o.tempRetries = 0
+	o.genTemp()
+	o.timerw.StartTicks(&o.timer, o.regenTicks)
+}
+
+func (o *NdSlaacCtx) onTempExpired(t *ndSlaacTempAddr) {
+	o.nd.nsPlug.stats.slaacTempExpired++
+	o.removeTemp(t)
+}
+
+// OnConflict another node advertised a generated address, returns false in case it is not a generated address
+func (o *NdSlaacCtx) OnConflict(ipv6 core.Ipv6Key) bool {
+	if !ipv6.IsZero() && ipv6 == o.client.Ipv6SlaacStable {
+		o.nd.nsPlug.stats.slaacDadConflict++
+		o.removeAddr(&ipv6)
+		o.client.Ipv6SlaacStable = core.Ipv6Key{}
+		o.dadCnt++
+		o.genStable()
+		return true
+	}
+	for i, t := range o.temps {
+		if t.ipv6 == ipv6 {
+			o.nd.nsPlug.stats.slaacDadConflict++
+			preferred := i == len(o.temps)-1
+			o.removeTemp(t)
+			if preferred {
+				o.tempRetries++
+				o.genTemp()
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -6,13 +6,20 @@
 package lldp
 
 import (
+	"bytes"
 	"emu/core"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"external/google/gopacket"
+	"external/google/gopacket/layers"
 	"flag"
+	"net"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/intel-go/fastjson"
 )
 
 var monitor int
@@ -29,6 +36,8 @@ type LldpTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	options      []byte
+	nsOptions    []byte
+	check        func(c *PluginLldpClient, t *testing.T)
 }
 
 type LldpTestCb func(tctx *core.CThreadCtx, test *LldpTestBase) int
@@ -68,6 +77,9 @@ func (o *LldpTestBase) Run(t *testing.T) {
 		t.Fatalf(" can't find plugin")
 	}
 	lldpPlug := nsplg.Ext.(*PluginLldpClient)
+	if o.check != nil {
+		o.check(lldpPlug, t)
+	}
 	lldpPlug.cdbv.Dump()
 	tctx.GetCounterDbVec().Dump()
 
@@ -90,7 +102,11 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *LldpTestBase) (*c
 		core.Ipv6Key{},
 		dg)
 	ns.AddClient(client)
-	ns.PluginCtx.CreatePlugins([]string{"lldp"}, [][]byte{})
+	if test.nsOptions == nil {
+		ns.PluginCtx.CreatePlugins([]string{"lldp"}, [][]byte{})
+	} else {
+		ns.PluginCtx.CreatePlugins([]string{"lldp"}, [][]byte{test.nsOptions})
+	}
 
 	var inijson [][]byte
 	if test.options == nil {
@@ -101,7 +117,7 @@ func createSimulationEnv(simRx *core.VethIFSim, num int, test *LldpTestBase) (*c
 
 	client.PluginCtx.CreatePlugins([]string{"lldp"}, inijson)
 	ns.Dump()
-	//tctx.RegisterParserCb("dhcp")
+	tctx.RegisterParserCb("lldp")
 
 	nsplg := ns.PluginCtx.Get(LLDP_PLUG)
 	if nsplg == nil {
@@ -162,6 +178,252 @@ func TestPluginLldp2(t *testing.T) {
 	a.Run(t)
 }
 
+// lldpTlv build a TLV of a LLDPDU
+func lldpTlv(t uint8, v ...byte) []byte {
+	h := make([]byte, 2)
+	binary.BigEndian.PutUint16(h, uint16(t)<<9|uint16(len(v)))
+	return append(h, v...)
+}
+
+// lldpDutPkt build a LLDP packet of the DUT on the vlans of the namespace
+func lldpDutPkt(dst net.HardwareAddr, tlvs ...[]byte) []byte {
+	var pdu []byte
+	for _, t := range tlvs {
+		pdu = append(pdu, t...)
+	}
+	return core.PacketUtlBuild(
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 1, 0, 0},
+			DstMAC:       dst,
+			EthernetType: layers.EthernetTypeDot1Q,
+		},
+		&layers.Dot1Q{VLANIdentifier: 1, Type: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 2, Type: layers.EthernetTypeLinkLayerDiscovery},
+		gopacket.Payload(pdu),
+	)
+}
+
+type lldpRxPkt struct {
+	sec int
+	pkt []byte
+}
+
+// LldpRxCtx inject the packets of the DUT by their time
+type LldpRxCtx struct {
+	tctx  *core.CThreadCtx
+	timer core.CHTimerObj
+	pkts  []lldpRxPkt
+	sec   int
+}
+
+func (o *LldpRxCtx) OnEvent(a, b interface{}) {
+	o.sec++
+	for len(o.pkts) > 0 && o.pkts[0].sec <= o.sec {
+		o.tctx.Veth.OnRx(genMbuf(o.tctx, o.pkts[0].pkt))
+		o.pkts = o.pkts[1:]
+	}
+	if len(o.pkts) > 0 {
+		timerw := o.tctx.GetTimerCtx()
+		timerw.StartTicks(&o.timer, timerw.DurationToTicks(time.Second))
+	}
+}
+
+func lldpRxCb(tctx *core.CThreadCtx, test *LldpTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	rxctx := &LldpRxCtx{tctx: tctx, pkts: test.cbArg1.([]lldpRxPkt)}
+	rxctx.timer.SetCB(rxctx, nil, nil)
+	timerw.StartTicks(&rxctx.timer, timerw.DurationToTicks(time.Second))
+	return 0
+}
+
+/* neighbors of the DUT, TTL aging, shutdown, a malformed packet and a unicast packet */
+func TestPluginLldp3(t *testing.T) {
+	mcast := net.HardwareAddr(lldpDefaultDestMAC)
+	nbr1 := lldpDutPkt(mcast,
+		lldpTlv(lldpTlvChassisId, 4, 0, 0, 0, 1, 0, 0),
+		lldpTlv(lldpTlvPortId, append([]byte{5}, "Gi1/0/1"...)...),
+		lldpTlv(lldpTlvTtl, 0, 10),
+		lldpTlv(lldpTlvPortDesc, []byte("uplink")...),
+		lldpTlv(lldpTlvSysName, []byte("switch1")...),
+		lldpTlv(lldpTlvSysCap, 0, 0x14, 0, 0x04),
+		lldpTlv(lldpTlvMgmtAddr, 5, 1, 10, 0, 0, 1, 2, 0, 0, 0, 1, 0),
+		lldpTlv(lldpTlvOrg, 0x00, 0x80, 0xc2, 1, 0, 100),
+		lldpTlv(lldpTlvEnd))
+	nbr2 := func(ttl byte) []byte {
+		return lldpDutPkt(mcast,
+			lldpTlv(lldpTlvChassisId, append([]byte{7}, "sw2"...)...),
+			lldpTlv(lldpTlvPortId, append([]byte{5}, "Gi1/0/2"...)...),
+			lldpTlv(lldpTlvTtl, 0, ttl),
+			lldpTlv(lldpTlvEnd))
+	}
+	bad := lldpDutPkt(mcast,
+		lldpTlv(lldpTlvChassisId, append([]byte{7}, "sw3"...)...),
+		lldpTlv(lldpTlvPortId, append([]byte{5}, "Gi1/0/3"...)...),
+		lldpTlv(lldpTlvEnd))
+	nbr4 := lldpDutPkt(net.HardwareAddr{0, 0, 1, 0, 0, 1},
+		lldpTlv(lldpTlvChassisId, append([]byte{7}, "sw4"...)...),
+		lldpTlv(lldpTlvPortId, 3, 0, 0, 0, 4, 0, 1),
+		lldpTlv(lldpTlvTtl, 0, 120),
+		lldpTlv(lldpTlvEnd))
+
+	a := &LldpTestBase{
+		testname:     "lldp3",
+		dropAll:      false,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     20 * time.Second,
+		clientsToSim: 1,
+		cb:           lldpRxCb,
+		cbArg1: []lldpRxPkt{{1, nbr1}, {2, nbr2(120)}, {3, bad}, {4, nbr1},
+			{6, nbr2(0)}, {8, nbr4}},
+		check: func(c *PluginLldpClient, t *testing.T) {
+			if c.stats.pktRx != 6 || c.stats.pktRxErr != 1 || c.stats.nbrAdd != 3 || c.stats.nbrAged != 1 ||
+				c.stats.nbrShutdown != 1 || c.stats.nbrActive != 1 {
+				t.Fatalf(" unexpected counters %+v \n", c.stats)
+			}
+			if c.IterReset() {
+				t.Fatalf(" neighbor table is empty \n")
+			}
+			nbrs, _ := c.GetNext(10)
+			if len(nbrs) != 1 || nbrs[0].ChassisId != "sw4" || nbrs[0].PortId != "00:00:00:04:00:01" ||
+				nbrs[0].RemainSec != 108 {
+				t.Fatalf(" unexpected neighbors %+v \n", nbrs)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the records of the neighbor table */
+func TestPluginLldpDecode(t *testing.T) {
+	var rec LldpNeighborRec
+	d := lldpDutPkt(net.HardwareAddr(lldpDefaultDestMAC),
+		lldpTlv(lldpTlvChassisId, 4, 0, 0, 0, 1, 0, 0),
+		lldpTlv(lldpTlvPortId, append([]byte{5}, "Gi1/0/1"...)...),
+		lldpTlv(lldpTlvTtl, 0, 120),
+		lldpTlv(lldpTlvSysName, []byte("switch1")...),
+		lldpTlv(lldpTlvSysCap, 0, 0x14, 0, 0x04),
+		lldpTlv(lldpTlvMgmtAddr, 17, 2, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 0, 0, 0, 1, 0),
+		lldpTlv(lldpTlvOrg, 0x00, 0x80, 0xc2, 1, 0, 100),
+		lldpTlv(lldpTlvEnd))
+	key, err := lldpDecode(d[22:], &rec)
+	if err != nil || key == "" {
+		t.Fatalf(" decode failed %v \n", err)
+	}
+	if rec.ChassisId != "00:00:00:01:00:00" || rec.PortId != "Gi1/0/1" || rec.Ttl != 120 ||
+		rec.SysName != "switch1" || rec.SysCap != 0x14 || rec.EnabledCap != 0x04 ||
+		len(rec.MgmtAddr) != 1 || rec.MgmtAddr[0] != "2001:db8::1" || rec.PortVlan != 100 {
+		t.Fatalf(" unexpected record %+v \n", rec)
+	}
+
+	for _, b := range [][]byte{
+		{},
+		lldpTlv(lldpTlvChassisId, 4, 0, 0, 0, 1, 0, 0),
+		append(lldpTlv(lldpTlvPortId, 5, 'a'), lldpTlv(lldpTlvEnd)...),
+		{0x02, 0x10, 4, 0},
+	} {
+		if _, err := lldpDecode(b, &rec); err == nil {
+			t.Fatalf(" malformed %v was decoded \n", b)
+		}
+	}
+}
+
+const lldpPhoneTlvs = `{"tlvs": {"port_id": "Port 1", "sys_name": "phone-", "sys_desc": "IP phone",
+	"cap": {"sys": 36, "enabled": 36}, "mgmt_addr": "10.0.0.5", "port_vlan": 10,
+	"med": {"policy": [{"app": 1, "vlan": 100, "priority": 5, "dscp": 46, "tagged": true}],
+	        "power": {"source": 1, "priority": 3, "value": 65},
+	        "inventory": {"serial": "SN", "manufacturer": "trex", "model": "phone"}}}}`
+
+const lldpPhoneEngines = `{"engines": [
+	{"engine_name": "sys_name", "engine_type": "uint",
+	 "params": {"size": 2, "offset": 0, "op": "inc", "step": 1, "min": 1, "max": 1000}},
+	{"engine_name": "policy_vlan", "engine_type": "uint",
+	 "params": {"size": 2, "offset": 0, "op": "inc", "step": 1, "min": 100, "max": 199}},
+	{"engine_name": "serial", "engine_type": "string_list",
+	 "params": {"size": 8, "offset": 0, "op": "inc", "list": ["SN000001", "SN000002"]}}]}`
+
+/* typed TLVs of an IP phone, the TLVs of the namespace engines */
+func TestPluginLldp4(t *testing.T) {
+	a := &LldpTestBase{
+		testname:     "lldp4",
+		dropAll:      false,
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     60 * time.Second,
+		clientsToSim: 1,
+		options:      []byte(lldpPhoneTlvs),
+		nsOptions:    []byte(lldpPhoneEngines),
+		check: func(c *PluginLldpClient, t *testing.T) {
+			var rec LldpNeighborRec
+			if _, err := lldpDecode(c.pktTemplate[c.l3Offset:], &rec); err != nil {
+				t.Fatalf(" decode failed %v \n", err)
+			}
+			if rec.SysName != "phone-1" || len(rec.MedPolicy) != 1 || rec.MedPolicy[0].Vlan != 100 {
+				t.Fatalf(" unexpected TLVs %+v \n", rec)
+			}
+		},
+	}
+	a.Run(t)
+}
+
+/* the engines of the namespace, each client takes the next value */
+func TestPluginLldpTlvs(t *testing.T) {
+	var init LldpInit
+	var nsInit LldpNsInit
+	json.Unmarshal([]byte(lldpPhoneTlvs), &init)
+	json.Unmarshal([]byte(lldpPhoneEngines), &nsInit)
+	var simrx core.VethIFSim = &VethIgmpSim{}
+	tctx := core.NewThreadCtx(0, 4510, true, &simrx)
+	defer tctx.Delete()
+	var eng lldpEngines
+	eng.create(tctx, nsInit.Engines)
+	if !eng.valid {
+		t.Fatalf(" invalid engines \n")
+	}
+
+	mac := []byte{0, 0, 1, 0, 0, 1}
+	for i, exp := range []struct {
+		sysName string
+		vlan    uint16
+		serial  string
+	}{{"phone-1", 100, "SN000001"}, {"phone-2", 101, "SN000002"}, {"phone-3", 102, "SN000001"}} {
+		tlvs := eng.apply(init.Tlvs)
+		d := append(lldpBuildTlvs(mac, tlvs), 0, 0)
+		var rec LldpNeighborRec
+		if _, err := lldpDecode(d, &rec); err != nil {
+			t.Fatalf(" client %d decode failed %v \n", i, err)
+		}
+		if rec.ChassisId != "00:00:01:00:00:01" || rec.PortId != "Port 1" || rec.Ttl != 120 ||
+			rec.SysName != exp.sysName || rec.SysDesc != "IP phone" || rec.SysCap != 36 ||
+			len(rec.MgmtAddr) != 1 || rec.MgmtAddr[0] != "10.0.0.5" || rec.PortVlan != 10 ||
+			len(rec.MedPolicy) != 1 || rec.MedPolicy[0] != (LldpMedPolicyT{App: 1, Vlan: exp.vlan, Priority: 5, Dscp: 46, Tagged: true}) {
+			t.Fatalf(" client %d unexpected record %+v \n", i, rec)
+		}
+		if tlvs.Med.Inventory.Serial != exp.serial || init.Tlvs.Med.Inventory.Serial != "SN" {
+			t.Fatalf(" client %d unexpected serial %v \n", i, tlvs.Med.Inventory.Serial)
+		}
+	}
+
+	// the LLDP-MED TLVs, capabilities, policy, power and inventory
+	d := lldpBuildMed(nil, init.Tlvs.Med)
+	exp, _ := hex.DecodeString("fe070012bb01003303" + "fe080012bb020140c96e" + "fe070012bb04530041" +
+		"fe060012bb08534e" + "fe080012bb0974726578" + "fe090012bb0a70686f6e65")
+	if !bytes.Equal(d, exp) {
+		t.Fatalf(" unexpected LLDP-MED TLVs %x \n", d)
+	}
+
+	eng.create(tctx, nil)
+	if !eng.valid || eng.apply(init.Tlvs).SysName != "phone-" {
+		t.Fatalf(" engines without a json \n")
+	}
+	bad := fastjson.RawMessage(`[{"engine_name": "sys_name", "engine_type": "none"}]`)
+	if eng.create(tctx, &bad); eng.valid {
+		t.Fatalf(" invalid engines were created \n")
+	}
+}
+
 func init() {
 	flag.IntVar(&monitor, "monitor", 0, "monitor")
 }
@@ -8,12 +8,15 @@ package lldp
 /*
 lldp client send every 30 sec information from initJson
 
+The LLDPDUs of the DUT are decoded to a neighbor table per client, see neighbor.go
+
 */
 
 import (
 	"emu/core"
 	"external/google/gopacket/layers"
 	"external/osamingo/jsonrpc"
+	"net"
 	"time"
 
 	"github.com/intel-go/fastjson"
@@ -32,12 +35,22 @@ type LldpOptionsT struct {
 }
 
 type LldpInit struct {
-	TimerSec uint32        `json:"timer"`
-	Options  *LldpOptionsT `json:"options"`
+	TimerSec     uint32        `json:"timer"`
+	Options      *LldpOptionsT `json:"options"`
+	Tlvs         *LldpTlvsT    `json:"tlvs"`          // typed TLVs, the raw options are added after them
+	MaxNeighbors uint16        `json:"max_neighbors"` // size of the neighbor table, default LLDP_MAX_NEIGHBORS
 }
 
 type LldpStats struct {
-	pktTx uint64
+	pktTx        uint64
+	pktRx        uint64
+	pktRxErr     uint64
+	nbrAdd       uint64
+	nbrAged      uint64
+	nbrShutdown  uint64
+	nbrTableFull uint64
+	nbrActive    uint64
+	errEngine    uint64
 }
 
 func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
@@ -51,6 +64,70 @@ func NewLldpStatsDb(o *LldpStats) *core.CCounterDb {
 		DumpZero: false,
 		Info:     core.ScINFO})
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "rx lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxErr,
+		Name:     "pktRxErr",
+		Help:     "rx malformed lldp packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrAdd,
+		Name:     "nbrAdd",
+		Help:     "new neighbors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrAged,
+		Name:     "nbrAged",
+		Help:     "neighbors removed by the TTL",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrShutdown,
+		Name:     "nbrShutdown",
+		Help:     "neighbors removed by a shutdown lldp packet",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrTableFull,
+		Name:     "nbrTableFull",
+		Help:     "new neighbors dropped, the table is full",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.nbrActive,
+		Name:     "nbrActive",
+		Help:     "neighbors in the table",
+		Unit:     "nbrs",
+		DumpZero: true,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.errEngine,
+		Name:     "errEngine",
+		Help:     "invalid field engines of the namespace",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
 	return db
 }
 
@@ -76,6 +153,12 @@ type PluginLldpClient struct {
 	timerSec    uint32
 	l3Offset    uint16
 	pktTemplate []byte
+	maxNbrs     uint16
+	nbrs        map[string]*LldpNeighbor
+	nbrHead     core.DList
+	activeIter  *core.DList
+	iterReady   bool
+	nbrTimerCb  PluginLldpNbrTimer
 }
 
 var lldpEvents = []string{}
@@ -90,6 +173,7 @@ func NewLldpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	o.RegisterEvents(ctx, lldpEvents, o) /* register events, only if exits*/
 	nsplg := o.Ns.PluginCtx.GetOrCreate(LLDP_PLUG)
 	o.lldpNsPlug = nsplg.Ext.(*PluginLldpNs)
+	o.lldpNsPlug.clients = append(o.lldpNsPlug.clients, o)
 	o.OnCreate()
 
 	return &o.PluginBase
@@ -97,15 +181,21 @@ func NewLldpClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 
 func (o *PluginLldpClient) OnCreate() {
 	o.timerw = o.Tctx.GetTimerCtx()
+	o.cdb = NewLldpStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec("lldp")
+	o.cdbv.Add(o.cdb)
 	o.preparePacketTemplate()
 	o.timerSec = 30
 	if o.init.TimerSec > 0 {
 		o.timerSec = o.init.TimerSec
 	}
+	o.maxNbrs = LLDP_MAX_NEIGHBORS
+	if o.init.MaxNeighbors > 0 {
+		o.maxNbrs = o.init.MaxNeighbors
+	}
+	o.nbrs = make(map[string]*LldpNeighbor)
+	o.nbrHead.SetSelf()
 
-	o.cdb = NewLldpStatsDb(&o.stats)
-	o.cdbv = core.NewCCounterDbVec("lldp")
-	o.cdbv.Add(o.cdb)
 	o.timer.SetCB(&o.timerCb, o, 0) // set the callback to OnEvent
 	o.SendLldp()
 }
@@ -116,15 +206,15 @@ func (o *PluginLldpClient) preparePacketTemplate() {
 	copy(l2[0:6], lldpDefaultDestMAC[:])
 	o.l3Offset = uint16(len(l2))
 
-	lldp := &layers.LinkLayerDiscovery{
-		ChassisID: layers.LLDPChassisID{layers.LLDPChassisIDSubTypeMACAddr, l2[6:12]},
-		PortID:    layers.LLDPPortID{layers.LLDPPortIDSubtypeIfaceName, []byte("1/1")},
-		TTL:       120,
+	var tlvs LldpTlvsT
+	if o.init.Tlvs != nil {
+		tlvs = *o.init.Tlvs
 	}
-
-	d := core.PacketUtlBuild(
-		lldp,
-	)
+	if !o.lldpNsPlug.engines.valid {
+		o.stats.errEngine++
+	}
+	d := lldpBuildTlvs(l2[6:12], o.lldpNsPlug.engines.apply(&tlvs))
+	d = append(d, 0, 0)
 	if (o.init.Options != nil) && (o.init.Options.RemoveDefault) {
 		d = []byte{0, 0}
 	}
@@ -149,6 +239,11 @@ func (o *PluginLldpClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginLldpClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginLldpClient) OnRemove(ctx *core.PluginCtx) {
 	/* force removing the link to the client */
 	ctx.UnregisterEvents(&o.PluginBase, lldpEvents)
@@ -156,6 +251,8 @@ func (o *PluginLldpClient) OnRemove(ctx *core.PluginCtx) {
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
+	o.removeAllNeighbors()
+	o.lldpNsPlug.removeClient(o)
 }
 
 func (o *PluginLldpClient) restartTimer(sec uint32) {
@@ -176,14 +273,19 @@ func (o *PluginLldpClient) onTimerEvent() {
 // PluginLldpNs icmp information per namespace
 type PluginLldpNs struct {
 	core.PluginBase
-	stats LldpStats
+	stats   LldpStats
+	init    LldpNsInit
+	engines lldpEngines
+	clients []*PluginLldpClient
 }
 
 func NewLldpNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 
 	o := new(PluginLldpNs)
+	fastjson.Unmarshal(initJson, &o.init)
 	o.InitPluginBase(ctx, o)
 	o.RegisterEvents(ctx, []string{}, o)
+	o.engines.create(o.Tctx, o.init.Engines)
 
 	return &o.PluginBase
 }
@@ -199,6 +301,72 @@ func (o *PluginLldpNs) SetTruncated() {
 
 }
 
+func (o *PluginLldpNs) removeClient(c *PluginLldpClient) {
+	for i, s := range o.clients {
+		if s == c {
+			o.clients = append(o.clients[:i], o.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+// HandleRxLldpPacket decode the LLDPDU once and update the neighbor table of the clients, all the clients for a
+// multicast destination
+func (o *PluginLldpNs) HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	p := ps.M.GetData()
+	var mackey core.MACKey
+	copy(mackey[:], p[0:6])
+
+	clients := o.clients
+	if !mackey.IsMulticast() {
+		client := o.Ns.CLookupByMac(&mackey)
+		if client == nil {
+			return core.PARSER_ERR
+		}
+		cplg := client.PluginCtx.Get(LLDP_PLUG)
+		if cplg == nil {
+			return core.PARSER_ERR
+		}
+		clients = []*PluginLldpClient{cplg.Ext.(*PluginLldpClient)}
+	}
+
+	var rec LldpNeighborRec
+	rec.SrcMac = net.HardwareAddr(p[6:12]).String()
+	key, err := lldpDecode(p[ps.L3:], &rec)
+	for _, c := range clients {
+		if string(c.Client.Mac[:]) == string(p[6:12]) {
+			continue // our own packet
+		}
+		c.stats.pktRx++
+		if err != nil {
+			c.stats.pktRxErr++
+			continue
+		}
+		r := rec
+		r.MgmtAddr = append([]string(nil), rec.MgmtAddr...)
+		r.MedPolicy = append([]LldpMedPolicyT(nil), rec.MedPolicy...)
+		c.onRxNeighbor(key, &r)
+	}
+	if err != nil {
+		return core.PARSER_ERR
+	}
+	return 0
+}
+
+// HandleRxLldpPacket Parser call this function with mbuf from the pool
+func HandleRxLldpPacket(ps *core.ParserPacketState) int {
+	ns := ps.Tctx.GetNs(ps.Tun)
+	if ns == nil {
+		return core.PARSER_ERR
+	}
+	nsplg := ns.PluginCtx.Get(LLDP_PLUG)
+	if nsplg == nil {
+		return core.PARSER_ERR
+	}
+	lldpPlug := nsplg.Ext.(*PluginLldpNs)
+	return lldpPlug.HandleRxLldpPacket(ps)
+}
+
 // Tx side client get an event and decide to act !
 // let's see how it works and add some tests
 
@@ -217,6 +385,17 @@ func (o PluginLldpNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.P
 /*  RPC commands */
 type (
 	ApiLldpClientCntHandler struct{}
+
+	ApiLldpClientNbrIterHandler struct{} // iterate on the neighbor table
+	ApiLldpClientNbrIterParams  struct {
+		Reset bool   `json:"reset"`
+		Count uint16 `json:"count" validate:"required,gte=0,lte=255"`
+	}
+	ApiLldpClientNbrIterResult struct {
+		Empty   bool              `json:"empty"`
+		Stopped bool              `json:"stopped"`
+		Vec     []LldpNeighborRec `json:"data"`
+	}
 )
 
 func getNs(ctx interface{}, params *fastjson.RawMessage) (*PluginLldpNs, *jsonrpc.Error) {
@@ -263,6 +442,51 @@ func (h ApiLldpClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.
 	return c.cdbv.GeneralCounters(err, tctx, params, &p)
 }
 
+func (h ApiLldpClientNbrIterHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+
+	var p ApiLldpClientNbrIterParams
+	var res ApiLldpClientNbrIterResult
+
+	tctx := ctx.(*core.CThreadCtx)
+
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	err = tctx.UnmarshalValidate(*params, &p)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+
+	if p.Reset {
+		res.Empty = c.IterReset()
+	}
+	if res.Empty {
+		return &res, nil
+	}
+	if c.IterIsStopped() {
+		res.Stopped = true
+		return &res, nil
+	}
+
+	nbrs, err := c.GetNext(p.Count)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	res.Vec = nbrs
+	return &res, nil
+}
+
 func init() {
 
 	/* register of plugins callbacks for ns,c level  */
@@ -286,10 +510,13 @@ func init() {
 	  aa - misc
 	*/
 
-	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false) // get counters/meta
+	core.RegisterCB("lldp_client_cnt", ApiLldpClientCntHandler{}, false)           // get counters/meta
+	core.RegisterCB("lldp_c_neighbors_iter", ApiLldpClientNbrIterHandler{}, false) // iterate the neighbors
 
+	/* register callback for rx side*/
+	core.ParserRegister("lldp", HandleRxLldpPacket)
 }
 
 func Register(ctx *core.CThreadCtx) {
-	//pass
+	ctx.RegisterParserCb("lldp")
 }
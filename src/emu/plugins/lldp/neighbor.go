@@ -0,0 +1,295 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+lldp rx side, the LLDPDUs of the DUT are decoded to a neighbor table per client.
+
+The neighbor is identified by the chassis ID and the port ID (MSAP). The TTL of the LLDPDU restarts the aging
+timer of the neighbor, a TTL of zero (shutdown LLDPDU) removes it.
+*/
+
+import (
+	"emu/core"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+)
+
+const (
+	LLDP_MAX_NEIGHBORS = 32 // default size of the neighbor table of a client
+
+	lldpTlvEnd       = 0
+	lldpTlvChassisId = 1
+	lldpTlvPortId    = 2
+	lldpTlvTtl       = 3
+	lldpTlvPortDesc  = 4
+	lldpTlvSysName   = 5
+	lldpTlvSysDesc   = 6
+	lldpTlvSysCap    = 7
+	lldpTlvMgmtAddr  = 8
+	lldpTlvOrg       = 127
+)
+
+var lldpOui8021 = []byte{0x00, 0x80, 0xc2}
+
+// LldpNeighborRec is the RPC view of a neighbor
+type LldpNeighborRec struct {
+	ChassisIdType uint8            `json:"chassis_id_type"`
+	ChassisId     string           `json:"chassis_id"`
+	PortIdType    uint8            `json:"port_id_type"`
+	PortId        string           `json:"port_id"`
+	Ttl           uint16           `json:"ttl"`
+	PortDesc      string           `json:"port_desc,omitempty"`
+	SysName       string           `json:"sys_name,omitempty"`
+	SysDesc       string           `json:"sys_desc,omitempty"`
+	SysCap        uint16           `json:"sys_cap"`
+	EnabledCap    uint16           `json:"enabled_cap"`
+	MgmtAddr      []string         `json:"mgmt_addr,omitempty"`
+	PortVlan      uint16           `json:"port_vlan,omitempty"` // IEEE 802.1 port VLAN ID
+	MedPolicy     []LldpMedPolicyT `json:"med_policy,omitempty"`
+	SrcMac        string           `json:"src_mac"`
+	RxPkts        uint64           `json:"rx_pkts"`
+	RemainSec     uint32           `json:"remain"`
+}
+
+// lldpFormatId format a chassis/port ID by its subtype, MAC and network address are formatted as addresses
+func lldpFormatId(subtype, macType, addrType uint8, v []byte) string {
+	if subtype == macType && len(v) == 6 {
+		return net.HardwareAddr(v).String()
+	}
+	if subtype == addrType && len(v) > 1 {
+		return lldpFormatAddr(v[0], v[1:])
+	}
+	for _, c := range v {
+		if c < 0x20 || c > 0x7e {
+			return hex.EncodeToString(v)
+		}
+	}
+	return string(v)
+}
+
+// lldpFormatAddr format an address by its IANA address family
+func lldpFormatAddr(family uint8, v []byte) string {
+	if (family == 1 && len(v) == 4) || (family == 2 && len(v) == 16) {
+		return net.IP(v).String()
+	}
+	return hex.EncodeToString(v)
+}
+
+// lldpDecode decode the TLVs of a LLDPDU, returns the MSAP key of the neighbor
+func lldpDecode(d []byte, rec *LldpNeighborRec) (string, error) {
+	var chassis, port []byte
+	ttl := false
+	for i := 0; ; i++ {
+		if len(d) < 2 {
+			return "", fmt.Errorf("missing end TLV")
+		}
+		t := d[0] >> 1
+		l := int(binary.BigEndian.Uint16(d[0:2]) & 0x1ff)
+		if len(d) < 2+l {
+			return "", fmt.Errorf("TLV %v is truncated", t)
+		}
+		v := d[2 : 2+l]
+		d = d[2+l:]
+
+		// the first three TLVs are mandatory and in order
+		if (i == 0 && t != lldpTlvChassisId) || (i == 1 && t != lldpTlvPortId) || (i == 2 && t != lldpTlvTtl) {
+			return "", fmt.Errorf("TLV %v instead of a mandatory TLV", t)
+		}
+
+		switch t {
+		case lldpTlvEnd:
+			if !ttl {
+				return "", fmt.Errorf("missing mandatory TLV")
+			}
+			key := string(chassis) + string(port)
+			return key, nil
+		case lldpTlvChassisId:
+			if i != 0 || l < 2 {
+				return "", fmt.Errorf("invalid chassis ID TLV")
+			}
+			chassis = v
+			rec.ChassisIdType = v[0]
+			rec.ChassisId = lldpFormatId(v[0], 4, 5, v[1:])
+		case lldpTlvPortId:
+			if i != 1 || l < 2 {
+				return "", fmt.Errorf("invalid port ID TLV")
+			}
+			port = v
+			rec.PortIdType = v[0]
+			rec.PortId = lldpFormatId(v[0], 3, 4, v[1:])
+		case lldpTlvTtl:
+			if i != 2 || l < 2 {
+				return "", fmt.Errorf("invalid TTL TLV")
+			}
+			ttl = true
+			rec.Ttl = binary.BigEndian.Uint16(v[0:2])
+		case lldpTlvPortDesc:
+			rec.PortDesc = string(v)
+		case lldpTlvSysName:
+			rec.SysName = string(v)
+		case lldpTlvSysDesc:
+			rec.SysDesc = string(v)
+		case lldpTlvSysCap:
+			if l < 4 {
+				return "", fmt.Errorf("invalid system capabilities TLV")
+			}
+			rec.SysCap = binary.BigEndian.Uint16(v[0:2])
+			rec.EnabledCap = binary.BigEndian.Uint16(v[2:4])
+		case lldpTlvMgmtAddr:
+			// address string length (subtype and address), subtype, address, interface
+			if l < 2 || int(v[0]) < 2 || l < 1+int(v[0]) {
+				return "", fmt.Errorf("invalid management address TLV")
+			}
+			rec.MgmtAddr = append(rec.MgmtAddr, lldpFormatAddr(v[1], v[2:1+int(v[0])]))
+		case lldpTlvOrg:
+			if l >= 6 && string(v[0:3]) == string(lldpOui8021) && v[3] == 1 {
+				rec.PortVlan = binary.BigEndian.Uint16(v[4:6])
+			}
+			if l >= 8 && string(v[0:3]) == string(lldpOuiMed) && v[3] == lldpMedPolicy {
+				p := binary.BigEndian.Uint32(v[4:8])
+				rec.MedPolicy = append(rec.MedPolicy, LldpMedPolicyT{
+					App:      uint8(p >> 24),
+					Unknown:  p&(1<<23) != 0,
+					Tagged:   p&(1<<22) != 0,
+					Vlan:     uint16(p>>9) & 0xfff,
+					Priority: uint8(p>>6) & 0x7,
+					Dscp:     uint8(p) & 0x3f,
+				})
+			}
+		}
+	}
+}
+
+type PluginLldpNbrTimer struct {
+}
+
+func (o *PluginLldpNbrTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginLldpClient)
+	nbr := b.(*LldpNeighbor)
+	pi.onNeighborTimer(nbr)
+}
+
+// LldpNeighbor a neighbor that was learned from a LLDPDU
+type LldpNeighbor struct {
+	dlist     core.DList
+	timer     core.CHTimerObj
+	key       string
+	expireTck uint64
+	rec       LldpNeighborRec
+}
+
+func covertToNeighbor(dlist *core.DList) *LldpNeighbor {
+	var s LldpNeighbor
+	return (*LldpNeighbor)(unsafe.Pointer(uintptr(unsafe.Pointer(dlist)) - unsafe.Offsetof(s.dlist)))
+}
+
+// onRxNeighbor update the table by the decoded LLDPDU
+func (o *PluginLldpClient) onRxNeighbor(key string, rec *LldpNeighborRec) {
+	nbr, ok := o.nbrs[key]
+	if rec.Ttl == 0 {
+		if ok {
+			o.stats.nbrShutdown++
+			o.removeNeighbor(nbr)
+		}
+		return
+	}
+	if !ok {
+		if len(o.nbrs) >= int(o.maxNbrs) {
+			o.stats.nbrTableFull++
+			return
+		}
+		nbr = new(LldpNeighbor)
+		nbr.key = key
+		nbr.timer.SetCB(&o.nbrTimerCb, o, nbr)
+		o.nbrs[key] = nbr
+		o.nbrHead.AddLast(&nbr.dlist)
+		o.stats.nbrAdd++
+		o.stats.nbrActive++
+	}
+	rec.RxPkts = nbr.rec.RxPkts + 1
+	nbr.rec = *rec
+
+	if nbr.timer.IsRunning() {
+		o.timerw.Stop(&nbr.timer)
+	}
+	ticks := o.timerw.DurationToTicks(time.Duration(rec.Ttl) * time.Second)
+	nbr.expireTck = o.timerw.Ticks + uint64(ticks)
+	o.timerw.StartTicks(&nbr.timer, ticks)
+}
+
+func (o *PluginLldpClient) removeNeighbor(nbr *LldpNeighbor) {
+	if nbr.timer.IsRunning() {
+		o.timerw.Stop(&nbr.timer)
+	}
+	if o.activeIter == &nbr.dlist {
+		o.activeIter = nbr.dlist.Next()
+	}
+	o.nbrHead.RemoveNode(&nbr.dlist)
+	delete(o.nbrs, nbr.key)
+	o.stats.nbrActive--
+}
+
+func (o *PluginLldpClient) removeAllNeighbors() {
+	for !o.nbrHead.IsEmpty() {
+		o.removeNeighbor(covertToNeighbor(o.nbrHead.Next()))
+	}
+}
+
+func (o *PluginLldpClient) onNeighborTimer(nbr *LldpNeighbor) {
+	o.stats.nbrAged++
+	o.removeNeighbor(nbr)
+}
+
+func (o *PluginLldpClient) neighborRec(nbr *LldpNeighbor) LldpNeighborRec {
+	rec := nbr.rec
+	if nbr.expireTck > o.timerw.Ticks {
+		remain := time.Duration(nbr.expireTck-o.timerw.Ticks) * o.timerw.TickDuration
+		rec.RemainSec = uint32(remain / time.Second)
+	}
+	return rec
+}
+
+func (o *PluginLldpClient) IterReset() bool {
+	o.activeIter = o.nbrHead.Next()
+	if o.nbrHead.IsEmpty() {
+		o.iterReady = false
+		return true
+	}
+	o.iterReady = true
+	return false
+}
+
+func (o *PluginLldpClient) IterIsStopped() bool {
+	return !o.iterReady
+}
+
+func (o *PluginLldpClient) GetNext(n uint16) ([]LldpNeighborRec, error) {
+	r := make([]LldpNeighborRec, 0)
+
+	if !o.iterReady {
+		return r, fmt.Errorf(" Iterator is not ready- reset the iterator")
+	}
+
+	cnt := 0
+	for {
+		if o.activeIter == &o.nbrHead {
+			o.iterReady = false // require a new reset
+			break
+		}
+		cnt++
+		if cnt > int(n) {
+			break
+		}
+		r = append(r, o.neighborRec(covertToNeighbor(o.activeIter)))
+		o.activeIter = o.activeIter.Next()
+	}
+	return r, nil
+}
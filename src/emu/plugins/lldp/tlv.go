@@ -0,0 +1,334 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package lldp
+
+/*
+typed TLVs of the LLDPDU, IEEE 802.1AB basic TLVs, IEEE 802.1 port VLAN and LLDP-MED (ANSI/TIA-1057)
+capabilities, network policy, extended power-via-MDI and inventory.
+
+The field engines of the namespace vary the TLVs per client, each new client takes the next value of the engine
+with the name of the field.
+*/
+
+import (
+	"bytes"
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"encoding/binary"
+	"net"
+	"strconv"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	lldpMedCap       = 1
+	lldpMedPolicy    = 2
+	lldpMedPower     = 4
+	lldpMedHwRev     = 5
+	lldpMedFwRev     = 6
+	lldpMedSwRev     = 7
+	lldpMedSerial    = 8
+	lldpMedMfgName   = 9
+	lldpMedModelName = 10
+	lldpMedAssetId   = 11
+
+	lldpMedCapCap       = 0x01
+	lldpMedCapPolicy    = 0x02
+	lldpMedCapPowerPd   = 0x10
+	lldpMedCapInventory = 0x20
+
+	LLDP_DEFAULT_PORT_ID = "1/1"
+	LLDP_DEFAULT_TTL     = 120
+)
+
+var lldpOuiMed = []byte{0x00, 0x12, 0xbb}
+
+// LldpCapT system capabilities, a bit per capability, 0x20 telephone, 0x08 WLAN access point
+type LldpCapT struct {
+	Sys     uint16 `json:"sys"`
+	Enabled uint16 `json:"enabled"`
+}
+
+// LldpMedPolicyT LLDP-MED network policy of an application
+type LldpMedPolicyT struct {
+	App      uint8  `json:"app"` // 1 voice, 2 voice signaling, 5 video conferencing ...
+	Vlan     uint16 `json:"vlan"`
+	Priority uint8  `json:"priority"` // L2 priority 0-7
+	Dscp     uint8  `json:"dscp"`     // 0-63
+	Tagged   bool   `json:"tagged"`
+	Unknown  bool   `json:"unknown"` // the policy is required but unknown
+}
+
+// LldpMedPowerT LLDP-MED extended power-via-MDI of a PD
+type LldpMedPowerT struct {
+	Source   uint8  `json:"source"`   // 0 unknown, 1 PSE, 2 local, 3 PSE and local
+	Priority uint8  `json:"priority"` // 0 unknown, 1 critical, 2 high, 3 low
+	Value    uint16 `json:"value"`    // the requested power in 0.1 W
+}
+
+// LldpMedInventoryT LLDP-MED inventory
+type LldpMedInventoryT struct {
+	HwRev        string `json:"hw_rev"`
+	FwRev        string `json:"fw_rev"`
+	SwRev        string `json:"sw_rev"`
+	Serial       string `json:"serial"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	AssetId      string `json:"asset_id"`
+}
+
+// LldpMedT LLDP-MED TLVs of an endpoint, the capabilities TLV is added by the other TLVs
+type LldpMedT struct {
+	Class     uint8              `json:"class"` // endpoint device class 1-3, default 3 (communication device)
+	Policy    []LldpMedPolicyT   `json:"policy"`
+	Power     *LldpMedPowerT     `json:"power"`
+	Inventory *LldpMedInventoryT `json:"inventory"`
+}
+
+// LldpTlvsT typed TLVs of the LLDPDU, the chassis ID is the MAC of the client
+type LldpTlvsT struct {
+	PortId   string    `json:"port_id"` // interface name, default 1/1
+	Ttl      uint16    `json:"ttl"`     // default 120
+	PortDesc string    `json:"port_desc"`
+	SysName  string    `json:"sys_name"`
+	SysDesc  string    `json:"sys_desc"`
+	Cap      *LldpCapT `json:"cap"`
+	MgmtAddr string    `json:"mgmt_addr"` // IPv4 or IPv6 address
+	PortVlan uint16    `json:"port_vlan"` // IEEE 802.1 port VLAN ID
+	Med      *LldpMedT `json:"med"`
+}
+
+// LldpNsInit the init json of the namespace
+type LldpNsInit struct {
+	Engines *fastjson.RawMessage `json:"engines"` // field engines by the name of the TLV field
+}
+
+// lldpEngines the field engines of the namespace by the name of the field
+type lldpEngines struct {
+	engines map[string]engines.FieldEngineIF
+	str     map[string]bool // engines that generate a string
+	valid   bool
+}
+
+func (o *lldpEngines) create(tctx *core.CThreadCtx, data *fastjson.RawMessage) {
+	*o = lldpEngines{valid: true}
+	if data == nil {
+		return
+	}
+	var req []engines.FieldEngineRequest
+	mgr := engines.NewEngineManager(tctx, data)
+	if !mgr.WasCreatedSuccessfully() || fastjson.Unmarshal(*data, &req) != nil {
+		o.valid = false
+		return
+	}
+	o.engines = mgr.GetEngineMap()
+	o.str = make(map[string]bool)
+	for _, r := range req {
+		switch r.EngineType {
+		case "string_list", "histogram_string", "histogram_url":
+			o.str[r.EngineName] = true
+		}
+	}
+}
+
+// next the next value of the engine, nil if there is no engine for the field
+func (o *lldpEngines) next(name string) []byte {
+	eng, ok := o.engines[name]
+	if !ok {
+		return nil
+	}
+	b := make([]byte, eng.GetSize())
+	if _, err := eng.Update(b); err != nil {
+		return nil
+	}
+	return b
+}
+
+// nextUint the number of a numeric engine
+func (o *lldpEngines) nextUint(name string) (uint64, bool) {
+	b := o.next(name)
+	if b == nil || o.str[name] || len(b) > 8 {
+		return 0, false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+// nextString a string engine replaces the value, the number of a numeric engine is added to it
+func (o *lldpEngines) nextString(name string, s string) string {
+	if o.str[name] {
+		if b := o.next(name); b != nil {
+			return string(bytes.TrimRight(b, "\x00"))
+		}
+		return s
+	}
+	if v, ok := o.nextUint(name); ok {
+		return s + strconv.FormatUint(v, 10)
+	}
+	return s
+}
+
+// apply the engines of the namespace to a copy of the TLVs
+func (o *lldpEngines) apply(t *LldpTlvsT) *LldpTlvsT {
+	r := *t
+	if len(o.engines) == 0 {
+		return &r
+	}
+	r.PortId = o.nextString("port_id", r.PortId)
+	r.PortDesc = o.nextString("port_desc", r.PortDesc)
+	r.SysName = o.nextString("sys_name", r.SysName)
+	r.SysDesc = o.nextString("sys_desc", r.SysDesc)
+	if v, ok := o.nextUint("port_vlan"); ok {
+		r.PortVlan = uint16(v)
+	}
+	if r.Med != nil {
+		med := *r.Med
+		r.Med = &med
+		if len(med.Policy) > 0 {
+			med.Policy = append([]LldpMedPolicyT(nil), med.Policy...)
+			if v, ok := o.nextUint("policy_vlan"); ok {
+				med.Policy[0].Vlan = uint16(v)
+			}
+		}
+		if med.Inventory != nil {
+			inv := *med.Inventory
+			med.Inventory = &inv
+			inv.Serial = o.nextString("serial", inv.Serial)
+			inv.AssetId = o.nextString("asset_id", inv.AssetId)
+		}
+	}
+	return &r
+}
+
+// lldpAppendTlv append a TLV to the LLDPDU
+func lldpAppendTlv(b []byte, t uint8, v ...[]byte) []byte {
+	l := 0
+	for _, p := range v {
+		l += len(p)
+	}
+	if l > 0x1ff {
+		return b // can't be encoded
+	}
+	b = append(b, t<<1|uint8(l>>8), uint8(l))
+	for _, p := range v {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func lldpAppendMed(b []byte, subtype uint8, v ...[]byte) []byte {
+	return lldpAppendTlv(b, lldpTlvOrg, append(append([]byte{}, lldpOuiMed...), subtype), bytes.Join(v, nil))
+}
+
+// lldpBuildTlvs build the mandatory TLVs and the typed TLVs, without the end TLV
+func lldpBuildTlvs(mac []byte, t *LldpTlvsT) []byte {
+	var b []byte
+	portId := t.PortId
+	if portId == "" {
+		portId = LLDP_DEFAULT_PORT_ID
+	}
+	ttl := t.Ttl
+	if ttl == 0 {
+		ttl = LLDP_DEFAULT_TTL
+	}
+	b = lldpAppendTlv(b, lldpTlvChassisId, []byte{4}, mac)
+	b = lldpAppendTlv(b, lldpTlvPortId, []byte{5}, []byte(portId))
+	b = lldpAppendTlv(b, lldpTlvTtl, []byte{uint8(ttl >> 8), uint8(ttl)})
+	if t.PortDesc != "" {
+		b = lldpAppendTlv(b, lldpTlvPortDesc, []byte(t.PortDesc))
+	}
+	if t.SysName != "" {
+		b = lldpAppendTlv(b, lldpTlvSysName, []byte(t.SysName))
+	}
+	if t.SysDesc != "" {
+		b = lldpAppendTlv(b, lldpTlvSysDesc, []byte(t.SysDesc))
+	}
+	if t.Cap != nil {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint16(v[0:2], t.Cap.Sys)
+		binary.BigEndian.PutUint16(v[2:4], t.Cap.Enabled)
+		b = lldpAppendTlv(b, lldpTlvSysCap, v)
+	}
+	if ip := net.ParseIP(t.MgmtAddr); ip != nil {
+		// address length, IANA family, address, ifIndex numbering, ifIndex 0, no OID
+		family, addr := uint8(2), []byte(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			family, addr = 1, ip4
+		}
+		b = lldpAppendTlv(b, lldpTlvMgmtAddr, []byte{uint8(len(addr) + 1), family}, addr,
+			[]byte{2, 0, 0, 0, 0, 0})
+	}
+	if t.PortVlan != 0 {
+		b = lldpAppendTlv(b, lldpTlvOrg, lldpOui8021, []byte{1, uint8(t.PortVlan >> 8), uint8(t.PortVlan)})
+	}
+	if t.Med != nil {
+		b = lldpBuildMed(b, t.Med)
+	}
+	return b
+}
+
+func lldpBuildMed(b []byte, m *LldpMedT) []byte {
+	class := m.Class
+	if class == 0 {
+		class = 3
+	}
+	medCap := uint16(lldpMedCapCap)
+	if len(m.Policy) > 0 {
+		medCap |= lldpMedCapPolicy
+	}
+	if m.Power != nil {
+		medCap |= lldpMedCapPowerPd
+	}
+	if m.Inventory != nil {
+		medCap |= lldpMedCapInventory
+	}
+	b = lldpAppendMed(b, lldpMedCap, []byte{uint8(medCap >> 8), uint8(medCap), class})
+
+	for _, p := range m.Policy {
+		// app (8), unknown (1), tagged (1), reserved (1), vlan (12), priority (3), dscp (6)
+		v := uint32(p.Vlan&0xfff)<<9 | uint32(p.Priority&0x7)<<6 | uint32(p.Dscp&0x3f)
+		if p.Unknown {
+			v |= 1 << 23
+		}
+		if p.Tagged {
+			v |= 1 << 22
+		}
+		v |= uint32(p.App) << 24
+		d := make([]byte, 4)
+		binary.BigEndian.PutUint32(d, v)
+		b = lldpAppendMed(b, lldpMedPolicy, d)
+	}
+
+	if p := m.Power; p != nil {
+		// power type PD (01), source (2), priority (4)
+		b = lldpAppendMed(b, lldpMedPower, []byte{0x40 | (p.Source&0x3)<<4 | p.Priority&0xf,
+			uint8(p.Value >> 8), uint8(p.Value)})
+	}
+
+	if inv := m.Inventory; inv != nil {
+		for _, s := range []struct {
+			subtype uint8
+			v       string
+		}{
+			{lldpMedHwRev, inv.HwRev},
+			{lldpMedFwRev, inv.FwRev},
+			{lldpMedSwRev, inv.SwRev},
+			{lldpMedSerial, inv.Serial},
+			{lldpMedMfgName, inv.Manufacturer},
+			{lldpMedModelName, inv.Model},
+			{lldpMedAssetId, inv.AssetId},
+		} {
+			if s.v != "" {
+				b = lldpAppendMed(b, s.subtype, []byte(s.v))
+			}
+		}
+	}
+	return b
+}
@@ -403,6 +403,11 @@ func (o *PluginMDnsClient) OnCreate() {
 // OnEvent callback of the mDNS client in case of events.
 func (o *PluginMDnsClient) OnEvent(msg string, a, b interface{}) {}
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginMDnsClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // OnRemove is called when we remove the mDNS client.
 func (o *PluginMDnsClient) OnRemove(ctx *core.PluginCtx) {
 	ctx.UnregisterEvents(&o.PluginBase, mdnsEvents)
@@ -882,6 +887,11 @@ func NewMDnsNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginMDnsNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // OnRemove when removing mDNS namespace plugin.
 func (o *PluginMDnsNs) OnRemove(ctx *core.PluginCtx) {
 	// Remove AutoPlay first
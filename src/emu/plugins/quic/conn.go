@@ -0,0 +1,2152 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+/*
+QUIC connection, RFC 9000/9001/9002, over the UDP sockets of the transport layer.
+
+The TLS 1.3 handshake is crypto/tls in QUIC mode, the connection carries its messages in CRYPTO frames of the
+Initial/Handshake/1-RTT packets and installs the keys of each level. The packets of the levels are coalesced in
+one datagram as long as there is room, a datagram with an Initial packet of the client is padded to 1200 bytes.
+
+Loss detection and the congestion controller (NewReno) are RFC 9002: a packet is lost after 3 later packets were
+acknowledged or after 9/8 RTT, PTO probes the peer in case there is no acknowledgment. The data of lost packets
+(CRYPTO, STREAM and control frames) is sent again in new packets.
+
+Each side issues connection IDs to the peer after the handshake. The client moves the connection to a new UDP
+socket in case its address was changed (migration): it uses the next connection ID of the server and validates the
+new path by PATH_CHALLENGE, the server follows the client to the new path on the first packet from it.
+
+Not supported: 0-RTT, Retry, key update, stateless reset, ECN.
+*/
+
+import (
+	"context"
+	"crypto/tls"
+	"emu/core"
+	"emu/plugins/transport"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	QUIC_CID_LEN          = 8
+	QUIC_MIN_INITIAL_SIZE = 1200
+	QUIC_MAX_DATAGRAM     = 1252 // the datagrams that are sent, in case the MTU allows it
+	QUIC_ACTIVE_CID_LIMIT = 4
+	QUIC_CONN_WINDOW      = 4 << 20
+	QUIC_STREAM_WINDOW    = 1 << 20
+	QUIC_MAX_STREAMS      = 100 // bidirectional streams of the peer
+	QUIC_MAX_UNI_STREAMS  = 16
+	QUIC_MAX_ACK_RANGES   = 32
+	QUIC_MAX_ACK_DELAY    = 25 * time.Millisecond
+	QUIC_INITIAL_RTT      = 333 * time.Millisecond
+	QUIC_PKT_THRESHOLD    = 3
+
+	// state of the connection
+	quicStateHandshake = 1
+	quicStateActive    = 2 // the handshake is complete
+	quicStateClosed    = 3
+
+	// timers of the connection
+	quicTimerLoss = 1
+	quicTimerAck  = 2
+	quicTimerIdle = 3
+
+	// retransmission records
+	quicRecCrypto = 1
+	quicRecStream = 2
+	quicRecCtrl   = 3
+)
+
+// the space of each level of crypto/tls
+var quicLevelSpace = map[tls.QUICEncryptionLevel]int{
+	tls.QUICEncryptionLevelInitial:     quicSpaceInitial,
+	tls.QUICEncryptionLevelHandshake:   quicSpaceHandshake,
+	tls.QUICEncryptionLevelApplication: quicSpaceApp,
+}
+
+var quicSpaceLevel = [quicSpaces]tls.QUICEncryptionLevel{
+	tls.QUICEncryptionLevelInitial,
+	tls.QUICEncryptionLevelHandshake,
+	tls.QUICEncryptionLevelApplication,
+}
+
+// quicError a connection error, closes the connection with code
+type quicError struct {
+	code   uint64
+	reason string
+}
+
+func (o *quicError) Error() string {
+	return fmt.Sprintf("quic error 0x%x %s", o.code, o.reason)
+}
+
+func quicErr(code uint64, reason string) *quicError {
+	return &quicError{code: code, reason: reason}
+}
+
+type QuicConnStats struct {
+	dgramTx         uint64
+	dgramTxBytes    uint64
+	dgramTxErr      uint64
+	dgramRx         uint64
+	dgramRxBytes    uint64
+	pktTxInitial    uint64
+	pktTxHandshake  uint64
+	pktTx1Rtt       uint64
+	pktRx           uint64
+	pktRxDrop       uint64
+	pktRxNoKeys     uint64
+	pktRxDecryptErr uint64
+	pktRxDup        uint64
+	pktLost         uint64
+	pto             uint64
+	handshakeDone   uint64
+	tlsErr          uint64
+	protoErr        uint64
+	closeTx         uint64
+	closeRx         uint64
+	idleTimeout     uint64
+	versionNeg      uint64
+	migrate         uint64
+	migrateFail     uint64
+	pathValidated   uint64
+	cidIssued       uint64
+	cidRetired      uint64
+	streamOpen      uint64
+	streamClose     uint64
+	streamReset     uint64
+}
+
+func NewQuicConnStatsDb(name string, o *QuicConnStats) *core.CCounterDb {
+	db := core.NewCCounterDb(name)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dgramTx,
+		Name:     "dgramTx",
+		Help:     "datagrams sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dgramTxBytes,
+		Name:     "dgramTxBytes",
+		Help:     "bytes of the datagrams sent",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dgramTxErr,
+		Name:     "dgramTxErr",
+		Help:     "error writing in socket",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dgramRx,
+		Name:     "dgramRx",
+		Help:     "datagrams received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.dgramRxBytes,
+		Name:     "dgramRxBytes",
+		Help:     "bytes of the datagrams received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxInitial,
+		Name:     "pktTxInitial",
+		Help:     "Initial packets sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxHandshake,
+		Name:     "pktTxHandshake",
+		Help:     "Handshake packets sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTx1Rtt,
+		Name:     "pktTx1Rtt",
+		Help:     "1-RTT packets sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRx,
+		Name:     "pktRx",
+		Help:     "packets received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDrop,
+		Name:     "pktRxDrop",
+		Help:     "invalid or unsupported packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxNoKeys,
+		Name:     "pktRxNoKeys",
+		Help:     "packets without keys, not yet or discarded",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDecryptErr,
+		Name:     "pktRxDecryptErr",
+		Help:     "packets that could not be decrypted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxDup,
+		Name:     "pktRxDup",
+		Help:     "duplicate packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktLost,
+		Name:     "pktLost",
+		Help:     "packets declared lost",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pto,
+		Name:     "pto",
+		Help:     "probe timeouts",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.handshakeDone,
+		Name:     "handshakeDone",
+		Help:     "handshakes completed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tlsErr,
+		Name:     "tlsErr",
+		Help:     "TLS handshake errors",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.protoErr,
+		Name:     "protoErr",
+		Help:     "connections closed due to a protocol error",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.closeTx,
+		Name:     "closeTx",
+		Help:     "CONNECTION_CLOSE sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.closeRx,
+		Name:     "closeRx",
+		Help:     "CONNECTION_CLOSE received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.idleTimeout,
+		Name:     "idleTimeout",
+		Help:     "connections closed by the idle timeout",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.versionNeg,
+		Name:     "versionNeg",
+		Help:     "version negotiation packets",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.migrate,
+		Name:     "migrate",
+		Help:     "connections moved to a new path",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.migrateFail,
+		Name:     "migrateFail",
+		Help:     "migration without a spare connection ID of the peer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pathValidated,
+		Name:     "pathValidated",
+		Help:     "paths validated by PATH_RESPONSE",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cidIssued,
+		Name:     "cidIssued",
+		Help:     "connection IDs issued by NEW_CONNECTION_ID",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.cidRetired,
+		Name:     "cidRetired",
+		Help:     "connection IDs retired by the peer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.streamOpen,
+		Name:     "streamOpen",
+		Help:     "streams opened",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.streamClose,
+		Name:     "streamClose",
+		Help:     "streams completed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.streamReset,
+		Name:     "streamReset",
+		Help:     "streams reset by the peer",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+	return db
+}
+
+// quicApp the application of a connection, HTTP/3
+type quicApp interface {
+	onConnected(c *quicConn)                                     // the handshake is complete, streams can be opened
+	onStreamData(c *quicConn, s *quicStream, d []byte, fin bool) // data in order, s.rxReset in case of RESET_STREAM
+	onClosed(c *quicConn)                                        // the connection is closed and freed
+}
+
+// quicPath a UDP socket of a connection
+type quicPath struct {
+	s      transport.SocketApi
+	conn   *quicConn
+	srv    *quicServer // server socket, the packets are dispatched by the connection ID
+	closed bool
+}
+
+func (o *quicPath) OnRxEvent(event transport.SocketEventType) {}
+
+func (o *quicPath) OnRxData(d []byte) {
+	if o.srv != nil {
+		o.srv.input(o, d)
+	} else if o.conn != nil {
+		o.conn.input(o, d)
+	}
+}
+
+func (o *quicPath) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *quicPath) close() {
+	if !o.closed {
+		o.closed = true
+		o.conn = nil
+		o.s.Close()
+	}
+}
+
+// quicSendBuf the data of a stream or of the CRYPTO frames of a level, it is kept until the stream is complete
+type quicSendBuf struct {
+	b        []byte
+	sent     uint64 // the data up to sent was sent at least once
+	lost     quicRangeSet
+	acked    quicRangeSet
+	fin      bool // the application finished the stream
+	finSent  bool
+	finAcked bool
+}
+
+func (o *quicSendBuf) pending() bool {
+	return len(o.lost) > 0 || o.sent < uint64(len(o.b)) || (o.fin && !o.finSent)
+}
+
+/*
+next the next frame of up to n bytes, lost data first. limit is the flow control limit of new data.
+isNew is the number of new bytes.
+*/
+func (o *quicSendBuf) next(n uint64, limit uint64) (off uint64, d []byte, fin bool, isNew uint64, ok bool) {
+	size := uint64(len(o.b))
+	for {
+		r, ok := o.lost.pop(n)
+		if !ok {
+			break
+		}
+		if o.acked.covers(r.start, r.end) {
+			continue
+		}
+		fin = o.fin && !o.finSent && r.end == size
+		if fin {
+			o.finSent = true
+		}
+		return r.start, o.b[r.start:r.end], fin, 0, true
+	}
+	end := o.sent + n
+	if end > limit {
+		end = limit
+	}
+	if end > size {
+		end = size
+	}
+	if end < o.sent {
+		end = o.sent
+	}
+	fin = o.fin && !o.finSent && end == size
+	if end == o.sent && !fin {
+		return 0, nil, false, 0, false
+	}
+	off = o.sent
+	o.sent = end
+	if fin {
+		o.finSent = true
+	}
+	return off, o.b[off:end], fin, end - off, true
+}
+
+// quicRecvBuf reassembly of the data of a stream or of the CRYPTO frames of a level
+type quicRecvBuf struct {
+	off  uint64 // the data up to off was delivered
+	segs []quicSeg
+	buf  []byte
+}
+
+type quicSeg struct {
+	off uint64
+	d   []byte
+}
+
+// push returns the data that is in order now, it is valid until the next push
+func (o *quicRecvBuf) push(off uint64, d []byte) []byte {
+	end := off + uint64(len(d))
+	if end <= o.off {
+		return nil
+	}
+	if off > o.off {
+		i := sort.Search(len(o.segs), func(i int) bool { return o.segs[i].off >= off })
+		o.segs = append(o.segs, quicSeg{})
+		copy(o.segs[i+1:], o.segs[i:])
+		o.segs[i] = quicSeg{off: off, d: append([]byte(nil), d...)}
+		return nil
+	}
+	out := d[o.off-off:]
+	o.off = end
+	if len(o.segs) == 0 || o.segs[0].off > o.off {
+		return out
+	}
+	o.buf = append(o.buf[:0], out...)
+	for len(o.segs) > 0 && o.segs[0].off <= o.off {
+		s := o.segs[0]
+		o.segs = o.segs[1:]
+		if e := s.off + uint64(len(s.d)); e > o.off {
+			o.buf = append(o.buf, s.d[o.off-s.off:]...)
+			o.off = e
+		}
+	}
+	return o.buf
+}
+
+// quicStream a stream of the connection
+type quicStream struct {
+	id        uint64
+	tx        quicSendBuf
+	txMax     uint64 // flow control limit of the peer
+	txDone    bool   // all the data was acknowledged, or receive only stream
+	queued    bool   // in the send queue
+	rx        quicRecvBuf
+	rxMax     uint64 // our flow control limit
+	rxHighest uint64
+	rxFin     bool
+	rxSize    uint64 // the final size
+	rxDone    bool   // all the data was delivered, or send only stream
+	rxReset   bool
+	ext       interface{} // of the application
+}
+
+func (o *quicStream) uni() bool {
+	return o.id&2 != 0
+}
+
+type quicCid struct {
+	seq uint64
+	id  []byte
+}
+
+type quicSentPkt struct {
+	pn           uint64
+	time         time.Duration
+	size         int
+	ackEliciting bool
+	recs         []quicRec
+}
+
+// quicRec the information to send again in case the packet is lost
+type quicRec struct {
+	kind uint8
+	s    *quicStream
+	off  uint64
+	n    uint64
+	fin  bool
+	b    []byte // control frame
+}
+
+// quicSpace packet number space
+type quicSpace struct {
+	tx, rx       *quicKeys
+	discarded    bool
+	nextPn       uint64
+	recv         quicRangeSet
+	hasRx        bool
+	largestRx    uint64
+	largestRxT   time.Duration
+	unacked      int // ack-eliciting packets that were not acknowledged
+	ackNow       bool
+	hasAcked     bool
+	largestAcked uint64
+	sent         []*quicSentPkt // in flight
+	lossTime     time.Duration
+	lastSent     time.Duration // of the last ack-eliciting packet
+	probe        int           // packets to send regardless of the congestion window
+	crypto       quicSendBuf
+	cryptoRx     quicRecvBuf
+}
+
+// quicBuild a packet that is built, before its protection
+type quicBuild struct {
+	b            []byte
+	sp           int
+	pnOff        int
+	pnLen        int
+	lenOff       int // of the length of a long header, zero for short header
+	pn           uint64
+	ackEliciting bool
+	hasAck       bool
+	recs         []quicRec
+}
+
+type quicConnTimer struct{}
+
+func (o *quicConnTimer) OnEvent(a, b interface{}) {
+	a.(*quicConn).onTimer(b.(int))
+}
+
+// quicConnCfg the environment of a connection
+type quicConnCfg struct {
+	server bool
+	tls    *tls.Config
+	app    quicApp
+	stats  *QuicConnStats
+	tctx   *core.CThreadCtx
+	cids   map[string]*quicConn // server, the connections by the connection IDs they issued
+	idle   time.Duration
+}
+
+// quicConn a QUIC connection
+type quicConn struct {
+	cfg         quicConnCfg
+	stats       *QuicConnStats
+	timerw      *core.TimerCtx
+	tls         *tls.QUICConn
+	state       uint8
+	confirmed   bool
+	connected   bool // onConnected should be called
+	path        *quicPath
+	maxDg       int
+	odcid       []byte // the first destination connection ID of the client
+	dcid        []byte // of the peer, in use
+	dcidSeq     uint64
+	dcidSet     bool // client, the connection ID of the server was taken from its first packet
+	spareDcids  []quicCid
+	retirePrior uint64
+	scids       []quicCid // issued by us and not retired
+	nextScidSeq uint64
+	peerCidLim  uint64
+	spaces      [quicSpaces]quicSpace
+	builds      [quicSpaces]quicBuild
+	dg          []byte
+	rxDg        []byte
+
+	// flow control
+	peer           quicParams
+	peerMaxData    uint64
+	txData         uint64 // new data sent on all the streams
+	rxMax          uint64
+	rxData         uint64 // the highest offsets received on all the streams
+	rxConsumed     uint64
+	peerMaxStreams [2]uint64 // bidi, uni
+	localOpened    [2]uint64
+	peerOpened     [2]uint64
+	peerClosed     [2]uint64
+	maxStreamsAdv  [2]uint64
+	streams        map[uint64]*quicStream
+	sendQ          []*quicStream
+	ctrl           [][]byte // control frames to send
+	pad            bool     // pad the next 1-RTT datagram, path validation
+	challenge      []byte
+
+	// recovery
+	srtt       time.Duration
+	rttvar     time.Duration
+	minRtt     time.Duration
+	latestRtt  time.Duration
+	hasRtt     bool
+	ptoCount   uint
+	cwnd       int
+	inflight   int
+	ssthresh   int
+	recovery   time.Duration
+	maxAckDly  time.Duration
+	idle       time.Duration
+	timerCb    quicConnTimer
+	lossTimer  core.CHTimerObj
+	ackTimer   core.CHTimerObj
+	idleTimer  core.CHTimerObj
+	closeCode  uint64
+	closeLocal bool
+}
+
+func quicRandom(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+}
+
+func quicNewCid() []byte {
+	b := make([]byte, QUIC_CID_LEN)
+	quicRandom(b)
+	return b
+}
+
+func newQuicConn(cfg *quicConnCfg, p *quicPath) *quicConn {
+	o := new(quicConn)
+	o.cfg = *cfg
+	o.stats = cfg.stats
+	o.timerw = cfg.tctx.GetTimerCtx()
+	o.state = quicStateHandshake
+	o.path = p
+	p.conn = o
+	o.maxDg = QUIC_MAX_DATAGRAM
+	if mtu := int(p.s.GetL7MTU()); mtu < o.maxDg {
+		o.maxDg = mtu
+	}
+	o.dg = make([]byte, 0, o.maxDg+quicTagLen)
+	o.streams = make(map[uint64]*quicStream)
+	o.rxMax = QUIC_CONN_WINDOW
+	o.maxStreamsAdv = [2]uint64{QUIC_MAX_STREAMS, QUIC_MAX_UNI_STREAMS}
+	o.idle = cfg.idle
+	o.maxAckDly = QUIC_MAX_ACK_DELAY
+	o.cwnd = 10 * o.maxDg
+	o.ssthresh = int(^uint(0) >> 1)
+	o.scids = []quicCid{{seq: 0, id: quicNewCid()}}
+	o.nextScidSeq = 1
+	o.lossTimer.SetCB(&o.timerCb, o, quicTimerLoss)
+	o.ackTimer.SetCB(&o.timerCb, o, quicTimerAck)
+	o.idleTimer.SetCB(&o.timerCb, o, quicTimerIdle)
+	return o
+}
+
+// newQuicClientConn a connection on the socket of p, it is opened by start
+func newQuicClientConn(cfg *quicConnCfg, p *quicPath) *quicConn {
+	o := newQuicConn(cfg, p)
+	o.odcid = quicNewCid()
+	o.dcid = o.odcid
+	o.spaces[quicSpaceInitial].tx, o.spaces[quicSpaceInitial].rx = quicInitialKeys(o.odcid, false)
+	o.tls = tls.QUICClient(&tls.QUICConfig{TLSConfig: cfg.tls})
+	return o
+}
+
+// newQuicServerConn a connection of the server for the Initial packet with h
+func newQuicServerConn(cfg *quicConnCfg, p *quicPath, h *quicHdr) *quicConn {
+	o := newQuicConn(cfg, p)
+	o.odcid = append([]byte(nil), h.dcid...)
+	o.dcid = append([]byte(nil), h.scid...)
+	o.dcidSet = true
+	o.spaces[quicSpaceInitial].tx, o.spaces[quicSpaceInitial].rx = quicInitialKeys(o.odcid, true)
+	o.cfg.cids[string(o.odcid)] = o
+	o.cfg.cids[string(o.scids[0].id)] = o
+	o.tls = tls.QUICServer(&tls.QUICConfig{TLSConfig: cfg.tls})
+	return o
+}
+
+// start the handshake, the connection is closed in case of an error
+func (o *quicConn) start() error {
+	o.tls.SetTransportParameters(o.params())
+	if err := o.tls.Start(context.Background()); err != nil {
+		o.stats.tlsErr++
+		o.terminate()
+		return err
+	}
+	if err := o.tlsEvents(); err != nil {
+		o.fail(err)
+		return err
+	}
+	o.restartIdle()
+	o.flush()
+	return nil
+}
+
+// granularity of the timers, the tick of the timer wheel
+func (o *quicConn) granularity() time.Duration {
+	if o.timerw.TickDuration > time.Millisecond {
+		return o.timerw.TickDuration
+	}
+	return time.Millisecond
+}
+
+func (o *quicConn) params() []byte {
+	p := quicParams{
+		origDcid:         o.odcid,
+		initialScid:      o.scids[0].id,
+		idleTimeout:      uint64(o.idle / time.Millisecond),
+		maxUdpPayload:    uint64(o.path.s.GetL7MTU()),
+		maxData:          QUIC_CONN_WINDOW,
+		maxStreamBidiLoc: QUIC_STREAM_WINDOW,
+		maxStreamBidiRem: QUIC_STREAM_WINDOW,
+		maxStreamUni:     QUIC_STREAM_WINDOW,
+		maxStreamsBidi:   QUIC_MAX_STREAMS,
+		maxStreamsUni:    QUIC_MAX_UNI_STREAMS,
+		activeCidLimit:   QUIC_ACTIVE_CID_LIMIT,
+	}
+	return p.encode(o.cfg.server)
+}
+
+func (o *quicConn) onParams(b []byte) error {
+	p := &o.peer
+	if err := p.decode(b); err != nil {
+		return quicErr(QUIC_TRANSPORT_PARAMETER_ERROR, err.Error())
+	}
+	if !p.hasInitialScid || string(p.initialScid) != string(o.dcid) {
+		return quicErr(QUIC_TRANSPORT_PARAMETER_ERROR, "initial_source_connection_id")
+	}
+	if !o.cfg.server && (!p.hasOrigDcid || string(p.origDcid) != string(o.odcid)) {
+		return quicErr(QUIC_TRANSPORT_PARAMETER_ERROR, "original_destination_connection_id")
+	}
+	if o.cfg.server && p.hasOrigDcid {
+		return quicErr(QUIC_TRANSPORT_PARAMETER_ERROR, "original_destination_connection_id of the client")
+	}
+	o.peerMaxData = p.maxData
+	o.peerMaxStreams = [2]uint64{p.maxStreamsBidi, p.maxStreamsUni}
+	o.maxAckDly = time.Duration(p.maxAckDelay) * time.Millisecond
+	o.peerCidLim = p.activeCidLimit
+	if d := time.Duration(p.idleTimeout) * time.Millisecond; d > 0 && (o.idle == 0 || d < o.idle) {
+		o.idle = d
+	}
+	if int(p.maxUdpPayload) < o.maxDg {
+		o.maxDg = int(p.maxUdpPayload)
+	}
+	return nil
+}
+
+func (o *quicConn) tlsEvents() error {
+	for {
+		e := o.tls.NextEvent()
+		switch e.Kind {
+		case tls.QUICNoEvent:
+			return nil
+		case tls.QUICSetReadSecret, tls.QUICSetWriteSecret:
+			sp, ok := quicLevelSpace[e.Level]
+			if !ok {
+				continue // 0-RTT is not supported
+			}
+			k, err := newQuicKeys(e.Suite, e.Data)
+			if err != nil {
+				return quicErr(QUIC_INTERNAL_ERROR, err.Error())
+			}
+			if e.Kind == tls.QUICSetReadSecret {
+				o.spaces[sp].rx = k
+			} else {
+				o.spaces[sp].tx = k
+			}
+		case tls.QUICWriteData:
+			if sp, ok := quicLevelSpace[e.Level]; ok {
+				o.spaces[sp].crypto.b = append(o.spaces[sp].crypto.b, e.Data...)
+			}
+		case tls.QUICTransportParameters:
+			if err := o.onParams(e.Data); err != nil {
+				return err
+			}
+		case tls.QUICTransportParametersRequired:
+			o.tls.SetTransportParameters(o.params())
+		case tls.QUICHandshakeDone:
+			o.onHandshakeDone()
+		case tls.QUICErrorEvent:
+			return e.Err
+		}
+	}
+}
+
+func (o *quicConn) onHandshakeDone() {
+	o.state = quicStateActive
+	o.stats.handshakeDone++
+	o.connected = true
+	if o.cfg.server {
+		o.confirmed = true
+		o.ctrl = append(o.ctrl, []byte{quicFrameHandshakeDone})
+		o.discard(quicSpaceHandshake)
+		o.tls.SendSessionTicket(tls.QUICSessionTicketOptions{})
+	}
+	o.issueCids()
+}
+
+// issueCids issue connection IDs to the peer up to its limit
+func (o *quicConn) issueCids() {
+	lim := o.peerCidLim
+	if lim > QUIC_ACTIVE_CID_LIMIT {
+		lim = QUIC_ACTIVE_CID_LIMIT
+	}
+	for uint64(len(o.scids)) < lim {
+		c := quicCid{seq: o.nextScidSeq, id: quicNewCid()}
+		o.nextScidSeq++
+		o.scids = append(o.scids, c)
+		if o.cfg.cids != nil {
+			o.cfg.cids[string(c.id)] = o
+		}
+		token := make([]byte, 16)
+		quicRandom(token)
+		f := []byte{quicFrameNewCid}
+		f = quicAppendVarint(f, c.seq)
+		f = quicAppendVarint(f, 0)
+		f = append(f, byte(len(c.id)))
+		f = append(f, c.id...)
+		f = append(f, token...)
+		o.ctrl = append(o.ctrl, f)
+		o.stats.cidIssued++
+	}
+}
+
+// discard the keys of a space, its packets are not in flight anymore
+func (o *quicConn) discard(sp int) {
+	s := &o.spaces[sp]
+	if s.discarded {
+		return
+	}
+	for _, p := range s.sent {
+		o.inflight -= p.size
+	}
+	*s = quicSpace{discarded: true}
+	o.ptoCount = 0
+}
+
+// fail close the connection due to err
+func (o *quicConn) fail(err error) {
+	var qe *quicError
+	if errors.As(err, &qe) {
+		o.stats.protoErr++
+		o.close(qe.code, false, qe.reason)
+		return
+	}
+	o.stats.tlsErr++
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		o.close(QUIC_CRYPTO_ERROR+uint64(alert), false, "")
+		return
+	}
+	o.close(QUIC_CRYPTO_ERROR+80, false, "") // internal_error
+}
+
+// input a datagram of the path p
+func (o *quicConn) input(p *quicPath, d []byte) {
+	if o.state == quicStateClosed {
+		return
+	}
+	o.stats.dgramRx++
+	o.stats.dgramRxBytes += uint64(len(d))
+	o.rxDg = append(o.rxDg[:0], d...)
+	d = o.rxDg
+	for len(d) > 0 && o.state != quicStateClosed {
+		h, err := quicParseHdr(d, QUIC_CID_LEN)
+		if err != nil {
+			o.stats.pktRxDrop++
+			break
+		}
+		pkt := d[:h.length]
+		d = d[h.length:]
+		if h.long && h.version == 0 {
+			if !o.cfg.server && o.state == quicStateHandshake {
+				// the server does not support version 1
+				o.stats.versionNeg++
+				o.terminate()
+				return
+			}
+			continue
+		}
+		if h.long && h.version != QUIC_VERSION_1 {
+			o.stats.pktRxDrop++
+			continue
+		}
+		sp := quicSpaceApp
+		if h.long {
+			switch h.ptype {
+			case quicPktInitial:
+				sp = quicSpaceInitial
+			case quicPktHandshake:
+				sp = quicSpaceHandshake
+			default:
+				o.stats.pktRxDrop++ // 0-RTT and Retry are not supported
+				continue
+			}
+		}
+		s := &o.spaces[sp]
+		if s.rx == nil {
+			o.stats.pktRxNoKeys++
+			continue
+		}
+		pn, payload, err := s.rx.unprotect(pkt, h.pnOff, s.largestRx)
+		if err != nil {
+			o.stats.pktRxDecryptErr++
+			continue
+		}
+		if h.long && !o.dcidSet {
+			// the client uses the connection ID of the server from its first packet
+			o.dcid = append([]byte(nil), h.scid...)
+			o.dcidSet = true
+		}
+		if s.recv.contains(pn) {
+			o.stats.pktRxDup++
+			s.unacked++
+			s.ackNow = true
+			continue
+		}
+		o.stats.pktRx++
+		eliciting, probing, err := o.frames(sp, p, payload)
+		if err != nil {
+			o.fail(err)
+			return
+		}
+		if o.state == quicStateClosed {
+			return
+		}
+		if s.discarded {
+			continue
+		}
+		s.recv.add(pn, pn+1)
+		if len(s.recv) > QUIC_MAX_ACK_RANGES {
+			s.recv = s.recv[1:]
+		}
+		if !s.hasRx || pn > s.largestRx {
+			s.hasRx = true
+			s.largestRx = pn
+			s.largestRxT = o.cfg.tctx.LatNow()
+		}
+		if eliciting {
+			s.unacked++
+			if sp != quicSpaceApp || s.unacked >= 2 {
+				s.ackNow = true
+			} else if !s.ackNow && !o.ackTimer.IsRunning() {
+				o.timerw.Start(&o.ackTimer, o.maxAckDelay())
+			}
+		}
+		if o.cfg.server && sp == quicSpaceHandshake {
+			o.discard(quicSpaceInitial)
+		}
+		if sp == quicSpaceApp && p != o.path && !probing && pn == s.largestRx {
+			o.onPeerMigration(p)
+		}
+		o.restartIdle()
+	}
+	if o.state == quicStateClosed {
+		return
+	}
+	if o.connected {
+		o.connected = false
+		o.cfg.app.onConnected(o)
+		if o.state == quicStateClosed {
+			return
+		}
+	}
+	o.flush()
+}
+
+// maxAckDelay our max_ack_delay, the default
+func (o *quicConn) maxAckDelay() time.Duration {
+	return QUIC_MAX_ACK_DELAY
+}
+
+// frames process the frames of a packet
+func (o *quicConn) frames(sp int, p *quicPath, b []byte) (eliciting, probing bool, err error) {
+	probing = true
+	r := quicReader{b: b}
+	for len(r.b) > 0 {
+		t := r.varint()
+		switch t {
+		case quicFramePadding, quicFramePathChallenge, quicFramePathResponse, quicFrameNewCid:
+		default:
+			probing = false
+		}
+		switch t {
+		case quicFramePadding, quicFrameAck, quicFrameAckEcn, quicFrameClose, quicFrameCloseApp:
+		default:
+			eliciting = true
+		}
+		if sp != quicSpaceApp {
+			switch t {
+			case quicFramePadding, quicFramePing, quicFrameAck, quicFrameAckEcn, quicFrameCrypto, quicFrameClose:
+			default:
+				return false, false, quicErr(QUIC_PROTOCOL_VIOLATION, fmt.Sprintf("frame 0x%x in Initial/Handshake", t))
+			}
+		}
+
+		switch {
+		case t == quicFramePadding:
+			for len(r.b) > 0 && r.b[0] == 0 {
+				r.b = r.b[1:]
+			}
+		case t == quicFramePing:
+		case t == quicFrameAck || t == quicFrameAckEcn:
+			ranges, delay, e := quicReadAck(&r, t == quicFrameAckEcn)
+			if e != nil {
+				return false, false, quicErr(QUIC_FRAME_ENCODING_ERROR, "ack")
+			}
+			err = o.onAck(sp, ranges, delay)
+		case t == quicFrameResetStream:
+			id := r.varint()
+			r.varint() // error code
+			size := r.varint()
+			if r.err == nil {
+				err = o.onResetStream(id, size)
+			}
+		case t == quicFrameStopSending:
+			id := r.varint()
+			r.varint()
+			if r.err == nil {
+				err = o.onStopSending(id)
+			}
+		case t == quicFrameCrypto:
+			off := r.varint()
+			data := r.bytes(r.varint())
+			if r.err == nil {
+				err = o.onCrypto(sp, off, data)
+			}
+		case t == quicFrameNewToken:
+			r.bytes(r.varint())
+		case t >= quicFrameStream && t <= quicFrameStream|0x7:
+			id := r.varint()
+			var off uint64
+			if t&quicStreamOff != 0 {
+				off = r.varint()
+			}
+			var data []byte
+			if t&quicStreamLen != 0 {
+				data = r.bytes(r.varint())
+			} else {
+				data = r.bytes(uint64(len(r.b)))
+			}
+			if r.err == nil {
+				err = o.onStream(id, off, data, t&quicStreamFin != 0)
+			}
+		case t == quicFrameMaxData:
+			if v := r.varint(); v > o.peerMaxData {
+				o.peerMaxData = v
+			}
+		case t == quicFrameMaxStreamData:
+			id := r.varint()
+			v := r.varint()
+			if st, ok := o.streams[id]; ok && v > st.txMax {
+				st.txMax = v
+			}
+		case t == quicFrameMaxStreamsBidi || t == quicFrameMaxStreamsUni:
+			k := t - quicFrameMaxStreamsBidi
+			if v := r.varint(); v > o.peerMaxStreams[k] {
+				o.peerMaxStreams[k] = v
+			}
+		case t == quicFrameDataBlocked || t == quicFrameStreamsBlockedB || t == quicFrameStreamsBlockedU:
+			r.varint()
+		case t == quicFrameStreamBlocked:
+			r.varint()
+			r.varint()
+		case t == quicFrameNewCid:
+			seq := r.varint()
+			prior := r.varint()
+			id := r.bytes(r.u8())
+			r.bytes(16) // stateless reset token
+			if r.err == nil {
+				err = o.onNewCid(seq, prior, id)
+			}
+		case t == quicFrameRetireCid:
+			seq := r.varint()
+			if r.err == nil {
+				err = o.onRetireCid(seq)
+			}
+		case t == quicFramePathChallenge:
+			data := r.bytes(8)
+			if r.err == nil {
+				f := append([]byte{quicFramePathResponse}, data...)
+				if p == o.path {
+					o.ctrl = append(o.ctrl, f)
+				} else {
+					o.probeResponse(p, f)
+				}
+			}
+		case t == quicFramePathResponse:
+			data := r.bytes(8)
+			if r.err == nil && o.challenge != nil && string(data) == string(o.challenge) {
+				o.challenge = nil
+				o.stats.pathValidated++
+			}
+		case t == quicFrameClose || t == quicFrameCloseApp:
+			r.varint() // error code
+			if t == quicFrameClose {
+				r.varint() // frame type
+			}
+			r.bytes(r.varint())
+			if r.err == nil {
+				o.stats.closeRx++
+				o.terminate()
+				return eliciting, probing, nil
+			}
+		case t == quicFrameHandshakeDone:
+			if o.cfg.server {
+				return false, false, quicErr(QUIC_PROTOCOL_VIOLATION, "HANDSHAKE_DONE from the client")
+			}
+			if !o.confirmed {
+				o.confirmed = true
+				o.discard(quicSpaceHandshake)
+			}
+		default:
+			return false, false, quicErr(QUIC_FRAME_ENCODING_ERROR, fmt.Sprintf("unknown frame 0x%x", t))
+		}
+		if r.err != nil {
+			return false, false, quicErr(QUIC_FRAME_ENCODING_ERROR, fmt.Sprintf("frame 0x%x", t))
+		}
+		if err != nil {
+			return false, false, err
+		}
+		if o.state == quicStateClosed || o.spaces[sp].discarded {
+			break
+		}
+	}
+	return eliciting, probing, nil
+}
+
+func (o *quicConn) onCrypto(sp int, off uint64, data []byte) error {
+	s := &o.spaces[sp]
+	d := s.cryptoRx.push(off, data)
+	if len(d) == 0 {
+		return nil
+	}
+	if err := o.tls.HandleData(quicSpaceLevel[sp], d); err != nil {
+		return err
+	}
+	return o.tlsEvents()
+}
+
+func (o *quicConn) onNewCid(seq, prior uint64, id []byte) error {
+	if len(id) == 0 || len(id) > 20 || prior > seq {
+		return quicErr(QUIC_FRAME_ENCODING_ERROR, "NEW_CONNECTION_ID")
+	}
+	if seq < o.retirePrior {
+		o.retireDcid(seq)
+		return nil
+	}
+	if seq == o.dcidSeq {
+		return nil
+	}
+	for _, c := range o.spareDcids {
+		if c.seq == seq {
+			return nil
+		}
+	}
+	o.spareDcids = append(o.spareDcids, quicCid{seq: seq, id: append([]byte(nil), id...)})
+	if prior > o.retirePrior {
+		o.retirePrior = prior
+		spare := o.spareDcids[:0]
+		for _, c := range o.spareDcids {
+			if c.seq < prior {
+				o.retireDcid(c.seq)
+			} else {
+				spare = append(spare, c)
+			}
+		}
+		o.spareDcids = spare
+		if o.dcidSeq < prior {
+			o.retireDcid(o.dcidSeq)
+			o.dcidSeq = o.spareDcids[0].seq
+			o.dcid = o.spareDcids[0].id
+			o.spareDcids = o.spareDcids[1:]
+		}
+	}
+	if len(o.spareDcids)+1 > QUIC_ACTIVE_CID_LIMIT {
+		return quicErr(0x09, "active_connection_id_limit") // CONNECTION_ID_LIMIT_ERROR
+	}
+	return nil
+}
+
+func (o *quicConn) retireDcid(seq uint64) {
+	f := []byte{quicFrameRetireCid}
+	o.ctrl = append(o.ctrl, quicAppendVarint(f, seq))
+}
+
+// nextDcid use the next connection ID of the peer, for a new path
+func (o *quicConn) nextDcid() bool {
+	if len(o.spareDcids) == 0 {
+		return false
+	}
+	o.retireDcid(o.dcidSeq)
+	o.dcidSeq = o.spareDcids[0].seq
+	o.dcid = o.spareDcids[0].id
+	o.spareDcids = o.spareDcids[1:]
+	return true
+}
+
+func (o *quicConn) onRetireCid(seq uint64) error {
+	if seq >= o.nextScidSeq {
+		return quicErr(QUIC_PROTOCOL_VIOLATION, "RETIRE_CONNECTION_ID")
+	}
+	for i, c := range o.scids {
+		if c.seq == seq {
+			o.scids = append(o.scids[:i], o.scids[i+1:]...)
+			if o.cfg.cids != nil {
+				delete(o.cfg.cids, string(c.id))
+			}
+			o.stats.cidRetired++
+			o.issueCids()
+			break
+		}
+	}
+	return nil
+}
+
+// challenge validate the path, the datagram with PATH_CHALLENGE is padded
+func (o *quicConn) pathChallenge() {
+	o.challenge = make([]byte, 8)
+	quicRandom(o.challenge)
+	o.ctrl = append(o.ctrl, append([]byte{quicFramePathChallenge}, o.challenge...))
+	o.pad = true
+}
+
+// resetPath reset the congestion controller and the RTT for a new path
+func (o *quicConn) resetPath() {
+	o.hasRtt = false
+	o.ptoCount = 0
+	o.cwnd = 10 * o.maxDg
+	o.ssthresh = int(^uint(0) >> 1)
+	o.recovery = 0
+}
+
+/*
+migrate the client moves the connection to p, after its address was changed. Returns false in case there is no
+spare connection ID of the server.
+*/
+func (o *quicConn) migrate(p *quicPath) bool {
+	if o.state != quicStateActive || !o.nextDcid() {
+		o.stats.migrateFail++
+		return false
+	}
+	old := o.path
+	o.path = p
+	p.conn = o
+	old.close()
+	o.resetPath()
+	o.pathChallenge()
+	o.stats.migrate++
+	o.flush()
+	return true
+}
+
+// onPeerMigration the server follows the client to a new path
+func (o *quicConn) onPeerMigration(p *quicPath) {
+	old := o.path
+	o.path = p
+	p.conn = o
+	if old.conn == o {
+		old.close()
+	}
+	o.nextDcid()
+	o.resetPath()
+	o.pathChallenge()
+	o.stats.migrate++
+}
+
+// probeResponse PATH_RESPONSE on a path that is not in use, it is not retransmitted
+func (o *quicConn) probeResponse(p *quicPath, f []byte) {
+	if s := &o.spaces[quicSpaceApp]; s.tx != nil {
+		o.single(p, quicSpaceApp, f, QUIC_MIN_INITIAL_SIZE)
+	}
+}
+
+func (o *quicConn) getStream(id uint64) (*quicStream, error) {
+	if st, ok := o.streams[id]; ok {
+		return st, nil
+	}
+	local := (id&1 == 1) == o.cfg.server
+	k := (id >> 1) & 1
+	idx := id >> 2
+	if local {
+		if idx >= o.localOpened[k] {
+			return nil, quicErr(QUIC_STREAM_STATE_ERROR, "stream was not opened")
+		}
+		return nil, nil // completed
+	}
+	if idx < o.peerOpened[k] {
+		return nil, nil // completed
+	}
+	if idx >= o.maxStreamsAdv[k] {
+		return nil, quicErr(QUIC_STREAM_LIMIT_ERROR, "streams limit")
+	}
+	var st *quicStream
+	for i := o.peerOpened[k]; i <= idx; i++ {
+		st = o.newStream(i<<2 | k<<1 | (id & 1))
+	}
+	o.peerOpened[k] = idx + 1
+	return st, nil
+}
+
+func (o *quicConn) newStream(id uint64) *quicStream {
+	st := &quicStream{id: id, rxMax: QUIC_STREAM_WINDOW}
+	local := (id&1 == 1) == o.cfg.server
+	switch {
+	case st.uni() && local:
+		st.txMax = o.peer.maxStreamUni
+		st.rxDone = true
+	case st.uni():
+		st.txDone = true
+	case local:
+		st.txMax = o.peer.maxStreamBidiRem
+	default:
+		st.txMax = o.peer.maxStreamBidiLoc
+	}
+	o.streams[id] = st
+	o.stats.streamOpen++
+	return st
+}
+
+// openStream open a stream, nil in case of the limit of the peer
+func (o *quicConn) openStream(uni bool) *quicStream {
+	var k uint64
+	if uni {
+		k = 1
+	}
+	if o.state != quicStateActive || o.localOpened[k] >= o.peerMaxStreams[k] {
+		return nil
+	}
+	id := o.localOpened[k]<<2 | k<<1
+	if o.cfg.server {
+		id |= 1
+	}
+	o.localOpened[k]++
+	return o.newStream(id)
+}
+
+// write queue data on the stream, fin ends the stream. The data is sent by flush.
+func (o *quicConn) write(st *quicStream, d []byte, fin bool) {
+	st.tx.b = append(st.tx.b, d...)
+	if fin {
+		st.tx.fin = true
+	}
+	o.queueStream(st)
+}
+
+func (o *quicConn) queueStream(st *quicStream) {
+	if !st.queued && st.tx.pending() {
+		st.queued = true
+		o.sendQ = append(o.sendQ, st)
+	}
+}
+
+func (o *quicConn) onStream(id, off uint64, data []byte, fin bool) error {
+	st, err := o.getStream(id)
+	if err != nil || st == nil {
+		return err
+	}
+	if st.rxDone {
+		if !st.uni() || (id&1 == 1) != o.cfg.server {
+			return nil // retransmission
+		}
+		return quicErr(QUIC_STREAM_STATE_ERROR, "data on a send only stream")
+	}
+	end := off + uint64(len(data))
+	if end > st.rxMax {
+		return quicErr(QUIC_FLOW_CONTROL_ERROR, "stream data limit")
+	}
+	if (st.rxFin && end > st.rxSize) || (fin && st.rxFin && end != st.rxSize) || (fin && end < st.rxHighest) {
+		return quicErr(0x06, "final size") // FINAL_SIZE_ERROR
+	}
+	if fin {
+		st.rxFin = true
+		st.rxSize = end
+	}
+	if end > st.rxHighest {
+		o.rxData += end - st.rxHighest
+		st.rxHighest = end
+		if o.rxData > o.rxMax {
+			return quicErr(QUIC_FLOW_CONTROL_ERROR, "connection data limit")
+		}
+	}
+	d := st.rx.push(off, data)
+	done := st.rxFin && st.rx.off == st.rxSize
+	if len(d) == 0 && !done {
+		return nil
+	}
+	st.rxDone = done
+	o.credit(st, len(d))
+	o.cfg.app.onStreamData(o, st, d, done)
+	o.checkStream(st)
+	return nil
+}
+
+// credit update the flow control limits of the peer after data was consumed
+func (o *quicConn) credit(st *quicStream, n int) {
+	o.rxConsumed += uint64(n)
+	if o.rxMax-o.rxConsumed < QUIC_CONN_WINDOW/2 {
+		o.rxMax = o.rxConsumed + QUIC_CONN_WINDOW
+		o.ctrl = append(o.ctrl, quicAppendVarint([]byte{quicFrameMaxData}, o.rxMax))
+	}
+	if !st.rxFin && st.rxMax-st.rx.off < QUIC_STREAM_WINDOW/2 {
+		st.rxMax = st.rx.off + QUIC_STREAM_WINDOW
+		f := quicAppendVarint([]byte{quicFrameMaxStreamData}, st.id)
+		o.ctrl = append(o.ctrl, quicAppendVarint(f, st.rxMax))
+	}
+}
+
+func (o *quicConn) onResetStream(id, size uint64) error {
+	st, err := o.getStream(id)
+	if err != nil || st == nil || st.rxDone {
+		return err
+	}
+	o.stats.streamReset++
+	st.rxDone = true
+	st.rxReset = true
+	o.cfg.app.onStreamData(o, st, nil, true)
+	o.checkStream(st)
+	return nil
+}
+
+func (o *quicConn) onStopSending(id uint64) error {
+	st, err := o.getStream(id)
+	if err != nil || st == nil || st.txDone {
+		return err
+	}
+	// the peer does not want the data, reset the stream
+	f := quicAppendVarint([]byte{quicFrameResetStream}, st.id)
+	f = quicAppendVarint(f, 0x10c) // H3_REQUEST_CANCELLED
+	o.ctrl = append(o.ctrl, quicAppendVarint(f, st.tx.sent))
+	st.txDone = true
+	st.tx = quicSendBuf{}
+	o.checkStream(st)
+	return nil
+}
+
+// checkStream free the stream in case both directions are complete
+func (o *quicConn) checkStream(st *quicStream) {
+	if !st.txDone && st.tx.finAcked && st.tx.acked.covers(0, uint64(len(st.tx.b))) {
+		st.txDone = true
+	}
+	if !st.txDone || !st.rxDone {
+		return
+	}
+	if _, ok := o.streams[st.id]; !ok {
+		return
+	}
+	delete(o.streams, st.id)
+	o.stats.streamClose++
+	if (st.id&1 == 1) == o.cfg.server {
+		return
+	}
+	// more streams of the peer
+	k := (st.id >> 1) & 1
+	o.peerClosed[k]++
+	lim := [2]uint64{QUIC_MAX_STREAMS, QUIC_MAX_UNI_STREAMS}[k]
+	if n := o.peerClosed[k] + lim; n >= o.maxStreamsAdv[k]+lim/2 {
+		o.maxStreamsAdv[k] = n
+		o.ctrl = append(o.ctrl, quicAppendVarint([]byte{byte(quicFrameMaxStreamsBidi + k)}, n))
+	}
+}
+
+func (o *quicConn) onAck(sp int, ranges quicRangeSet, delay uint64) error {
+	s := &o.spaces[sp]
+	largest := ranges[len(ranges)-1].end - 1
+	if largest >= s.nextPn {
+		return quicErr(QUIC_PROTOCOL_VIOLATION, "ack of a packet that was not sent")
+	}
+	now := o.cfg.tctx.LatNow()
+	var rtt time.Duration
+	newly := false
+	sent := s.sent[:0]
+	for _, p := range s.sent {
+		if !ranges.contains(p.pn) {
+			sent = append(sent, p)
+			continue
+		}
+		newly = true
+		if p.pn == largest && p.ackEliciting {
+			rtt = now - p.time
+		}
+		o.inflight -= p.size
+		o.onAcked(p)
+	}
+	s.sent = sent
+	if !s.hasAcked || largest > s.largestAcked {
+		s.hasAcked = true
+		s.largestAcked = largest
+	}
+	if !newly {
+		return nil
+	}
+	if rtt > 0 || (newly && largest == s.largestAcked && !o.hasRtt) {
+		d := time.Duration(delay<<o.peer.ackDelayExp) * time.Microsecond
+		if sp != quicSpaceApp {
+			d = 0
+		} else if d > o.maxAckDly {
+			d = o.maxAckDly
+		}
+		o.updateRtt(rtt, d)
+	}
+	o.ptoCount = 0
+	o.detectLoss(sp, now)
+	return nil
+}
+
+func (o *quicConn) onAcked(p *quicSentPkt) {
+	if p.ackEliciting && p.time > o.recovery {
+		// NewReno
+		if o.cwnd < o.ssthresh {
+			o.cwnd += p.size
+		} else {
+			o.cwnd += o.maxDg * p.size / o.cwnd
+		}
+	}
+	for i := range p.recs {
+		r := &p.recs[i]
+		if r.kind != quicRecStream {
+			continue
+		}
+		r.s.tx.acked.add(r.off, r.off+r.n)
+		if r.fin {
+			r.s.tx.finAcked = true
+		}
+		o.checkStream(r.s)
+	}
+}
+
+func (o *quicConn) updateRtt(latest, ackDelay time.Duration) {
+	o.latestRtt = latest
+	if !o.hasRtt {
+		o.hasRtt = true
+		o.minRtt = latest
+		o.srtt = latest
+		o.rttvar = latest / 2
+		return
+	}
+	if latest < o.minRtt {
+		o.minRtt = latest
+	}
+	adj := latest
+	if latest >= o.minRtt+ackDelay {
+		adj -= ackDelay
+	}
+	diff := o.srtt - adj
+	if diff < 0 {
+		diff = -diff
+	}
+	o.rttvar = (3*o.rttvar + diff) / 4
+	o.srtt = (7*o.srtt + adj) / 8
+}
+
+func (o *quicConn) lossDelay() time.Duration {
+	d := o.latestRtt
+	if o.srtt > d {
+		d = o.srtt
+	}
+	if !o.hasRtt {
+		d = QUIC_INITIAL_RTT
+	}
+	d = d * 9 / 8
+	if g := o.granularity(); d < g {
+		d = g
+	}
+	return d
+}
+
+func (o *quicConn) detectLoss(sp int, now time.Duration) {
+	s := &o.spaces[sp]
+	s.lossTime = 0
+	if !s.hasAcked {
+		return
+	}
+	delay := o.lossDelay()
+	var lost *quicSentPkt
+	sent := s.sent[:0]
+	for _, p := range s.sent {
+		if p.pn > s.largestAcked {
+			sent = append(sent, p)
+			continue
+		}
+		if p.time+delay <= now || s.largestAcked >= p.pn+QUIC_PKT_THRESHOLD {
+			lost = p
+			o.onLost(s, p)
+			continue
+		}
+		if t := p.time + delay; s.lossTime == 0 || t < s.lossTime {
+			s.lossTime = t
+		}
+		sent = append(sent, p)
+	}
+	s.sent = sent
+	if lost != nil && lost.time > o.recovery {
+		// congestion event
+		o.recovery = now
+		o.ssthresh = o.cwnd / 2
+		if o.ssthresh < 2*o.maxDg {
+			o.ssthresh = 2 * o.maxDg
+		}
+		o.cwnd = o.ssthresh
+	}
+}
+
+// onLost queue the data of the packet to be sent again
+func (o *quicConn) onLost(s *quicSpace, p *quicSentPkt) {
+	o.stats.pktLost++
+	o.inflight -= p.size
+	for i := range p.recs {
+		r := &p.recs[i]
+		switch r.kind {
+		case quicRecCrypto:
+			s.crypto.lost.add(r.off, r.off+r.n)
+		case quicRecStream:
+			if _, ok := o.streams[r.s.id]; !ok || r.s.txDone {
+				continue
+			}
+			r.s.tx.lost.add(r.off, r.off+r.n)
+			if r.fin {
+				r.s.tx.finSent = false
+			}
+			o.queueStream(r.s)
+		case quicRecCtrl:
+			o.ctrl = append(o.ctrl, r.b)
+		}
+	}
+}
+
+func (o *quicConn) pto(sp int) time.Duration {
+	srtt, rttvar := o.srtt, o.rttvar
+	if !o.hasRtt {
+		srtt, rttvar = QUIC_INITIAL_RTT, QUIC_INITIAL_RTT/2
+	}
+	v := 4 * rttvar
+	if g := o.granularity(); v < g {
+		v = g
+	}
+	d := srtt + v
+	if sp == quicSpaceApp {
+		d += o.maxAckDly
+	}
+	return d << o.ptoCount
+}
+
+// setLossTimer the timer of the earliest loss time, or of the PTO
+func (o *quicConn) setLossTimer() {
+	var t time.Duration
+	for sp := range o.spaces {
+		if lt := o.spaces[sp].lossTime; lt != 0 && (t == 0 || lt < t) {
+			t = lt
+		}
+	}
+	if t == 0 {
+		for sp := range o.spaces {
+			s := &o.spaces[sp]
+			if sp == quicSpaceApp && o.state != quicStateActive {
+				continue
+			}
+			eliciting := false
+			for _, p := range s.sent {
+				if p.ackEliciting {
+					eliciting = true
+					break
+				}
+			}
+			if !eliciting {
+				continue
+			}
+			if pt := s.lastSent + o.pto(sp); t == 0 || pt < t {
+				t = pt
+			}
+		}
+	}
+	if o.lossTimer.IsRunning() {
+		o.timerw.Stop(&o.lossTimer)
+	}
+	if t == 0 {
+		return
+	}
+	d := t - o.cfg.tctx.LatNow()
+	if d < 0 {
+		d = 0
+	}
+	o.timerw.Start(&o.lossTimer, d)
+}
+
+func (o *quicConn) onTimer(t int) {
+	if o.state == quicStateClosed {
+		return
+	}
+	switch t {
+	case quicTimerIdle:
+		o.stats.idleTimeout++
+		o.terminate()
+		return
+	case quicTimerAck:
+		o.spaces[quicSpaceApp].ackNow = true
+	case quicTimerLoss:
+		now := o.cfg.tctx.LatNow()
+		lossTime := false
+		for sp := range o.spaces {
+			if lt := o.spaces[sp].lossTime; lt != 0 && lt <= now {
+				o.detectLoss(sp, now)
+				lossTime = true
+			}
+		}
+		if !lossTime {
+			o.onPto()
+		}
+	}
+	o.flush()
+}
+
+// onPto the in flight data is sent again, regardless of the congestion window
+func (o *quicConn) onPto() {
+	o.stats.pto++
+	o.ptoCount++
+	for sp := range o.spaces {
+		s := &o.spaces[sp]
+		if s.discarded || len(s.sent) == 0 {
+			continue
+		}
+		sent := s.sent[:0]
+		for _, p := range s.sent {
+			if p.ackEliciting {
+				o.stats.pktLost--
+				o.onLost(s, p)
+			} else {
+				sent = append(sent, p)
+			}
+		}
+		s.sent = sent
+		s.probe = 1
+	}
+}
+
+func (o *quicConn) restartIdle() {
+	if o.idle == 0 {
+		return
+	}
+	if o.idleTimer.IsRunning() {
+		o.timerw.Stop(&o.idleTimer)
+	}
+	o.timerw.Start(&o.idleTimer, o.idle)
+}
+
+// flush send the pending data, up to the congestion window
+func (o *quicConn) flush() {
+	if o.state == quicStateClosed {
+		return
+	}
+	for {
+		dg := o.datagram()
+		if dg == nil {
+			break
+		}
+		o.send(o.path, dg)
+	}
+	o.setLossTimer()
+}
+
+func (o *quicConn) send(p *quicPath, dg []byte) {
+	if p.closed {
+		return
+	}
+	if r, _ := p.s.Write(dg); r != transport.SeOK {
+		o.stats.dgramTxErr++
+		return
+	}
+	o.stats.dgramTx++
+	o.stats.dgramTxBytes += uint64(len(dg))
+}
+
+// canData ack-eliciting data can be sent in the space
+func (o *quicConn) canData(s *quicSpace) bool {
+	return s.probe > 0 || o.inflight < o.cwnd
+}
+
+// hasData there is data to send in the space
+func (o *quicConn) hasData(sp int) bool {
+	s := &o.spaces[sp]
+	if s.crypto.pending() {
+		return true
+	}
+	return sp == quicSpaceApp && o.state == quicStateActive && (len(o.ctrl) > 0 || len(o.sendQ) > 0)
+}
+
+// datagram the next datagram, nil in case there is nothing to send
+func (o *quicConn) datagram() []byte {
+	b := o.dg[:0]
+	var last *quicBuild
+	minSize := 0
+	for sp := 0; sp < quicSpaces; sp++ {
+		s := &o.spaces[sp]
+		if s.tx == nil {
+			continue
+		}
+		room := o.maxDg - len(b)
+		if last != nil {
+			room -= len(last.b) + quicTagLen
+		}
+		pk := o.build(sp, room)
+		if pk == nil {
+			continue
+		}
+		if sp == quicSpaceInitial && (!o.cfg.server || pk.ackEliciting) {
+			minSize = QUIC_MIN_INITIAL_SIZE
+		}
+		if sp == quicSpaceApp && o.pad && pk.ackEliciting {
+			o.pad = false
+			minSize = QUIC_MIN_INITIAL_SIZE
+		}
+		if last != nil {
+			b = o.seal(b, last, 0)
+		}
+		last = pk
+	}
+	if last == nil {
+		return nil
+	}
+	if minSize > o.maxDg {
+		minSize = o.maxDg
+	}
+	return o.seal(b, last, minSize)
+}
+
+// header the header of a packet of the space, up to the packet number
+func (o *quicConn) header(pk *quicBuild, sp int) {
+	s := &o.spaces[sp]
+	pn := s.nextPn
+	pk.pnLen = 2
+	if pn-s.largestAcked >= 1<<14 {
+		pk.pnLen = 4
+	}
+	b := pk.b[:0]
+	pk.lenOff = 0
+	if sp == quicSpaceApp {
+		b = append(b, 0x40|byte(pk.pnLen-1))
+		b = append(b, o.dcid...)
+	} else {
+		t := byte(quicPktInitial)
+		if sp == quicSpaceHandshake {
+			t = quicPktHandshake
+		}
+		b = append(b, 0xc0|t<<4|byte(pk.pnLen-1), 0, 0, 0, QUIC_VERSION_1)
+		b = append(b, byte(len(o.dcid)))
+		b = append(b, o.dcid...)
+		b = append(b, byte(len(o.scids[0].id)))
+		b = append(b, o.scids[0].id...)
+		if sp == quicSpaceInitial {
+			b = append(b, 0) // token
+		}
+		pk.lenOff = len(b)
+		b = append(b, 0, 0)
+	}
+	pk.pnOff = len(b)
+	for i := pk.pnLen - 1; i >= 0; i-- {
+		b = append(b, byte(pn>>(8*uint(i))))
+	}
+	pk.b = b
+	pk.sp = sp
+	pk.pn = pn
+	pk.ackEliciting = false
+	pk.hasAck = false
+	pk.recs = nil
+}
+
+// build the next packet of the space in up to room bytes, nil in case there is nothing to send
+func (o *quicConn) build(sp int, room int) *quicBuild {
+	s := &o.spaces[sp]
+	pk := &o.builds[sp]
+	o.header(pk, sp)
+	max := room - quicTagLen
+	if max-len(pk.b) < 32 {
+		return nil
+	}
+	canData := o.canData(s)
+	if s.unacked > 0 && (sp != quicSpaceApp || s.ackNow || (canData && o.hasData(sp))) {
+		var delay uint64
+		if sp == quicSpaceApp {
+			delay = uint64((o.cfg.tctx.LatNow()-s.largestRxT)/time.Microsecond) >> 3 // ack_delay_exponent 3
+		}
+		pk.b = quicAppendAck(pk.b, s.recv, delay, QUIC_MAX_ACK_RANGES)
+		pk.hasAck = true
+	}
+	if canData {
+		o.buildData(pk, s, max)
+	}
+	if s.probe > 0 && !pk.ackEliciting {
+		pk.b = append(pk.b, quicFramePing)
+		pk.ackEliciting = true
+	}
+	if len(pk.b) == pk.pnOff+pk.pnLen {
+		return nil
+	}
+	if pk.ackEliciting && s.probe > 0 {
+		s.probe--
+	}
+	return pk
+}
+
+// buildData the ack-eliciting frames of the packet
+func (o *quicConn) buildData(pk *quicBuild, s *quicSpace, max int) {
+	for max-len(pk.b) > 16 {
+		off, d, _, _, ok := s.crypto.next(uint64(max-len(pk.b)-16), quicMaxVarint)
+		if !ok {
+			break
+		}
+		pk.b = append(pk.b, quicFrameCrypto)
+		pk.b = quicAppendVarint(pk.b, off)
+		pk.b = quicAppendVarint(pk.b, uint64(len(d)))
+		pk.b = append(pk.b, d...)
+		pk.recs = append(pk.recs, quicRec{kind: quicRecCrypto, off: off, n: uint64(len(d))})
+		pk.ackEliciting = true
+	}
+	if pk.sp != quicSpaceApp || o.state != quicStateActive {
+		return
+	}
+
+	ctrl := o.ctrl[:0]
+	for _, f := range o.ctrl {
+		if len(f) > max-len(pk.b) {
+			ctrl = append(ctrl, f)
+			continue
+		}
+		pk.b = append(pk.b, f...)
+		if f[0] != quicFramePathResponse {
+			pk.recs = append(pk.recs, quicRec{kind: quicRecCtrl, b: f})
+		}
+		pk.ackEliciting = true
+	}
+	o.ctrl = ctrl
+
+	q := o.sendQ[:0]
+	for _, st := range o.sendQ {
+		left := max - len(pk.b) - (1 + quicVarintLen(st.id) + 8 + 2)
+		if left < 1 && !(st.tx.fin && !st.tx.finSent) || left < 0 {
+			q = append(q, st)
+			continue
+		}
+		limit := st.txMax
+		if cl := st.tx.sent + o.peerMaxData - o.txData; cl < limit {
+			limit = cl
+		}
+		off, d, fin, isNew, ok := st.tx.next(uint64(left), limit)
+		if ok {
+			o.txData += isNew
+			t := byte(quicFrameStream | quicStreamLen)
+			if off > 0 {
+				t |= quicStreamOff
+			}
+			if fin {
+				t |= quicStreamFin
+			}
+			pk.b = append(pk.b, t)
+			pk.b = quicAppendVarint(pk.b, st.id)
+			if off > 0 {
+				pk.b = quicAppendVarint(pk.b, off)
+			}
+			pk.b = quicAppendVarint(pk.b, uint64(len(d)))
+			pk.b = append(pk.b, d...)
+			pk.recs = append(pk.recs, quicRec{kind: quicRecStream, s: st, off: off, n: uint64(len(d)), fin: fin})
+			pk.ackEliciting = true
+		}
+		if st.tx.pending() {
+			q = append(q, st)
+		} else {
+			st.queued = false
+		}
+	}
+	o.sendQ = q
+}
+
+// seal protect the packet pk and append it to the datagram b, the datagram is padded to minSize
+func (o *quicConn) seal(b []byte, pk *quicBuild, minSize int) []byte {
+	s := &o.spaces[pk.sp]
+	pad := 4 - (len(pk.b) - pk.pnOff) // for the sample of the header protection
+	if n := minSize - (len(b) + len(pk.b) + quicTagLen); n > pad {
+		pad = n
+	}
+	for i := 0; i < pad; i++ {
+		pk.b = append(pk.b, quicFramePadding)
+	}
+	if pk.lenOff > 0 {
+		l := uint64(len(pk.b) - pk.pnOff + quicTagLen)
+		pk.b[pk.lenOff] = 0x40 | byte(l>>8)
+		pk.b[pk.lenOff+1] = byte(l)
+	}
+	start := len(b)
+	b = append(b, pk.b...)
+	size := len(s.tx.protect(b[start:], pk.pnOff, pk.pnLen, pk.pn))
+	b = b[:start+size]
+
+	now := o.cfg.tctx.LatNow()
+	s.nextPn++
+	if pk.hasAck {
+		s.unacked = 0
+		s.ackNow = false
+		if pk.sp == quicSpaceApp && o.ackTimer.IsRunning() {
+			o.timerw.Stop(&o.ackTimer)
+		}
+	}
+	if pk.ackEliciting || pad > 0 {
+		s.sent = append(s.sent, &quicSentPkt{pn: pk.pn, time: now, size: size, ackEliciting: pk.ackEliciting,
+			recs: pk.recs})
+		o.inflight += size
+	}
+	if pk.ackEliciting {
+		s.lastSent = now
+	}
+	switch pk.sp {
+	case quicSpaceInitial:
+		o.stats.pktTxInitial++
+	case quicSpaceHandshake:
+		o.stats.pktTxHandshake++
+		if !o.cfg.server {
+			o.discard(quicSpaceInitial)
+		}
+	default:
+		o.stats.pktTx1Rtt++
+	}
+	return b
+}
+
+// single send one packet with the frames f on the path p, it is not in flight
+func (o *quicConn) single(p *quicPath, sp int, f []byte, minSize int) {
+	pk := &o.builds[sp]
+	o.header(pk, sp)
+	pk.b = append(pk.b, f...)
+	dg := o.seal(o.dg[:0], pk, minSize)
+	s := &o.spaces[sp]
+	if n := len(s.sent); n > 0 && s.sent[n-1].pn == pk.pn {
+		o.inflight -= s.sent[n-1].size
+		s.sent = s.sent[:n-1]
+	}
+	o.send(p, dg)
+}
+
+/*
+close send CONNECTION_CLOSE and free the connection. app is true for an error code of the application (HTTP/3),
+it is sent as APPLICATION_ERROR in Initial/Handshake packets.
+*/
+func (o *quicConn) close(code uint64, app bool, reason string) {
+	if o.state == quicStateClosed {
+		return
+	}
+	o.closeCode = code
+	o.closeLocal = true
+	if o.state == quicStateActive && o.spaces[quicSpaceApp].tx != nil {
+		t := byte(quicFrameClose)
+		if app {
+			t = quicFrameCloseApp
+		}
+		f := quicAppendVarint([]byte{t}, code)
+		if !app {
+			f = append(f, 0)
+		}
+		f = quicAppendVarint(f, uint64(len(reason)))
+		f = append(f, reason...)
+		o.single(o.path, quicSpaceApp, f, 0)
+	} else {
+		if app {
+			code = 0x0c // APPLICATION_ERROR
+		}
+		f := quicAppendVarint([]byte{quicFrameClose}, code)
+		f = append(f, 0, 0)
+		for sp := quicSpaceInitial; sp <= quicSpaceHandshake; sp++ {
+			if o.spaces[sp].tx != nil {
+				o.single(o.path, sp, f, 0)
+			}
+		}
+	}
+	o.stats.closeTx++
+	o.terminate()
+}
+
+// terminate free the connection without sending
+func (o *quicConn) terminate() {
+	if o.state == quicStateClosed {
+		return
+	}
+	o.state = quicStateClosed
+	for _, t := range []*core.CHTimerObj{&o.lossTimer, &o.ackTimer, &o.idleTimer} {
+		if t.IsRunning() {
+			o.timerw.Stop(t)
+		}
+	}
+	o.tls.Close()
+	if o.cfg.cids != nil {
+		for _, c := range o.scids {
+			if o.cfg.cids[string(c.id)] == o {
+				delete(o.cfg.cids, string(c.id))
+			}
+		}
+		if o.cfg.cids[string(o.odcid)] == o {
+			delete(o.cfg.cids, string(o.odcid))
+		}
+	}
+	if o.path.conn == o {
+		o.path.close()
+	}
+	o.cfg.app.onClosed(o)
+}
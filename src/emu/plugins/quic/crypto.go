@@ -0,0 +1,229 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+/*
+Packet protection, RFC 9001 section 5. The Initial keys are derived from the first destination connection ID of
+the client, the keys of the other levels are derived from the secrets of crypto/tls.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	quicTagLen    = 16
+	quicSampleLen = 16
+)
+
+// the salt of the Initial secret of QUIC version 1
+var quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+// hkdfExpandLabel HKDF-Expand-Label of TLS 1.3 with an empty context
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, n int) []byte {
+	info := make([]byte, 0, 4+6+len(label))
+	info = append(info, byte(n>>8), byte(n), byte(6+len(label)))
+	info = append(info, "tls13 "...)
+	info = append(info, label...)
+	info = append(info, 0)
+	out := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.Expand(h, secret, info), out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// quicKeys the keys of one direction of an encryption level
+type quicKeys struct {
+	aead cipher.AEAD
+	iv   []byte
+	hp   func(sample []byte) [5]byte // the mask of the header protection
+	nbuf [12]byte
+}
+
+func newQuicKeys(suite uint16, secret []byte) (*quicKeys, error) {
+	var h func() hash.Hash
+	var keyLen int
+	switch suite {
+	case tls.TLS_AES_128_GCM_SHA256:
+		h, keyLen = sha256.New, 16
+	case tls.TLS_AES_256_GCM_SHA384:
+		h, keyLen = sha512.New384, 32
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		h, keyLen = sha256.New, 32
+	default:
+		return nil, fmt.Errorf("unsupported cipher suite 0x%04x", suite)
+	}
+	key := hkdfExpandLabel(h, secret, "quic key", keyLen)
+	hpKey := hkdfExpandLabel(h, secret, "quic hp", keyLen)
+	o := &quicKeys{iv: hkdfExpandLabel(h, secret, "quic iv", 12)}
+
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		var err error
+		if o.aead, err = chacha20poly1305.New(key); err != nil {
+			return nil, err
+		}
+		o.hp = func(sample []byte) (mask [5]byte) {
+			ks := chachaBlock(hpKey, binary.LittleEndian.Uint32(sample[:4]), sample[4:16])
+			copy(mask[:], ks[:5])
+			return
+		}
+		return o, nil
+	}
+
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if o.aead, err = cipher.NewGCM(b); err != nil {
+		return nil, err
+	}
+	hb, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, err
+	}
+	var out [16]byte
+	o.hp = func(sample []byte) (mask [5]byte) {
+		hb.Encrypt(out[:], sample[:16])
+		copy(mask[:], out[:5])
+		return
+	}
+	return o, nil
+}
+
+// quicInitialKeys the Initial keys of an endpoint, tx and rx
+func quicInitialKeys(dcid []byte, server bool) (tx, rx *quicKeys) {
+	initial := hkdf.Extract(sha256.New, dcid, quicInitialSalt)
+	client := hkdfExpandLabel(sha256.New, initial, "client in", 32)
+	srv := hkdfExpandLabel(sha256.New, initial, "server in", 32)
+	c, _ := newQuicKeys(tls.TLS_AES_128_GCM_SHA256, client)
+	s, _ := newQuicKeys(tls.TLS_AES_128_GCM_SHA256, srv)
+	if server {
+		return s, c
+	}
+	return c, s
+}
+
+func (o *quicKeys) nonce(pn uint64) []byte {
+	copy(o.nbuf[:], o.iv)
+	for i := 0; i < 8; i++ {
+		o.nbuf[11-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	return o.nbuf[:]
+}
+
+/*
+protect encrypt the packet in place. b is the header up to the end of the packet number of pnLen bytes at
+pnOff and the payload, it should have room for the tag. Returns the protected packet.
+*/
+func (o *quicKeys) protect(b []byte, pnOff, pnLen int, pn uint64) []byte {
+	hl := pnOff + pnLen
+	out := o.aead.Seal(b[hl:hl], o.nonce(pn), b[hl:], b[:hl])
+	b = b[:hl+len(out)]
+	mask := o.hp(b[pnOff+4 : pnOff+4+quicSampleLen])
+	if b[0]&0x80 != 0 {
+		b[0] ^= mask[0] & 0x0f
+	} else {
+		b[0] ^= mask[0] & 0x1f
+	}
+	for i := 0; i < pnLen; i++ {
+		b[pnOff+i] ^= mask[1+i]
+	}
+	return b
+}
+
+/*
+unprotect decrypt the packet b in place, pnOff is the offset of the packet number and largest the largest
+packet number that was received in the space. Returns the packet number and the payload.
+*/
+func (o *quicKeys) unprotect(b []byte, pnOff int, largest uint64) (pn uint64, payload []byte, err error) {
+	if len(b) < pnOff+4+quicSampleLen {
+		return 0, nil, fmt.Errorf("packet is too short")
+	}
+	mask := o.hp(b[pnOff+4 : pnOff+4+quicSampleLen])
+	if b[0]&0x80 != 0 {
+		b[0] ^= mask[0] & 0x0f
+	} else {
+		b[0] ^= mask[0] & 0x1f
+	}
+	pnLen := int(b[0]&0x3) + 1
+	var truncated uint64
+	for i := 0; i < pnLen; i++ {
+		b[pnOff+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(b[pnOff+i])
+	}
+	pn = quicDecodePn(largest, truncated, pnLen)
+	hl := pnOff + pnLen
+	payload, err = o.aead.Open(b[hl:hl], o.nonce(pn), b[hl:], b[:hl])
+	return pn, payload, err
+}
+
+// quicDecodePn the full packet number from its truncated value, RFC 9000 appendix A.3
+func quicDecodePn(largest, truncated uint64, pnLen int) uint64 {
+	expected := largest + 1
+	win := uint64(1) << (8 * uint(pnLen))
+	hwin := win / 2
+	mask := win - 1
+	candidate := (expected &^ mask) | truncated
+	if candidate+hwin <= expected && candidate < (1<<62)-win {
+		return candidate + win
+	}
+	if candidate > expected+hwin && candidate >= win {
+		return candidate - win
+	}
+	return candidate
+}
+
+// chachaBlock one block of the ChaCha20 key stream, RFC 8439, the header protection mask of RFC 9001 section 5.4.4
+func chachaBlock(key []byte, counter uint32, nonce []byte) (out [64]byte) {
+	var s, x [16]uint32
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		s[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	s[12] = counter
+	for i := 0; i < 3; i++ {
+		s[13+i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+	x = s
+	qr := func(a, b, c, d int) {
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+		x[a] += x[b]
+		x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+		x[c] += x[d]
+		x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+	}
+	for i := 0; i < 10; i++ {
+		qr(0, 4, 8, 12)
+		qr(1, 5, 9, 13)
+		qr(2, 6, 10, 14)
+		qr(3, 7, 11, 15)
+		qr(0, 5, 10, 15)
+		qr(1, 6, 11, 12)
+		qr(2, 7, 8, 13)
+		qr(3, 4, 9, 14)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+	return
+}
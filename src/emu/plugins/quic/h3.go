@@ -0,0 +1,534 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+/*
+HTTP/3 over a QUIC connection, RFC 9114.
+
+Each side opens a control stream with its SETTINGS. A request is a bidirectional stream of the client with
+HEADERS, DATA and FIN, the response is on the same stream. The field sections are QPACK (RFC 9204) with the
+static table only, the capacity of the dynamic table is zero so there are no encoder/decoder streams. Strings are
+sent without Huffman coding, Huffman coded strings of the peer are not decoded (empty value).
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// frame types
+	h3FrameData        = 0x00
+	h3FrameHeaders     = 0x01
+	h3FrameCancelPush  = 0x03
+	h3FrameSettings    = 0x04
+	h3FramePushPromise = 0x05
+	h3FrameGoaway      = 0x07
+	h3FrameMaxPushId   = 0x0d
+
+	// unidirectional stream types
+	h3StreamControl = 0x00
+
+	// settings
+	h3SettingQpackCapacity   = 0x01
+	h3SettingMaxFieldSection = 0x06
+	h3SettingQpackBlocked    = 0x07
+
+	H3_MAX_FRAME = 16384 // frames other than DATA, and the max field section
+
+	// error codes
+	H3_NO_ERROR                = 0x100
+	H3_GENERAL_PROTOCOL_ERROR  = 0x101
+	H3_STREAM_CREATION_ERROR   = 0x103
+	H3_CLOSED_CRITICAL_STREAM  = 0x104
+	H3_FRAME_UNEXPECTED        = 0x105
+	H3_FRAME_ERROR             = 0x106
+	H3_EXCESSIVE_LOAD          = 0x107
+	H3_MISSING_SETTINGS        = 0x10a
+	H3_MESSAGE_ERROR           = 0x10e
+	QPACK_DECOMPRESSION_FAILED = 0x200
+)
+
+// h3Error an HTTP/3 connection error, the connection is closed with code
+type h3Error struct {
+	code uint64
+}
+
+func (o *h3Error) Error() string {
+	return fmt.Sprintf("http/3 error 0x%x", o.code)
+}
+
+func h3Err(code uint64) error {
+	return &h3Error{code: code}
+}
+
+// h3ErrCode the code to close the connection for err
+func h3ErrCode(err error) uint64 {
+	if e, ok := err.(*h3Error); ok {
+		return e.code
+	}
+	return H3_GENERAL_PROTOCOL_ERROR
+}
+
+type qpackField struct {
+	name  string
+	value string
+}
+
+// qpackStatic the static table, RFC 9204 appendix A
+var qpackStatic = [...]qpackField{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
+
+// qpackIndex the index of each field of the static table
+var qpackIndex = func() map[qpackField]uint64 {
+	m := make(map[qpackField]uint64)
+	for i, f := range qpackStatic {
+		m[f] = uint64(i)
+	}
+	return m
+}()
+
+// qpackNameIndex the first index of each name of the static table
+var qpackNameIndex = func() map[string]uint64 {
+	m := make(map[string]uint64)
+	for i, f := range qpackStatic {
+		if _, ok := m[f.name]; !ok {
+			m[f.name] = uint64(i)
+		}
+	}
+	return m
+}()
+
+// qpackAppendInt an integer with a prefix of n bits, flags are the bits above the prefix, RFC 7541 section 5.1
+func qpackAppendInt(b []byte, flags byte, n uint, v uint64) []byte {
+	max := uint64(1)<<n - 1
+	if v < max {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(max))
+	v -= max
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func qpackReadInt(b []byte, n uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, h3Err(QPACK_DECOMPRESSION_FAILED)
+	}
+	max := uint64(1)<<n - 1
+	v := uint64(b[0]) & max
+	b = b[1:]
+	if v < max {
+		return v, b, nil
+	}
+	for m := uint(0); len(b) > 0 && m < 62; m += 7 {
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << m
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+	}
+	return 0, nil, h3Err(QPACK_DECOMPRESSION_FAILED)
+}
+
+// qpackAppendString a string with a length prefix of n bits, without Huffman coding
+func qpackAppendString(b []byte, flags byte, n uint, s string) []byte {
+	b = qpackAppendInt(b, flags, n, uint64(len(s)))
+	return append(b, s...)
+}
+
+// qpackReadString a string with a length prefix of n bits, the Huffman flag is the bit above the prefix
+func qpackReadString(b []byte, n uint) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, h3Err(QPACK_DECOMPRESSION_FAILED)
+	}
+	huff := b[0]&(1<<n) != 0
+	l, b, err := qpackReadInt(b, n)
+	if err != nil || l > uint64(len(b)) {
+		return "", nil, h3Err(QPACK_DECOMPRESSION_FAILED)
+	}
+	s := string(b[:l])
+	if huff {
+		s = ""
+	}
+	return s, b[l:], nil
+}
+
+// qpackEncode append the field section of fields, by the static table
+func qpackEncode(b []byte, fields []qpackField) []byte {
+	b = append(b, 0, 0) // required insert count and base
+	for _, f := range fields {
+		if i, ok := qpackIndex[f]; ok {
+			b = qpackAppendInt(b, 0xc0, 6, i) // indexed field line, static
+			continue
+		}
+		if i, ok := qpackNameIndex[f.name]; ok {
+			b = qpackAppendInt(b, 0x50, 4, i) // literal field line with name reference, static
+			b = qpackAppendString(b, 0, 7, f.value)
+			continue
+		}
+		b = qpackAppendString(b, 0x20, 3, f.name) // literal field line with literal name
+		b = qpackAppendString(b, 0, 7, f.value)
+	}
+	return b
+}
+
+// qpackDecode call f for each field of the field section b
+func qpackDecode(b []byte, f func(name, value string)) error {
+	ric, b, err := qpackReadInt(b, 8)
+	if err != nil || ric != 0 {
+		return h3Err(QPACK_DECOMPRESSION_FAILED) // there is no dynamic table
+	}
+	if _, b, err = qpackReadInt(b, 7); err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		c := b[0]
+		var name, value string
+		switch {
+		case c&0x80 != 0:
+			var i uint64
+			if i, b, err = qpackReadInt(b, 6); err != nil || c&0x40 == 0 || i >= uint64(len(qpackStatic)) {
+				return h3Err(QPACK_DECOMPRESSION_FAILED)
+			}
+			name, value = qpackStatic[i].name, qpackStatic[i].value
+		case c&0x40 != 0:
+			var i uint64
+			if i, b, err = qpackReadInt(b, 4); err != nil || c&0x10 == 0 || i >= uint64(len(qpackStatic)) {
+				return h3Err(QPACK_DECOMPRESSION_FAILED)
+			}
+			name = qpackStatic[i].name
+			if value, b, err = qpackReadString(b, 7); err != nil {
+				return err
+			}
+		case c&0x20 != 0:
+			if name, b, err = qpackReadString(b, 3); err != nil {
+				return err
+			}
+			if value, b, err = qpackReadString(b, 7); err != nil {
+				return err
+			}
+		default:
+			return h3Err(QPACK_DECOMPRESSION_FAILED) // post-base index
+		}
+		f(name, value)
+	}
+	return nil
+}
+
+// h3AppendFrame a frame with the payload p
+func h3AppendFrame(b []byte, t uint64, p []byte) []byte {
+	b = quicAppendVarint(b, t)
+	b = quicAppendVarint(b, uint64(len(p)))
+	return append(b, p...)
+}
+
+// h3AppendData a DATA frame of n bytes of the body
+func h3AppendData(b []byte, n int) []byte {
+	b = quicAppendVarint(b, h3FrameData)
+	b = quicAppendVarint(b, uint64(n))
+	for n > 0 {
+		c := n
+		if c > len(quicBody) {
+			c = len(quicBody)
+		}
+		b = append(b, quicBody[:c]...)
+		n -= c
+	}
+	return b
+}
+
+// h3Parser the frames of a stream, DATA is not buffered
+type h3Parser struct {
+	hdr     []byte // partial type and length
+	ftype   uint64
+	left    uint64 // of the payload of the current frame
+	inFrame bool
+	buf     []byte
+}
+
+/*
+parse call onFrame for each complete frame other than DATA and onData with the size of the payload of DATA
+frames.
+*/
+func (o *h3Parser) parse(d []byte, onFrame func(t uint64, p []byte) error, onData func(n int) error) error {
+	for len(d) > 0 {
+		if !o.inFrame {
+			o.hdr = append(o.hdr, d[0])
+			d = d[1:]
+			t, n := quicReadVarint(o.hdr)
+			if n == 0 {
+				continue
+			}
+			l, m := quicReadVarint(o.hdr[n:])
+			if m == 0 {
+				continue
+			}
+			o.hdr = o.hdr[:0]
+			if t != h3FrameData && l > H3_MAX_FRAME {
+				return h3Err(H3_EXCESSIVE_LOAD)
+			}
+			o.ftype, o.left, o.inFrame = t, l, true
+			o.buf = o.buf[:0]
+		} else {
+			n := uint64(len(d))
+			if n > o.left {
+				n = o.left
+			}
+			if o.ftype == h3FrameData {
+				if err := onData(int(n)); err != nil {
+					return err
+				}
+			} else {
+				o.buf = append(o.buf, d[:n]...)
+			}
+			o.left -= n
+			d = d[n:]
+		}
+		if o.inFrame && o.left == 0 {
+			o.inFrame = false
+			if o.ftype != h3FrameData {
+				if err := onFrame(o.ftype, o.buf); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// eof the stream ended, in the middle of a frame is an error
+func (o *h3Parser) eof() error {
+	if o.inFrame || len(o.hdr) > 0 {
+		return h3Err(H3_FRAME_ERROR)
+	}
+	return nil
+}
+
+// h3Stream the HTTP/3 state of a stream, ext of quicStream
+type h3Stream struct {
+	typ     int64 // of a unidirectional stream of the peer, -1 until it is received
+	tb      []byte
+	parser  h3Parser
+	headers bool // the HEADERS of the message were received
+	status  int
+	head    bool
+	body    uint64
+	start   time.Duration // of the request, by the client
+}
+
+func h3GetStream(st *quicStream) *h3Stream {
+	if st.ext == nil {
+		st.ext = &h3Stream{typ: -1}
+	}
+	return st.ext.(*h3Stream)
+}
+
+// h3Conn the HTTP/3 state of a connection
+type h3Conn struct {
+	c        *quicConn
+	ctrl     bool // the control stream of the peer
+	settings bool // the SETTINGS of the peer
+	goaway   bool
+}
+
+// open the control stream with our SETTINGS
+func (o *h3Conn) open() {
+	st := o.c.openStream(true)
+	if st == nil {
+		return
+	}
+	var s []byte
+	s = quicAppendVarint(s, h3SettingQpackCapacity)
+	s = quicAppendVarint(s, 0)
+	s = quicAppendVarint(s, h3SettingMaxFieldSection)
+	s = quicAppendVarint(s, H3_MAX_FRAME)
+	s = quicAppendVarint(s, h3SettingQpackBlocked)
+	s = quicAppendVarint(s, 0)
+	b := h3AppendFrame([]byte{h3StreamControl}, h3FrameSettings, s)
+	o.c.write(st, b, false)
+}
+
+// onUni data of a unidirectional stream of the peer
+func (o *h3Conn) onUni(st *quicStream, d []byte, fin bool) error {
+	hs := h3GetStream(st)
+	for hs.typ < 0 && len(d) > 0 {
+		hs.tb = append(hs.tb, d[0])
+		d = d[1:]
+		if v, n := quicReadVarint(hs.tb); n > 0 {
+			hs.typ = int64(v)
+			if v == h3StreamControl {
+				if o.ctrl {
+					return h3Err(H3_STREAM_CREATION_ERROR)
+				}
+				o.ctrl = true
+			}
+		}
+	}
+	if hs.typ != h3StreamControl {
+		return nil // QPACK, push and reserved streams are ignored
+	}
+	if fin {
+		return h3Err(H3_CLOSED_CRITICAL_STREAM)
+	}
+	return hs.parser.parse(d, o.onCtrlFrame, func(n int) error {
+		return h3Err(H3_FRAME_UNEXPECTED)
+	})
+}
+
+func (o *h3Conn) onCtrlFrame(t uint64, p []byte) error {
+	if !o.settings && t != h3FrameSettings {
+		return h3Err(H3_MISSING_SETTINGS)
+	}
+	switch t {
+	case h3FrameSettings:
+		if o.settings {
+			return h3Err(H3_FRAME_UNEXPECTED)
+		}
+		o.settings = true
+		r := quicReader{b: p}
+		for len(r.b) > 0 {
+			r.varint()
+			r.varint()
+		}
+		if r.err != nil {
+			return h3Err(H3_FRAME_ERROR)
+		}
+	case h3FrameHeaders, h3FramePushPromise:
+		return h3Err(H3_FRAME_UNEXPECTED)
+	case h3FrameGoaway:
+		o.goaway = true
+	}
+	return nil
+}
+
+// h3RequestFrame a frame of a request stream, other than DATA. HEADERS is decoded by f.
+func h3RequestFrame(hs *h3Stream, t uint64, p []byte, f func(name, value string)) error {
+	switch t {
+	case h3FrameHeaders:
+		if hs.headers {
+			return nil // trailers
+		}
+		hs.headers = true
+		return qpackDecode(p, f)
+	case h3FrameCancelPush, h3FrameSettings, h3FrameGoaway, h3FrameMaxPushId:
+		return h3Err(H3_FRAME_UNEXPECTED)
+	}
+	return nil
+}
+
+// h3Status the value of :status
+func h3Status(v string) int {
+	s, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return s
+}
@@ -0,0 +1,480 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+/*
+QUIC version 1 wire format, RFC 9000: variable-length integers, packet headers, frames and transport parameters.
+*/
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	QUIC_VERSION_1 = 0x00000001
+
+	// long header packet types
+	quicPktInitial   = 0
+	quicPkt0Rtt      = 1
+	quicPktHandshake = 2
+	quicPktRetry     = 3
+
+	// packet number spaces
+	quicSpaceInitial   = 0
+	quicSpaceHandshake = 1
+	quicSpaceApp       = 2
+	quicSpaces         = 3
+
+	// frame types
+	quicFramePadding         = 0x00
+	quicFramePing            = 0x01
+	quicFrameAck             = 0x02
+	quicFrameAckEcn          = 0x03
+	quicFrameResetStream     = 0x04
+	quicFrameStopSending     = 0x05
+	quicFrameCrypto          = 0x06
+	quicFrameNewToken        = 0x07
+	quicFrameStream          = 0x08 // 0x08-0x0f
+	quicFrameMaxData         = 0x10
+	quicFrameMaxStreamData   = 0x11
+	quicFrameMaxStreamsBidi  = 0x12
+	quicFrameMaxStreamsUni   = 0x13
+	quicFrameDataBlocked     = 0x14
+	quicFrameStreamBlocked   = 0x15
+	quicFrameStreamsBlockedB = 0x16
+	quicFrameStreamsBlockedU = 0x17
+	quicFrameNewCid          = 0x18
+	quicFrameRetireCid       = 0x19
+	quicFramePathChallenge   = 0x1a
+	quicFramePathResponse    = 0x1b
+	quicFrameClose           = 0x1c
+	quicFrameCloseApp        = 0x1d
+	quicFrameHandshakeDone   = 0x1e
+
+	// bits of the STREAM frame type
+	quicStreamFin = 0x01
+	quicStreamLen = 0x02
+	quicStreamOff = 0x04
+
+	// transport error codes
+	QUIC_NO_ERROR                  = 0x00
+	QUIC_INTERNAL_ERROR            = 0x01
+	QUIC_FLOW_CONTROL_ERROR        = 0x03
+	QUIC_STREAM_LIMIT_ERROR        = 0x04
+	QUIC_STREAM_STATE_ERROR        = 0x05
+	QUIC_FRAME_ENCODING_ERROR      = 0x07
+	QUIC_TRANSPORT_PARAMETER_ERROR = 0x08
+	QUIC_PROTOCOL_VIOLATION        = 0x0a
+	QUIC_CRYPTO_ERROR              = 0x100 // + TLS alert
+
+	// transport parameters
+	quicTpOrigDcid         = 0x00
+	quicTpIdleTimeout      = 0x01
+	quicTpResetToken       = 0x02
+	quicTpMaxUdpPayload    = 0x03
+	quicTpMaxData          = 0x04
+	quicTpMaxStreamBidiLoc = 0x05
+	quicTpMaxStreamBidiRem = 0x06
+	quicTpMaxStreamUni     = 0x07
+	quicTpMaxStreamsBidi   = 0x08
+	quicTpMaxStreamsUni    = 0x09
+	quicTpAckDelayExp      = 0x0a
+	quicTpMaxAckDelay      = 0x0b
+	quicTpActiveCidLimit   = 0x0e
+	quicTpInitialScid      = 0x0f
+
+	quicMaxVarint = (1 << 62) - 1
+)
+
+var errQuicFrame = fmt.Errorf("invalid frame")
+
+func quicVarintLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	}
+	return 8
+}
+
+func quicAppendVarint(b []byte, v uint64) []byte {
+	switch quicVarintLen(v) {
+	case 1:
+		return append(b, byte(v))
+	case 2:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case 4:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// quicReadVarint returns the value and its length, zero length in case b is too short
+func quicReadVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	n := 1 << (b[0] >> 6)
+	if len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n
+}
+
+// quicReader reads the fields of a frame, the first error is kept
+type quicReader struct {
+	b   []byte
+	err error
+}
+
+func (o *quicReader) varint() uint64 {
+	v, n := quicReadVarint(o.b)
+	if n == 0 {
+		o.err = errQuicFrame
+		o.b = nil
+		return 0
+	}
+	o.b = o.b[n:]
+	return v
+}
+
+func (o *quicReader) u8() uint64 {
+	if len(o.b) == 0 {
+		o.err = errQuicFrame
+		return 0
+	}
+	v := o.b[0]
+	o.b = o.b[1:]
+	return uint64(v)
+}
+
+func (o *quicReader) bytes(n uint64) []byte {
+	if uint64(len(o.b)) < n {
+		o.err = errQuicFrame
+		o.b = nil
+		return nil
+	}
+	d := o.b[:n]
+	o.b = o.b[n:]
+	return d
+}
+
+// quicRange [start, end)
+type quicRange struct {
+	start, end uint64
+}
+
+// quicRangeSet sorted ranges that do not overlap or touch
+type quicRangeSet []quicRange
+
+func (o *quicRangeSet) add(start, end uint64) {
+	if start >= end {
+		return
+	}
+	s := *o
+	i := 0
+	for i < len(s) && s[i].end < start {
+		i++
+	}
+	j := i
+	for j < len(s) && s[j].start <= end {
+		if s[j].start < start {
+			start = s[j].start
+		}
+		if s[j].end > end {
+			end = s[j].end
+		}
+		j++
+	}
+	if i == j {
+		s = append(s, quicRange{})
+		copy(s[i+1:], s[i:])
+		s[i] = quicRange{start, end}
+	} else {
+		s[i] = quicRange{start, end}
+		s = append(s[:i+1], s[j:]...)
+	}
+	*o = s
+}
+
+func (o quicRangeSet) contains(v uint64) bool {
+	for _, r := range o {
+		if v < r.start {
+			return false
+		}
+		if v < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// covers the range [start, end) is in the set
+func (o quicRangeSet) covers(start, end uint64) bool {
+	for _, r := range o {
+		if start < r.start {
+			return false
+		}
+		if end <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// pop remove up to n bytes from the first range
+func (o *quicRangeSet) pop(n uint64) (r quicRange, ok bool) {
+	if len(*o) == 0 {
+		return r, false
+	}
+	s := *o
+	r = s[0]
+	if r.end-r.start > n {
+		r.end = r.start + n
+		s[0].start = r.end
+	} else {
+		*o = s[1:]
+	}
+	return r, true
+}
+
+// quicHdr the public fields of a packet
+type quicHdr struct {
+	long    bool
+	ptype   uint8
+	version uint32
+	dcid    []byte
+	scid    []byte
+	token   []byte
+	pnOff   int // offset of the packet number
+	length  int // the length of the packet in the datagram
+}
+
+// quicParseHdr parse the header of the first packet of b, dcidLen is the length of the connection ID of short headers
+func quicParseHdr(b []byte, dcidLen int) (h quicHdr, err error) {
+	if len(b) < 1+dcidLen {
+		return h, fmt.Errorf("packet is too short")
+	}
+	if b[0]&0x80 == 0 {
+		if b[0]&0x40 == 0 {
+			return h, fmt.Errorf("fixed bit is zero")
+		}
+		h.dcid = b[1 : 1+dcidLen]
+		h.pnOff = 1 + dcidLen
+		h.length = len(b)
+		return h, nil
+	}
+
+	h.long = true
+	if len(b) < 7 {
+		return h, fmt.Errorf("long header is too short")
+	}
+	h.version = binary.BigEndian.Uint32(b[1:5])
+	h.ptype = (b[0] >> 4) & 0x3
+	r := quicReader{b: b[5:]}
+	h.dcid = r.bytes(r.u8())
+	h.scid = r.bytes(r.u8())
+	if r.err != nil || len(h.dcid) > 20 || len(h.scid) > 20 {
+		return h, fmt.Errorf("invalid connection ID")
+	}
+	if h.version == 0 {
+		// version negotiation, the rest is the supported versions
+		h.length = len(b)
+		return h, nil
+	}
+	if h.version != QUIC_VERSION_1 {
+		h.length = len(b)
+		return h, nil
+	}
+	if h.ptype == quicPktRetry {
+		h.length = len(b)
+		return h, nil
+	}
+	if h.ptype == quicPktInitial {
+		h.token = r.bytes(r.varint())
+	}
+	length := r.varint()
+	if r.err != nil || length > uint64(len(r.b)) {
+		return h, fmt.Errorf("invalid length")
+	}
+	h.pnOff = len(b) - len(r.b)
+	h.length = h.pnOff + int(length)
+	return h, nil
+}
+
+// quicVersionNeg a version negotiation packet in response to a long header packet, that supports version 1 only
+func quicVersionNeg(dcid, scid []byte, first byte) []byte {
+	b := make([]byte, 0, 16+len(dcid)+len(scid))
+	b = append(b, 0x80|first, 0, 0, 0, 0, byte(len(dcid)))
+	b = append(b, dcid...)
+	b = append(b, byte(len(scid)))
+	b = append(b, scid...)
+	return append(b, 0, 0, 0, QUIC_VERSION_1)
+}
+
+func quicAppendAck(b []byte, recv quicRangeSet, delay uint64, maxRanges int) []byte {
+	n := len(recv)
+	first := n - maxRanges
+	if first < 0 {
+		first = 0
+	}
+	last := recv[n-1]
+	b = append(b, quicFrameAck)
+	b = quicAppendVarint(b, last.end-1)
+	b = quicAppendVarint(b, delay)
+	b = quicAppendVarint(b, uint64(n-1-first))
+	b = quicAppendVarint(b, last.end-1-last.start)
+	for i := n - 2; i >= first; i-- {
+		r := recv[i]
+		b = quicAppendVarint(b, recv[i+1].start-r.end-1)
+		b = quicAppendVarint(b, r.end-1-r.start)
+	}
+	return b
+}
+
+// quicReadAck the acknowledged ranges of an ACK frame, the type was read
+func quicReadAck(r *quicReader, ecn bool) (ranges quicRangeSet, delay uint64, err error) {
+	largest := r.varint()
+	delay = r.varint()
+	count := r.varint()
+	first := r.varint()
+	if r.err != nil || first > largest {
+		return nil, 0, errQuicFrame
+	}
+	smallest := largest - first
+	ranges.add(smallest, largest+1)
+	for i := uint64(0); i < count; i++ {
+		gap := r.varint()
+		l := r.varint()
+		if r.err != nil || smallest < gap+2 || smallest-gap-2 < l {
+			return nil, 0, errQuicFrame
+		}
+		largest = smallest - gap - 2
+		smallest = largest - l
+		ranges.add(smallest, largest+1)
+	}
+	if ecn {
+		r.varint()
+		r.varint()
+		r.varint()
+	}
+	return ranges, delay, r.err
+}
+
+// quicParams the transport parameters
+type quicParams struct {
+	origDcid         []byte
+	initialScid      []byte
+	hasOrigDcid      bool
+	hasInitialScid   bool
+	idleTimeout      uint64 // msec
+	maxUdpPayload    uint64
+	maxData          uint64
+	maxStreamBidiLoc uint64
+	maxStreamBidiRem uint64
+	maxStreamUni     uint64
+	maxStreamsBidi   uint64
+	maxStreamsUni    uint64
+	ackDelayExp      uint64
+	maxAckDelay      uint64 // msec
+	activeCidLimit   uint64
+}
+
+func quicAppendParam(b []byte, id uint64, v uint64) []byte {
+	b = quicAppendVarint(b, id)
+	b = quicAppendVarint(b, uint64(quicVarintLen(v)))
+	return quicAppendVarint(b, v)
+}
+
+func quicAppendParamBytes(b []byte, id uint64, v []byte) []byte {
+	b = quicAppendVarint(b, id)
+	b = quicAppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (o *quicParams) encode(server bool) []byte {
+	var b []byte
+	if server {
+		b = quicAppendParamBytes(b, quicTpOrigDcid, o.origDcid)
+	}
+	b = quicAppendParam(b, quicTpIdleTimeout, o.idleTimeout)
+	b = quicAppendParam(b, quicTpMaxUdpPayload, o.maxUdpPayload)
+	b = quicAppendParam(b, quicTpMaxData, o.maxData)
+	b = quicAppendParam(b, quicTpMaxStreamBidiLoc, o.maxStreamBidiLoc)
+	b = quicAppendParam(b, quicTpMaxStreamBidiRem, o.maxStreamBidiRem)
+	b = quicAppendParam(b, quicTpMaxStreamUni, o.maxStreamUni)
+	b = quicAppendParam(b, quicTpMaxStreamsBidi, o.maxStreamsBidi)
+	b = quicAppendParam(b, quicTpMaxStreamsUni, o.maxStreamsUni)
+	b = quicAppendParam(b, quicTpActiveCidLimit, o.activeCidLimit)
+	return quicAppendParamBytes(b, quicTpInitialScid, o.initialScid)
+}
+
+func (o *quicParams) decode(b []byte) error {
+	*o = quicParams{maxUdpPayload: 65527, ackDelayExp: 3, maxAckDelay: 25, activeCidLimit: 2}
+	r := quicReader{b: b}
+	for len(r.b) > 0 {
+		id := r.varint()
+		v := r.bytes(r.varint())
+		if r.err != nil {
+			return fmt.Errorf("invalid transport parameters")
+		}
+		switch id {
+		case quicTpOrigDcid:
+			o.origDcid = v
+			o.hasOrigDcid = true
+			continue
+		case quicTpInitialScid:
+			o.initialScid = v
+			o.hasInitialScid = true
+			continue
+		case quicTpIdleTimeout, quicTpMaxUdpPayload, quicTpMaxData, quicTpMaxStreamBidiLoc, quicTpMaxStreamBidiRem,
+			quicTpMaxStreamUni, quicTpMaxStreamsBidi, quicTpMaxStreamsUni, quicTpAckDelayExp, quicTpMaxAckDelay,
+			quicTpActiveCidLimit:
+		default:
+			continue // unknown or not used
+		}
+		n, l := quicReadVarint(v)
+		if l == 0 || l != len(v) {
+			return fmt.Errorf("invalid transport parameter 0x%x", id)
+		}
+		switch id {
+		case quicTpIdleTimeout:
+			o.idleTimeout = n
+		case quicTpMaxUdpPayload:
+			o.maxUdpPayload = n
+		case quicTpMaxData:
+			o.maxData = n
+		case quicTpMaxStreamBidiLoc:
+			o.maxStreamBidiLoc = n
+		case quicTpMaxStreamBidiRem:
+			o.maxStreamBidiRem = n
+		case quicTpMaxStreamUni:
+			o.maxStreamUni = n
+		case quicTpMaxStreamsBidi:
+			o.maxStreamsBidi = n
+		case quicTpMaxStreamsUni:
+			o.maxStreamsUni = n
+		case quicTpAckDelayExp:
+			o.ackDelayExp = n
+		case quicTpMaxAckDelay:
+			o.maxAckDelay = n
+		case quicTpActiveCidLimit:
+			o.activeCidLimit = n
+		}
+	}
+	if o.maxUdpPayload < 1200 || o.ackDelayExp > 20 || o.maxAckDelay >= 1<<14 || o.activeCidLimit < 2 {
+		return fmt.Errorf("invalid transport parameters")
+	}
+	return nil
+}
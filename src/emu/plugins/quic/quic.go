@@ -0,0 +1,1262 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+/*
+QUIC (RFC 9000) and HTTP/3 (RFC 9114) client and server over the UDP sockets of the emu transport
+
+Each client runs a request profile toward the server address, like the http plugin. The requests are sent on
+concurrent streams of one connection (keepalive), up to streams requests in flight. A new request is sent after
+think_time msec from the response, or immediately in case it is zero. Without keepalive each request opens a new
+connection. The client stops after requests requests, zero means forever.
+
+The connection moves to the new address of the client in case it was changed (connection migration), the requests
+in flight continue on the new path.
+
+client init json:
+
+	{
+		"addr": "48.0.0.1:443",         // the server, there is no request profile in case it is not provided
+		"tls": {"sni": "www.emu.com"},  // transport TlsCfg, TLS 1.3 only, the default alpn is h3
+		"method": "GET",
+		"url": "/index.html",
+		"engines": [],                  // url engine, see the http plugin
+		"headers": {"accept": "text/html"},
+		"body_size": [{"size": 0, "prob": 80}, {"size": 1024, "prob": 20}],
+		"think_time": 0,
+		"requests": 0,
+		"keepalive": true,
+		"streams": 1,                   // concurrent requests
+		"timeo": 10,                    // sec for the handshake or without a response, the connection is closed
+		"listen": false                 // serve the namespace server on this client
+	}
+
+The server is defined per namespace, it is served by each client that was created with listen. It answers each
+request with a status and an object size picked from responses by the probability of each entry.
+
+namespace init json:
+
+	{
+		"server": {
+			"port": 443,
+			"tls": {"cert": "..", "key": ".."},
+			"responses": [{"status": 200, "size": 1024, "prob": 90}, {"status": 404, "size": 0, "prob": 10}],
+			"headers": {"cache-control": "no-cache"}
+		}
+	}
+
+Not supported: 0-RTT, Retry, key update, server push, the QPACK dynamic table.
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"emu/core"
+	engines "emu/plugins/field_engine"
+	"emu/plugins/http"
+	"emu/plugins/transport"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	QUIC_PLUG = "quic"
+
+	QUIC_DEF_PORT        = 443
+	QUIC_DEF_TIMEO       = 10
+	QUIC_DEF_IDLE        = 30 * time.Second
+	QUIC_DEF_OBJECT_SIZE = 1024
+	QUIC_ALPN            = "h3"
+	QUIC_URL_ENGINE      = "url"
+	QUIC_USER_AGENT      = "trex-emu"
+
+	// delay of a new connection after a connection that failed
+	QUIC_RECONNECT_DELAY = time.Second
+
+	// state of each client
+	QUIC_STATE_INIT       = 1 // not started, invalid configuration or server only
+	QUIC_STATE_CONNECTING = 2
+	QUIC_STATE_ACTIVE     = 3 // connected, sending requests
+	QUIC_STATE_WAIT       = 4 // not connected, waiting for the next connection
+	QUIC_STATE_DONE       = 5 // all the requests were sent
+
+	// timers of the client
+	quicTimerThink = 1
+	quicTimerTimeo = 2
+)
+
+// quicBody the content of the bodies
+var quicBody = func() []byte {
+	b := make([]byte, 16384)
+	for i := range b {
+		b[i] = 'a' + byte(i%26)
+	}
+	return b
+}()
+
+type QuicClientStats struct {
+	invalidInitJson uint64
+	invalidEngine   uint64
+	invalidUrl      uint64
+	invalidSocket   uint64
+	invalidTls      uint64
+	connOpen        uint64
+	connEstablished uint64
+	connErr         uint64
+	migrateErr      uint64
+	streamsBlocked  uint64
+	reqTx           uint64
+	reqTxBytes      uint64
+	respRx          uint64
+	respRxBodyBytes uint64
+	respParserErr   uint64
+	respTimeout     uint64
+	respAborted     uint64
+	respReset       uint64
+}
+
+func NewQuicClientStatsDb(o *QuicClientStats) *core.CCounterDb {
+	db := core.NewCCounterDb(QUIC_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidEngine,
+		Name:     "invalidEngine",
+		Help:     "Error creating the field engines or there is no url engine",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidUrl,
+		Name:     "invalidUrl",
+		Help:     "Invalid URL from the url engine",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidSocket,
+		Name:     "invalidSocket",
+		Help:     "Error creating socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidTls,
+		Name:     "invalidTls",
+		Help:     "Invalid TLS configuration, QUIC requires TLS 1.3",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connOpen,
+		Name:     "connOpen",
+		Help:     "Connections opened",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connEstablished,
+		Name:     "connEstablished",
+		Help:     "Connections with a complete handshake",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connErr,
+		Name:     "connErr",
+		Help:     "Connections closed before the handshake was complete",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.migrateErr,
+		Name:     "migrateErr",
+		Help:     "The connection could not move to the new address",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.streamsBlocked,
+		Name:     "streamsBlocked",
+		Help:     "A request waits for the streams limit of the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqTx,
+		Name:     "reqTx",
+		Help:     "Requests sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqTxBytes,
+		Name:     "reqTxBytes",
+		Help:     "Bytes of the requests, frames of the headers and body",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respRx,
+		Name:     "respRx",
+		Help:     "Responses received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respRxBodyBytes,
+		Name:     "respRxBodyBytes",
+		Help:     "Bytes of the bodies of the responses",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respParserErr,
+		Name:     "respParserErr",
+		Help:     "Invalid response or HTTP/3 error, the connection is closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTimeout,
+		Name:     "respTimeout",
+		Help:     "Requests without a response in timeo",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respAborted,
+		Name:     "respAborted",
+		Help:     "Requests that were pending when the connection was closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respReset,
+		Name:     "respReset",
+		Help:     "Requests that were reset by the server",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	return db
+}
+
+type QuicServerStats struct {
+	invalidInitJson uint64
+	invalidServer   uint64
+	connAccept      uint64
+	connErr         uint64
+	pktUnknownCid   uint64
+	versionNeg      uint64
+	reqRx           uint64
+	reqRxBodyBytes  uint64
+	reqParserErr    uint64
+	respTx          uint64
+	respTxBytes     uint64
+}
+
+func NewQuicServerStatsDb(o *QuicServerStats) *core.CCounterDb {
+	db := core.NewCCounterDb("quicSrv")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidServer,
+		Name:     "invalidServer",
+		Help:     "Error listening, invalid server or TLS configuration",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connAccept,
+		Name:     "connAccept",
+		Help:     "Connections accepted",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.connErr,
+		Name:     "connErr",
+		Help:     "Connections closed before the handshake was complete",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktUnknownCid,
+		Name:     "pktUnknownCid",
+		Help:     "Packets of an unknown connection that do not open a connection",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.versionNeg,
+		Name:     "versionNeg",
+		Help:     "Version negotiation sent for an unsupported version",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqRx,
+		Name:     "reqRx",
+		Help:     "Requests received",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqRxBodyBytes,
+		Name:     "reqRxBodyBytes",
+		Help:     "Bytes of the bodies of the requests",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.reqParserErr,
+		Name:     "reqParserErr",
+		Help:     "Invalid request or HTTP/3 error, the connection is closed",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTx,
+		Name:     "respTx",
+		Help:     "Responses sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.respTxBytes,
+		Name:     "respTxBytes",
+		Help:     "Bytes of the responses, frames of the headers and body",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type QuicClientInit struct {
+	Listen    bool                 `json:"listen"`
+	Addr      string               `json:"addr"`
+	Tls       *transport.TlsCfg    `json:"tls"`
+	Method    string               `json:"method" validate:"oneof=GET HEAD POST PUT DELETE OPTIONS"`
+	Url       string               `json:"url"`
+	Engines   *fastjson.RawMessage `json:"engines"`
+	Headers   map[string]string    `json:"headers"`
+	BodySize  []http.HttpSizeEntry `json:"body_size" validate:"dive"`
+	ThinkTime uint32               `json:"think_time"`
+	Requests  uint32               `json:"requests"`
+	KeepAlive bool                 `json:"keepalive"`
+	Streams   uint32               `json:"streams" validate:"gte=1,lte=100"`
+	TimeoSec  uint32               `json:"timeo" validate:"gte=1"`
+}
+
+type QuicServerInit struct {
+	Port      uint16                   `json:"port" validate:"ne=0"`
+	Tls       *transport.TlsCfg        `json:"tls" validate:"required"`
+	Responses []http.HttpResponseEntry `json:"responses" validate:"required,min=1,dive"`
+	Headers   map[string]string        `json:"headers"`
+}
+
+type QuicNsInit struct {
+	Server QuicServerInit `json:"server"`
+}
+
+// quicHeaders the headers of the configuration sorted by name, the names are lower case in HTTP/3
+func quicHeaders(m map[string]string) []qpackField {
+	names := make([]string, 0, len(m))
+	for n := range m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	f := make([]qpackField, 0, len(m))
+	for _, n := range names {
+		f = append(f, qpackField{strings.ToLower(n), m[n]})
+	}
+	return f
+}
+
+func quicHasHeader(m map[string]string, name string) bool {
+	for n := range m {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// quicTlsConfig the TLS configuration of QUIC, TLS 1.3 with h3 ALPN by default
+func quicTlsConfig(ctx *transport.TransportCtx, cfg *transport.TlsCfg, server bool) (*tls.Config, error) {
+	c, err := ctx.GetTlsConfig(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxVersion < tls.VersionTLS13 {
+		return nil, fmt.Errorf("QUIC requires TLS 1.3")
+	}
+	c = c.Clone()
+	c.MinVersion = tls.VersionTLS13
+	if len(c.NextProtos) == 0 {
+		c.NextProtos = []string{QUIC_ALPN}
+	}
+	return c, nil
+}
+
+type PluginQuicTimer struct {
+}
+
+func (o *PluginQuicTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginQuicClient)
+	pi.onTimerEvent(b.(int))
+}
+
+// PluginQuicClient information per client
+type PluginQuicClient struct {
+	core.PluginBase
+	nsPlug    *PluginQuicNs
+	cfg       QuicClientInit
+	valid     bool
+	ctx       *transport.TransportCtx
+	tlsCfg    *tls.Config
+	timerw    *core.TimerCtx
+	timerCb   PluginQuicTimer
+	think     core.CHTimerObj
+	timeo     core.CHTimerObj
+	state     uint8
+	conn      *quicConn
+	h3        h3Conn
+	connected bool
+	pending   map[*quicStream]bool
+	reqCnt    uint32
+	hdrs      []qpackField // the headers of the configuration
+	hasAgent  bool
+	host      string
+	urlEng    engines.FieldEngineIF // nil in case of a fixed url
+	urlBuf    []byte
+	bodyGen   *engines.NonUniformRandGen
+	srv       *quicServer
+	stats     QuicClientStats
+	connStats QuicConnStats
+	status    http.HttpStatusStats
+	lat       core.LatHist
+	cdb       *core.CCounterDb
+	cdbv      *core.CCounterDbVec
+}
+
+var quicEvents = []string{core.MSG_UPDATE_IPV4_ADDR}
+
+/*NewQuicClient create plugin */
+func NewQuicClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginQuicClient)
+	o.InitPluginBase(ctx, o)             /* init base object*/
+	o.RegisterEvents(ctx, quicEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(QUIC_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginQuicNs)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.state = QUIC_STATE_INIT
+	o.think.SetCB(&o.timerCb, o, quicTimerThink)
+	o.timeo.SetCB(&o.timerCb, o, quicTimerTimeo)
+	o.pending = make(map[*quicStream]bool)
+	o.cdb = NewQuicClientStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(QUIC_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.cdbv.Add(NewQuicConnStatsDb("quicConn", &o.connStats))
+	o.cdbv.Add(http.NewHttpStatusDb("quicStatus", &o.status))
+	o.cdbv.Add(core.NewLatHistDb("quicLat", "ops", &o.lat))
+
+	o.cfg = QuicClientInit{Method: "GET", Url: "/", KeepAlive: true, Streams: 1, TimeoSec: QUIC_DEF_TIMEO}
+	if err := o.Tctx.UnmarshalValidate(initJson, &o.cfg); err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.ctx = transport.GetTransportCtx(o.Client)
+	if o.ctx == nil {
+		o.stats.invalidSocket++
+		return &o.PluginBase
+	}
+	if o.cfg.Listen {
+		o.listen()
+	}
+	if o.cfg.Addr == "" {
+		return &o.PluginBase
+	}
+	if err := o.OnCreate(); err != nil {
+		return &o.PluginBase
+	}
+	o.valid = true
+	o.dial()
+	return &o.PluginBase
+}
+
+func (o *PluginQuicClient) OnCreate() error {
+	host, port, err := net.SplitHostPort(o.cfg.Addr)
+	if err != nil || net.ParseIP(host) == nil || port == "" {
+		o.stats.invalidInitJson++
+		return fmt.Errorf("invalid addr %s", o.cfg.Addr)
+	}
+	if !strings.HasPrefix(o.cfg.Url, "/") {
+		o.stats.invalidInitJson++
+		return fmt.Errorf("invalid url %s", o.cfg.Url)
+	}
+	if o.cfg.Tls == nil {
+		o.cfg.Tls = &transport.TlsCfg{}
+	}
+	if o.tlsCfg, err = quicTlsConfig(o.ctx, o.cfg.Tls, false); err != nil {
+		o.stats.invalidTls++
+		return err
+	}
+	o.host = o.cfg.Addr
+	if o.cfg.Tls.ServerName != nil {
+		o.host = *o.cfg.Tls.ServerName
+	}
+	o.hdrs = quicHeaders(o.cfg.Headers)
+	o.hasAgent = quicHasHeader(o.cfg.Headers, "User-Agent")
+	if !o.cfg.KeepAlive {
+		o.cfg.Streams = 1
+	}
+
+	if o.cfg.Engines != nil {
+		mgr := engines.NewEngineManager(o.Tctx, o.cfg.Engines)
+		if !mgr.WasCreatedSuccessfully() {
+			o.stats.invalidEngine++
+			return fmt.Errorf("invalid engines")
+		}
+		eng, ok := mgr.GetEngineMap()[QUIC_URL_ENGINE]
+		if !ok {
+			o.stats.invalidEngine++
+			return fmt.Errorf("there is no %s engine", QUIC_URL_ENGINE)
+		}
+		o.urlEng = eng
+		o.urlBuf = make([]byte, eng.GetSize())
+	}
+
+	if len(o.cfg.BodySize) > 0 {
+		probs := make([]uint32, len(o.cfg.BodySize))
+		for i := range o.cfg.BodySize {
+			probs[i] = o.cfg.BodySize[i].Prob
+		}
+		o.bodyGen, err = engines.NewNonUniformRandGen(probs)
+		if err != nil {
+			o.stats.invalidInitJson++
+			return err
+		}
+	}
+	return nil
+}
+
+/*OnEvent the connection moves to the new address of the client */
+func (o *PluginQuicClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_UPDATE_IPV4_ADDR:
+		oldIPv4 := a.(core.Ipv4Key)
+		newIPv4 := b.(core.Ipv4Key)
+		if oldIPv4 != newIPv4 && !newIPv4.IsZero() && o.conn != nil {
+			o.migrate()
+		}
+	}
+}
+
+func (o *PluginQuicClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, quicEvents)
+	o.stopTimer(&o.think)
+	o.stopTimer(&o.timeo)
+	o.state = QUIC_STATE_DONE
+	if o.conn != nil {
+		o.conn.close(H3_NO_ERROR, true, "")
+	}
+	if o.srv != nil {
+		o.unlisten()
+	}
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginQuicClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginQuicClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+func (o *PluginQuicClient) startTimer(t *core.CHTimerObj, d time.Duration) {
+	o.stopTimer(t)
+	o.timerw.Start(t, d)
+}
+
+// more there are more requests to send
+func (o *PluginQuicClient) more() bool {
+	return o.cfg.Requests == 0 || o.reqCnt < o.cfg.Requests
+}
+
+func (o *PluginQuicClient) connCfg() *quicConnCfg {
+	return &quicConnCfg{tls: o.tlsCfg, app: o, stats: &o.connStats, tctx: o.Tctx, idle: QUIC_DEF_IDLE}
+}
+
+// path a new UDP socket to the server
+func (o *PluginQuicClient) path() *quicPath {
+	p := new(quicPath)
+	s, err := o.ctx.Dial("udp", o.cfg.Addr, p, nil, nil, 0)
+	if err != nil {
+		o.stats.invalidSocket++
+		return nil
+	}
+	p.s = s
+	return p
+}
+
+func (o *PluginQuicClient) dial() {
+	p := o.path()
+	if p == nil {
+		o.state = QUIC_STATE_WAIT
+		o.startTimer(&o.think, QUIC_RECONNECT_DELAY)
+		return
+	}
+	o.stats.connOpen++
+	o.state = QUIC_STATE_CONNECTING
+	o.connected = false
+	c := newQuicClientConn(o.connCfg(), p)
+	o.conn = c
+	o.h3 = h3Conn{c: c}
+	o.startTimer(&o.timeo, time.Duration(o.cfg.TimeoSec)*time.Second)
+	c.start()
+}
+
+// migrate move the connection to a new socket from the new address
+func (o *PluginQuicClient) migrate() {
+	if !o.connected {
+		o.stats.migrateErr++
+		return
+	}
+	p := o.path()
+	if p == nil {
+		o.stats.migrateErr++
+		return
+	}
+	if !o.conn.migrate(p) {
+		o.stats.migrateErr++
+		p.close()
+	}
+}
+
+// onConnected implements quicApp
+func (o *PluginQuicClient) onConnected(c *quicConn) {
+	o.stats.connEstablished++
+	o.connected = true
+	o.state = QUIC_STATE_ACTIVE
+	o.stopTimer(&o.timeo)
+	o.h3.open()
+	o.fill()
+}
+
+// onClosed implements quicApp
+func (o *PluginQuicClient) onClosed(c *quicConn) {
+	if c != o.conn {
+		return // closed while it was created
+	}
+	if !o.connected {
+		o.stats.connErr++
+	}
+	o.stats.respAborted += uint64(len(o.pending))
+	o.pending = make(map[*quicStream]bool)
+	o.conn = nil
+	o.stopTimer(&o.timeo)
+	if o.state == QUIC_STATE_DONE {
+		return
+	}
+	if !o.more() {
+		o.state = QUIC_STATE_DONE
+		return
+	}
+	// the next connection after the think time, in case of a failure wait at least the reconnect delay
+	d := time.Duration(o.cfg.ThinkTime) * time.Millisecond
+	if !o.connected && d < QUIC_RECONNECT_DELAY {
+		d = QUIC_RECONNECT_DELAY
+	}
+	o.state = QUIC_STATE_WAIT
+	if d == 0 {
+		// not from the callback of the connection
+		d = o.timerw.TickDuration
+	}
+	o.startTimer(&o.think, d)
+}
+
+// fill send requests up to the concurrent streams
+func (o *PluginQuicClient) fill() {
+	for o.conn != nil && o.connected && !o.h3.goaway && len(o.pending) < int(o.cfg.Streams) && o.more() {
+		if !o.sendRequest() {
+			break
+		}
+	}
+	if o.conn != nil {
+		o.conn.flush()
+	}
+}
+
+// next the next request after a response, after the think time
+func (o *PluginQuicClient) next() {
+	if o.cfg.ThinkTime == 0 {
+		o.fill()
+		return
+	}
+	if !o.think.IsRunning() {
+		o.startTimer(&o.think, time.Duration(o.cfg.ThinkTime)*time.Millisecond)
+	}
+}
+
+// target the request target and the host of the next request
+func (o *PluginQuicClient) target() (target string, host string, err error) {
+	if o.urlEng == nil {
+		return o.cfg.Url, o.host, nil
+	}
+	if _, err = o.urlEng.Update(o.urlBuf); err != nil {
+		return "", "", err
+	}
+	u, err := url.Parse(string(bytes.TrimRight(o.urlBuf, "\x00")))
+	if err != nil {
+		return "", "", err
+	}
+	if u.Host == "" {
+		return u.RequestURI(), o.host, nil
+	}
+	return u.RequestURI(), u.Host, nil
+}
+
+func (o *PluginQuicClient) sendRequest() bool {
+	target, host, err := o.target()
+	if err != nil {
+		o.stats.invalidUrl++
+		o.state = QUIC_STATE_DONE
+		o.conn.close(H3_NO_ERROR, true, "")
+		return false
+	}
+	st := o.conn.openStream(false)
+	if st == nil {
+		o.stats.streamsBlocked++
+		return false
+	}
+	var bodyLen int
+	if o.bodyGen != nil {
+		bodyLen = int(o.cfg.BodySize[o.bodyGen.Generate()].Size)
+	}
+
+	f := make([]qpackField, 0, 8+len(o.hdrs))
+	f = append(f, qpackField{":method", o.cfg.Method}, qpackField{":scheme", "https"},
+		qpackField{":authority", host}, qpackField{":path", target})
+	if !o.hasAgent {
+		f = append(f, qpackField{"user-agent", QUIC_USER_AGENT})
+	}
+	if bodyLen > 0 || o.cfg.Method == "POST" || o.cfg.Method == "PUT" {
+		f = append(f, qpackField{"content-length", strconv.Itoa(bodyLen)})
+	}
+	f = append(f, o.hdrs...)
+	b := h3AppendFrame(nil, h3FrameHeaders, qpackEncode(nil, f))
+	if bodyLen > 0 {
+		b = h3AppendData(b, bodyLen)
+	}
+	o.conn.write(st, b, true)
+
+	hs := h3GetStream(st)
+	hs.head = o.cfg.Method == "HEAD"
+	hs.start = o.Tctx.LatNow()
+	o.pending[st] = true
+	o.reqCnt++
+	o.stats.reqTx++
+	o.stats.reqTxBytes += uint64(len(b))
+	if !o.timeo.IsRunning() {
+		o.startTimer(&o.timeo, time.Duration(o.cfg.TimeoSec)*time.Second)
+	}
+	return true
+}
+
+func (o *PluginQuicClient) onTimerEvent(t int) {
+	switch t {
+	case quicTimerThink:
+		if o.conn == nil {
+			o.dial()
+		} else {
+			o.fill()
+		}
+	case quicTimerTimeo:
+		// the handshake or the responses
+		o.stats.respTimeout += uint64(len(o.pending))
+		o.pending = make(map[*quicStream]bool)
+		if o.conn != nil {
+			o.conn.close(H3_NO_ERROR, true, "timeout")
+		}
+	}
+}
+
+// onStreamData implements quicApp, the responses
+func (o *PluginQuicClient) onStreamData(c *quicConn, st *quicStream, d []byte, fin bool) {
+	var err error
+	if st.uni() {
+		err = o.h3.onUni(st, d, fin)
+	} else {
+		err = o.onResponse(st, d, fin)
+	}
+	if err != nil {
+		o.stats.respParserErr++
+		c.close(h3ErrCode(err), true, "")
+	}
+}
+
+func (o *PluginQuicClient) onResponse(st *quicStream, d []byte, fin bool) error {
+	if !o.pending[st] {
+		return nil
+	}
+	if len(o.pending) > 0 {
+		o.startTimer(&o.timeo, time.Duration(o.cfg.TimeoSec)*time.Second)
+	}
+	if st.rxReset {
+		delete(o.pending, st)
+		o.stats.respReset++
+		o.next()
+		return nil
+	}
+	hs := h3GetStream(st)
+	onFrame := func(t uint64, p []byte) error {
+		return h3RequestFrame(hs, t, p, func(name, value string) {
+			if name == ":status" {
+				hs.status = h3Status(value)
+			}
+		})
+	}
+	onData := func(n int) error {
+		if !hs.headers {
+			return h3Err(H3_FRAME_UNEXPECTED)
+		}
+		hs.body += uint64(n)
+		o.stats.respRxBodyBytes += uint64(n)
+		return nil
+	}
+	if err := hs.parser.parse(d, onFrame, onData); err != nil {
+		return err
+	}
+	if !fin {
+		return nil
+	}
+	if err := hs.parser.eof(); err != nil {
+		return err
+	}
+	if hs.status < 200 {
+		return h3Err(H3_MESSAGE_ERROR)
+	}
+	delete(o.pending, st)
+	o.status.Add(hs.status)
+	o.stats.respRx++
+	o.lat.Add(o.Tctx.LatNow() - hs.start)
+	if len(o.pending) == 0 {
+		o.stopTimer(&o.timeo)
+	}
+
+	if !o.cfg.KeepAlive {
+		// the connection was for one request
+		o.conn.close(H3_NO_ERROR, true, "")
+		return nil
+	}
+	if !o.more() {
+		if len(o.pending) == 0 {
+			o.state = QUIC_STATE_DONE
+			o.conn.close(H3_NO_ERROR, true, "")
+		}
+		return nil
+	}
+	o.next()
+	return nil
+}
+
+// listen serve the namespace server on this client
+func (o *PluginQuicClient) listen() {
+	ns := o.nsPlug
+	if !ns.valid {
+		ns.stats.invalidServer++
+		return
+	}
+	tlsCfg, err := quicTlsConfig(o.ctx, ns.init.Server.Tls, true)
+	if err != nil {
+		ns.stats.invalidServer++
+		return
+	}
+	srv := newQuicServer(o, tlsCfg)
+	if err := o.ctx.Listen("udp", fmt.Sprintf(":%v", ns.init.Server.Port), srv); err != nil {
+		ns.stats.invalidServer++
+		return
+	}
+	o.srv = srv
+}
+
+func (o *PluginQuicClient) unlisten() {
+	o.ctx.UnListen("udp", fmt.Sprintf(":%v", o.nsPlug.init.Server.Port), o.srv)
+	o.srv.close()
+	o.srv = nil
+}
+
+// quicServer the server of a client, the connections are found by the connection ID of each packet
+type quicServer struct {
+	c     *PluginQuicClient
+	ns    *PluginQuicNs
+	cfg   quicConnCfg
+	cids  map[string]*quicConn
+	conns map[*quicConn]*h3Conn
+	paths map[*quicPath]bool
+}
+
+func newQuicServer(c *PluginQuicClient, tlsCfg *tls.Config) *quicServer {
+	o := &quicServer{c: c, ns: c.nsPlug}
+	o.cids = make(map[string]*quicConn)
+	o.conns = make(map[*quicConn]*h3Conn)
+	o.paths = make(map[*quicPath]bool)
+	o.cfg = quicConnCfg{server: true, tls: tlsCfg, app: o, stats: &o.ns.connStats, tctx: c.Tctx, cids: o.cids,
+		idle: QUIC_DEF_IDLE}
+	return o
+}
+
+// OnAccept implements transport.IServerSocketCb, a socket for each address of a client
+func (o *quicServer) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	p := &quicPath{s: socket, srv: o}
+	o.paths[p] = true
+	return p
+}
+
+func (o *quicServer) close() {
+	for c := range o.conns {
+		c.close(H3_NO_ERROR, true, "")
+	}
+	for p := range o.paths {
+		p.close()
+	}
+}
+
+// input a datagram of the socket of p
+func (o *quicServer) input(p *quicPath, d []byte) {
+	h, err := quicParseHdr(d, QUIC_CID_LEN)
+	if err == nil {
+		if c, ok := o.cids[string(h.dcid)]; ok {
+			c.input(p, d)
+			return
+		}
+	}
+	switch {
+	case err != nil || !h.long:
+		o.ns.stats.pktUnknownCid++ // stateless reset is not supported
+	case h.version != QUIC_VERSION_1:
+		if len(d) >= QUIC_MIN_INITIAL_SIZE {
+			o.ns.stats.versionNeg++
+			p.s.Write(quicVersionNeg(h.scid, h.dcid, d[0]))
+		}
+	case h.ptype != quicPktInitial || len(d) < QUIC_MIN_INITIAL_SIZE || len(h.dcid) < QUIC_CID_LEN:
+		o.ns.stats.pktUnknownCid++
+	default:
+		o.ns.stats.connAccept++
+		c := newQuicServerConn(&o.cfg, p, &h)
+		o.conns[c] = &h3Conn{c: c}
+		if c.start() == nil {
+			c.input(p, d)
+		}
+		return
+	}
+	if p.conn == nil {
+		p.close()
+	}
+}
+
+// onConnected implements quicApp
+func (o *quicServer) onConnected(c *quicConn) {
+	if h, ok := o.conns[c]; ok {
+		h.open()
+	}
+}
+
+// onClosed implements quicApp
+func (o *quicServer) onClosed(c *quicConn) {
+	if _, ok := o.conns[c]; ok {
+		if !c.confirmed {
+			o.ns.stats.connErr++
+		}
+		delete(o.conns, c)
+	}
+}
+
+// onStreamData implements quicApp, the requests
+func (o *quicServer) onStreamData(c *quicConn, st *quicStream, d []byte, fin bool) {
+	h, ok := o.conns[c]
+	if !ok {
+		return
+	}
+	var err error
+	if st.uni() {
+		err = h.onUni(st, d, fin)
+	} else {
+		err = o.onRequest(c, st, d, fin)
+	}
+	if err != nil {
+		o.ns.stats.reqParserErr++
+		c.close(h3ErrCode(err), true, "")
+	}
+}
+
+func (o *quicServer) onRequest(c *quicConn, st *quicStream, d []byte, fin bool) error {
+	if st.rxReset {
+		return nil
+	}
+	hs := h3GetStream(st)
+	var method bool
+	onFrame := func(t uint64, p []byte) error {
+		return h3RequestFrame(hs, t, p, func(name, value string) {
+			if name == ":method" {
+				method = true
+				hs.head = value == "HEAD"
+			}
+		})
+	}
+	onData := func(n int) error {
+		if !hs.headers {
+			return h3Err(H3_FRAME_UNEXPECTED)
+		}
+		o.ns.stats.reqRxBodyBytes += uint64(n)
+		return nil
+	}
+	headers := hs.headers
+	if err := hs.parser.parse(d, onFrame, onData); err != nil {
+		return err
+	}
+	if !headers && hs.headers && !method {
+		return h3Err(H3_MESSAGE_ERROR)
+	}
+	if !fin {
+		return nil
+	}
+	if err := hs.parser.eof(); err != nil {
+		return err
+	}
+	if !hs.headers {
+		return h3Err(H3_MESSAGE_ERROR)
+	}
+	o.ns.stats.reqRx++
+	e := &o.ns.init.Server.Responses[o.ns.respGen.Generate()]
+	o.respond(c, st, int(e.Status), int(e.Size), hs.head)
+	return nil
+}
+
+func (o *quicServer) respond(c *quicConn, st *quicStream, status int, size int, head bool) {
+	bodyless := status == 204 || status == 304
+	f := make([]qpackField, 0, 4+len(o.ns.hdrs))
+	f = append(f, qpackField{":status", strconv.Itoa(status)}, qpackField{"server", QUIC_USER_AGENT})
+	if !bodyless {
+		f = append(f, qpackField{"content-type", "application/octet-stream"},
+			qpackField{"content-length", strconv.Itoa(size)})
+	}
+	f = append(f, o.ns.hdrs...)
+	b := h3AppendFrame(nil, h3FrameHeaders, qpackEncode(nil, f))
+	if !bodyless && !head && size > 0 {
+		b = h3AppendData(b, size)
+	}
+	c.write(st, b, true)
+	o.ns.stats.respTx++
+	o.ns.stats.respTxBytes += uint64(len(b))
+	o.ns.status.Add(status)
+}
+
+// PluginQuicNs information per namespace, the server
+type PluginQuicNs struct {
+	core.PluginBase
+	init      QuicNsInit
+	valid     bool
+	respGen   *engines.NonUniformRandGen
+	hdrs      []qpackField
+	stats     QuicServerStats
+	connStats QuicConnStats
+	status    http.HttpStatusStats
+	cdb       *core.CCounterDb
+	cdbv      *core.CCounterDbVec
+}
+
+func NewQuicNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginQuicNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewQuicServerStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(QUIC_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.cdbv.Add(NewQuicConnStatsDb("quicSrvConn", &o.connStats))
+	o.cdbv.Add(http.NewHttpStatusDb("quicSrvStatus", &o.status))
+
+	o.init.Server = QuicServerInit{Port: QUIC_DEF_PORT,
+		Responses: []http.HttpResponseEntry{{Status: 200, Size: QUIC_DEF_OBJECT_SIZE, Prob: 1}}}
+	if len(initJson) == 0 {
+		// there is no server without a certificate
+		return &o.PluginBase
+	}
+	if err := o.Tctx.UnmarshalValidate(initJson, &o.init); err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	probs := make([]uint32, len(o.init.Server.Responses))
+	for i := range o.init.Server.Responses {
+		probs[i] = o.init.Server.Responses[i].Prob
+	}
+	var err error
+	o.respGen, err = engines.NewNonUniformRandGen(probs)
+	if err != nil {
+		o.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	o.hdrs = quicHeaders(o.init.Server.Headers)
+	o.valid = true
+	return &o.PluginBase
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginQuicNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginQuicNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginQuicNs) OnEvent(msg string, a, b interface{}) {
+}
+
+type PluginQuicCReg struct{}
+type PluginQuicNsReg struct{}
+
+func (o PluginQuicCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewQuicClient(ctx, initJson)
+}
+
+func (o PluginQuicNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewQuicNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiQuicNsCntHandler     struct{}
+	ApiQuicClientCntHandler struct{}
+)
+
+func getNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginQuicNs, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, QUIC_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginQuicNs), nil
+}
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginQuicClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, QUIC_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginQuicClient), nil
+}
+
+func (h ApiQuicNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	nsPlug, err := getNsPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return nsPlug.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiQuicClientCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return c.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(QUIC_PLUG,
+		core.PluginRegisterData{Client: PluginQuicCReg{},
+			Ns:     PluginQuicNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("quic_ns_cnt", ApiQuicNsCntHandler{}, true)    // server counters
+	core.RegisterCB("quic_c_cnt", ApiQuicClientCntHandler{}, true) // counters of the request profile of the client
+}
+
+func Register(ctx *core.CThreadCtx) {
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"emu/core"
+	"emu/core/testpki"
+	"emu/plugins/transport"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+type QuicTestBase struct {
+	testname   string
+	duration   time.Duration
+	seed       int64
+	nsJson     string
+	clientJson string
+	expState   uint8
+	expReq     uint32
+	listen     bool          // the server listens, otherwise the datagrams are dropped
+	migrate    time.Duration // the address of the client is changed after migrate
+}
+
+var quicTestClientMac = core.MACKey{0, 0, 1, 0, 0, 1}
+var quicTestServerMac = core.MACKey{0, 0, 1, 0, 0, 2}
+
+// the crypto is random, the counters are recorded without a capture
+func (o *QuicTestBase) Run(t *testing.T) {
+	var simVeth VethQuicSim
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	rand.Seed(o.seed)
+	tctx := createSimulationEnv(&simrx, o)
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, false)
+	tctx.MainLoopSim(o.duration)
+	defer tctx.Delete()
+
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := tctx.GetNs(&key)
+	if ns == nil {
+		t.Fatalf(" can't find ns")
+	}
+	c := ns.CLookupByMac(&quicTestClientMac)
+	plug := c.PluginCtx.Get(QUIC_PLUG).Ext.(*PluginQuicClient)
+	nsPlug := ns.PluginCtx.Get(QUIC_PLUG).Ext.(*PluginQuicNs)
+	plug.cdbv.Dump()
+	nsPlug.cdbv.Dump()
+	tctx.SimRecordAppend(plug.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(nsPlug.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(map[string]interface{}{"state": plug.state, "requests": plug.reqCnt})
+	if plug.state != o.expState || plug.reqCnt != o.expReq {
+		t.Fatalf(" expected state %v requests %v, got %v %v", o.expState, o.expReq, plug.state, plug.reqCnt)
+	}
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+// quicTestMigrate changes the address of the client
+type quicTestMigrate struct {
+	timer  core.CHTimerObj
+	ns     *core.CNSCtx
+	client *core.CClient
+}
+
+func (o *quicTestMigrate) OnEvent(a, b interface{}) {
+	o.ns.UpdateClientIpv4(o.client, core.Ipv4Key{16, 0, 0, 3})
+}
+
+func createSimulationEnv(simRx *core.VethIFSim, test *QuicTestBase) *core.CThreadCtx {
+	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+	ns.PluginCtx.CreatePlugins([]string{QUIC_PLUG}, [][]byte{[]byte(test.nsJson)})
+
+	// the packets are looped back, the default gateway of the server is the client and vice versa
+	srv := core.NewClient(ns, quicTestServerMac, core.Ipv4Key{16, 0, 0, 2}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 1})
+	srv.ForceDGW = true
+	srv.Ipv4ForcedgMac = quicTestClientMac
+	ns.AddClient(srv)
+	srvJson := []byte(`{"listen": true}`)
+	if !test.listen {
+		srvJson = []byte(`{}`)
+	}
+	srv.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, QUIC_PLUG}, [][]byte{[]byte{}, srvJson})
+
+	client := core.NewClient(ns, quicTestClientMac, core.Ipv4Key{16, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 2})
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = quicTestServerMac
+	ns.AddClient(client)
+	client.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, QUIC_PLUG}, [][]byte{[]byte{}, []byte(test.clientJson)})
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+	ns.Dump()
+
+	if test.migrate > 0 {
+		m := &quicTestMigrate{ns: ns, client: client}
+		m.timer.SetCB(m, nil, nil)
+		tctx.GetTimerCtx().Start(&m.timer, test.migrate)
+	}
+	return tctx
+}
+
+type VethQuicSim struct {
+}
+
+func (o *VethQuicSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	return m
+}
+
+// quicTestTls a self signed certificate of the server, the client does not verify it
+func quicTestTls() string {
+	c, key := testpki.SelfSigned("www.emu.test")
+	return fmt.Sprintf(`{"cert": %q, "key": %q}`, testpki.CertPem(c), testpki.KeyPem(key))
+}
+
+func TestPluginQuic1(t *testing.T) {
+	a := &QuicTestBase{
+		testname:   "quic1",
+		duration:   10 * time.Second,
+		nsJson:     fmt.Sprintf(`{"server": {"tls": %s, "responses": [{"status": 200, "size": 3000, "prob": 1}]}}`, quicTestTls()),
+		clientJson: `{"addr": "16.0.0.2:443", "tls": {"sni": "www.emu.test"}, "url": "/index.html", "requests": 3, "think_time": 500}`,
+		expState:   QUIC_STATE_DONE,
+		expReq:     3,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+// concurrent POST requests, the status and the size of the responses by their probability
+func TestPluginQuic2(t *testing.T) {
+	a := &QuicTestBase{
+		testname: "quic2",
+		duration: 60 * time.Second,
+		seed:     0x1234,
+		nsJson: fmt.Sprintf(`{"server": {"port": 8443, "tls": %s, "headers": {"Cache-Control": "no-cache"},
+			"responses": [{"status": 200, "size": 100000, "prob": 60}, {"status": 302, "size": 0, "prob": 10},
+						  {"status": 404, "size": 100, "prob": 20}, {"status": 503, "size": 0, "prob": 10}]}}`, quicTestTls()),
+		clientJson: `{"addr": "16.0.0.2:8443", "method": "POST", "url": "/upload", "requests": 100, "streams": 8,
+			"headers": {"Accept": "*/*"}, "body_size": [{"size": 0, "prob": 50}, {"size": 5000, "prob": 50}]}`,
+		expState: QUIC_STATE_DONE,
+		expReq:   100,
+		listen:   true,
+	}
+	a.Run(t)
+}
+
+// a new connection for each request
+func TestPluginQuic3(t *testing.T) {
+	a := &QuicTestBase{
+		testname:   "quic3",
+		duration:   10 * time.Second,
+		nsJson:     fmt.Sprintf(`{"server": {"tls": %s}}`, quicTestTls()),
+		clientJson: `{"addr": "16.0.0.2:443", "method": "HEAD", "requests": 4, "think_time": 100, "keepalive": false}`,
+		expState:   QUIC_STATE_DONE,
+		expReq:     4,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+// the address of the client is changed while the responses are received, the connection moves to the new path
+func TestPluginQuic4(t *testing.T) {
+	a := &QuicTestBase{
+		testname:   "quic4",
+		duration:   20 * time.Second,
+		nsJson:     fmt.Sprintf(`{"server": {"tls": %s, "responses": [{"status": 200, "size": 200000, "prob": 1}]}}`, quicTestTls()),
+		clientJson: `{"addr": "16.0.0.2:443", "requests": 10, "streams": 2}`,
+		expState:   QUIC_STATE_DONE,
+		expReq:     10,
+		listen:     true,
+		migrate:    time.Second,
+	}
+	a.Run(t)
+}
+
+// there is no server, a new connection a second after the handshake times out
+func TestPluginQuic5(t *testing.T) {
+	a := &QuicTestBase{
+		testname:   "quic5",
+		duration:   12 * time.Second,
+		nsJson:     fmt.Sprintf(`{"server": {"tls": %s}}`, quicTestTls()),
+		clientJson: `{"addr": "16.0.0.2:443", "requests": 1, "timeo": 3}`,
+		expState:   QUIC_STATE_CONNECTING,
+		expReq:     0,
+		listen:     false,
+	}
+	a.Run(t)
+}
+
+func TestPluginQuicInvalid(t *testing.T) {
+	a := &QuicTestBase{
+		testname:   "quic_invalid",
+		duration:   time.Second,
+		nsJson:     `{"server": {"responses": [{"status": 200, "size": 10, "prob": 1}]}}`,
+		clientJson: `{"addr": "16.0.0.2:443", "tls": {"max_version": "1.2"}}`,
+		expState:   QUIC_STATE_INIT,
+		expReq:     0,
+		listen:     true,
+	}
+	a.Run(t)
+}
+
+func quicTestHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// the examples of RFC 9001 appendix A
+func TestQuicKeys(t *testing.T) {
+	tx, _ := quicInitialKeys(quicTestHex("8394c8f03e515708"), false)
+	if !bytes.Equal(tx.iv, quicTestHex("fa044b2f42a3fd3b46fb255c")) {
+		t.Fatalf(" client initial iv %x", tx.iv)
+	}
+	if mask := tx.hp(quicTestHex("d1b1c98dd7689fb8ec11d242b123dc9b")); !bytes.Equal(mask[:], quicTestHex("437b9aec36")) {
+		t.Fatalf(" client initial header protection mask %x", mask)
+	}
+
+	// ChaCha20-Poly1305 short header packet
+	k, err := newQuicKeys(tls.TLS_CHACHA20_POLY1305_SHA256,
+		quicTestHex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b"))
+	if err != nil {
+		t.Fatalf(" %v", err)
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, quicTestHex("4200bff401")...)
+	p := k.protect(b, 1, 3, 654360564)
+	exp := quicTestHex("4cfe4189655e5cd55c41f69080575d7999c25a5bfb")
+	if !bytes.Equal(p, exp) {
+		t.Fatalf(" protected packet %x, expected %x", p, exp)
+	}
+	pn, payload, err := k.unprotect(p, 1, 654360563)
+	if err != nil || pn != 654360564 || !bytes.Equal(payload, []byte{1}) {
+		t.Fatalf(" unprotect %v %v %x", err, pn, payload)
+	}
+}
+
+func TestQuicQpack(t *testing.T) {
+	fields := []qpackField{{":method", "GET"}, {":scheme", "https"}, {":authority", "www.emu.test"},
+		{":path", "/index.html"}, {"x-emu", "1"}, {"content-length", "0"}}
+	b := h3AppendFrame(nil, h3FrameHeaders, qpackEncode(nil, fields))
+	b = h3AppendData(b, 20000)
+	// feed the frames in all the possible splits to two buffers
+	for split := 0; split <= len(b); split += 7 {
+		var p h3Parser
+		var got []qpackField
+		body := 0
+		onFrame := func(t uint64, f []byte) error {
+			return qpackDecode(f, func(name, value string) { got = append(got, qpackField{name, value}) })
+		}
+		onData := func(n int) error {
+			body += n
+			return nil
+		}
+		if err := p.parse(b[:split], onFrame, onData); err != nil {
+			t.Fatalf(" split %v %v", split, err)
+		}
+		if err := p.parse(b[split:], onFrame, onData); err != nil || p.eof() != nil {
+			t.Fatalf(" split %v %v", split, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(fields) || body != 20000 {
+			t.Fatalf(" split %v fields %v body %v", split, got, body)
+		}
+	}
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
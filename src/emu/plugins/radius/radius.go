@@ -0,0 +1,1172 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package radius
+
+/*
+RADIUS client, RFC 2865 (authentication) and RFC 2866 (accounting), for AAA load testing
+
+Each client sends an Access-Request to the server using PAP, CHAP, EAP-MD5 or EAP-MSCHAPv2. The EAP methods are
+the methods of the dot1x supplicant carried by EAP-Message (RFC 3579) with a Message-Authenticator.
+In case of Access-Accept the session starts, Accounting-Request Start is sent, Interim-Update every interim
+seconds and Stop after session_time seconds or by the radius_c_stop command. In case interim/session_time are
+zero, the Acct-Interim-Interval/Session-Timeout of the server are used.
+
+Each request is retransmitted after timeo seconds, up to retries times. The latency from the first transmission
+of a request to its response is counted in a histogram per namespace (msec buckets).
+
+client init json:
+
+	{
+		"server": "16.0.0.1:1812",       // required
+		"acct_server": "16.0.0.1:1813",  // no accounting in case it is not provided
+		"secret": "testing123",          // required
+		"user": "user1",                 // required
+		"password": "password1",
+		"method": "pap",                 // pap, chap, eap-md5, eap-mschapv2
+		"timeo": 3,
+		"retries": 3,
+		"interim": 0,
+		"session_time": 0,
+		"nas": {"ip": "16.0.0.1", "identifier": "emu", "port": 1, "port_type": 15,
+				"called_station_id": "00-00-02-00-00-00:emu", "calling_station_id": "00-00-01-00-00-00"}
+	}
+
+NAS-IP-Address and Calling-Station-Id are the address/MAC of the client by default.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"external/osamingo/jsonrpc"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/intel-go/fastjson"
+)
+
+const (
+	RADIUS_PLUG = "radius"
+
+	RADIUS_ACCESS_REQUEST      = 1
+	RADIUS_ACCESS_ACCEPT       = 2
+	RADIUS_ACCESS_REJECT       = 3
+	RADIUS_ACCOUNTING_REQUEST  = 4
+	RADIUS_ACCOUNTING_RESPONSE = 5
+	RADIUS_ACCESS_CHALLENGE    = 11
+
+	RADIUS_ATTR_USER_NAME             = 1
+	RADIUS_ATTR_USER_PASSWORD         = 2
+	RADIUS_ATTR_CHAP_PASSWORD         = 3
+	RADIUS_ATTR_NAS_IP_ADDRESS        = 4
+	RADIUS_ATTR_NAS_PORT              = 5
+	RADIUS_ATTR_SERVICE_TYPE          = 6
+	RADIUS_ATTR_FRAMED_IP_ADDRESS     = 8
+	RADIUS_ATTR_STATE                 = 24
+	RADIUS_ATTR_CLASS                 = 25
+	RADIUS_ATTR_SESSION_TIMEOUT       = 27
+	RADIUS_ATTR_CALLED_STATION_ID     = 30
+	RADIUS_ATTR_CALLING_STATION_ID    = 31
+	RADIUS_ATTR_NAS_IDENTIFIER        = 32
+	RADIUS_ATTR_ACCT_STATUS_TYPE      = 40
+	RADIUS_ATTR_ACCT_SESSION_ID       = 44
+	RADIUS_ATTR_ACCT_SESSION_TIME     = 46
+	RADIUS_ATTR_ACCT_TERMINATE_CAUSE  = 49
+	RADIUS_ATTR_NAS_PORT_TYPE         = 61
+	RADIUS_ATTR_EAP_MESSAGE           = 79
+	RADIUS_ATTR_MESSAGE_AUTHENTICATOR = 80
+	RADIUS_ATTR_ACCT_INTERIM_INTERVAL = 85
+
+	RADIUS_SERVICE_TYPE_FRAMED = 2
+	RADIUS_NAS_PORT_ETHERNET   = 15
+
+	RADIUS_ACCT_START   = 1
+	RADIUS_ACCT_STOP    = 2
+	RADIUS_ACCT_INTERIM = 3
+
+	RADIUS_TERMINATE_USER_REQUEST    = 1
+	RADIUS_TERMINATE_SESSION_TIMEOUT = 5
+
+	RADIUS_HEADER_LEN   = 20
+	RADIUS_AUTH_LEN     = 16
+	RADIUS_MAX_PKT_LEN  = 4096
+	RADIUS_MAX_ATTR_LEN = 253
+
+	RADIUS_DEF_TIMEO   = 3
+	RADIUS_DEF_RETRIES = 3
+
+	// state of each client
+	RADIUS_STATE_INIT    = 1 // not started, invalid configuration or socket
+	RADIUS_STATE_AUTH    = 2 // Access-Request was sent
+	RADIUS_STATE_SESSION = 3 // accepted, the session is active
+	RADIUS_STATE_REJECT  = 4
+	RADIUS_STATE_TIMEOUT = 5
+	RADIUS_STATE_DONE    = 6 // the session was stopped
+
+	// timers of the client
+	radiusTimerAuth    = 1
+	radiusTimerAcct    = 2
+	radiusTimerInterim = 3
+	radiusTimerSession = 4
+)
+
+var radiusMethods = map[string]uint8{
+	"pap":          0,
+	"chap":         0,
+	"eap-md5":      dot1x.EAP_TYPE_MD5,
+	"eap-mschapv2": dot1x.EAP_TYPE_MSCHAPV2,
+}
+
+type RadiusStats struct {
+	invalidInitJson       uint64
+	invalidSocket         uint64
+	socketWriteError      uint64
+	pktTxAccessRequest    uint64
+	pktTxAccessRetransmit uint64
+	pktTxAcctRequest      uint64
+	pktTxAcctRetransmit   uint64
+	pktRxAccessAccept     uint64
+	pktRxAccessReject     uint64
+	pktRxAccessChallenge  uint64
+	pktRxAcctResponse     uint64
+	pktRxParserErr        uint64
+	pktRxInvalidCode      uint64
+	pktRxBadAuthenticator uint64
+	pktRxBadMessageAuth   uint64
+	pktRxUnexpected       uint64
+	eapErr                uint64
+	authTimeout           uint64
+	acctTimeout           uint64
+	acctStart             uint64
+	acctInterim           uint64
+	acctInterimSkip       uint64
+	acctStop              uint64
+	sessionTimeout        uint64
+}
+
+func NewRadiusStatsDb(o *RadiusStats) *core.CCounterDb {
+	db := core.NewCCounterDb(RADIUS_PLUG)
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidInitJson,
+		Name:     "invalidInitJson",
+		Help:     "Error while decoding init Json",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.invalidSocket,
+		Name:     "invalidSocket",
+		Help:     "Error creating socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.socketWriteError,
+		Name:     "socketWriteError",
+		Help:     "Error writing in socket",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAccessRequest,
+		Name:     "pktTxAccessRequest",
+		Help:     "Access-Request sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAccessRetransmit,
+		Name:     "pktTxAccessRetransmit",
+		Help:     "Access-Request retransmitted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAcctRequest,
+		Name:     "pktTxAcctRequest",
+		Help:     "Accounting-Request sent",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktTxAcctRetransmit,
+		Name:     "pktTxAcctRetransmit",
+		Help:     "Accounting-Request retransmitted",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAccessAccept,
+		Name:     "pktRxAccessAccept",
+		Help:     "Access-Accept received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAccessReject,
+		Name:     "pktRxAccessReject",
+		Help:     "Access-Reject received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAccessChallenge,
+		Name:     "pktRxAccessChallenge",
+		Help:     "Access-Challenge received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxAcctResponse,
+		Name:     "pktRxAcctResponse",
+		Help:     "Accounting-Response received",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxParserErr,
+		Name:     "pktRxParserErr",
+		Help:     "Invalid RADIUS packet",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxInvalidCode,
+		Name:     "pktRxInvalidCode",
+		Help:     "RADIUS packet with invalid code",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadAuthenticator,
+		Name:     "pktRxBadAuthenticator",
+		Help:     "Response with wrong authenticator, wrong secret",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxBadMessageAuth,
+		Name:     "pktRxBadMessageAuth",
+		Help:     "EAP response without a valid Message-Authenticator",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.pktRxUnexpected,
+		Name:     "pktRxUnexpected",
+		Help:     "Response without a pending request or with a wrong identifier",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.eapErr,
+		Name:     "eapErr",
+		Help:     "No valid EAP response to the Access-Challenge",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.authTimeout,
+		Name:     "authTimeout",
+		Help:     "No response to Access-Request after all the retries",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acctTimeout,
+		Name:     "acctTimeout",
+		Help:     "No response to Accounting-Request after all the retries",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acctStart,
+		Name:     "acctStart",
+		Help:     "Accounting Start sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acctInterim,
+		Name:     "acctInterim",
+		Help:     "Accounting Interim-Update sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acctInterimSkip,
+		Name:     "acctInterimSkip",
+		Help:     "Interim-Update skipped, the previous request is pending",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.acctStop,
+		Name:     "acctStop",
+		Help:     "Accounting Stop sent",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.sessionTimeout,
+		Name:     "sessionTimeout",
+		Help:     "Sessions that ended by session time",
+		Unit:     "ops",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type RadiusNasInit struct {
+	Ip               *string `json:"ip"`
+	Identifier       *string `json:"identifier"`
+	Port             *uint32 `json:"port"`
+	PortType         uint32  `json:"port_type"`
+	CalledStationId  *string `json:"called_station_id"`
+	CallingStationId *string `json:"calling_station_id"`
+}
+
+type RadiusClientInit struct {
+	Server      string        `json:"server" validate:"required"`
+	AcctServer  string        `json:"acct_server"`
+	Secret      string        `json:"secret" validate:"required"`
+	User        string        `json:"user" validate:"required"`
+	Password    string        `json:"password"`
+	Method      string        `json:"method" validate:"oneof=pap chap eap-md5 eap-mschapv2"`
+	TimeoSec    uint32        `json:"timeo" validate:"gte=1"`
+	Retries     uint32        `json:"retries"`
+	InterimSec  uint32        `json:"interim"`
+	SessionTime uint32        `json:"session_time"`
+	Nas         RadiusNasInit `json:"nas"`
+}
+
+type RadiusClientInfo struct {
+	State       uint8  `json:"state"`
+	SessionId   string `json:"session_id"`
+	SessionTime uint32 `json:"session_time"`
+	Sessions    uint32 `json:"sessions"`
+}
+
+// radiusAttr one attribute of a received packet
+type radiusAttr struct {
+	t uint8
+	v []byte
+}
+
+// radiusPkt a received packet
+type radiusPkt struct {
+	code  uint8
+	id    uint8
+	attrs []radiusAttr
+	maOff int // offset of the Message-Authenticator value, zero in case there is none
+}
+
+func radiusDecode(p []byte) (*radiusPkt, error) {
+	if len(p) < RADIUS_HEADER_LEN {
+		return nil, fmt.Errorf("packet too short")
+	}
+	l := int(binary.BigEndian.Uint16(p[2:4]))
+	if l < RADIUS_HEADER_LEN || l > len(p) || l > RADIUS_MAX_PKT_LEN {
+		return nil, fmt.Errorf("invalid length %v", l)
+	}
+	o := &radiusPkt{code: p[0], id: p[1]}
+	off := RADIUS_HEADER_LEN
+	for off < l {
+		if off+2 > l {
+			return nil, fmt.Errorf("attribute too short")
+		}
+		al := int(p[off+1])
+		if al < 2 || off+al > l {
+			return nil, fmt.Errorf("invalid attribute length %v", al)
+		}
+		a := radiusAttr{t: p[off], v: p[off+2 : off+al]}
+		if a.t == RADIUS_ATTR_MESSAGE_AUTHENTICATOR && al == 2+md5.Size {
+			o.maOff = off + 2
+		}
+		o.attrs = append(o.attrs, a)
+		off += al
+	}
+	return o, nil
+}
+
+func (o *radiusPkt) get(t uint8) []byte {
+	for i := range o.attrs {
+		if o.attrs[i].t == t {
+			return o.attrs[i].v
+		}
+	}
+	return nil
+}
+
+// getAll concatenate the values of all the attributes of a type, e.g. EAP-Message
+func (o *radiusPkt) getAll(t uint8) []byte {
+	var r []byte
+	for i := range o.attrs {
+		if o.attrs[i].t == t {
+			r = append(r, o.attrs[i].v...)
+		}
+	}
+	return r
+}
+
+func (o *radiusPkt) getUint32(t uint8) uint32 {
+	v := o.get(t)
+	if len(v) != 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
+
+// radiusAppendAttr append an attribute, long values are split to several attributes
+func radiusAppendAttr(b []byte, t uint8, v []byte) []byte {
+	for {
+		n := len(v)
+		if n > RADIUS_MAX_ATTR_LEN {
+			n = RADIUS_MAX_ATTR_LEN
+		}
+		b = append(b, t, uint8(2+n))
+		b = append(b, v[:n]...)
+		v = v[n:]
+		if len(v) == 0 {
+			return b
+		}
+	}
+}
+
+func radiusAppendUint32(b []byte, t uint8, v uint32) []byte {
+	var d [4]byte
+	binary.BigEndian.PutUint32(d[:], v)
+	return radiusAppendAttr(b, t, d[:])
+}
+
+// radiusHidePassword the User-Password of PAP, c(i) = p(i) xor MD5(secret + c(i-1)), c(0) is the authenticator
+func radiusHidePassword(password []byte, secret []byte, auth []byte) []byte {
+	p := append([]byte{}, password...)
+	for len(p) == 0 || len(p)%md5.Size != 0 {
+		p = append(p, 0)
+	}
+	last := auth
+	for i := 0; i < len(p); i += md5.Size {
+		h := md5.New()
+		h.Write(secret)
+		h.Write(last)
+		b := h.Sum(nil)
+		for j := range b {
+			p[i+j] ^= b[j]
+		}
+		last = p[i : i+md5.Size]
+	}
+	return p
+}
+
+// radiusResponseAuth MD5(Code + Identifier + Length + Request Authenticator + Attributes + Secret)
+func radiusResponseAuth(p []byte, reqAuth []byte, secret []byte) []byte {
+	h := md5.New()
+	h.Write(p[0:4])
+	h.Write(reqAuth)
+	h.Write(p[RADIUS_HEADER_LEN:])
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+// radiusMessageAuth HMAC-MD5 of the packet with a zero Message-Authenticator at maOff and the authenticator auth
+func radiusMessageAuth(p []byte, maOff int, auth []byte, secret []byte) []byte {
+	c := append([]byte{}, p...)
+	copy(c[4:RADIUS_HEADER_LEN], auth)
+	for i := 0; i < md5.Size; i++ {
+		c[maOff+i] = 0
+	}
+	h := hmac.New(md5.New, secret)
+	h.Write(c)
+	return h.Sum(nil)
+}
+
+type PluginRadiusTimer struct {
+}
+
+func (o *PluginRadiusTimer) OnEvent(a, b interface{}) {
+	pi := a.(*PluginRadiusClient)
+	pi.onTimerEvent(b.(int))
+}
+
+// radiusReq a pending request, retransmitted until there is a response
+type radiusReq struct {
+	acct       bool
+	socket     transport.SocketApi
+	pkt        []byte
+	auth       [RADIUS_AUTH_LEN]byte
+	pending    bool
+	unresolved bool
+	retries    uint32
+	start      time.Duration
+	timer      core.CHTimerObj
+}
+
+// PluginRadiusClient information per client
+type PluginRadiusClient struct {
+	core.PluginBase
+	nsPlug      *PluginRadiusNs
+	cfg         RadiusClientInit
+	valid       bool
+	method      uint8 // EAP method, zero for PAP/CHAP
+	chap        bool
+	secret      []byte
+	timerw      *core.TimerCtx
+	timerCb     PluginRadiusTimer
+	authReq     radiusReq
+	acctReq     radiusReq
+	interim     core.CHTimerObj
+	sessTimer   core.CHTimerObj
+	state       uint8
+	id          uint8
+	reqCnt      uint32
+	eap         *dot1x.EapPeer
+	eapState    []byte // State attribute of the last Access-Challenge
+	class       []byte
+	interimSec  uint32
+	sessionTime uint32
+	sessionId   string
+	sessions    uint32
+	sessStart   time.Duration
+}
+
+var radiusEvents = []string{core.MSG_DG_MAC_RESOLVED}
+
+/*NewRadiusClient create plugin */
+func NewRadiusClient(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+
+	o := new(PluginRadiusClient)
+	o.InitPluginBase(ctx, o)               /* init base object*/
+	o.RegisterEvents(ctx, radiusEvents, o) /* register events, only if exits*/
+	nsplg := o.Ns.PluginCtx.GetOrCreate(RADIUS_PLUG)
+	o.nsPlug = nsplg.Ext.(*PluginRadiusNs)
+	o.timerw = o.Tctx.GetTimerCtx()
+	o.state = RADIUS_STATE_INIT
+	o.authReq.timer.SetCB(&o.timerCb, o, radiusTimerAuth)
+	o.acctReq.timer.SetCB(&o.timerCb, o, radiusTimerAcct)
+	o.acctReq.acct = true
+	o.interim.SetCB(&o.timerCb, o, radiusTimerInterim)
+	o.sessTimer.SetCB(&o.timerCb, o, radiusTimerSession)
+
+	o.cfg = RadiusClientInit{Method: "pap", TimeoSec: RADIUS_DEF_TIMEO, Retries: RADIUS_DEF_RETRIES}
+	o.cfg.Nas.PortType = RADIUS_NAS_PORT_ETHERNET
+	if err := o.Tctx.UnmarshalValidate(initJson, &o.cfg); err != nil {
+		o.nsPlug.stats.invalidInitJson++
+		return &o.PluginBase
+	}
+	if err := o.OnCreate(); err != nil {
+		return &o.PluginBase
+	}
+	o.valid = true
+	o.StartAuth()
+	return &o.PluginBase
+}
+
+func (o *PluginRadiusClient) OnCreate() error {
+	o.secret = []byte(o.cfg.Secret)
+	o.method = radiusMethods[o.cfg.Method]
+	o.chap = o.cfg.Method == "chap"
+	if o.method != 0 {
+		var err error
+		o.eap, err = dot1x.NewEapPeer(o.Tctx, o.cfg.User, o.cfg.Password, o.method)
+		if err != nil {
+			o.nsPlug.stats.invalidInitJson++
+			return err
+		}
+	}
+	if o.cfg.Nas.Ip != nil && net.ParseIP(*o.cfg.Nas.Ip).To4() == nil {
+		o.nsPlug.stats.invalidInitJson++
+		return fmt.Errorf("invalid nas ip %s", *o.cfg.Nas.Ip)
+	}
+
+	ctx := transport.GetTransportCtx(o.Client)
+	if ctx == nil {
+		o.nsPlug.stats.invalidSocket++
+		return fmt.Errorf("no transport")
+	}
+	var err error
+	o.authReq.socket, err = ctx.Dial("udp", o.cfg.Server, o, nil, nil, 0)
+	if err != nil {
+		o.nsPlug.stats.invalidSocket++
+		return err
+	}
+	if o.cfg.AcctServer != "" {
+		o.acctReq.socket, err = ctx.Dial("udp", o.cfg.AcctServer, o, nil, nil, 0)
+		if err != nil {
+			o.nsPlug.stats.invalidSocket++
+			return err
+		}
+	}
+	return nil
+}
+
+/*OnEvent support event change of IP  */
+func (o *PluginRadiusClient) OnEvent(msg string, a, b interface{}) {
+	switch msg {
+	case core.MSG_DG_MAC_RESOLVED:
+		// send the requests that are waiting for the resolution now
+		for _, req := range []*radiusReq{&o.authReq, &o.acctReq} {
+			if req.pending && req.unresolved {
+				o.write(req)
+			}
+		}
+	}
+}
+
+func (o *PluginRadiusClient) OnRemove(ctx *core.PluginCtx) {
+	ctx.UnregisterEvents(&o.PluginBase, radiusEvents)
+	o.stopTimer(&o.authReq.timer)
+	o.stopTimer(&o.acctReq.timer)
+	o.stopTimer(&o.interim)
+	o.stopTimer(&o.sessTimer)
+	for _, req := range []*radiusReq{&o.authReq, &o.acctReq} {
+		if req.socket != nil {
+			req.socket.Close()
+			req.socket = nil
+		}
+	}
+	if o.eap != nil {
+		o.eap.OnRemove()
+	}
+}
+
+func (o *PluginRadiusClient) stopTimer(t *core.CHTimerObj) {
+	if t.IsRunning() {
+		o.timerw.Stop(t)
+	}
+}
+
+func (o *PluginRadiusClient) startTimer(t *core.CHTimerObj, sec uint32) {
+	o.stopTimer(t)
+	o.timerw.Start(t, time.Duration(sec)*time.Second)
+}
+
+func (o *PluginRadiusClient) newAuthenticator(auth []byte) {
+	o.reqCnt++
+	if o.Tctx.Simulation {
+		var b [10]byte
+		copy(b[0:6], o.Client.Mac[:])
+		binary.BigEndian.PutUint32(b[6:10], o.reqCnt)
+		s := md5.Sum(b[:])
+		copy(auth, s[:])
+	} else {
+		rand.Read(auth)
+	}
+}
+
+// nasAttrs the attributes of the NAS that are added to all the requests
+func (o *PluginRadiusClient) nasAttrs(b []byte) []byte {
+	nas := &o.cfg.Nas
+	if nas.Ip != nil {
+		b = radiusAppendAttr(b, RADIUS_ATTR_NAS_IP_ADDRESS, net.ParseIP(*nas.Ip).To4())
+	} else if !o.Client.Ipv4.IsZero() {
+		b = radiusAppendAttr(b, RADIUS_ATTR_NAS_IP_ADDRESS, o.Client.Ipv4[:])
+	}
+	if nas.Identifier != nil {
+		b = radiusAppendAttr(b, RADIUS_ATTR_NAS_IDENTIFIER, []byte(*nas.Identifier))
+	}
+	if nas.Port != nil {
+		b = radiusAppendUint32(b, RADIUS_ATTR_NAS_PORT, *nas.Port)
+	}
+	b = radiusAppendUint32(b, RADIUS_ATTR_NAS_PORT_TYPE, nas.PortType)
+	if nas.CalledStationId != nil {
+		b = radiusAppendAttr(b, RADIUS_ATTR_CALLED_STATION_ID, []byte(*nas.CalledStationId))
+	}
+	if nas.CallingStationId != nil {
+		b = radiusAppendAttr(b, RADIUS_ATTR_CALLING_STATION_ID, []byte(*nas.CallingStationId))
+	} else {
+		m := o.Client.Mac
+		s := fmt.Sprintf("%02X-%02X-%02X-%02X-%02X-%02X", m[0], m[1], m[2], m[3], m[4], m[5])
+		b = radiusAppendAttr(b, RADIUS_ATTR_CALLING_STATION_ID, []byte(s))
+	}
+	return b
+}
+
+// send build the request from the attributes and send it, ma adds a Message-Authenticator
+func (o *PluginRadiusClient) send(req *radiusReq, code uint8, attrs []byte, ma bool) {
+	o.id++
+	p := make([]byte, RADIUS_HEADER_LEN, RADIUS_HEADER_LEN+len(attrs)+2+md5.Size)
+	p[0] = code
+	p[1] = o.id
+	p = append(p, attrs...)
+	maOff := 0
+	if ma {
+		p = append(p, RADIUS_ATTR_MESSAGE_AUTHENTICATOR, 2+md5.Size)
+		maOff = len(p)
+		p = append(p, make([]byte, md5.Size)...)
+	}
+	binary.BigEndian.PutUint16(p[2:4], uint16(len(p)))
+	if code == RADIUS_ACCOUNTING_REQUEST {
+		// MD5(Code + Identifier + Length + 16 zero octets + Attributes + Secret)
+		var zero [RADIUS_AUTH_LEN]byte
+		copy(req.auth[:], radiusResponseAuth(p, zero[:], o.secret))
+	}
+	copy(p[4:RADIUS_HEADER_LEN], req.auth[:])
+	if ma {
+		copy(p[maOff:], radiusMessageAuth(p, maOff, req.auth[:], o.secret))
+	}
+
+	if req.pending {
+		o.stopTimer(&req.timer)
+	}
+	req.pkt = p
+	req.pending = true
+	req.retries = 0
+	req.start = o.Tctx.LatNow()
+	if req.acct {
+		o.nsPlug.stats.pktTxAcctRequest++
+	} else {
+		o.nsPlug.stats.pktTxAccessRequest++
+	}
+	o.write(req)
+	o.startTimer(&req.timer, o.cfg.TimeoSec)
+}
+
+func (o *PluginRadiusClient) write(req *radiusReq) {
+	err, _ := req.socket.Write(req.pkt)
+	req.unresolved = err == transport.SeUNRESOLVED
+	if err != transport.SeOK {
+		o.nsPlug.stats.socketWriteError++
+	}
+}
+
+// StartAuth start a new authentication, the session is stopped in case it is active
+func (o *PluginRadiusClient) StartAuth() {
+	if o.state == RADIUS_STATE_SESSION {
+		o.StopSession(RADIUS_TERMINATE_USER_REQUEST)
+	}
+	o.state = RADIUS_STATE_AUTH
+	o.eapState = nil
+	o.class = nil
+	if o.eap != nil {
+		o.sendAccessRequest(o.eap.Identity(0))
+	} else {
+		o.sendAccessRequest(nil)
+	}
+}
+
+func (o *PluginRadiusClient) sendAccessRequest(eap []byte) {
+	req := &o.authReq
+	o.newAuthenticator(req.auth[:])
+	b := radiusAppendAttr(nil, RADIUS_ATTR_USER_NAME, []byte(o.cfg.User))
+	switch {
+	case o.eap != nil:
+		b = radiusAppendAttr(b, RADIUS_ATTR_EAP_MESSAGE, eap)
+		if o.eapState != nil {
+			b = radiusAppendAttr(b, RADIUS_ATTR_STATE, o.eapState)
+		}
+	case o.chap:
+		// the request authenticator is the challenge, CHAP-Password is ident + MD5(ident + password + challenge)
+		ident := o.id + 1
+		h := md5.New()
+		h.Write([]byte{ident})
+		h.Write([]byte(o.cfg.Password))
+		h.Write(req.auth[:])
+		b = radiusAppendAttr(b, RADIUS_ATTR_CHAP_PASSWORD, append([]byte{ident}, h.Sum(nil)...))
+	default:
+		b = radiusAppendAttr(b, RADIUS_ATTR_USER_PASSWORD, radiusHidePassword([]byte(o.cfg.Password), o.secret, req.auth[:]))
+	}
+	b = radiusAppendUint32(b, RADIUS_ATTR_SERVICE_TYPE, RADIUS_SERVICE_TYPE_FRAMED)
+	b = o.nasAttrs(b)
+	o.send(req, RADIUS_ACCESS_REQUEST, b, o.eap != nil)
+}
+
+func (o *PluginRadiusClient) sendAcct(status uint32, cause uint32) {
+	req := &o.acctReq
+	if req.socket == nil {
+		return
+	}
+	if req.pending && status == RADIUS_ACCT_INTERIM {
+		o.nsPlug.stats.acctInterimSkip++
+		return
+	}
+	b := radiusAppendUint32(nil, RADIUS_ATTR_ACCT_STATUS_TYPE, status)
+	b = radiusAppendAttr(b, RADIUS_ATTR_ACCT_SESSION_ID, []byte(o.sessionId))
+	b = radiusAppendAttr(b, RADIUS_ATTR_USER_NAME, []byte(o.cfg.User))
+	if !o.Client.Ipv4.IsZero() {
+		b = radiusAppendAttr(b, RADIUS_ATTR_FRAMED_IP_ADDRESS, o.Client.Ipv4[:])
+	}
+	if o.class != nil {
+		b = radiusAppendAttr(b, RADIUS_ATTR_CLASS, o.class)
+	}
+	switch status {
+	case RADIUS_ACCT_START:
+		o.nsPlug.stats.acctStart++
+	case RADIUS_ACCT_INTERIM:
+		o.nsPlug.stats.acctInterim++
+		b = radiusAppendUint32(b, RADIUS_ATTR_ACCT_SESSION_TIME, o.getSessionTime())
+	case RADIUS_ACCT_STOP:
+		o.nsPlug.stats.acctStop++
+		b = radiusAppendUint32(b, RADIUS_ATTR_ACCT_SESSION_TIME, o.getSessionTime())
+		b = radiusAppendUint32(b, RADIUS_ATTR_ACCT_TERMINATE_CAUSE, cause)
+	}
+	b = o.nasAttrs(b)
+	o.send(req, RADIUS_ACCOUNTING_REQUEST, b, false)
+}
+
+func (o *PluginRadiusClient) getSessionTime() uint32 {
+	if o.state != RADIUS_STATE_SESSION {
+		return 0
+	}
+	return uint32((o.Tctx.LatNow() - o.sessStart) / time.Second)
+}
+
+func (o *PluginRadiusClient) startSession(pkt *radiusPkt) {
+	o.state = RADIUS_STATE_SESSION
+	o.sessions++
+	m := o.Client.Mac
+	o.sessionId = fmt.Sprintf("%02X%02X%02X%02X%02X%02X-%d", m[0], m[1], m[2], m[3], m[4], m[5], o.sessions)
+	o.sessStart = o.Tctx.LatNow()
+	o.class = append([]byte{}, pkt.get(RADIUS_ATTR_CLASS)...)
+	if len(o.class) == 0 {
+		o.class = nil
+	}
+	o.interimSec = o.cfg.InterimSec
+	if o.interimSec == 0 {
+		o.interimSec = pkt.getUint32(RADIUS_ATTR_ACCT_INTERIM_INTERVAL)
+	}
+	o.sessionTime = o.cfg.SessionTime
+	if o.sessionTime == 0 {
+		o.sessionTime = pkt.getUint32(RADIUS_ATTR_SESSION_TIMEOUT)
+	}
+	o.sendAcct(RADIUS_ACCT_START, 0)
+	if o.interimSec > 0 && o.acctReq.socket != nil {
+		o.startTimer(&o.interim, o.interimSec)
+	}
+	if o.sessionTime > 0 {
+		o.startTimer(&o.sessTimer, o.sessionTime)
+	}
+}
+
+// StopSession send Accounting Stop and end the session
+func (o *PluginRadiusClient) StopSession(cause uint32) {
+	if o.state != RADIUS_STATE_SESSION {
+		return
+	}
+	o.stopTimer(&o.interim)
+	o.stopTimer(&o.sessTimer)
+	o.sendAcct(RADIUS_ACCT_STOP, cause)
+	o.state = RADIUS_STATE_DONE
+}
+
+func (o *PluginRadiusClient) onRetransmit(req *radiusReq) {
+	if !req.pending {
+		return
+	}
+	if req.unresolved {
+		// not sent yet, does not count as a retry
+		o.write(req)
+		o.startTimer(&req.timer, o.cfg.TimeoSec)
+		return
+	}
+	if req.retries >= o.cfg.Retries {
+		req.pending = false
+		if req.acct {
+			o.nsPlug.stats.acctTimeout++
+		} else {
+			o.nsPlug.stats.authTimeout++
+			o.state = RADIUS_STATE_TIMEOUT
+		}
+		return
+	}
+	req.retries++
+	if req.acct {
+		o.nsPlug.stats.pktTxAcctRetransmit++
+	} else {
+		o.nsPlug.stats.pktTxAccessRetransmit++
+	}
+	o.write(req)
+	o.startTimer(&req.timer, o.cfg.TimeoSec)
+}
+
+func (o *PluginRadiusClient) onTimerEvent(t int) {
+	switch t {
+	case radiusTimerAuth:
+		o.onRetransmit(&o.authReq)
+	case radiusTimerAcct:
+		o.onRetransmit(&o.acctReq)
+	case radiusTimerInterim:
+		o.sendAcct(RADIUS_ACCT_INTERIM, 0)
+		o.startTimer(&o.interim, o.interimSec)
+	case radiusTimerSession:
+		o.nsPlug.stats.sessionTimeout++
+		o.StopSession(RADIUS_TERMINATE_SESSION_TIMEOUT)
+	}
+}
+
+// OnRxEvent function to complete the ISocketCb interface.
+func (o *PluginRadiusClient) OnRxEvent(event transport.SocketEventType) {}
+
+// OnTxEvent function to complete the ISocketCb interface.
+func (o *PluginRadiusClient) OnTxEvent(event transport.SocketEventType) {}
+
+// OnRxData a response of the server, the authentication and accounting sockets
+func (o *PluginRadiusClient) OnRxData(d []byte) {
+	stats := &o.nsPlug.stats
+	pkt, err := radiusDecode(d)
+	if err != nil {
+		stats.pktRxParserErr++
+		return
+	}
+	var req *radiusReq
+	switch pkt.code {
+	case RADIUS_ACCESS_ACCEPT, RADIUS_ACCESS_REJECT, RADIUS_ACCESS_CHALLENGE:
+		req = &o.authReq
+	case RADIUS_ACCOUNTING_RESPONSE:
+		req = &o.acctReq
+	default:
+		stats.pktRxInvalidCode++
+		return
+	}
+	if !req.pending || req.pkt[1] != pkt.id {
+		stats.pktRxUnexpected++
+		return
+	}
+	p := d[:binary.BigEndian.Uint16(d[2:4])]
+	if !hmac.Equal(radiusResponseAuth(p, req.auth[:], o.secret), p[4:RADIUS_HEADER_LEN]) {
+		stats.pktRxBadAuthenticator++
+		return
+	}
+	if !req.acct && o.eap != nil {
+		if pkt.maOff == 0 || !hmac.Equal(radiusMessageAuth(p, pkt.maOff, req.auth[:], o.secret), p[pkt.maOff:pkt.maOff+md5.Size]) {
+			stats.pktRxBadMessageAuth++
+			return
+		}
+	}
+
+	req.pending = false
+	o.stopTimer(&req.timer)
+	lat := o.Tctx.LatNow() - req.start
+	if req.acct {
+		stats.pktRxAcctResponse++
+		o.nsPlug.acctLat.Add(lat)
+		return
+	}
+	o.nsPlug.authLat.Add(lat)
+
+	switch pkt.code {
+	case RADIUS_ACCESS_ACCEPT:
+		stats.pktRxAccessAccept++
+		o.startSession(pkt)
+	case RADIUS_ACCESS_REJECT:
+		stats.pktRxAccessReject++
+		o.state = RADIUS_STATE_REJECT
+	case RADIUS_ACCESS_CHALLENGE:
+		stats.pktRxAccessChallenge++
+		var r []byte
+		if o.eap != nil {
+			r = o.eap.Response(pkt.getAll(RADIUS_ATTR_EAP_MESSAGE))
+		}
+		if r == nil {
+			stats.eapErr++
+			o.state = RADIUS_STATE_REJECT
+			return
+		}
+		o.eapState = append([]byte{}, pkt.get(RADIUS_ATTR_STATE)...)
+		if len(o.eapState) == 0 {
+			o.eapState = nil
+		}
+		o.sendAccessRequest(r)
+	}
+}
+
+// PluginRadiusNs information per namespace
+type PluginRadiusNs struct {
+	core.PluginBase
+	stats   RadiusStats
+	authLat core.LatHist
+	acctLat core.LatHist
+	cdb     *core.CCounterDb
+	cdbv    *core.CCounterDbVec
+}
+
+func NewRadiusNs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	o := new(PluginRadiusNs)
+	o.InitPluginBase(ctx, o)
+	o.RegisterEvents(ctx, []string{}, o)
+	o.cdb = NewRadiusStatsDb(&o.stats)
+	o.cdbv = core.NewCCounterDbVec(RADIUS_PLUG)
+	o.cdbv.Add(o.cdb)
+	o.cdbv.Add(core.NewLatHistDb("radiusAuthLat", "pkts", &o.authLat))
+	o.cdbv.Add(core.NewLatHistDb("radiusAcctLat", "pkts", &o.acctLat))
+	return &o.PluginBase
+}
+
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginRadiusNs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
+func (o *PluginRadiusNs) OnRemove(ctx *core.PluginCtx) {
+}
+
+func (o *PluginRadiusNs) OnEvent(msg string, a, b interface{}) {
+}
+
+type PluginRadiusCReg struct{}
+type PluginRadiusNsReg struct{}
+
+func (o PluginRadiusCReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewRadiusClient(ctx, initJson)
+}
+
+func (o PluginRadiusNsReg) NewPlugin(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
+	return NewRadiusNs(ctx, initJson)
+}
+
+/*******************************************/
+/*  RPC commands */
+type (
+	ApiRadiusNsCntHandler       struct{}
+	ApiRadiusClientInfoHandler  struct{}
+	ApiRadiusClientStartHandler struct{}
+	ApiRadiusClientStopHandler  struct{}
+)
+
+func getNsPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginRadiusNs, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetNsPlugin(params, RADIUS_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginRadiusNs), nil
+}
+
+func getClientPlugin(ctx interface{}, params *fastjson.RawMessage) (*PluginRadiusClient, error) {
+	tctx := ctx.(*core.CThreadCtx)
+	plug, err := tctx.GetClientPlugin(params, RADIUS_PLUG)
+	if err != nil {
+		return nil, err
+	}
+	return plug.Ext.(*PluginRadiusClient), nil
+}
+
+func (h ApiRadiusNsCntHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	var p core.ApiCntParams
+	tctx := ctx.(*core.CThreadCtx)
+	nsPlug, err := getNsPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	return nsPlug.cdbv.GeneralCounters(err, tctx, params, &p)
+}
+
+func (h ApiRadiusClientInfoHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	var res RadiusClientInfo
+	res.State = c.state
+	res.SessionId = c.sessionId
+	res.SessionTime = c.getSessionTime()
+	res.Sessions = c.sessions
+	return &res, nil
+}
+
+func (h ApiRadiusClientStartHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	if !c.valid {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "invalid configuration of the client",
+		}
+	}
+	c.StartAuth()
+	return nil, nil
+}
+
+func (h ApiRadiusClientStopHandler) ServeJSONRPC(ctx interface{}, params *fastjson.RawMessage) (interface{}, *jsonrpc.Error) {
+	c, err := getClientPlugin(ctx, params)
+	if err != nil {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: err.Error(),
+		}
+	}
+	if c.state != RADIUS_STATE_SESSION {
+		return nil, &jsonrpc.Error{
+			Code:    jsonrpc.ErrorCodeInvalidRequest,
+			Message: "there is no active session",
+		}
+	}
+	c.StopSession(RADIUS_TERMINATE_USER_REQUEST)
+	return nil, nil
+}
+
+func init() {
+
+	/* register of plugins callbacks for ns,c level  */
+	core.PluginRegister(RADIUS_PLUG,
+		core.PluginRegisterData{Client: PluginRadiusCReg{},
+			Ns:     PluginRadiusNsReg{},
+			Thread: nil}) /* no need for thread context for now */
+
+	/* The format of the RPC commands xxx_yy_zz_aa
+
+	  xxx - the plugin name
+
+	  yy  - ns - namespace
+			c  - client
+			t   -thread
+
+	  zz  - cmd  command like ping etc
+			set  set configuration
+			get  get configuration/counters
+
+	  aa - misc
+	*/
+
+	core.RegisterCB("radius_ns_cnt", ApiRadiusNsCntHandler{}, true)
+	core.RegisterCB("radius_c_info", ApiRadiusClientInfoHandler{}, false)
+	core.RegisterCB("radius_c_start", ApiRadiusClientStartHandler{}, false)
+	core.RegisterCB("radius_c_stop", ApiRadiusClientStopHandler{}, false)
+}
+
+func Register(ctx *core.CThreadCtx) {
+}
@@ -0,0 +1,425 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"emu/core"
+	"emu/plugins/dot1x"
+	"emu/plugins/transport"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+var monitor int
+
+type RadiusTestBase struct {
+	testname   string
+	dropAll    bool
+	capture    bool
+	duration   time.Duration
+	clientJson string
+	srv        *radiusSrvSim
+	expState   uint8
+	cb         RadiusTestCb
+}
+
+type RadiusTestCb func(tctx *core.CThreadCtx, test *RadiusTestBase) int
+
+var radiusTestClientMac = core.MACKey{0, 0, 1, 0, 0, 1}
+var radiusTestServerMac = core.MACKey{0, 0, 1, 0, 0, 2}
+
+func (o *RadiusTestBase) Run(t *testing.T) {
+	var simVeth VethRadiusSim
+	simVeth.DropAll = o.dropAll
+	var simrx core.VethIFSim
+	simrx = &simVeth
+
+	tctx := createSimulationEnv(&simrx, o)
+	if o.cb != nil {
+		o.cb(tctx, o)
+	}
+	m := false
+	if monitor > 0 {
+		m = true
+	}
+	tctx.Veth.SetDebug(m, os.Stdout, o.capture)
+	tctx.MainLoopSim(o.duration)
+	defer tctx.Delete()
+
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := tctx.GetNs(&key)
+	if ns == nil {
+		t.Fatalf(" can't find ns")
+	}
+	c := ns.CLookupByMac(&radiusTestClientMac)
+	plug := c.PluginCtx.Get(RADIUS_PLUG).Ext.(*PluginRadiusClient)
+	nsPlug := ns.PluginCtx.Get(RADIUS_PLUG).Ext.(*PluginRadiusNs)
+	nsPlug.cdbv.Dump()
+	tctx.SimRecordAppend(nsPlug.cdbv.MarshalValues(false))
+	tctx.SimRecordAppend(map[string]interface{}{"state": plug.state, "session_id": plug.sessionId, "accounting": o.srv.acct})
+	if plug.state != o.expState {
+		t.Fatalf(" expected state %v, got %v", o.expState, plug.state)
+	}
+	tctx.SimRecordCompare(o.testname, t)
+}
+
+func createSimulationEnv(simRx *core.VethIFSim, test *RadiusTestBase) *core.CThreadCtx {
+	tctx := core.NewThreadCtx(0, 4510, true, simRx)
+	var key core.CTunnelKey
+	key.Set(&core.CTunnelData{Vport: 1})
+	ns := core.NewNSCtx(tctx, &key)
+	tctx.AddNs(&key, ns)
+
+	// the packets are looped back, the default gateway of the server is the client and vice versa
+	srv := core.NewClient(ns, radiusTestServerMac, core.Ipv4Key{16, 0, 0, 2}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 1})
+	srv.ForceDGW = true
+	srv.Ipv4ForcedgMac = radiusTestClientMac
+	ns.AddClient(srv)
+	srv.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG}, [][]byte{})
+	ctx := transport.GetTransportCtx(srv)
+	ctx.Listen("udp", ":1812", test.srv)
+	ctx.Listen("udp", ":1813", test.srv)
+
+	client := core.NewClient(ns, radiusTestClientMac, core.Ipv4Key{16, 0, 0, 1}, core.Ipv6Key{}, core.Ipv4Key{16, 0, 0, 2})
+	client.ForceDGW = true
+	client.Ipv4ForcedgMac = radiusTestServerMac
+	ns.AddClient(client)
+	client.PluginCtx.CreatePlugins([]string{transport.TRANS_PLUG, RADIUS_PLUG}, [][]byte{[]byte{}, []byte(test.clientJson)})
+	tctx.RegisterParserCb(transport.TRANS_PLUG)
+	ns.Dump()
+	return tctx
+}
+
+type VethRadiusSim struct {
+	DropAll bool
+}
+
+func (o *VethRadiusSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
+	if o.DropAll {
+		m.FreeMbuf()
+		return nil
+	}
+	return m
+}
+
+// radiusSrvSim RADIUS server for the tests, PAP, CHAP, EAP-MD5 and EAP-MSCHAPv2
+type radiusSrvSim struct {
+	secret         []byte
+	users          map[string]string
+	eapMethod      uint8
+	sessionTimeout uint32
+	interim        uint32
+	challenge      []byte
+	eapId          uint8
+	authResponse   string
+	acct           []uint32 // Acct-Status-Type of the accounting requests
+}
+
+type radiusSrvFlow struct {
+	srv *radiusSrvSim
+	s   transport.SocketApi
+}
+
+func newRadiusSrvSim(secret string) *radiusSrvSim {
+	o := new(radiusSrvSim)
+	o.secret = []byte(secret)
+	o.users = map[string]string{"user1": "password1"}
+	o.challenge = []byte{0xa1, 0x50, 0x1e, 0x8b, 0xb2, 0x70, 0x1d, 0x3d, 0x9b, 0x53, 0x55, 0x94, 0x99, 0x3f, 0x67, 0xa7}
+	o.acct = []uint32{}
+	return o
+}
+
+func (o *radiusSrvSim) OnAccept(socket transport.SocketApi) transport.ISocketCb {
+	return &radiusSrvFlow{srv: o, s: socket}
+}
+
+func (o *radiusSrvFlow) OnRxEvent(event transport.SocketEventType) {}
+func (o *radiusSrvFlow) OnTxEvent(event transport.SocketEventType) {}
+
+func (o *radiusSrvFlow) OnRxData(d []byte) {
+	r := o.srv.process(d)
+	if r != nil {
+		o.s.Write(r)
+	}
+}
+
+func (o *radiusSrvSim) reply(req []byte, code uint8, attrs []byte, ma bool) []byte {
+	p := make([]byte, RADIUS_HEADER_LEN)
+	p[0] = code
+	p[1] = req[1]
+	p = append(p, attrs...)
+	maOff := 0
+	if ma {
+		p = append(p, RADIUS_ATTR_MESSAGE_AUTHENTICATOR, 2+md5.Size)
+		maOff = len(p)
+		p = append(p, make([]byte, md5.Size)...)
+	}
+	binary.BigEndian.PutUint16(p[2:4], uint16(len(p)))
+	if ma {
+		copy(p[maOff:], radiusMessageAuth(p, maOff, req[4:RADIUS_HEADER_LEN], o.secret))
+	}
+	copy(p[4:RADIUS_HEADER_LEN], radiusResponseAuth(p, req[4:RADIUS_HEADER_LEN], o.secret))
+	return p
+}
+
+func (o *radiusSrvSim) eapRequest(req []byte, eaptype uint8, d []byte) []byte {
+	o.eapId++
+	e := []byte{1, o.eapId, 0, 0, eaptype}
+	e = append(e, d...)
+	binary.BigEndian.PutUint16(e[2:4], uint16(len(e)))
+	b := radiusAppendAttr(nil, RADIUS_ATTR_EAP_MESSAGE, e)
+	b = radiusAppendAttr(b, RADIUS_ATTR_STATE, []byte("emu-state"))
+	return o.reply(req, RADIUS_ACCESS_CHALLENGE, b, true)
+}
+
+func (o *radiusSrvSim) accept(req []byte, eap bool) []byte {
+	b := radiusAppendAttr(nil, RADIUS_ATTR_CLASS, []byte("emu-class"))
+	if o.sessionTimeout > 0 {
+		b = radiusAppendUint32(b, RADIUS_ATTR_SESSION_TIMEOUT, o.sessionTimeout)
+	}
+	if o.interim > 0 {
+		b = radiusAppendUint32(b, RADIUS_ATTR_ACCT_INTERIM_INTERVAL, o.interim)
+	}
+	if eap {
+		b = radiusAppendAttr(b, RADIUS_ATTR_EAP_MESSAGE, []byte{3, o.eapId, 0, 4})
+	}
+	return o.reply(req, RADIUS_ACCESS_ACCEPT, b, eap)
+}
+
+func (o *radiusSrvSim) reject(req []byte, eap bool) []byte {
+	var b []byte
+	if eap {
+		b = radiusAppendAttr(b, RADIUS_ATTR_EAP_MESSAGE, []byte{4, o.eapId, 0, 4})
+	}
+	return o.reply(req, RADIUS_ACCESS_REJECT, b, eap)
+}
+
+func (o *radiusSrvSim) process(p []byte) []byte {
+	pkt, err := radiusDecode(p)
+	if err != nil {
+		return nil
+	}
+	if pkt.code == RADIUS_ACCOUNTING_REQUEST {
+		var zero [RADIUS_AUTH_LEN]byte
+		if !bytes.Equal(radiusResponseAuth(p, zero[:], o.secret), p[4:RADIUS_HEADER_LEN]) {
+			return nil
+		}
+		o.acct = append(o.acct, pkt.getUint32(RADIUS_ATTR_ACCT_STATUS_TYPE))
+		return o.reply(p, RADIUS_ACCOUNTING_RESPONSE, nil, false)
+	}
+	if pkt.code != RADIUS_ACCESS_REQUEST {
+		return nil
+	}
+	if pkt.maOff != 0 && !hmac.Equal(radiusMessageAuth(p, pkt.maOff, p[4:RADIUS_HEADER_LEN], o.secret), p[pkt.maOff:pkt.maOff+md5.Size]) {
+		return nil
+	}
+	user := string(pkt.get(RADIUS_ATTR_USER_NAME))
+	password, ok := o.users[user]
+	if !ok {
+		return o.reject(p, pkt.maOff != 0)
+	}
+
+	if eap := pkt.getAll(RADIUS_ATTR_EAP_MESSAGE); eap != nil {
+		return o.processEap(p, eap, user, password)
+	}
+	if chap := pkt.get(RADIUS_ATTR_CHAP_PASSWORD); chap != nil {
+		h := md5.New()
+		h.Write(chap[0:1])
+		h.Write([]byte(password))
+		h.Write(p[4:RADIUS_HEADER_LEN])
+		if !bytes.Equal(h.Sum(nil), chap[1:]) {
+			return o.reject(p, false)
+		}
+		return o.accept(p, false)
+	}
+	// hiding is xor, the same function reveals the password
+	c := pkt.get(RADIUS_ATTR_USER_PASSWORD)
+	var r []byte
+	last := p[4:RADIUS_HEADER_LEN]
+	for i := 0; i+md5.Size <= len(c); i += md5.Size {
+		r = append(r, radiusHidePassword(c[i:i+md5.Size], o.secret, last)...)
+		last = c[i : i+md5.Size]
+	}
+	if string(bytes.TrimRight(r, "\x00")) != password {
+		return o.reject(p, false)
+	}
+	return o.accept(p, false)
+}
+
+func (o *radiusSrvSim) processEap(p []byte, eap []byte, user string, password string) []byte {
+	if len(eap) < 5 || eap[0] != 2 || eap[1] != o.eapId {
+		return o.reject(p, true)
+	}
+	d := eap[5:]
+	switch eap[4] {
+	case 1: // identity
+		o.authResponse = ""
+		if o.eapMethod == dot1x.EAP_TYPE_MD5 {
+			return o.eapRequest(p, dot1x.EAP_TYPE_MD5, append([]byte{16}, o.challenge...))
+		}
+		r := []byte{dot1x.MS_CHAPV2_CHALLENGE, o.eapId + 1, 0, 0, 16}
+		r = append(r, o.challenge...)
+		r = append(r, []byte("emu")...)
+		binary.BigEndian.PutUint16(r[2:4], uint16(len(r)))
+		return o.eapRequest(p, dot1x.EAP_TYPE_MSCHAPV2, r)
+	case dot1x.EAP_TYPE_MD5:
+		h := md5.Sum(append(append([]byte{o.eapId}, []byte(password)...), o.challenge...))
+		if len(d) < 17 || !bytes.Equal(d[1:17], h[:]) {
+			return o.reject(p, true)
+		}
+		return o.accept(p, true)
+	case dot1x.EAP_TYPE_MSCHAPV2:
+		if len(d) >= 1 && d[0] == dot1x.MS_CHAPV2_SUCCESS && o.authResponse != "" {
+			return o.accept(p, true)
+		}
+		if len(d) < 54 || d[0] != dot1x.MS_CHAPV2_RESPONSE {
+			return o.reject(p, true)
+		}
+		res, err := dot1x.Encryptv2(o.challenge, d[5:21], user, password)
+		if err != nil || !bytes.Equal(d[29:53], res.ChallengeResponse) {
+			return o.reject(p, true)
+		}
+		o.authResponse = res.AuthenticatorResponse
+		r := []byte{dot1x.MS_CHAPV2_SUCCESS, d[1], 0, 0}
+		r = append(r, []byte(res.AuthenticatorResponse+" M=OK")...)
+		binary.BigEndian.PutUint16(r[2:4], uint16(len(r)))
+		return o.eapRequest(p, dot1x.EAP_TYPE_MSCHAPV2, r)
+	}
+	return o.reject(p, true)
+}
+
+type RadiusRpcCtx struct {
+	tctx   *core.CThreadCtx
+	timer  core.CHTimerObj
+	method string
+}
+
+func (o *RadiusRpcCtx) OnEvent(a, b interface{}) {
+	o.tctx.Veth.AppendSimuationRPC([]byte(fmt.Sprintf(`{"jsonrpc": "2.0",
+	"method":"%s",
+	"params": {"tun": {"vport":1}, "mac":[0, 0, 1, 0, 0, 1] },
+	"id": 3 }`, o.method)))
+}
+
+func rpcStopQueue(tctx *core.CThreadCtx, test *RadiusTestBase) int {
+	timerw := tctx.GetTimerCtx()
+	var rpcctx RadiusRpcCtx
+	rpcctx.timer.SetCB(&rpcctx, 0, 0)
+	rpcctx.tctx = tctx
+	rpcctx.method = "radius_c_stop"
+	timerw.Start(&rpcctx.timer, 25*time.Second)
+	return 0
+}
+
+const radiusTestClientJson = `{"server": "16.0.0.2:1812", "acct_server": "16.0.0.2:1813", "secret": "testing123",
+	"user": "user1", "password": "%s", "method": "%s", "timeo": 2, "retries": 2 %s}`
+
+func TestPluginRadiusPap(t *testing.T) {
+	a := &RadiusTestBase{
+		testname:   "radius_pap",
+		capture:    true,
+		duration:   30 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "pap", `, "interim": 10, "nas": {"identifier": "emu", "port": 7}`),
+		srv:        newRadiusSrvSim("testing123"),
+		expState:   RADIUS_STATE_DONE,
+		cb:         rpcStopQueue,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusChap(t *testing.T) {
+	srv := newRadiusSrvSim("testing123")
+	srv.sessionTimeout = 20
+	srv.interim = 8
+	a := &RadiusTestBase{
+		testname:   "radius_chap",
+		capture:    true,
+		duration:   25 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "chap", ""),
+		srv:        srv,
+		expState:   RADIUS_STATE_DONE,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusEapMd5(t *testing.T) {
+	srv := newRadiusSrvSim("testing123")
+	srv.eapMethod = dot1x.EAP_TYPE_MD5
+	a := &RadiusTestBase{
+		testname:   "radius_eap_md5",
+		capture:    true,
+		duration:   5 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "eap-md5", ""),
+		srv:        srv,
+		expState:   RADIUS_STATE_SESSION,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusEapMschapv2(t *testing.T) {
+	srv := newRadiusSrvSim("testing123")
+	srv.eapMethod = dot1x.EAP_TYPE_MSCHAPV2
+	a := &RadiusTestBase{
+		testname:   "radius_eap_mschapv2",
+		capture:    true,
+		duration:   5 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "eap-mschapv2", ""),
+		srv:        srv,
+		expState:   RADIUS_STATE_SESSION,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusReject(t *testing.T) {
+	srv := newRadiusSrvSim("testing123")
+	srv.eapMethod = dot1x.EAP_TYPE_MSCHAPV2
+	a := &RadiusTestBase{
+		testname:   "radius_reject",
+		capture:    false,
+		duration:   5 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "wrong", "eap-mschapv2", ""),
+		srv:        srv,
+		expState:   RADIUS_STATE_REJECT,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusBadSecret(t *testing.T) {
+	a := &RadiusTestBase{
+		testname:   "radius_bad_secret",
+		capture:    false,
+		duration:   10 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "pap", ""),
+		srv:        newRadiusSrvSim("other"),
+		expState:   RADIUS_STATE_TIMEOUT,
+	}
+	a.Run(t)
+}
+
+func TestPluginRadiusTimeout(t *testing.T) {
+	a := &RadiusTestBase{
+		testname:   "radius_timeout",
+		dropAll:    true,
+		capture:    true,
+		duration:   10 * time.Second,
+		clientJson: fmt.Sprintf(radiusTestClientJson, "password1", "pap", ""),
+		srv:        newRadiusSrvSim("testing123"),
+		expState:   RADIUS_STATE_TIMEOUT,
+	}
+	a.Run(t)
+}
+
+func init() {
+	flag.IntVar(&monitor, "monitor", 0, "monitor")
+}
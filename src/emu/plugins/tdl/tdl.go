@@ -408,6 +408,11 @@ func (o *PluginTdlClient) OnCreate() {
 	o.pktTimer.SetCB(&o.timerCb, o, 0)
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginTdlClient) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 // OnRemove is called when we remove the Tdl client.
 func (o *PluginTdlClient) OnRemove(ctx *core.PluginCtx) {
 	if o.pktTimer.IsRunning() {
@@ -189,6 +189,12 @@ func (o *baseSocket) buildIpv6Template(udp bool) {
 	o.pktTemplate = append(l2, dr...)
 }
 
+// resolveIPv4NextHop resolve the mac of the next hop by the client routes, the flow tuple chooses the ECMP next hop
+func (o *baseSocket) resolveIPv4NextHop(proto uint8) (core.MACKey, bool) {
+	hash := core.RouteHashIPv4(o.src, o.dst, proto, o.srcPort, o.dstPort)
+	return o.client.ResolveIPv4NextHopMac(o.dst, hash)
+}
+
 func (o *baseSocket) initphase2(udp bool, dstMac *core.MACKey) {
 	if o.ipv6 {
 		o.buildIpv6Template(udp)
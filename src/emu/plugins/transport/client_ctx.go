@@ -31,6 +31,9 @@ type TransportCtxCfg struct {
 	TcpTxBufSize    *uint32 `json:"txbufsize" validate:"gte=8192 &lte=1048576"`
 	TcpDorfc1323    *bool   `json:"do_rfc1323"`
 	TcpMss          *uint16 `json:"mss" validate:"gte=10 &lte=9000"`
+	TcpCc           *string `json:"cc" validate:"omitempty,oneof=newreno cubic"`
+	TcpSack         *bool   `json:"sack"`
+	Tls             *TlsCfg `json:"tls"`
 }
 
 type prototbl map[uint8]IServerSocketCb // per protocol accept callback
@@ -486,6 +489,9 @@ type TransportCtx struct {
 	tcp_maxidle          uint16 /* time to drop after starting probes */
 	tcp_maxpersistidle   uint16
 	tcp_fast_tick_msec   uint16
+	tcp_cc               string /* default congestion control of new sockets */
+	tcp_cc_selected      bool   /* tcp_cc was set by the init json */
+	tcp_do_sack          bool   /* request SACK, RFC 2018 */
 
 	// flow table
 	flowTableStats ftStats
@@ -494,6 +500,8 @@ type TransportCtx struct {
 	ftv6           flowTablev6
 	srcPorts       srcPortManager
 	serverCb       serverft // server callbacks
+
+	tls tlsCtx
 }
 
 func updateInitwnd(mss uint16, initwnd uint16) uint16 {
@@ -526,6 +534,8 @@ func newCtx(c *core.CClient) *TransportCtx {
 	o.ftv6 = make(flowTablev6)
 	o.srcPorts.init(o)
 	o.serverCb = make(serverft)
+	o.tls.init()
+	o.cdbv.Add(o.tls.cdb)
 	return o
 }
 
@@ -567,6 +577,21 @@ func (o *TransportCtx) setCfg(cfg *TransportCtxCfg) {
 		o.tcp_mssdflt_ = *cfg.TcpMss
 	}
 
+	if cfg.TcpSack != nil {
+		o.tcp_do_sack = *cfg.TcpSack
+	}
+
+	if cfg.TcpCc != nil {
+		if _, err := newTcpCc(*cfg.TcpCc); err == nil {
+			o.tcp_cc = *cfg.TcpCc
+			o.tcp_cc_selected = true
+		}
+	}
+
+	if cfg.Tls != nil {
+		o.tls.cfg = cfg.Tls
+	}
+
 }
 
 func (o *TransportCtx) getActiveFlows() uint64 {
@@ -589,6 +614,8 @@ func (o *TransportCtx) onRemove() {
 		or.onRemove()
 	}
 
+	o.tls.onRemove()
+
 	if o.timer.IsRunning() {
 		o.timerw.Stop(&o.timer)
 	}
@@ -686,6 +713,7 @@ func (o *TransportCtx) init() {
 	o.tcp_keepcnt = TCPTV_KEEPCNT          /* max idle probes */
 	o.tcp_maxpersistidle = TCPTV_KEEP_IDLE /* max idle time in persist */
 	o.tcp_no_delay_counter = TCP_MSS * 2
+	o.tcp_cc = TCP_CC_NEWRENO
 }
 
 func (o *TransportCtx) getTcpIss() uint32 {
@@ -979,6 +1007,10 @@ func (o *TransportCtx) handleRxPacket(ps *core.ParserPacketState) int {
 //	Dial("tcp", "192.0.2.1:80",cb,nil, nil, 5353)
 func (o *TransportCtx) Dial(network, address string, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey, srcPort uint16) (SocketApi, error) {
 
+	if network == "tls" {
+		return o.DialTls(address, cb, ioctl, dstMac, srcPort, o.tls.cfg)
+	}
+
 	o.flowTableStats.dial++
 
 	switch network {
@@ -1199,6 +1231,9 @@ ctx.UnListen("tcp",":8080",cb)
 
 */
 func (o *TransportCtx) Listen(network, address string, cb IServerSocketCb) error {
+	if network == "tls" {
+		return o.ListenTls(address, cb, o.tls.cfg)
+	}
 	var proto uint8
 	var port uint16
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
@@ -1214,6 +1249,9 @@ func (o *TransportCtx) Listen(network, address string, cb IServerSocketCb) error
 UnListen(), see listen
 */
 func (o *TransportCtx) UnListen(network, address string, cb IServerSocketCb) error {
+	if network == "tls" {
+		return o.UnListenTls(address, cb)
+	}
 	var proto uint8
 	var port uint16
 	if err := o.parseNA(network, address, &port, &proto); err != nil {
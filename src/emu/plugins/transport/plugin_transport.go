@@ -64,6 +64,15 @@ func (o *PluginTransClient) OnEvent(msg string, a, b interface{}) {
 
 }
 
+// GetCounterDbVec implements core.IPluginCounters, nil until the transport context is created
+func (o *PluginTransClient) GetCounterDbVec() *core.CCounterDbVec {
+	tx := getTransportCtxIfExist(o.Client)
+	if tx == nil {
+		return nil
+	}
+	return tx.cdbv
+}
+
 func (o *PluginTransClient) OnRemove(ctx *core.PluginCtx) {
 	tl := o.Client.GetTransportCtx()
 	if tl == nil {
@@ -0,0 +1,291 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+import (
+	"fmt"
+	"math"
+)
+
+/*
+ * Congestion control modules, modeled after the FreeBSD cc(4) framework.
+ * The tcp input/timer/output code signals the events and the module updates
+ * snd_cwnd/snd_ssthresh of the socket. The fast retransmit window inflation
+ * (ssthresh + dupacks*maxseg) stays in tcp_input.
+ *
+ * newreno - the classic BSD Reno (default)
+ * cubic   - RFC 8312
+ *
+ * A socket that selects its module by the cc ioctl or the init json also gets
+ * the RFC 6582 NewReno recovery (partial acks) and queues out of order data
+ * without SACK. The default socket keeps the classic Reno recovery.
+ */
+
+const (
+	TCP_CC_NEWRENO = "newreno"
+	TCP_CC_CUBIC   = "cubic"
+
+	/* congestion signals */
+	CC_NDUPACK = 1 /* dupacks threshold was reached, fast retransmit */
+	CC_RTO     = 2 /* retransmit timeout */
+
+	CUBIC_C    = 0.4 /* scaling constant, segments/sec^3 */
+	CUBIC_BETA = 0.7 /* multiplicative decrease factor */
+)
+
+type tcpCcIF interface {
+	name() string
+	/* new data was acked, increase the window */
+	ackReceived(o *TcpSocket, acked uint32)
+	/* a congestion event, update ssthresh (and cwnd for RTO) */
+	congSignal(o *TcpSocket, signal int)
+	/* end of fast recovery, retract the inflated window */
+	postRecovery(o *TcpSocket)
+	/* restart after idle period */
+	afterIdle(o *TcpSocket)
+}
+
+func newTcpCc(name string) (tcpCcIF, error) {
+	switch name {
+	case TCP_CC_NEWRENO:
+		return tcpCcNewReno{}, nil
+	case TCP_CC_CUBIC:
+		return new(tcpCcCubic), nil
+	}
+	return nil, fmt.Errorf("unsupported congestion control %q", name)
+}
+
+// half of the current flight rounded to maxseg, at least two segments
+func (o *TcpSocket) ccHalfWindow() uint32 {
+	win := bsd_umin(o.snd_wnd, o.snd_cwnd) / 2 / uint32(o.maxseg)
+	if win < 2 {
+		win = 2
+	}
+	return win * uint32(o.maxseg)
+}
+
+// update the counters with the new window values
+func (o *TcpSocket) ccUpdateStats(signal int) {
+	sts := &o.ctx.tcpStats
+	switch signal {
+	case CC_NDUPACK:
+		sts.tcps_cc_recovery++
+	case CC_RTO:
+		sts.tcps_cc_rto++
+	}
+	if signal != 0 && (sts.tcps_cc_ssthresh_min == 0 || uint64(o.snd_ssthresh) < sts.tcps_cc_ssthresh_min) {
+		sts.tcps_cc_ssthresh_min = uint64(o.snd_ssthresh)
+	}
+	if uint64(o.snd_cwnd) > sts.tcps_cc_cwnd_max {
+		sts.tcps_cc_cwnd_max = uint64(o.snd_cwnd)
+	}
+}
+
+func (o *TcpSocket) ccAckReceived(acked uint32) {
+	o.cc.ackReceived(o, acked)
+	o.ccUpdateStats(0)
+}
+
+func (o *TcpSocket) ccCongSignal(signal int) {
+	o.cc.congSignal(o, signal)
+	o.ccUpdateStats(signal)
+}
+
+func (o *TcpSocket) ccPostRecovery() {
+	o.cc.postRecovery(o)
+}
+
+/*
+ * The data of one full segment, maxseg includes the timestamp option.
+ * A window of maxseg would send a full segment and a sliver of the option size.
+ */
+func (o *TcpSocket) rexmtseg() uint32 {
+	if (o.flags & (TF_REQ_TSTMP | TF_RCVD_TSTMP | TF_NOOPT)) == (TF_REQ_TSTMP | TF_RCVD_TSTMP) {
+		return uint32(o.maxseg - TCPOLEN_TSTAMP_APPA)
+	}
+	return uint32(o.maxseg)
+}
+
+/*
+ * RFC 6582 partial ack in fast recovery, ack is below snd_recover.
+ * Retransmit the first unacked segment, deflate the window by the
+ * acked data and add back one segment, stay in the recovery.
+ * Called before snd_una is updated.
+ */
+func (o *TcpSocket) newrenoPartialAck(ack uint32) {
+	onxt := o.snd_nxt
+	ocwnd := o.snd_cwnd
+	acked := ack - o.snd_una
+
+	o.ctx.tcpStats.tcps_cc_partial_ack++
+	o.timer[TCPT_REXMT] = 0
+	o.rtt = 0
+	o.snd_nxt = ack
+	o.snd_cwnd = o.rexmtseg() + acked
+	o.output()
+	o.snd_cwnd = ocwnd
+	if seq_gt(onxt, o.snd_nxt) {
+		o.snd_nxt = onxt
+	}
+	if o.snd_cwnd > acked {
+		o.snd_cwnd -= acked
+	} else {
+		o.snd_cwnd = 0
+	}
+	o.snd_cwnd += uint32(o.maxseg)
+}
+
+func (o *TcpSocket) ccAfterIdle() {
+	o.ctx.tcpStats.tcps_cc_after_idle++
+	o.cc.afterIdle(o)
+}
+
+/* msec clock of the thread, simulation time in case of simulation */
+func (o *TcpSocket) ccNowMsec() uint64 {
+	return o.timerw.Ticks * uint64(o.timerw.MinTickMsec())
+}
+
+/* smoothed rtt in msec */
+func (o *TcpSocket) ccSrttMsec() uint64 {
+	return uint64(o.srtt>>TCP_RTT_SHIFT) * 1000 / PR_SLOWHZ
+}
+
+type tcpCcNewReno struct{}
+
+func (c tcpCcNewReno) name() string {
+	return TCP_CC_NEWRENO
+}
+
+/*
+ * When new data is acked, open the congestion window.
+ * If the window gives us less than ssthresh packets
+ * in flight, open exponentially (maxseg per packet).
+ * Otherwise open linearly: maxseg per window
+ * (maxseg * (maxseg / cwnd) per packet).
+ */
+func (c tcpCcNewReno) ackReceived(o *TcpSocket, acked uint32) {
+	cw := o.snd_cwnd
+	incr := uint32(o.maxseg)
+
+	if cw > o.snd_ssthresh {
+		incr = incr * incr / cw
+	}
+	o.snd_cwnd = bsd_umin(cw+incr, TCP_MAXWIN<<o.snd_scale)
+}
+
+func (c tcpCcNewReno) congSignal(o *TcpSocket, signal int) {
+	o.snd_ssthresh = o.ccHalfWindow()
+	if signal == CC_RTO {
+		o.snd_cwnd = uint32(o.maxseg)
+	}
+}
+
+func (c tcpCcNewReno) postRecovery(o *TcpSocket) {
+	if o.snd_cwnd > o.snd_ssthresh {
+		o.snd_cwnd = o.snd_ssthresh
+	}
+}
+
+func (c tcpCcNewReno) afterIdle(o *TcpSocket) {
+	o.snd_cwnd = uint32(o.maxseg)
+}
+
+type tcpCcCubic struct {
+	epochStart uint64  /* msec of the start of the current epoch, zero - not started */
+	wMax       float64 /* window before the last reduction, in bytes */
+	wLastMax   float64 /* wMax of the previous reduction, fast convergence */
+	k          float64 /* seconds to reach wMax */
+	origin     float64 /* origin point of the cubic function, in bytes */
+	wEst       float64 /* estimation of the reno window, in bytes */
+}
+
+func (c *tcpCcCubic) name() string {
+	return TCP_CC_CUBIC
+}
+
+func (c *tcpCcCubic) ackReceived(o *TcpSocket, acked uint32) {
+	mss := float64(o.maxseg)
+	cw := float64(o.snd_cwnd)
+	maxwin := uint32(TCP_MAXWIN) << o.snd_scale
+
+	if o.snd_cwnd <= o.snd_ssthresh {
+		/* slow start */
+		o.snd_cwnd = bsd_umin(o.snd_cwnd+uint32(o.maxseg), maxwin)
+		return
+	}
+
+	now := o.ccNowMsec()
+	if c.epochStart == 0 {
+		c.epochStart = now
+		if now == 0 {
+			c.epochStart = 1
+		}
+		c.wEst = cw
+		if cw < c.wMax {
+			c.k = math.Cbrt((c.wMax - cw) / mss / CUBIC_C)
+			c.origin = c.wMax
+		} else {
+			c.k = 0
+			c.origin = cw
+		}
+	}
+
+	t := float64(now-c.epochStart+o.ccSrttMsec()) / 1000.0
+	target := c.origin + CUBIC_C*math.Pow(t-c.k, 3)*mss
+
+	/* tcp friendly region, the window of reno with the same beta */
+	c.wEst += 3 * (1 - CUBIC_BETA) / (1 + CUBIC_BETA) * mss * float64(acked) / cw
+
+	var incr float64
+	if target > cw {
+		incr = (target - cw) * mss / cw
+	} else {
+		incr = mss * mss / (100 * cw)
+	}
+	next := cw + incr
+	if c.wEst > next {
+		next = c.wEst
+	}
+	if next > float64(maxwin) {
+		next = float64(maxwin)
+	}
+	o.snd_cwnd = uint32(next)
+}
+
+func (c *tcpCcCubic) congSignal(o *TcpSocket, signal int) {
+	mss := uint32(o.maxseg)
+	cw := float64(bsd_umin(o.snd_wnd, o.snd_cwnd))
+
+	/* fast convergence, release bandwidth for new flows */
+	if cw < c.wLastMax {
+		c.wLastMax = cw
+		c.wMax = cw * (1 + CUBIC_BETA) / 2
+	} else {
+		c.wLastMax = cw
+		c.wMax = cw
+	}
+	c.epochStart = 0
+
+	ssthresh := uint32(cw * CUBIC_BETA)
+	if ssthresh < 2*mss {
+		ssthresh = 2 * mss
+	}
+	o.snd_ssthresh = ssthresh
+	if signal == CC_RTO {
+		o.snd_cwnd = mss
+	}
+}
+
+func (c *tcpCcCubic) postRecovery(o *TcpSocket) {
+	if o.snd_cwnd > o.snd_ssthresh {
+		o.snd_cwnd = o.snd_ssthresh
+	}
+}
+
+func (c *tcpCcCubic) afterIdle(o *TcpSocket) {
+	c.epochStart = 0
+	o.snd_cwnd = uint32(o.maxseg)
+}
@@ -81,11 +81,130 @@ type TcpStats struct {
 	tcps_already_closed    uint64 /* close  API error */
 	tcps_already_opened    uint64 /* connect/listen  API error */
 	tcps_write_while_drain uint64 /* write  API error */
+
+	tcps_cc_recovery     uint64 /* congestion signals by dup acks */
+	tcps_cc_partial_ack  uint64 /* NewReno partial acks in fast recovery */
+	tcps_cc_rto          uint64 /* congestion signals by retransmit timeout */
+	tcps_cc_after_idle   uint64 /* slow start restart after idle */
+	tcps_cc_cwnd_max     uint64 /* largest congestion window */
+	tcps_cc_ssthresh_min uint64 /* smallest ssthresh set by a congestion signal */
+
+	tcps_sack_recovery     uint64 /* SACK recovery episodes */
+	tcps_sack_rexmits      uint64 /* segments retransmitted in SACK recovery */
+	tcps_sack_rexmit_bytes uint64 /* bytes retransmitted in SACK recovery */
+	tcps_sack_rcv_blocks   uint64 /* SACK blocks received */
+	tcps_sack_snd_blocks   uint64 /* SACK blocks sent, including DSACK */
+	tcps_dsack_rcv         uint64 /* DSACK blocks received */
+	tcps_dsack_snd         uint64 /* DSACK blocks sent */
 }
 
 func NewTcpStatsDb(o *TcpStats) *core.CCounterDb {
 	db := core.NewCCounterDb("tcp")
 
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_recovery,
+		Name:     "cc_recovery",
+		Help:     "congestion signals by dup acks",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_partial_ack,
+		Name:     "cc_partial_ack",
+		Help:     "NewReno partial acks in fast recovery",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_rto,
+		Name:     "cc_rto",
+		Help:     "congestion signals by retransmit timeout",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_after_idle,
+		Name:     "cc_after_idle",
+		Help:     "slow start restart after idle",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_cwnd_max,
+		Name:     "cc_cwnd_max",
+		Help:     "largest congestion window",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_cc_ssthresh_min,
+		Name:     "cc_ssthresh_min",
+		Help:     "smallest slow start threshold set by a congestion signal",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_recovery,
+		Name:     "sack_recovery",
+		Help:     "SACK recovery episodes",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rexmits,
+		Name:     "sack_rexmits",
+		Help:     "segments retransmitted in SACK recovery",
+		Unit:     "pkts",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rexmit_bytes,
+		Name:     "sack_rexmit_bytes",
+		Help:     "bytes retransmitted in SACK recovery",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_rcv_blocks,
+		Name:     "sack_rcv_blocks",
+		Help:     "SACK blocks received",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_sack_snd_blocks,
+		Name:     "sack_snd_blocks",
+		Help:     "SACK blocks sent, including DSACK",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_dsack_rcv,
+		Name:     "dsack_rcv",
+		Help:     "DSACK blocks received",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tcps_dsack_snd,
+		Name:     "dsack_snd",
+		Help:     "DSACK blocks sent",
+		Unit:     "blocks",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
 	db.Add(&core.CCounterRec{
 		Counter:  &o.tcps_write_while_drain,
 		Name:     "write_while_drain",
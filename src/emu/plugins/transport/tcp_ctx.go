@@ -38,6 +38,7 @@ const (
 	TF_CLOSE_NOTIFY uint16 = 0x0800 /* CLOSE was notified  */
 	TF_WRITE_DRAIN  uint16 = 0x1000 /* write with a buffer to drain, not allowed to add more */
 	TF_CLOSE_DEFER  uint16 = 0x2000 /* mask as closed  */
+	TF_REQ_SACK     uint16 = 0x4000 /* have/will request SACK */
 
 	TH_FIN        = 0x01
 	TH_SYN        = 0x02
@@ -46,7 +47,7 @@ const (
 	TH_ACK        = 0x10
 	TH_URG        = 0x20
 	TCP_MAXWIN    = 65535 /* largest value for (unscaled) window */
-	MAX_TCPOPTLEN = 32    /* max # bytes that go in options */
+	MAX_TCPOPTLEN = 40    /* max # bytes that go in options */
 
 	TCPOPT_EOL     = 0
 	TCPOPT_NOP     = 1
@@ -300,4 +301,24 @@ type TcpSocket struct {
 	tun_mss         uint16
 	tun_init_window uint16
 	tun_no_delay    uint16
+
+	cc           tcpCcIF /* congestion control module */
+	ccSelected   bool    /* cc was selected explicitly, RFC 6582 recovery and out of order queue without SACK */
+	fastRecovery bool    /* in NewReno fast recovery, without SACK */
+	snd_recover  uint32  /* RFC 6582 recover, snd_max at the start of the recovery */
+
+	/* SACK, see tcp_sack.go */
+	sackRx       [TCP_MAX_SACK]tcpSackBlock /* blocks of the segment in process */
+	sackRxCnt    uint8
+	scoreboard   []tcpSackBlock /* SACKed blocks above snd_una, sorted */
+	sackRecovery bool           /* in SACK loss recovery */
+	sackForce    bool           /* retransmit the first hole regardless of pipe */
+	sackRecover  uint32         /* snd_max at the start of the recovery */
+	sackHighRxt  uint32         /* highest retransmitted sequence in the recovery */
+	reassq       []tcpReassSeg  /* out of order segments, sorted */
+	reassBytes   uint32
+	reassOut     [][]byte /* data released from reassq, for the callback */
+	reassLast    uint32   /* sequence of the latest out of order segment */
+	dsack        tcpSackBlock
+	dsackPending bool
 }
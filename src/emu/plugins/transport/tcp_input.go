@@ -62,7 +62,13 @@ func (o *TcpSocket) input(ps *core.ParserPacketState) int {
 	r := o._input(ps)
 	if o.cbmask > 0 {
 		if o.cbmask&SocketRxData > 0 {
-			o.cb.OnRxData(ps.M.GetData()[:])
+			if ps.M.DataLen() > 0 {
+				o.cb.OnRxData(ps.M.GetData()[:])
+			}
+			for _, d := range o.reassOut {
+				o.cb.OnRxData(d)
+			}
+			o.reassOut = o.reassOut[:0]
 		}
 		if (o.cbmask & SocketRxMask) > 0 {
 			o.cb.OnRxEvent(SocketEventType(o.cbmask))
@@ -165,6 +171,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 	 * Process options if not in LISTEN state,
 	 * else do it below (after getting remote address).
 	 */
+	o.sackRxCnt = 0
 	if (len(tcph.Options) > 0) && (o.state != TCPS_LISTEN) {
 		o.dooptions(&tcph,
 			&ts_present, &ts_val, &ts_ecr)
@@ -204,7 +211,7 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 		if ti_len == 0 {
 			if seq_gt(tcph.Ack, o.snd_una) &&
 				seq_leq(tcph.Ack, o.snd_max) &&
-				o.snd_cwnd >= o.snd_wnd {
+				o.snd_cwnd >= o.snd_wnd && !o.fastRecovery {
 				/*
 				 * this is a pure ack for outstanding data.
 				 */
@@ -464,6 +471,13 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			}
 			todrop--
 		}
+		if ti_len > 0 && todrop > 0 {
+			dup := uint32(todrop)
+			if dup > uint32(ti_len) {
+				dup = uint32(ti_len)
+			}
+			o.sackDupRcvd(tcph.Seq, tcph.Seq+dup)
+		}
 		if todrop >= int32(ti_len) {
 			sts.tcps_rcvduppack++
 			sts.tcps_rcvdupbyte += uint64(ti_len)
@@ -663,6 +677,9 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 		TCPS_CLOSING,
 		TCPS_LAST_ACK,
 		TCPS_TIME_WAIT:
+		if (o.flags&TF_SACK_PERMIT) > 0 && (o.sackRxCnt > 0 || len(o.scoreboard) > 0) {
+			o.sackDoAck(tcph.Ack)
+		}
 		if seq_leq(tcph.Ack, o.snd_una) {
 			if (ti_len == 0) && (tiwin == o.snd_wnd) {
 				if o.state != TCPS_FIN_WAIT_2 {
@@ -697,18 +714,42 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 					o.dupacks = 0
 				} else {
 					o.dupacks++
+					if o.sackRecovery {
+						/* the scoreboard was updated, clock out holes or new data */
+						o.output()
+						goto drop
+					}
+					if o.fastRecovery {
+						/* each dup ack is a segment that left the network */
+						o.snd_cwnd += uint32(o.maxseg)
+						o.output()
+						goto drop
+					}
+					if o.dupacks == o.ctx.tcprexmtthresh &&
+						(o.flags&TF_SACK_PERMIT) > 0 {
+						o.sackEnterRecovery()
+						o.output()
+						goto drop
+					}
+					if o.dupacks == o.ctx.tcprexmtthresh && o.ccSelected &&
+						seq_leq(tcph.Ack, o.snd_recover) {
+						/* RFC 6582, the loss is of the window that was already recovered */
+						o.dupacks = 0
+						goto drop
+					}
 					if o.dupacks == o.ctx.tcprexmtthresh {
 						var onxt uint32
 						onxt = o.snd_nxt
-						win := bsd_umin(o.snd_wnd, o.snd_cwnd) / 2 / uint32(o.maxseg)
-						if win < 2 {
-							win = 2
-						}
-						o.snd_ssthresh = win * uint32(o.maxseg)
+						o.ccCongSignal(CC_NDUPACK)
 						o.timer[TCPT_REXMT] = 0
 						o.rtt = 0
 						o.snd_nxt = tcph.Ack
 						o.snd_cwnd = uint32(o.maxseg)
+						if o.ccSelected {
+							o.fastRecovery = true
+							o.snd_recover = o.snd_max
+							o.snd_cwnd = o.rexmtseg()
+						}
 						o.output()
 						o.snd_cwnd = o.snd_ssthresh + uint32(o.maxseg)*uint32(o.dupacks)
 						if seq_gt(onxt, o.snd_nxt) {
@@ -729,9 +770,21 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			 * If the congestion window was inflated to account
 			 * for the other side's cached packets, retract it.
 			 */
-			if (o.dupacks > o.ctx.tcprexmtthresh) &&
-				(o.snd_cwnd > o.snd_ssthresh) {
-				o.snd_cwnd = o.snd_ssthresh
+			if o.sackRecovery {
+				if seq_geq(tcph.Ack, o.sackRecover) {
+					o.sackExitRecovery()
+				} else {
+					needoutput = true /* partial ack, continue the recovery */
+				}
+			} else if o.fastRecovery {
+				if seq_lt(tcph.Ack, o.snd_recover) {
+					o.newrenoPartialAck(tcph.Ack)
+				} else {
+					o.fastRecovery = false
+					o.ccPostRecovery()
+				}
+			} else if o.dupacks > o.ctx.tcprexmtthresh {
+				o.ccPostRecovery()
 			}
 			o.dupacks = 0
 			if seq_gt(tcph.Ack, o.snd_max) {
@@ -770,21 +823,11 @@ func (o *TcpSocket) _input(ps *core.ParserPacketState) int {
 			}
 			/*
 			 * When new data is acked, open the congestion window.
-			 * If the window gives us less than ssthresh packets
-			 * in flight, open exponentially (maxseg per packet).
-			 * Otherwise open linearly: maxseg per window
-			 * (maxseg * (maxseg / cwnd) per packet).
+			 * The congestion control module decides how.
+			 * Not during SACK recovery, cwnd is ssthresh.
 			 */
-			{
-				var cw uint32
-				var incr uint32
-				cw = o.snd_cwnd
-				incr = uint32(o.maxseg)
-
-				if cw > o.snd_ssthresh {
-					incr = incr * incr / cw
-				}
-				o.snd_cwnd = bsd_umin(cw+incr, TCP_MAXWIN<<o.snd_scale)
+			if !o.sackRecovery && !o.fastRecovery {
+				o.ccAckReceived(acked)
 			}
 
 			if acked > so.so_snd.getSize() {
@@ -1155,6 +1198,7 @@ func (o *TcpSocket) sendseqinit() {
 	o.snd_max = o.snd_up
 	o.snd_nxt = o.snd_max
 	o.snd_una = o.snd_nxt
+	o.snd_recover = o.snd_una
 }
 
 func (o *TcpSocket) rcvseqinit() {
@@ -1162,9 +1206,9 @@ func (o *TcpSocket) rcvseqinit() {
 	o.rcv_adv = o.rcv_nxt
 }
 
-/* for now reass is not supported, segment need to come in order ! */
+/* out of order segments are kept only in case of SACK, see tcp_sack.go */
 func (o *TcpSocket) reass_is_exists() bool {
-	return false
+	return len(o.reassq) > 0
 }
 
 func (o *TcpSocket) soisconnected_cb() {
@@ -1195,6 +1239,25 @@ func (o *TcpSocket) dooptions(tcph *layers.TCP,
 				}
 			}
 
+		case layers.TCPOptionKindSACKPermitted:
+			if obj.OptionLength == TCPOLEN_SACK_PERMITTED {
+				if ((tcph.Flags & TH_SYN) > 0) && ((o.flags & TF_REQ_SACK) > 0) {
+					o.flags |= TF_SACK_PERMIT
+				}
+			}
+
+		case layers.TCPOptionKindSACK:
+			if (o.flags & TF_SACK_PERMIT) > 0 {
+				d := obj.OptionData
+				for len(d) >= TCPOLEN_SACK && o.sackRxCnt < TCP_MAX_SACK {
+					b := &o.sackRx[o.sackRxCnt]
+					b.start = binary.BigEndian.Uint32(d[0:4])
+					b.end = binary.BigEndian.Uint32(d[4:8])
+					o.sackRxCnt++
+					d = d[TCPOLEN_SACK:]
+				}
+			}
+
 		case layers.TCPOptionKindTimestamps:
 			if obj.OptionLength == 10 {
 				if len(obj.OptionData) == 8 {
@@ -1246,6 +1309,20 @@ func (o *TcpSocket) reass(tcph *layers.TCP,
 			o.flags |= TF_ACKNOW
 		}
 		o.sbappend(m, ti_len)
+	} else if ((o.flags&TF_SACK_PERMIT) > 0 || o.ccSelected) &&
+		o.state == TCPS_ESTABLISHED {
+		/* ack immediately, a hole was created or filled */
+		o.flags |= TF_ACKNOW
+		if tcph.Seq == o.rcv_nxt {
+			o.rcv_nxt += uint32(ti_len)
+			sts.tcps_rcvpack++
+			sts.tcps_rcvbyte += uint64(ti_len)
+			o.sbappend(m, ti_len)
+			*flags = (tcph.Flags & TH_FIN) | o.reassPull()
+		} else {
+			o.reassInsert(tcph.Seq, m.GetData()[:ti_len], (*flags&TH_FIN) > 0)
+			*flags = 0
+		}
 	} else {
 		sts.tcps_rcvoopackdrop++
 		sts.tcps_rcvoobytesdrop += uint64(ti_len)
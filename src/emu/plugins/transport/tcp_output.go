@@ -34,7 +34,7 @@ func (o *TcpSocket) resolve() bool {
 	}
 
 	if o.ipv6 == false {
-		mac, ok := o.client.ResolveIPv4DGMac()
+		mac, ok := o.resolveIPv4NextHop(TCP_PROTO)
 		if ok {
 			layers.EthernetHeader(o.pktTemplate).SetDestAddress(mac[:])
 			o.resolved = true
@@ -211,6 +211,7 @@ func (o *TcpSocket) output() int {
 	var optlen, hdrlen uint16
 	var sendalot bool
 	var idle bool
+	var sackSeq, sackLen, sackLim uint32
 	so := o.socket
 
 	/*
@@ -227,15 +228,30 @@ func (o *TcpSocket) output() int {
 		 * expected to clock out any data we send --
 		 * slow start to get ack "clock" running again.
 		 */
-		o.snd_cwnd = uint32(o.maxseg)
+		o.ccAfterIdle()
 	}
 
 again:
 
 	sendalot = false
+	sackLim = 0
+	if !o.sackRecovery && seq_lt(o.snd_nxt, o.snd_max) {
+		/* going back after RTO, skip what the peer already has */
+		sackLim = o.sackSkip(&o.snd_nxt)
+	}
 	off = int32(o.snd_nxt - o.snd_una)
 	win = bsd_umin(o.snd_wnd, o.snd_cwnd)
 
+	/*
+	 * In SACK recovery retransmit the next hole, otherwise
+	 * send new data as long as pipe < cwnd.
+	 */
+	sackLen = 0
+	if o.sackRecovery {
+		sackLen = o.sackNextHole(&sackSeq)
+		win = bsd_umin(o.snd_wnd, uint32(off)+o.sackAwnd())
+	}
+
 	flags = tcp_outflags[o.state]
 	/*
 	* If in persist timeout with window of 0, send 1 byte.
@@ -302,6 +318,10 @@ again:
 		len = max_seg
 		sendalot = true
 	}
+	if sackLim > 0 && len > int32(sackLim) {
+		len = int32(sackLim)
+		sendalot = true
+	}
 
 	if seq_lt(o.snd_nxt+uint32(len), o.snd_una+uint32(so.so_snd.getSize())) {
 		flags &= (^TH_FIN)
@@ -309,6 +329,14 @@ again:
 
 	win = so.so_rcv.sbspace()
 
+	if sackLen > 0 {
+		off = int32(sackSeq - o.snd_una)
+		len = int32(sackLen)
+		flags &= (^TH_FIN)
+		sendalot = true
+		goto send
+	}
+
 	/*
 	 * Sender silly window avoidance.  If connection is idle
 	 * and can send all data, a maximum segment,
@@ -450,6 +478,13 @@ send:
 				binary.BigEndian.PutUint32(opt[optlen:optlen+4], a)
 				optlen += 4
 			}
+
+			if ((o.flags & TF_REQ_SACK) > 0) &&
+				(((flags & TH_ACK) == 0) ||
+					((o.flags & TF_SACK_PERMIT) > 0)) {
+				binary.BigEndian.PutUint32(opt[optlen:optlen+4], TCPOPT_SACK_PERMIT_HDR)
+				optlen += 4
+			}
 		}
 	}
 
@@ -470,6 +505,15 @@ send:
 		optlen += TCPOLEN_TSTAMP_APPA
 	}
 
+	/*
+	 * Report the out of order data and duplicates to the peer.
+	 */
+	if ((o.flags & (TF_SACK_PERMIT | TF_NOOPT)) == TF_SACK_PERMIT) &&
+		((flags & (TH_SYN | TH_RST)) == 0) &&
+		(o.reass_is_exists() || o.dsackPending) {
+		optlen = o.sackAddOption(opt[:], optlen)
+	}
+
 	hdrlen += optlen
 
 	var pkt tcpPkt
@@ -494,6 +538,11 @@ send:
 	if len > 0 {
 		if o.force && len == 1 {
 			sts.tcps_sndprobe++
+		} else if sackLen > 0 {
+			sts.tcps_sndrexmitpack++
+			sts.tcps_sndrexmitbyte += uint64(len)
+			sts.tcps_sack_rexmits++
+			sts.tcps_sack_rexmit_bytes += uint64(len)
 		} else if seq_lt(o.snd_nxt, o.snd_max) {
 			sts.tcps_sndrexmitpack++
 			sts.tcps_sndrexmitbyte += uint64(len)
@@ -559,7 +608,9 @@ send:
 	 * case, since we know we aren't doing a retransmission.
 	 * (retransmit and persist are mutually exclusive...)
 	 */
-	if (len > 0) || ((flags & (TH_SYN | TH_FIN)) > 0) || (o.timer[TCPT_PERSIST] > 0) {
+	if sackLen > 0 {
+		tcph.SetSeqNumber(sackSeq)
+	} else if (len > 0) || ((flags & (TH_SYN | TH_FIN)) > 0) || (o.timer[TCPT_PERSIST] > 0) {
 		tcph.SetSeqNumber(uint32(o.snd_nxt))
 	} else {
 		tcph.SetSeqNumber(uint32(o.snd_max))
@@ -604,8 +655,14 @@ send:
 	/*
 	 * In transmit state, time the transmission and arrange for
 	 * the retransmit.  In persist state, just set snd_max.
+	 * A SACK retransmission does not move snd_nxt.
 	 */
-	if (o.force == false) || (o.timer[TCPT_PERSIST] == 0) {
+	if sackLen > 0 {
+		o.sackHighRxt = sackSeq + uint32(len)
+		if o.timer[TCPT_REXMT] == 0 {
+			o.timer[TCPT_REXMT] = o.rxtcur
+		}
+	} else if (o.force == false) || (o.timer[TCPT_PERSIST] == 0) {
 		var startseq uint32
 		startseq = o.snd_nxt
 
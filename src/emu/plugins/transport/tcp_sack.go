@@ -0,0 +1,369 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+import "encoding/binary"
+
+/*
+ * Selective acknowledgment, RFC 2018 with DSACK RFC 2883 and
+ * the conservative loss recovery of RFC 6675.
+ *
+ * receiver - out of order segments are kept in the reassembly queue (only
+ *            when SACK was negotiated) and reported as SACK blocks, the
+ *            block of the latest segment first. A duplicate segment is
+ *            reported once as DSACK in the first block.
+ *
+ * sender   - the scoreboard keeps the SACKed ranges above snd_una. Loss
+ *            recovery starts at the dupacks threshold, the holes below the
+ *            highest SACKed sequence are considered lost and are
+ *            retransmitted while pipe < cwnd, then new data is sent.
+ *            The recovery ends when the recovery point is acked. After
+ *            RTO the SACKed data is not sent again.
+ */
+
+const (
+	TCP_MAX_SACK       = 4  /* max SACK blocks in one segment */
+	TCP_MAX_REASS_SEGS = 64 /* max out of order segments */
+
+	TCPOPT_SACK_PERMITTED  = 4
+	TCPOLEN_SACK_PERMITTED = 2
+	TCPOPT_SACK            = 5
+	TCPOLEN_SACK           = 8 /* len of sack block */
+
+	TCPOPT_SACK_PERMIT_HDR = (TCPOPT_NOP<<24 | TCPOPT_NOP<<16 | TCPOPT_SACK_PERMITTED<<8 | TCPOLEN_SACK_PERMITTED)
+	TCPOPT_SACK_HDR        = (TCPOPT_NOP<<24 | TCPOPT_NOP<<16 | TCPOPT_SACK<<8)
+)
+
+type tcpSackBlock struct {
+	start uint32
+	end   uint32
+}
+
+type tcpReassSeg struct {
+	seq  uint32
+	data []byte
+	fin  bool
+}
+
+// merge a block into a sorted list of disjoint blocks
+func sackMerge(l []tcpSackBlock, b tcpSackBlock) []tcpSackBlock {
+	r := make([]tcpSackBlock, 0, len(l)+1)
+	i := 0
+	for ; i < len(l) && seq_lt(l[i].end, b.start); i++ {
+		r = append(r, l[i])
+	}
+	for ; i < len(l) && seq_leq(l[i].start, b.end); i++ {
+		if seq_lt(l[i].start, b.start) {
+			b.start = l[i].start
+		}
+		if seq_gt(l[i].end, b.end) {
+			b.end = l[i].end
+		}
+	}
+	r = append(r, b)
+	return append(r, l[i:]...)
+}
+
+/* sender side */
+
+// update the scoreboard with the blocks of the last received segment and the cumulative ack
+func (o *TcpSocket) sackDoAck(ack uint32) {
+	sts := &o.ctx.tcpStats
+	for i := 0; i < int(o.sackRxCnt); i++ {
+		b := o.sackRx[i]
+		if i == 0 {
+			if seq_leq(b.end, ack) ||
+				((o.sackRxCnt > 1) && seq_geq(b.start, o.sackRx[1].start) && seq_leq(b.end, o.sackRx[1].end)) {
+				sts.tcps_dsack_rcv++
+				continue
+			}
+		}
+		if !seq_lt(b.start, b.end) || seq_leq(b.end, ack) || seq_gt(b.end, o.snd_max) {
+			continue
+		}
+		if seq_lt(b.start, ack) {
+			b.start = ack
+		}
+		sts.tcps_sack_rcv_blocks++
+		o.scoreboard = sackMerge(o.scoreboard, b)
+	}
+
+	/* remove what was acked */
+	i := 0
+	for ; i < len(o.scoreboard) && seq_leq(o.scoreboard[i].end, ack); i++ {
+	}
+	o.scoreboard = o.scoreboard[i:]
+	if len(o.scoreboard) > 0 && seq_lt(o.scoreboard[0].start, ack) {
+		o.scoreboard[0].start = ack
+	}
+}
+
+// the highest SACKed sequence
+func (o *TcpSocket) sackFack() uint32 {
+	if len(o.scoreboard) == 0 {
+		return o.snd_una
+	}
+	return o.scoreboard[len(o.scoreboard)-1].end
+}
+
+// estimation of the bytes in flight, the holes below the highest SACKed sequence are lost
+// unless they were retransmitted
+func (o *TcpSocket) sackPipe() uint32 {
+	pipe := o.snd_max - o.sackFack()
+	s := o.snd_una
+	for _, b := range o.scoreboard {
+		if seq_gt(o.sackHighRxt, s) {
+			e := b.start
+			if seq_lt(o.sackHighRxt, e) {
+				e = o.sackHighRxt
+			}
+			pipe += e - s
+		}
+		s = b.end
+	}
+	return pipe
+}
+
+// the window that is left for new data in recovery
+func (o *TcpSocket) sackAwnd() uint32 {
+	pipe := o.sackPipe()
+	if pipe >= o.snd_cwnd {
+		return 0
+	}
+	return o.snd_cwnd - pipe
+}
+
+// the next hole to retransmit, returns the length, zero in case there is nothing to retransmit
+func (o *TcpSocket) sackNextHole(seq *uint32) uint32 {
+	if !o.sackForce && o.sackAwnd() < uint32(o.maxseg) {
+		return 0
+	}
+	o.sackForce = false
+	if len(o.scoreboard) == 0 {
+		/* no SACK information, the first segment */
+		if seq_leq(o.sackHighRxt, o.snd_una) && seq_lt(o.snd_una, o.snd_max) {
+			*seq = o.snd_una
+			return bsd_umin(o.snd_max-o.snd_una, uint32(o.maxseg))
+		}
+		return 0
+	}
+	s := o.snd_una
+	for _, b := range o.scoreboard {
+		if seq_lt(s, o.sackHighRxt) {
+			s = o.sackHighRxt
+		}
+		if seq_lt(s, b.start) {
+			*seq = s
+			return bsd_umin(b.start-s, uint32(o.maxseg))
+		}
+		s = b.end
+	}
+	return 0
+}
+
+func (o *TcpSocket) sackEnterRecovery() {
+	o.ccCongSignal(CC_NDUPACK)
+	o.sackRecovery = true
+	o.sackForce = true
+	o.sackRecover = o.snd_max
+	o.sackHighRxt = o.snd_una
+	o.timer[TCPT_REXMT] = 0
+	o.rtt = 0
+	o.snd_cwnd = o.snd_ssthresh
+	o.ctx.tcpStats.tcps_sack_recovery++
+}
+
+// the recovery point was acked
+func (o *TcpSocket) sackExitRecovery() {
+	o.sackRecovery = false
+	o.sackForce = false
+	o.ccPostRecovery()
+}
+
+// retransmit timeout, the scoreboard is kept for the first timeout and cleared
+// in case of a repeated timeout (the receiver may have reneged)
+func (o *TcpSocket) sackOnRto() {
+	if o.rxtshift > 1 {
+		o.scoreboard = nil
+	}
+	o.sackRecovery = false
+	o.sackForce = false
+}
+
+// move seq over the SACKed data when going back after RTO, returns the bytes
+// until the next SACKed block (zero - no limit)
+func (o *TcpSocket) sackSkip(seq *uint32) uint32 {
+	for _, b := range o.scoreboard {
+		if seq_lt(*seq, b.start) {
+			return b.start - *seq
+		}
+		if seq_lt(*seq, b.end) {
+			*seq = b.end
+		}
+	}
+	return 0
+}
+
+/* receiver side */
+
+// insert out of order data, only the parts that are not in the queue are kept
+func (o *TcpSocket) reassInsert(seq uint32, d []byte, fin bool) {
+	sts := &o.ctx.tcpStats
+	end := seq + uint32(len(d))
+
+	if (len(o.reassq) >= TCP_MAX_REASS_SEGS) ||
+		(o.reassBytes+uint32(len(d)) > o.socket.so_rcv.sb_hiwat) {
+		sts.tcps_rcvoopackdrop++
+		sts.tcps_rcvoobytesdrop += uint64(len(d))
+		return
+	}
+
+	var added uint32
+	nq := make([]tcpReassSeg, 0, len(o.reassq)+1)
+	add := func(s, e uint32) {
+		nd := make([]byte, e-s)
+		copy(nd, d[s-seq:e-seq])
+		nq = append(nq, tcpReassSeg{seq: s, data: nd, fin: fin && (e == end)})
+		added += e - s
+	}
+	cur := seq
+	for _, q := range o.reassq {
+		qend := q.seq + uint32(len(q.data))
+		if seq_lt(cur, end) && seq_lt(cur, q.seq) {
+			e := end
+			if seq_lt(q.seq, e) {
+				e = q.seq
+			}
+			add(cur, e)
+			cur = e
+		}
+		nq = append(nq, q)
+		if seq_geq(cur, q.seq) && seq_lt(cur, qend) {
+			cur = qend
+			if seq_gt(cur, end) {
+				cur = end
+			}
+		}
+	}
+	if seq_lt(cur, end) {
+		add(cur, end)
+	}
+
+	if added == 0 {
+		sts.tcps_rcvduppack++
+		sts.tcps_rcvdupbyte += uint64(len(d))
+		o.sackDupRcvd(seq, end)
+		return
+	}
+	o.reassq = nq
+	o.reassBytes += added
+	o.reassLast = seq
+	sts.tcps_rcvoopack++
+	sts.tcps_rcvoobyte += uint64(added)
+}
+
+// release the in order data of the queue, return TH_FIN in case the FIN was released
+func (o *TcpSocket) reassPull() uint8 {
+	sts := &o.ctx.tcpStats
+	var fin uint8
+	for len(o.reassq) > 0 {
+		q := &o.reassq[0]
+		if seq_gt(q.seq, o.rcv_nxt) {
+			break
+		}
+		d := q.data
+		skip := o.rcv_nxt - q.seq
+		if skip < uint32(len(d)) {
+			d = d[skip:]
+			o.reassOut = append(o.reassOut, d)
+			o.rcv_nxt += uint32(len(d))
+			sts.tcps_rcvpack++
+			sts.tcps_rcvbyte += uint64(len(d))
+			if q.fin {
+				fin = TH_FIN
+			}
+		}
+		o.reassBytes -= uint32(len(q.data))
+		o.reassq = o.reassq[1:]
+	}
+	if len(o.reassOut) > 0 {
+		o.cbmask |= SocketRxData
+	}
+	return fin
+}
+
+// a duplicate segment was received, report it as DSACK
+func (o *TcpSocket) sackDupRcvd(start, end uint32) {
+	if (o.flags&TF_SACK_PERMIT) == 0 || !seq_lt(start, end) {
+		return
+	}
+	o.dsack.start = start
+	o.dsack.end = end
+	o.dsackPending = true
+}
+
+// add the SACK option, returns the new options length
+func (o *TcpSocket) sackAddOption(opt []byte, optlen uint16) uint16 {
+	sts := &o.ctx.tcpStats
+	var blocks [TCP_MAX_SACK]tcpSackBlock
+	var cnt int
+
+	max := (MAX_TCPOPTLEN - int(optlen) - 4) / TCPOLEN_SACK
+	if max > TCP_MAX_SACK {
+		max = TCP_MAX_SACK
+	}
+	if max <= 0 {
+		return optlen
+	}
+
+	if o.dsackPending {
+		blocks[cnt] = o.dsack
+		cnt++
+		o.dsackPending = false
+		sts.tcps_dsack_snd++
+	}
+
+	/* contiguous ranges of the queue, the one with the latest segment first */
+	var ranges []tcpSackBlock
+	for _, q := range o.reassq {
+		b := tcpSackBlock{q.seq, q.seq + uint32(len(q.data))}
+		if n := len(ranges); n > 0 && ranges[n-1].end == b.start {
+			ranges[n-1].end = b.end
+		} else {
+			ranges = append(ranges, b)
+		}
+	}
+	for i, b := range ranges {
+		if seq_geq(o.reassLast, b.start) && seq_lt(o.reassLast, b.end) {
+			if cnt < max {
+				blocks[cnt] = b
+				cnt++
+			}
+			ranges = append(ranges[:i:i], ranges[i+1:]...)
+			break
+		}
+	}
+	for _, b := range ranges {
+		if cnt >= max {
+			break
+		}
+		blocks[cnt] = b
+		cnt++
+	}
+	if cnt == 0 {
+		return optlen
+	}
+
+	binary.BigEndian.PutUint32(opt[optlen:optlen+4], uint32(TCPOPT_SACK_HDR|(2+TCPOLEN_SACK*cnt)))
+	optlen += 4
+	for i := 0; i < cnt; i++ {
+		binary.BigEndian.PutUint32(opt[optlen:optlen+4], blocks[i].start)
+		binary.BigEndian.PutUint32(opt[optlen+4:optlen+8], blocks[i].end)
+		optlen += TCPOLEN_SACK
+	}
+	sts.tcps_sack_snd_blocks += uint64(cnt)
+	return optlen
+}
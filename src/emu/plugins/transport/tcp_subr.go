@@ -7,6 +7,7 @@ package transport
 
 import (
 	"emu/core"
+	"fmt"
 	"net"
 	"time"
 )
@@ -21,6 +22,10 @@ const (
 	TCP_IOCTL_DELAY_ACK_MSEC = "delay_ack_msec"   // msec of fast tcp time
 	TCP_IOCTL_TX_BUF_SIZE    = "txbufsize"        // tx queue in bytes, can be change only in case the queue if empty
 	TCP_IOCTL_RX_BUF_SIZE    = "rxbufsize"        // rx queue in bytes
+	TCP_IOCTL_CC             = "cc"               // congestion control module, "newreno" or "cubic"
+	TCP_IOCTL_CWND           = "cwnd"             // congestion window in bytes, read only
+	TCP_IOCTL_SSTHRESH       = "ssthresh"         // slow start threshold in bytes, read only
+	TCP_IOCTL_SACK           = "sack"             // 1 - request SACK, can be changed only before the connection
 )
 
 func (o *TcpSocket) SetIoctl(m IoctlMap) error {
@@ -149,6 +154,34 @@ func (o *TcpSocket) SetIoctl(m IoctlMap) error {
 		}
 	}
 
+	val, prs = m[TCP_IOCTL_SACK]
+	if prs {
+		sack, ok := getAsInt(val)
+		if ok && o.state <= TCPS_LISTEN {
+			if sack > 0 {
+				o.flags |= TF_REQ_SACK
+			} else {
+				o.flags &= ^TF_REQ_SACK
+			}
+		}
+	}
+
+	val, prs = m[TCP_IOCTL_CC]
+	if prs {
+		name, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("invalid congestion control %v", val)
+		}
+		if name != o.cc.name() {
+			cc, err := newTcpCc(name)
+			if err != nil {
+				return err
+			}
+			o.cc = cc
+		}
+		o.ccSelected = true
+	}
+
 	return nil
 }
 
@@ -173,6 +206,14 @@ func (o *TcpSocket) GetIoctl(m IoctlMap) error {
 	m[TCP_IOCTL_NODELAY_CNT] = int(o.fastMsec)
 	m[TCP_IOCTL_TX_BUF_SIZE] = int(o.socket.so_snd.sb_hiwat)
 	m[TCP_IOCTL_RX_BUF_SIZE] = int(o.socket.so_rcv.sb_hiwat)
+	m[TCP_IOCTL_CC] = o.cc.name()
+	if (o.flags & TF_SACK_PERMIT) > 0 {
+		m[TCP_IOCTL_SACK] = 1
+	} else {
+		m[TCP_IOCTL_SACK] = 0
+	}
+	m[TCP_IOCTL_CWND] = int(o.snd_cwnd)
+	m[TCP_IOCTL_SSTHRESH] = int(o.snd_ssthresh)
 	return nil
 }
 
@@ -508,6 +549,10 @@ func (o *TcpSocket) init(client *core.CClient, ctx *TransportCtx) {
 		o.flags |= (TF_REQ_SCALE | TF_REQ_TSTMP)
 	}
 
+	if ctx.tcp_do_sack {
+		o.flags |= TF_REQ_SACK
+	}
+
 	if (ctx.tcp_no_delay & NO_DELAY_MASK_NAGLE) > 0 {
 		o.flags |= TF_NODELAY
 	}
@@ -528,6 +573,8 @@ func (o *TcpSocket) init(client *core.CClient, ctx *TransportCtx) {
 	o.snd_cwnd = TCP_MAXWIN << TCP_MAX_WINSHIFT
 	o.snd_ssthresh = TCP_MAXWIN << TCP_MAX_WINSHIFT
 	o.tcp_no_delay_counter = ctx.tcp_no_delay_counter
+	o.cc, _ = newTcpCc(ctx.tcp_cc)
+	o.ccSelected = ctx.tcp_cc_selected
 
 	/* set the timers */
 	o.fasttimer.SetCB(&o.fastTimerCb, o, 0)
@@ -113,15 +113,11 @@ func (o *TcpSocket) timers(timer int) {
 		 * growth is 2 mss.  We don't allow the threshhold
 		 * to go below this.)
 		 */
-		{
-			win := bsd_umin(o.snd_wnd, o.snd_cwnd) / 2 / uint32(o.maxseg)
-			if win < 2 {
-				win = 2
-			}
-			o.snd_cwnd = uint32(o.maxseg)
-			o.snd_ssthresh = uint32(win) * uint32(o.maxseg)
-			o.dupacks = 0
-		}
+		o.ccCongSignal(CC_RTO)
+		o.fastRecovery = false
+		o.snd_recover = o.snd_max
+		o.sackOnRto()
+		o.dupacks = 0
 		o.output()
 
 	/*
@@ -0,0 +1,700 @@
+// Copyright (c) 2020 Cisco Systems and/or its affiliates.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package transport
+
+/*
+TLS 1.2/1.3 sockets on top of the TCP sockets of the transport layer.
+
+TlsSocket implements SocketApi for the application and ISocketCb for the TCP socket below it. The TLS is a
+core.TlsSession that is stepped by the TCP callbacks: the TCP data is pushed to the session and its output is
+written to the TCP socket.
+
+The application gets SocketEventConnected after the handshake, the data that is written before it is queued.
+The client sessions of the TransportCtx share a session cache, so a new session to the same server (SNI or
+address) is resumed. The server sessions of a listener share one tls.Config, for the session ticket keys.
+
+Dial/Listen with "tls" network use the "tls" section of the transport init json, DialTls/ListenTls get the
+configuration explicitly.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"emu/core"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	TLS_SESSION_CACHE_SIZE = 64    // client sessions per TransportCtx for resumption
+	TLS_RX_BUF_SIZE        = 16384 // max plaintext of a record
+
+	TLS_IOCTL_VERSION = "tls_version" // negotiated version "1.2"/"1.3", read only
+	TLS_IOCTL_CIPHER  = "tls_cipher"  // negotiated cipher suite, read only
+	TLS_IOCTL_ALPN    = "tls_alpn"    // negotiated application protocol, read only
+	TLS_IOCTL_RESUMED = "tls_resumed" // 1 in case the session was resumed, read only
+	TLS_IOCTL_SNI     = "tls_sni"     // server name of the client, read only
+)
+
+// TlsCfg configuration of TLS sockets, "tls" of the transport init json
+type TlsCfg struct {
+	ServerName   *string  `json:"sni"`                                            // client - SNI and the name to verify
+	Alpn         []string `json:"alpn"`                                           // application protocols by preference e.g. ["h2", "http/1.1"]
+	MinVersion   *string  `json:"min_version" validate:"omitempty,oneof=1.2 1.3"` // default 1.2
+	MaxVersion   *string  `json:"max_version" validate:"omitempty,oneof=1.2 1.3"` // default 1.3
+	CipherSuites []string `json:"ciphers"`                                        // TLS 1.2 cipher suites by name e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	CaCert       *string  `json:"ca_cert"`                                        // PEM, client - verify the server, server - require a client certificate
+	Cert         *string  `json:"cert"`                                           // PEM certificate, required for server
+	Key          *string  `json:"key"`                                            // PEM private key of the certificate
+	Resumption   *bool    `json:"resumption"`                                     // session cache/tickets, default true
+}
+
+type tlsStats struct {
+	tls_dial              uint64
+	tls_accept            uint64
+	tls_handshake_ok      uint64
+	tls_handshake_err     uint64
+	tls_resumed           uint64
+	tls_session_err       uint64
+	tls_cfg_err           uint64
+	tls_tx_bytes          uint64
+	tls_rx_bytes          uint64
+	tls_write_before_conn uint64
+	tls_close_notify_rcv  uint64
+}
+
+func newTlsStatsDb(o *tlsStats) *core.CCounterDb {
+	db := core.NewCCounterDb("tls")
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_dial,
+		Name:     "tls_dial",
+		Help:     "tls client sessions",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_accept,
+		Name:     "tls_accept",
+		Help:     "tls server sessions",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_ok,
+		Name:     "tls_handshake_ok",
+		Help:     "successful handshakes",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_handshake_err,
+		Name:     "tls_handshake_err",
+		Help:     "failed handshakes",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_resumed,
+		Name:     "tls_resumed",
+		Help:     "resumed sessions",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_session_err,
+		Name:     "tls_session_err",
+		Help:     "session error after the handshake",
+		Unit:     "sessions",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_cfg_err,
+		Name:     "tls_cfg_err",
+		Help:     "invalid tls configuration",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScERROR})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_tx_bytes,
+		Name:     "tls_tx_bytes",
+		Help:     "application bytes sent",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_rx_bytes,
+		Name:     "tls_rx_bytes",
+		Help:     "application bytes received",
+		Unit:     "bytes",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_write_before_conn,
+		Name:     "tls_write_before_conn",
+		Help:     "write before the handshake, queued",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	db.Add(&core.CCounterRec{
+		Counter:  &o.tls_close_notify_rcv,
+		Name:     "tls_close_notify_rcv",
+		Help:     "close notify from the peer",
+		Unit:     "event",
+		DumpZero: false,
+		Info:     core.ScINFO})
+
+	return db
+}
+
+type tlsCfgKey struct {
+	cfg    *TlsCfg
+	server bool
+}
+
+// tlsCtx TLS information of the TransportCtx
+type tlsCtx struct {
+	stats     tlsStats
+	cdb       *core.CCounterDb
+	cfg       *TlsCfg                   // default configuration of "tls" network
+	cfgs      map[tlsCfgKey]*tls.Config // built configuration, the server ticket keys are per tls.Config
+	cache     tls.ClientSessionCache    // client sessions for resumption
+	listeners map[IServerSocketCb]*tlsListener
+	active    map[*TlsSocket]bool // sessions with a goroutine
+}
+
+func (o *tlsCtx) init() {
+	o.cdb = newTlsStatsDb(&o.stats)
+	o.cfgs = make(map[tlsCfgKey]*tls.Config)
+	o.listeners = make(map[IServerSocketCb]*tlsListener)
+	o.active = make(map[*TlsSocket]bool)
+}
+
+func (o *tlsCtx) onRemove() {
+	for s := range o.active {
+		s.closeSession()
+	}
+}
+
+func tlsParseVersion(v *string, def uint16) uint16 {
+	if v == nil {
+		return def
+	}
+	switch *v {
+	case "1.2":
+		return tls.VersionTLS12
+	case "1.3":
+		return tls.VersionTLS13
+	}
+	return def
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+func tlsCipherSuite(name string) (uint16, bool) {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == name {
+			return c.ID, true
+		}
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		if c.Name == name {
+			return c.ID, true
+		}
+	}
+	return 0, false
+}
+
+// getTlsConfig build the tls.Config of cfg, the same object is returned for the same cfg
+func (o *TransportCtx) getTlsConfig(cfg *TlsCfg, server bool) (*tls.Config, error) {
+	key := tlsCfgKey{cfg, server}
+	if c, ok := o.tls.cfgs[key]; ok {
+		return c, nil
+	}
+
+	c := &tls.Config{
+		MinVersion: tlsParseVersion(cfg.MinVersion, tls.VersionTLS12),
+		MaxVersion: tlsParseVersion(cfg.MaxVersion, tls.VersionTLS13),
+		NextProtos: cfg.Alpn,
+	}
+	if c.MinVersion > c.MaxVersion {
+		return nil, fmt.Errorf("min_version is higher than max_version")
+	}
+	for _, name := range cfg.CipherSuites {
+		id, ok := tlsCipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		c.CipherSuites = append(c.CipherSuites, id)
+	}
+
+	var pool *x509.CertPool
+	if cfg.CaCert != nil {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(*cfg.CaCert)) {
+			return nil, fmt.Errorf("invalid ca_cert")
+		}
+	}
+
+	if (cfg.Cert != nil) && (cfg.Key != nil) {
+		cert, err := tls.X509KeyPair([]byte(*cfg.Cert), []byte(*cfg.Key))
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	} else if server {
+		return nil, fmt.Errorf("tls server requires cert and key")
+	}
+
+	resumption := true
+	if cfg.Resumption != nil {
+		resumption = *cfg.Resumption
+	}
+
+	if server {
+		if pool != nil {
+			c.ClientCAs = pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		c.SessionTicketsDisabled = !resumption
+	} else {
+		if cfg.ServerName != nil {
+			c.ServerName = *cfg.ServerName
+		}
+		if pool != nil {
+			c.RootCAs = pool
+		} else {
+			c.InsecureSkipVerify = true // no verification in case there is no CA
+		}
+		if resumption {
+			if o.tls.cache == nil {
+				o.tls.cache = tls.NewLRUClientSessionCache(TLS_SESSION_CACHE_SIZE)
+			}
+			c.ClientSessionCache = o.tls.cache
+		}
+	}
+	o.tls.cfgs[key] = c
+	return c, nil
+}
+
+// tlsListener accept callback of the TCP listener
+type tlsListener struct {
+	ctx *TransportCtx
+	cfg *tls.Config
+	cb  IServerSocketCb
+}
+
+func (o *tlsListener) OnAccept(socket SocketApi) ISocketCb {
+	s := newTlsSocket(o.ctx, socket, nil, o.cfg, true)
+	cb := o.cb.OnAccept(s)
+	if cb == nil {
+		return nil
+	}
+	s.cb = cb
+	o.ctx.tls.stats.tls_accept++
+	s.startSession()
+	return s
+}
+
+// TlsSocket TLS session over a TCP socket
+type TlsSocket struct {
+	ctx    *TransportCtx
+	s      SocketApi // the TCP socket
+	cb     ISocketCb // the application
+	cfg    *tls.Config
+	server bool
+
+	sess *core.TlsSession
+
+	handshakeDone bool // set by the session
+	connected     bool // the application got SocketEventConnected
+	closed        bool // Close/Shutdown by the application
+	remoteClosed  bool // the application got SocketRemoteDisconnect
+	lastErr       SocketErr
+
+	rx      []byte   // plaintext from the session
+	rxBuf   []byte   // read buffer of the session
+	txq     [][]byte // records that wait for the TCP queue
+	tcpWait bool     // the TCP queue is full, wait for SocketTxMore
+	appWait bool     // the application waits for SocketTxMore
+	appTx   [][]byte // application data before the handshake
+}
+
+func newTlsSocket(ctx *TransportCtx, s SocketApi, cb ISocketCb, cfg *tls.Config, server bool) *TlsSocket {
+	o := new(TlsSocket)
+	o.ctx = ctx
+	o.s = s
+	o.cb = cb
+	o.cfg = cfg
+	o.server = server
+	return o
+}
+
+// startSession run the session until it waits for data
+func (o *TlsSocket) startSession() {
+	o.rxBuf = make([]byte, TLS_RX_BUF_SIZE)
+	o.sess = core.NewTlsSession(o.cfg, o.server, o.s.LocalAddr(), o.s.RemoteAddr())
+	o.ctx.tls.active[o] = true
+	o.sess.Start(o.run)
+	o.onStep()
+}
+
+// run the session goroutine, the handshake and then read until an error
+func (o *TlsSocket) run(c *tls.Conn) error {
+	err := c.Handshake()
+	if err != nil {
+		return err
+	}
+	o.handshakeDone = true
+	for {
+		n, err := c.Read(o.rxBuf)
+		if n > 0 {
+			o.rx = append(o.rx, o.rxBuf[:n]...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// step push TCP data to the session
+func (o *TlsSocket) step(d []byte) {
+	if !o.sess.Running() || len(d) == 0 {
+		return
+	}
+	o.sess.Step(d)
+	o.onStep()
+}
+
+// closeSession end the goroutine of the session
+func (o *TlsSocket) closeSession() {
+	o.sess.Close()
+	delete(o.ctx.tls.active, o)
+}
+
+// onStep handle the output of the session
+func (o *TlsSocket) onStep() {
+	sts := &o.ctx.tls.stats
+	o.flush()
+
+	if o.handshakeDone && !o.connected && !o.closed {
+		o.connected = true
+		sts.tls_handshake_ok++
+		if o.sess.Conn.ConnectionState().DidResume {
+			sts.tls_resumed++
+		}
+		for _, d := range o.appTx {
+			o.write(d)
+		}
+		o.appTx = nil
+		o.cb.OnRxEvent(SocketEventConnected)
+	}
+
+	if len(o.rx) > 0 {
+		d := o.rx
+		o.rx = nil
+		sts.tls_rx_bytes += uint64(len(d))
+		if !o.closed {
+			o.cb.OnRxData(d)
+		}
+	}
+
+	if o.sess.Running() {
+		return
+	}
+	delete(o.ctx.tls.active, o)
+	if o.sess.Err() == io.EOF {
+		// close notify of the peer
+		sts.tls_close_notify_rcv++
+		o.remoteDisconnect()
+		return
+	}
+	if !o.handshakeDone {
+		sts.tls_handshake_err++
+	} else {
+		sts.tls_session_err++
+	}
+	o.lastErr = SeECONNABORTED
+	if !o.closed {
+		o.closed = true
+		o.s.Close() // after the alert
+	}
+}
+
+func (o *TlsSocket) remoteDisconnect() {
+	if !o.remoteClosed && !o.closed {
+		o.remoteClosed = true
+		o.cb.OnRxEvent(SocketRemoteDisconnect)
+	}
+}
+
+// flush move the output of the session to the TCP socket
+func (o *TlsSocket) flush() {
+	if d := o.sess.TakeOut(); d != nil {
+		o.txq = append(o.txq, d)
+	}
+	for len(o.txq) > 0 && !o.tcpWait {
+		d := o.txq[0]
+		o.txq = o.txq[1:]
+		err, queued := o.s.Write(d)
+		if err != SeOK && err != SeUNRESOLVED {
+			o.txq = nil
+			return
+		}
+		if !queued {
+			o.tcpWait = true
+		}
+	}
+}
+
+func (o *TlsSocket) write(buf []byte) {
+	o.ctx.tls.stats.tls_tx_bytes += uint64(len(buf))
+	o.sess.Conn.Write(buf)
+	o.flush()
+	if o.tcpWait {
+		o.appWait = true
+	}
+}
+
+// OnRxEvent implements ISocketCb for the TCP socket
+func (o *TlsSocket) OnRxEvent(event SocketEventType) {
+	if (event&SocketEventConnected) > 0 && !o.server && o.sess == nil {
+		o.startSession()
+	}
+	if (event & SocketRemoteDisconnect) > 0 {
+		o.remoteDisconnect()
+	}
+	if (event & SocketClosed) > 0 {
+		if o.sess != nil {
+			if o.sess.Running() && !o.handshakeDone {
+				o.ctx.tls.stats.tls_handshake_err++
+			}
+			o.closeSession()
+		}
+		o.cb.OnRxEvent(SocketClosed)
+	}
+}
+
+// OnRxData implements ISocketCb for the TCP socket
+func (o *TlsSocket) OnRxData(d []byte) {
+	o.step(d)
+}
+
+// OnTxEvent implements ISocketCb for the TCP socket
+func (o *TlsSocket) OnTxEvent(event SocketEventType) {
+	if (event & SocketTxMore) > 0 {
+		o.tcpWait = false
+		if o.sess != nil {
+			o.flush()
+		}
+	}
+	if o.tcpWait || len(o.txq) > 0 || !o.connected || o.closed {
+		return
+	}
+	if o.appWait {
+		o.appWait = false
+		o.cb.OnTxEvent(event | SocketTxMore)
+		return
+	}
+	if (event & SocketTxEmpty) > 0 {
+		o.cb.OnTxEvent(SocketTxEmpty)
+	}
+}
+
+// Close send close notify and close the TCP socket after the tx queue was flushed
+func (o *TlsSocket) Close() SocketErr {
+	if o.closed {
+		return SeCONNECTION_IS_CLOSED
+	}
+	o.closed = true
+	if o.handshakeDone && o.sess.Running() {
+		o.sess.Conn.CloseWrite()
+		o.flush()
+	}
+	return o.s.Close()
+}
+
+// Shutdown close the TCP socket immediately
+func (o *TlsSocket) Shutdown() SocketErr {
+	o.closed = true
+	return o.s.Shutdown()
+}
+
+func (o *TlsSocket) LocalAddr() net.Addr {
+	return o.s.LocalAddr()
+}
+
+func (o *TlsSocket) RemoteAddr() net.Addr {
+	return o.s.RemoteAddr()
+}
+
+func (o *TlsSocket) GetCap() SocketCapType {
+	return SocketCapStream | SocketCapConnection
+}
+
+func (o *TlsSocket) GetLastError() SocketErr {
+	if o.lastErr != SeOK {
+		return o.lastErr
+	}
+	return o.s.GetLastError()
+}
+
+func (o *TlsSocket) SetIoctl(m IoctlMap) error {
+	return o.s.SetIoctl(m)
+}
+
+// GetIoctl the TCP values and the TLS values of the session after the handshake
+func (o *TlsSocket) GetIoctl(m IoctlMap) error {
+	if err := o.s.GetIoctl(m); err != nil {
+		return err
+	}
+	if !o.connected {
+		return nil
+	}
+	cs := o.sess.Conn.ConnectionState()
+	m[TLS_IOCTL_VERSION] = tlsVersionName(cs.Version)
+	m[TLS_IOCTL_CIPHER] = tls.CipherSuiteName(cs.CipherSuite)
+	m[TLS_IOCTL_ALPN] = cs.NegotiatedProtocol
+	m[TLS_IOCTL_SNI] = cs.ServerName
+	if cs.DidResume {
+		m[TLS_IOCTL_RESUMED] = 1
+	} else {
+		m[TLS_IOCTL_RESUMED] = 0
+	}
+	return nil
+}
+
+// Write queue the data before the handshake, encrypt it after the handshake
+func (o *TlsSocket) Write(buf []byte) (err SocketErr, queued bool) {
+	if o.closed {
+		return SeCONNECTION_IS_CLOSED, false
+	}
+	if o.appWait {
+		return SeWRITE_WHILE_DRAIN, false
+	}
+	if len(buf) == 0 {
+		return SeOK, true
+	}
+	if !o.connected {
+		o.ctx.tls.stats.tls_write_before_conn++
+		o.appTx = append(o.appTx, append([]byte{}, buf...))
+		return SeOK, true
+	}
+	o.write(buf)
+	return SeOK, !o.appWait
+}
+
+func (o *TlsSocket) GetL7MTU() uint16 {
+	return o.s.GetL7MTU()
+}
+
+func (o *TlsSocket) IsIPv6() bool {
+	return o.s.IsIPv6()
+}
+
+// GetSocket return the TCP socket
+func (o *TlsSocket) GetSocket() interface{} {
+	return o.s.GetSocket()
+}
+
+// ConnectionState the state of the session, valid after SocketEventConnected
+func (o *TlsSocket) ConnectionState() tls.ConnectionState {
+	return o.sess.Conn.ConnectionState()
+}
+
+/*
+DialTls open a TLS session to address over TCP, the same as Dial with cfg as the TLS configuration.
+The callback gets SocketEventConnected after the handshake.
+*/
+func (o *TransportCtx) DialTls(address string, cb ISocketCb, ioctl IoctlMap, dstMac *core.MACKey, srcPort uint16, cfg *TlsCfg) (SocketApi, error) {
+	if cb == nil {
+		return nil, fmt.Errorf(" callback should not be nil ")
+	}
+	if cfg == nil {
+		cfg = &TlsCfg{}
+	}
+	c, err := o.getTlsConfig(cfg, false)
+	if err != nil {
+		o.tls.stats.tls_cfg_err++
+		return nil, err
+	}
+	s := newTlsSocket(o, nil, cb, c, false)
+	ts, err := o.Dial("tcp", address, s, ioctl, dstMac, srcPort)
+	if err != nil {
+		return nil, err
+	}
+	s.s = ts
+	o.tls.stats.tls_dial++
+	return s, nil
+}
+
+/*
+ListenTls accept TLS sessions on TCP address, cfg must have a certificate.
+cb.OnAccept is called with the TLS socket on the TCP accept, the socket callback gets SocketEventConnected after the handshake.
+*/
+func (o *TransportCtx) ListenTls(address string, cb IServerSocketCb, cfg *TlsCfg) error {
+	if cfg == nil {
+		cfg = &TlsCfg{}
+	}
+	c, err := o.getTlsConfig(cfg, true)
+	if err != nil {
+		o.tls.stats.tls_cfg_err++
+		return err
+	}
+	if _, ok := o.tls.listeners[cb]; ok {
+		return fmt.Errorf(" callback already listens ")
+	}
+	l := &tlsListener{ctx: o, cfg: c, cb: cb}
+	if err := o.Listen("tcp", address, l); err != nil {
+		return err
+	}
+	o.tls.listeners[cb] = l
+	return nil
+}
+
+// UnListenTls see ListenTls
+func (o *TransportCtx) UnListenTls(address string, cb IServerSocketCb) error {
+	l, ok := o.tls.listeners[cb]
+	if !ok {
+		return fmt.Errorf(" callback does not listen ")
+	}
+	if err := o.UnListen("tcp", address, l); err != nil {
+		return err
+	}
+	delete(o.tls.listeners, cb)
+	return nil
+}
+
+/*
+GetTlsConfig the crypto/tls configuration of cfg, for protocols that run the TLS handshake by themselves (QUIC).
+The object is shared by the sockets of the same cfg and must not be changed, Clone it.
+*/
+func (o *TransportCtx) GetTlsConfig(cfg *TlsCfg, server bool) (*tls.Config, error) {
+	c, err := o.getTlsConfig(cfg, server)
+	if err != nil {
+		o.tls.stats.tls_cfg_err++
+	}
+	return c, err
+}
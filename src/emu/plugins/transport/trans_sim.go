@@ -16,12 +16,13 @@ import (
 )
 
 type simContext struct {
-	ctx    *TransportCtx
-	Client *core.CClient
-	Ns     *core.CNSCtx
-	Tctx   *core.CThreadCtx
-	sim    *transportSim
-	ioctl  map[string]interface{}
+	ctx     *TransportCtx
+	Client  *core.CClient
+	Ns      *core.CNSCtx
+	Tctx    *core.CThreadCtx
+	sim     *transportSim
+	ioctl   map[string]interface{}
+	lastErr SocketErr
 }
 
 func newSimCtx(app iSockeApp, c *core.CClient, server bool, ioctl *map[string]interface{}, params *transportSimParam) *simContext {
@@ -37,32 +38,49 @@ func newSimCtx(app iSockeApp, c *core.CClient, server bool, ioctl *map[string]in
 		net = "udp"
 	}
 
+	if ioctl != nil {
+		o.ioctl = *ioctl // save it for the callback
+	}
 	if server {
-		o.ctx.Listen(net, ":80", app.getServerAcceptCb())
-		if ioctl != nil {
-			o.ioctl = *ioctl // save it for the callback
+		if params.tlss != nil {
+			o.ctx.ListenTls(":80", app.getServerAcceptCb(), params.tlss)
+		} else {
+			o.ctx.Listen(net, ":80", app.getServerAcceptCb())
 		}
 	} else {
-		var mioctl IoctlMap
-		if ioctl != nil {
-			mioctl = *ioctl
-		}
-		d := "48.0.0.1:80"
-		if params.ipv6 {
-			d = "[2001:db8::3000:1]:80"
-		}
-		ap, err := o.ctx.Dial(net, d, app.getCb(), mioctl, nil, 0)
-		if err != nil {
-			fmt.Printf(" ERROR %v \n", err)
+		if !o.dial(app, params) {
 			return nil
 		}
-
-		app.setSocket(ap)
 	}
 	o.ctx.cdbv.Dump()
 	return o
 }
 
+func (o *simContext) dial(app iSockeApp, params *transportSimParam) bool {
+	d := "48.0.0.1:80"
+	if params.ipv6 {
+		d = "[2001:db8::3000:1]:80"
+	}
+	var ap SocketApi
+	var err error
+	if params.tlsc != nil {
+		ap, err = o.ctx.DialTls(d, app.getCb(), o.ioctl, nil, 0, params.tlsc)
+	} else {
+		net := "tcp"
+		if params.udp {
+			net = "udp"
+		}
+		ap, err = o.ctx.Dial(net, d, app.getCb(), o.ioctl, nil, 0)
+	}
+	if err != nil {
+		fmt.Printf(" ERROR %v \n", err)
+		return false
+	}
+
+	app.setSocket(ap)
+	return true
+}
+
 type iSockeApp interface {
 	setSim(ctx *simContext)
 	setSocket(socket SocketApi)
@@ -414,6 +432,8 @@ type SocketAppRR1 struct {
 	timerw       *core.TimerCtx
 	request      []byte
 	response     []byte
+	redial       uint32   // dial again after the close
+	tlsInfo      IoctlMap // ioctl of the TLS socket after it was connected
 }
 
 func (o *SocketAppRR1) setCtx(ctx *core.CThreadCtx) {
@@ -424,6 +444,12 @@ func (o *SocketAppRR1) setSocket(socket SocketApi) {
 }
 
 func (o *SocketAppRR1) OnAccept(socket SocketApi) ISocketCb {
+	if o.socket != nil {
+		// the previous flow is still open, a new one for the next
+		n := &SocketAppRR1{SocketAppBase: o.SocketAppBase, request: o.request, response: o.response}
+		n.socket = nil
+		return n.OnAccept(socket)
+	}
 	o.socket = socket
 	if o.sim.ioctl != nil {
 		o.socket.SetIoctl(o.sim.ioctl)
@@ -488,7 +514,10 @@ func (o *SocketAppRR1) OnRxEvent(event SocketEventType) {
 		fmt.Printf(" clientRx %p %x  \n", o, event)
 	}
 	if (event & SocketEventConnected) > 0 {
-		// nothing to do
+		if _, ok := o.socket.(*TlsSocket); ok {
+			o.tlsInfo = make(IoctlMap)
+			o.socket.GetIoctl(o.tlsInfo)
+		}
 		if o.isClient {
 			if (o.socket.GetCap() & SocketCapConnection) > 0 {
 				o.SendRequest()
@@ -502,7 +531,16 @@ func (o *SocketAppRR1) OnRxEvent(event SocketEventType) {
 	}
 
 	if (event & SocketClosed) > 0 {
+		if o.isClient {
+			o.sim.lastErr = o.socket.GetLastError()
+		}
 		o.socket = nil
+		if o.isClient && o.redial < o.params.redial {
+			o.redial++
+			o.waitResponse = false
+			o.state = 0
+			o.sim.dial(o, o.params)
+		}
 	}
 
 }
@@ -610,11 +648,15 @@ type transportSimParam struct {
 	closeForce              bool // force to close without flush the Tx queue
 	CloseByRst              bool // the server will send the first request
 	drop                    float32
+	dropPkts                []uint32 // drop these packets, by the sim packet counter
 	debug                   bool
 	ioctlc                  *map[string]interface{}
 	ioctls                  *map[string]interface{}
 	ipv6                    bool
 	udp                     bool
+	tlsc                    *TlsCfg // client over TLS
+	tlss                    *TlsCfg // server over TLS
+	redial                  uint32  // r_r client, dial again after the close
 }
 
 type transportSim struct {
@@ -714,7 +756,7 @@ func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 	}
 	ps.L7Len = ps.M.DataLen() - ps.L7
 
-	if (o.sim.param.drop > 0.0) && (rand.Float32() < o.sim.param.drop) {
+	if o.sim.dropPkt(o.cnt) || ((o.sim.param.drop > 0.0) && (rand.Float32() < o.sim.param.drop)) {
 		fmt.Printf(" drop pkt : %d, to_server: %v\n", o.cnt, o.sendToServer)
 		o.m.FreeMbuf()
 		return
@@ -728,6 +770,15 @@ func (o *pktEventTxRx) OnEvent(a, b interface{}) {
 	ps.M.FreeMbuf()
 }
 
+func (o *transportSim) dropPkt(cnt uint32) bool {
+	for _, c := range o.param.dropPkts {
+		if c == cnt {
+			return true
+		}
+	}
+	return false
+}
+
 func (o *transportSim) ProcessTxToRx(m *core.Mbuf) *core.Mbuf {
 	o.cnt++
 	//if o.cnt == 2 {
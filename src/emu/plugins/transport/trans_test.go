@@ -6,7 +6,10 @@
 package transport
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"emu/core"
+	"emu/core/testpki"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -279,6 +282,7 @@ type TransportSimTestBase struct {
 	cbArg1       interface{}
 	cbArg2       interface{}
 	param        transportSimParam
+	check        func(t *testing.T, sim *transportSim) // verify the sim before the compare
 }
 
 func (o *TransportSimTestBase) Run(t *testing.T, compare bool) {
@@ -301,6 +305,9 @@ func (o *TransportSimTestBase) Run(t *testing.T, compare bool) {
 	if acf > 0 {
 		panic(" active flows exists")
 	}
+	if o.check != nil {
+		o.check(t, sim)
+	}
 
 	defer sim.tctx.Delete()
 	sim.tctx.SimRecordCompare(o.testname, t)
@@ -694,6 +701,318 @@ func TestPluginTransIoctl5(t *testing.T) {
 	a.Run(t, false)
 }
 
+// congestion control, random packet drop
+func TestPluginTransCc1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-cc-newreno",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			drop:                    0.05,
+			ioctlc:                  &map[string]interface{}{"cc": "newreno", "txbufsize": 128 * 1024},
+			ioctls:                  &map[string]interface{}{"cc": "newreno", "rxbufsize": 128 * 1024},
+		},
+	}
+	a.Run(t, false)
+}
+
+func TestPluginTransCc2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-cc-cubic",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			drop:                    0.05,
+			ioctlc:                  &map[string]interface{}{"cc": "cubic", "txbufsize": 128 * 1024},
+			ioctls:                  &map[string]interface{}{"cc": "cubic", "rxbufsize": 128 * 1024},
+		},
+	}
+	a.Run(t, false)
+}
+
+// NewReno, a few packets of the same window are lost, recovered by partial acks without RTO
+func TestPluginTransCc4(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-cc-newreno-multi-loss",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			dropPkts:                []uint32{38, 42, 46},
+			ioctlc:                  &map[string]interface{}{"cc": "newreno", "txbufsize": 128 * 1024},
+			ioctls:                  &map[string]interface{}{"cc": "newreno", "rxbufsize": 128 * 1024},
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		s := &sim.client.ctx.tcpStats
+		if s.tcps_cc_recovery != 1 || s.tcps_cc_partial_ack != 2 || s.tcps_cc_rto != 0 {
+			t.Fatalf(" unexpected recovery %v partial acks %v rto %v", s.tcps_cc_recovery, s.tcps_cc_partial_ack, s.tcps_cc_rto)
+		}
+	}
+	a.Run(t, false)
+}
+
+func TestPluginTransCc3(t *testing.T) {
+	_, err := newTcpCc("bbr")
+	if err == nil {
+		t.Fatalf(" bbr should not be supported")
+	}
+	for _, name := range []string{TCP_CC_NEWRENO, TCP_CC_CUBIC} {
+		var s TcpSocket
+		s.maxseg = 1000
+		s.snd_wnd = 64000
+		s.snd_cwnd = 20000
+		s.snd_ssthresh = 10000
+		s.cc, _ = newTcpCc(name)
+		s.cc.congSignal(&s, CC_NDUPACK)
+		fmt.Printf(" %s ssthresh after dupacks %v\n", name, s.snd_ssthresh)
+		if s.snd_ssthresh >= 20000 || s.snd_ssthresh < 2000 {
+			t.Fatalf(" %s invalid ssthresh %v", name, s.snd_ssthresh)
+		}
+		s.cc.congSignal(&s, CC_RTO)
+		if s.snd_cwnd != 1000 {
+			t.Fatalf(" %s cwnd should collapse to one segment %v", name, s.snd_cwnd)
+		}
+	}
+}
+
+// SACK, random packet drop
+func TestPluginTransSack1(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-sack1",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			drop:                    0.05,
+			ioctlc:                  &map[string]interface{}{"sack": 1, "txbufsize": 128 * 1024},
+			ioctls:                  &map[string]interface{}{"sack": 1, "rxbufsize": 128 * 1024},
+		},
+	}
+	a.Run(t, false)
+}
+
+// SACK with cubic, server -> client
+func TestPluginTransSack2(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-sack2",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     200 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "s_c",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			drop:                    0.05,
+			ioctlc:                  &map[string]interface{}{"sack": 1, "rxbufsize": 128 * 1024},
+			ioctls:                  &map[string]interface{}{"sack": 1, "cc": "cubic", "txbufsize": 128 * 1024},
+		},
+	}
+	a.Run(t, false)
+}
+
+// only the client requests SACK, not negotiated
+func TestPluginTransSack3(t *testing.T) {
+	a := &TransportSimTestBase{
+		testname:     "tcp-sack3",
+		monitor:      false,
+		match:        0,
+		capture:      true,
+		duration:     10 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 1024,
+			chunkSize:               1024,
+			closeByClient:           true,
+			ioctlc:                  &map[string]interface{}{"sack": 1},
+		},
+	}
+	a.Run(t, false)
+}
+
+func TestPluginTransSack4(t *testing.T) {
+	var l []tcpSackBlock
+	l = sackMerge(l, tcpSackBlock{100, 200})
+	l = sackMerge(l, tcpSackBlock{300, 400})
+	l = sackMerge(l, tcpSackBlock{50, 60})
+	l = sackMerge(l, tcpSackBlock{150, 300})
+	fmt.Printf(" %v \n", l)
+	if len(l) != 2 || l[0] != (tcpSackBlock{50, 60}) || l[1] != (tcpSackBlock{100, 400}) {
+		t.Fatalf(" invalid merge %v", l)
+	}
+}
+
+func tlsTestPem(b []byte) *string {
+	s := string(b)
+	return &s
+}
+
+// configuration of a client and a server, the client verifies the server with ca (1 - the right ca, 2 - other ca)
+func tlsTestCfg(ca int64, version string) (*TlsCfg, *TlsCfg) {
+	caKey := testpki.Key(1)
+	caCert := testpki.Cert(1, "emu ca", caKey, nil, nil, 0)
+	srvKey := testpki.Key(3)
+	srv := testpki.Cert(3, "www.emu.test", srvKey, caCert, caKey, x509.ExtKeyUsageServerAuth)
+
+	if ca != 1 {
+		caCert = testpki.Cert(ca, "other ca", testpki.Key(byte(ca)), nil, nil, 0)
+	}
+	sni := "www.emu.test"
+	c := &TlsCfg{ServerName: &sni, Alpn: []string{"h2", "http/1.1"}, CaCert: tlsTestPem(testpki.CertPem(caCert)), MaxVersion: &version}
+	s := &TlsCfg{Alpn: []string{"http/1.1"}, Cert: tlsTestPem(testpki.CertPem(srv)), Key: tlsTestPem(testpki.KeyPem(srvKey)), MaxVersion: &version}
+	return c, s
+}
+
+func tlsTestRR(t *testing.T, testname string, version string, ca int64, ciphers []string) {
+	c, s := tlsTestCfg(ca, version)
+	c.CipherSuites = ciphers
+	a := &TransportSimTestBase{
+		testname:     testname,
+		monitor:      false,
+		match:        0,
+		capture:      false,
+		duration:     30 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:   "r_r",
+			tlsc:   c,
+			tlss:   s,
+			redial: 1,
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		cs := &sim.client.ctx.tls.stats
+		ss := &sim.server.ctx.tls.stats
+		if ca != 1 {
+			if cs.tls_handshake_err != 2 || ss.tls_handshake_err != 2 || cs.tls_handshake_ok != 0 {
+				t.Fatalf(" handshake should fail %+v %+v", cs, ss)
+			}
+			if sim.client.lastErr != SeECONNABORTED {
+				t.Fatalf(" unexpected error %v", sim.client.lastErr)
+			}
+			return
+		}
+		if cs.tls_handshake_ok != 2 || ss.tls_handshake_ok != 2 || cs.tls_resumed != 1 || ss.tls_resumed != 1 {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+		info := sim.clientApp.(*SocketAppRR1).tlsInfo
+		if info[TLS_IOCTL_VERSION] != version || info[TLS_IOCTL_ALPN] != "http/1.1" || info[TLS_IOCTL_RESUMED] != 1 {
+			t.Fatalf(" unexpected client info %v", info)
+		}
+		info = sim.serverApp.(*SocketAppRR1).tlsInfo
+		if info[TLS_IOCTL_SNI] != "www.emu.test" {
+			t.Fatalf(" unexpected server info %v", info)
+		}
+		if len(ciphers) > 0 && info[TLS_IOCTL_CIPHER] != ciphers[0] {
+			t.Fatalf(" unexpected cipher %v", info)
+		}
+	}
+	a.Run(t, false)
+}
+
+// TLS 1.3 request/response, SNI, ALPN and resumption of the second session
+func TestPluginTransTls1(t *testing.T) {
+	tlsTestRR(t, "tls1", "1.3", 1, nil)
+}
+
+// TLS 1.2 with a cipher suite
+func TestPluginTransTls2(t *testing.T) {
+	tlsTestRR(t, "tls2", "1.2", 1, []string{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"})
+}
+
+// the server certificate is not signed by the ca of the client
+func TestPluginTransTls3(t *testing.T) {
+	tlsTestRR(t, "tls3", "1.3", 2, nil)
+}
+
+// bulk client -> server with drop, the TCP queue is full
+func TestPluginTransTls4(t *testing.T) {
+	c, s := tlsTestCfg(1, "1.3")
+	a := &TransportSimTestBase{
+		testname:     "tls4",
+		monitor:      false,
+		match:        0,
+		capture:      false,
+		duration:     100 * time.Second,
+		clientsToSim: 1,
+		param: transportSimParam{
+			name:                    "a",
+			sendRandom:              false,
+			totalClientToServerSize: 120000,
+			chunkSize:               10000,
+			closeByClient:           true,
+			drop:                    0.02,
+			tlsc:                    c,
+			tlss:                    s,
+		},
+	}
+	a.check = func(t *testing.T, sim *transportSim) {
+		cs := &sim.client.ctx.tls.stats
+		ss := &sim.server.ctx.tls.stats
+		if cs.tls_tx_bytes != 120000 || ss.tls_rx_bytes != 120000 || ss.tls_close_notify_rcv != 1 {
+			t.Fatalf(" unexpected client %+v server %+v", cs, ss)
+		}
+	}
+	a.Run(t, false)
+}
+
+func TestPluginTransTls5(t *testing.T) {
+	var ctx TransportCtx
+	ctx.tls.init()
+	bad := "1.1"
+	_, s := tlsTestCfg(1, "1.3")
+	if _, err := ctx.getTlsConfig(&TlsCfg{}, true); err == nil {
+		t.Fatalf(" server without certificate")
+	}
+	if _, err := ctx.getTlsConfig(&TlsCfg{CipherSuites: []string{"TLS_NO_SUCH"}}, false); err == nil {
+		t.Fatalf(" unknown cipher suite")
+	}
+	c1, err := ctx.getTlsConfig(s, true)
+	c2, _ := ctx.getTlsConfig(s, true)
+	if err != nil || c1 != c2 {
+		t.Fatalf(" the server configuration should be shared %v", err)
+	}
+	if tlsParseVersion(&bad, tls.VersionTLS12) != tls.VersionTLS12 {
+		t.Fatalf(" invalid version")
+	}
+}
+
 func MyDial(network, address string) error {
 	fmt.Printf(" %v %v \n", network, address)
 	host, port, err := net.SplitHostPort(address)
@@ -203,7 +203,7 @@ func (o *UdpSocket) resolve() bool {
 	}
 
 	if o.ipv6 == false {
-		mac, ok := o.client.ResolveIPv4DGMac()
+		mac, ok := o.resolveIPv4NextHop(UDP_PROTO)
 		if ok {
 			layers.EthernetHeader(o.pktTemplate).SetDestAddress(mac[:])
 			o.resolved = true
@@ -254,6 +254,11 @@ func NewTransportENs(ctx *core.PluginCtx, initJson []byte) *core.PluginBase {
 	return &o.PluginBase
 }
 
+// GetCounterDbVec implements core.IPluginCounters
+func (o *PluginTransportENs) GetCounterDbVec() *core.CCounterDbVec {
+	return o.cdbv
+}
+
 func (o *PluginTransportENs) OnRemove(ctx *core.PluginCtx) {
 }
 
@@ -668,3 +668,59 @@ func (o *DHCPv6OptionIANA) Decode(data []byte) error {
 	o.ValidLife = binary.BigEndian.Uint32(p[20:24])
 	return nil
 }
+
+// DHCPv6OptionIAPD is an IA_PD option (RFC 8415 21.21) with its first IA Prefix option
+type DHCPv6OptionIAPD struct {
+	IAID          uint32
+	T1            uint32
+	T2            uint32
+	OptionValid   bool // an IA Prefix option was found
+	Status        uint16
+	Prefix        net.IP
+	PrefixLen     uint8
+	PreferredLife uint32
+	ValidLife     uint32
+}
+
+func (o *DHCPv6OptionIAPD) Decode(data []byte) error {
+
+	if len(data) < 12 {
+		return errors.New("not enough data to decode")
+	}
+	o.OptionValid = false
+	o.Status = 0
+	o.IAID = binary.BigEndian.Uint32(data[0:4])
+	o.T1 = binary.BigEndian.Uint32(data[4:8])
+	o.T2 = binary.BigEndian.Uint32(data[8:12])
+
+	p := data[12:]
+	for len(p) >= 4 {
+		code := DHCPv6Opt(binary.BigEndian.Uint16(p[0:2]))
+		length := int(binary.BigEndian.Uint16(p[2:4]))
+		if len(p) < 4+length {
+			return errors.New("not enough data to decode")
+		}
+		v := p[4 : 4+length]
+		switch code {
+		case DHCPv6OptIAPrefix:
+			if length < 25 {
+				return errors.New("not enough data to decode")
+			}
+			if !o.OptionValid {
+				o.OptionValid = true
+				o.PreferredLife = binary.BigEndian.Uint32(v[0:4])
+				o.ValidLife = binary.BigEndian.Uint32(v[4:8])
+				o.PrefixLen = v[8]
+				if o.Prefix != nil {
+					copy(o.Prefix[:], v[9:25])
+				}
+			}
+		case DHCPv6OptStatusCode:
+			if length >= 2 {
+				o.Status = binary.BigEndian.Uint16(v[0:2])
+			}
+		}
+		p = p[4+length:]
+	}
+	return nil
+}
@@ -65,3 +65,18 @@ func compressBuff(msg []byte) []byte {
 	w.Flush()
 	return out.Bytes()
 }
+
+// IsCompress returns true in case the message is zlib compressed with the zmq magic header
+func IsCompress(msg []byte) bool {
+	return isCompress(msg)
+}
+
+// UncompressBuff uncompress a message with the zmq magic header
+func UncompressBuff(msg []byte) []byte {
+	return uncompressBuff(msg)
+}
+
+// CompressBuff compress a message and add the zmq magic header
+func CompressBuff(msg []byte) []byte {
+	return compressBuff(msg)
+}